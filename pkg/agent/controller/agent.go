@@ -18,20 +18,29 @@ package controller
 import (
 	"fmt"
 	"reflect"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
+	"github.com/submariner-io/admiral/pkg/federate"
 	"github.com/submariner-io/admiral/pkg/log"
 	"github.com/submariner-io/admiral/pkg/syncer"
 	"github.com/submariner-io/admiral/pkg/syncer/broker"
 	"github.com/submariner-io/admiral/pkg/util"
 	lhconstants "github.com/submariner-io/lighthouse/pkg/constants"
+	"github.com/submariner-io/lighthouse/pkg/ipam"
+	"github.com/submariner-io/lighthouse/pkg/serviceimport"
 	corev1 "k8s.io/api/core/v1"
 	discovery "k8s.io/api/discovery/v1beta1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/util/retry"
 	"k8s.io/klog"
@@ -39,20 +48,120 @@ import (
 )
 
 const (
-	submarinerIpamGlobalIp = "submariner.io/globalIp"
-	serviceUnavailable     = "ServiceUnavailable"
-	invalidServiceType     = "UnsupportedServiceType"
-	clusterIP              = "cluster-ip"
+	submarinerIpamGlobalIp  = "submariner.io/globalIp"
+	serviceUnavailable      = "ServiceUnavailable"
+	invalidServiceType      = "UnsupportedServiceType"
+	typeConflict            = "ConflictingType"
+	nameCollision           = "NameCollision"
+	collidingExport         = "CollidingExport"
+	clusterNotEligible      = "ClusterNotEligible"
+	clustersetIPExhausted   = "ClustersetIPPoolExhausted"
+	cleanupPending          = "CleanupPending"
+	namespaceTerminating    = "NamespaceTerminating"
+	invalidPortRemap        = "InvalidPortRemap"
+	invalidHTTPRoute        = "InvalidHTTPRoute"
+	invalidEndpointSelector = "InvalidEndpointSelector"
+	nodePortsNotExported    = "NodePortsNotExported"
+	serviceExportFinalizer  = "lighthouse.submariner.io/service-export-cleanup"
 )
 
+// serviceExportHostNetwork is set on the ServiceExport of a headless service to report whether endpoints of
+// host-networked pods are excluded.
+const serviceExportHostNetwork mcsv1a1.ServiceExportConditionType = "HostNetworkEndpoints"
+
+// serviceExportNoEndpoints is set on the ServiceExport of a service without a selector, whose endpoints are maintained
+// manually, to report whether it has any endpoints.
+const serviceExportNoEndpoints mcsv1a1.ServiceExportConditionType = "NoEndpoints"
+
+// serviceExportNodePort is set on the ServiceExport of a NodePort service to report that its node ports aren't exported.
+const serviceExportNodePort mcsv1a1.ServiceExportConditionType = "NodePort"
+
 var MaxExportStatusConditions = 10
 
+// maxCleanupRequeues is the number of times the cleanup of a deleted ServiceExport is retried while the broker is
+// unreachable before its finalizer is removed regardless.
+var maxCleanupRequeues = 12
+
 func New(spec *AgentSpecification, syncerConf broker.SyncerConfig, kubeClientSet kubernetes.Interface) (*Controller, error) {
 	agentController := &Controller{
-		clusterID:        spec.ClusterID,
-		namespace:        spec.Namespace,
-		globalnetEnabled: spec.GlobalnetEnabled,
-		kubeClientSet:    kubeClientSet,
+		clusterID:           spec.ClusterID,
+		namespace:           spec.Namespace,
+		globalnetEnabled:    spec.GlobalnetEnabled,
+		annotationAllowlist: spec.AnnotationAllowlist,
+		namespaceMembership: map[string][]string{},
+		kubeClientSet:       kubeClientSet,
+		leaseDuration:       spec.LeaderElectionLeaseDuration,
+		renewDeadline:       spec.LeaderElectionRenewDeadline,
+		retryPeriod:         spec.LeaderElectionRetryPeriod,
+		leadership:          newLeadership(),
+
+		orphanedEndpointSliceMaxAge: spec.OrphanedEndpointSliceMaxAge,
+		noExport:                    spec.NoExport,
+		reexportTimers:              map[string]*time.Timer{},
+		pendingClaims:               map[string]*mcsv1a1.ServiceImport{},
+	}
+
+	for namespace, clusters := range spec.NamespaceMembership {
+		agentController.namespaceMembership[namespace] = strings.Split(clusters, ";")
+	}
+
+	if spec.ClustersetIPCIDR != "" {
+		var err error
+
+		agentController.clustersetIPs, err = ipam.NewPool(spec.ClustersetIPCIDR)
+		if err != nil {
+			return nil, errors.Wrap(err, "error creating the clusterset IP pool")
+		}
+
+		if agentController.clustersetIPs.IsIPv6() {
+			return nil, fmt.Errorf("the clusterset IP CIDR %q is not IPv4", spec.ClustersetIPCIDR)
+		}
+	}
+
+	if spec.ClustersetIPv6CIDR != "" {
+		var err error
+
+		agentController.clustersetIPv6s, err = ipam.NewPool(spec.ClustersetIPv6CIDR)
+		if err != nil {
+			return nil, errors.Wrap(err, "error creating the clusterset IPv6 pool")
+		}
+
+		if !agentController.clustersetIPv6s.IsIPv6() {
+			return nil, fmt.Errorf("the clusterset IPv6 CIDR %q is not IPv6", spec.ClustersetIPv6CIDR)
+		}
+	}
+
+	if spec.NoExport && spec.AutoExport {
+		return nil, errors.New("automatic export can't be enabled in no-export mode")
+	}
+
+	if spec.ServiceExportSelector != "" {
+		if _, err := labels.Parse(spec.ServiceExportSelector); err != nil {
+			return nil, errors.Wrapf(err, "error parsing the ServiceExport label selector %q", spec.ServiceExportSelector)
+		}
+
+		klog.Infof("Only ServiceExports matching the label selector %q will be exported - all others are ignored",
+			spec.ServiceExportSelector)
+	}
+
+	retryBaseDelay, retryMaxDelay, err := importRetryDelays(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	agentController.clustersetDomain, agentController.maxClustersetNameLength, err = clustersetNameLimits(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	// A broker client created by the syncer from the environment isn't wrapped, but client-go still honors the
+	// Retry-After of its individual requests. The failed updates of the imported resources are counted as conflicts
+	// are expected while the agents overlap during a leader election handoff. The local writes wait for the agent to
+	// lead, as do the syncers writing to the broker.
+	syncerConf.LocalClient = newLeaderGatedClient(newWriteErrorCountingClient(newThrottledClient(syncerConf.LocalClient,
+		"local"), "serviceimports", "endpointslices"), agentController.leadership)
+	if syncerConf.BrokerClient != nil {
+		syncerConf.BrokerClient = newThrottledClient(syncerConf.BrokerClient, "broker")
 	}
 
 	_, gvr, err := util.ToUnstructuredResource(&mcsv1a1.ServiceExport{}, syncerConf.RestMapper)
@@ -62,6 +171,13 @@ func New(spec *AgentSpecification, syncerConf broker.SyncerConfig, kubeClientSet
 
 	agentController.serviceExportClient = syncerConf.LocalClient.Resource(*gvr)
 
+	_, gvr, err = util.ToUnstructuredResource(&discovery.EndpointSlice{}, syncerConf.RestMapper)
+	if err != nil {
+		return nil, err
+	}
+
+	agentController.endpointSliceClient = syncerConf.LocalClient.Resource(*gvr)
+
 	syncerConf.LocalNamespace = spec.Namespace
 	syncerConf.LocalClusterID = spec.ClusterID
 
@@ -69,7 +185,9 @@ func New(spec *AgentSpecification, syncerConf broker.SyncerConfig, kubeClientSet
 		{
 			LocalSourceNamespace: metav1.NamespaceAll,
 			LocalResourceType:    &mcsv1a1.ServiceImport{},
+			LocalTransform:       agentController.leadership.gate(nil),
 			BrokerResourceType:   &mcsv1a1.ServiceImport{},
+			BrokerTransform:      newRetryBackoff(retryBaseDelay, retryMaxDelay).wrap(agentController.remoteServiceImportToLocal),
 		},
 	}
 
@@ -83,7 +201,7 @@ func New(spec *AgentSpecification, syncerConf broker.SyncerConfig, kubeClientSet
 		{
 			LocalSourceNamespace: metav1.NamespaceAll,
 			LocalResourceType:    &discovery.EndpointSlice{},
-			LocalTransform:       agentController.filterLocalEndpointSlices,
+			LocalTransform:       agentController.leadership.gate(agentController.filterLocalEndpointSlices),
 			LocalResourcesEquivalent: func(obj1, obj2 *unstructured.Unstructured) bool {
 				return false
 			},
@@ -91,7 +209,7 @@ func New(spec *AgentSpecification, syncerConf broker.SyncerConfig, kubeClientSet
 			BrokerResourcesEquivalent: func(obj1, obj2 *unstructured.Unstructured) bool {
 				return false
 			},
-			BrokerTransform: agentController.remoteEndpointSliceToLocal,
+			BrokerTransform: newRetryBackoff(retryBaseDelay, retryMaxDelay).wrap(agentController.remoteEndpointSliceToLocal),
 		},
 	}
 
@@ -100,50 +218,122 @@ func New(spec *AgentSpecification, syncerConf broker.SyncerConfig, kubeClientSet
 		return nil, err
 	}
 
-	agentController.serviceExportSyncer, err = syncer.NewResourceSyncer(&syncer.ResourceSyncerConfig{
-		Name:             "ServiceExport -> ServiceImport",
-		SourceClient:     syncerConf.LocalClient,
-		SourceNamespace:  metav1.NamespaceAll,
-		Direction:        syncer.RemoteToLocal,
-		RestMapper:       syncerConf.RestMapper,
-		Federator:        agentController.serviceImportSyncer.GetLocalFederator(),
-		ResourceType:     &mcsv1a1.ServiceExport{},
-		Transform:        agentController.serviceExportToServiceImport,
-		OnSuccessfulSync: agentController.onSuccessfulServiceImportSync,
-		Scheme:           syncerConf.Scheme,
+	if spec.NoExport {
+		klog.Info("No-export mode is enabled - ServiceExports are ignored and services are only imported")
+		return agentController, nil
+	}
+
+	if err := agentController.newExportControllers(spec, syncerConf, kubeClientSet, retryBaseDelay,
+		retryMaxDelay); err != nil {
+		return nil, err
+	}
+
+	return agentController, nil
+}
+
+// newExportControllers creates the syncers and controllers exporting the local services, which aren't needed in
+// no-export mode.
+func (a *Controller) newExportControllers(spec *AgentSpecification, syncerConf broker.SyncerConfig,
+	kubeClientSet kubernetes.Interface, retryBaseDelay, retryMaxDelay time.Duration) error {
+	var err error
+
+	a.serviceExportSyncer, err = syncer.NewResourceSyncer(&syncer.ResourceSyncerConfig{
+		Name:                "ServiceExport -> ServiceImport",
+		SourceClient:        syncerConf.LocalClient,
+		SourceNamespace:     metav1.NamespaceAll,
+		SourceLabelSelector: spec.ServiceExportSelector,
+		Direction:           syncer.RemoteToLocal,
+		RestMapper:          syncerConf.RestMapper,
+		Federator:           a.serviceImportSyncer.GetLocalFederator(),
+		ResourceType:        &mcsv1a1.ServiceExport{},
+		Transform:           a.serviceExportToServiceImport,
+		OnSuccessfulSync:    a.onSuccessfulServiceImportSync,
+		Scheme:              syncerConf.Scheme,
 	})
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	agentController.serviceSyncer, err = syncer.NewResourceSyncer(&syncer.ResourceSyncerConfig{
+	a.serviceSyncer, err = syncer.NewResourceSyncer(&syncer.ResourceSyncerConfig{
 		Name:            "Service deletion",
 		SourceClient:    syncerConf.LocalClient,
 		SourceNamespace: metav1.NamespaceAll,
 		Direction:       syncer.RemoteToLocal,
 		RestMapper:      syncerConf.RestMapper,
-		Federator:       agentController.serviceImportSyncer.GetLocalFederator(),
+		Federator:       a.serviceImportSyncer.GetLocalFederator(),
 		ResourceType:    &corev1.Service{},
-		Transform:       agentController.serviceToRemoteServiceImport,
+		Transform:       a.serviceToRemoteServiceImport,
 		Scheme:          syncerConf.Scheme,
 	})
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	agentController.serviceImportController, err = newServiceImportController(spec, agentController.serviceSyncer,
-		syncerConf.RestMapper, syncerConf.LocalClient, syncerConf.Scheme)
+	// The exported Services are read unstructured for the fields the vendored type drops, and their changes re-export them.
+	a.unstructuredServiceSyncer, err = syncer.NewResourceSyncer(&syncer.ResourceSyncerConfig{
+		Name:                "Service -> ServiceImport",
+		SourceClient:        syncerConf.LocalClient,
+		SourceNamespace:     metav1.NamespaceAll,
+		Direction:           syncer.RemoteToLocal,
+		RestMapper:          syncerConf.RestMapper,
+		Federator:           federate.NewNoopFederator(),
+		ResourceType:        serviceType,
+		Transform:           a.serviceToServiceExports,
+		ResourcesEquivalent: syncer.DefaultResourcesEquivalent,
+		Scheme:              syncerConf.Scheme,
+	})
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	agentController.lhServiceExportController, err = newLHServiceExportController(syncerConf.LocalClient, syncerConf.RestMapper,
+	if spec.AutoExport {
+		klog.Infof("Services labeled %q will be exported automatically", lhconstants.LabelExport+"=true")
+
+		a.autoExportSyncer, err = syncer.NewResourceSyncer(&syncer.ResourceSyncerConfig{
+			Name:            "Service -> ServiceExport",
+			SourceClient:    syncerConf.LocalClient,
+			SourceNamespace: metav1.NamespaceAll,
+			Direction:       syncer.RemoteToLocal,
+			RestMapper:      syncerConf.RestMapper,
+			Federator:       a.serviceImportSyncer.GetLocalFederator(),
+			ResourceType:    &corev1.Service{},
+			Transform:       a.serviceToAutoExport,
+			Scheme:          syncerConf.Scheme,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if spec.GatewayAPIRoutes {
+		klog.Infof("ServiceExports annotated with %q will export the backend Service of the HTTPRoute",
+			lhconstants.AnnotationHTTPRoute)
+
+		if err := a.newHTTPRouteSyncer(syncerConf); err != nil {
+			return err
+		}
+	}
+
+	a.serviceImportController, err = newServiceImportController(spec, a.serviceSyncer,
+		syncerConf.RestMapper, syncerConf.LocalClient, kubeClientSet, syncerConf.Scheme,
+		a.updateExportedServiceStatus, newRetryBackoff(retryBaseDelay, retryMaxDelay))
+	if err != nil {
+		return err
+	}
+
+	a.lhServiceExportController, err = newLHServiceExportController(syncerConf.LocalClient, syncerConf.RestMapper,
 		syncerConf.Scheme)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return agentController, nil
+	if spec.ClusterSetReadiness {
+		if err := a.newReadinessSyncer(syncerConf); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 func (a *Controller) Start(stopCh <-chan struct{}) error {
@@ -152,30 +342,72 @@ func (a *Controller) Start(stopCh <-chan struct{}) error {
 	// Start the informer factories to begin populating the informer caches
 	klog.Info("Starting Agent controller")
 
-	if err := a.serviceExportSyncer.Start(stopCh); err != nil {
-		return err
+	// The HTTPRoutes are cached first as the ServiceExports referencing them are resolved from the cache.
+	if a.httpRouteSyncer != nil {
+		if err := a.httpRouteSyncer.Start(stopCh); err != nil {
+			return err
+		}
 	}
 
-	if err := a.serviceSyncer.Start(stopCh); err != nil {
+	// The ServiceImports are cached before the ServiceExports are processed as the exports are checked against the
+	// imports from all the clusters, eg for the clusterset IPs they claim.
+	if err := a.serviceImportSyncer.Start(stopCh); err != nil {
 		return err
 	}
 
-	if err := a.endpointSliceSyncer.Start(stopCh); err != nil {
-		return err
+	if !a.noExport {
+		// The namespaces are cached first as the exports in terminating namespaces are withdrawn.
+		if err := a.startNamespaceInformer(stopCh); err != nil {
+			return err
+		}
+
+		// The unstructured Services are cached first as the exports read their fields from the cache.
+		if err := a.unstructuredServiceSyncer.Start(stopCh); err != nil {
+			return err
+		}
+
+		if err := a.serviceExportSyncer.Start(stopCh); err != nil {
+			return err
+		}
+
+		if err := a.serviceSyncer.Start(stopCh); err != nil {
+			return err
+		}
 	}
 
-	if err := a.serviceImportSyncer.Start(stopCh); err != nil {
-		return err
+	if a.autoExportSyncer != nil {
+		if err := a.autoExportSyncer.Start(stopCh); err != nil {
+			return err
+		}
 	}
 
-	if err := a.serviceImportController.start(stopCh); err != nil {
+	if err := a.endpointSliceSyncer.Start(stopCh); err != nil {
 		return err
 	}
 
-	if err := a.lhServiceExportController.start(stopCh); err != nil {
-		return err
+	if !a.noExport {
+		if err := a.serviceImportController.start(stopCh); err != nil {
+			return err
+		}
+
+		if err := a.lhServiceExportController.start(stopCh); err != nil {
+			return err
+		}
 	}
 
+	if a.readinessSyncer != nil {
+		if err := a.startReadiness(stopCh); err != nil {
+			return err
+		}
+	}
+
+	a.startOrphanedEndpointSliceCollection(stopCh)
+
+	go func() {
+		<-stopCh
+		a.stopReexports()
+	}()
+
 	klog.Info("Agent controller started")
 
 	return nil
@@ -185,10 +417,51 @@ func (a *Controller) serviceExportToServiceImport(obj runtime.Object, numRequeue
 	svcExport := obj.(*mcsv1a1.ServiceExport)
 
 	if op == syncer.Delete {
-		return a.newServiceImport(svcExport), false
+		serviceImport := a.newServiceImport(svcExport)
+		if colliding := a.getNameCollision(serviceImport); colliding != nil {
+			// The ServiceImport with this name belongs to another export so it mustn't be deleted.
+			a.clearCollidingExport(colliding)
+			return nil, false
+		}
+
+		return serviceImport, false
+	}
+
+	if svcExport.DeletionTimestamp != nil {
+		return a.cleanupServiceExport(svcExport, numRequeues)
+	}
+
+	if a.isNamespaceTerminating(svcExport.Namespace) {
+		return a.withdrawServiceExport(svcExport, numRequeues)
 	}
 
-	obj, found, err := a.serviceSyncer.GetResource(svcExport.Name, svcExport.Namespace)
+	if !a.isClusterEligible(svcExport.Namespace, a.clusterID) {
+		klog.V(log.DEBUG).Infof("Cluster %q is not eligible to export services from namespace %q", a.clusterID,
+			svcExport.Namespace)
+		a.updateExportedServiceStatus(svcExport.Name, svcExport.Namespace, mcsv1a1.ServiceExportValid,
+			corev1.ConditionFalse, clusterNotEligible, fmt.Sprintf("Cluster %q is not a member of the clusterset "+
+				"for namespace %q", a.clusterID, svcExport.Namespace))
+
+		return nil, false
+	}
+
+	// The finalizer is added before anything is exported so a deletion can't leave the ServiceImport behind.
+	if err := a.setServiceExportFinalizer(svcExport.Name, svcExport.Namespace, true); err != nil {
+		klog.Errorf("Error adding the finalizer to ServiceExport (%s/%s): %v", svcExport.Namespace, svcExport.Name, err)
+		return nil, true
+	}
+
+	serviceName, err := a.getExportedServiceName(svcExport)
+	if err != nil {
+		klog.Errorf("Error resolving the Service for ServiceExport (%s/%s): %v", svcExport.Namespace, svcExport.Name, err)
+		a.updateExportedServiceStatus(svcExport.Name, svcExport.Namespace, mcsv1a1.ServiceExportValid,
+			corev1.ConditionFalse, invalidHTTPRoute, err.Error())
+
+		// The export is retried when the HTTPRoute changes.
+		return nil, false
+	}
+
+	svc, unstructuredSvc, found, err := a.getService(svcExport.Namespace, serviceName)
 	if err != nil {
 		// some other error. Log and requeue
 		a.updateExportedServiceStatus(svcExport.Name, svcExport.Namespace, mcsv1a1.ServiceExportValid,
@@ -206,12 +479,14 @@ func (a *Controller) serviceExportToServiceImport(obj runtime.Object, numRequeue
 		return nil, true
 	}
 
-	if op == syncer.Update && getLastExportConditionReason(svcExport) != serviceUnavailable {
+	// Retry updates until the ServiceImport is created, ie while the Service is missing or no clusterset IP is free,
+	// and re-export those changing the annotations the ServiceImport gets from the ServiceExport.
+	if reason := getLastExportConditionReason(svcExport); op == syncer.Update && reason != serviceUnavailable &&
+		reason != clustersetIPExhausted && reason != nameCollision && reason != invalidPortRemap &&
+		reason != invalidHTTPRoute && reason != invalidEndpointSelector && !a.exportAnnotationsChanged(svcExport) {
 		return nil, false
 	}
 
-	svc := obj.(*corev1.Service)
-
 	svcType, ok := getServiceImportType(svc)
 
 	if !ok {
@@ -222,29 +497,92 @@ func (a *Controller) serviceExportToServiceImport(obj runtime.Object, numRequeue
 		return nil, false
 	}
 
-	if a.globalnetEnabled && svcType == mcsv1a1.Headless {
-		klog.Infof("Headless Services not supported with globalnet yet")
+	if svc.Spec.Type == corev1.ServiceTypeNodePort {
+		a.updateExportedServiceStatus(svcExport.Name, svcExport.Namespace, serviceExportNodePort, corev1.ConditionTrue,
+			nodePortsNotExported, "The Service is exported by its cluster IP - its node ports aren't reachable "+
+				"via the clusterset")
+	} else {
+		a.clearExportCondition(svcExport, serviceExportNodePort, nodePortsNotExported)
+	}
+
+	// The global IPs of a headless service's pods are exported by its EndpointController.
+	if a.globalnetEnabled && svcType != mcsv1a1.Headless && getGlobalIpFromService(svc) == "" {
+		klog.V(log.DEBUG).Infof("Service to be exported (%s/%s) doesn't have a global IP yet", svcExport.Namespace, svcExport.Name)
+
+		// The export is retried once the Service is updated with its global IP.
 		a.updateExportedServiceStatus(svcExport.Name, svcExport.Namespace, mcsv1a1.ServiceExportValid,
-			corev1.ConditionFalse, invalidServiceType, "Headless Services not supported with Globalnet IP")
+			corev1.ConditionFalse, "ServiceGlobalIPUnavailable", "Service doesn't have a global IP yet")
 
 		return nil, false
 	}
 
-	if a.globalnetEnabled && getGlobalIpFromService(svc) == "" {
-		klog.V(log.DEBUG).Infof("Service to be exported (%s/%s) doesn't have a global IP yet", svcExport.Namespace, svcExport.Name)
+	ports, err := exportedPorts(svcExport, svc)
+	if err != nil {
+		klog.Errorf("Invalid port remapping for ServiceExport (%s/%s): %v", svcExport.Namespace, svcExport.Name, err)
+		a.updateExportedServiceStatus(svcExport.Name, svcExport.Namespace, mcsv1a1.ServiceExportValid,
+			corev1.ConditionFalse, invalidPortRemap, fmt.Sprintf("Invalid port remapping: %v", err))
 
-		// Globalnet enabled but service doesn't have globalIp yet, Update the status and requeue
+		return nil, false
+	}
+
+	if _, err := exportedEndpointSelector(svcExport.GetAnnotations()); err != nil {
+		klog.Errorf("Invalid endpoint selector for ServiceExport (%s/%s): %v", svcExport.Namespace, svcExport.Name, err)
 		a.updateExportedServiceStatus(svcExport.Name, svcExport.Namespace, mcsv1a1.ServiceExportValid,
-			corev1.ConditionFalse, "ServiceGlobalIPUnavailable", "Service doesn't have a global IP yet")
+			corev1.ConditionFalse, invalidEndpointSelector, fmt.Sprintf("Invalid endpoint selector: %v", err))
 
-		return nil, true
+		return nil, false
+	}
+
+	nameErr, clusterNameErr := a.checkClustersetNames(svcExport.Name, svcExport.Namespace)
+	if nameErr != nil {
+		klog.Errorf("Unresolvable clusterset name for ServiceExport (%s/%s): %v", svcExport.Namespace, svcExport.Name,
+			nameErr)
+		a.updateExportedServiceStatus(svcExport.Name, svcExport.Namespace, mcsv1a1.ServiceExportValid,
+			corev1.ConditionFalse, clustersetNameTooLong, fmt.Sprintf("The clusterset name can't be resolved: %v", nameErr))
+
+		return nil, false
 	}
 
+	if clusterNameErr != nil {
+		klog.Warningf("Unresolvable cluster-specific name for ServiceExport (%s/%s): %v", svcExport.Namespace,
+			svcExport.Name, clusterNameErr)
+		a.updateExportedServiceStatus(svcExport.Name, svcExport.Namespace, serviceExportNameLength, corev1.ConditionTrue,
+			clusterNameTooLong, fmt.Sprintf("The Service can't be resolved by cluster: %v", clusterNameErr))
+	} else {
+		a.clearExportCondition(svcExport, serviceExportNameLength, clusterNameTooLong)
+	}
+
+	setAppProtocols(ports, appProtocolsFromService(unstructuredSvc))
+
 	serviceImport := a.newServiceImport(svcExport)
 
+	if colliding := a.getNameCollision(serviceImport); colliding != nil {
+		a.reportNameCollision(serviceImport, colliding, nameCollision)
+
+		if colliding.Labels[lhconstants.LabelSourceCluster] == a.clusterID {
+			a.reportNameCollision(colliding, serviceImport, collidingExport)
+		}
+
+		// Retry as the collision is resolved once the other export is removed.
+		return nil, true
+	}
+
+	a.clearExportCondition(svcExport, mcsv1a1.ServiceExportConflict, nameCollision)
+
+	if serviceName != svcExport.Name {
+		serviceImport.Annotations[lhconstants.AnnotationBackend] = serviceName
+	}
+
+	a.copyAllowedAnnotations(svc, serviceImport)
+	copyMinClusters(svc, serviceImport)
+	copySingleton(svc, serviceImport)
+	copyDNSPriority(svc, serviceImport)
+	a.copyInternalTrafficPolicy(svcExport, unstructuredSvc, serviceImport)
+
 	serviceImport.Spec = mcsv1a1.ServiceImportSpec{
-		Ports:                 []mcsv1a1.ServicePort{},
+		Ports:                 ports,
 		Type:                  svcType,
+		SessionAffinity:       svc.Spec.SessionAffinity,
 		SessionAffinityConfig: new(corev1.SessionAffinityConfig),
 	}
 
@@ -268,17 +606,310 @@ func (a *Controller) serviceExportToServiceImport(obj runtime.Object, numRequeue
 	if svcType == mcsv1a1.ClusterSetIP {
 		serviceImport.Spec.IPs = ips
 		/* We also store the clusterIP in an annotation as an optimization to recover it in case the IPs are
-		cleared out when here's no backing Endpoint pods.
+		cleared out when here's no backing Endpoint pods, and as the IPs are replaced by the clusterset VIPs if any.
 		*/
-		serviceImport.Annotations[clusterIP] = ips[0]
+		serviceImport.Annotations[lhconstants.AnnotationClusterIP] = ips[0]
+
+		if !a.allocateClustersetIPs(svcExport, svc, unstructuredSvc, serviceImport) {
+			return nil, true
+		}
 	}
 
+	a.checkTypeConflict(svcExport, svcType)
+	a.holdDelayedExport(svcExport)
+
 	a.updateExportedServiceStatus(svcExport.Name, svcExport.Namespace, mcsv1a1.ServiceExportValid,
 		corev1.ConditionFalse, "AwaitingSync", "Awaiting sync of the ServiceImport to the broker")
 
 	return serviceImport, false
 }
 
+// checkTypeConflict sets the Conflict condition on the ServiceExport if an older export of the same service from
+// another cluster has a different type. Per the MCS oldest-wins policy, the older export determines the type of the
+// clusterset service and this cluster's export isn't merged into it.
+func (a *Controller) checkTypeConflict(svcExport *mcsv1a1.ServiceExport, svcType mcsv1a1.ServiceImportType) {
+	list, err := a.serviceImportSyncer.ListLocalResources(&mcsv1a1.ServiceImport{})
+	if err != nil {
+		klog.Errorf("Error listing ServiceImports to check for conflicts with (%s/%s): %v", svcExport.Namespace,
+			svcExport.Name, err)
+		return
+	}
+
+	for _, obj := range list {
+		si := obj.(*mcsv1a1.ServiceImport)
+		if si.Annotations[lhconstants.OriginName] != svcExport.Name ||
+			si.Annotations[lhconstants.OriginNamespace] != svcExport.Namespace ||
+			si.Labels[lhconstants.LabelSourceCluster] == a.clusterID || si.Spec.Type == svcType {
+			continue
+		}
+
+		otherCluster := si.Labels[lhconstants.LabelSourceCluster]
+
+		exportTime, err := time.Parse(time.RFC3339, si.Annotations[lhconstants.AnnotationExportTime])
+		if err != nil || exportTime.After(svcExport.CreationTimestamp.Time) ||
+			(exportTime.Equal(svcExport.CreationTimestamp.Time) && otherCluster > a.clusterID) {
+			continue
+		}
+
+		a.updateExportedServiceStatus(svcExport.Name, svcExport.Namespace, mcsv1a1.ServiceExportConflict,
+			corev1.ConditionTrue, typeConflict, fmt.Sprintf("The service type %q conflicts with type %q exported by "+
+				"cluster %q", svcType, si.Spec.Type, otherCluster))
+
+		return
+	}
+
+	a.clearExportCondition(svcExport, mcsv1a1.ServiceExportConflict, typeConflict)
+}
+
+// getNameCollision returns the existing local ServiceImport with the same name as the given one if it was exported for
+// a different service or from a different cluster. This happens as the names are formed by joining the service name,
+// namespace and cluster ID with dashes, which they may all contain, eg "a-b" in "c" and "a" in "b-c" exported by the
+// same cluster. Such ServiceImports would overwrite each other rather than being merged.
+func (a *Controller) getNameCollision(serviceImport *mcsv1a1.ServiceImport) *mcsv1a1.ServiceImport {
+	obj, found, err := a.serviceImportSyncer.GetLocalResource(serviceImport.Name, a.namespace, &mcsv1a1.ServiceImport{})
+	if err != nil || !found {
+		return nil
+	}
+
+	existing := obj.(*mcsv1a1.ServiceImport)
+	if existing.Annotations[lhconstants.OriginName] == serviceImport.Annotations[lhconstants.OriginName] &&
+		existing.Annotations[lhconstants.OriginNamespace] == serviceImport.Annotations[lhconstants.OriginNamespace] &&
+		existing.Labels[lhconstants.LabelSourceCluster] == serviceImport.Labels[lhconstants.LabelSourceCluster] {
+		return nil
+	}
+
+	return existing
+}
+
+// reexportedAnnotations are the annotations copied from a ServiceExport to its ServiceImport whose changes are
+// re-exported.
+var reexportedAnnotations = []string{lhconstants.AnnotationPortRemap, lhconstants.AnnotationFrozen,
+	lhconstants.AnnotationEndpointSelector}
+
+// exportAnnotationsChanged returns whether one of the reexportedAnnotations of the ServiceExport differs from that of
+// its exported ServiceImport.
+func (a *Controller) exportAnnotationsChanged(svcExport *mcsv1a1.ServiceExport) bool {
+	obj, found, err := a.serviceImportSyncer.GetLocalResource(a.getObjectNameWithClusterId(svcExport.Name,
+		svcExport.Namespace), a.namespace, &mcsv1a1.ServiceImport{})
+	if err != nil || !found {
+		return false
+	}
+
+	existing := obj.(*mcsv1a1.ServiceImport)
+
+	for _, annotation := range reexportedAnnotations {
+		if existing.Annotations[annotation] != svcExport.GetAnnotations()[annotation] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reportNameCollision sets the Conflict condition on the local ServiceExport of serviceImport to report that its name
+// collides with that of other, with reason nameCollision if it isn't exported because of it, otherwise collidingExport.
+func (a *Controller) reportNameCollision(serviceImport, other *mcsv1a1.ServiceImport, reason string) {
+	name := serviceImport.Annotations[lhconstants.OriginName]
+	namespace := serviceImport.Annotations[lhconstants.OriginNamespace]
+
+	klog.Errorf("The ServiceImport name %q of ServiceExport (%s/%s) collides with that of service (%s/%s) exported "+
+		"by cluster %q", serviceImport.Name, namespace, name, other.Annotations[lhconstants.OriginNamespace],
+		other.Annotations[lhconstants.OriginName], other.Labels[lhconstants.LabelSourceCluster])
+
+	a.updateExportedServiceStatus(name, namespace, mcsv1a1.ServiceExportConflict, corev1.ConditionTrue, reason,
+		fmt.Sprintf("The ServiceImport name %q collides with that of service \"%s/%s\" exported by cluster %q",
+			serviceImport.Name, other.Annotations[lhconstants.OriginNamespace], other.Annotations[lhconstants.OriginName],
+			other.Labels[lhconstants.LabelSourceCluster]))
+}
+
+// clearCollidingExport clears the Conflict condition reported on the local ServiceExport of serviceImport when another
+// export's ServiceImport collided with it, once that export is withdrawn.
+func (a *Controller) clearCollidingExport(serviceImport *mcsv1a1.ServiceImport) {
+	if a.serviceExportSyncer == nil || serviceImport.Labels[lhconstants.LabelSourceCluster] != a.clusterID {
+		return
+	}
+
+	obj, found, err := a.serviceExportSyncer.GetResource(serviceImport.Annotations[lhconstants.OriginName],
+		serviceImport.Annotations[lhconstants.OriginNamespace])
+	if err != nil || !found {
+		return
+	}
+
+	a.clearExportCondition(obj.(*mcsv1a1.ServiceExport), mcsv1a1.ServiceExportConflict, collidingExport)
+}
+
+// cleanupServiceExport deletes the ServiceImport and EndpointSlice of a ServiceExport being deleted, locally and from
+// the broker, before removing its finalizer so other clusters aren't left with an orphaned import. While the broker is
+// unreachable the cleanup is retried up to maxCleanupRequeues times, after which the finalizer is removed anyway and
+// the broker syncers are left to delete the broker copies once it's reachable again.
+func (a *Controller) cleanupServiceExport(svcExport *mcsv1a1.ServiceExport, numRequeues int) (runtime.Object, bool) {
+	if !hasFinalizer(svcExport) {
+		return nil, false
+	}
+
+	serviceImport := a.newServiceImport(svcExport)
+	ownsServiceImport := a.getNameCollision(serviceImport) == nil
+	endpointSlice := &discovery.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      svcExport.Name + "-" + a.clusterID,
+			Namespace: svcExport.Namespace,
+		},
+	}
+
+	var err error
+	if ownsServiceImport {
+		err = deleteIfExists(a.serviceImportSyncer.GetLocalFederator(), serviceImport)
+	}
+
+	if err == nil {
+		err = deleteIfExists(a.endpointSliceSyncer.GetLocalFederator(), endpointSlice)
+	}
+
+	if err != nil {
+		klog.Errorf("Error deleting the local resources of ServiceExport (%s/%s): %v", svcExport.Namespace,
+			svcExport.Name, err)
+		return nil, true
+	}
+
+	if ownsServiceImport {
+		err = deleteIfExists(a.serviceImportSyncer.GetBrokerFederator(), serviceImport)
+	}
+
+	if err == nil {
+		err = deleteIfExists(a.endpointSliceSyncer.GetBrokerFederator(), endpointSlice)
+	}
+
+	if err != nil {
+		// Retrying in a terminating namespace would only hold up its deletion.
+		if numRequeues < maxCleanupRequeues && !a.isNamespaceTerminating(svcExport.Namespace) {
+			klog.Errorf("Error deleting the broker resources of ServiceExport (%s/%s) - retrying: %v",
+				svcExport.Namespace, svcExport.Name, err)
+			a.updateExportedServiceStatus(svcExport.Name, svcExport.Namespace, mcsv1a1.ServiceExportValid,
+				corev1.ConditionFalse, cleanupPending, fmt.Sprintf("Error removing the service from the broker: %v", err))
+
+			return nil, true
+		}
+
+		klog.Warningf("Giving up deleting the broker resources of ServiceExport (%s/%s) after %d attempts - they "+
+			"will be deleted once the broker is reachable: %v", svcExport.Namespace, svcExport.Name, numRequeues+1, err)
+	}
+
+	if err := a.setServiceExportFinalizer(svcExport.Name, svcExport.Namespace, false); err != nil {
+		klog.Errorf("Error removing the finalizer from ServiceExport (%s/%s): %v", svcExport.Namespace, svcExport.Name, err)
+		return nil, true
+	}
+
+	return nil, false
+}
+
+// withdrawServiceExport removes a ServiceExport in a terminating namespace from the clusterset up front, rather than
+// letting its ServiceImport flap as the Service and Endpoints are deleted one by one. The finalizer is removed along
+// with the imports and never added back so the namespace deletion isn't blocked.
+func (a *Controller) withdrawServiceExport(svcExport *mcsv1a1.ServiceExport, numRequeues int) (runtime.Object, bool) {
+	klog.V(log.DEBUG).Infof("Namespace %q is terminating - withdrawing ServiceExport (%s/%s)", svcExport.Namespace,
+		svcExport.Namespace, svcExport.Name)
+
+	a.updateExportedServiceStatus(svcExport.Name, svcExport.Namespace, mcsv1a1.ServiceExportValid,
+		corev1.ConditionFalse, namespaceTerminating, "The namespace is being deleted - the service was withdrawn "+
+			"from the clusterset")
+
+	return a.cleanupServiceExport(svcExport, numRequeues)
+}
+
+// isNamespaceTerminating returns whether the namespace is being deleted. Errors retrieving it are logged and treated
+// as not terminating so a transient failure doesn't withdraw the exports.
+func (a *Controller) isNamespaceTerminating(name string) bool {
+	namespace, err := a.namespaceLister.Get(name)
+	if apierrors.IsNotFound(err) {
+		return false
+	} else if err != nil {
+		klog.Errorf("Error retrieving Namespace %q: %v", name, err)
+		return false
+	}
+
+	return isTerminating(namespace)
+}
+
+func isTerminating(namespace *corev1.Namespace) bool {
+	return namespace.DeletionTimestamp != nil || namespace.Status.Phase == corev1.NamespaceTerminating
+}
+
+// setServiceExportFinalizer adds or removes the cleanup finalizer on the ServiceExport.
+func (a *Controller) setServiceExportFinalizer(name, namespace string, present bool) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		toUpdate, err := a.getServiceExport(name, namespace)
+		if apierrors.IsNotFound(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if hasFinalizer(toUpdate) == present {
+			return nil
+		}
+
+		if present && toUpdate.DeletionTimestamp != nil {
+			return errors.New("the ServiceExport is being deleted")
+		}
+
+		finalizers := []string{}
+		for _, f := range toUpdate.Finalizers {
+			if f != serviceExportFinalizer {
+				finalizers = append(finalizers, f)
+			}
+		}
+
+		if present {
+			finalizers = append(finalizers, serviceExportFinalizer)
+		}
+
+		toUpdate.Finalizers = finalizers
+
+		raw, err := util.ToUnstructured(toUpdate)
+		if err != nil {
+			return err
+		}
+
+		_, err = a.serviceExportClient.Namespace(namespace).Update(raw, metav1.UpdateOptions{})
+
+		return err
+	})
+}
+
+func hasFinalizer(svcExport *mcsv1a1.ServiceExport) bool {
+	for _, f := range svcExport.Finalizers {
+		if f == serviceExportFinalizer {
+			return true
+		}
+	}
+
+	return false
+}
+
+func deleteIfExists(federator federate.Federator, obj runtime.Object) error {
+	if err := federator.Delete(obj); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	return nil
+}
+
+// clustersetIPKey returns the key of the service's clusterset IP allocation. Service names and namespaces can't contain
+// dots so the key is unique.
+func clustersetIPKey(svcExport *mcsv1a1.ServiceExport) string {
+	return svcExport.Namespace + "." + svcExport.Name
+}
+
+// clustersetUIDSpace is the namespace of the name-based UUIDs identifying exported services across the clusterset.
+var clustersetUIDSpace = uuid.MustParse("1c3cfb5e-8e0b-4d4a-9d39-5d8b1f0f6b47")
+
+// clustersetUID returns the UID identifying the exported service across the clusterset. It's derived from the
+// service's namespace and name so every cluster exporting the service, including after a restart or a re-export,
+// comes up with the same UID without having to persist or coordinate it.
+func clustersetUID(svcExport *mcsv1a1.ServiceExport) string {
+	return uuid.NewSHA1(clustersetUIDSpace, []byte(svcExport.Namespace+"/"+svcExport.Name)).String()
+}
+
 func getLastExportConditionReason(svcExport *mcsv1a1.ServiceExport) string {
 	numCond := len(svcExport.Status.Conditions)
 	if numCond > 0 && svcExport.Status.Conditions[numCond-1].Reason != nil {
@@ -288,8 +919,29 @@ func getLastExportConditionReason(svcExport *mcsv1a1.ServiceExport) string {
 	return ""
 }
 
+// clearExportCondition sets the condition of the given type of the ServiceExport to False if it was last set to True
+// with the given reason, once what it reported no longer applies.
+func (a *Controller) clearExportCondition(svcExport *mcsv1a1.ServiceExport, condType mcsv1a1.ServiceExportConditionType,
+	reason string) {
+	for i := len(svcExport.Status.Conditions) - 1; i >= 0; i-- {
+		condition := &svcExport.Status.Conditions[i]
+		if condition.Type != condType {
+			continue
+		}
+
+		if condition.Status == corev1.ConditionTrue && condition.Reason != nil && *condition.Reason == reason {
+			a.updateExportedServiceStatus(svcExport.Name, svcExport.Namespace, condType, corev1.ConditionFalse, "", "")
+		}
+
+		return
+	}
+}
+
+// getServiceImportType returns the type of the ServiceImport for the Service, if it can be exported. NodePort services
+// are exported like ClusterIP services as their node ports don't carry over to the clusterset.
 func getServiceImportType(service *corev1.Service) (mcsv1a1.ServiceImportType, bool) {
-	if service.Spec.Type != "" && service.Spec.Type != corev1.ServiceTypeClusterIP {
+	if service.Spec.Type != "" && service.Spec.Type != corev1.ServiceTypeClusterIP &&
+		service.Spec.Type != corev1.ServiceTypeNodePort {
 		return "", false
 	}
 
@@ -334,6 +986,10 @@ func (a *Controller) serviceToRemoteServiceImport(obj runtime.Object, numRequeue
 
 	svcExport := obj.(*mcsv1a1.ServiceExport)
 
+	if a.isNamespaceTerminating(svc.Namespace) {
+		return a.withdrawServiceExport(svcExport, numRequeues)
+	}
+
 	serviceImport := a.newServiceImport(svcExport)
 
 	// Update the status and requeue
@@ -343,6 +999,59 @@ func (a *Controller) serviceToRemoteServiceImport(obj runtime.Object, numRequeue
 	return serviceImport, false
 }
 
+// serviceToServiceExports re-exports the ServiceExports of an updated Service, ie those named like it or whose
+// HTTPRoute has it as backend, so their ServiceImports follow the changes of the Service, eg of its ports. An export
+// is retried until its Service is created, and the deletion of a Service is handled by the serviceSyncer.
+func (a *Controller) serviceToServiceExports(obj runtime.Object, numRequeues int, op syncer.Operation) (runtime.Object, bool) {
+	if op != syncer.Update {
+		return nil, false
+	}
+
+	svc := obj.(*unstructured.Unstructured)
+
+	exports, err := a.serviceExportSyncer.ListResources()
+	if err != nil {
+		klog.Errorf("Error listing the ServiceExports for Service (%s/%s): %v", svc.GetNamespace(), svc.GetName(), err)
+		return nil, true
+	}
+
+	requeue := false
+
+	for _, obj := range exports {
+		svcExport := obj.(*mcsv1a1.ServiceExport)
+		if svcExport.Namespace != svc.GetNamespace() {
+			continue
+		}
+
+		// An export whose HTTPRoute can't be resolved is retried when the HTTPRoute changes.
+		if serviceName, err := a.getExportedServiceName(svcExport); err != nil || serviceName != svc.GetName() {
+			continue
+		}
+
+		requeue = a.reexport(svcExport, numRequeues) || requeue
+	}
+
+	return nil, requeue
+}
+
+// reexport exports the ServiceExport anew, returning whether it should be retried. The export is processed as if it
+// was created as an update isn't re-exported once it's valid.
+func (a *Controller) reexport(svcExport *mcsv1a1.ServiceExport, numRequeues int) bool {
+	serviceImport, retry := a.serviceExportToServiceImport(svcExport, numRequeues, syncer.Create)
+	if serviceImport == nil {
+		return retry
+	}
+
+	if err := a.serviceImportSyncer.GetLocalFederator().Distribute(serviceImport); err != nil {
+		klog.Errorf("Error re-exporting the ServiceExport (%s/%s): %v", svcExport.Namespace, svcExport.Name, err)
+		return true
+	}
+
+	a.onSuccessfulServiceImportSync(serviceImport, syncer.Update)
+
+	return false
+}
+
 func (a *Controller) updateExportedServiceStatus(name, namespace string, condType mcsv1a1.ServiceExportConditionType,
 	status corev1.ConditionStatus, reason, msg string) {
 	klog.V(log.DEBUG).Infof("updateExportedServiceStatus for (%s/%s) - Type: %q, Status: %q, Reason: %q, Message: %q",
@@ -416,12 +1125,13 @@ func serviceExportConditionEqual(c1, c2 *mcsv1a1.ServiceExportCondition) bool {
 }
 
 func (a *Controller) newServiceImport(svcExport *mcsv1a1.ServiceExport) *mcsv1a1.ServiceImport {
-	return &mcsv1a1.ServiceImport{
+	serviceImport := &mcsv1a1.ServiceImport{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: a.getObjectNameWithClusterId(svcExport.Name, svcExport.Namespace),
 			Annotations: map[string]string{
-				lhconstants.OriginName:      svcExport.Name,
-				lhconstants.OriginNamespace: svcExport.Namespace,
+				lhconstants.OriginName:              svcExport.Name,
+				lhconstants.OriginNamespace:         svcExport.Namespace,
+				lhconstants.AnnotationClustersetUID: clustersetUID(svcExport),
 			},
 			Labels: map[string]string{
 				lhconstants.LabelSourceName:      svcExport.Name,
@@ -430,6 +1140,182 @@ func (a *Controller) newServiceImport(svcExport *mcsv1a1.ServiceExport) *mcsv1a1
 			},
 		},
 	}
+
+	if variant, ok := svcExport.GetAnnotations()[lhconstants.AnnotationVariant]; ok {
+		serviceImport.Annotations[lhconstants.AnnotationVariant] = variant
+	}
+
+	if portRemap, ok := svcExport.GetAnnotations()[lhconstants.AnnotationPortRemap]; ok {
+		serviceImport.Annotations[lhconstants.AnnotationPortRemap] = portRemap
+	}
+
+	if selector, ok := svcExport.GetAnnotations()[lhconstants.AnnotationEndpointSelector]; ok {
+		serviceImport.Annotations[lhconstants.AnnotationEndpointSelector] = selector
+	}
+
+	copyWeight(svcExport, serviceImport)
+	copyGlobalName(svcExport, serviceImport)
+	copyExportDelay(svcExport, serviceImport)
+	copyFrozen(svcExport, serviceImport)
+	copyDraining(svcExport, serviceImport)
+
+	if !svcExport.CreationTimestamp.IsZero() {
+		serviceImport.Annotations[lhconstants.AnnotationExportTime] = svcExport.CreationTimestamp.UTC().Format(time.RFC3339)
+	}
+
+	return serviceImport
+}
+
+func (a *Controller) copyAllowedAnnotations(from *corev1.Service, to *mcsv1a1.ServiceImport) {
+	for key, value := range from.GetAnnotations() {
+		if _, exists := to.Annotations[key]; !exists && a.isAnnotationAllowed(key) {
+			to.Annotations[key] = value
+		}
+	}
+}
+
+// copyMinClusters copies the minimum number of clusters the service must be available from to be resolved, if valid.
+func copyMinClusters(from *corev1.Service, to *mcsv1a1.ServiceImport) {
+	value, ok := from.GetAnnotations()[lhconstants.AnnotationMinClusters]
+	if !ok {
+		return
+	}
+
+	if n, err := strconv.Atoi(value); err != nil || n < 1 {
+		klog.Warningf("Ignoring the %q annotation of Service \"%s/%s\" as %q isn't a positive integer",
+			lhconstants.AnnotationMinClusters, from.Namespace, from.Name, value)
+		return
+	}
+
+	to.Annotations[lhconstants.AnnotationMinClusters] = value
+}
+
+// copySingleton marks the ServiceImport of a service answered with a single primary endpoint, if requested.
+func copySingleton(from *corev1.Service, to *mcsv1a1.ServiceImport) {
+	value, ok := from.GetAnnotations()[lhconstants.AnnotationSingleton]
+	if !ok {
+		return
+	}
+
+	if value != "true" {
+		klog.Warningf("Ignoring the %q annotation of Service \"%s/%s\" as %q isn't \"true\"",
+			lhconstants.AnnotationSingleton, from.Namespace, from.Name, value)
+		return
+	}
+
+	to.Annotations[lhconstants.AnnotationSingleton] = value
+}
+
+// copyDNSPriority copies whether the local or the clusterset answers take precedence for the service, if valid.
+func copyDNSPriority(from *corev1.Service, to *mcsv1a1.ServiceImport) {
+	value, ok := from.GetAnnotations()[lhconstants.AnnotationDNSPriority]
+	if !ok {
+		return
+	}
+
+	if value != lhconstants.DNSPriorityLocal && value != lhconstants.DNSPriorityClusterset {
+		klog.Warningf("Ignoring the %q annotation of Service \"%s/%s\" as %q isn't %q or %q",
+			lhconstants.AnnotationDNSPriority, from.Namespace, from.Name, value, lhconstants.DNSPriorityLocal,
+			lhconstants.DNSPriorityClusterset)
+		return
+	}
+
+	to.Annotations[lhconstants.AnnotationDNSPriority] = value
+}
+
+// copyExportDelay copies the delay after the export's creation before the importing clusters resolve it, if valid, and
+// flags the ServiceImport as pending until it elapsed.
+func copyExportDelay(from *mcsv1a1.ServiceExport, to *mcsv1a1.ServiceImport) {
+	value, ok := from.GetAnnotations()[lhconstants.AnnotationExportDelay]
+	if !ok {
+		return
+	}
+
+	if delay, err := time.ParseDuration(value); err != nil || delay <= 0 {
+		klog.Warningf("Ignoring the %q annotation of ServiceExport \"%s/%s\" as %q isn't a positive duration",
+			lhconstants.AnnotationExportDelay, from.Namespace, from.Name, value)
+		return
+	}
+
+	to.Annotations[lhconstants.AnnotationExportDelay] = value
+
+	if isExportPending(from) {
+		to.Annotations[lhconstants.AnnotationExportPending] = "true"
+	}
+}
+
+// copyFrozen copies the IPs the importing clusters freeze the service's answers to, if valid.
+func copyFrozen(from *mcsv1a1.ServiceExport, to *mcsv1a1.ServiceImport) {
+	value, ok := from.GetAnnotations()[lhconstants.AnnotationFrozen]
+	if !ok {
+		return
+	}
+
+	if serviceimport.ParseFrozenIPs(value) == nil {
+		klog.Warningf("Ignoring the %q annotation of ServiceExport \"%s/%s\" as %q isn't a comma-separated list of IPs",
+			lhconstants.AnnotationFrozen, from.Namespace, from.Name, value)
+		return
+	}
+
+	to.Annotations[lhconstants.AnnotationFrozen] = value
+}
+
+// copyWeight copies the weight the importing clusters give this cluster's endpoints in the round-robin, if valid.
+func copyWeight(from *mcsv1a1.ServiceExport, to *mcsv1a1.ServiceImport) {
+	value, ok := from.GetAnnotations()[lhconstants.AnnotationWeight]
+	if !ok {
+		return
+	}
+
+	if n, err := strconv.Atoi(value); err != nil || n < 1 || n > lhconstants.MaxWeight {
+		klog.Warningf("Ignoring the %q annotation of ServiceExport \"%s/%s\" as %q isn't an integer in range [1, %d]",
+			lhconstants.AnnotationWeight, from.Namespace, from.Name, value, lhconstants.MaxWeight)
+		return
+	}
+
+	to.Annotations[lhconstants.AnnotationWeight] = value
+}
+
+// copyDraining copies the period over which the importing clusters phase this cluster's endpoints out, if valid.
+func copyDraining(from *mcsv1a1.ServiceExport, to *mcsv1a1.ServiceImport) {
+	value, ok := from.GetAnnotations()[lhconstants.AnnotationDraining]
+	if !ok {
+		return
+	}
+
+	if period, err := time.ParseDuration(value); value != "true" && (err != nil || period <= 0) {
+		klog.Warningf("Ignoring the %q annotation of ServiceExport \"%s/%s\" as %q is neither \"true\" nor a positive "+
+			"duration", lhconstants.AnnotationDraining, from.Namespace, from.Name, value)
+		return
+	}
+
+	to.Annotations[lhconstants.AnnotationDraining] = value
+}
+
+// copyGlobalName copies the name the service is also resolvable by under the clusterset's global subdomain, if valid.
+func copyGlobalName(from *mcsv1a1.ServiceExport, to *mcsv1a1.ServiceImport) {
+	value, ok := from.GetAnnotations()[lhconstants.AnnotationGlobalName]
+	if !ok {
+		return
+	}
+
+	if errs := validation.IsDNS1123Label(value); len(errs) > 0 {
+		klog.Warningf("Ignoring the %q annotation of ServiceExport \"%s/%s\" as %q isn't a valid DNS label: %s",
+			lhconstants.AnnotationGlobalName, from.Namespace, from.Name, value, strings.Join(errs, ", "))
+		return
+	}
+
+	to.Annotations[lhconstants.AnnotationGlobalName] = value
+}
+
+func (a *Controller) isAnnotationAllowed(key string) bool {
+	for _, allowed := range a.annotationAllowlist {
+		if allowed == key || (strings.HasSuffix(allowed, "*") && strings.HasPrefix(key, strings.TrimSuffix(allowed, "*"))) {
+			return true
+		}
+	}
+
+	return false
 }
 
 func (a *Controller) getIPsForService(service *corev1.Service, siType mcsv1a1.ServiceImportType) ([]string, error) {
@@ -484,8 +1370,66 @@ func getGlobalIpFromService(service *corev1.Service) string {
 	return ""
 }
 
+// isClusterEligible returns true if the given cluster is a member of the clusterset for the given namespace.
+func (a *Controller) isClusterEligible(namespace, clusterID string) bool {
+	clusters, ok := a.namespaceMembership[namespace]
+	if !ok {
+		return true
+	}
+
+	for _, c := range clusters {
+		if c == clusterID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isImportEligible returns true if a resource for the given namespace exported by the given cluster may be imported
+// into the local cluster, ie both clusters are members of the clusterset for the namespace.
+func (a *Controller) isImportEligible(namespace, sourceClusterID string) bool {
+	return a.isClusterEligible(namespace, sourceClusterID) && a.isClusterEligible(namespace, a.clusterID)
+}
+
+func (a *Controller) remoteServiceImportToLocal(obj runtime.Object, numRequeues int, op syncer.Operation) (runtime.Object, bool) {
+	serviceImport := obj.(*mcsv1a1.ServiceImport)
+
+	if colliding := a.getNameCollision(serviceImport); colliding != nil {
+		if op == syncer.Delete {
+			a.clearCollidingExport(colliding)
+		} else if colliding.Labels[lhconstants.LabelSourceCluster] == a.clusterID {
+			a.reportNameCollision(colliding, serviceImport, collidingExport)
+		}
+
+		klog.Errorf("Not syncing ServiceImport %q from cluster %q as its name collides with that of service (%s/%s) "+
+			"exported by cluster %q", serviceImport.Name, serviceImport.Labels[lhconstants.LabelSourceCluster],
+			colliding.Annotations[lhconstants.OriginNamespace], colliding.Annotations[lhconstants.OriginName],
+			colliding.Labels[lhconstants.LabelSourceCluster])
+
+		return nil, false
+	}
+
+	if op != syncer.Delete && !a.isImportEligible(serviceImport.GetAnnotations()[lhconstants.OriginNamespace],
+		serviceImport.GetLabels()[lhconstants.LabelSourceCluster]) {
+		return nil, false
+	}
+
+	if op != syncer.Delete && a.reallocateDisplacedClustersetIPs(serviceImport, numRequeues) {
+		return nil, true
+	}
+
+	return serviceImport, false
+}
+
 func (a *Controller) remoteEndpointSliceToLocal(obj runtime.Object, numRequeues int, op syncer.Operation) (runtime.Object, bool) {
 	endpointSlice := obj.(*discovery.EndpointSlice)
+
+	if op != syncer.Delete && !a.isImportEligible(endpointSlice.GetLabels()[lhconstants.LabelSourceNamespace],
+		endpointSlice.GetLabels()[lhconstants.LabelSourceCluster]) {
+		return nil, false
+	}
+
 	endpointSlice.Namespace = endpointSlice.GetObjectMeta().GetLabels()[lhconstants.LabelSourceNamespace]
 
 	return endpointSlice, false
@@ -20,21 +20,26 @@ import (
 	"fmt"
 	"reflect"
 	"sort"
+	"strings"
 	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/format"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/submariner-io/admiral/pkg/fake"
+	"github.com/submariner-io/admiral/pkg/federate"
 	"github.com/submariner-io/admiral/pkg/syncer/broker"
 	"github.com/submariner-io/admiral/pkg/syncer/test"
 	"github.com/submariner-io/lighthouse/pkg/agent/controller"
 	lighthousev2a1 "github.com/submariner-io/lighthouse/pkg/apis/lighthouse.submariner.io/v2alpha1"
 	lhconstants "github.com/submariner-io/lighthouse/pkg/constants"
+	"github.com/submariner-io/lighthouse/pkg/ipam"
 	corev1 "k8s.io/api/core/v1"
 	discovery "k8s.io/api/discovery/v1beta1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -48,6 +53,7 @@ import (
 const clusterID1 = "east"
 const clusterID2 = "west"
 const serviceNamespace = "service-ns"
+const serviceExportFinalizer = "lighthouse.submariner.io/service-export-cleanup"
 
 var nodeName = "my-node"
 var hostName = "my-host"
@@ -160,9 +166,59 @@ var _ = Describe("ServiceImport syncing", func() {
 		})
 	})
 
-	When("a ServiceExport is created for a Service whose type is other than ServiceTypeClusterIP", func() {
+	When("a ServiceExport is created for a Service with ClientIP session affinity", func() {
+		BeforeEach(func() {
+			t.service.Spec.SessionAffinity = corev1.ServiceAffinityClientIP
+		})
+
+		It("should propagate the session affinity to the ServiceImport", func() {
+			t.createService()
+			t.createServiceExport()
+			t.awaitServiceExported(t.service.Spec.ClusterIP, 0)
+
+			serviceImport := awaitServiceImport(t.cluster2.localServiceImportClient, t.service, mcsv1a1.ClusterSetIP,
+				t.service.Spec.ClusterIP)
+			Expect(serviceImport.Spec.SessionAffinity).To(Equal(corev1.ServiceAffinityClientIP))
+		})
+	})
+
+	When("a ServiceExport is created for a Service of type NodePort", func() {
 		BeforeEach(func() {
 			t.service.Spec.Type = corev1.ServiceTypeNodePort
+			t.service.Spec.Ports = []corev1.ServicePort{{Port: 80, NodePort: 30080, Protocol: corev1.ProtocolTCP}}
+		})
+
+		It("should sync a ServiceImport with the cluster IP and the pod endpoints and update the ServiceExport status",
+			func() {
+				t.createService()
+				t.createEndpoints()
+				t.createServiceExport()
+				t.awaitServiceExported(t.service.Spec.ClusterIP, 1)
+				t.awaitEndpointSlice()
+
+				t.awaitServiceExportCondition(newServiceExportCondition("NodePort", corev1.ConditionTrue,
+					"NodePortsNotExported"))
+			})
+	})
+
+	When("a ServiceExport with the NodePort condition is exported for a Service of another type", func() {
+		BeforeEach(func() {
+			t.serviceExport.Status.Conditions = []mcsv1a1.ServiceExportCondition{
+				*newServiceExportCondition("NodePort", corev1.ConditionTrue, "NodePortsNotExported"),
+			}
+		})
+
+		It("should clear the NodePort condition", func() {
+			t.createService()
+			t.createServiceExport()
+
+			t.awaitServiceExportCondition(newServiceExportCondition("NodePort", corev1.ConditionFalse, ""))
+		})
+	})
+
+	When("a ServiceExport is created for a Service whose type is other than ServiceTypeClusterIP or NodePort", func() {
+		BeforeEach(func() {
+			t.service.Spec.Type = corev1.ServiceTypeLoadBalancer
 		})
 
 		It("should update the ServiceExport status and not sync a ServiceImport", func() {
@@ -176,6 +232,44 @@ var _ = Describe("ServiceImport syncing", func() {
 	})
 })
 
+var _ = Describe("EndpointSlice refreshes", func() {
+	var t *testDriver
+
+	BeforeEach(func() {
+		t = newTestDiver()
+		t.cluster1.agentSpec.EndpointSliceRefreshPeriod = 300 * time.Millisecond
+	})
+
+	JustBeforeEach(func() {
+		t.justBeforeEach()
+	})
+
+	AfterEach(func() {
+		t.afterEach()
+	})
+
+	When("a ServiceExport is created", func() {
+		It("should periodically stamp the exported EndpointSlice with its refresh time", func() {
+			t.createService()
+			t.createEndpoints()
+			t.createServiceExport()
+			t.awaitEndpointSliceExported()
+
+			refreshed := func() string {
+				obj, err := t.cluster2.localEndpointSliceClient.Get(t.endpoints.Name+"-"+clusterID1, metav1.GetOptions{})
+				Expect(err).To(Succeed())
+
+				return obj.GetAnnotations()[lhconstants.AnnotationRefreshed]
+			}
+
+			Eventually(refreshed, 5).ShouldNot(BeEmpty())
+			first := refreshed()
+
+			Eventually(refreshed, 5).ShouldNot(Equal(first))
+		})
+	})
+})
+
 var _ = Describe("Globalnet enabled", func() {
 	globalIP := "192.168.10.34"
 	var t *testDriver
@@ -219,15 +313,2097 @@ var _ = Describe("Globalnet enabled", func() {
 	})
 
 	When("a ServiceExport is created for a headless Service", func() {
+		createPod := func(name, globalIP string) {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: t.service.Namespace,
+				Labels: t.service.Spec.Selector}}
+			if globalIP != "" {
+				pod.Annotations = map[string]string{"submariner.io/globalIp": globalIP}
+			}
+
+			_, err := t.cluster1.localKubeClient.CoreV1().Pods(t.service.Namespace).Create(pod)
+			Expect(err).To(Succeed())
+		}
+
 		BeforeEach(func() {
 			t.service.Spec.ClusterIP = corev1.ClusterIPNone
+			t.endpoints.Subsets[0].Addresses[0].TargetRef = &corev1.ObjectReference{Kind: "Pod", Name: "pod1",
+				Namespace: t.service.Namespace}
+			t.endpoints.Subsets[0].Addresses[1].TargetRef = &corev1.ObjectReference{Kind: "Pod", Name: "pod2",
+				Namespace: t.service.Namespace}
 		})
 
-		It("should update the ServiceExport status and not sync a ServiceImport", func() {
-			t.awaitServiceExportStatus(0, newServiceExportCondition(mcsv1a1.ServiceExportValid,
-				corev1.ConditionFalse, "UnsupportedServiceType"))
+		When("its pods have global IPs", func() {
+			It("should sync an EndpointSlice with the global IPs of the pods", func() {
+				createPod("pod1", "242.254.1.1")
+				createPod("pod2", "242.254.1.2")
+				t.createEndpoints()
+
+				t.awaitHeadlessServiceImport("")
+				t.awaitEndpointSliceExported()
+				t.awaitUpdatedEndpointSlice([]string{"242.254.1.1", "242.254.1.2", "10.253.6.1"})
+				Expect(readyEndpointIPs(t.brokerEndpointSliceClient, t.endpoints)).To(ConsistOf("242.254.1.1",
+					"242.254.1.2"))
+			})
+		})
+
+		When("one of its pods doesn't initially have a global IP", func() {
+			It("should export its endpoint as not ready until it's assigned one", func() {
+				createPod("pod1", "242.254.1.1")
+				createPod("pod2", "")
+				t.createEndpoints()
+
+				t.awaitHeadlessServiceImport("")
+				t.awaitEndpointSliceExported()
+				t.awaitUpdatedEndpointSlice([]string{"242.254.1.1", "192.168.5.2", "10.253.6.1"})
+				Expect(readyEndpointIPs(t.brokerEndpointSliceClient, t.endpoints)).To(ConsistOf("242.254.1.1"))
+
+				_, err := t.cluster1.localKubeClient.CoreV1().Pods(t.service.Namespace).Update(&corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Name: "pod2", Namespace: t.service.Namespace,
+						Labels: t.service.Spec.Selector, Annotations: map[string]string{"submariner.io/globalIp": "242.254.1.2"}},
+				})
+				Expect(err).To(Succeed())
+
+				t.awaitUpdatedEndpointSlice([]string{"242.254.1.1", "242.254.1.2", "10.253.6.1"})
+				Expect(readyEndpointIPs(t.brokerEndpointSliceClient, t.endpoints)).To(ConsistOf("242.254.1.1",
+					"242.254.1.2"))
+			})
+		})
+
+		When("one of its addresses doesn't belong to a pod", func() {
+			BeforeEach(func() {
+				t.endpoints.Subsets[0].Addresses[1].TargetRef = nil
+			})
+
+			It("should export the address as it is", func() {
+				createPod("pod1", "242.254.1.1")
+				t.createEndpoints()
+
+				t.awaitHeadlessServiceImport("")
+				t.awaitEndpointSliceExported()
+				t.awaitUpdatedEndpointSlice([]string{"242.254.1.1", "192.168.5.2", "10.253.6.1"})
+				Expect(readyEndpointIPs(t.brokerEndpointSliceClient, t.endpoints)).To(ConsistOf("242.254.1.1",
+					"192.168.5.2"))
+			})
+		})
+	})
+})
+
+var _ = Describe("Service annotation propagation", func() {
+	var t *testDriver
+
+	BeforeEach(func() {
+		t = newTestDiver()
+		t.service.SetAnnotations(map[string]string{
+			"mesh.io/subset":   "v1",
+			"mesh.io/version":  "2",
+			"team.io/owner":    "dev",
+			"internal.io/cost": "secret",
+		})
+	})
+
+	JustBeforeEach(func() {
+		t.justBeforeEach()
+		t.createService()
+		t.createServiceExport()
+	})
+
+	AfterEach(func() {
+		t.afterEach()
+	})
+
+	When("no annotation allowlist is configured", func() {
+		It("should not propagate any Service annotations", func() {
+			si := awaitServiceImport(t.brokerServiceImportClient, t.service, mcsv1a1.ClusterSetIP, t.service.Spec.ClusterIP)
+			for key := range t.service.GetAnnotations() {
+				Expect(si.GetAnnotations()).ToNot(HaveKey(key))
+			}
+		})
+	})
+
+	When("an annotation allowlist is configured", func() {
+		BeforeEach(func() {
+			t.cluster1.agentSpec.AnnotationAllowlist = []string{"mesh.io/*", "team.io/owner"}
+		})
+
+		It("should propagate only the allowlisted Service annotations", func() {
+			si := awaitServiceImport(t.brokerServiceImportClient, t.service, mcsv1a1.ClusterSetIP, t.service.Spec.ClusterIP)
+			Expect(si.GetAnnotations()).To(HaveKeyWithValue("mesh.io/subset", "v1"))
+			Expect(si.GetAnnotations()).To(HaveKeyWithValue("mesh.io/version", "2"))
+			Expect(si.GetAnnotations()).To(HaveKeyWithValue("team.io/owner", "dev"))
+			Expect(si.GetAnnotations()).ToNot(HaveKey("internal.io/cost"))
+			Expect(si.GetAnnotations()).To(HaveKeyWithValue(lhconstants.OriginName, t.service.Name))
+		})
+	})
+
+	When("the ServiceExport has a variant annotation", func() {
+		BeforeEach(func() {
+			t.serviceExport.SetAnnotations(map[string]string{lhconstants.AnnotationVariant: "blue"})
+		})
+
+		It("should propagate the variant to the ServiceImport", func() {
+			si := awaitServiceImport(t.brokerServiceImportClient, t.service, mcsv1a1.ClusterSetIP, t.service.Spec.ClusterIP)
+			Expect(si.GetAnnotations()).To(HaveKeyWithValue(lhconstants.AnnotationVariant, "blue"))
+		})
+	})
+
+	When("the ServiceExport has a valid weight annotation", func() {
+		BeforeEach(func() {
+			t.serviceExport.SetAnnotations(map[string]string{lhconstants.AnnotationWeight: "10"})
+		})
+
+		It("should propagate it to the ServiceImport", func() {
+			si := awaitServiceImport(t.brokerServiceImportClient, t.service, mcsv1a1.ClusterSetIP, t.service.Spec.ClusterIP)
+			Expect(si.GetAnnotations()).To(HaveKeyWithValue(lhconstants.AnnotationWeight, "10"))
+		})
+	})
+
+	When("the ServiceExport has an out of range weight annotation", func() {
+		BeforeEach(func() {
+			t.serviceExport.SetAnnotations(map[string]string{lhconstants.AnnotationWeight: "0"})
+		})
+
+		It("should not propagate it to the ServiceImport", func() {
+			si := awaitServiceImport(t.brokerServiceImportClient, t.service, mcsv1a1.ClusterSetIP, t.service.Spec.ClusterIP)
+			Expect(si.GetAnnotations()).ToNot(HaveKey(lhconstants.AnnotationWeight))
+		})
+	})
+
+	When("the ServiceExport has a valid frozen annotation", func() {
+		BeforeEach(func() {
+			t.serviceExport.SetAnnotations(map[string]string{lhconstants.AnnotationFrozen: "10.253.9.1,10.253.9.2"})
+		})
+
+		It("should propagate it to the ServiceImport", func() {
+			si := awaitServiceImport(t.brokerServiceImportClient, t.service, mcsv1a1.ClusterSetIP, t.service.Spec.ClusterIP)
+			Expect(si.GetAnnotations()).To(HaveKeyWithValue(lhconstants.AnnotationFrozen, "10.253.9.1,10.253.9.2"))
+		})
+	})
+
+	When("the ServiceExport has an invalid frozen annotation", func() {
+		BeforeEach(func() {
+			t.serviceExport.SetAnnotations(map[string]string{lhconstants.AnnotationFrozen: "10.253.9.1,east"})
+		})
+
+		It("should not propagate it to the ServiceImport", func() {
+			si := awaitServiceImport(t.brokerServiceImportClient, t.service, mcsv1a1.ClusterSetIP, t.service.Spec.ClusterIP)
+			Expect(si.GetAnnotations()).ToNot(HaveKey(lhconstants.AnnotationFrozen))
+		})
+	})
+
+	When("the ServiceExport has a valid export-delay annotation", func() {
+		BeforeEach(func() {
+			t.serviceExport.SetAnnotations(map[string]string{lhconstants.AnnotationExportDelay: "30s"})
+		})
+
+		It("should propagate it to the ServiceImport", func() {
+			si := awaitServiceImport(t.brokerServiceImportClient, t.service, mcsv1a1.ClusterSetIP, t.service.Spec.ClusterIP)
+			Expect(si.GetAnnotations()).To(HaveKeyWithValue(lhconstants.AnnotationExportDelay, "30s"))
+		})
+	})
+
+	When("the ServiceExport is within its export delay", func() {
+		BeforeEach(func() {
+			t.serviceExport.SetAnnotations(map[string]string{lhconstants.AnnotationExportDelay: "3s"})
+			t.serviceExport.CreationTimestamp = metav1.Now()
+		})
+
+		It("should flag the ServiceImport as pending until the delay elapsed", func() {
+			t.awaitServiceExportCondition(newServiceExportCondition("Pending", corev1.ConditionTrue, "ExportDelayed"))
+
+			si := awaitServiceImport(t.brokerServiceImportClient, t.service, mcsv1a1.ClusterSetIP, t.service.Spec.ClusterIP)
+			Expect(si.GetAnnotations()).To(HaveKeyWithValue(lhconstants.AnnotationExportPending, "true"))
+
+			Eventually(func() map[string]string {
+				obj, err := t.brokerServiceImportClient.Get(si.Name, metav1.GetOptions{})
+				Expect(err).To(Succeed())
+
+				return obj.GetAnnotations()
+			}, 6).ShouldNot(HaveKey(lhconstants.AnnotationExportPending))
+
+			t.awaitServiceExportCondition(newServiceExportCondition("Pending", corev1.ConditionFalse, ""))
+		})
+	})
+
+	When("the ServiceExport has an invalid export-delay annotation", func() {
+		BeforeEach(func() {
+			t.serviceExport.SetAnnotations(map[string]string{lhconstants.AnnotationExportDelay: "-30s"})
+		})
+
+		It("should not propagate it to the ServiceImport", func() {
+			si := awaitServiceImport(t.brokerServiceImportClient, t.service, mcsv1a1.ClusterSetIP, t.service.Spec.ClusterIP)
+			Expect(si.GetAnnotations()).ToNot(HaveKey(lhconstants.AnnotationExportDelay))
+		})
+	})
+
+	When("the ServiceExport has a draining annotation", func() {
+		BeforeEach(func() {
+			t.serviceExport.SetAnnotations(map[string]string{lhconstants.AnnotationDraining: "true"})
+		})
+
+		It("should propagate it to the ServiceImport", func() {
+			si := awaitServiceImport(t.brokerServiceImportClient, t.service, mcsv1a1.ClusterSetIP, t.service.Spec.ClusterIP)
+			Expect(si.GetAnnotations()).To(HaveKeyWithValue(lhconstants.AnnotationDraining, "true"))
+		})
+	})
+
+	When("the ServiceExport has a draining annotation with a drain period", func() {
+		BeforeEach(func() {
+			t.serviceExport.SetAnnotations(map[string]string{lhconstants.AnnotationDraining: "30m"})
+		})
+
+		It("should propagate it to the ServiceImport", func() {
+			si := awaitServiceImport(t.brokerServiceImportClient, t.service, mcsv1a1.ClusterSetIP, t.service.Spec.ClusterIP)
+			Expect(si.GetAnnotations()).To(HaveKeyWithValue(lhconstants.AnnotationDraining, "30m"))
+		})
+	})
+
+	When("the ServiceExport has an invalid draining annotation", func() {
+		BeforeEach(func() {
+			t.serviceExport.SetAnnotations(map[string]string{lhconstants.AnnotationDraining: "soon"})
+		})
+
+		It("should not propagate it to the ServiceImport", func() {
+			si := awaitServiceImport(t.brokerServiceImportClient, t.service, mcsv1a1.ClusterSetIP, t.service.Spec.ClusterIP)
+			Expect(si.GetAnnotations()).ToNot(HaveKey(lhconstants.AnnotationDraining))
+		})
+	})
+
+	When("the ServiceExport has a global name annotation", func() {
+		BeforeEach(func() {
+			t.serviceExport.SetAnnotations(map[string]string{lhconstants.AnnotationGlobalName: "registry"})
+		})
+
+		It("should propagate it to the ServiceImport", func() {
+			si := awaitServiceImport(t.brokerServiceImportClient, t.service, mcsv1a1.ClusterSetIP, t.service.Spec.ClusterIP)
+			Expect(si.GetAnnotations()).To(HaveKeyWithValue(lhconstants.AnnotationGlobalName, "registry"))
+		})
+	})
+
+	When("the ServiceExport has an invalid global name annotation", func() {
+		BeforeEach(func() {
+			t.serviceExport.SetAnnotations(map[string]string{lhconstants.AnnotationGlobalName: "registry.infra"})
+		})
+
+		It("should not propagate it to the ServiceImport", func() {
+			si := awaitServiceImport(t.brokerServiceImportClient, t.service, mcsv1a1.ClusterSetIP, t.service.Spec.ClusterIP)
+			Expect(si.GetAnnotations()).ToNot(HaveKey(lhconstants.AnnotationGlobalName))
+		})
+	})
+
+	When("the Service has a valid minimum clusters annotation", func() {
+		BeforeEach(func() {
+			t.service.Annotations[lhconstants.AnnotationMinClusters] = "2"
+		})
+
+		It("should propagate it to the ServiceImport", func() {
+			si := awaitServiceImport(t.brokerServiceImportClient, t.service, mcsv1a1.ClusterSetIP, t.service.Spec.ClusterIP)
+			Expect(si.GetAnnotations()).To(HaveKeyWithValue(lhconstants.AnnotationMinClusters, "2"))
+		})
+	})
+
+	When("the Service has an invalid minimum clusters annotation", func() {
+		BeforeEach(func() {
+			t.service.Annotations[lhconstants.AnnotationMinClusters] = "none"
+		})
+
+		It("should not propagate it to the ServiceImport", func() {
+			si := awaitServiceImport(t.brokerServiceImportClient, t.service, mcsv1a1.ClusterSetIP, t.service.Spec.ClusterIP)
+			Expect(si.GetAnnotations()).ToNot(HaveKey(lhconstants.AnnotationMinClusters))
+		})
+	})
+
+	When("the Service has a singleton annotation", func() {
+		BeforeEach(func() {
+			t.service.Annotations[lhconstants.AnnotationSingleton] = "true"
+		})
+
+		It("should propagate it to the ServiceImport", func() {
+			si := awaitServiceImport(t.brokerServiceImportClient, t.service, mcsv1a1.ClusterSetIP, t.service.Spec.ClusterIP)
+			Expect(si.GetAnnotations()).To(HaveKeyWithValue(lhconstants.AnnotationSingleton, "true"))
+		})
+	})
+
+	When("the Service has a DNS priority annotation", func() {
+		BeforeEach(func() {
+			t.service.Annotations[lhconstants.AnnotationDNSPriority] = lhconstants.DNSPriorityClusterset
+		})
+
+		It("should propagate it to the ServiceImport", func() {
+			si := awaitServiceImport(t.brokerServiceImportClient, t.service, mcsv1a1.ClusterSetIP, t.service.Spec.ClusterIP)
+			Expect(si.GetAnnotations()).To(HaveKeyWithValue(lhconstants.AnnotationDNSPriority, lhconstants.DNSPriorityClusterset))
+		})
+	})
+
+	When("the Service has an invalid DNS priority annotation", func() {
+		BeforeEach(func() {
+			t.service.Annotations[lhconstants.AnnotationDNSPriority] = "remote"
+		})
+
+		It("should not propagate it to the ServiceImport", func() {
+			si := awaitServiceImport(t.brokerServiceImportClient, t.service, mcsv1a1.ClusterSetIP, t.service.Spec.ClusterIP)
+			Expect(si.GetAnnotations()).ToNot(HaveKey(lhconstants.AnnotationDNSPriority))
+		})
+	})
+
+	When("the Service has an invalid singleton annotation", func() {
+		BeforeEach(func() {
+			t.service.Annotations[lhconstants.AnnotationSingleton] = "yes"
+		})
+
+		It("should not propagate it to the ServiceImport", func() {
+			si := awaitServiceImport(t.brokerServiceImportClient, t.service, mcsv1a1.ClusterSetIP, t.service.Spec.ClusterIP)
+			Expect(si.GetAnnotations()).ToNot(HaveKey(lhconstants.AnnotationSingleton))
+		})
+	})
+})
+
+var _ = Describe("Port remapping", func() {
+	const canonicalPort = int32(80)
+
+	var t *testDriver
+
+	BeforeEach(func() {
+		t = newTestDiver()
+		t.service.Spec.Ports = []corev1.ServicePort{{Name: "http", Protocol: corev1.ProtocolTCP, Port: 8080}}
+		t.endpoints.Subsets[0].Ports = []corev1.EndpointPort{{Name: "http", Protocol: corev1.ProtocolTCP, Port: 8080}}
+	})
+
+	JustBeforeEach(func() {
+		t.justBeforeEach()
+	})
+
+	AfterEach(func() {
+		t.afterEach()
+	})
+
+	awaitBrokerPorts := func(clusterID string) ([]mcsv1a1.ServicePort, []discovery.EndpointPort) {
+		obj := test.AwaitResource(t.brokerServiceImportClient, t.service.Name+"-"+t.service.Namespace+"-"+clusterID)
+		serviceImport := &mcsv1a1.ServiceImport{}
+		Expect(scheme.Scheme.Convert(obj, serviceImport, nil)).To(Succeed())
+
+		obj = test.AwaitResource(t.brokerEndpointSliceClient, t.endpoints.Name+"-"+clusterID)
+		endpointSlice := &discovery.EndpointSlice{}
+		Expect(scheme.Scheme.Convert(obj, endpointSlice, nil)).To(Succeed())
+
+		return serviceImport.Spec.Ports, endpointSlice.Ports
+	}
+
+	When("a service with divergent ports in two clusters is remapped to a canonical port", func() {
+		BeforeEach(func() {
+			t.serviceExport.SetAnnotations(map[string]string{lhconstants.AnnotationPortRemap: "8080:80"})
+		})
+
+		It("should export both with the canonical port on their ServiceImport and their own EndpointSlice port", func() {
+			t.createService()
+			t.createEndpoints()
+			t.createServiceExport()
+
+			service2 := t.service.DeepCopy()
+			service2.Spec.Ports[0].Port = canonicalPort
+			_, err := t.cluster2.localKubeClient.CoreV1().Services(service2.Namespace).Create(service2)
+			Expect(err).To(Succeed())
+			test.CreateResource(t.cluster2.localDynClient.Resource(schema.GroupVersionResource{Version: "v1",
+				Resource: "services"}).Namespace(service2.Namespace), service2)
+
+			endpoints2 := t.endpoints.DeepCopy()
+			endpoints2.Subsets[0].Ports[0].Port = canonicalPort
+			_, err = t.cluster2.localKubeClient.CoreV1().Endpoints(endpoints2.Namespace).Create(endpoints2)
+			Expect(err).To(Succeed())
+			test.CreateResource(t.cluster2.localDynClient.Resource(schema.GroupVersionResource{Version: "v1",
+				Resource: "endpoints"}).Namespace(endpoints2.Namespace), endpoints2)
+
+			serviceExport2 := t.serviceExport.DeepCopy()
+			serviceExport2.SetAnnotations(nil)
+			test.CreateResource(t.cluster2.localServiceExportClient, serviceExport2)
+
+			for clusterID, targetPort := range map[string]int32{clusterID1: 8080, clusterID2: canonicalPort} {
+				importPorts, slicePorts := awaitBrokerPorts(clusterID)
+				Expect(importPorts).To(Equal([]mcsv1a1.ServicePort{{Name: "http", Protocol: corev1.ProtocolTCP,
+					Port: canonicalPort}}), "ServiceImport ports of %q", clusterID)
+				Expect(slicePorts).To(HaveLen(1))
+				Expect(*slicePorts[0].Name).To(Equal("http"))
+				Expect(*slicePorts[0].Port).To(Equal(targetPort), "EndpointSlice port of %q", clusterID)
+			}
+		})
+	})
+
+	When("the port remapping of an exported service is changed", func() {
+		It("should re-export the ServiceImport with the new canonical port", func() {
+			t.createService()
+			t.createEndpoints()
+			t.createServiceExport()
+			t.awaitServiceExported(t.service.Spec.ClusterIP, 0)
+
+			importPorts, _ := awaitBrokerPorts(clusterID1)
+			Expect(importPorts[0].Port).To(Equal(int32(8080)))
+
+			serviceExport := t.getServiceExport()
+			serviceExport.SetAnnotations(map[string]string{lhconstants.AnnotationPortRemap: "8080:80"})
+			test.UpdateResource(t.cluster1.localServiceExportClient, serviceExport)
+
+			Eventually(func() int32 {
+				importPorts, _ := awaitBrokerPorts(clusterID1)
+				return importPorts[0].Port
+			}, 5).Should(Equal(canonicalPort))
+		})
+	})
+
+	When("a remapped port collides with another port of the service", func() {
+		BeforeEach(func() {
+			t.service.Spec.Ports = append(t.service.Spec.Ports, corev1.ServicePort{Name: "web", Protocol: corev1.ProtocolTCP,
+				Port: canonicalPort})
+			t.serviceExport.SetAnnotations(map[string]string{lhconstants.AnnotationPortRemap: "8080:80"})
+		})
+
+		It("should update the ServiceExport status and not sync a ServiceImport", func() {
+			t.createService()
+			t.createServiceExport()
+
+			t.awaitServiceExportCondition(newServiceExportCondition(mcsv1a1.ServiceExportValid,
+				corev1.ConditionFalse, "InvalidPortRemap"))
+			t.awaitNoServiceImport(t.brokerServiceImportClient)
+		})
+	})
+
+	When("the port remapping refers to a port the service doesn't have", func() {
+		BeforeEach(func() {
+			t.serviceExport.SetAnnotations(map[string]string{lhconstants.AnnotationPortRemap: "9090:80"})
+		})
+
+		It("should update the ServiceExport status and not sync a ServiceImport", func() {
+			t.createService()
+			t.createServiceExport()
+
+			t.awaitServiceExportCondition(newServiceExportCondition(mcsv1a1.ServiceExportValid,
+				corev1.ConditionFalse, "InvalidPortRemap"))
+			t.awaitNoServiceImport(t.brokerServiceImportClient)
+		})
+	})
+})
+
+var _ = Describe("Port appProtocol", func() {
+	var t *testDriver
+
+	BeforeEach(func() {
+		t = newTestDiver()
+		t.service.Spec.Ports = []corev1.ServicePort{
+			{Name: "grpc", Protocol: corev1.ProtocolTCP, Port: 8080},
+			{Name: "metrics", Protocol: corev1.ProtocolTCP, Port: 9090},
+		}
+	})
+
+	JustBeforeEach(func() {
+		t.justBeforeEach()
+	})
+
+	AfterEach(func() {
+		t.afterEach()
+	})
+
+	// The appProtocol is set on the unstructured Service as the vendored Service type doesn't have it.
+	newServiceWithAppProtocol := func() *unstructured.Unstructured {
+		obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(t.service)
+		Expect(err).To(Succeed())
+
+		ports, _, _ := unstructured.NestedSlice(obj, "spec", "ports")
+		ports[0].(map[string]interface{})["appProtocol"] = "kubernetes.io/h2c"
+		Expect(unstructured.SetNestedSlice(obj, ports, "spec", "ports")).To(Succeed())
+
+		service := &unstructured.Unstructured{Object: obj}
+		service.SetAPIVersion("v1")
+		service.SetKind("Service")
+
+		return service
+	}
+
+	When("a port of the exported service has an appProtocol", func() {
+		It("should propagate it to the ServiceImport port", func() {
+			_, err := t.cluster1.localKubeClient.CoreV1().Services(t.service.Namespace).Create(t.service)
+			Expect(err).To(Succeed())
+
+			_, err = t.dynamicServiceClient().Create(newServiceWithAppProtocol(), metav1.CreateOptions{})
+			Expect(err).To(Succeed())
+
+			t.createServiceExport()
+
+			si := awaitServiceImport(t.brokerServiceImportClient, t.service, mcsv1a1.ClusterSetIP, t.service.Spec.ClusterIP)
+			Expect(si.Spec.Ports).To(HaveLen(2))
+			Expect(si.Spec.Ports[0].AppProtocol).ToNot(BeNil())
+			Expect(*si.Spec.Ports[0].AppProtocol).To(Equal("kubernetes.io/h2c"))
+			Expect(si.Spec.Ports[1].AppProtocol).To(BeNil())
+
+			si = awaitServiceImport(t.cluster2.localServiceImportClient, t.service, mcsv1a1.ClusterSetIP,
+				t.service.Spec.ClusterIP)
+			Expect(si.Spec.Ports[0].AppProtocol).ToNot(BeNil())
+			Expect(*si.Spec.Ports[0].AppProtocol).To(Equal("kubernetes.io/h2c"))
+		})
+	})
+
+	When("an appProtocol is set on a port after the service is exported", func() {
+		It("should re-export the ServiceImport with it", func() {
+			t.createService()
+			t.createServiceExport()
+			t.awaitServiceExported(t.service.Spec.ClusterIP, 0)
+
+			_, err := t.dynamicServiceClient().Update(newServiceWithAppProtocol(), metav1.UpdateOptions{})
+			Expect(err).To(Succeed())
+
+			Eventually(func() *string {
+				si := awaitServiceImport(t.brokerServiceImportClient, t.service, mcsv1a1.ClusterSetIP,
+					t.service.Spec.ClusterIP)
+				return si.Spec.Ports[0].AppProtocol
+			}, 5).ShouldNot(BeNil())
+		})
+	})
+})
+
+var _ = Describe("Internal traffic policy", func() {
+	var t *testDriver
+
+	BeforeEach(func() {
+		t = newTestDiver()
+	})
+
+	JustBeforeEach(func() {
+		t.justBeforeEach()
+	})
+
+	AfterEach(func() {
+		t.afterEach()
+	})
+
+	// The policy is set on the unstructured Service as the vendored Service type doesn't have it.
+	newServiceWithPolicy := func(policy string) *unstructured.Unstructured {
+		obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(t.service)
+		Expect(err).To(Succeed())
+		Expect(unstructured.SetNestedField(obj, policy, "spec", "internalTrafficPolicy")).To(Succeed())
+
+		service := &unstructured.Unstructured{Object: obj}
+		service.SetAPIVersion("v1")
+		service.SetKind("Service")
+
+		return service
+	}
+
+	createServiceWithPolicy := func(policy string) {
+		_, err := t.cluster1.localKubeClient.CoreV1().Services(t.service.Namespace).Create(t.service)
+		Expect(err).To(Succeed())
+
+		_, err = t.dynamicServiceClient().Create(newServiceWithPolicy(policy), metav1.CreateOptions{})
+		Expect(err).To(Succeed())
+	}
+
+	When("the exported service has a Local internalTrafficPolicy", func() {
+		It("should capture it on the ServiceImport and update the ServiceExport status", func() {
+			createServiceWithPolicy("Local")
+			t.createServiceExport()
+
+			si := awaitServiceImport(t.brokerServiceImportClient, t.service, mcsv1a1.ClusterSetIP, t.service.Spec.ClusterIP)
+			Expect(si.Annotations).To(HaveKeyWithValue(lhconstants.AnnotationInternalTrafficPolicy, "Local"))
+
+			si = awaitServiceImport(t.cluster2.localServiceImportClient, t.service, mcsv1a1.ClusterSetIP,
+				t.service.Spec.ClusterIP)
+			Expect(si.Annotations).To(HaveKeyWithValue(lhconstants.AnnotationInternalTrafficPolicy, "Local"))
+
+			t.awaitServiceExportCondition(newServiceExportCondition("InternalTrafficPolicy", corev1.ConditionTrue,
+				"LocalTrafficPolicy"))
+		})
+	})
+
+	When("the exported service's internalTrafficPolicy changes from Local to Cluster", func() {
+		It("should re-export the ServiceImport with it and clear the InternalTrafficPolicy condition", func() {
+			createServiceWithPolicy("Local")
+			t.createServiceExport()
+			t.awaitServiceExportCondition(newServiceExportCondition("InternalTrafficPolicy", corev1.ConditionTrue,
+				"LocalTrafficPolicy"))
+
+			_, err := t.dynamicServiceClient().Update(newServiceWithPolicy("Cluster"), metav1.UpdateOptions{})
+			Expect(err).To(Succeed())
+
+			t.awaitServiceExportCondition(newServiceExportCondition("InternalTrafficPolicy", corev1.ConditionFalse, ""))
+
+			Eventually(func() map[string]string {
+				return awaitServiceImport(t.brokerServiceImportClient, t.service, mcsv1a1.ClusterSetIP,
+					t.service.Spec.ClusterIP).Annotations
+			}, 5).Should(HaveKeyWithValue(lhconstants.AnnotationInternalTrafficPolicy, "Cluster"))
+		})
+	})
+
+	When("the exported service has a Cluster internalTrafficPolicy", func() {
+		It("should capture it on the ServiceImport", func() {
+			createServiceWithPolicy("Cluster")
+			t.createServiceExport()
+
+			si := awaitServiceImport(t.brokerServiceImportClient, t.service, mcsv1a1.ClusterSetIP, t.service.Spec.ClusterIP)
+			Expect(si.Annotations).To(HaveKeyWithValue(lhconstants.AnnotationInternalTrafficPolicy, "Cluster"))
+		})
+
+		It("should clear the InternalTrafficPolicy condition of a previously Local policy", func() {
+			t.serviceExport.Status.Conditions = []mcsv1a1.ServiceExportCondition{
+				*newServiceExportCondition("InternalTrafficPolicy", corev1.ConditionTrue, "LocalTrafficPolicy"),
+			}
+
+			createServiceWithPolicy("Cluster")
+			t.createServiceExport()
+
+			t.awaitServiceExportCondition(newServiceExportCondition("InternalTrafficPolicy", corev1.ConditionFalse, ""))
+		})
+	})
+
+	When("the exported service has no internalTrafficPolicy", func() {
+		It("should not set it on the ServiceImport", func() {
+			t.createService()
+			t.createServiceExport()
+
+			si := awaitServiceImport(t.brokerServiceImportClient, t.service, mcsv1a1.ClusterSetIP, t.service.Spec.ClusterIP)
+			Expect(si.Annotations).ToNot(HaveKey(lhconstants.AnnotationInternalTrafficPolicy))
+		})
+	})
+})
+
+var _ = Describe("HTTPRoute exports", func() {
+	const routeName = "nginx-route"
+
+	var (
+		t       *testDriver
+		backend *corev1.Service
+	)
+
+	BeforeEach(func() {
+		t = newTestDiver()
+		t.cluster1.agentSpec.GatewayAPIRoutes = true
+		t.serviceExport.Annotations = map[string]string{lhconstants.AnnotationHTTPRoute: routeName}
+
+		backend = newBackendService("nginx-v1", "10.253.9.11")
+	})
+
+	JustBeforeEach(func() {
+		t.justBeforeEach()
+	})
+
+	AfterEach(func() {
+		t.afterEach()
+	})
+
+	When("the ServiceExport references an HTTPRoute with one backend Service", func() {
+		JustBeforeEach(func() {
+			t.createBackendService(backend)
+			t.createHTTPRoute(routeName, backend.Name)
+			t.createServiceExport()
+		})
+
+		It("should export the backend Service under the ServiceExport's name", func() {
+			si := awaitServiceImport(t.brokerServiceImportClient, t.service, mcsv1a1.ClusterSetIP, backend.Spec.ClusterIP)
+			Expect(si.Annotations).To(HaveKeyWithValue(lhconstants.AnnotationBackend, backend.Name))
+
+			t.cluster2.awaitServiceImport(t.service, mcsv1a1.ClusterSetIP, backend.Spec.ClusterIP)
+		})
+
+		Context("and the HTTPRoute's backend changes", func() {
+			It("should export the new backend Service", func() {
+				t.awaitBrokerServiceImport(mcsv1a1.ClusterSetIP, backend.Spec.ClusterIP)
+
+				newBackend := newBackendService("nginx-v2", "10.253.9.12")
+				t.createBackendService(newBackend)
+				test.UpdateResource(t.httpRouteClient(), newHTTPRoute(routeName, newBackend.Name))
+
+				si := awaitUpdatedServiceImport(t.brokerServiceImportClient, t.service, newBackend.Spec.ClusterIP)
+				Expect(si.Annotations).To(HaveKeyWithValue(lhconstants.AnnotationBackend, newBackend.Name))
+			})
+		})
+
+		Context("and the backend Service's ports change", func() {
+			It("should re-export the backend Service with its new ports", func() {
+				t.awaitBrokerServiceImport(mcsv1a1.ClusterSetIP, backend.Spec.ClusterIP)
+
+				backend.Spec.Ports = []corev1.ServicePort{{Name: "http", Protocol: corev1.ProtocolTCP, Port: 8080}}
+				test.UpdateResource(t.dynamicServiceClient(), backend)
+
+				Eventually(func() []mcsv1a1.ServicePort {
+					return awaitServiceImport(t.brokerServiceImportClient, t.service, mcsv1a1.ClusterSetIP,
+						backend.Spec.ClusterIP).Spec.Ports
+				}, 5).Should(ContainElement(mcsv1a1.ServicePort{Name: "http", Protocol: corev1.ProtocolTCP, Port: 8080}))
+			})
+		})
+
+		Context("and the HTTPRoute is deleted", func() {
+			It("should unexport the service", func() {
+				t.awaitBrokerServiceImport(mcsv1a1.ClusterSetIP, backend.Spec.ClusterIP)
+
+				Expect(t.httpRouteClient().Delete(routeName, &metav1.DeleteOptions{})).To(Succeed())
+
+				t.awaitServiceUnexported()
+				t.awaitServiceExportCondition(newServiceExportCondition(mcsv1a1.ServiceExportValid,
+					corev1.ConditionFalse, "InvalidHTTPRoute"))
+			})
+		})
+	})
+
+	When("the ServiceExport references an HTTPRoute with several backend Services", func() {
+		JustBeforeEach(func() {
+			t.createBackendService(backend)
+			t.createHTTPRoute(routeName, backend.Name, "nginx-v2")
+			t.createServiceExport()
+		})
+
+		It("should update the ServiceExport status and not export the service", func() {
+			t.awaitServiceExportCondition(newServiceExportCondition(mcsv1a1.ServiceExportValid,
+				corev1.ConditionFalse, "InvalidHTTPRoute"))
+			t.awaitNoServiceImport(t.brokerServiceImportClient)
+		})
+	})
+
+	When("the ServiceExport references an HTTPRoute that doesn't exist", func() {
+		JustBeforeEach(func() {
+			t.createBackendService(backend)
+			t.createServiceExport()
+		})
+
+		It("should update the ServiceExport status and export the service once the HTTPRoute is created", func() {
+			t.awaitServiceExportCondition(newServiceExportCondition(mcsv1a1.ServiceExportValid,
+				corev1.ConditionFalse, "InvalidHTTPRoute"))
+			t.awaitNoServiceImport(t.brokerServiceImportClient)
+
+			t.createHTTPRoute(routeName, backend.Name)
+
+			t.awaitBrokerServiceImport(mcsv1a1.ClusterSetIP, backend.Spec.ClusterIP)
+		})
+	})
+
+	When("the Gateway API routes aren't enabled", func() {
+		BeforeEach(func() {
+			t.cluster1.agentSpec.GatewayAPIRoutes = false
+		})
+
+		JustBeforeEach(func() {
+			t.createBackendService(backend)
+			t.createServiceExport()
+		})
+
+		It("should update the ServiceExport status and not export the service", func() {
+			t.awaitServiceExportCondition(newServiceExportCondition(mcsv1a1.ServiceExportValid,
+				corev1.ConditionFalse, "InvalidHTTPRoute"))
+			t.awaitNoServiceImport(t.brokerServiceImportClient)
+		})
+	})
+})
+
+var _ = Describe("Namespace clusterset membership", func() {
+	var t *testDriver
+
+	BeforeEach(func() {
+		t = newTestDiver()
+	})
+
+	JustBeforeEach(func() {
+		t.justBeforeEach()
+		t.createService()
+		t.createServiceExport()
+	})
+
+	AfterEach(func() {
+		t.afterEach()
+	})
+
+	When("both clusters are eligible for the namespace", func() {
+		BeforeEach(func() {
+			membership := map[string]string{serviceNamespace: clusterID1 + ";" + clusterID2}
+			t.cluster1.agentSpec.NamespaceMembership = membership
+			t.cluster2.agentSpec.NamespaceMembership = membership
+		})
+
+		It("should export and import the service", func() {
+			t.awaitServiceExported(t.service.Spec.ClusterIP, 0)
+		})
+	})
+
+	When("the exporting cluster isn't eligible for the namespace", func() {
+		BeforeEach(func() {
+			membership := map[string]string{serviceNamespace: clusterID2}
+			t.cluster1.agentSpec.NamespaceMembership = membership
+			t.cluster2.agentSpec.NamespaceMembership = membership
+		})
+
+		It("should update the ServiceExport status and not export the service", func() {
+			t.awaitServiceExportStatus(0, newServiceExportCondition(mcsv1a1.ServiceExportValid,
+				corev1.ConditionFalse, "ClusterNotEligible"))
+			t.awaitNoServiceImport(t.brokerServiceImportClient)
+		})
+	})
+
+	When("the importing cluster isn't eligible for the namespace", func() {
+		BeforeEach(func() {
+			membership := map[string]string{serviceNamespace: clusterID1}
+			t.cluster1.agentSpec.NamespaceMembership = membership
+			t.cluster2.agentSpec.NamespaceMembership = membership
+		})
+
+		It("should export the service but not import it into the ineligible cluster", func() {
+			t.cluster1.awaitServiceImport(t.service, mcsv1a1.ClusterSetIP, t.service.Spec.ClusterIP)
+			t.awaitBrokerServiceImport(mcsv1a1.ClusterSetIP, t.service.Spec.ClusterIP)
+			t.awaitNoServiceImport(t.cluster2.localServiceImportClient)
+		})
+	})
+
+	When("the namespace isn't listed in the membership", func() {
+		BeforeEach(func() {
+			membership := map[string]string{"other-ns": clusterID2}
+			t.cluster1.agentSpec.NamespaceMembership = membership
+			t.cluster2.agentSpec.NamespaceMembership = membership
+		})
+
+		It("should export and import the service", func() {
+			t.awaitServiceExported(t.service.Spec.ClusterIP, 0)
+		})
+	})
+})
+
+var _ = Describe("ServiceExport label selector", func() {
+	var t *testDriver
+
+	BeforeEach(func() {
+		t = newTestDiver()
+		t.cluster1.agentSpec.ServiceExportSelector = "lighthouse=enabled"
+	})
+
+	AfterEach(func() {
+		t.afterEach()
+	})
+
+	When("the ServiceExport matches the selector", func() {
+		BeforeEach(func() {
+			t.serviceExport.Labels = map[string]string{"lighthouse": "enabled"}
+		})
+
+		JustBeforeEach(func() {
+			t.justBeforeEach()
+			t.createService()
+			t.createServiceExport()
+		})
+
+		It("should export the service", func() {
+			t.awaitServiceExported(t.service.Spec.ClusterIP, 0)
+		})
+	})
+
+	When("the ServiceExport doesn't match the selector", func() {
+		BeforeEach(func() {
+			t.serviceExport.Labels = map[string]string{"lighthouse": "disabled"}
+		})
+
+		// The fake client only applies label selectors when listing, so the ServiceExport is created before the
+		// agent is started.
+		JustBeforeEach(func() {
+			t.createService()
+			t.createServiceExport()
+			t.justBeforeEach()
+		})
+
+		It("should not export the service", func() {
+			t.awaitNoServiceImport(t.brokerServiceImportClient)
+			t.awaitNoServiceImport(t.cluster1.localServiceImportClient)
+		})
+	})
+
+	When("the selector is invalid", func() {
+		It("should fail to create the controller", func() {
+			syncerConfig := *t.syncerConfig
+			syncerConfig.LocalClient = t.cluster2.localDynClient
+			t.cluster2.agentSpec.ServiceExportSelector = "lighthouse in enabled"
+
+			_, err := controller.New(&t.cluster2.agentSpec, syncerConfig, t.cluster2.localKubeClient)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
+
+var _ = Describe("Clusterset name length", func() {
+	var t *testDriver
+
+	BeforeEach(func() {
+		t = newTestDiver()
+	})
+
+	AfterEach(func() {
+		t.afterEach()
+	})
+
+	When("the clusterset names are within the DNS limits", func() {
+		JustBeforeEach(func() {
+			t.justBeforeEach()
+			t.createService()
+			t.createServiceExport()
+		})
+
+		It("should export the service without a NameLength condition", func() {
+			t.awaitServiceExported(t.service.Spec.ClusterIP, 0)
+			t.awaitNotServiceExportStatus(newServiceExportCondition("NameLength", corev1.ConditionTrue,
+				"ClusterNameTooLong"))
+		})
+
+		Context("and the ServiceExport has a NameLength condition", func() {
+			BeforeEach(func() {
+				t.serviceExport.Status.Conditions = []mcsv1a1.ServiceExportCondition{
+					*newServiceExportCondition("NameLength", corev1.ConditionTrue, "ClusterNameTooLong"),
+				}
+			})
+
+			It("should clear the condition", func() {
+				t.awaitServiceExportCondition(newServiceExportCondition("NameLength", corev1.ConditionFalse, ""))
+			})
+		})
+	})
+
+	When("the cluster ID is longer than a DNS label", func() {
+		longClusterID := strings.Repeat("c", 64)
+
+		BeforeEach(func() {
+			t.cluster1.agentSpec.ClusterID = longClusterID
+		})
+
+		JustBeforeEach(func() {
+			t.justBeforeEach()
+			t.createService()
+			t.createServiceExport()
+		})
+
+		It("should export the service and update the ServiceExport status", func() {
+			t.awaitServiceExportCondition(newServiceExportCondition("NameLength", corev1.ConditionTrue,
+				"ClusterNameTooLong"))
+			test.AwaitResource(t.brokerServiceImportClient, t.service.Name+"-"+t.service.Namespace+"-"+longClusterID)
+		})
+	})
+
+	When("the clusterset name is longer than the configured maximum", func() {
+		BeforeEach(func() {
+			t.cluster1.agentSpec.MaxClustersetNameLength = 30
+		})
+
+		JustBeforeEach(func() {
+			t.justBeforeEach()
+			t.createService()
+			t.createServiceExport()
+		})
+
+		It("should update the ServiceExport status and not sync a ServiceImport", func() {
+			t.awaitServiceExportCondition(newServiceExportCondition(mcsv1a1.ServiceExportValid, corev1.ConditionFalse,
+				"ClustersetNameTooLong"))
+			t.awaitNoServiceImport(t.brokerServiceImportClient)
+		})
+	})
+
+	When("the configured maximum is beyond the DNS limit", func() {
+		It("should fail to create the controller", func() {
+			syncerConfig := *t.syncerConfig
+			syncerConfig.LocalClient = t.cluster2.localDynClient
+			t.cluster2.agentSpec.MaxClustersetNameLength = 300
+
+			_, err := controller.New(&t.cluster2.agentSpec, syncerConfig, t.cluster2.localKubeClient)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
+
+var _ = Describe("ServiceExport deletion cleanup", func() {
+	var t *testDriver
+
+	BeforeEach(func() {
+		t = newTestDiver()
+	})
+
+	JustBeforeEach(func() {
+		t.justBeforeEach()
+		t.createService()
+	})
+
+	AfterEach(func() {
+		t.afterEach()
+	})
+
+	When("a ServiceExport is exported", func() {
+		It("should add the cleanup finalizer", func() {
+			t.createServiceExport()
+			t.awaitServiceExported(t.service.Spec.ClusterIP, 0)
+			Expect(t.getServiceExport().Finalizers).To(ContainElement(serviceExportFinalizer))
+		})
+	})
+
+	When("a ServiceExport is deleted after a ServiceImport is synced", func() {
+		It("should delete the ServiceImports before removing the finalizer", func() {
+			t.createServiceExport()
+			t.awaitServiceExported(t.service.Spec.ClusterIP, 0)
+
+			t.finalizeServiceExport()
+			t.awaitServiceUnexported()
+		})
+	})
+
+	When("a ServiceExport is deleted right after being created", func() {
+		It("should not leave an orphaned ServiceImport", func() {
+			t.serviceExport.Finalizers = []string{serviceExportFinalizer}
+			t.createServiceExport()
+
+			t.finalizeServiceExport()
+			t.awaitServiceUnexported()
+		})
+	})
+
+	When("a headless ServiceExport is deleted", func() {
+		BeforeEach(func() {
+			t.service.Spec.ClusterIP = corev1.ClusterIPNone
+		})
+
+		It("should delete the EndpointSlices before removing the finalizer", func() {
+			t.createEndpoints()
+			t.createServiceExport()
+			t.awaitHeadlessServiceImport("")
+			t.awaitEndpointSlice()
+
+			t.finalizeServiceExport()
+			t.awaitServiceUnexported()
+			t.awaitNoEndpointSlice(t.cluster1.localEndpointSliceClient)
+			t.awaitNoEndpointSlice(t.brokerEndpointSliceClient)
+			t.awaitNoEndpointSlice(t.cluster2.localEndpointSliceClient)
+		})
+	})
+
+	When("the broker is unreachable while a ServiceExport is deleted", func() {
+		JustBeforeEach(func() {
+			t.createServiceExport()
+			t.awaitServiceExported(t.service.Spec.ClusterIP, 0)
+			t.brokerServiceImportClient.PersistentFailOnDelete.Store("mock delete error")
+		})
+
+		It("should retain the finalizer until the ServiceImport is deleted from the broker", func() {
+			t.markServiceExportDeleted()
+			t.awaitNoServiceImport(t.cluster1.localServiceImportClient)
+			Consistently(func() []string {
+				return t.getServiceExport().Finalizers
+			}, 500*time.Millisecond).Should(ContainElement(serviceExportFinalizer))
+
+			t.brokerServiceImportClient.PersistentFailOnDelete.Store("")
+			t.finalizeServiceExport()
+			t.awaitServiceUnexported()
+		})
+
+		When("the cleanup retries are exhausted", func() {
+			var restoreMaxCleanupRequeues func()
+
+			BeforeEach(func() {
+				restoreMaxCleanupRequeues = controller.SetMaxCleanupRequeues(2)
+			})
+
+			AfterEach(func() {
+				restoreMaxCleanupRequeues()
+			})
+
+			It("should remove the finalizer and delete the ServiceImport once the broker is reachable", func() {
+				t.finalizeServiceExport()
+				_, err := t.brokerServiceImportClient.Get(t.service.Name+"-"+t.service.Namespace+"-"+clusterID1,
+					metav1.GetOptions{})
+				Expect(err).To(Succeed())
+
+				t.brokerServiceImportClient.PersistentFailOnDelete.Store("")
+				t.awaitServiceUnexported()
+			})
+		})
+	})
+})
+
+// newClaimingImport returns a ServiceImport exported by the given cluster for the given service, in the agent
+// namespace, claiming the clusterset VIPs in the given annotations as of the given export time.
+func newClaimingImport(name, clusterID string, exportTime time.Time, vips ...string) *mcsv1a1.ServiceImport {
+	return &mcsv1a1.ServiceImport{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name + "-" + serviceNamespace + "-" + clusterID,
+			Annotations: map[string]string{
+				lhconstants.OriginName:           name,
+				lhconstants.OriginNamespace:      serviceNamespace,
+				lhconstants.AnnotationExportTime: exportTime.UTC().Format(time.RFC3339),
+				lhconstants.AnnotationClusterIP:  "10.253.10.1",
+			},
+			Labels: map[string]string{
+				lhconstants.LabelSourceName:      name,
+				lhconstants.LabelSourceNamespace: serviceNamespace,
+				lhconstants.LabelSourceCluster:   clusterID,
+				federate.ClusterIDLabelKey:       clusterID,
+			},
+		},
+		Spec: mcsv1a1.ServiceImportSpec{
+			Type: mcsv1a1.ClusterSetIP,
+			IPs:  vips,
+		},
+	}
+}
+
+// derivedClustersetIP returns the clusterset VIP derived for the service from the CIDR while no other service claims
+// it.
+func derivedClustersetIP(cidr, name string) string {
+	pool, err := ipam.NewPool(cidr)
+	Expect(err).To(Succeed())
+
+	vip, err := pool.Allocate(serviceNamespace+"."+name, nil)
+	Expect(err).To(Succeed())
+
+	return vip
+}
+
+// otherIP returns the IP of the two allocatable IPs of a /30 or /126 CIDR which isn't the given one.
+func otherIP(ip, first, second string) string {
+	if ip == first {
+		return second
+	}
+
+	return first
+}
+
+var _ = Describe("Clusterset IP allocation", func() {
+	const cidr = "243.0.0.0/30"
+
+	var (
+		t      *testDriver
+		claims []*mcsv1a1.ServiceImport
+	)
+
+	BeforeEach(func() {
+		t = newTestDiver()
+		t.cluster1.agentSpec.ClustersetIPCIDR = cidr
+		t.serviceExport.CreationTimestamp = metav1.Now()
+		claims = nil
+	})
+
+	JustBeforeEach(func() {
+		t.justBeforeEach()
+		t.createService()
+
+		for _, claim := range claims {
+			test.CreateResource(t.brokerServiceImportClient, claim)
+			test.AwaitResource(t.cluster1.localServiceImportClient, claim.Name)
+		}
+
+		t.createServiceExport()
+	})
+
+	AfterEach(func() {
+		t.afterEach()
+	})
+
+	awaitClustersetIP := func() string {
+		serviceImport := awaitClustersetServiceImport(t.cluster2.localServiceImportClient, t.service)
+		Expect(serviceImport.Spec.IPs).To(HaveLen(1))
+
+		return serviceImport.Spec.IPs[0]
+	}
+
+	When("a ClusterSetIP service is exported", func() {
+		It("should allocate the clusterset IP derived from its name", func() {
+			Expect(awaitClustersetIP()).To(Equal(derivedClustersetIP(cidr, t.service.Name)))
+		})
+	})
+
+	When("another cluster's older export of the service claims a clusterset IP", func() {
+		var vip string
+
+		BeforeEach(func() {
+			vip = otherIP(derivedClustersetIP(cidr, t.service.Name), "243.0.0.1", "243.0.0.2")
+			claims = []*mcsv1a1.ServiceImport{newClaimingImport(t.service.Name, clusterID2,
+				t.serviceExport.CreationTimestamp.Add(-time.Hour), vip)}
+		})
+
+		It("should allocate the same clusterset IP", func() {
+			Expect(awaitClustersetIP()).To(Equal(vip))
+		})
+	})
+
+	When("another service's older export claims the clusterset IP derived from the service's name", func() {
+		BeforeEach(func() {
+			claims = []*mcsv1a1.ServiceImport{newClaimingImport("other", clusterID2,
+				t.serviceExport.CreationTimestamp.Add(-time.Hour),
+				derivedClustersetIP(cidr, t.service.Name))}
+		})
+
+		It("should allocate another clusterset IP", func() {
+			Expect(awaitClustersetIP()).To(Equal(otherIP(derivedClustersetIP(cidr, t.service.Name), "243.0.0.1",
+				"243.0.0.2")))
+		})
+	})
+
+	When("another service's older export claiming the same clusterset IP is imported after the service is exported", func() {
+		It("should reallocate the service's clusterset IP", func() {
+			vip := awaitClustersetIP()
+
+			test.CreateResource(t.brokerServiceImportClient, newClaimingImport("other", clusterID2,
+				t.serviceExport.CreationTimestamp.Add(-time.Hour), vip))
+
+			Eventually(awaitClustersetIP, 5).Should(Equal(otherIP(vip, "243.0.0.1", "243.0.0.2")))
+		})
+	})
+
+	When("another service's newer export claiming the same clusterset IP is imported after the service is exported", func() {
+		It("should keep the service's clusterset IP", func() {
+			vip := awaitClustersetIP()
+
+			test.CreateResource(t.brokerServiceImportClient, newClaimingImport("other", clusterID2,
+				t.serviceExport.CreationTimestamp.Add(time.Hour), vip))
+			test.AwaitResource(t.cluster1.localServiceImportClient, "other-"+serviceNamespace+"-"+clusterID2)
+
+			Consistently(awaitClustersetIP, 300*time.Millisecond).Should(Equal(vip))
+		})
+	})
+
+	When("the clusterset IP pool is exhausted", func() {
+		BeforeEach(func() {
+			exportTime := t.serviceExport.CreationTimestamp.Add(-time.Hour)
+			claims = []*mcsv1a1.ServiceImport{
+				newClaimingImport("svc1", clusterID2, exportTime, "243.0.0.1"),
+				newClaimingImport("svc2", clusterID2, exportTime, "243.0.0.2"),
+			}
+		})
+
+		It("should update the ServiceExport status and not export the service", func() {
+			t.awaitServiceExportStatus(0, newServiceExportCondition(mcsv1a1.ServiceExportValid,
+				corev1.ConditionFalse, "ClustersetIPPoolExhausted"))
+			t.awaitNoServiceImport(t.brokerServiceImportClient)
+		})
+	})
+})
+
+var _ = Describe("Dual-stack clusterset IP allocation", func() {
+	const (
+		cidr   = "243.0.0.0/30"
+		cidrV6 = "fd00:243::/126"
+	)
+
+	var (
+		t          *testDriver
+		ipFamilies []interface{}
+		claims     []*mcsv1a1.ServiceImport
+	)
+
+	BeforeEach(func() {
+		t = newTestDiver()
+		t.cluster1.agentSpec.ClustersetIPCIDR = cidr
+		t.cluster1.agentSpec.ClustersetIPv6CIDR = cidrV6
+		t.serviceExport.CreationTimestamp = metav1.Now()
+		ipFamilies = []interface{}{"IPv4", "IPv6"}
+		claims = nil
+	})
+
+	JustBeforeEach(func() {
+		t.justBeforeEach()
+
+		_, err := t.cluster1.localKubeClient.CoreV1().Services(t.service.Namespace).Create(t.service)
+		Expect(err).To(Succeed())
+
+		// The IP families are set on the unstructured Service as the vendored Service type doesn't have them.
+		obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(t.service)
+		Expect(err).To(Succeed())
+		Expect(unstructured.SetNestedSlice(obj, ipFamilies, "spec", "ipFamilies")).To(Succeed())
+
+		service := &unstructured.Unstructured{Object: obj}
+		service.SetAPIVersion("v1")
+		service.SetKind("Service")
+
+		_, err = t.dynamicServiceClient().Create(service, metav1.CreateOptions{})
+		Expect(err).To(Succeed())
+
+		for _, claim := range claims {
+			test.CreateResource(t.brokerServiceImportClient, claim)
+			test.AwaitResource(t.cluster1.localServiceImportClient, claim.Name)
+		}
+
+		t.createServiceExport()
+	})
+
+	AfterEach(func() {
+		t.afterEach()
+	})
+
+	When("a dual-stack ClusterSetIP service is exported", func() {
+		It("should allocate an IPv4 and an IPv6 clusterset IP", func() {
+			serviceImport := awaitClustersetServiceImport(t.cluster2.localServiceImportClient, t.service)
+			Expect(serviceImport.Spec.IPs).To(Equal([]string{derivedClustersetIP(cidr, t.service.Name),
+				derivedClustersetIP(cidrV6, t.service.Name)}))
+		})
+	})
+
+	When("a single-stack IPv4 ClusterSetIP service is exported", func() {
+		BeforeEach(func() {
+			ipFamilies = []interface{}{"IPv4"}
+		})
+
+		It("should only allocate an IPv4 clusterset IP", func() {
+			serviceImport := awaitClustersetServiceImport(t.cluster2.localServiceImportClient, t.service)
+			Expect(serviceImport.Spec.IPs).To(Equal([]string{derivedClustersetIP(cidr, t.service.Name)}))
+		})
+	})
+
+	When("a single-stack IPv6 ClusterSetIP service is exported", func() {
+		BeforeEach(func() {
+			ipFamilies = []interface{}{"IPv6"}
+		})
+
+		It("should only allocate an IPv6 clusterset IP", func() {
+			serviceImport := awaitClustersetServiceImport(t.cluster2.localServiceImportClient, t.service)
+			Expect(serviceImport.Spec.IPs).To(Equal([]string{derivedClustersetIP(cidrV6, t.service.Name)}))
+		})
+	})
+
+	When("the IPv6 clusterset IP pool is exhausted", func() {
+		BeforeEach(func() {
+			exportTime := t.serviceExport.CreationTimestamp.Add(-time.Hour)
+			claims = []*mcsv1a1.ServiceImport{
+				newClaimingImport("svc1", clusterID2, exportTime, "fd00:243::1"),
+				newClaimingImport("svc2", clusterID2, exportTime, "fd00:243::2"),
+			}
+		})
+
+		It("should update the ServiceExport status and not export the service", func() {
+			t.awaitServiceExportStatus(0, newServiceExportCondition(mcsv1a1.ServiceExportValid,
+				corev1.ConditionFalse, "ClustersetIPPoolExhausted"))
+			t.awaitNoServiceImport(t.brokerServiceImportClient)
+		})
+	})
+})
+
+var _ = Describe("Clusterset UID", func() {
+	var (
+		t      *testDriver
+		stopCh chan struct{}
+	)
+
+	BeforeEach(func() {
+		t = newTestDiver()
+		stopCh = make(chan struct{})
+	})
+
+	JustBeforeEach(func() {
+		Expect(t.cluster1.newAgent(*t.syncerConfig).Start(stopCh)).To(Succeed())
+		t.cluster2.start(t, *t.syncerConfig)
+		t.createService()
+		t.createServiceExport()
+	})
+
+	AfterEach(func() {
+		t.afterEach()
+	})
+
+	awaitUID := func() string {
+		serviceImport := awaitServiceImport(t.cluster1.localServiceImportClient, t.service, mcsv1a1.ClusterSetIP,
+			t.service.Spec.ClusterIP)
+		uid := serviceImport.Annotations[lhconstants.AnnotationClustersetUID]
+		Expect(uid).ToNot(BeEmpty())
+
+		return uid
+	}
+
+	When("a service is exported", func() {
+		It("should set the same clusterset UID on the local and broker ServiceImports", func() {
+			uid := awaitUID()
+
+			brokerImport := awaitServiceImport(t.brokerServiceImportClient, t.service, mcsv1a1.ClusterSetIP,
+				t.service.Spec.ClusterIP)
+			Expect(brokerImport.Annotations[lhconstants.AnnotationClustersetUID]).To(Equal(uid))
+
+			remoteImport := awaitServiceImport(t.cluster2.localServiceImportClient, t.service, mcsv1a1.ClusterSetIP,
+				t.service.Spec.ClusterIP)
+			Expect(remoteImport.Annotations[lhconstants.AnnotationClustersetUID]).To(Equal(uid))
+		})
+	})
+
+	When("the agent is restarted and the ServiceImport is regenerated", func() {
+		It("should keep the clusterset UID", func() {
+			uid := awaitUID()
+
+			close(stopCh)
+			Expect(t.cluster1.localServiceImportClient.Delete(t.service.Name+"-"+t.service.Namespace+"-"+clusterID1,
+				nil)).To(Succeed())
+
+			t.cluster1.start(t, *t.syncerConfig)
+
+			Expect(awaitUID()).To(Equal(uid))
+		})
+	})
+
+	When("the service is unexported and re-exported", func() {
+		It("should keep the clusterset UID", func() {
+			uid := awaitUID()
+
+			t.deleteServiceExport()
+			t.awaitServiceUnexported()
+
+			t.createServiceExport()
+
+			Expect(awaitUID()).To(Equal(uid))
+		})
+	})
+
+	When("different services are exported", func() {
+		It("should give them different clusterset UIDs", func() {
+			uid := awaitUID()
+
+			t.service.Name = "other"
+			t.createService()
+			t.serviceExport.Name = t.service.Name
+			t.createServiceExport()
+
+			Expect(awaitUID()).ToNot(Equal(uid))
+		})
+	})
+})
+
+var _ = Describe("Leader election", func() {
+	var (
+		t           *testDriver
+		stopLeader  chan struct{}
+		leaderDone  chan error
+		standbyDone chan error
+	)
+
+	BeforeEach(func() {
+		t = newTestDiver()
+		t.cluster1.agentSpec.ClustersetIPCIDR = "243.0.0.0/29"
+		t.cluster1.agentSpec.LeaderElectionLeaseDuration = time.Second
+		t.cluster1.agentSpec.LeaderElectionRenewDeadline = 500 * time.Millisecond
+		t.cluster1.agentSpec.LeaderElectionRetryPeriod = 100 * time.Millisecond
+	})
+
+	leaseHolder := func() string {
+		lease, err := t.cluster1.localKubeClient.CoordinationV1().Leases(test.LocalNamespace).Get("lighthouse-agent",
+			metav1.GetOptions{})
+		if err != nil || lease.Spec.HolderIdentity == nil {
+			return ""
+		}
+
+		return *lease.Spec.HolderIdentity
+	}
+
+	runLeaderElected := func(agent *controller.Controller, stopCh <-chan struct{}, identity string) chan error {
+		done := make(chan error, 1)
+
+		go func() {
+			done <- agent.RunLeaderElected(stopCh, identity)
+		}()
+
+		return done
+	}
+
+	// The leader starts the agent asynchronously so, unlike with Start, the ServiceExport may be processed before the
+	// Service is, which adds conditions to the status - only the resulting ServiceImports are checked.
+	awaitExported := func() {
+		awaitClustersetServiceImport(t.cluster1.localServiceImportClient, t.service)
+		awaitClustersetServiceImport(t.brokerServiceImportClient, t.service)
+		awaitClustersetServiceImport(t.cluster2.localServiceImportClient, t.service)
+	}
+
+	JustBeforeEach(func() {
+		t.cluster2.start(t, *t.syncerConfig)
+
+		leader := t.cluster1.newAgent(*t.syncerConfig)
+		standby := t.cluster1.newAgent(*t.syncerConfig)
+
+		stopLeader = make(chan struct{})
+		leaderDone = runLeaderElected(leader, stopLeader, "replica-1")
+		Eventually(leaseHolder, 5).Should(Equal("replica-1"))
+
+		standbyDone = runLeaderElected(standby, t.stopCh, "replica-2")
+
+		t.createService()
+		t.createServiceExport()
+	})
+
+	AfterEach(func() {
+		t.afterEach()
+		Eventually(standbyDone, 5).Should(Receive(Succeed()))
+	})
+
+	When("a service is exported", func() {
+		It("should be exported by the leader", func() {
+			awaitExported()
+			Expect(leaseHolder()).To(Equal("replica-1"))
+
+			close(stopLeader)
+			Eventually(leaderDone, 5).Should(Receive(Succeed()))
+		})
+	})
+
+	When("the leader loses the lease", func() {
+		var stopOtherHolder chan struct{}
+
+		JustBeforeEach(func() {
+			awaitExported()
+
+			// Another replica takes over the lease and keeps renewing it, as if the leader failed to renew it in time.
+			leases := t.cluster1.localKubeClient.CoordinationV1().Leases(test.LocalNamespace)
+			holder := "replica-3"
+			renew := func() {
+				lease, err := leases.Get("lighthouse-agent", metav1.GetOptions{})
+				Expect(err).To(Succeed())
+
+				renewTime := metav1.NewMicroTime(time.Now())
+				lease.Spec.HolderIdentity = &holder
+				lease.Spec.RenewTime = &renewTime
+
+				_, err = leases.Update(lease)
+				Expect(err).To(Succeed())
+			}
+
+			renew()
+
+			stopOtherHolder = make(chan struct{})
+
+			go func() {
+				defer GinkgoRecover()
+
+				for {
+					select {
+					case <-stopOtherHolder:
+						return
+					case <-time.After(t.cluster1.agentSpec.LeaderElectionRetryPeriod):
+						renew()
+					}
+				}
+			}()
+
+			// The leader steps down once it fails to renew the lease within the renew deadline.
+			time.Sleep(2 * t.cluster1.agentSpec.LeaderElectionRenewDeadline)
+		})
+
+		It("should step down without exiting and take over again once the lease is free", func() {
+			t.service.Name = "other"
+			t.createService()
+			t.serviceExport.Name = t.service.Name
+			t.createServiceExport()
+
+			Consistently(func() bool {
+				_, err := t.brokerServiceImportClient.Get(t.service.Name+"-"+t.service.Namespace+"-"+clusterID1,
+					metav1.GetOptions{})
+				return apierrors.IsNotFound(err)
+			}, 2).Should(BeTrue())
+			Expect(leaderDone).ToNot(Receive())
+
+			close(stopOtherHolder)
+
+			Eventually(leaseHolder, 5).Should(BeElementOf("replica-1", "replica-2"))
+			awaitClustersetServiceImport(t.brokerServiceImportClient, t.service)
+
+			close(stopLeader)
+			Eventually(leaderDone, 5).Should(Receive(Succeed()))
+		})
+	})
+
+	When("the leader is stopped", func() {
+		exportService := func(name, clusterIP string) {
+			service := t.service.DeepCopy()
+			service.Name = name
+			service.Spec.ClusterIP = clusterIP
+
+			_, err := t.cluster1.localKubeClient.CoreV1().Services(service.Namespace).Create(service)
+			Expect(err).To(Succeed())
+			test.CreateResource(t.dynamicServiceClient(), service)
+			test.CreateResource(t.cluster1.localServiceExportClient, &mcsv1a1.ServiceExport{
+				ObjectMeta: metav1.ObjectMeta{Name: service.Name, Namespace: service.Namespace},
+			})
+		}
+
+		clustersetIP := func(name string) func() string {
+			return func() string {
+				obj, err := t.brokerServiceImportClient.Get(name+"-"+serviceNamespace+"-"+clusterID1, metav1.GetOptions{})
+				if err != nil {
+					return ""
+				}
+
+				ips, _, _ := unstructured.NestedStringSlice(obj.Object, "spec", "ips")
+				if len(ips) == 0 {
+					return ""
+				}
+
+				return ips[0]
+			}
+		}
+
+		var backendIP string
+
+		JustBeforeEach(func() {
+			awaitExported()
+			exportService("backend", "10.253.9.2")
+			Eventually(clustersetIP("backend"), 5).ShouldNot(BeEmpty())
+			backendIP = clustersetIP("backend")()
+
+			close(stopLeader)
+			Eventually(leaderDone, 5).Should(Receive(Succeed()))
+			Eventually(leaseHolder, 5).Should(Equal("replica-2"))
+		})
+
+		It("should hand over to the standby without unexporting the services or reallocating their clusterset IPs", func() {
+			exportService("frontend", "10.253.9.3")
+
+			Eventually(clustersetIP("frontend"), 5).ShouldNot(BeEmpty())
+			Expect(clustersetIP("frontend")()).ToNot(Equal(backendIP))
+			Expect(clustersetIP("backend")()).To(Equal(backendIP))
+			Expect(clustersetIP(t.service.Name)()).ToNot(BeEmpty())
+		})
+	})
+})
+
+var _ = Describe("Service export type conflicts", func() {
+	var (
+		t           *testDriver
+		otherImport *mcsv1a1.ServiceImport
+	)
+
+	BeforeEach(func() {
+		t = newTestDiver()
+		t.serviceExport.CreationTimestamp = metav1.Now()
+
+		otherImport = &mcsv1a1.ServiceImport{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: t.service.Name + "-" + t.service.Namespace + "-" + clusterID2,
+				Annotations: map[string]string{
+					lhconstants.OriginName:      t.service.Name,
+					lhconstants.OriginNamespace: t.service.Namespace,
+				},
+				Labels: map[string]string{
+					lhconstants.LabelSourceName:      t.service.Name,
+					lhconstants.LabelSourceNamespace: t.service.Namespace,
+					lhconstants.LabelSourceCluster:   clusterID2,
+					federate.ClusterIDLabelKey:       clusterID2,
+				},
+			},
+			Spec: mcsv1a1.ServiceImportSpec{
+				Type: mcsv1a1.Headless,
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		t.justBeforeEach()
+		t.createService()
+
+		test.CreateResource(t.brokerServiceImportClient, otherImport)
+		test.AwaitResource(t.cluster1.localServiceImportClient, otherImport.Name)
+
+		t.createServiceExport()
+	})
+
+	AfterEach(func() {
+		t.afterEach()
+	})
+
+	When("an older export from another cluster has a different type", func() {
+		BeforeEach(func() {
+			otherImport.Annotations[lhconstants.AnnotationExportTime] =
+				t.serviceExport.CreationTimestamp.Add(-time.Hour).UTC().Format(time.RFC3339)
+		})
+
+		It("should set the Conflict condition and still sync the ServiceImport", func() {
+			t.awaitBrokerServiceImport(mcsv1a1.ClusterSetIP, t.service.Spec.ClusterIP)
+			t.awaitServiceExportStatus(0, newServiceExportCondition(mcsv1a1.ServiceExportConflict,
+				corev1.ConditionTrue, "ConflictingType"), newServiceExportCondition(mcsv1a1.ServiceExportValid,
+				corev1.ConditionFalse, "AwaitingSync"), newServiceExportCondition(mcsv1a1.ServiceExportValid,
+				corev1.ConditionTrue, ""))
+		})
+	})
+
+	When("a newer export from another cluster has a different type", func() {
+		BeforeEach(func() {
+			otherImport.Annotations[lhconstants.AnnotationExportTime] =
+				t.serviceExport.CreationTimestamp.Add(time.Hour).UTC().Format(time.RFC3339)
+		})
+
+		It("should not set the Conflict condition", func() {
+			t.awaitServiceExported(t.service.Spec.ClusterIP, 0)
+		})
+	})
+
+	When("an older export from another cluster has the same type", func() {
+		BeforeEach(func() {
+			otherImport.Spec.Type = mcsv1a1.ClusterSetIP
+			otherImport.Spec.IPs = []string{"10.253.10.1"}
+			otherImport.Annotations[lhconstants.AnnotationExportTime] =
+				t.serviceExport.CreationTimestamp.Add(-time.Hour).UTC().Format(time.RFC3339)
+		})
+
+		It("should not set the Conflict condition", func() {
+			t.awaitServiceExported(t.service.Spec.ClusterIP, 0)
+		})
+	})
+
+	When("the ServiceExport has a type conflict that no longer applies", func() {
+		BeforeEach(func() {
+			otherImport.Spec.Type = mcsv1a1.ClusterSetIP
+			otherImport.Spec.IPs = []string{"10.253.10.1"}
+			t.serviceExport.Status.Conditions = []mcsv1a1.ServiceExportCondition{
+				*newServiceExportCondition(mcsv1a1.ServiceExportConflict, corev1.ConditionTrue, "ConflictingType"),
+			}
+		})
+
+		It("should clear the Conflict condition", func() {
+			t.awaitServiceExportCondition(newServiceExportCondition(mcsv1a1.ServiceExportConflict,
+				corev1.ConditionFalse, ""))
+		})
+	})
+})
+
+var _ = Describe("ServiceImport name collisions", func() {
+	var t *testDriver
+
+	BeforeEach(func() {
+		t = newTestDiver()
+	})
+
+	JustBeforeEach(func() {
+		t.justBeforeEach()
+		t.createService()
+	})
+
+	AfterEach(func() {
+		t.afterEach()
+	})
+
+	// newOtherImport returns a ServiceImport exported by the given cluster for the given service, named as the agent
+	// names them.
+	newOtherImport := func(name, namespace, clusterID string) *mcsv1a1.ServiceImport {
+		return &mcsv1a1.ServiceImport{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name + "-" + namespace + "-" + clusterID,
+				Annotations: map[string]string{
+					lhconstants.OriginName:      name,
+					lhconstants.OriginNamespace: namespace,
+				},
+				Labels: map[string]string{
+					lhconstants.LabelSourceName:      name,
+					lhconstants.LabelSourceNamespace: namespace,
+					lhconstants.LabelSourceCluster:   clusterID,
+					federate.ClusterIDLabelKey:       clusterID,
+				},
+			},
+			Spec: mcsv1a1.ServiceImportSpec{
+				Type: mcsv1a1.ClusterSetIP,
+				IPs:  []string{"10.253.10.1"},
+			},
+		}
+	}
+
+	// The name of the service's ServiceImport, "nginx-service-ns-east", is also that of service "nginx" in namespace
+	// "service" exported by cluster "ns-east".
+	newCollidingImport := func() *mcsv1a1.ServiceImport {
+		Expect(t.service.Namespace).To(Equal("service-ns"))
+		return newOtherImport(t.service.Name, "service", "ns-"+clusterID1)
+	}
+
+	awaitOriginNamespace := func(client dynamic.ResourceInterface, name, namespace string) {
+		Eventually(func() string {
+			obj, err := client.Get(name, metav1.GetOptions{})
+			Expect(err).To(Succeed())
+
+			return obj.GetAnnotations()[lhconstants.OriginNamespace]
+		}, 5).Should(Equal(namespace))
+
+		Consistently(func() string {
+			obj, err := client.Get(name, metav1.GetOptions{})
+			Expect(err).To(Succeed())
+
+			return obj.GetAnnotations()[lhconstants.OriginNamespace]
+		}, 0.3).Should(Equal(namespace))
+	}
+
+	When("a ServiceImport from another cluster already has the same name", func() {
+		It("should set the Conflict condition and not overwrite the other ServiceImport", func() {
+			colliding := newCollidingImport()
+			test.CreateResource(t.brokerServiceImportClient, colliding)
+			test.AwaitResource(t.cluster1.localServiceImportClient, colliding.Name)
+
+			t.createServiceExport()
+			t.awaitServiceExportCondition(newServiceExportCondition(mcsv1a1.ServiceExportConflict,
+				corev1.ConditionTrue, "NameCollision"))
+
+			awaitOriginNamespace(t.brokerServiceImportClient, colliding.Name, "service")
+			awaitOriginNamespace(t.cluster1.localServiceImportClient, colliding.Name, "service")
+		})
+
+		It("should export the service and clear the Conflict condition once the other ServiceImport is removed", func() {
+			colliding := newCollidingImport()
+			test.CreateResource(t.brokerServiceImportClient, colliding)
+			test.AwaitResource(t.cluster1.localServiceImportClient, colliding.Name)
+
+			t.createServiceExport()
+			t.awaitServiceExportCondition(newServiceExportCondition(mcsv1a1.ServiceExportConflict,
+				corev1.ConditionTrue, "NameCollision"))
+
+			Expect(t.brokerServiceImportClient.Delete(colliding.Name, nil)).To(Succeed())
+
+			t.awaitBrokerServiceImport(mcsv1a1.ClusterSetIP, t.service.Spec.ClusterIP)
+			t.awaitServiceExportCondition(newServiceExportCondition(mcsv1a1.ServiceExportConflict,
+				corev1.ConditionFalse, ""))
+		})
+	})
+
+	When("a ServiceImport with the same name is synced from the broker after the service is exported", func() {
+		It("should set the Conflict condition and not overwrite the local ServiceImport", func() {
+			t.createServiceExport()
+			t.awaitServiceExported(t.service.Spec.ClusterIP, 0)
+
+			colliding := newCollidingImport()
+			test.UpdateResource(t.brokerServiceImportClient, colliding)
+
+			t.awaitServiceExportCondition(newServiceExportCondition(mcsv1a1.ServiceExportConflict,
+				corev1.ConditionTrue, "CollidingExport"))
+			awaitOriginNamespace(t.cluster1.localServiceImportClient, colliding.Name, t.service.Namespace)
+		})
+
+		It("should clear the Conflict condition once the other ServiceImport is removed", func() {
+			t.createServiceExport()
+			t.awaitServiceExported(t.service.Spec.ClusterIP, 0)
+
+			colliding := newCollidingImport()
+			test.UpdateResource(t.brokerServiceImportClient, colliding)
+			t.awaitServiceExportCondition(newServiceExportCondition(mcsv1a1.ServiceExportConflict,
+				corev1.ConditionTrue, "CollidingExport"))
+
+			Expect(t.brokerServiceImportClient.Delete(colliding.Name, nil)).To(Succeed())
+
+			t.awaitServiceExportCondition(newServiceExportCondition(mcsv1a1.ServiceExportConflict,
+				corev1.ConditionFalse, ""))
+		})
+	})
+
+	When("a ServiceImport for the same service is exported by another cluster", func() {
+		It("should sync the ServiceImport without a collision", func() {
+			other := newOtherImport(t.service.Name, t.service.Namespace, clusterID2)
+			test.CreateResource(t.brokerServiceImportClient, other)
+			test.AwaitResource(t.cluster1.localServiceImportClient, other.Name)
+
+			t.createServiceExport()
+			t.awaitServiceExported(t.service.Spec.ClusterIP, 0)
+			awaitOriginNamespace(t.cluster1.localServiceImportClient, other.Name, t.service.Namespace)
+		})
+	})
+})
+
+var _ = Describe("Automatic export", func() {
+	var t *testDriver
+
+	BeforeEach(func() {
+		t = newTestDiver()
+		t.cluster1.agentSpec.AutoExport = true
+	})
+
+	JustBeforeEach(func() {
+		t.justBeforeEach()
+	})
+
+	AfterEach(func() {
+		t.afterEach()
+	})
+
+	setExportLabel := func(labeled bool) {
+		t.service.Labels = map[string]string{}
+		if labeled {
+			t.service.Labels[lhconstants.LabelExport] = "true"
+		}
+	}
+
+	When("a Service is labeled for export", func() {
+		It("should create a ServiceExport and remove it once the label is removed", func() {
+			setExportLabel(true)
+			t.createService()
+
+			obj := test.AwaitResource(t.cluster1.localServiceExportClient, t.service.Name)
+			Expect(obj.GetAnnotations()).To(HaveKeyWithValue(lhconstants.AnnotationAutoExported, "true"))
+			t.awaitServiceExported(t.service.Spec.ClusterIP, 0)
+
+			setExportLabel(false)
+			test.UpdateResource(t.dynamicServiceClient(), t.service)
+
+			test.AwaitNoResource(t.cluster1.localServiceExportClient, t.service.Name)
+			t.awaitServiceUnexported()
+		})
+	})
+
+	When("a Service is deleted while labeled for export", func() {
+		It("should remove the ServiceExport", func() {
+			setExportLabel(true)
+			t.createService()
+			t.awaitServiceExported(t.service.Spec.ClusterIP, 0)
+
+			t.deleteService()
+			test.AwaitNoResource(t.cluster1.localServiceExportClient, t.service.Name)
+		})
+	})
+
+	When("a Service that isn't labeled for export is exported explicitly", func() {
+		It("should not remove its ServiceExport", func() {
+			t.createService()
+			t.createServiceExport()
+			t.awaitServiceExported(t.service.Spec.ClusterIP, 0)
+
+			t.service.Labels = map[string]string{"app": "nginx"}
+			test.UpdateResource(t.dynamicServiceClient(), t.service)
+
+			Consistently(func() bool {
+				_, err := t.cluster1.localServiceExportClient.Get(t.service.Name, metav1.GetOptions{})
+				return err == nil
+			}, 0.5).Should(BeTrue())
+		})
+	})
+
+	When("automatic export is disabled", func() {
+		BeforeEach(func() {
+			t.cluster1.agentSpec.AutoExport = false
+		})
+
+		It("should not create a ServiceExport for a labeled Service", func() {
+			setExportLabel(true)
+			t.createService()
+
+			Consistently(func() bool {
+				_, err := t.cluster1.localServiceExportClient.Get(t.service.Name, metav1.GetOptions{})
+				return apierrors.IsNotFound(err)
+			}, 0.5).Should(BeTrue())
+		})
+	})
+})
+
+var _ = Describe("No-export mode", func() {
+	var t *testDriver
+
+	BeforeEach(func() {
+		t = newTestDiver()
+		t.cluster1.agentSpec.NoExport = true
+	})
+
+	JustBeforeEach(func() {
+		t.justBeforeEach()
+	})
+
+	AfterEach(func() {
+		t.afterEach()
+	})
+
+	When("a ServiceExport is created", func() {
+		It("should ignore it", func() {
+			t.createService()
+			t.createServiceExport()
+
+			name := t.service.Name + "-" + t.service.Namespace + "-" + clusterID1
+			t.cluster1.localServiceImportClient.VerifyNoCreate(name)
+			t.brokerServiceImportClient.VerifyNoCreate(name)
+
+			serviceExport := t.getServiceExport()
+			Expect(serviceExport.Status.Conditions).To(BeEmpty())
+			Expect(serviceExport.Finalizers).To(BeEmpty())
+		})
+	})
+
+	When("another cluster exports a service", func() {
+		It("should import it", func() {
+			serviceImport := &mcsv1a1.ServiceImport{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: t.service.Name + "-" + t.service.Namespace + "-" + clusterID2,
+					Annotations: map[string]string{
+						lhconstants.OriginName:      t.service.Name,
+						lhconstants.OriginNamespace: t.service.Namespace,
+					},
+					Labels: map[string]string{
+						lhconstants.LabelSourceName:      t.service.Name,
+						lhconstants.LabelSourceNamespace: t.service.Namespace,
+						lhconstants.LabelSourceCluster:   clusterID2,
+						federate.ClusterIDLabelKey:       clusterID2,
+					},
+				},
+				Spec: mcsv1a1.ServiceImportSpec{
+					Type: mcsv1a1.ClusterSetIP,
+					IPs:  []string{"10.253.9.2"},
+				},
+			}
+
+			test.CreateResource(t.brokerServiceImportClient, serviceImport)
+			test.AwaitResource(t.cluster1.localServiceImportClient, serviceImport.Name)
+		})
+	})
+
+	When("automatic export is also enabled", func() {
+		It("should fail to create the controller", func() {
+			syncerConfig := *t.syncerConfig
+			syncerConfig.LocalClient = t.cluster2.localDynClient
+			t.cluster2.agentSpec.NoExport = true
+			t.cluster2.agentSpec.AutoExport = true
+
+			_, err := controller.New(&t.cluster2.agentSpec, syncerConfig, t.cluster2.localKubeClient)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
+
+var _ = Describe("Namespace termination", func() {
+	var t *testDriver
+
+	BeforeEach(func() {
+		t = newTestDiver()
+	})
+
+	JustBeforeEach(func() {
+		t.justBeforeEach()
+		t.createService()
+	})
+
+	AfterEach(func() {
+		t.afterEach()
+	})
+
+	When("the namespace of an exported service starts terminating", func() {
+		JustBeforeEach(func() {
+			t.createServiceExport()
+			t.awaitServiceExported(t.service.Spec.ClusterIP, 0)
+			t.terminateNamespace()
+		})
+
+		It("should withdraw the ServiceImports without waiting for its resources to be deleted", func() {
+			t.awaitServiceUnexported()
+			t.awaitServiceExportCondition(newServiceExportCondition(mcsv1a1.ServiceExportValid, corev1.ConditionFalse,
+				"NamespaceTerminating"))
+			Eventually(func() []string {
+				return t.getServiceExport().Finalizers
+			}).ShouldNot(ContainElement(serviceExportFinalizer))
+		})
+
+		It("should withdraw the ServiceImports and remove the finalizer once its resources are deleted", func() {
+			t.deleteService()
+			t.awaitServiceUnexported()
+			t.awaitServiceExportCondition(newServiceExportCondition(mcsv1a1.ServiceExportValid, corev1.ConditionFalse,
+				"NamespaceTerminating"))
+			Eventually(func() []string {
+				return t.getServiceExport().Finalizers
+			}).ShouldNot(ContainElement(serviceExportFinalizer))
+			t.awaitNotServiceExportStatus(newServiceExportCondition(mcsv1a1.ServiceExportValid, corev1.ConditionFalse,
+				"ServiceUnavailable"))
+		})
+
+		When("the broker is unreachable", func() {
+			JustBeforeEach(func() {
+				t.brokerServiceImportClient.PersistentFailOnDelete.Store("mock delete error")
+			})
+
+			It("should remove the finalizer without waiting for the broker", func() {
+				t.finalizeServiceExport()
+				t.awaitNoServiceImport(t.cluster1.localServiceImportClient)
+			})
+		})
+	})
+
+	When("a ServiceExport is created in a terminating namespace", func() {
+		JustBeforeEach(func() {
+			t.terminateNamespace()
+			t.createServiceExport()
+		})
 
+		It("should not export the service nor add the finalizer", func() {
+			t.awaitServiceExportCondition(newServiceExportCondition(mcsv1a1.ServiceExportValid, corev1.ConditionFalse,
+				"NamespaceTerminating"))
+			Expect(t.getServiceExport().Finalizers).ToNot(ContainElement(serviceExportFinalizer))
 			t.awaitNoServiceImport(t.brokerServiceImportClient)
+			t.awaitNoServiceImport(t.cluster1.localServiceImportClient)
 		})
 	})
 })
@@ -287,6 +2463,201 @@ var _ = Describe("Headless service syncing", func() {
 		})
 	})
 
+	When("the Endpoints have a subset for each of several workloads with different ports", func() {
+		BeforeEach(func() {
+			t.endpoints.Subsets = append(t.endpoints.Subsets, corev1.EndpointSubset{
+				Addresses: []corev1.EndpointAddress{{IP: "192.168.6.1"}, {IP: "192.168.6.2"}},
+				Ports:     []corev1.EndpointPort{{Name: "port-2", Protocol: corev1.ProtocolTCP, Port: 8080}},
+			})
+		})
+
+		It("should sync the addresses and ports of all the subsets to the EndpointSlice", func() {
+			t.createEndpoints()
+			t.createServiceExport()
+			t.awaitHeadlessServiceImport("")
+
+			name := t.endpoints.Name + "-" + clusterID1
+			test.AwaitResource(t.brokerEndpointSliceClient, name)
+			test.AwaitResource(t.cluster1.localEndpointSliceClient, name)
+			test.AwaitResource(t.cluster2.localEndpointSliceClient, name)
+			t.awaitUpdatedEndpointSlice(append(t.endpointIPs(), "10.253.6.1", "192.168.6.1", "192.168.6.2"))
+
+			obj, err := t.brokerEndpointSliceClient.Get(name, metav1.GetOptions{})
+			Expect(err).To(Succeed())
+
+			endpointSlice := &discovery.EndpointSlice{}
+			Expect(scheme.Scheme.Convert(obj, endpointSlice, nil)).To(Succeed())
+
+			ports := []string{}
+			for _, port := range endpointSlice.Ports {
+				ports = append(ports, *port.Name)
+			}
+
+			Expect(ports).To(ConsistOf("port-1", "port-2"))
+		})
+	})
+
+	When("the Endpoints include host-networked pods", func() {
+		BeforeEach(func() {
+			t.endpoints.Subsets[0].Addresses = append(t.endpoints.Subsets[0].Addresses, corev1.EndpointAddress{
+				IP:        "172.17.0.5",
+				TargetRef: &corev1.ObjectReference{Kind: "Pod", Name: "host-pod", Namespace: t.service.Namespace},
+			})
+		})
+
+		It("should exclude their addresses from the EndpointSlice and update the ServiceExport status", func() {
+			_, err := t.cluster1.localKubeClient.CoreV1().Pods(t.service.Namespace).Create(&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "host-pod", Namespace: t.service.Namespace, Labels: t.service.Spec.Selector},
+				Spec:       corev1.PodSpec{HostNetwork: true},
+			})
+			Expect(err).To(Succeed())
+
+			t.createEndpoints()
+			t.createServiceExport()
+			t.awaitHeadlessServiceImport("")
+
+			name := t.endpoints.Name + "-" + clusterID1
+			test.AwaitResource(t.brokerEndpointSliceClient, name)
+			test.AwaitResource(t.cluster1.localEndpointSliceClient, name)
+			test.AwaitResource(t.cluster2.localEndpointSliceClient, name)
+			t.awaitUpdatedEndpointSlice([]string{"192.168.5.1", "192.168.5.2", "10.253.6.1"})
+
+			t.awaitServiceExportCondition(newServiceExportCondition("HostNetworkEndpoints", corev1.ConditionTrue,
+				"HostNetworkEndpoints"))
+		})
+	})
+
+	When("the ServiceExport has an endpoint selector", func() {
+		BeforeEach(func() {
+			t.serviceExport.SetAnnotations(map[string]string{lhconstants.AnnotationEndpointSelector: "tier=public"})
+			t.endpoints.Subsets[0].Addresses[0].TargetRef = &corev1.ObjectReference{Kind: "Pod", Name: "canary-pod",
+				Namespace: t.service.Namespace}
+			t.endpoints.Subsets[0].Addresses[1].TargetRef = &corev1.ObjectReference{Kind: "Pod", Name: "stable-pod",
+				Namespace: t.service.Namespace}
+		})
+
+		It("should only export the addresses of the matching pods", func() {
+			t.createSelectedPods(map[string]string{"canary-pod": "public", "stable-pod": "internal"})
+			t.createEndpoints()
+			t.createServiceExport()
+			t.awaitHeadlessServiceImport("")
+
+			name := t.endpoints.Name + "-" + clusterID1
+			test.AwaitResource(t.brokerEndpointSliceClient, name)
+			test.AwaitResource(t.cluster1.localEndpointSliceClient, name)
+			test.AwaitResource(t.cluster2.localEndpointSliceClient, name)
+			// The not ready address doesn't belong to a pod so it's exported as it is.
+			t.awaitUpdatedEndpointSlice([]string{"192.168.5.1", "10.253.6.1"})
+		})
+
+		It("should re-export the addresses when the labels of the pods change", func() {
+			t.createSelectedPods(map[string]string{"canary-pod": "public", "stable-pod": "internal"})
+			t.createEndpoints()
+			t.createServiceExport()
+			t.awaitHeadlessServiceImport("")
+			test.AwaitResource(t.cluster1.localEndpointSliceClient, t.endpoints.Name+"-"+clusterID1)
+			t.awaitUpdatedEndpointSlice([]string{"192.168.5.1", "10.253.6.1"})
+
+			pod, err := t.cluster1.localKubeClient.CoreV1().Pods(t.service.Namespace).Get("stable-pod", metav1.GetOptions{})
+			Expect(err).To(Succeed())
+
+			pod.Labels["tier"] = "public"
+			_, err = t.cluster1.localKubeClient.CoreV1().Pods(t.service.Namespace).Update(pod)
+			Expect(err).To(Succeed())
+
+			t.awaitUpdatedEndpointSlice([]string{"192.168.5.1", "192.168.5.2", "10.253.6.1"})
+		})
+
+		It("should re-export the addresses when it changes", func() {
+			t.createSelectedPods(map[string]string{"canary-pod": "public", "stable-pod": "internal"})
+			t.createEndpoints()
+			t.createServiceExport()
+			t.awaitHeadlessServiceImport("")
+			test.AwaitResource(t.cluster1.localEndpointSliceClient, t.endpoints.Name+"-"+clusterID1)
+			t.awaitUpdatedEndpointSlice([]string{"192.168.5.1", "10.253.6.1"})
+
+			serviceExport := t.getServiceExport()
+			serviceExport.SetAnnotations(map[string]string{lhconstants.AnnotationEndpointSelector: "tier=internal"})
+			test.UpdateResource(t.cluster1.localServiceExportClient, serviceExport)
+
+			t.awaitUpdatedEndpointSlice([]string{"192.168.5.2", "10.253.6.1"})
+		})
+
+		When("it's invalid", func() {
+			BeforeEach(func() {
+				t.serviceExport.SetAnnotations(map[string]string{lhconstants.AnnotationEndpointSelector: "tier in public"})
+			})
+
+			It("should update the ServiceExport status and not sync a ServiceImport", func() {
+				t.createEndpoints()
+				t.createServiceExport()
+
+				t.awaitServiceExportCondition(newServiceExportCondition(mcsv1a1.ServiceExportValid,
+					corev1.ConditionFalse, "InvalidEndpointSelector"))
+				t.awaitNoServiceImport(t.brokerServiceImportClient)
+			})
+		})
+	})
+
+	When("the Service has no selector", func() {
+		BeforeEach(func() {
+			t.service.Spec.Selector = nil
+		})
+
+		When("its EndpointSlices are maintained manually", func() {
+			It("should sync the EndpointSlice and update the ServiceExport status", func() {
+				t.createManualEndpointSlice()
+				t.createServiceExport()
+
+				t.awaitHeadlessServiceImport("")
+				t.awaitEndpointSlice()
+				t.awaitServiceExportCondition(newServiceExportCondition("NoEndpoints", corev1.ConditionFalse,
+					"ManualEndpoints"))
+			})
+
+			Context("and the ServiceExport has an endpoint selector", func() {
+				BeforeEach(func() {
+					t.serviceExport.SetAnnotations(map[string]string{lhconstants.AnnotationEndpointSelector: "tier=public"})
+				})
+
+				It("should sync all the endpoints as they don't belong to pods", func() {
+					t.createManualEndpointSlice()
+					t.createServiceExport()
+
+					t.awaitHeadlessServiceImport("")
+					t.awaitEndpointSlice()
+				})
+			})
+		})
+
+		When("only its Endpoints are maintained manually", func() {
+			It("should not sync them as they're mirrored to EndpointSlices", func() {
+				t.createEndpoints()
+				t.createServiceExport()
+
+				t.awaitHeadlessServiceImport("")
+				t.awaitServiceExportCondition(newServiceExportCondition("NoEndpoints", corev1.ConditionTrue,
+					"NoSelectorOrEndpoints"))
+				t.awaitNoEndpointSlice(t.brokerEndpointSliceClient)
+			})
+		})
+
+		When("it has no EndpointSlices", func() {
+			It("should update the ServiceExport status and sync the EndpointSlice once they're created", func() {
+				t.createServiceExport()
+
+				t.awaitHeadlessServiceImport("")
+				t.awaitServiceExportCondition(newServiceExportCondition("NoEndpoints", corev1.ConditionTrue,
+					"NoSelectorOrEndpoints"))
+
+				t.createManualEndpointSlice()
+				t.awaitEndpointSlice()
+				t.awaitServiceExportCondition(newServiceExportCondition("NoEndpoints", corev1.ConditionFalse,
+					"ManualEndpoints"))
+			})
+		})
+	})
+
 	When("a ServiceExport is deleted", func() {
 		It("should delete the ServiceImport and EndpointSlice", func() {
 			t.createEndpoints()
@@ -344,6 +2715,79 @@ var _ = Describe("Service export failures", func() {
 	})
 })
 
+var _ = Describe("Orphaned EndpointSlice collection", func() {
+	const maxAge = time.Second
+
+	var t *testDriver
+
+	BeforeEach(func() {
+		t = newTestDiver()
+		t.service.Spec.ClusterIP = corev1.ClusterIPNone
+		t.cluster2.agentSpec.OrphanedEndpointSliceMaxAge = maxAge
+	})
+
+	JustBeforeEach(func() {
+		t.justBeforeEach()
+	})
+
+	AfterEach(func() {
+		t.afterEach()
+	})
+
+	When("an imported EndpointSlice without a ServiceImport is older than the max age", func() {
+		It("should delete it and count the deletion", func() {
+			deleted := testutil.ToFloat64(controller.OrphanedEndpointSlicesDeleted.WithLabelValues(clusterID1))
+
+			t.cluster2.createOrphanedEndpointSlice("orphan", clusterID1, time.Now().Add(-time.Hour))
+			test.AwaitNoResource(t.cluster2.localEndpointSliceClient, "orphan")
+
+			Eventually(func() float64 {
+				return testutil.ToFloat64(controller.OrphanedEndpointSlicesDeleted.WithLabelValues(clusterID1))
+			}).Should(Equal(deleted + 1))
+		})
+	})
+
+	When("an imported EndpointSlice without a ServiceImport is younger than the max age", func() {
+		It("should only delete it once it's older than the max age", func() {
+			t.cluster2.createOrphanedEndpointSlice("orphan", clusterID1, time.Now())
+
+			Consistently(func() error {
+				_, err := t.cluster2.localEndpointSliceClient.Get("orphan", metav1.GetOptions{})
+				return err
+			}, maxAge/2).Should(Succeed())
+
+			test.AwaitNoResource(t.cluster2.localEndpointSliceClient, "orphan")
+		})
+	})
+
+	When("an EndpointSlice without a ServiceImport was exported by the local cluster", func() {
+		It("should not delete it", func() {
+			t.cluster2.createOrphanedEndpointSlice("local", clusterID2, time.Now().Add(-time.Hour))
+
+			Consistently(func() error {
+				_, err := t.cluster2.localEndpointSliceClient.Get("local", metav1.GetOptions{})
+				return err
+			}, 2*maxAge).Should(Succeed())
+		})
+	})
+
+	When("an imported EndpointSlice has a ServiceImport", func() {
+		It("should not delete it", func() {
+			t.createService()
+			t.createEndpoints()
+			t.createServiceExport()
+			t.awaitHeadlessServiceImport("")
+			t.awaitEndpointSlice()
+
+			name := t.endpoints.Name + "-" + clusterID1
+			Consistently(func() error {
+				_, err := t.cluster2.localEndpointSliceClient.Get(name, metav1.GetOptions{})
+				return err
+			}, 2*maxAge).Should(Succeed())
+		})
+	})
+})
+
 type cluster struct {
 	agentSpec                controller.AgentSpecification
 	localDynClient           dynamic.Interface
@@ -401,7 +2845,8 @@ func newTestDiver() *testDriver {
 		syncerConfig: &broker.SyncerConfig{
 			BrokerNamespace: test.RemoteNamespace,
 			RestMapper: test.GetRESTMapperFor(&mcsv1a1.ServiceExport{}, &mcsv1a1.ServiceImport{},
-				&corev1.Service{}, &corev1.Endpoints{}, &discovery.EndpointSlice{}, &lighthousev2a1.ServiceExport{}),
+				&corev1.Service{}, &corev1.Endpoints{}, &discovery.EndpointSlice{}, &lighthousev2a1.ServiceExport{},
+				newHTTPRoute("")),
 			BrokerClient: fake.NewDynamicClient(syncerScheme),
 			Scheme:       syncerScheme,
 		},
@@ -470,6 +2915,25 @@ func (t *testDriver) afterEach() {
 	close(t.stopCh)
 }
 
+// createOrphanedEndpointSlice creates an EndpointSlice exported by the given cluster, as imported by the broker syncer,
+// for a ServiceImport that doesn't exist.
+func (c *cluster) createOrphanedEndpointSlice(name, sourceCluster string, created time.Time) {
+	test.CreateResource(c.localEndpointSliceClient, &discovery.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         serviceNamespace,
+			CreationTimestamp: metav1.NewTime(created),
+			Labels: map[string]string{
+				discovery.LabelManagedBy:           lhconstants.LabelValueManagedBy,
+				lhconstants.LabelSourceCluster:     sourceCluster,
+				lhconstants.LabelServiceImportName: name + "-" + serviceNamespace + "-" + sourceCluster,
+				federate.ClusterIDLabelKey:         sourceCluster,
+			},
+		},
+		AddressType: discovery.AddressTypeIPv4,
+	})
+}
+
 func (c *cluster) init(syncerConfig broker.SyncerConfig) {
 	c.localDynClient = fake.NewDynamicClient(syncerConfig.Scheme)
 
@@ -490,11 +2954,16 @@ func (c *cluster) init(syncerConfig broker.SyncerConfig) {
 }
 
 func (c *cluster) start(t *testDriver, syncerConfig broker.SyncerConfig) {
+	Expect(c.newAgent(syncerConfig).Start(t.stopCh)).To(Succeed())
+}
+
+func (c *cluster) newAgent(syncerConfig broker.SyncerConfig) *controller.Controller {
 	syncerConfig.LocalClient = c.localDynClient
 	agentController, err := controller.New(&c.agentSpec, syncerConfig, c.localKubeClient)
 
 	Expect(err).To(Succeed())
-	Expect(agentController.Start(t.stopCh)).To(Succeed())
+
+	return agentController
 }
 
 func awaitServiceImport(client dynamic.ResourceInterface, service *corev1.Service, sType mcsv1a1.ServiceImportType,
@@ -525,6 +2994,20 @@ func awaitServiceImport(client dynamic.ResourceInterface, service *corev1.Servic
 	return serviceImport
 }
 
+// awaitClustersetServiceImport awaits the ServiceImport of a ClusterSetIP service allocated clusterset IPs, whose
+// spec.ips are the clusterset IPs rather than the service's cluster IP.
+func awaitClustersetServiceImport(client dynamic.ResourceInterface, service *corev1.Service) *mcsv1a1.ServiceImport {
+	obj := test.AwaitResource(client, service.Name+"-"+service.Namespace+"-"+clusterID1)
+
+	serviceImport := &mcsv1a1.ServiceImport{}
+	Expect(scheme.Scheme.Convert(obj, serviceImport, nil)).To(Succeed())
+
+	Expect(serviceImport.Spec.Type).To(Equal(mcsv1a1.ClusterSetIP))
+	Expect(serviceImport.Annotations[lhconstants.AnnotationClusterIP]).To(Equal(service.Spec.ClusterIP))
+
+	return serviceImport
+}
+
 func (c *cluster) awaitServiceImport(service *corev1.Service, sType mcsv1a1.ServiceImportType, serviceIP string) {
 	awaitServiceImport(c.localServiceImportClient, service, sType, serviceIP)
 }
@@ -669,6 +3152,31 @@ func awaitUpdatedEndpointSlice(endpointSliceClient dynamic.ResourceInterface, en
 	Expect(err).To(Succeed())
 }
 
+func (t *testDriver) awaitEndpointSliceExported() {
+	name := t.endpoints.Name + "-" + clusterID1
+	test.AwaitResource(t.brokerEndpointSliceClient, name)
+	test.AwaitResource(t.cluster1.localEndpointSliceClient, name)
+	test.AwaitResource(t.cluster2.localEndpointSliceClient, name)
+}
+
+// readyEndpointIPs returns the addresses of the ready endpoints of the EndpointSlice exported for the Endpoints.
+func readyEndpointIPs(endpointSliceClient dynamic.ResourceInterface, endpoints *corev1.Endpoints) []string {
+	obj, err := endpointSliceClient.Get(endpoints.Name+"-"+clusterID1, metav1.GetOptions{})
+	Expect(err).To(Succeed())
+
+	endpointSlice := &discovery.EndpointSlice{}
+	Expect(scheme.Scheme.Convert(obj, endpointSlice, nil)).To(Succeed())
+
+	ips := []string{}
+	for _, ep := range endpointSlice.Endpoints {
+		if ep.Conditions.Ready != nil && *ep.Conditions.Ready {
+			ips = append(ips, ep.Addresses...)
+		}
+	}
+
+	return ips
+}
+
 func (c *cluster) awaitUpdatedEndpointSlice(endpoints *corev1.Endpoints, expectedIPs []string) {
 	awaitUpdatedEndpointSlice(c.localEndpointSliceClient, endpoints, expectedIPs)
 }
@@ -709,6 +3217,40 @@ func (t *testDriver) createEndpoints() {
 	test.CreateResource(t.dynamicEndpointsClient(), t.endpoints)
 }
 
+// createManualEndpointSlice creates an EndpointSlice equivalent to the Endpoints, as maintained manually for a service
+// without a selector.
+func (t *testDriver) createManualEndpointSlice() {
+	name := "port-1"
+	protocol := corev1.ProtocolTCP
+	var port int32 = 1234
+
+	test.CreateResource(t.cluster1.localEndpointSliceClient, &discovery.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      t.service.Name + "-manual",
+			Namespace: t.service.Namespace,
+			Labels:    map[string]string{discovery.LabelServiceName: t.service.Name},
+		},
+		AddressType: discovery.AddressTypeIPv4,
+		Endpoints: []discovery.Endpoint{
+			{
+				Addresses:  []string{"192.168.5.1"},
+				Conditions: discovery.EndpointConditions{Ready: &ready},
+				Hostname:   &hostName,
+			},
+			{
+				Addresses:  []string{"192.168.5.2"},
+				Conditions: discovery.EndpointConditions{Ready: &ready},
+				Topology:   map[string]string{"kubernetes.io/hostname": nodeName},
+			},
+			{
+				Addresses:  []string{"10.253.6.1"},
+				Conditions: discovery.EndpointConditions{Ready: &notReady},
+			},
+		},
+		Ports: []discovery.EndpointPort{{Name: &name, Protocol: &protocol, Port: &port}},
+	})
+}
+
 func (t *testDriver) updateEndpoints() {
 	_, err := t.cluster1.localKubeClient.CoreV1().Endpoints(t.endpoints.Namespace).Update(t.endpoints)
 	Expect(err).To(Succeed())
@@ -720,10 +3262,82 @@ func (t *testDriver) dynamicEndpointsClient() dynamic.ResourceInterface {
 	return t.cluster1.localDynClient.Resource(schema.GroupVersionResource{Version: "v1", Resource: "endpoints"}).Namespace(t.service.Namespace)
 }
 
+// createSelectedPods creates pods of the service with the given tier label, by name.
+func (t *testDriver) createSelectedPods(tiers map[string]string) {
+	for name, tier := range tiers {
+		podLabels := map[string]string{"tier": tier}
+		for k, v := range t.service.Spec.Selector {
+			podLabels[k] = v
+		}
+
+		_, err := t.cluster1.localKubeClient.CoreV1().Pods(t.service.Namespace).Create(&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: t.service.Namespace, Labels: podLabels},
+		})
+		Expect(err).To(Succeed())
+	}
+}
+
 func (t *testDriver) createServiceExport() {
 	test.CreateResource(t.cluster1.localServiceExportClient, t.serviceExport)
 }
 
+func (t *testDriver) getServiceExport() *mcsv1a1.ServiceExport {
+	obj, err := t.cluster1.localServiceExportClient.Get(t.serviceExport.Name, metav1.GetOptions{})
+	Expect(err).To(Succeed())
+
+	serviceExport := &mcsv1a1.ServiceExport{}
+	Expect(scheme.Scheme.Convert(obj, serviceExport, nil)).To(Succeed())
+
+	return serviceExport
+}
+
+func (t *testDriver) markServiceExportDeleted() {
+	serviceExport := t.getServiceExport()
+	now := metav1.Now()
+	serviceExport.DeletionTimestamp = &now
+	test.UpdateResource(t.cluster1.localServiceExportClient, serviceExport)
+}
+
+// terminateNamespace marks the service's namespace as being deleted, as the API server does before removing its
+// resources.
+func (t *testDriver) terminateNamespace() {
+	now := metav1.Now()
+	_, err := t.cluster1.localKubeClient.CoreV1().Namespaces().Create(&corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              t.service.Namespace,
+			DeletionTimestamp: &now,
+		},
+		Status: corev1.NamespaceStatus{Phase: corev1.NamespaceTerminating},
+	})
+	Expect(err).To(Succeed())
+}
+
+// finalizeServiceExport emulates the API server deleting a ServiceExport with finalizers, which the fake client
+// removes immediately: a deletion timestamp is set and the ServiceExport is removed once its finalizers are gone.
+func (t *testDriver) finalizeServiceExport() {
+	err := wait.PollImmediate(50*time.Millisecond, 5*time.Second, func() (bool, error) {
+		obj, err := t.cluster1.localServiceExportClient.Get(t.serviceExport.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+
+		Expect(err).To(Succeed())
+
+		serviceExport := &mcsv1a1.ServiceExport{}
+		Expect(scheme.Scheme.Convert(obj, serviceExport, nil)).To(Succeed())
+
+		if serviceExport.DeletionTimestamp == nil {
+			t.markServiceExportDeleted()
+		} else if len(serviceExport.Finalizers) == 0 {
+			Expect(t.cluster1.localServiceExportClient.Delete(serviceExport.Name, nil)).To(Succeed())
+		}
+
+		return false, nil
+	})
+
+	Expect(err).To(Succeed(), "The ServiceExport finalizer wasn't removed")
+}
+
 func (t *testDriver) deleteServiceExport() {
 	Expect(t.cluster1.localServiceExportClient.Delete(t.service.GetName(), nil)).To(Succeed())
 }
@@ -738,6 +3352,57 @@ func (t *testDriver) dynamicServiceClient() dynamic.ResourceInterface {
 	return t.cluster1.localDynClient.Resource(schema.GroupVersionResource{Version: "v1", Resource: "services"}).Namespace(t.service.Namespace)
 }
 
+func newBackendService(name, clusterIP string) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: serviceNamespace,
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: clusterIP,
+			Selector:  map[string]string{"app": name},
+		},
+	}
+}
+
+func (t *testDriver) createBackendService(service *corev1.Service) {
+	_, err := t.cluster1.localKubeClient.CoreV1().Services(service.Namespace).Create(service)
+	Expect(err).To(Succeed())
+
+	test.CreateResource(t.dynamicServiceClient(), service)
+}
+
+func newHTTPRoute(name string, backends ...string) *unstructured.Unstructured {
+	refs := []interface{}{}
+	for _, backend := range backends {
+		refs = append(refs, map[string]interface{}{"name": backend, "port": int64(80)})
+	}
+
+	route := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "gateway.networking.k8s.io/v1",
+		"kind":       "HTTPRoute",
+		"spec": map[string]interface{}{
+			"rules": []interface{}{
+				map[string]interface{}{"backendRefs": refs},
+			},
+		},
+	}}
+
+	route.SetName(name)
+	route.SetNamespace(serviceNamespace)
+
+	return route
+}
+
+func (t *testDriver) createHTTPRoute(name string, backends ...string) {
+	test.CreateResource(t.httpRouteClient(), newHTTPRoute(name, backends...))
+}
+
+func (t *testDriver) httpRouteClient() dynamic.ResourceInterface {
+	return t.cluster1.localDynClient.Resource(*test.GetGroupVersionResourceFor(t.syncerConfig.RestMapper,
+		newHTTPRoute(""))).Namespace(serviceNamespace)
+}
+
 func (t *testDriver) awaitNoServiceImport(client dynamic.ResourceInterface) {
 	test.AwaitNoResource(client, t.service.Name+"-"+t.service.Namespace+"-"+clusterID1)
 }
@@ -794,6 +3459,30 @@ func (t *testDriver) awaitServiceExportStatus(atIndex int, expCond ...*mcsv1a1.S
 	}
 }
 
+func (t *testDriver) awaitServiceExportCondition(expCond *mcsv1a1.ServiceExportCondition) {
+	err := wait.PollImmediate(50*time.Millisecond, 5*time.Second, func() (bool, error) {
+		obj, err := t.cluster1.localServiceExportClient.Get(t.service.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, nil
+		}
+
+		se := &mcsv1a1.ServiceExport{}
+		Expect(scheme.Scheme.Convert(obj, se, nil)).To(Succeed())
+
+		for i := range se.Status.Conditions {
+			actual := &se.Status.Conditions[i]
+			if actual.Type == expCond.Type && actual.Status == expCond.Status && actual.Reason != nil &&
+				*actual.Reason == *expCond.Reason {
+				return true, nil
+			}
+		}
+
+		return false, nil
+	})
+
+	Expect(err).To(Succeed(), "ServiceExport condition %#v not found", expCond)
+}
+
 func (t *testDriver) awaitNotServiceExportStatus(notCond *mcsv1a1.ServiceExportCondition) {
 	err := wait.PollImmediate(50*time.Millisecond, 300*time.Millisecond, func() (bool, error) {
 		obj, err := t.cluster1.localServiceExportClient.Get(t.service.Name, metav1.GetOptions{})
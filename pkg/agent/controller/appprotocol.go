@@ -0,0 +1,80 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
+)
+
+// serviceType is the Service handled unstructured, as the vendored Service type predates some of the fields read from
+// it, eg the ports' appProtocol, which are dropped when the Service is converted.
+var serviceType = &unstructured.Unstructured{Object: map[string]interface{}{
+	"apiVersion": "v1",
+	"kind":       "Service",
+}}
+
+// getService returns the Service from the cache, along with the unstructured Service for the fields the vendored type
+// drops. Both are taken from the same cached object so they're consistent.
+func (a *Controller) getService(namespace, name string) (*corev1.Service, *unstructured.Unstructured, bool, error) {
+	obj, found, err := a.unstructuredServiceSyncer.GetResource(name, namespace)
+	if err != nil || !found {
+		return nil, nil, false, err
+	}
+
+	unstructuredSvc := obj.(*unstructured.Unstructured)
+
+	svc := &corev1.Service{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(unstructuredSvc.Object, svc); err != nil {
+		return nil, nil, false, err
+	}
+
+	return svc, unstructuredSvc, true, nil
+}
+
+// appProtocolsFromService returns the appProtocol of each port of the Service that has one, keyed by the port's name.
+func appProtocolsFromService(obj *unstructured.Unstructured) map[string]string {
+	appProtocols := map[string]string{}
+
+	ports, _, _ := unstructured.NestedSlice(obj.Object, "spec", "ports")
+	for _, p := range ports {
+		port, ok := p.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		appProtocol, _, _ := unstructured.NestedString(port, "appProtocol")
+		if appProtocol == "" {
+			continue
+		}
+
+		portName, _, _ := unstructured.NestedString(port, "name")
+		appProtocols[portName] = appProtocol
+	}
+
+	return appProtocols
+}
+
+// setAppProtocols sets the appProtocol of the exported ports from the Service ports of the same name.
+func setAppProtocols(ports []mcsv1a1.ServicePort, appProtocols map[string]string) {
+	for i := range ports {
+		if appProtocol, ok := appProtocols[ports[i].Name]; ok {
+			ports[i].AppProtocol = &appProtocol
+		}
+	}
+}
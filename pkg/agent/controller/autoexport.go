@@ -0,0 +1,102 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"github.com/submariner-io/admiral/pkg/syncer"
+	"github.com/submariner-io/admiral/pkg/util"
+	lhconstants "github.com/submariner-io/lighthouse/pkg/constants"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog"
+	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
+)
+
+// serviceToAutoExport manages the ServiceExport of a Service according to its export label: a ServiceExport is created
+// while the label is "true" and deleted once the label is removed or the Service is deleted. Only ServiceExports
+// created this way, marked by an annotation, are deleted so users can still export services explicitly.
+func (a *Controller) serviceToAutoExport(obj runtime.Object, numRequeues int, op syncer.Operation) (runtime.Object, bool) {
+	svc := obj.(*corev1.Service)
+
+	var err error
+	if op != syncer.Delete && svc.Labels[lhconstants.LabelExport] == "true" {
+		err = a.createAutoExport(svc)
+	} else {
+		err = a.deleteAutoExport(svc)
+	}
+
+	if err != nil {
+		klog.Errorf("Error updating the automatic ServiceExport of Service (%s/%s): %v", svc.Namespace, svc.Name, err)
+		return nil, true
+	}
+
+	return nil, false
+}
+
+func (a *Controller) createAutoExport(svc *corev1.Service) error {
+	_, found, err := a.serviceExportSyncer.GetResource(svc.Name, svc.Namespace)
+	if err != nil || found {
+		return err
+	}
+
+	raw, err := util.ToUnstructured(&mcsv1a1.ServiceExport{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        svc.Name,
+			Namespace:   svc.Namespace,
+			Annotations: map[string]string{lhconstants.AnnotationAutoExported: "true"},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = a.serviceExportClient.Namespace(svc.Namespace).Create(raw, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		return nil
+	}
+
+	if err == nil {
+		klog.Infof("Created a ServiceExport for Service (%s/%s) as it's labeled for export", svc.Namespace, svc.Name)
+	}
+
+	return err
+}
+
+func (a *Controller) deleteAutoExport(svc *corev1.Service) error {
+	obj, found, err := a.serviceExportSyncer.GetResource(svc.Name, svc.Namespace)
+	if err != nil || !found {
+		return err
+	}
+
+	svcExport := obj.(*mcsv1a1.ServiceExport)
+	if svcExport.Annotations[lhconstants.AnnotationAutoExported] != "true" || svcExport.DeletionTimestamp != nil {
+		return nil
+	}
+
+	err = a.serviceExportClient.Namespace(svc.Namespace).Delete(svc.Name, &metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+
+	if err == nil {
+		klog.Infof("Deleted the automatic ServiceExport of Service (%s/%s) as it's no longer labeled for export",
+			svc.Namespace, svc.Name)
+	}
+
+	return err
+}
@@ -0,0 +1,124 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/submariner-io/admiral/pkg/syncer"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	defaultImportRetryBaseDelay = 5 * time.Millisecond
+	defaultImportRetryMaxDelay  = 30 * time.Second
+
+	// syncerMaxRetryDelay is the maximum delay of the admiral syncers' own backoff.
+	syncerMaxRetryDelay = 30 * time.Second
+)
+
+// retryBackoff spaces out the retries of a failed import with an exponential backoff from a base to a max delay. The
+// admiral syncers requeue a failed resource with their own fixed backoff, so a retry that comes too early is requeued
+// again without running the transform or syncing anything. The retries can therefore be made less frequent than the
+// syncers' own, which range from 5ms to 30s, but not more.
+type retryBackoff struct {
+	limiter   workqueue.RateLimiter
+	maxDelay  time.Duration
+	now       func() time.Time
+	mutex     sync.Mutex
+	nextRetry map[string]time.Time
+}
+
+// importRetryDelays returns the import retry delays in the spec, defaulting those that are zero.
+func importRetryDelays(spec *AgentSpecification) (time.Duration, time.Duration, error) {
+	baseDelay, maxDelay := spec.ImportRetryBaseDelay, spec.ImportRetryMaxDelay
+	if baseDelay == 0 {
+		baseDelay = defaultImportRetryBaseDelay
+	}
+
+	if maxDelay == 0 {
+		maxDelay = defaultImportRetryMaxDelay
+	}
+
+	if baseDelay < 0 || maxDelay < baseDelay {
+		return 0, 0, fmt.Errorf("the import retry base delay %v must be positive and no greater than the max delay %v",
+			baseDelay, maxDelay)
+	}
+
+	return baseDelay, maxDelay, nil
+}
+
+func newRetryBackoff(baseDelay, maxDelay time.Duration) *retryBackoff {
+	return &retryBackoff{
+		limiter:   workqueue.NewItemExponentialFailureRateLimiter(baseDelay, maxDelay),
+		maxDelay:  maxDelay,
+		now:       time.Now,
+		nextRetry: map[string]time.Time{},
+	}
+}
+
+// wrap returns a TransformFunc running the given one subject to the backoff. The syncer passes a non-zero numRequeues
+// once an attempt failed, either in the transform or while syncing its result, which is what starts the backoff.
+func (b *retryBackoff) wrap(transform syncer.TransformFunc) syncer.TransformFunc {
+	return func(from runtime.Object, numRequeues int, op syncer.Operation) (runtime.Object, bool) {
+		key, err := cache.MetaNamespaceKeyFunc(from)
+		if err == nil && !b.allow(key, numRequeues > 0) {
+			return nil, true
+		}
+
+		return transform(from, numRequeues, op)
+	}
+}
+
+// allow returns whether an attempt for the key may proceed now and, if it's a retry, schedules the next one.
+func (b *retryBackoff) allow(key string, isRetry bool) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if !isRetry {
+		b.forget(key)
+		return true
+	}
+
+	now := b.now()
+	if next, ok := b.nextRetry[key]; ok && now.Before(next) {
+		return false
+	}
+
+	b.nextRetry[key] = now.Add(b.limiter.When(key))
+	b.prune(now)
+
+	return true
+}
+
+// prune forgets the keys that weren't retried for longer than any retry can take, ie that succeeded or were deleted
+// since.
+func (b *retryBackoff) prune(now time.Time) {
+	for key, next := range b.nextRetry {
+		if now.Sub(next) > b.maxDelay+syncerMaxRetryDelay {
+			b.forget(key)
+		}
+	}
+}
+
+func (b *retryBackoff) forget(key string) {
+	b.limiter.Forget(key)
+	delete(b.nextRetry, key)
+}
@@ -0,0 +1,128 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/submariner-io/admiral/pkg/syncer"
+	"github.com/submariner-io/lighthouse/pkg/agent/controller"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
+)
+
+var _ = Describe("Import retry backoff", func() {
+	var (
+		now       time.Time
+		attempts  []time.Duration
+		transform syncer.TransformFunc
+		numCalls  int
+	)
+
+	start := time.Now()
+	serviceImport := &mcsv1a1.ServiceImport{ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "service-ns"}}
+
+	BeforeEach(func() {
+		now = start
+		attempts = nil
+		numCalls = 0
+
+		transform = controller.NewRetryBackoffTransform(time.Second, 8*time.Second, func() time.Time {
+			return now
+		}, func(from runtime.Object, numRequeues int, op syncer.Operation) (runtime.Object, bool) {
+			attempts = append(attempts, now.Sub(start))
+			return nil, true
+		})
+	})
+
+	// retryUntil emulates the syncer requeuing the failed import every 100ms until the given time.
+	retryUntil := func(until time.Duration) {
+		for ; now.Sub(start) <= until; now = now.Add(100 * time.Millisecond) {
+			numCalls++
+			obj, requeue := transform(serviceImport, numCalls, syncer.Create)
+			Expect(obj).To(BeNil())
+			Expect(requeue).To(BeTrue())
+		}
+	}
+
+	When("an import keeps failing", func() {
+		It("should retry it with exponentially increasing delays up to the max", func() {
+			_, _ = transform(serviceImport, 0, syncer.Create)
+			retryUntil(32 * time.Second)
+
+			Expect(attempts).To(Equal([]time.Duration{0, 0, time.Second, 3 * time.Second, 7 * time.Second,
+				15 * time.Second, 23 * time.Second, 31 * time.Second}))
+		})
+	})
+
+	When("an import is processed again after succeeding", func() {
+		It("should restart the backoff from the base delay", func() {
+			_, _ = transform(serviceImport, 0, syncer.Create)
+			retryUntil(4 * time.Second)
+
+			now = start.Add(10 * time.Second)
+			attempts = nil
+			numCalls = 0
+
+			_, _ = transform(serviceImport, 0, syncer.Update)
+			retryUntil(12 * time.Second)
+
+			Expect(attempts).To(Equal([]time.Duration{10 * time.Second, 10 * time.Second, 11 * time.Second}))
+		})
+	})
+
+	When("another import fails", func() {
+		It("should back it off independently", func() {
+			_, _ = transform(serviceImport, 0, syncer.Create)
+			retryUntil(4 * time.Second)
+
+			attempts = nil
+			_, _ = transform(&mcsv1a1.ServiceImport{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "service-ns"}}, 1,
+				syncer.Create)
+
+			Expect(attempts).To(HaveLen(1))
+		})
+	})
+
+	When("the base delay is greater than the max delay", func() {
+		var t *testDriver
+
+		BeforeEach(func() {
+			t = newTestDiver()
+		})
+
+		JustBeforeEach(func() {
+			t.justBeforeEach()
+		})
+
+		AfterEach(func() {
+			t.afterEach()
+		})
+
+		It("should fail to create the controller", func() {
+			syncerConfig := *t.syncerConfig
+			syncerConfig.LocalClient = t.cluster2.localDynClient
+			t.cluster2.agentSpec.ImportRetryBaseDelay = time.Minute
+			t.cluster2.agentSpec.ImportRetryMaxDelay = time.Second
+
+			_, err := controller.New(&t.cluster2.agentSpec, syncerConfig, t.cluster2.localKubeClient)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
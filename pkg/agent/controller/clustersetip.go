@@ -0,0 +1,257 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+	lhconstants "github.com/submariner-io/lighthouse/pkg/constants"
+	"github.com/submariner-io/lighthouse/pkg/ipam"
+	"github.com/submariner-io/lighthouse/pkg/serviceimport"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog"
+	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
+)
+
+// ipFamiliesFromService returns whether the Service has an IPv4 and an IPv6 cluster IP. The vendored Service type
+// predates dual-stack so its ipFamilies are read from the unstructured Service. A Service that doesn't list any is
+// single-stack, of the family of its cluster IP.
+func ipFamiliesFromService(svc *corev1.Service, obj *unstructured.Unstructured) (ipv4, ipv6 bool) {
+	families, _, _ := unstructured.NestedStringSlice(obj.Object, "spec", "ipFamilies")
+	for _, family := range families {
+		switch corev1.IPFamily(family) {
+		case corev1.IPv4Protocol:
+			ipv4 = true
+		case corev1.IPv6Protocol:
+			ipv6 = true
+		}
+	}
+
+	if ipv4 || ipv6 {
+		return ipv4, ipv6
+	}
+
+	if ip := net.ParseIP(svc.Spec.ClusterIP); ip != nil && ip.To4() == nil {
+		return false, true
+	}
+
+	return true, false
+}
+
+// allocateClustersetIPs allocates a clusterset VIP from the pool of each IP family of the Service for which one is
+// configured, and records them as the ServiceImport's IPs, the cluster's IP being kept in its cluster-ip annotation.
+// A dual-stack Service thus gets both an IPv4 and an IPv6 VIP. The pools are stateless so the VIPs are consistent
+// across the clusterset: the agents of all the clusters configured with the same CIDRs derive the same VIPs for a
+// service from its namespace and name, skipping the VIPs claimed by the other services in their ServiceImports,
+// imported from all the clusters. It returns false if an allocation failed, after updating the ServiceExport status.
+func (a *Controller) allocateClustersetIPs(svcExport *mcsv1a1.ServiceExport, svc *corev1.Service,
+	unstructuredSvc *unstructured.Unstructured, serviceImport *mcsv1a1.ServiceImport) bool {
+	if a.clustersetIPs == nil && a.clustersetIPv6s == nil {
+		return true
+	}
+
+	ipv4, ipv6 := ipFamiliesFromService(svc, unstructuredSvc)
+
+	pools := []struct {
+		pool   *ipam.Pool
+		wanted bool
+		family string
+	}{
+		{a.clustersetIPs, ipv4, "IP"},
+		{a.clustersetIPv6s, ipv6, "IPv6 address"},
+	}
+
+	list, err := a.serviceImportSyncer.ListLocalResources(&mcsv1a1.ServiceImport{})
+	if err != nil {
+		a.updateExportedServiceStatus(svcExport.Name, svcExport.Namespace, mcsv1a1.ServiceExportValid,
+			corev1.ConditionUnknown, "ClustersetIPAllocationFailed", err.Error())
+
+		return false
+	}
+
+	list = a.withPendingClaims(list)
+
+	vips := []string{}
+
+	for _, p := range pools {
+		if p.pool == nil || !p.wanted {
+			continue
+		}
+
+		vip, err := p.pool.Allocate(clustersetIPKey(svcExport), clustersetIPClaims(list, p.pool.IsIPv6()))
+		if errors.Is(err, ipam.ErrPoolExhausted) {
+			a.updateExportedServiceStatus(svcExport.Name, svcExport.Namespace, mcsv1a1.ServiceExportValid,
+				corev1.ConditionFalse, clustersetIPExhausted, "No clusterset "+p.family+" is available in the configured pool")
+
+			return false
+		}
+
+		if err != nil {
+			a.updateExportedServiceStatus(svcExport.Name, svcExport.Namespace, mcsv1a1.ServiceExportValid,
+				corev1.ConditionUnknown, "ClustersetIPAllocationFailed", err.Error())
+
+			return false
+		}
+
+		vips = append(vips, vip)
+	}
+
+	if len(vips) > 0 {
+		serviceImport.Spec.IPs = vips
+	}
+
+	return true
+}
+
+// clustersetIPClaims returns the clusterset VIPs of the given family of the ServiceImports, claimed for their service
+// as of its export.
+func clustersetIPClaims(serviceImports []runtime.Object, ipv6 bool) []ipam.Claim {
+	claims := make([]ipam.Claim, 0, len(serviceImports))
+
+	for _, obj := range serviceImports {
+		si := obj.(*mcsv1a1.ServiceImport)
+
+		vip4, vip6 := serviceimport.ClustersetIPs(si)
+
+		ip := vip4
+		if ipv6 {
+			ip = vip6
+		}
+
+		if ip == "" {
+			continue
+		}
+
+		claim := ipam.Claim{
+			Key: si.Annotations[lhconstants.OriginNamespace] + "." + si.Annotations[lhconstants.OriginName],
+			IP:  ip,
+		}
+
+		if exportTime, err := time.Parse(time.RFC3339, si.Annotations[lhconstants.AnnotationExportTime]); err == nil {
+			claim.Time = exportTime
+		}
+
+		claims = append(claims, claim)
+	}
+
+	return claims
+}
+
+// reallocateDisplacedClustersetIPs re-exports the local exports of other services holding a clusterset VIP that the
+// imported ServiceImport claims with precedence. As the clusters allocate the VIPs of their exports concurrently, two
+// of them may derive the same VIP for different services before either sees the other's ServiceImport, and the oldest
+// claim must win as it does on allocation. It returns whether it should be retried.
+func (a *Controller) reallocateDisplacedClustersetIPs(serviceImport *mcsv1a1.ServiceImport, numRequeues int) bool {
+	if (a.clustersetIPs == nil && a.clustersetIPv6s == nil) || a.serviceExportSyncer == nil ||
+		serviceImport.Labels[lhconstants.LabelSourceCluster] == a.clusterID {
+		return false
+	}
+
+	list, err := a.serviceImportSyncer.ListLocalResources(&mcsv1a1.ServiceImport{})
+	if err != nil {
+		klog.Errorf("Error listing the ServiceImports to check the clusterset IP claims of %q: %v", serviceImport.Name, err)
+		return true
+	}
+
+	displaced := []*mcsv1a1.ServiceImport{}
+
+	for _, obj := range list {
+		local := obj.(*mcsv1a1.ServiceImport)
+		if local.Labels[lhconstants.LabelSourceCluster] == a.clusterID && displacesClustersetIP(serviceImport, local) {
+			displaced = append(displaced, local)
+		}
+	}
+
+	if len(displaced) == 0 {
+		return false
+	}
+
+	a.pendingClaimsMutex.Lock()
+	a.pendingClaims[serviceImport.Name] = serviceImport
+	a.pendingClaimsMutex.Unlock()
+
+	retry := false
+
+	for _, local := range displaced {
+		name, namespace := local.Annotations[lhconstants.OriginName], local.Annotations[lhconstants.OriginNamespace]
+
+		obj, found, err := a.serviceExportSyncer.GetResource(name, namespace)
+		if err != nil {
+			klog.Errorf("Error retrieving the ServiceExport (%s/%s) to reallocate its clusterset IP: %v", namespace, name, err)
+			retry = true
+
+			continue
+		}
+
+		if !found {
+			continue
+		}
+
+		klog.Infof("The clusterset IP of ServiceExport (%s/%s) is claimed first by service (%s/%s) exported by cluster %q"+
+			" - re-exporting it", namespace, name, serviceImport.Annotations[lhconstants.OriginNamespace],
+			serviceImport.Annotations[lhconstants.OriginName], serviceImport.Labels[lhconstants.LabelSourceCluster])
+
+		retry = a.reexport(obj.(*mcsv1a1.ServiceExport), numRequeues) || retry
+	}
+
+	return retry
+}
+
+// displacesClustersetIP returns whether the ServiceImport of a service claims a clusterset VIP of the other
+// ServiceImport, of another service, with precedence.
+func displacesClustersetIP(serviceImport, other *mcsv1a1.ServiceImport) bool {
+	for _, ipv6 := range []bool{false, true} {
+		claims := clustersetIPClaims([]runtime.Object{serviceImport}, ipv6)
+		otherClaims := clustersetIPClaims([]runtime.Object{other}, ipv6)
+
+		if len(claims) == 1 && len(otherClaims) == 1 && claims[0].IP == otherClaims[0].IP &&
+			claims[0].Key != otherClaims[0].Key && claims[0].Precedes(&otherClaims[0]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// withPendingClaims returns the listed ServiceImports along with the pending claims that aren't listed yet, and forgets
+// those that are.
+func (a *Controller) withPendingClaims(list []runtime.Object) []runtime.Object {
+	a.pendingClaimsMutex.Lock()
+	defer a.pendingClaimsMutex.Unlock()
+
+	if len(a.pendingClaims) == 0 {
+		return list
+	}
+
+	listed := map[string]bool{}
+	for _, obj := range list {
+		listed[obj.(*mcsv1a1.ServiceImport).Name] = true
+	}
+
+	for name, serviceImport := range a.pendingClaims {
+		if listed[name] {
+			delete(a.pendingClaims, name)
+		} else {
+			list = append(list, serviceImport)
+		}
+	}
+
+	return list
+}
@@ -0,0 +1,99 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"github.com/submariner-io/admiral/pkg/log"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/klog"
+)
+
+// writeErrorCountingClient is a dynamic client counting the failed updates of the given resources by reason. The
+// agents write the same imported objects from the broker, so while two agents briefly both reconcile them during a
+// leader election handoff, a 409 Conflict only means the other one wrote first. The conflict is returned as it is, so
+// the syncer reads the latest object and reapplies its change to it, or requeues the sync, rather than overwriting the
+// other agent's write.
+type writeErrorCountingClient struct {
+	dynamic.Interface
+	resources map[string]bool
+}
+
+type writeErrorCountingNamespaceableResource struct {
+	writeErrorCountingResource
+	namespaceable dynamic.NamespaceableResourceInterface
+}
+
+type writeErrorCountingResource struct {
+	dynamic.ResourceInterface
+	resource string
+}
+
+// newWriteErrorCountingClient wraps the given dynamic client so the failed updates of the given resources are counted.
+func newWriteErrorCountingClient(client dynamic.Interface, resources ...string) dynamic.Interface {
+	c := &writeErrorCountingClient{Interface: client, resources: map[string]bool{}}
+	for _, resource := range resources {
+		c.resources[resource] = true
+	}
+
+	return c
+}
+
+func (c *writeErrorCountingClient) Resource(gvr schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	resource := c.Interface.Resource(gvr)
+	if !c.resources[gvr.Resource] {
+		return resource
+	}
+
+	return &writeErrorCountingNamespaceableResource{
+		writeErrorCountingResource: writeErrorCountingResource{ResourceInterface: resource, resource: gvr.Resource},
+		namespaceable:              resource,
+	}
+}
+
+func (r *writeErrorCountingNamespaceableResource) Namespace(namespace string) dynamic.ResourceInterface {
+	return &writeErrorCountingResource{ResourceInterface: r.namespaceable.Namespace(namespace), resource: r.resource}
+}
+
+func (r *writeErrorCountingResource) Update(obj *unstructured.Unstructured, options metav1.UpdateOptions,
+	subresources ...string) (*unstructured.Unstructured, error) {
+	result, err := r.ResourceInterface.Update(obj, options, subresources...)
+	if err == nil {
+		return result, nil
+	}
+
+	ImportWriteErrors.WithLabelValues(r.resource, writeErrorReason(err)).Inc()
+
+	if apierrors.IsConflict(err) {
+		klog.V(log.DEBUG).Infof("The update of %s %q conflicted, likely with another agent: %v", r.resource,
+			obj.GetName(), err)
+	}
+
+	return result, err
+}
+
+// writeErrorReason returns the API status reason of a failed write, eg "Conflict" or "Forbidden", or "Unknown" for an
+// error without one, eg a connection failure.
+func writeErrorReason(err error) string {
+	if reason := apierrors.ReasonForError(err); reason != metav1.StatusReasonUnknown {
+		return string(reason)
+	}
+
+	return "Unknown"
+}
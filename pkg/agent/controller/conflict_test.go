@@ -0,0 +1,97 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/submariner-io/admiral/pkg/syncer/test"
+	"github.com/submariner-io/lighthouse/pkg/agent/controller"
+	lhconstants "github.com/submariner-io/lighthouse/pkg/constants"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
+)
+
+var _ = Describe("Import write errors", func() {
+	var t *testDriver
+
+	BeforeEach(func() {
+		t = newTestDiver()
+	})
+
+	JustBeforeEach(func() {
+		t.justBeforeEach()
+	})
+
+	AfterEach(func() {
+		t.afterEach()
+	})
+
+	updateBrokerImportWeight := func(weight string) {
+		brokerImport := test.AwaitResource(t.brokerServiceImportClient, t.service.Name+"-"+t.service.Namespace+"-"+clusterID1)
+		annotations := brokerImport.GetAnnotations()
+		annotations[lhconstants.AnnotationWeight] = weight
+		brokerImport.SetAnnotations(annotations)
+		_, err := t.brokerServiceImportClient.Update(brokerImport, metav1.UpdateOptions{})
+		Expect(err).To(Succeed())
+	}
+
+	awaitImportedWeight := func(weight string) {
+		Eventually(func() map[string]string {
+			return awaitServiceImport(t.cluster2.localServiceImportClient, t.service, mcsv1a1.ClusterSetIP,
+				t.service.Spec.ClusterIP).GetAnnotations()
+		}, 5).Should(HaveKeyWithValue(lhconstants.AnnotationWeight, weight))
+	}
+
+	JustBeforeEach(func() {
+		t.createService()
+		t.createServiceExport()
+		awaitServiceImport(t.cluster2.localServiceImportClient, t.service, mcsv1a1.ClusterSetIP, t.service.Spec.ClusterIP)
+	})
+
+	When("the update of an imported ServiceImport conflicts with another agent's write", func() {
+		It("should reapply the change to the latest version and count the conflict", func() {
+			conflicts := testutil.ToFloat64(controller.ImportWriteErrors.WithLabelValues("serviceimports", "Conflict"))
+
+			t.cluster2.localServiceImportClient.FailOnUpdate = apierrors.NewConflict(
+				schema.GroupResource{Resource: "serviceimports"}, t.serviceExport.Name, nil)
+
+			updateBrokerImportWeight("5")
+			awaitImportedWeight("5")
+
+			Expect(testutil.ToFloat64(controller.ImportWriteErrors.WithLabelValues("serviceimports", "Conflict"))).To(
+				Equal(conflicts + 1))
+		})
+	})
+
+	When("the update of an imported ServiceImport fails otherwise", func() {
+		It("should count the error by reason and retry it", func() {
+			forbidden := testutil.ToFloat64(controller.ImportWriteErrors.WithLabelValues("serviceimports", "Forbidden"))
+
+			t.cluster2.localServiceImportClient.FailOnUpdate = apierrors.NewForbidden(
+				schema.GroupResource{Resource: "serviceimports"}, t.serviceExport.Name, nil)
+
+			updateBrokerImportWeight("7")
+			awaitImportedWeight("7")
+
+			Expect(testutil.ToFloat64(controller.ImportWriteErrors.WithLabelValues("serviceimports", "Forbidden"))).To(
+				Equal(forbidden + 1))
+		})
+	})
+})
@@ -0,0 +1,87 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
+)
+
+const (
+	defaultClustersetDomain = "clusterset.local"
+	// The DNS limits on the length of a label and of a name, excluding the trailing dot.
+	maxDNSLabelLength = 63
+	maxDNSNameLength  = 253
+	// The reason of the Valid condition of an export whose clusterset name can't be resolved.
+	clustersetNameTooLong = "ClustersetNameTooLong"
+	// The reason of the NameLength condition of an export whose cluster-specific clusterset name can't be resolved.
+	clusterNameTooLong = "ClusterNameTooLong"
+)
+
+// serviceExportNameLength is set on the ServiceExport of a service whose cluster-specific clusterset name exceeds the
+// DNS limits, which leaves the service resolvable by its clusterset name but not by cluster.
+const serviceExportNameLength mcsv1a1.ServiceExportConditionType = "NameLength"
+
+func clustersetNameLimits(spec *AgentSpecification) (string, int, error) {
+	domain, maxLength := strings.TrimSuffix(spec.ClustersetDomain, "."), spec.MaxClustersetNameLength
+	if domain == "" {
+		domain = defaultClustersetDomain
+	}
+
+	if maxLength == 0 {
+		maxLength = maxDNSNameLength
+	}
+
+	if maxLength < 0 || maxLength > maxDNSNameLength {
+		return "", 0, fmt.Errorf("the max clusterset name length %d must be in range [1, %d]", maxLength, maxDNSNameLength)
+	}
+
+	if err := checkDNSName(domain, maxLength); err != nil {
+		return "", 0, fmt.Errorf("invalid clusterset domain: %v", err)
+	}
+
+	return domain, maxLength, nil
+}
+
+// checkClustersetNames checks the names the service is resolved by in the clusterset against the DNS limits:
+// "<service>.<namespace>.svc.<domain>", and "<cluster>.<service>.<namespace>.svc.<domain>" for this cluster.
+func (a *Controller) checkClustersetNames(name, namespace string) (nameErr, clusterNameErr error) {
+	serviceName := name + "." + namespace + ".svc." + a.clustersetDomain
+
+	if err := checkDNSName(serviceName, a.maxClustersetNameLength); err != nil {
+		return err, nil
+	}
+
+	return nil, checkDNSName(a.clusterID+"."+serviceName, a.maxClustersetNameLength)
+}
+
+// checkDNSName returns an error if the name is longer than maxLength or has a label longer than 63 bytes.
+func checkDNSName(name string, maxLength int) error {
+	if len(name) > maxLength {
+		return fmt.Errorf("the name %q is %d bytes long, longer than the maximum of %d", name, len(name), maxLength)
+	}
+
+	for _, label := range strings.Split(name, ".") {
+		if len(label) > maxDNSLabelLength {
+			return fmt.Errorf("the label %q of the name %q is %d bytes long, longer than the maximum of %d", label, name,
+				len(label), maxDNSLabelLength)
+		}
+	}
+
+	return nil
+}
@@ -16,24 +16,40 @@ limitations under the License.
 package controller
 
 import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/submariner-io/admiral/pkg/federate"
 	"github.com/submariner-io/admiral/pkg/log"
 	"github.com/submariner-io/admiral/pkg/syncer"
 	"github.com/submariner-io/admiral/pkg/syncer/broker"
+	"github.com/submariner-io/admiral/pkg/workqueue"
 	lhconstants "github.com/submariner-io/lighthouse/pkg/constants"
 	corev1 "k8s.io/api/core/v1"
 	discovery "k8s.io/api/discovery/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/klog"
 	utilnet "k8s.io/utils/net"
 )
 
-func startEndpointController(localClient dynamic.Interface, restMapper meta.RESTMapper, scheme *runtime.Scheme,
-	serviceImportUID types.UID, serviceImportName, serviceImportNameSpace, serviceName, clusterID string) (*EndpointController, error) {
+func startEndpointController(localClient dynamic.Interface, kubeClientSet kubernetes.Interface, restMapper meta.RESTMapper,
+	scheme *runtime.Scheme, serviceImportUID types.UID, serviceImportName, serviceImportNameSpace, exportName, serviceName, clusterID string,
+	isHeadless bool, serviceSelector map[string]string, globalnetEnabled bool, endpointSelector labels.Selector,
+	refreshPeriod time.Duration, updateExportStatus exportStatusFunc) (*EndpointController, error) {
 	klog.V(log.DEBUG).Infof("Starting Endpoints controller for service %q", serviceName)
 
 	controller := &EndpointController{
@@ -41,32 +57,82 @@ func startEndpointController(localClient dynamic.Interface, restMapper meta.REST
 		serviceImportUID:             serviceImportUID,
 		serviceImportName:            serviceImportName,
 		serviceImportSourceNameSpace: serviceImportNameSpace,
+		exportName:                   exportName,
 		serviceName:                  serviceName,
+		isHeadless:                   isHeadless,
+		withoutSelector:              len(serviceSelector) == 0,
+		globalnetEnabled:             globalnetEnabled,
+		endpointSelector:             endpointSelector,
+		podSelector:                  labels.SelectorFromSet(serviceSelector).String(),
+		refreshPeriod:                refreshPeriod,
+		updateExportStatus:           updateExportStatus,
+		federator:                    broker.NewFederator(localClient, restMapper, serviceImportNameSpace, "", "ownerReferences"),
+		queue:                        workqueue.New("Endpoints -> EndpointSlice"),
 		stopCh:                       make(chan struct{}),
 	}
 
 	nameSelector := fields.OneTermEqualSelector("metadata.name", serviceName)
 
-	epsSyncer, err := syncer.NewResourceSyncer(&syncer.ResourceSyncerConfig{
-		Name:                "Endpoints -> EndpointSlice",
+	// The Endpoints are resynced periodically whenever the EndpointSlice is refreshed. The global IPs assigned to the
+	// pods of a headless service with Globalnet are exported as their pods are updated.
+	var resourcesEquivalent syncer.ResourceEquivalenceFunc
+	if refreshPeriod > 0 {
+		resourcesEquivalent = controller.endpointsEquivalent
+	}
+
+	// The Endpoints are exported from the queue, which the changes of both the Endpoints and their pods are enqueued to.
+	// Those of a service without a selector are maintained manually, as EndpointSlices or as Endpoints which Kubernetes
+	// mirrors to EndpointSlices, so its EndpointSlices are exported instead.
+	config := &syncer.ResourceSyncerConfig{
+		Name:                "Endpoints watcher",
 		SourceClient:        localClient,
 		SourceNamespace:     serviceImportNameSpace,
 		SourceFieldSelector: nameSelector.String(),
 		Direction:           syncer.LocalToRemote,
 		RestMapper:          restMapper,
-		Federator:           broker.NewFederator(localClient, restMapper, serviceImportNameSpace, "", "ownerReferences"),
+		Federator:           federate.NewNoopFederator(),
 		ResourceType:        &corev1.Endpoints{},
-		Transform:           controller.endpointsToEndpointSlice,
+		Transform:           controller.endpointsChanged,
+		ResourcesEquivalent: resourcesEquivalent,
 		Scheme:              scheme,
-	})
+		ResyncPeriod:        refreshPeriod,
+	}
+
+	if controller.withoutSelector {
+		config.Name = "Manual EndpointSlice watcher"
+		config.SourceFieldSelector = ""
+		config.SourceLabelSelector = discovery.LabelServiceName + "=" + serviceName + "," + discovery.LabelManagedBy + "!=" +
+			lhconstants.LabelValueManagedBy
+		config.ResourceType = &discovery.EndpointSlice{}
+	}
+
+	var err error
+
+	controller.endpointsSyncer, err = syncer.NewResourceSyncer(config)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := epsSyncer.Start(controller.stopCh); err != nil {
+	if controller.watchesPods() {
+		if err := controller.startPodInformer(kubeClientSet); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := controller.endpointsSyncer.Start(controller.stopCh); err != nil {
 		return nil, err
 	}
 
+	// The queue is only processed once the Endpoints are synced, so their absence isn't mistaken for their deletion. It's
+	// processed once up front to report their absence, or delete an EndpointSlice exported before they were deleted.
+	controller.enqueue()
+	controller.queue.Run(controller.stopCh, controller.processEndpoints)
+
+	go func() {
+		<-controller.stopCh
+		controller.queue.ShutDown()
+	}()
+
 	return controller, nil
 }
 
@@ -74,32 +140,452 @@ func (e *EndpointController) stop() {
 	close(e.stopCh)
 }
 
-func (e *EndpointController) endpointsToEndpointSlice(obj runtime.Object, numRequeues int, op syncer.Operation) (runtime.Object, bool) {
-	endPoints := obj.(*corev1.Endpoints)
-	endpointSliceName := endPoints.Name + "-" + e.clusterID
+// watchesPods returns whether the pods of the addresses are looked up, to match them against the endpoint selector
+// or, for a headless service, to exclude those using the host network and export their global IPs.
+func (e *EndpointController) watchesPods() bool {
+	return e.isHeadless || (e.endpointSelector != nil && !e.endpointSelector.Empty())
+}
+
+// startPodInformer starts watching the pods of the service, ie those its selector matches or, for a service without
+// a selector, all those of its namespace. The Endpoints are exported anew whenever a pod changes in a way that affects
+// them, eg its labels no longer match the endpoint selector or it's assigned a global IP.
+func (e *EndpointController) startPodInformer(kubeClientSet kubernetes.Interface) error {
+	informerFactory := informers.NewSharedInformerFactoryWithOptions(kubeClientSet, 0,
+		informers.WithNamespace(e.serviceImportSourceNameSpace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = e.podSelector
+		}))
+
+	podInformer := informerFactory.Core().V1().Pods()
+	podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(_ interface{}) {
+			e.enqueue()
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if !podsEquivalent(oldObj.(*corev1.Pod), newObj.(*corev1.Pod)) {
+				e.enqueue()
+			}
+		},
+		DeleteFunc: func(_ interface{}) {
+			e.enqueue()
+		},
+	})
+
+	e.podLister = podInformer.Lister()
+
+	informerFactory.Start(e.stopCh)
+
+	if !cache.WaitForCacheSync(e.stopCh, podInformer.Informer().HasSynced) {
+		return fmt.Errorf("failed to wait for the Pod informer of service %q to sync", e.serviceName)
+	}
+
+	return nil
+}
+
+// podsEquivalent returns whether a pod's update leaves the export of its address unchanged.
+func podsEquivalent(oldPod, newPod *corev1.Pod) bool {
+	return reflect.DeepEqual(oldPod.Labels, newPod.Labels) && oldPod.Spec.HostNetwork == newPod.Spec.HostNetwork &&
+		oldPod.GetAnnotations()[submarinerIpamGlobalIp] == newPod.GetAnnotations()[submarinerIpamGlobalIp]
+}
+
+func (e *EndpointController) endpointsChanged(_ runtime.Object, _ int, _ syncer.Operation) (runtime.Object, bool) {
+	e.enqueue()
+	return nil, false
+}
+
+func (e *EndpointController) enqueue() {
+	e.queue.Enqueue(&metav1.ObjectMeta{Name: e.serviceName, Namespace: e.serviceImportSourceNameSpace})
+}
+
+// processEndpoints exports the Endpoints of the service as an EndpointSlice, or deletes the exported EndpointSlice
+// once they're deleted.
+func (e *EndpointController) processEndpoints(_, name, namespace string) (bool, error) {
+	endPoints, found, err := e.getEndpoints(name, namespace)
+	if err != nil {
+		return true, err
+	}
+
+	if !found {
+		e.reportEndpoints(false)
 
-	if op == syncer.Delete {
-		return &discovery.EndpointSlice{
+		err := e.federator.Delete(&discovery.EndpointSlice{
 			ObjectMeta: metav1.ObjectMeta{
-				Name:      endpointSliceName,
-				Namespace: endPoints.Namespace,
+				Name:      e.exportName + "-" + e.clusterID,
+				Namespace: namespace,
 			},
-		}, false
+		})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return true, err
+		}
+
+		return false, nil
+	}
+
+	e.reportEndpoints(hasAddresses(endPoints))
+
+	endpointSlice := e.withRefreshTime(e.endpointSliceFromEndpoints(e.withGlobalIPs(e.withoutHostNetworkAddresses(
+		e.withSelectedAddresses(endPoints)))))
+
+	if err := e.federator.Distribute(endpointSlice); err != nil {
+		return true, err
 	}
 
-	return e.endpointSliceFromEndpoints(endPoints), false
+	return false, nil
+}
+
+// getEndpoints returns the Endpoints of the service or, for a service without a selector, the equivalent of its manual
+// EndpointSlices.
+func (e *EndpointController) getEndpoints(name, namespace string) (*corev1.Endpoints, bool, error) {
+	if !e.withoutSelector {
+		obj, found, err := e.endpointsSyncer.GetResource(name, namespace)
+		if err != nil || !found {
+			return nil, found, err
+		}
+
+		return obj.(*corev1.Endpoints), true, nil
+	}
+
+	objs, err := e.endpointsSyncer.ListResources()
+	if err != nil || len(objs) == 0 {
+		return nil, false, err
+	}
+
+	endpointSlices := make([]*discovery.EndpointSlice, 0, len(objs))
+
+	for i := range objs {
+		endpointSlice := objs[i].(*discovery.EndpointSlice)
+		if e.isManualEndpointSlice(endpointSlice) {
+			endpointSlices = append(endpointSlices, endpointSlice)
+		}
+	}
+
+	if len(endpointSlices) == 0 {
+		return nil, false, nil
+	}
+
+	return endpointsFromEndpointSlices(name, namespace, endpointSlices), true, nil
+}
+
+// isManualEndpointSlice returns whether the EndpointSlice is one of the service's own rather than one Lighthouse maintains.
+func (e *EndpointController) isManualEndpointSlice(endpointSlice *discovery.EndpointSlice) bool {
+	labels := endpointSlice.GetLabels()
+	return labels[discovery.LabelServiceName] == e.serviceName && labels[discovery.LabelManagedBy] != lhconstants.LabelValueManagedBy
+}
+
+// endpointsFromEndpointSlices returns the Endpoints equivalent to EndpointSlices, with a subset for each of them in the
+// order of their names so the export is stable. The FQDN EndpointSlices are left out as only IPs are exported.
+func endpointsFromEndpointSlices(name, namespace string, endpointSlices []*discovery.EndpointSlice) *corev1.Endpoints {
+	sort.Slice(endpointSlices, func(i, j int) bool {
+		return endpointSlices[i].Name < endpointSlices[j].Name
+	})
+
+	endpoints := &corev1.Endpoints{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+
+	for _, endpointSlice := range endpointSlices {
+		if endpointSlice.AddressType == discovery.AddressTypeFQDN {
+			continue
+		}
+
+		subset := corev1.EndpointSubset{}
+
+		for _, port := range endpointSlice.Ports {
+			endpointPort := corev1.EndpointPort{}
+			if port.Name != nil {
+				endpointPort.Name = *port.Name
+			}
+
+			if port.Port != nil {
+				endpointPort.Port = *port.Port
+			}
+
+			if port.Protocol != nil {
+				endpointPort.Protocol = *port.Protocol
+			}
+
+			subset.Ports = append(subset.Ports, endpointPort)
+		}
+
+		for i := range endpointSlice.Endpoints {
+			endpoint := &endpointSlice.Endpoints[i]
+
+			for _, ip := range endpoint.Addresses {
+				address := corev1.EndpointAddress{IP: ip, TargetRef: endpoint.TargetRef}
+				if endpoint.Hostname != nil {
+					address.Hostname = *endpoint.Hostname
+				}
+
+				if nodeName, ok := endpoint.Topology[corev1.LabelHostname]; ok {
+					address.NodeName = &nodeName
+				}
+
+				if endpoint.Conditions.Ready == nil || *endpoint.Conditions.Ready {
+					subset.Addresses = append(subset.Addresses, address)
+				} else {
+					subset.NotReadyAddresses = append(subset.NotReadyAddresses, address)
+				}
+			}
+		}
+
+		endpoints.Subsets = append(endpoints.Subsets, subset)
+	}
+
+	return endpoints
+}
+
+// reportEndpoints updates the ServiceExport status of a service without a selector whenever whether it has endpoints
+// changes, as nothing else populates its Endpoints.
+func (e *EndpointController) reportEndpoints(hasEndpoints bool) {
+	if !e.withoutSelector || (e.endpointsReported && e.hasEndpoints == hasEndpoints) {
+		return
+	}
+
+	e.endpointsReported = true
+	e.hasEndpoints = hasEndpoints
+
+	if hasEndpoints {
+		e.updateExportStatus(e.exportName, e.serviceImportSourceNameSpace, serviceExportNoEndpoints, corev1.ConditionFalse,
+			"ManualEndpoints", "The manually maintained endpoints of the Service without a selector are exported")
+	} else {
+		e.updateExportStatus(e.exportName, e.serviceImportSourceNameSpace, serviceExportNoEndpoints, corev1.ConditionTrue,
+			"NoSelectorOrEndpoints", "The Service has neither a selector nor any endpoints")
+	}
+}
+
+func hasAddresses(endpoints *corev1.Endpoints) bool {
+	for i := range endpoints.Subsets {
+		if len(endpoints.Subsets[i].Addresses) > 0 || len(endpoints.Subsets[i].NotReadyAddresses) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// withoutHostNetworkAddresses returns the Endpoints with the addresses of host-networked pods removed for headless
+// services. Such addresses are node IPs which aren't reachable from other clusters so returning them in DNS answers
+// would silently break clients. The ServiceExport status is updated whenever the presence of such addresses changes.
+func (e *EndpointController) withoutHostNetworkAddresses(endpoints *corev1.Endpoints) *corev1.Endpoints {
+	if !e.isHeadless {
+		return endpoints
+	}
+
+	filtered := endpoints.DeepCopy()
+	excluded := []string{}
+
+	for i := range filtered.Subsets {
+		subset := &filtered.Subsets[i]
+		subset.Addresses = e.filterHostNetworkAddresses(endpoints.Namespace, subset.Addresses, &excluded)
+		subset.NotReadyAddresses = e.filterHostNetworkAddresses(endpoints.Namespace, subset.NotReadyAddresses, &excluded)
+	}
+
+	hasHostNetworkEndpoints := len(excluded) > 0
+	if hasHostNetworkEndpoints != e.hasHostNetworkEndpoints {
+		e.hasHostNetworkEndpoints = hasHostNetworkEndpoints
+
+		if hasHostNetworkEndpoints {
+			e.updateExportStatus(e.exportName, e.serviceImportSourceNameSpace, serviceExportHostNetwork, corev1.ConditionTrue,
+				"HostNetworkEndpoints", fmt.Sprintf("Endpoints %v of host-networked pods are not reachable across the "+
+					"clusterset and were excluded", excluded))
+		} else {
+			e.updateExportStatus(e.exportName, e.serviceImportSourceNameSpace, serviceExportHostNetwork, corev1.ConditionFalse,
+				"NoHostNetworkEndpoints", "No endpoints of host-networked pods are excluded")
+		}
+	}
+
+	return filtered
+}
+
+func (e *EndpointController) filterHostNetworkAddresses(namespace string, addresses []corev1.EndpointAddress,
+	excluded *[]string) []corev1.EndpointAddress {
+	filtered := []corev1.EndpointAddress{}
+
+	for _, address := range addresses {
+		if e.isHostNetworked(namespace, address) {
+			*excluded = append(*excluded, address.IP)
+			continue
+		}
+
+		filtered = append(filtered, address)
+	}
+
+	return filtered
+}
+
+func (e *EndpointController) isHostNetworked(namespace string, address corev1.EndpointAddress) bool {
+	pod, err := e.getPod(namespace, address)
+	if err != nil {
+		klog.V(log.DEBUG).Infof("Unable to retrieve the Pod of endpoint %s: %v", address.IP, err)
+		return false
+	}
+
+	return pod != nil && pod.Spec.HostNetwork
+}
+
+// withGlobalIPs returns the Endpoints with the addresses of the pods replaced by their global IPs for headless services
+// with Globalnet, as the pod IPs aren't reachable across the clusterset then. A ready address whose pod isn't assigned a
+// global IP yet is exported as not ready until it is, which the pod's update re-exports. Globalnet only assigns global
+// IPs to pods so the addresses that don't belong to one, eg those maintained manually, are exported as they are.
+func (e *EndpointController) withGlobalIPs(endpoints *corev1.Endpoints) *corev1.Endpoints {
+	if !e.globalnetEnabled || !e.isHeadless {
+		return endpoints
+	}
+
+	filtered := endpoints.DeepCopy()
+	pending := []string{}
+
+	for i := range filtered.Subsets {
+		subset := &filtered.Subsets[i]
+		ready := []corev1.EndpointAddress{}
+
+		for _, address := range subset.Addresses {
+			globalIP, isPod := e.getGlobalIP(endpoints.Namespace, address)
+
+			switch {
+			case !isPod:
+				ready = append(ready, address)
+			case globalIP != "":
+				address.IP = globalIP
+				ready = append(ready, address)
+			default:
+				pending = append(pending, address.IP)
+				subset.NotReadyAddresses = append(subset.NotReadyAddresses, address)
+			}
+		}
+
+		subset.Addresses = ready
+
+		for j := range subset.NotReadyAddresses {
+			if globalIP, _ := e.getGlobalIP(endpoints.Namespace, subset.NotReadyAddresses[j]); globalIP != "" {
+				subset.NotReadyAddresses[j].IP = globalIP
+			}
+		}
+	}
+
+	if len(pending) > 0 {
+		klog.V(log.DEBUG).Infof("The endpoints %v of Service \"%s/%s\" are exported as not ready as their pods don't "+
+			"have a global IP yet", pending, endpoints.Namespace, endpoints.Name)
+	}
+
+	return filtered
+}
+
+// getGlobalIP returns the global IP assigned to the pod the address belongs to, if any, and whether it belongs to a pod.
+func (e *EndpointController) getGlobalIP(namespace string, address corev1.EndpointAddress) (string, bool) {
+	if address.TargetRef == nil || address.TargetRef.Kind != "Pod" {
+		return "", false
+	}
+
+	pod, err := e.getPod(namespace, address)
+	if err != nil {
+		klog.V(log.DEBUG).Infof("Unable to retrieve the Pod of endpoint %s to get its global IP: %v", address.IP, err)
+		return "", true
+	}
+
+	return pod.GetAnnotations()[submarinerIpamGlobalIp], true
+}
+
+// endpointsEquivalent skips the periodic resyncs of the Endpoints unless the EndpointSlice is due to be refreshed,
+// while the actual updates are always synced.
+func (e *EndpointController) endpointsEquivalent(oldObj, newObj *unstructured.Unstructured) bool {
+	return !e.isRefreshDue() && oldObj.GetResourceVersion() == newObj.GetResourceVersion()
+}
+
+// isRefreshDue returns whether the EndpointSlice is due to be refreshed on this resync. A refresh is due on the first
+// resync at least half a refresh period after the last one, so an update shortly before the resync doesn't delay the
+// refresh by a whole period.
+func (e *EndpointController) isRefreshDue() bool {
+	if e.refreshPeriod <= 0 {
+		return false
+	}
+
+	return time.Since(time.Unix(0, atomic.LoadInt64(&e.lastRefreshed))) >= e.refreshPeriod/2
+}
+
+// withRefreshTime stamps the EndpointSlice with the time it's refreshed at, if refreshes are enabled.
+func (e *EndpointController) withRefreshTime(endpointSlice *discovery.EndpointSlice) *discovery.EndpointSlice {
+	if e.refreshPeriod <= 0 {
+		return endpointSlice
+	}
+
+	now := time.Now()
+	atomic.StoreInt64(&e.lastRefreshed, now.UnixNano())
+
+	endpointSlice.Annotations = map[string]string{lhconstants.AnnotationRefreshed: now.UTC().Format(time.RFC3339Nano)}
+
+	return endpointSlice
+}
+
+// exportedEndpointSelector parses the endpoint selector annotation of a ServiceExport or its ServiceImport. Without
+// the annotation, all the endpoints are selected.
+func exportedEndpointSelector(annotations map[string]string) (labels.Selector, error) {
+	return labels.Parse(annotations[lhconstants.AnnotationEndpointSelector])
+}
+
+// withSelectedAddresses returns the Endpoints with only the addresses of the pods matching the export's endpoint
+// selector, if any, eg to expose only canary pods across the clusterset. Addresses whose pod can't be retrieved are
+// removed, while those that don't belong to a pod, eg the manual endpoints of a service without a selector, are kept as
+// the selector doesn't apply to them. The pods are watched so changing their labels re-evaluates the selector.
+func (e *EndpointController) withSelectedAddresses(endpoints *corev1.Endpoints) *corev1.Endpoints {
+	if e.endpointSelector == nil || e.endpointSelector.Empty() {
+		return endpoints
+	}
+
+	filtered := endpoints.DeepCopy()
+
+	for i := range filtered.Subsets {
+		subset := &filtered.Subsets[i]
+		subset.Addresses = e.filterSelectedAddresses(endpoints.Namespace, subset.Addresses)
+		subset.NotReadyAddresses = e.filterSelectedAddresses(endpoints.Namespace, subset.NotReadyAddresses)
+	}
+
+	return filtered
+}
+
+func (e *EndpointController) filterSelectedAddresses(namespace string,
+	addresses []corev1.EndpointAddress) []corev1.EndpointAddress {
+	filtered := []corev1.EndpointAddress{}
+
+	for _, address := range addresses {
+		pod, err := e.getPod(namespace, address)
+		if err != nil {
+			klog.V(log.DEBUG).Infof("Unable to retrieve the Pod of endpoint %s to match the endpoint selector: %v",
+				address.IP, err)
+			continue
+		}
+
+		if pod == nil || e.endpointSelector.Matches(labels.Set(pod.Labels)) {
+			filtered = append(filtered, address)
+		}
+	}
+
+	return filtered
+}
+
+// getPod returns the pod the address belongs to, as watched in the service's namespace, or nil if it doesn't belong to a
+// pod.
+func (e *EndpointController) getPod(namespace string, address corev1.EndpointAddress) (*corev1.Pod, error) {
+	if address.TargetRef == nil || address.TargetRef.Kind != "Pod" {
+		return nil, nil
+	}
+
+	podNamespace := address.TargetRef.Namespace
+	if podNamespace == "" {
+		podNamespace = namespace
+	}
+
+	return e.podLister.Pods(podNamespace).Get(address.TargetRef.Name)
 }
 
 func (e *EndpointController) endpointSliceFromEndpoints(endpoints *corev1.Endpoints) *discovery.EndpointSlice {
 	endpointSlice := &discovery.EndpointSlice{}
 	controllerFlag := false
-	endpointSlice.Name = endpoints.Name + "-" + e.clusterID
+	endpointSlice.Name = e.exportName + "-" + e.clusterID
 	endpointSlice.Labels = map[string]string{
 		lhconstants.LabelServiceImportName: e.serviceImportName,
 		discovery.LabelManagedBy:           lhconstants.LabelValueManagedBy,
 		lhconstants.LabelSourceNamespace:   e.serviceImportSourceNameSpace,
 		lhconstants.LabelSourceCluster:     e.clusterID,
-		lhconstants.LabelSourceName:        e.serviceName,
+		lhconstants.LabelSourceName:        e.exportName,
 	}
 	endpointSlice.OwnerReferences = []metav1.OwnerReference{{
 		APIVersion:         "lighthouse.submariner.io.v2alpha1",
@@ -112,20 +598,35 @@ func (e *EndpointController) endpointSliceFromEndpoints(endpoints *corev1.Endpoi
 
 	endpointSlice.AddressType = discovery.AddressTypeIPv4
 
-	if len(endpoints.Subsets) > 0 {
-		subset := endpoints.Subsets[0]
-		for i := range subset.Ports {
+	// A service selecting several workloads whose ports differ has a subset per distinct set of ports. All their
+	// addresses are exported, with the union of their ports.
+	allAddresses := []corev1.EndpointAddress{}
+	for i := range endpoints.Subsets {
+		allAddresses = append(allAddresses, endpoints.Subsets[i].Addresses...)
+		allAddresses = append(allAddresses, endpoints.Subsets[i].NotReadyAddresses...)
+	}
+
+	if allAddressesIPv6(allAddresses) {
+		endpointSlice.AddressType = discovery.AddressTypeIPv6
+	}
+
+	ports := map[corev1.EndpointPort]bool{}
+
+	for i := range endpoints.Subsets {
+		subset := &endpoints.Subsets[i]
+		for j := range subset.Ports {
+			if ports[subset.Ports[j]] {
+				continue
+			}
+
+			ports[subset.Ports[j]] = true
 			endpointSlice.Ports = append(endpointSlice.Ports, discovery.EndpointPort{
-				Port:     &subset.Ports[i].Port,
-				Name:     &subset.Ports[i].Name,
-				Protocol: &subset.Ports[i].Protocol,
+				Port:     &subset.Ports[j].Port,
+				Name:     &subset.Ports[j].Name,
+				Protocol: &subset.Ports[j].Protocol,
 			})
 		}
 
-		if allAddressesIPv6(append(subset.Addresses, subset.NotReadyAddresses...)) {
-			endpointSlice.AddressType = discovery.AddressTypeIPv6
-		}
-
 		endpointSlice.Endpoints = append(endpointSlice.Endpoints, getEndpointsFromAddresses(subset.Addresses, endpointSlice.AddressType, true)...)
 		endpointSlice.Endpoints = append(endpointSlice.Endpoints, getEndpointsFromAddresses(subset.NotReadyAddresses,
 			endpointSlice.AddressType, false)...)
@@ -0,0 +1,87 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"time"
+
+	lhconstants "github.com/submariner-io/lighthouse/pkg/constants"
+	discovery "k8s.io/api/discovery/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
+)
+
+// startOrphanedEndpointSliceCollection periodically collects the orphaned imported EndpointSlices, every half the max
+// age, until stopCh is closed. It's disabled if the max age isn't positive.
+func (a *Controller) startOrphanedEndpointSliceCollection(stopCh <-chan struct{}) {
+	if a.orphanedEndpointSliceMaxAge <= 0 {
+		return
+	}
+
+	klog.Infof("Imported EndpointSlices without a ServiceImport for more than %v will be deleted",
+		a.orphanedEndpointSliceMaxAge)
+
+	go wait.Until(a.collectOrphanedEndpointSlices, a.orphanedEndpointSliceMaxAge/2, stopCh)
+}
+
+// collectOrphanedEndpointSlices deletes the EndpointSlices imported from other clusters whose ServiceImport doesn't
+// exist locally, which happens if the deletion of their source service was missed. Only slices created more than the
+// max age ago are deleted, so slices imported just before their ServiceImport aren't.
+func (a *Controller) collectOrphanedEndpointSlices() {
+	objs, err := a.endpointSliceSyncer.ListLocalResources(&discovery.EndpointSlice{})
+	if err != nil {
+		klog.Errorf("Error listing the EndpointSlices to collect the orphaned ones: %v", err)
+		return
+	}
+
+	now := time.Now()
+
+	for _, obj := range objs {
+		endpointSlice := obj.(*discovery.EndpointSlice)
+		labels := endpointSlice.GetLabels()
+
+		if labels[discovery.LabelManagedBy] != lhconstants.LabelValueManagedBy ||
+			labels[lhconstants.LabelSourceCluster] == a.clusterID {
+			continue
+		}
+
+		if now.Sub(endpointSlice.CreationTimestamp.Time) <= a.orphanedEndpointSliceMaxAge {
+			continue
+		}
+
+		_, found, err := a.serviceImportSyncer.GetLocalResource(labels[lhconstants.LabelServiceImportName], a.namespace,
+			&mcsv1a1.ServiceImport{})
+		if err != nil || found {
+			continue
+		}
+
+		klog.Warningf("Deleting EndpointSlice \"%s/%s\" imported from cluster %q as its ServiceImport %q doesn't exist",
+			endpointSlice.Namespace, endpointSlice.Name, labels[lhconstants.LabelSourceCluster],
+			labels[lhconstants.LabelServiceImportName])
+
+		err = a.endpointSliceClient.Namespace(endpointSlice.Namespace).Delete(endpointSlice.Name, &metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			klog.Errorf("Error deleting orphaned EndpointSlice \"%s/%s\": %v", endpointSlice.Namespace,
+				endpointSlice.Name, err)
+			continue
+		}
+
+		OrphanedEndpointSlicesDeleted.WithLabelValues(labels[lhconstants.LabelSourceCluster]).Inc()
+	}
+}
@@ -0,0 +1,42 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"time"
+
+	"github.com/submariner-io/admiral/pkg/syncer"
+)
+
+// SetMaxCleanupRequeues sets the number of cleanup retries for the external test package and returns a function
+// restoring the previous value.
+func SetMaxCleanupRequeues(n int) func() {
+	prev := maxCleanupRequeues
+	maxCleanupRequeues = n
+
+	return func() {
+		maxCleanupRequeues = prev
+	}
+}
+
+// NewRetryBackoffTransform wraps a transform in a retry backoff using the given clock for the external test package.
+func NewRetryBackoffTransform(baseDelay, maxDelay time.Duration, now func() time.Time,
+	transform syncer.TransformFunc) syncer.TransformFunc {
+	backoff := newRetryBackoff(baseDelay, maxDelay)
+	backoff.now = now
+
+	return backoff.wrap(transform)
+}
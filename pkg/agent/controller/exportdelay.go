@@ -0,0 +1,125 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	lhconstants "github.com/submariner-io/lighthouse/pkg/constants"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
+)
+
+// serviceExportPending is set on a ServiceExport within its export delay, while its ServiceImport is flagged as pending
+// and the importing clusters don't resolve it.
+const serviceExportPending mcsv1a1.ServiceExportConditionType = "Pending"
+
+// How long until a failed re-export of a ServiceExport past its export delay is retried.
+const reexportRetryDelay = 5 * time.Second
+
+// exportResolvableAfter returns when the ServiceExport's valid export delay elapses, or the zero time if it has none.
+// The delay is checked against this cluster's clock, that its creation time is recorded with, so the importing clusters
+// don't depend on their clocks being in sync with it.
+func exportResolvableAfter(svcExport *mcsv1a1.ServiceExport) time.Time {
+	delay, err := time.ParseDuration(svcExport.GetAnnotations()[lhconstants.AnnotationExportDelay])
+	if err != nil || delay <= 0 || svcExport.CreationTimestamp.IsZero() {
+		return time.Time{}
+	}
+
+	return svcExport.CreationTimestamp.Add(delay)
+}
+
+// isExportPending returns whether the ServiceExport is within its export delay.
+func isExportPending(svcExport *mcsv1a1.ServiceExport) bool {
+	return time.Now().Before(exportResolvableAfter(svcExport))
+}
+
+// holdDelayedExport sets the Pending condition of a ServiceExport within its export delay and schedules its re-export
+// once the delay elapsed, which then clears the pending flag of its ServiceImport.
+func (a *Controller) holdDelayedExport(svcExport *mcsv1a1.ServiceExport) {
+	resolvableAfter := exportResolvableAfter(svcExport)
+
+	remaining := time.Until(resolvableAfter)
+	if remaining <= 0 {
+		// The condition is only cleared once it was set, to keep it out of the status of the exports without a delay.
+		if lastConditionHasStatus(svcExport, serviceExportPending, corev1.ConditionTrue) {
+			a.updateExportedServiceStatus(svcExport.Name, svcExport.Namespace, serviceExportPending,
+				corev1.ConditionFalse, "", "")
+		}
+
+		return
+	}
+
+	if !lastConditionHasStatus(svcExport, serviceExportPending, corev1.ConditionTrue) {
+		a.updateExportedServiceStatus(svcExport.Name, svcExport.Namespace, serviceExportPending, corev1.ConditionTrue,
+			"ExportDelayed", fmt.Sprintf("The service isn't resolved by the clusterset until %s",
+				resolvableAfter.UTC().Format(time.RFC3339)))
+	}
+
+	a.scheduleReexport(svcExport.Name, svcExport.Namespace, remaining)
+}
+
+// scheduleReexport re-exports the ServiceExport after the given time, replacing any re-export already scheduled.
+func (a *Controller) scheduleReexport(name, namespace string, after time.Duration) {
+	key := namespace + "/" + name
+
+	a.reexportTimersMutex.Lock()
+	defer a.reexportTimersMutex.Unlock()
+
+	if timer, ok := a.reexportTimers[key]; ok {
+		timer.Stop()
+	}
+
+	a.reexportTimers[key] = time.AfterFunc(after, func() {
+		a.reexportTimersMutex.Lock()
+		delete(a.reexportTimers, key)
+		a.reexportTimersMutex.Unlock()
+
+		a.reexportServiceExport(name, namespace)
+	})
+}
+
+// stopReexports stops the scheduled re-exports, once the agent is stopped.
+func (a *Controller) stopReexports() {
+	a.reexportTimersMutex.Lock()
+	defer a.reexportTimersMutex.Unlock()
+
+	for key, timer := range a.reexportTimers {
+		timer.Stop()
+		delete(a.reexportTimers, key)
+	}
+}
+
+// reexportServiceExport re-exports a ServiceExport whose export delay elapsed, as it isn't updated then.
+func (a *Controller) reexportServiceExport(name, namespace string) {
+	obj, found, err := a.serviceExportSyncer.GetResource(name, namespace)
+	if err != nil {
+		klog.Errorf("Error retrieving the ServiceExport (%s/%s) to re-export: %v", namespace, name, err)
+		a.scheduleReexport(name, namespace, reexportRetryDelay)
+
+		return
+	}
+
+	if !found {
+		return
+	}
+
+	if a.reexport(obj.(*mcsv1a1.ServiceExport), 0) {
+		a.scheduleReexport(name, namespace, reexportRetryDelay)
+	}
+}
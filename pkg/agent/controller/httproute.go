@@ -0,0 +1,168 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/submariner-io/admiral/pkg/federate"
+	"github.com/submariner-io/admiral/pkg/syncer"
+	"github.com/submariner-io/admiral/pkg/syncer/broker"
+	lhconstants "github.com/submariner-io/lighthouse/pkg/constants"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog"
+	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
+)
+
+// httpRouteType is the Gateway API HTTPRoute, which isn't a vendored type so it's handled unstructured.
+var httpRouteType = &unstructured.Unstructured{Object: map[string]interface{}{
+	"apiVersion": "gateway.networking.k8s.io/v1",
+	"kind":       "HTTPRoute",
+}}
+
+func (a *Controller) newHTTPRouteSyncer(syncerConf broker.SyncerConfig) error {
+	var err error
+
+	a.httpRouteSyncer, err = syncer.NewResourceSyncer(&syncer.ResourceSyncerConfig{
+		Name:            "HTTPRoute -> ServiceExport",
+		SourceClient:    syncerConf.LocalClient,
+		SourceNamespace: metav1.NamespaceAll,
+		Direction:       syncer.RemoteToLocal,
+		RestMapper:      syncerConf.RestMapper,
+		Federator:       federate.NewNoopFederator(),
+		ResourceType:    httpRouteType,
+		Transform:       a.httpRouteToServiceExports,
+		Scheme:          syncerConf.Scheme,
+	})
+
+	return err
+}
+
+// getExportedServiceName returns the name of the Service exported by the ServiceExport: the backend of the HTTPRoute
+// it references, if any, else the Service named like the export.
+func (a *Controller) getExportedServiceName(svcExport *mcsv1a1.ServiceExport) (string, error) {
+	routeName, ok := svcExport.GetAnnotations()[lhconstants.AnnotationHTTPRoute]
+	if !ok {
+		return svcExport.Name, nil
+	}
+
+	if a.httpRouteSyncer == nil {
+		return "", fmt.Errorf("the %q annotation requires the Gateway API routes to be enabled",
+			lhconstants.AnnotationHTTPRoute)
+	}
+
+	obj, found, err := a.httpRouteSyncer.GetResource(routeName, svcExport.Namespace)
+	if err != nil {
+		return "", errors.Wrapf(err, "error retrieving the HTTPRoute %q", routeName)
+	}
+
+	if !found {
+		return "", fmt.Errorf("the HTTPRoute %q doesn't exist", routeName)
+	}
+
+	backends := routeBackends(obj.(*unstructured.Unstructured))
+	if len(backends) != 1 {
+		return "", fmt.Errorf("the HTTPRoute %q must have exactly one backend Service in its namespace but has %v",
+			routeName, backends)
+	}
+
+	return backends[0], nil
+}
+
+// routeBackends returns the distinct Services in the HTTPRoute's namespace its rules refer to, in order. Only the
+// backends are resolved - the routing itself, eg the matches, filters and weights, isn't applied.
+func routeBackends(route *unstructured.Unstructured) []string {
+	backends := []string{}
+	seen := map[string]bool{}
+
+	rules, _, _ := unstructured.NestedSlice(route.Object, "spec", "rules")
+	for _, r := range rules {
+		rule, ok := r.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		refs, _, _ := unstructured.NestedSlice(rule, "backendRefs")
+		for _, r := range refs {
+			ref, ok := r.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			group, _, _ := unstructured.NestedString(ref, "group")
+			kind, _, _ := unstructured.NestedString(ref, "kind")
+			namespace, _, _ := unstructured.NestedString(ref, "namespace")
+			name, _, _ := unstructured.NestedString(ref, "name")
+
+			if group != "" || (kind != "" && kind != "Service") || (namespace != "" && namespace != route.GetNamespace()) ||
+				name == "" || seen[name] {
+				continue
+			}
+
+			seen[name] = true
+			backends = append(backends, name)
+		}
+	}
+
+	return backends
+}
+
+// httpRouteToServiceExports re-exports the ServiceExports referencing the HTTPRoute so they follow its backend, or
+// withdraws them once it's deleted.
+func (a *Controller) httpRouteToServiceExports(obj runtime.Object, numRequeues int,
+	op syncer.Operation) (runtime.Object, bool) {
+	route := obj.(*unstructured.Unstructured)
+
+	exports, err := a.serviceExportSyncer.ListResources()
+	if err != nil {
+		klog.Errorf("Error listing the ServiceExports for HTTPRoute (%s/%s): %v", route.GetNamespace(), route.GetName(), err)
+		return nil, true
+	}
+
+	requeue := false
+	federator := a.serviceImportSyncer.GetLocalFederator()
+
+	for _, obj := range exports {
+		svcExport := obj.(*mcsv1a1.ServiceExport)
+		if svcExport.Namespace != route.GetNamespace() ||
+			svcExport.GetAnnotations()[lhconstants.AnnotationHTTPRoute] != route.GetName() {
+			continue
+		}
+
+		if op == syncer.Delete {
+			err := deleteIfExists(federator, a.newServiceImport(svcExport))
+			if err != nil {
+				klog.Errorf("Error withdrawing the ServiceExport (%s/%s): %v", svcExport.Namespace, svcExport.Name, err)
+				requeue = true
+
+				continue
+			}
+
+			a.updateExportedServiceStatus(svcExport.Name, svcExport.Namespace, mcsv1a1.ServiceExportValid,
+				corev1.ConditionFalse, invalidHTTPRoute, fmt.Sprintf("The HTTPRoute %q doesn't exist", route.GetName()))
+
+			continue
+		}
+
+		requeue = a.reexport(svcExport, numRequeues) || requeue
+	}
+
+	return nil, requeue
+}
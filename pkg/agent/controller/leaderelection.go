@@ -0,0 +1,272 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/submariner-io/admiral/pkg/syncer"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog"
+)
+
+const leaderElectionLease = "lighthouse-agent"
+
+var errAgentStopped = errors.New("the agent is stopped")
+
+// leadership holds back the writes of a leader-elected agent while it doesn't hold the lease. The agent runs, and
+// keeps its caches synced, as a follower too, so its writes block until it leads rather than it only being started
+// then. An agent that isn't leader-elected always leads.
+type leadership struct {
+	mutex sync.Mutex
+	// Closed while the agent leads, replaced once it steps down.
+	leading   chan struct{}
+	isLeading bool
+	stopCh    <-chan struct{}
+}
+
+func newLeadership() *leadership {
+	l := &leadership{leading: make(chan struct{})}
+	l.lead(context.Background())
+
+	return l
+}
+
+// follow holds back the writes until the agent leads, or stopCh is closed, in which case they fail.
+func (l *leadership) follow(stopCh <-chan struct{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.stopCh = stopCh
+	l.stepDownLocked()
+}
+
+// lead releases the writes, unless the given context of the leadership already ended, ie the lease was lost since.
+func (l *leadership) lead(ctx context.Context) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if ctx.Err() == nil && !l.isLeading {
+		l.isLeading = true
+		close(l.leading)
+	}
+}
+
+func (l *leadership) stepDown() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.stepDownLocked()
+}
+
+func (l *leadership) stepDownLocked() {
+	if l.isLeading {
+		l.isLeading = false
+		l.leading = make(chan struct{})
+	}
+}
+
+// await blocks until the agent leads. It returns false if the agent is stopped first.
+func (l *leadership) await() bool {
+	l.mutex.Lock()
+	leading, stopCh := l.leading, l.stopCh
+	l.mutex.Unlock()
+
+	select {
+	case <-leading:
+		return true
+	case <-stopCh:
+		return false
+	}
+}
+
+// gate returns a transform that waits for the agent to lead before running the given one, or syncing the resource as
+// is if it's nil, for the syncers writing to the broker with a client that isn't gated.
+func (l *leadership) gate(transform syncer.TransformFunc) syncer.TransformFunc {
+	return func(from runtime.Object, numRequeues int, op syncer.Operation) (runtime.Object, bool) {
+		if !l.await() {
+			return nil, false
+		}
+
+		if transform == nil {
+			return from, false
+		}
+
+		return transform(from, numRequeues, op)
+	}
+}
+
+// RunLeaderElected runs the agent as one of several replicas identified by identity, of which only the one holding the
+// lease in the agent namespace writes. The agent is started straight away so a follower's caches are synced by the
+// time it acquires the lease, while its writes are held back until then. A replica losing the lease steps down, ie its
+// writes are held back again, and waits to reacquire it. It blocks until stopCh is closed, in which case the lease is
+// released so another replica takes over without waiting for it to expire. Stopping the agent leaves everything it
+// exported in place for the next leader to adopt.
+func (a *Controller) RunLeaderElected(stopCh <-chan struct{}, identity string) error {
+	a.leadership.follow(stopCh)
+
+	// Starting fails if the agent is stopped before its caches are synced, which isn't an error.
+	if err := a.Start(stopCh); err != nil {
+		select {
+		case <-stopCh:
+			return nil
+		default:
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for ctx.Err() == nil {
+		elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+			Lock: &resourcelock.LeaseLock{
+				LeaseMeta:  metav1.ObjectMeta{Name: leaderElectionLease, Namespace: a.namespace},
+				Client:     a.kubeClientSet.CoordinationV1(),
+				LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+			},
+			LeaseDuration:   a.leaseDuration,
+			RenewDeadline:   a.renewDeadline,
+			RetryPeriod:     a.retryPeriod,
+			ReleaseOnCancel: true,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(leaderCtx context.Context) {
+					klog.Infof("Replica %q acquired the %q lease - leading", identity, leaderElectionLease)
+					a.leadership.lead(leaderCtx)
+				},
+				OnStoppedLeading: func() {
+					a.leadership.stepDown()
+				},
+			},
+		})
+		if err != nil {
+			return errors.Wrap(err, "error creating the leader elector")
+		}
+
+		klog.Infof("Replica %q waiting to acquire the %q lease", identity, leaderElectionLease)
+
+		elector.Run(ctx)
+
+		if ctx.Err() == nil {
+			klog.Warningf("Replica %q lost the %q lease - stepping down", identity, leaderElectionLease)
+		}
+	}
+
+	return nil
+}
+
+// leaderGatedClient is a dynamic client whose writes are held back while the agent doesn't lead.
+type leaderGatedClient struct {
+	dynamic.Interface
+	leadership *leadership
+}
+
+type leaderGatedNamespaceableResource struct {
+	leaderGatedResource
+	namespaceable dynamic.NamespaceableResourceInterface
+}
+
+type leaderGatedResource struct {
+	dynamic.ResourceInterface
+	leadership *leadership
+}
+
+// newLeaderGatedClient wraps the given dynamic client so its writes wait for the agent to lead.
+func newLeaderGatedClient(client dynamic.Interface, leadership *leadership) dynamic.Interface {
+	return &leaderGatedClient{Interface: client, leadership: leadership}
+}
+
+func (c *leaderGatedClient) Resource(gvr schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	resource := c.Interface.Resource(gvr)
+
+	return &leaderGatedNamespaceableResource{
+		leaderGatedResource: leaderGatedResource{ResourceInterface: resource, leadership: c.leadership},
+		namespaceable:       resource,
+	}
+}
+
+func (r *leaderGatedNamespaceableResource) Namespace(namespace string) dynamic.ResourceInterface {
+	return &leaderGatedResource{ResourceInterface: r.namespaceable.Namespace(namespace), leadership: r.leadership}
+}
+
+func (r *leaderGatedResource) Create(obj *unstructured.Unstructured, options metav1.CreateOptions,
+	subresources ...string) (*unstructured.Unstructured, error) {
+	if !r.leadership.await() {
+		return nil, errAgentStopped
+	}
+
+	return r.ResourceInterface.Create(obj, options, subresources...)
+}
+
+func (r *leaderGatedResource) Update(obj *unstructured.Unstructured, options metav1.UpdateOptions,
+	subresources ...string) (*unstructured.Unstructured, error) {
+	if !r.leadership.await() {
+		return nil, errAgentStopped
+	}
+
+	return r.ResourceInterface.Update(obj, options, subresources...)
+}
+
+func (r *leaderGatedResource) UpdateStatus(obj *unstructured.Unstructured,
+	options metav1.UpdateOptions) (*unstructured.Unstructured, error) {
+	if !r.leadership.await() {
+		return nil, errAgentStopped
+	}
+
+	return r.ResourceInterface.UpdateStatus(obj, options)
+}
+
+func (r *leaderGatedResource) Delete(name string, options *metav1.DeleteOptions, subresources ...string) error {
+	if !r.leadership.await() {
+		return errAgentStopped
+	}
+
+	return r.ResourceInterface.Delete(name, options, subresources...)
+}
+
+func (r *leaderGatedResource) DeleteCollection(options *metav1.DeleteOptions, listOptions metav1.ListOptions) error {
+	if !r.leadership.await() {
+		return errAgentStopped
+	}
+
+	return r.ResourceInterface.DeleteCollection(options, listOptions)
+}
+
+func (r *leaderGatedResource) Patch(name string, pt types.PatchType, data []byte, options metav1.PatchOptions,
+	subresources ...string) (*unstructured.Unstructured, error) {
+	if !r.leadership.await() {
+		return nil, errAgentStopped
+	}
+
+	return r.ResourceInterface.Patch(name, pt, data, options, subresources...)
+}
@@ -75,6 +75,7 @@ func (c *LHServiceExportController) LHtoMCSServiceExport(obj runtime.Object, num
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      objMeta.GetName(),
 			Namespace: objMeta.GetNamespace(),
+			Labels:    objMeta.GetLabels(),
 		},
 	}
 
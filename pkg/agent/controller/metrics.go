@@ -0,0 +1,49 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/submariner-io/lighthouse/pkg/constants"
+)
+
+// OrphanedEndpointSlicesDeleted counts, per source cluster, the imported EndpointSlices deleted as their ServiceImport
+// no longer exists.
+var OrphanedEndpointSlicesDeleted = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: constants.MetricsNamespace,
+	Name:      "orphaned_endpointslices_deleted_total",
+	Help:      "Number of imported EndpointSlices deleted as their ServiceImport no longer exists.",
+}, []string{"source_cluster"})
+
+// ThrottledWrites counts, per API server and resource, the writes rejected with a 429 Too Many Requests response.
+var ThrottledWrites = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: constants.MetricsNamespace,
+	Name:      "throttled_writes_total",
+	Help:      "Number of writes rejected by the API server with 429 Too Many Requests.",
+}, []string{"api_server", "resource"})
+
+// ImportWriteErrors counts, per resource, the failed updates of the imported resources, by the API status reason, eg
+// "Conflict" for the conflicts expected while two agents briefly overlap, or "Unknown" for an error without one.
+var ImportWriteErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: constants.MetricsNamespace,
+	Name:      "import_write_errors_total",
+	Help:      "Number of failed updates of imported resources, by API status reason.",
+}, []string{"resource", "reason"})
+
+// Collectors returns the metrics maintained by the agent controllers.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{OrphanedEndpointSlicesDeleted, ThrottledWrites, ImportWriteErrors}
+}
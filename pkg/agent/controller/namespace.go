@@ -0,0 +1,89 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"github.com/pkg/errors"
+	"github.com/submariner-io/admiral/pkg/workqueue"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
+)
+
+// startNamespaceInformer starts watching the namespaces, whose termination is checked against the cache. The exports
+// of a namespace are withdrawn as soon as it starts terminating, rather than once its Services are deleted.
+func (a *Controller) startNamespaceInformer(stopCh <-chan struct{}) error {
+	a.namespaceQueue = workqueue.New("Namespace termination")
+
+	informerFactory := informers.NewSharedInformerFactory(a.kubeClientSet, 0)
+
+	namespaceInformer := informerFactory.Core().V1().Namespaces()
+	namespaceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: a.enqueueTerminatingNamespace,
+		UpdateFunc: func(_, newObj interface{}) {
+			a.enqueueTerminatingNamespace(newObj)
+		},
+	})
+
+	a.namespaceLister = namespaceInformer.Lister()
+
+	informerFactory.Start(stopCh)
+
+	if !cache.WaitForCacheSync(stopCh, namespaceInformer.Informer().HasSynced) {
+		return errors.New("failed to wait for the Namespace informer to sync")
+	}
+
+	a.namespaceQueue.Run(stopCh, a.withdrawNamespaceExports)
+
+	go func() {
+		<-stopCh
+		a.namespaceQueue.ShutDown()
+	}()
+
+	return nil
+}
+
+func (a *Controller) enqueueTerminatingNamespace(obj interface{}) {
+	namespace := obj.(*corev1.Namespace)
+	if isTerminating(namespace) {
+		a.namespaceQueue.Enqueue(&metav1.ObjectMeta{Name: namespace.Name})
+	}
+}
+
+// withdrawNamespaceExports withdraws the ServiceExports of a terminating namespace that are still exported, ie that
+// still have the finalizer.
+func (a *Controller) withdrawNamespaceExports(key, name, _ string) (bool, error) {
+	exports, err := a.serviceExportSyncer.ListResources()
+	if err != nil {
+		return true, errors.Wrapf(err, "error listing the ServiceExports of terminating namespace %q", name)
+	}
+
+	requeue := false
+
+	for _, obj := range exports {
+		svcExport := obj.(*mcsv1a1.ServiceExport)
+		if svcExport.Namespace != name || !hasFinalizer(svcExport) {
+			continue
+		}
+
+		_, retry := a.withdrawServiceExport(svcExport, a.namespaceQueue.NumRequeues(key))
+		requeue = requeue || retry
+	}
+
+	return requeue, nil
+}
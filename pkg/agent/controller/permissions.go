@@ -0,0 +1,69 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"github.com/submariner-io/lighthouse/pkg/rbac"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RequiredPermissions returns the permissions the agent needs in the local cluster with the given spec, which depend on
+// the features it enables.
+func RequiredPermissions(spec *AgentSpecification) []rbac.Permission {
+	permissions := rbac.ResourcePermissions("multicluster.x-k8s.io", "serviceimports", metav1.NamespaceAll,
+		"get", "list", "watch", "create", "update", "delete")
+	permissions = append(permissions, rbac.ResourcePermissions("discovery.k8s.io", "endpointslices", metav1.NamespaceAll,
+		"get", "list", "watch", "create", "update", "delete")...)
+
+	if !spec.NoExport {
+		permissions = append(permissions, rbac.ResourcePermissions("multicluster.x-k8s.io", "serviceexports",
+			metav1.NamespaceAll, "get", "list", "watch", "update")...)
+		permissions = append(permissions, rbac.Permission{Verb: "update", Group: "multicluster.x-k8s.io",
+			Resource: "serviceexports", Subresource: "status", Namespace: metav1.NamespaceAll})
+		permissions = append(permissions, rbac.ResourcePermissions("", "services", metav1.NamespaceAll,
+			"get", "list", "watch")...)
+		permissions = append(permissions, rbac.ResourcePermissions("", "endpoints", metav1.NamespaceAll,
+			"get", "list", "watch")...)
+		// To withdraw the exports of the namespaces being deleted.
+		permissions = append(permissions, rbac.ResourcePermissions("", "namespaces", metav1.NamespaceAll,
+			"list", "watch")...)
+		// To select the exported endpoints by the labels of their pods and, with Globalnet, to export their global IPs.
+		permissions = append(permissions, rbac.ResourcePermissions("", "pods", metav1.NamespaceAll, "list", "watch")...)
+	}
+
+	if spec.AutoExport {
+		permissions = append(permissions, rbac.ResourcePermissions("multicluster.x-k8s.io", "serviceexports",
+			metav1.NamespaceAll, "create", "delete")...)
+	}
+
+	if spec.GatewayAPIRoutes {
+		permissions = append(permissions, rbac.ResourcePermissions("gateway.networking.k8s.io", "httproutes",
+			metav1.NamespaceAll, "get", "list", "watch")...)
+	}
+
+	if !spec.NoExport && spec.ClusterSetReadiness {
+		// To evaluate the clusterset readiness of the exported services against the connected clusters.
+		permissions = append(permissions, rbac.ResourcePermissions("submariner.io", "gateways", metav1.NamespaceAll,
+			"list", "watch")...)
+	}
+
+	if spec.LeaderElection {
+		permissions = append(permissions, rbac.ResourcePermissions("coordination.k8s.io", "leases", spec.Namespace,
+			"get", "create", "update")...)
+	}
+
+	return permissions
+}
@@ -0,0 +1,153 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/submariner-io/admiral/pkg/syncer/test"
+	"github.com/submariner-io/lighthouse/pkg/agent/controller"
+	lhconstants "github.com/submariner-io/lighthouse/pkg/constants"
+	"github.com/submariner-io/lighthouse/pkg/rbac"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakeKubeClient "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/testing"
+)
+
+var _ = Describe("Required permissions", func() {
+	var spec *controller.AgentSpecification
+
+	BeforeEach(func() {
+		spec = &controller.AgentSpecification{Namespace: "submariner-operator"}
+	})
+
+	resources := func() map[string]bool {
+		found := map[string]bool{}
+		for _, p := range controller.RequiredPermissions(spec) {
+			found[p.Resource] = true
+		}
+
+		return found
+	}
+
+	When("no optional features are enabled", func() {
+		It("should require the exported and imported resources only", func() {
+			Expect(resources()).To(Equal(map[string]bool{"serviceimports": true, "endpointslices": true,
+				"serviceexports": true, "services": true, "endpoints": true, "namespaces": true, "pods": true}))
+		})
+	})
+
+	When("services are only imported", func() {
+		BeforeEach(func() {
+			spec.NoExport = true
+		})
+
+		It("should not require the exported resources", func() {
+			Expect(resources()).To(Equal(map[string]bool{"serviceimports": true, "endpointslices": true}))
+		})
+	})
+
+	When("services are exported", func() {
+		It("should require updating the ServiceExport status", func() {
+			Expect(controller.RequiredPermissions(spec)).To(ContainElement(rbac.Permission{Verb: "update",
+				Group: "multicluster.x-k8s.io", Resource: "serviceexports", Subresource: "status"}))
+		})
+	})
+
+	When("the clusterset readiness is enabled", func() {
+		BeforeEach(func() {
+			spec.ClusterSetReadiness = true
+		})
+
+		It("should require watching the Gateways", func() {
+			Expect(controller.RequiredPermissions(spec)).To(ContainElement(rbac.Permission{Verb: "watch",
+				Group: "submariner.io", Resource: "gateways"}))
+		})
+	})
+
+	When("leader election is enabled", func() {
+		BeforeEach(func() {
+			spec.LeaderElection = true
+		})
+
+		It("should require the Leases in the agent namespace", func() {
+			Expect(controller.RequiredPermissions(spec)).To(ContainElement(rbac.Permission{Verb: "create",
+				Group: "coordination.k8s.io", Resource: "leases", Namespace: "submariner-operator"}))
+		})
+	})
+})
+
+var _ = Describe("Permissions used by an exporting agent", func() {
+	var t *testDriver
+
+	BeforeEach(func() {
+		t = newTestDiver()
+		t.service.Spec.ClusterIP = corev1.ClusterIPNone
+		t.serviceExport.SetAnnotations(map[string]string{lhconstants.AnnotationEndpointSelector: "tier=public"})
+		t.endpoints.Subsets[0].Addresses[0].TargetRef = &corev1.ObjectReference{Kind: "Pod", Name: "public-pod",
+			Namespace: t.service.Namespace}
+	})
+
+	JustBeforeEach(func() {
+		_, err := t.cluster1.localKubeClient.CoreV1().Pods(t.service.Namespace).Create(&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "public-pod", Namespace: t.service.Namespace,
+				Labels: map[string]string{"app": "test", "tier": "public"}},
+		})
+		Expect(err).To(Succeed())
+
+		t.createService()
+		t.createEndpoints()
+
+		// Only the requests of the agent are checked, not those creating the resources above.
+		t.cluster1.localKubeClient.(*fakeKubeClient.Clientset).ClearActions()
+
+		t.cluster1.start(t, *t.syncerConfig)
+		t.cluster2.start(t, *t.syncerConfig)
+	})
+
+	AfterEach(func() {
+		t.afterEach()
+	})
+
+	It("should only make requests covered by its required permissions", func() {
+		t.createServiceExport()
+		t.awaitHeadlessServiceImport("")
+		test.AwaitResource(t.cluster1.localEndpointSliceClient, t.endpoints.Name+"-"+clusterID1)
+
+		actions := t.cluster1.localKubeClient.(*fakeKubeClient.Clientset).Actions()
+		Expect(actions).ToNot(BeEmpty())
+
+		permissions := controller.RequiredPermissions(&t.cluster1.agentSpec)
+		for _, action := range actions {
+			Expect(isPermitted(permissions, action)).To(BeTrue(), "Unexpected request to %s %s/%s", action.GetVerb(),
+				action.GetResource().Resource, action.GetSubresource())
+		}
+	})
+})
+
+// isPermitted returns whether the request of the action is covered by one of the permissions.
+func isPermitted(permissions []rbac.Permission, action testing.Action) bool {
+	for _, p := range permissions {
+		if p.Verb == action.GetVerb() && p.Group == action.GetResource().Group &&
+			p.Resource == action.GetResource().Resource && p.Subresource == action.GetSubresource() &&
+			(p.Namespace == metav1.NamespaceAll || p.Namespace == action.GetNamespace()) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,126 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	lhconstants "github.com/submariner-io/lighthouse/pkg/constants"
+	corev1 "k8s.io/api/core/v1"
+	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
+)
+
+// exportedPorts returns the ports of the ServiceImport for the Service, remapped as requested by the ServiceExport's
+// port remapping annotation, if any.
+func exportedPorts(svcExport *mcsv1a1.ServiceExport, svc *corev1.Service) ([]mcsv1a1.ServicePort, error) {
+	remap := map[int32]int32{}
+
+	if value, ok := svcExport.GetAnnotations()[lhconstants.AnnotationPortRemap]; ok {
+		var err error
+
+		remap, err = parsePortRemap(value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return remapServicePorts(svc.Spec.Ports, remap)
+}
+
+// parsePortRemap parses the value of the port remapping annotation, a comma-separated list of "PORT:CANONICAL" pairs,
+// into a map of each Service port to the canonical port it's presented as across the clusterset.
+func parsePortRemap(value string) (map[int32]int32, error) {
+	remap := map[int32]int32{}
+
+	for _, pair := range strings.Split(value, ",") {
+		ports := strings.Split(strings.TrimSpace(pair), ":")
+		if len(ports) != 2 {
+			return nil, fmt.Errorf("invalid port remapping %q - expected PORT:CANONICAL", pair)
+		}
+
+		from, err := parsePort(ports[0])
+		if err != nil {
+			return nil, err
+		}
+
+		to, err := parsePort(ports[1])
+		if err != nil {
+			return nil, err
+		}
+
+		if _, exists := remap[from]; exists {
+			return nil, fmt.Errorf("port %d is remapped more than once", from)
+		}
+
+		remap[from] = to
+	}
+
+	return remap, nil
+}
+
+func parsePort(s string) (int32, error) {
+	port, err := strconv.ParseInt(s, 10, 32)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+
+	return int32(port), nil
+}
+
+// remapServicePorts returns the ports of the ServiceImport for the Service ports, with those in the remapping replaced
+// by their canonical port. It fails if a remapped port isn't a Service port or if two ports end up colliding.
+func remapServicePorts(ports []corev1.ServicePort, remap map[int32]int32) ([]mcsv1a1.ServicePort, error) {
+	type portKey struct {
+		port     int32
+		protocol corev1.Protocol
+	}
+
+	remapped := map[int32]bool{}
+	exposed := map[portKey]int32{}
+	importPorts := make([]mcsv1a1.ServicePort, 0, len(ports))
+
+	for i := range ports {
+		port := ports[i].Port
+		if canonical, ok := remap[port]; ok {
+			port = canonical
+			remapped[ports[i].Port] = true
+		}
+
+		key := portKey{port: port, protocol: ports[i].Protocol}
+		if other, exists := exposed[key]; exists {
+			return nil, fmt.Errorf("ports %d and %d would both be exported as %s port %d", other, ports[i].Port,
+				ports[i].Protocol, port)
+		}
+
+		exposed[key] = ports[i].Port
+
+		importPorts = append(importPorts, mcsv1a1.ServicePort{
+			Name:     ports[i].Name,
+			Protocol: ports[i].Protocol,
+			Port:     port,
+		})
+	}
+
+	for from := range remap {
+		if !remapped[from] {
+			return nil, fmt.Errorf("remapped port %d isn't a port of the Service", from)
+		}
+	}
+
+	return importPorts, nil
+}
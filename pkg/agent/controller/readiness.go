@@ -0,0 +1,284 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"github.com/submariner-io/admiral/pkg/federate"
+	"github.com/submariner-io/admiral/pkg/syncer"
+	"github.com/submariner-io/admiral/pkg/syncer/broker"
+	"github.com/submariner-io/admiral/pkg/workqueue"
+	lhconstants "github.com/submariner-io/lighthouse/pkg/constants"
+	"github.com/submariner-io/lighthouse/pkg/gateway"
+	corev1 "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog"
+	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
+)
+
+// serviceExportClusterSetReady is set on a ServiceExport to report whether its service has ready endpoints in at
+// least one connected cluster, ie whether it's available somewhere in the clusterset. The ServiceImport has no
+// conditions in this version of the MCS API so it's reported on the export of each cluster instead.
+const serviceExportClusterSetReady mcsv1a1.ServiceExportConditionType = "ClusterSetReady"
+
+// serviceExportReachableClustersDisconnected is set on a ServiceExport whose service only has ready endpoints in
+// disconnected clusters, as it then looks exported but is unreachable from the whole clusterset.
+const serviceExportReachableClustersDisconnected mcsv1a1.ServiceExportConditionType = "ReachableClustersDisconnected"
+
+// ClusterConnectivity reports whether the other clusters are connected, eg as per the Submariner Gateways.
+type ClusterConnectivity interface {
+	IsConnected(clusterID string) bool
+	OnConnectivityChange(handler gateway.ConnectivityChangeHandler)
+}
+
+// serviceReadiness is the clusterset-wide availability of an exported service.
+type serviceReadiness struct {
+	// Whether the service has ready endpoints in at least one connected cluster.
+	ready bool
+
+	// Whether the service has ready endpoints in disconnected clusters only.
+	disconnected bool
+}
+
+// SetClusterConnectivity sets the connectivity the clusterset readiness of the exported services is evaluated with. It
+// must be called before the agent is started. Without it, all the clusters are considered connected.
+func (a *Controller) SetClusterConnectivity(connectivity ClusterConnectivity) {
+	a.clusterConnectivity = connectivity
+}
+
+func (a *Controller) newReadinessSyncer(syncerConf broker.SyncerConfig) error {
+	var err error
+
+	a.readinessSyncer, err = syncer.NewResourceSyncer(&syncer.ResourceSyncerConfig{
+		Name:                "EndpointSlice -> clusterset readiness",
+		SourceClient:        syncerConf.LocalClient,
+		SourceNamespace:     metav1.NamespaceAll,
+		SourceLabelSelector: discovery.LabelManagedBy + "=" + lhconstants.LabelValueManagedBy,
+		Direction:           syncer.RemoteToLocal,
+		RestMapper:          syncerConf.RestMapper,
+		Federator:           federate.NewNoopFederator(),
+		ResourceType:        &discovery.EndpointSlice{},
+		Transform:           a.endpointSliceToReadiness,
+		Scheme:              syncerConf.Scheme,
+	})
+	if err != nil {
+		return err
+	}
+
+	a.readinessImportSyncer, err = syncer.NewResourceSyncer(&syncer.ResourceSyncerConfig{
+		Name:            "ServiceImport -> clusterset readiness",
+		SourceClient:    syncerConf.LocalClient,
+		SourceNamespace: a.namespace,
+		Direction:       syncer.RemoteToLocal,
+		RestMapper:      syncerConf.RestMapper,
+		Federator:       federate.NewNoopFederator(),
+		ResourceType:    &mcsv1a1.ServiceImport{},
+		Transform:       a.serviceImportToReadiness,
+		Scheme:          syncerConf.Scheme,
+	})
+	if err != nil {
+		return err
+	}
+
+	a.readinessQueue = workqueue.New("ServiceExport clusterset readiness")
+
+	return nil
+}
+
+func (a *Controller) startReadiness(stopCh <-chan struct{}) error {
+	if err := a.readinessSyncer.Start(stopCh); err != nil {
+		return err
+	}
+
+	if err := a.readinessImportSyncer.Start(stopCh); err != nil {
+		return err
+	}
+
+	if a.clusterConnectivity != nil {
+		a.clusterConnectivity.OnConnectivityChange(func(_ string, _ bool) {
+			a.enqueueAllReadiness()
+		})
+	}
+
+	a.readinessQueue.Run(stopCh, a.processReadiness)
+
+	go func() {
+		<-stopCh
+		a.readinessQueue.ShutDown()
+	}()
+
+	return nil
+}
+
+// endpointSliceToReadiness re-evaluates the readiness of the service of a local or imported EndpointSlice.
+func (a *Controller) endpointSliceToReadiness(obj runtime.Object, _ int, _ syncer.Operation) (runtime.Object, bool) {
+	labels := obj.(*discovery.EndpointSlice).GetLabels()
+	a.enqueueReadiness(labels[lhconstants.LabelSourceNamespace], labels[lhconstants.LabelSourceName])
+
+	return nil, false
+}
+
+// serviceImportToReadiness re-evaluates the readiness of the service of a local or imported ServiceImport, eg once its
+// export delay elapsed.
+func (a *Controller) serviceImportToReadiness(obj runtime.Object, _ int, _ syncer.Operation) (runtime.Object, bool) {
+	annotations := obj.(*mcsv1a1.ServiceImport).GetAnnotations()
+	a.enqueueReadiness(annotations[lhconstants.OriginNamespace], annotations[lhconstants.OriginName])
+
+	return nil, false
+}
+
+func (a *Controller) enqueueReadiness(namespace, name string) {
+	if namespace == "" || name == "" {
+		return
+	}
+
+	a.readinessQueue.Enqueue(&metav1.ObjectMeta{Name: name, Namespace: namespace})
+}
+
+// enqueueAllReadiness re-evaluates the readiness of every exported service, eg once a cluster's connectivity changed.
+func (a *Controller) enqueueAllReadiness() {
+	exports, err := a.serviceExportSyncer.ListResources()
+	if err != nil {
+		klog.Errorf("Error listing the ServiceExports to update their clusterset readiness: %v", err)
+		return
+	}
+
+	for _, obj := range exports {
+		svcExport := obj.(*mcsv1a1.ServiceExport)
+		a.enqueueReadiness(svcExport.Namespace, svcExport.Name)
+	}
+}
+
+// processReadiness sets the clusterset readiness condition of the local ServiceExport of the service, if any, when it
+// changed.
+func (a *Controller) processReadiness(_, name, namespace string) (bool, error) {
+	obj, found, err := a.serviceExportSyncer.GetResource(name, namespace)
+	if err != nil {
+		return true, err
+	}
+
+	if !found {
+		return false, nil
+	}
+
+	svcExport := obj.(*mcsv1a1.ServiceExport)
+
+	readiness, err := a.serviceReadiness(svcExport)
+	if err != nil {
+		return true, err
+	}
+
+	status, reason, msg := corev1.ConditionFalse, "NoReadyEndpoints",
+		"The service has no ready endpoints in any connected cluster"
+	if readiness.ready {
+		status, reason, msg = corev1.ConditionTrue, "", ""
+	}
+
+	if !lastConditionHasStatus(svcExport, serviceExportClusterSetReady, status) {
+		a.updateExportedServiceStatus(name, namespace, serviceExportClusterSetReady, status, reason, msg)
+	}
+
+	// The condition is only cleared once it was set, to keep it out of the status of the services that never had it.
+	if readiness.disconnected && !lastConditionHasStatus(svcExport, serviceExportReachableClustersDisconnected,
+		corev1.ConditionTrue) {
+		klog.Warningf("The ready endpoints of the exported service %s/%s are all in disconnected clusters", namespace, name)
+		a.updateExportedServiceStatus(name, namespace, serviceExportReachableClustersDisconnected, corev1.ConditionTrue,
+			"ClustersDisconnected", "The service only has ready endpoints in disconnected clusters")
+	} else if !readiness.disconnected && lastConditionHasStatus(svcExport, serviceExportReachableClustersDisconnected,
+		corev1.ConditionTrue) {
+		a.updateExportedServiceStatus(name, namespace, serviceExportReachableClustersDisconnected, corev1.ConditionFalse,
+			"", "")
+	}
+
+	return false, nil
+}
+
+// serviceReadiness evaluates the clusterset readiness of the exported service from the local and imported
+// EndpointSlices. Those of the exports within their export delay are left out, as the clusterset doesn't resolve them
+// yet.
+func (a *Controller) serviceReadiness(svcExport *mcsv1a1.ServiceExport) (serviceReadiness, error) {
+	endpointSlices, err := a.readinessSyncer.ListResources()
+	if err != nil {
+		return serviceReadiness{}, err
+	}
+
+	readiness := serviceReadiness{}
+	reachableDisconnected := false
+
+	for _, obj := range endpointSlices {
+		endpointSlice := obj.(*discovery.EndpointSlice)
+		labels := endpointSlice.GetLabels()
+
+		if labels[lhconstants.LabelSourceNamespace] != svcExport.Namespace ||
+			labels[lhconstants.LabelSourceName] != svcExport.Name || !hasReadyEndpoints(endpointSlice) ||
+			a.isExportPendingIn(svcExport, labels) {
+			continue
+		}
+
+		if a.isConnected(labels[lhconstants.LabelSourceCluster]) {
+			readiness.ready = true
+		} else {
+			reachableDisconnected = true
+		}
+	}
+
+	readiness.disconnected = !readiness.ready && reachableDisconnected
+
+	return readiness, nil
+}
+
+// isExportPendingIn returns whether the export of the service by the cluster of an EndpointSlice with the given labels
+// is within its export delay. The local export is checked directly, the others as flagged on their ServiceImports.
+func (a *Controller) isExportPendingIn(svcExport *mcsv1a1.ServiceExport, labels map[string]string) bool {
+	if labels[lhconstants.LabelSourceCluster] == a.clusterID {
+		return isExportPending(svcExport)
+	}
+
+	obj, found, err := a.readinessImportSyncer.GetResource(labels[lhconstants.LabelServiceImportName], a.namespace)
+	if err != nil || !found {
+		return false
+	}
+
+	return obj.(*mcsv1a1.ServiceImport).GetAnnotations()[lhconstants.AnnotationExportPending] == "true"
+}
+
+func (a *Controller) isConnected(clusterID string) bool {
+	return clusterID == a.clusterID || a.clusterConnectivity == nil || a.clusterConnectivity.IsConnected(clusterID)
+}
+
+func hasReadyEndpoints(endpointSlice *discovery.EndpointSlice) bool {
+	for i := range endpointSlice.Endpoints {
+		ready := endpointSlice.Endpoints[i].Conditions.Ready
+		if len(endpointSlice.Endpoints[i].Addresses) > 0 && (ready == nil || *ready) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lastConditionHasStatus returns whether the last condition of the given type of the ServiceExport has the status.
+func lastConditionHasStatus(svcExport *mcsv1a1.ServiceExport, condType mcsv1a1.ServiceExportConditionType,
+	status corev1.ConditionStatus) bool {
+	for i := len(svcExport.Status.Conditions) - 1; i >= 0; i-- {
+		if svcExport.Status.Conditions[i].Type == condType {
+			return svcExport.Status.Conditions[i].Status == status
+		}
+	}
+
+	return false
+}
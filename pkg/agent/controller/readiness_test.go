@@ -0,0 +1,225 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller_test
+
+import (
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/submariner-io/admiral/pkg/federate"
+	"github.com/submariner-io/admiral/pkg/syncer/test"
+	lhconstants "github.com/submariner-io/lighthouse/pkg/constants"
+	"github.com/submariner-io/lighthouse/pkg/gateway"
+	corev1 "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
+)
+
+const (
+	serviceExportClusterSetReady               = "ClusterSetReady"
+	serviceExportReachableClustersDisconnected = "ReachableClustersDisconnected"
+)
+
+var _ = Describe("ClusterSet readiness", func() {
+	var (
+		t            *testDriver
+		connectivity *fakeConnectivity
+	)
+
+	BeforeEach(func() {
+		t = newTestDiver()
+		t.cluster1.agentSpec.ClusterSetReadiness = true
+		connectivity = &fakeConnectivity{connected: map[string]bool{clusterID2: true}}
+	})
+
+	JustBeforeEach(func() {
+		agent := t.cluster1.newAgent(*t.syncerConfig)
+		agent.SetClusterConnectivity(connectivity)
+		Expect(agent.Start(t.stopCh)).To(Succeed())
+
+		t.cluster2.start(t, *t.syncerConfig)
+	})
+
+	AfterEach(func() {
+		t.afterEach()
+	})
+
+	When("the exported service has ready endpoints in the local cluster", func() {
+		It("should set the ServiceExport ClusterSetReady", func() {
+			t.createService()
+			t.createEndpoints()
+			t.createServiceExport()
+
+			t.awaitServiceExportCondition(newServiceExportCondition(serviceExportClusterSetReady, corev1.ConditionTrue, ""))
+		})
+	})
+
+	When("the exported service has no ready endpoints in any cluster", func() {
+		BeforeEach(func() {
+			t.endpoints.Subsets[0].Addresses = nil
+		})
+
+		It("should set the ServiceExport not ClusterSetReady", func() {
+			t.createService()
+			t.createEndpoints()
+			t.createServiceExport()
+
+			t.awaitServiceExportCondition(newServiceExportCondition(serviceExportClusterSetReady, corev1.ConditionFalse,
+				"NoReadyEndpoints"))
+			t.awaitNoServiceExportCondition(serviceExportReachableClustersDisconnected)
+		})
+	})
+
+	When("the exported service is within its export delay", func() {
+		BeforeEach(func() {
+			t.serviceExport.SetAnnotations(map[string]string{lhconstants.AnnotationExportDelay: "5s"})
+			t.serviceExport.CreationTimestamp = metav1.Now()
+		})
+
+		It("should only set the ServiceExport ClusterSetReady once the delay elapsed", func() {
+			t.createService()
+			t.createEndpoints()
+			t.createServiceExport()
+
+			t.awaitServiceExportCondition(newServiceExportCondition("Pending", corev1.ConditionTrue, "ExportDelayed"))
+
+			Consistently(func() []corev1.ConditionStatus {
+				var statuses []corev1.ConditionStatus
+
+				conditions := t.getServiceExport().Status.Conditions
+				for i := range conditions {
+					if conditions[i].Type == serviceExportClusterSetReady {
+						statuses = append(statuses, conditions[i].Status)
+					}
+				}
+
+				return statuses
+			}, time.Second).ShouldNot(ContainElement(corev1.ConditionTrue))
+
+			t.awaitServiceExportCondition(newServiceExportCondition(serviceExportClusterSetReady, corev1.ConditionTrue, ""))
+		})
+	})
+
+	When("the exported service only has ready endpoints in another cluster", func() {
+		BeforeEach(func() {
+			t.endpoints.Subsets[0].Addresses = nil
+		})
+
+		JustBeforeEach(func() {
+			t.createService()
+			t.createEndpoints()
+			t.createServiceExport()
+			t.cluster1.createImportedEndpointSlice(t.service, clusterID2)
+		})
+
+		It("should set the ServiceExport ClusterSetReady while the other cluster is connected", func() {
+			t.awaitServiceExportCondition(newServiceExportCondition(serviceExportClusterSetReady, corev1.ConditionTrue, ""))
+
+			connectivity.setConnected(clusterID2, false)
+
+			t.awaitServiceExportCondition(newServiceExportCondition(serviceExportClusterSetReady, corev1.ConditionFalse,
+				"NoReadyEndpoints"))
+		})
+
+		It("should set the ServiceExport ReachableClustersDisconnected while the other cluster is disconnected", func() {
+			t.awaitServiceExportCondition(newServiceExportCondition(serviceExportClusterSetReady, corev1.ConditionTrue, ""))
+
+			connectivity.setConnected(clusterID2, false)
+
+			t.awaitServiceExportCondition(newServiceExportCondition(serviceExportReachableClustersDisconnected,
+				corev1.ConditionTrue, "ClustersDisconnected"))
+
+			connectivity.setConnected(clusterID2, true)
+
+			t.awaitServiceExportCondition(newServiceExportCondition(serviceExportReachableClustersDisconnected,
+				corev1.ConditionFalse, ""))
+		})
+	})
+})
+
+func (t *testDriver) awaitNoServiceExportCondition(condType mcsv1a1.ServiceExportConditionType) {
+	Consistently(func() []mcsv1a1.ServiceExportConditionType {
+		var types []mcsv1a1.ServiceExportConditionType
+
+		conditions := t.getServiceExport().Status.Conditions
+		for i := range conditions {
+			types = append(types, conditions[i].Type)
+		}
+
+		return types
+	}, 300*time.Millisecond).ShouldNot(ContainElement(condType))
+}
+
+// fakeConnectivity reports the connectivity of the clusters as set by the tests.
+type fakeConnectivity struct {
+	sync.Mutex
+	connected map[string]bool
+	handlers  []gateway.ConnectivityChangeHandler
+}
+
+func (c *fakeConnectivity) IsConnected(clusterID string) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.connected[clusterID]
+}
+
+func (c *fakeConnectivity) OnConnectivityChange(handler gateway.ConnectivityChangeHandler) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.handlers = append(c.handlers, handler)
+}
+
+func (c *fakeConnectivity) setConnected(clusterID string, connected bool) {
+	c.Lock()
+	c.connected[clusterID] = connected
+	handlers := c.handlers
+	c.Unlock()
+
+	for _, handler := range handlers {
+		handler(clusterID, connected)
+	}
+}
+
+// createImportedEndpointSlice creates an EndpointSlice with a ready endpoint for the service, as imported from the
+// given cluster.
+func (c *cluster) createImportedEndpointSlice(service *corev1.Service, sourceCluster string) {
+	test.CreateResource(c.localEndpointSliceClient, &discovery.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      service.Name + "-" + sourceCluster,
+			Namespace: service.Namespace,
+			Labels: map[string]string{
+				discovery.LabelManagedBy:           lhconstants.LabelValueManagedBy,
+				lhconstants.LabelSourceCluster:     sourceCluster,
+				lhconstants.LabelSourceName:        service.Name,
+				lhconstants.LabelSourceNamespace:   service.Namespace,
+				lhconstants.LabelServiceImportName: service.Name + "-" + service.Namespace + "-" + sourceCluster,
+				federate.ClusterIDLabelKey:         sourceCluster,
+			},
+		},
+		AddressType: discovery.AddressTypeIPv4,
+		Endpoints: []discovery.Endpoint{
+			{
+				Addresses:  []string{"192.168.6.1"},
+				Conditions: discovery.EndpointConditions{Ready: &ready},
+			},
+		},
+	})
+}
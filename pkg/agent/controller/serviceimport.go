@@ -22,21 +22,28 @@ import (
 	lhconstants "github.com/submariner-io/lighthouse/pkg/constants"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/klog"
 	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
 )
 
 func newServiceImportController(spec *AgentSpecification, serviceSyncer syncer.Interface, restMapper meta.RESTMapper,
-	localClient dynamic.Interface, scheme *runtime.Scheme) (*ServiceImportController, error) {
+	localClient dynamic.Interface, kubeClientSet kubernetes.Interface, scheme *runtime.Scheme,
+	updateExportStatus exportStatusFunc, retries *retryBackoff) (*ServiceImportController, error) {
 	controller := &ServiceImportController{
-		serviceSyncer: serviceSyncer,
-		localClient:   localClient,
-		restMapper:    restMapper,
-		clusterID:     spec.ClusterID,
-		scheme:        scheme,
+		serviceSyncer:      serviceSyncer,
+		localClient:        localClient,
+		kubeClientSet:      kubeClientSet,
+		updateExportStatus: updateExportStatus,
+		restMapper:         restMapper,
+		clusterID:          spec.ClusterID,
+		globalnetEnabled:   spec.GlobalnetEnabled,
+		refreshPeriod:      spec.EndpointSliceRefreshPeriod,
+		scheme:             scheme,
 	}
 
 	var err error
@@ -49,7 +56,7 @@ func newServiceImportController(spec *AgentSpecification, serviceSyncer syncer.I
 		RestMapper:      restMapper,
 		Federator:       federate.NewNoopFederator(),
 		ResourceType:    &mcsv1a1.ServiceImport{},
-		Transform:       controller.serviceImportToEndpointController,
+		Transform:       retries.wrap(controller.serviceImportToEndpointController),
 		Scheme:          scheme,
 	})
 	if err != nil {
@@ -79,18 +86,25 @@ func (c *ServiceImportController) start(stopCh <-chan struct{}) error {
 }
 
 func (c *ServiceImportController) serviceImportCreatedOrUpdated(serviceImport *mcsv1a1.ServiceImport, key string) bool {
-	if _, found := c.endpointControllers.Load(key); found {
-		klog.V(log.DEBUG).Infof("The endpoint controller is already running for %q", key)
-		return false
-	}
-
 	if serviceImport.GetLabels()[lhconstants.LabelSourceCluster] != c.clusterID {
 		return false
 	}
 
 	annotations := serviceImport.ObjectMeta.Annotations
 	serviceNameSpace := annotations[lhconstants.OriginNamespace]
-	serviceName := annotations[lhconstants.OriginName]
+	exportName := annotations[lhconstants.OriginName]
+
+	serviceName, ok := annotations[lhconstants.AnnotationBackend]
+	if !ok {
+		serviceName = exportName
+	}
+
+	// The selector was validated when the ServiceImport was created.
+	endpointSelector, err := exportedEndpointSelector(annotations)
+	if err != nil {
+		klog.Errorf("Invalid endpoint selector for the service %s/%s: %v", serviceNameSpace, exportName, err)
+		return false
+	}
 
 	obj, found, err := c.serviceSyncer.GetResource(serviceName, serviceNameSpace)
 	if err != nil {
@@ -104,13 +118,26 @@ func (c *ServiceImportController) serviceImportCreatedOrUpdated(serviceImport *m
 	}
 
 	service := obj.(*corev1.Service)
-	if service.Spec.Selector == nil {
-		klog.Errorf("The service %s/%s without a Selector is not supported", serviceNameSpace, serviceName)
-		return false
+
+	if obj, found := c.endpointControllers.Load(key); found {
+		endpointController := obj.(*EndpointController)
+		if endpointController.serviceName == serviceName &&
+			endpointController.endpointSelector.String() == endpointSelector.String() &&
+			endpointController.podSelector == labels.SelectorFromSet(service.Spec.Selector).String() {
+			klog.V(log.DEBUG).Infof("The endpoint controller is already running for %q", key)
+			return false
+		}
+
+		// The exported HTTPRoute's backend, the endpoint selector or the pods of the service changed so the Endpoints
+		// are exported anew.
+		endpointController.stop()
+		c.endpointControllers.Delete(key)
 	}
 
-	endpointController, err := startEndpointController(c.localClient, c.restMapper, c.scheme,
-		serviceImport.ObjectMeta.UID, serviceImport.ObjectMeta.Name, serviceNameSpace, serviceName, c.clusterID)
+	endpointController, err := startEndpointController(c.localClient, c.kubeClientSet, c.restMapper, c.scheme,
+		serviceImport.ObjectMeta.UID, serviceImport.ObjectMeta.Name, serviceNameSpace, exportName, serviceName, c.clusterID,
+		serviceImport.Spec.Type == mcsv1a1.Headless, service.Spec.Selector, c.globalnetEnabled,
+		endpointSelector, c.refreshPeriod, c.updateExportStatus)
 	if err != nil {
 		klog.Errorf(err.Error())
 		return true
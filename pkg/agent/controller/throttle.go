@@ -0,0 +1,183 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/klog"
+)
+
+// defaultThrottleDelay is how long writes are held back after a 429 response without a Retry-After.
+const defaultThrottleDelay = time.Second
+
+// writeThrottle holds back the writes to an API server once it rejected one with a 429 Too Many Requests response,
+// until the delay it asked for with Retry-After has elapsed. The syncers retry failed writes immediately through their
+// rate limiter, so without this they would keep adding to the server's overload.
+type writeThrottle struct {
+	name  string
+	now   func() time.Time
+	mutex sync.Mutex
+	until time.Time
+}
+
+// throttledClient is a dynamic client whose writes are subject to a writeThrottle shared by all its resources.
+type throttledClient struct {
+	dynamic.Interface
+	throttle *writeThrottle
+}
+
+type throttledNamespaceableResource struct {
+	throttledResource
+	namespaceable dynamic.NamespaceableResourceInterface
+}
+
+type throttledResource struct {
+	dynamic.ResourceInterface
+	resource string
+	throttle *writeThrottle
+}
+
+// newThrottledClient wraps the given dynamic client so its writes are held back while the API server, identified by
+// name in the logs and metrics, is throttling them.
+func newThrottledClient(client dynamic.Interface, name string) dynamic.Interface {
+	return &throttledClient{Interface: client, throttle: &writeThrottle{name: name, now: time.Now}}
+}
+
+func (c *throttledClient) Resource(gvr schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	resource := c.Interface.Resource(gvr)
+
+	return &throttledNamespaceableResource{
+		throttledResource: throttledResource{ResourceInterface: resource, resource: gvr.Resource, throttle: c.throttle},
+		namespaceable:     resource,
+	}
+}
+
+func (r *throttledNamespaceableResource) Namespace(namespace string) dynamic.ResourceInterface {
+	return &throttledResource{
+		ResourceInterface: r.namespaceable.Namespace(namespace),
+		resource:          r.resource,
+		throttle:          r.throttle,
+	}
+}
+
+func (r *throttledResource) Create(obj *unstructured.Unstructured, options metav1.CreateOptions,
+	subresources ...string) (*unstructured.Unstructured, error) {
+	if err := r.throttle.check(r.resource); err != nil {
+		return nil, err
+	}
+
+	result, err := r.ResourceInterface.Create(obj, options, subresources...)
+
+	return result, r.throttle.record(r.resource, err)
+}
+
+func (r *throttledResource) Update(obj *unstructured.Unstructured, options metav1.UpdateOptions,
+	subresources ...string) (*unstructured.Unstructured, error) {
+	if err := r.throttle.check(r.resource); err != nil {
+		return nil, err
+	}
+
+	result, err := r.ResourceInterface.Update(obj, options, subresources...)
+
+	return result, r.throttle.record(r.resource, err)
+}
+
+func (r *throttledResource) UpdateStatus(obj *unstructured.Unstructured,
+	options metav1.UpdateOptions) (*unstructured.Unstructured, error) {
+	if err := r.throttle.check(r.resource); err != nil {
+		return nil, err
+	}
+
+	result, err := r.ResourceInterface.UpdateStatus(obj, options)
+
+	return result, r.throttle.record(r.resource, err)
+}
+
+func (r *throttledResource) Delete(name string, options *metav1.DeleteOptions, subresources ...string) error {
+	if err := r.throttle.check(r.resource); err != nil {
+		return err
+	}
+
+	return r.throttle.record(r.resource, r.ResourceInterface.Delete(name, options, subresources...))
+}
+
+func (r *throttledResource) DeleteCollection(options *metav1.DeleteOptions, listOptions metav1.ListOptions) error {
+	if err := r.throttle.check(r.resource); err != nil {
+		return err
+	}
+
+	return r.throttle.record(r.resource, r.ResourceInterface.DeleteCollection(options, listOptions))
+}
+
+func (r *throttledResource) Patch(name string, pt types.PatchType, data []byte, options metav1.PatchOptions,
+	subresources ...string) (*unstructured.Unstructured, error) {
+	if err := r.throttle.check(r.resource); err != nil {
+		return nil, err
+	}
+
+	result, err := r.ResourceInterface.Patch(name, pt, data, options, subresources...)
+
+	return result, r.throttle.record(r.resource, err)
+}
+
+// check returns a 429 error, without calling the API server, if writes are currently held back.
+func (t *writeThrottle) check(resource string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	remaining := t.until.Sub(t.now())
+	if remaining <= 0 {
+		return nil
+	}
+
+	return apierrors.NewTooManyRequests(fmt.Sprintf("writes of %s to the %s API server are throttled for another %v",
+		resource, t.name, remaining), int(remaining.Round(time.Second)/time.Second))
+}
+
+// record holds back the writes if the given error of a write is a 429 response, for the delay it suggests if any.
+// The error is returned as is.
+func (t *writeThrottle) record(resource string, err error) error {
+	if !apierrors.IsTooManyRequests(err) {
+		return err
+	}
+
+	delay := defaultThrottleDelay
+	if seconds, ok := apierrors.SuggestsClientDelay(err); ok && seconds > 0 {
+		delay = time.Duration(seconds) * time.Second
+	}
+
+	ThrottledWrites.WithLabelValues(t.name, resource).Inc()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if until := t.now().Add(delay); until.After(t.until) {
+		t.until = until
+	}
+
+	klog.Warningf("The %s API server throttled a write of %s - holding back writes for %v", t.name, resource, delay)
+
+	return err
+}
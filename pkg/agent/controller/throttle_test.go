@@ -0,0 +1,129 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller_test
+
+import (
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/submariner-io/lighthouse/pkg/agent/controller"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
+)
+
+var _ = Describe("Throttled writes", func() {
+	var (
+		t      *testDriver
+		server *overloadedServer
+	)
+
+	BeforeEach(func() {
+		t = newTestDiver()
+		server = &overloadedServer{rejections: 2}
+		t.syncerConfig.BrokerClient = &overloadedClient{Interface: t.syncerConfig.BrokerClient, server: server}
+	})
+
+	JustBeforeEach(func() {
+		t.justBeforeEach()
+	})
+
+	AfterEach(func() {
+		t.afterEach()
+	})
+
+	When("the broker API server rejects the ServiceImport creation with a Retry-After", func() {
+		It("should hold back the retries for the requested delay and count the throttled writes", func() {
+			throttled := testutil.ToFloat64(controller.ThrottledWrites.WithLabelValues("broker", "serviceimports"))
+
+			t.createService()
+			t.createServiceExport()
+			t.awaitBrokerServiceImport(mcsv1a1.ClusterSetIP, t.service.Spec.ClusterIP)
+
+			attempts := server.getAttempts()
+			Expect(attempts).To(HaveLen(3))
+			Expect(attempts[1].Sub(attempts[0])).To(BeNumerically(">=", time.Second))
+			Expect(attempts[2].Sub(attempts[1])).To(BeNumerically(">=", time.Second))
+
+			Expect(testutil.ToFloat64(controller.ThrottledWrites.WithLabelValues("broker", "serviceimports"))).To(
+				Equal(throttled + 2))
+		})
+	})
+})
+
+// overloadedServer rejects the first ServiceImport creations with a 429 response asking to retry after a second,
+// recording the time of each attempt.
+type overloadedServer struct {
+	mutex      sync.Mutex
+	rejections int
+	attempts   []time.Time
+}
+
+type overloadedClient struct {
+	dynamic.Interface
+	server *overloadedServer
+}
+
+type overloadedResource struct {
+	dynamic.NamespaceableResourceInterface
+	server *overloadedServer
+}
+
+type overloadedNamespacedResource struct {
+	dynamic.ResourceInterface
+	server *overloadedServer
+}
+
+func (s *overloadedServer) getAttempts() []time.Time {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return append([]time.Time{}, s.attempts...)
+}
+
+func (c *overloadedClient) Resource(gvr schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	if gvr.Resource != "serviceimports" {
+		return c.Interface.Resource(gvr)
+	}
+
+	return &overloadedResource{NamespaceableResourceInterface: c.Interface.Resource(gvr), server: c.server}
+}
+
+func (r *overloadedResource) Namespace(namespace string) dynamic.ResourceInterface {
+	return &overloadedNamespacedResource{ResourceInterface: r.NamespaceableResourceInterface.Namespace(namespace),
+		server: r.server}
+}
+
+func (r *overloadedNamespacedResource) Create(obj *unstructured.Unstructured, options metav1.CreateOptions,
+	subresources ...string) (*unstructured.Unstructured, error) {
+	r.server.mutex.Lock()
+	r.server.attempts = append(r.server.attempts, time.Now())
+	reject := r.server.rejections > 0
+	r.server.rejections--
+	r.server.mutex.Unlock()
+
+	if reject {
+		return nil, apierrors.NewTooManyRequests("the server is overloaded", 1)
+	}
+
+	return r.ResourceInterface.Create(obj, options, subresources...)
+}
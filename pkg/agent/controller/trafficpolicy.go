@@ -0,0 +1,56 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	lhconstants "github.com/submariner-io/lighthouse/pkg/constants"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
+)
+
+// serviceExportInternalTrafficPolicy is set on the ServiceExport of a service whose internalTrafficPolicy is Local to
+// note how the policy is reflected across the clusterset.
+const serviceExportInternalTrafficPolicy mcsv1a1.ServiceExportConditionType = "InternalTrafficPolicy"
+
+const localTrafficPolicy = "LocalTrafficPolicy"
+
+// internalTrafficPolicyFromService returns the internalTrafficPolicy of the Service, or "" if it has none. The
+// vendored Service type predates the field so it's read from the unstructured Service.
+func internalTrafficPolicyFromService(obj *unstructured.Unstructured) string {
+	policy, _, _ := unstructured.NestedString(obj.Object, "spec", "internalTrafficPolicy")
+	return policy
+}
+
+// copyInternalTrafficPolicy records the Service's internalTrafficPolicy on its ServiceImport, so the importing clusters
+// can restrict the answers of their own consumers to their own endpoints, and reports a Local policy on the
+// ServiceExport as it has no direct clusterset equivalent, until the Service's policy changes.
+func (a *Controller) copyInternalTrafficPolicy(svcExport *mcsv1a1.ServiceExport, from *unstructured.Unstructured,
+	to *mcsv1a1.ServiceImport) {
+	policy := internalTrafficPolicyFromService(from)
+	if policy != "" {
+		to.Annotations[lhconstants.AnnotationInternalTrafficPolicy] = policy
+	}
+
+	if policy != lhconstants.InternalTrafficPolicyLocal {
+		a.clearExportCondition(svcExport, serviceExportInternalTrafficPolicy, localTrafficPolicy)
+		return
+	}
+
+	a.updateExportedServiceStatus(svcExport.Name, svcExport.Namespace, serviceExportInternalTrafficPolicy,
+		corev1.ConditionTrue, localTrafficPolicy, "The Service's Local internalTrafficPolicy has no clusterset "+
+			"equivalent - clusters exporting the Service may answer their own consumers with their own endpoints only")
+}
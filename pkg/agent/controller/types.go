@@ -17,35 +17,139 @@ package controller
 
 import (
 	"sync"
+	"time"
 
 	"k8s.io/client-go/kubernetes"
 
+	"github.com/submariner-io/admiral/pkg/federate"
 	"github.com/submariner-io/admiral/pkg/syncer"
 	"github.com/submariner-io/admiral/pkg/syncer/broker"
+	"github.com/submariner-io/admiral/pkg/workqueue"
+	"github.com/submariner-io/lighthouse/pkg/ipam"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/dynamic"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
 )
 
 type Controller struct {
 	clusterID                 string
 	globalnetEnabled          bool
 	namespace                 string
+	annotationAllowlist       []string
+	namespaceMembership       map[string][]string
 	kubeClientSet             kubernetes.Interface
+	clustersetIPs             *ipam.Pool
+	clustersetIPv6s           *ipam.Pool
 	serviceExportClient       dynamic.NamespaceableResourceInterface
+	endpointSliceClient       dynamic.NamespaceableResourceInterface
 	serviceExportSyncer       syncer.Interface
 	serviceImportSyncer       *broker.Syncer
 	endpointSliceSyncer       *broker.Syncer
 	serviceSyncer             syncer.Interface
+	unstructuredServiceSyncer syncer.Interface
+	autoExportSyncer          syncer.Interface
+	httpRouteSyncer           syncer.Interface
 	serviceImportController   *ServiceImportController
 	lhServiceExportController *LHServiceExportController
+	readinessSyncer           syncer.Interface
+	readinessQueue            workqueue.Interface
+	readinessImportSyncer     syncer.Interface
+	namespaceLister           corelisters.NamespaceLister
+	namespaceQueue            workqueue.Interface
+	clusterConnectivity       ClusterConnectivity
+	leaseDuration             time.Duration
+	renewDeadline             time.Duration
+	retryPeriod               time.Duration
+	leadership                *leadership
+
+	// Imported EndpointSlices without a ServiceImport are deleted once they're older than this, if positive.
+	orphanedEndpointSliceMaxAge time.Duration
+
+	// If set, services are only imported and the export syncers and controllers aren't created.
+	noExport bool
+
+	// The timers re-exporting the ServiceExports within their export delay once it elapsed, by namespace/name.
+	reexportTimers      map[string]*time.Timer
+	reexportTimersMutex sync.Mutex
+
+	// The imported ServiceImports whose clusterset VIP claims displaced those of local exports, by name, until they're
+	// listed locally, so the re-exported services don't allocate the same VIPs again.
+	pendingClaims      map[string]*mcsv1a1.ServiceImport
+	pendingClaimsMutex sync.Mutex
+
+	// The domain the exported services are resolved in, whose names mustn't exceed the max length.
+	clustersetDomain        string
+	maxClustersetNameLength int
 }
 
 type AgentSpecification struct {
 	ClusterID        string
 	Namespace        string
 	GlobalnetEnabled bool `split_words:"true"`
+	// Service annotations to copy into the exported ServiceImport. An entry ending in '*' matches any annotation
+	// with that prefix. When empty, no annotations are propagated.
+	AnnotationAllowlist []string `split_words:"true"`
+	// Maps a namespace to the ';'-separated IDs of the clusters eligible to export and import its services, eg
+	// "ns1:east;west,ns2:east". Namespaces that aren't listed are shared by all clusters.
+	NamespaceMembership map[string]string `split_words:"true"`
+	// The CIDR from which a clusterset VIP is allocated for each exported ClusterSetIP service. It must be the same in
+	// all the clusters, which derive the same VIP for a service. When empty, no VIPs are allocated.
+	ClustersetIPCIDR string `envconfig:"CLUSTERSET_IP_CIDR"`
+	// The IPv6 CIDR from which a clusterset VIP is allocated for each exported ClusterSetIP service with an IPv6
+	// cluster IP, ie single-stack IPv6 or dual-stack. When empty, no IPv6 VIPs are allocated.
+	ClustersetIPv6CIDR string `envconfig:"CLUSTERSET_IPV6_CIDR"`
+	// A label selector restricting the ServiceExports that are watched and exported, eg "lighthouse=enabled". When
+	// empty, all ServiceExports are exported.
+	ServiceExportSelector string `split_words:"true"`
+	// Whether replicas are leader-elected via a Lease in the agent namespace, so only the leader exports and imports
+	// services while the others are on standby, with their caches synced so they take over without a full resync.
+	LeaderElection              bool          `split_words:"true"`
+	LeaderElectionLeaseDuration time.Duration `split_words:"true" default:"15s"`
+	LeaderElectionRenewDeadline time.Duration `split_words:"true" default:"10s"`
+	LeaderElectionRetryPeriod   time.Duration `split_words:"true" default:"2s"`
+	// Whether a ServiceExport is automatically created for each Service labeled "lighthouse.submariner.io/export: true"
+	// and deleted once the label is removed.
+	AutoExport bool `split_words:"true"`
+	// Whether a ServiceExport annotated with "lighthouse.submariner.io/http-route: <name>" exports the backend Service
+	// of the Gateway API HTTPRoute of that name in its namespace, rather than the Service named like the export. The
+	// HTTPRoutes are watched so the export follows changes to the route's backend, which requires access to them.
+	GatewayAPIRoutes bool `envconfig:"GATEWAY_API_ROUTES"`
+	// The initial and maximum delays between the retries of a failed import, which back off exponentially. The
+	// defaults match the syncers' own backoff, which retries can't be more frequent than.
+	ImportRetryBaseDelay time.Duration `split_words:"true" default:"5ms"`
+	ImportRetryMaxDelay  time.Duration `split_words:"true" default:"30s"`
+	// The age beyond which an imported EndpointSlice whose ServiceImport doesn't exist is deleted, which backstops
+	// missed deletions. The EndpointSlices are checked every half of it. Zero disables the checks.
+	OrphanedEndpointSliceMaxAge time.Duration `split_words:"true" default:"1h"`
+	// The client-side rate limit of the requests to the local API server, in queries per second with the given
+	// burst, so the agent's own flow control smooths out its writes before the server has to throttle them. Zero
+	// keeps the client-go defaults.
+	KubeAPIQPS   float32 `envconfig:"KUBE_API_QPS"`
+	KubeAPIBurst int     `envconfig:"KUBE_API_BURST"`
+	// Whether services are only imported, eg in spoke clusters of a hub-and-spoke topology. ServiceExports aren't
+	// watched so none of the cluster's services can be exported, and the agent needs no access to ServiceExports,
+	// Services or Endpoints. Services this cluster exported before aren't withdrawn.
+	NoExport bool `split_words:"true"`
+	// The domain the exported services are resolved in and the maximum length of their names in it, eg for resolvers
+	// with a stricter limit than DNS. An export whose clusterset name is longer, or has a label longer than 63 bytes,
+	// is rejected. The defaults are "clusterset.local" and the DNS limit, 253.
+	ClustersetDomain        string `split_words:"true"`
+	MaxClustersetNameLength int    `split_words:"true"`
+	// Whether the agent exits at startup if it's missing any of the permissions it needs, rather than only logging
+	// them.
+	RBACFailFast bool `envconfig:"RBAC_FAIL_FAST"`
+	// Whether a ClusterSetReady condition is set on each ServiceExport, reporting whether its service has ready
+	// endpoints in at least one cluster connected as per the Submariner Gateways, which requires access to them.
+	ClusterSetReadiness bool `split_words:"true"`
+	// How often the exported EndpointSlices are refreshed, by stamping them with the time in the
+	// "lighthouse.submariner.io/refreshed" annotation, so the importing clusters can tell a stale EndpointSlice from a
+	// stable one. Zero disables the refreshes.
+	EndpointSliceRefreshPeriod time.Duration `split_words:"true"`
 }
 
 // The ServiceImportController listens for ServiceImport resources created in the target namespace
@@ -54,25 +158,57 @@ type AgentSpecification struct {
 type ServiceImportController struct {
 	serviceSyncer       syncer.Interface
 	localClient         dynamic.Interface
+	kubeClientSet       kubernetes.Interface
+	updateExportStatus  exportStatusFunc
 	restMapper          meta.RESTMapper
 	serviceImportSyncer syncer.Interface
 	endpointControllers sync.Map
 	clusterID           string
+	globalnetEnabled    bool
+	refreshPeriod       time.Duration
 	scheme              *runtime.Scheme
 }
 
 // Each EndpointController listens for the endpoints that backs a service and have a ServiceImport
 // It will create an endpoint slice corresponding to an endpoint object and set the owner references
 // to ServiceImport. The app label from the endpoint will be added to endpoint slice as well.
+// For headless services, addresses of pods using the host network are excluded from the endpoint slice as node IPs
+// are not routable across the clusterset and the ServiceExport status is updated to reflect it.
 type EndpointController struct {
-	serviceImportUID             types.UID
-	clusterID                    string
-	serviceImportName            string
+	serviceImportUID  types.UID
+	clusterID         string
+	serviceImportName string
+	exportName        string
+	// The Service whose Endpoints are exported, which differs from the export's name if it's an HTTPRoute's backend.
 	serviceName                  string
 	serviceImportSourceNameSpace string
-	stopCh                       chan struct{}
+	isHeadless                   bool
+	hasHostNetworkEndpoints      bool
+	// Set with Globalnet, in which case the global IPs of a headless service's pods are exported rather than their IPs.
+	globalnetEnabled bool
+	// How often the EndpointSlice is refreshed, if positive, ie the resync period of the Endpoints.
+	refreshPeriod time.Duration
+	// The time the EndpointSlice was last refreshed, in Unix nanoseconds, accessed atomically.
+	lastRefreshed int64
+	// Set for a service without a selector, whose Endpoints are maintained manually and exported as they are.
+	withoutSelector   bool
+	endpointsReported bool
+	hasEndpoints      bool
+	// Only the addresses of the pods it matches are exported.
+	endpointSelector labels.Selector
+	// The pods of the service, as selected by podSelector, watched if they're looked up by the addresses.
+	podSelector        string
+	podLister          corelisters.PodLister
+	updateExportStatus exportStatusFunc
+	endpointsSyncer    syncer.Interface
+	federator          federate.Federator
+	queue              workqueue.Interface
+	stopCh             chan struct{}
 }
 
+type exportStatusFunc func(name, namespace string, condType mcsv1a1.ServiceExportConditionType,
+	status corev1.ConditionStatus, reason, msg string)
+
 type LHServiceExportController struct {
 	lhServiceExportSyncer syncer.Interface
 	localClient           dynamic.Interface
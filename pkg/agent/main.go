@@ -17,11 +17,17 @@ package main
 
 import (
 	"flag"
+	"net/http"
+	"os"
 
 	"github.com/kelseyhightower/envconfig"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/submariner-io/admiral/pkg/syncer/broker"
 	"github.com/submariner-io/admiral/pkg/util"
 	"github.com/submariner-io/lighthouse/pkg/agent/controller"
+	"github.com/submariner-io/lighthouse/pkg/gateway"
+	"github.com/submariner-io/lighthouse/pkg/rbac"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
@@ -34,8 +40,9 @@ import (
 )
 
 var (
-	masterURL  string
-	kubeConfig string
+	masterURL      string
+	kubeConfig     string
+	metricsAddress string
 )
 
 func main() {
@@ -67,11 +74,25 @@ func main() {
 		klog.Fatalf("Error building kubeconfig: %s", err.Error())
 	}
 
+	if agentSpec.KubeAPIQPS > 0 {
+		cfg.QPS = agentSpec.KubeAPIQPS
+	}
+
+	if agentSpec.KubeAPIBurst > 0 {
+		cfg.Burst = agentSpec.KubeAPIBurst
+	}
+
 	kubeClientSet, err := kubernetes.NewForConfig(cfg)
 	if err != nil {
 		klog.Fatalf("Error building clientset: %s", err.Error())
 	}
 
+	err = rbac.Verify(kubeClientSet, "the lighthouse agent", controller.RequiredPermissions(&agentSpec),
+		agentSpec.RBACFailFast)
+	if err != nil {
+		klog.Exitf("%v", err)
+	}
+
 	restMapper, err := util.BuildRestMapper(cfg)
 	if err != nil {
 		klog.Fatal(err.Error())
@@ -96,11 +117,40 @@ func main() {
 		klog.Fatalf("Failed to create lighthouse agent: %v", err)
 	}
 
-	if err := lightHouseAgent.Start(stopCh); err != nil {
-		klog.Fatalf("Failed to start lighthouse agent: %v", err)
+	if agentSpec.ClusterSetReadiness && !agentSpec.NoExport {
+		gatewayController := gateway.NewController()
+		if err := gatewayController.Start(cfg); err != nil {
+			klog.Fatalf("Error starting the Gateway controller: %v", err)
+		}
+
+		lightHouseAgent.SetClusterConnectivity(gatewayController)
+	}
+
+	if metricsAddress != "" {
+		prometheus.MustRegister(controller.Collectors()...)
+
+		go func() {
+			http.Handle("/metrics", promhttp.Handler())
+			klog.Fatal(http.ListenAndServe(metricsAddress, nil))
+		}()
 	}
 
-	<-stopCh
+	if agentSpec.LeaderElection {
+		identity, err := os.Hostname()
+		if err != nil {
+			klog.Fatalf("Error getting the hostname for the leader election identity: %v", err)
+		}
+
+		if err := lightHouseAgent.RunLeaderElected(stopCh, identity); err != nil {
+			klog.Fatalf("Leader-elected lighthouse agent failed: %v", err)
+		}
+	} else {
+		if err := lightHouseAgent.Start(stopCh); err != nil {
+			klog.Fatalf("Failed to start lighthouse agent: %v", err)
+		}
+
+		<-stopCh
+	}
 
 	klog.Info("All controllers stopped or exited. Stopping main loop")
 }
@@ -109,4 +159,6 @@ func init() {
 	flag.StringVar(&kubeConfig, "kubeconfig", "", "Path to a kubeconfig. Only required if out-of-cluster.")
 	flag.StringVar(&masterURL, "master", "",
 		"The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
+	flag.StringVar(&metricsAddress, "metrics-address", "",
+		"The address on which to expose the Prometheus metrics, eg \":8082\". Not exposed if empty.")
 }
@@ -0,0 +1,187 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package circuitbreaker
+
+import (
+	"sync"
+	"time"
+)
+
+type State int
+
+const (
+	// Closed allows the cluster to be returned for the service.
+	Closed State = iota
+
+	// Open stops the cluster from being returned for the service until the cooldown expires.
+	Open
+
+	// HalfOpen allows a single trial of the cluster for the service to test whether it recovered.
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+type breakerKey struct {
+	service   string
+	clusterID string
+}
+
+type clusterBreaker struct {
+	state    State
+	failures int
+	openedAt time.Time
+}
+
+// Breaker maintains a circuit breaker per service and cluster, so a service failing in a cluster doesn't exclude the
+// cluster for the other services it exports. A breaker opens after Threshold consecutive failures and stays open for
+// Cooldown, after which it half-opens to test recovery. A success in the half-open state closes the breaker while a
+// failure opens it again. The service is identified by its namespace and name, eg "ns/name".
+type Breaker struct {
+	threshold int
+	cooldown  time.Duration
+	breakers  map[breakerKey]*clusterBreaker
+	mutex     sync.Mutex
+}
+
+func New(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		breakers:  make(map[breakerKey]*clusterBreaker),
+	}
+}
+
+// Allow returns true if the given cluster may be returned in DNS answers for the service.
+func (b *Breaker) Allow(service, clusterID string) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	key := breakerKey{service: service, clusterID: clusterID}
+
+	cb, ok := b.breakers[key]
+	if !ok {
+		return true
+	}
+
+	if cb.state == Open && time.Since(cb.openedAt) >= b.cooldown {
+		b.setState(key, cb, HalfOpen)
+	}
+
+	return cb.state != Open
+}
+
+// Peek returns whether Allow would allow the given cluster for the service, without half-opening its breaker once the
+// cooldown expires.
+func (b *Breaker) Peek(service, clusterID string) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	cb, ok := b.breakers[breakerKey{service: service, clusterID: clusterID}]
+	if !ok {
+		return true
+	}
+
+	return cb.state != Open || time.Since(cb.openedAt) >= b.cooldown
+}
+
+// RecordSuccess records a successful health observation of the service in the given cluster.
+func (b *Breaker) RecordSuccess(service, clusterID string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	key := breakerKey{service: service, clusterID: clusterID}
+
+	cb, ok := b.breakers[key]
+	if !ok {
+		return
+	}
+
+	cb.failures = 0
+
+	if cb.state == HalfOpen {
+		b.setState(key, cb, Closed)
+	}
+}
+
+// RecordFailure records a failed health observation of the service in the given cluster.
+func (b *Breaker) RecordFailure(service, clusterID string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	key := breakerKey{service: service, clusterID: clusterID}
+
+	cb, ok := b.breakers[key]
+	if !ok {
+		cb = &clusterBreaker{}
+		b.breakers[key] = cb
+		b.setState(key, cb, Closed)
+	}
+
+	cb.failures++
+
+	if cb.state == HalfOpen || (cb.state == Closed && cb.failures >= b.threshold) {
+		cb.openedAt = time.Now()
+		b.setState(key, cb, Open)
+	}
+}
+
+// Reset closes the breakers of all the services in the given cluster and clears their failures, eg once the cluster
+// is known to have recovered. It returns true if any of them wasn't closed.
+func (b *Breaker) Reset(clusterID string) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	reset := false
+
+	for key, cb := range b.breakers {
+		if key.clusterID != clusterID {
+			continue
+		}
+
+		reset = reset || cb.state != Closed
+		cb.failures = 0
+		b.setState(key, cb, Closed)
+	}
+
+	return reset
+}
+
+// GetState returns the state of the breaker of the service in the given cluster.
+func (b *Breaker) GetState(service, clusterID string) State {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if cb, ok := b.breakers[breakerKey{service: service, clusterID: clusterID}]; ok {
+		return cb.state
+	}
+
+	return Closed
+}
+
+func (b *Breaker) setState(key breakerKey, cb *clusterBreaker, state State) {
+	cb.state = state
+	BreakerState.WithLabelValues(key.service, key.clusterID).Set(float64(state))
+}
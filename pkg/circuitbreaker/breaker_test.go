@@ -0,0 +1,137 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package circuitbreaker_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/submariner-io/lighthouse/pkg/circuitbreaker"
+)
+
+const (
+	service    = "ns/service1"
+	service2   = "ns/service2"
+	clusterID1 = "east"
+	clusterID2 = "west"
+	cooldown   = 200 * time.Millisecond
+)
+
+var _ = Describe("Circuit breaker", func() {
+	var breaker *circuitbreaker.Breaker
+
+	BeforeEach(func() {
+		breaker = circuitbreaker.New(3, cooldown)
+	})
+
+	failures := func(clusterID string, count int) {
+		for i := 0; i < count; i++ {
+			breaker.RecordFailure(service, clusterID)
+		}
+	}
+
+	expectState := func(clusterID string, state circuitbreaker.State) {
+		Expect(breaker.GetState(service, clusterID)).To(Equal(state))
+		Expect(testutil.ToFloat64(circuitbreaker.BreakerState.WithLabelValues(service, clusterID))).To(Equal(float64(state)))
+	}
+
+	When("no failures are recorded", func() {
+		It("should allow the cluster", func() {
+			Expect(breaker.Allow(service, clusterID1)).To(BeTrue())
+			Expect(breaker.GetState(service, clusterID1)).To(Equal(circuitbreaker.Closed))
+		})
+	})
+
+	When("fewer failures than the threshold are recorded", func() {
+		It("should remain closed", func() {
+			failures(clusterID1, 2)
+			Expect(breaker.Allow(service, clusterID1)).To(BeTrue())
+			expectState(clusterID1, circuitbreaker.Closed)
+		})
+	})
+
+	When("a success resets the consecutive failures", func() {
+		It("should remain closed", func() {
+			failures(clusterID1, 2)
+			breaker.RecordSuccess(service, clusterID1)
+			failures(clusterID1, 2)
+			Expect(breaker.Allow(service, clusterID1)).To(BeTrue())
+			expectState(clusterID1, circuitbreaker.Closed)
+		})
+	})
+
+	When("the threshold of failures is reached", func() {
+		BeforeEach(func() {
+			failures(clusterID1, 3)
+		})
+
+		It("should open and stop allowing only that cluster", func() {
+			Expect(breaker.Peek(service, clusterID1)).To(BeFalse())
+			Expect(breaker.Allow(service, clusterID1)).To(BeFalse())
+			Expect(breaker.Allow(service, clusterID2)).To(BeTrue())
+			expectState(clusterID1, circuitbreaker.Open)
+		})
+
+		It("should still allow the cluster for the other services", func() {
+			Expect(breaker.Allow(service2, clusterID1)).To(BeTrue())
+			Expect(breaker.GetState(service2, clusterID1)).To(Equal(circuitbreaker.Closed))
+		})
+
+		When("it's reset", func() {
+			It("should close before the cooldown expires and require the threshold of failures to re-open", func() {
+				Expect(breaker.Reset(clusterID1)).To(BeTrue())
+				expectState(clusterID1, circuitbreaker.Closed)
+				Expect(breaker.Allow(service, clusterID1)).To(BeTrue())
+
+				failures(clusterID1, 2)
+				Expect(breaker.Allow(service, clusterID1)).To(BeTrue())
+			})
+		})
+
+		When("the cooldown expires", func() {
+			BeforeEach(func() {
+				time.Sleep(cooldown)
+			})
+
+			It("should half-open and allow the cluster", func() {
+				Expect(breaker.Peek(service, clusterID1)).To(BeTrue())
+				expectState(clusterID1, circuitbreaker.Open)
+				Expect(breaker.Allow(service, clusterID1)).To(BeTrue())
+				expectState(clusterID1, circuitbreaker.HalfOpen)
+			})
+
+			When("a success is then recorded", func() {
+				It("should close", func() {
+					Expect(breaker.Allow(service, clusterID1)).To(BeTrue())
+					breaker.RecordSuccess(service, clusterID1)
+					expectState(clusterID1, circuitbreaker.Closed)
+					Expect(breaker.Allow(service, clusterID1)).To(BeTrue())
+				})
+			})
+
+			When("a failure is then recorded", func() {
+				It("should re-open", func() {
+					Expect(breaker.Allow(service, clusterID1)).To(BeTrue())
+					breaker.RecordFailure(service, clusterID1)
+					expectState(clusterID1, circuitbreaker.Open)
+					Expect(breaker.Allow(service, clusterID1)).To(BeFalse())
+				})
+			})
+		})
+	})
+})
@@ -0,0 +1,36 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package circuitbreaker
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/submariner-io/lighthouse/pkg/constants"
+)
+
+var (
+	// BreakerState is the state of each service's circuit breaker in each cluster: 0 for closed, 1 for open and 2 for
+	// half-open.
+	BreakerState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: constants.MetricsNamespace,
+		Name:      "circuit_breaker_state",
+		Help:      "State of the per-service, per-cluster circuit breaker (0 closed, 1 open, 2 half-open).",
+	}, []string{"service", "cluster"})
+)
+
+// Collectors returns the metrics maintained by the circuit breaker.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{BreakerState}
+}
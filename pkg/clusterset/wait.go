@@ -0,0 +1,174 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+// Package clusterset provides helpers for clients of the clusterset services, eg to wait until an exported service is
+// resolvable from a consuming cluster in integration tests or custom controllers. The clients access the consuming
+// cluster, and need to list the ServiceImports in the Lighthouse agent namespace and the EndpointSlices in the
+// service's namespace:
+//
+//	waiter := clusterset.NewWaiter(mcsClient, kubeClient)
+//
+//	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+//	defer cancel()
+//
+//	clusters, err := waiter.WaitForServiceImport(ctx, "nginx", "default", clusterset.WaitOptions{MinClusters: 2})
+//
+// A controller shouldn't block its work queue while waiting, but rather check with a short timeout and requeue the
+// item on error.
+package clusterset
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	lhconstants "github.com/submariner-io/lighthouse/pkg/constants"
+	mcsClientset "github.com/submariner-io/lighthouse/pkg/mcs/client/clientset/versioned"
+	discovery "k8s.io/api/discovery/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
+)
+
+const (
+	// The namespace of the Lighthouse agent, in which the ServiceImports are synced, if not specified.
+	DefaultServiceImportNamespace = "submariner-operator"
+	// The interval between checks if not specified.
+	DefaultPollInterval = time.Second
+)
+
+type WaitOptions struct {
+	// The namespace of the Lighthouse agent, in which the ServiceImports are synced. Defaults to
+	// DefaultServiceImportNamespace.
+	ServiceImportNamespace string
+	// The interval between checks. Defaults to DefaultPollInterval.
+	PollInterval time.Duration
+	// The number of clusters that must contribute to the service for it to be considered resolvable. Defaults to 1.
+	MinClusters int
+}
+
+// A Waiter checks the resources of the consuming cluster its clients access.
+type Waiter struct {
+	mcsClient  mcsClientset.Interface
+	kubeClient kubernetes.Interface
+}
+
+func NewWaiter(mcsClient mcsClientset.Interface, kubeClient kubernetes.Interface) *Waiter {
+	return &Waiter{mcsClient: mcsClient, kubeClient: kubeClient}
+}
+
+// WaitForServiceImport waits until the service with the given name and namespace is resolvable clusterset-wide from
+// the consuming cluster and returns the sorted IDs of the clusters contributing to it. A cluster contributes once its
+// ServiceImport is synced, with an IP for a ClusterSetIP service, and its EndpointSlice has ready endpoints, as done by
+// the Lighthouse DNS plugin. The connectivity of the clusters isn't checked. An error is returned if ctx is done
+// first, with the state of the service when it was last checked.
+func (w *Waiter) WaitForServiceImport(ctx context.Context, name, namespace string, opts WaitOptions) ([]string, error) {
+	if opts.ServiceImportNamespace == "" {
+		opts.ServiceImportNamespace = DefaultServiceImportNamespace
+	}
+
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = DefaultPollInterval
+	}
+
+	if opts.MinClusters <= 0 {
+		opts.MinClusters = 1
+	}
+
+	var (
+		clusters []string
+		lastErr  error
+	)
+
+	err := wait.PollImmediateUntil(opts.PollInterval, func() (bool, error) {
+		clusters, lastErr = w.contributingClusters(name, namespace, opts.ServiceImportNamespace)
+		if lastErr != nil {
+			return false, nil
+		}
+
+		if len(clusters) < opts.MinClusters {
+			lastErr = fmt.Errorf("%d of the required %d clusters contribute: %v", len(clusters), opts.MinClusters,
+				clusters)
+			return false, nil
+		}
+
+		return true, nil
+	}, ctx.Done())
+	if err != nil {
+		return clusters, fmt.Errorf("service \"%s/%s\" isn't resolvable: %v (%v)", namespace, name, lastErr, ctx.Err())
+	}
+
+	return clusters, nil
+}
+
+func (w *Waiter) contributingClusters(name, namespace, serviceImportNamespace string) ([]string, error) {
+	selector := labels.Set(map[string]string{
+		lhconstants.LabelSourceName:      name,
+		lhconstants.LabelSourceNamespace: namespace,
+	}).String()
+
+	serviceImports, err := w.mcsClient.MulticlusterV1alpha1().ServiceImports(serviceImportNamespace).List(
+		metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("error listing the ServiceImports: %v", err)
+	}
+
+	endpointSlices, err := w.kubeClient.DiscoveryV1beta1().EndpointSlices(namespace).List(metav1.ListOptions{
+		LabelSelector: labels.Set(map[string]string{
+			discovery.LabelManagedBy:    lhconstants.LabelValueManagedBy,
+			lhconstants.LabelSourceName: name,
+		}).String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing the EndpointSlices: %v", err)
+	}
+
+	ready := map[string]bool{}
+
+	for i := range endpointSlices.Items {
+		if hasReadyEndpoints(&endpointSlices.Items[i]) {
+			ready[endpointSlices.Items[i].Labels[lhconstants.LabelSourceCluster]] = true
+		}
+	}
+
+	clusters := []string{}
+
+	for i := range serviceImports.Items {
+		si := &serviceImports.Items[i]
+		cluster := si.Labels[lhconstants.LabelSourceCluster]
+
+		if ready[cluster] && (si.Spec.Type == mcsv1a1.Headless || len(si.Spec.IPs) > 0) {
+			clusters = append(clusters, cluster)
+		}
+	}
+
+	sort.Strings(clusters)
+
+	return clusters, nil
+}
+
+func hasReadyEndpoints(endpointSlice *discovery.EndpointSlice) bool {
+	for i := range endpointSlice.Endpoints {
+		ep := &endpointSlice.Endpoints[i]
+		if len(ep.Addresses) > 0 && (ep.Conditions.Ready == nil || *ep.Conditions.Ready) {
+			return true
+		}
+	}
+
+	return false
+}
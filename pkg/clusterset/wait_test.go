@@ -0,0 +1,173 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package clusterset_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/submariner-io/lighthouse/pkg/clusterset"
+	lhconstants "github.com/submariner-io/lighthouse/pkg/constants"
+	fakeMCSClientSet "github.com/submariner-io/lighthouse/pkg/mcs/client/clientset/versioned/fake"
+	discovery "k8s.io/api/discovery/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakeKubeClient "k8s.io/client-go/kubernetes/fake"
+	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
+)
+
+const (
+	service1   = "service1"
+	namespace1 = "namespace1"
+	clusterID1 = "east"
+	clusterID2 = "west"
+)
+
+var _ = Describe("WaitForServiceImport", func() {
+	var (
+		mcsClient  *fakeMCSClientSet.Clientset
+		kubeClient *fakeKubeClient.Clientset
+		waiter     *clusterset.Waiter
+		opts       clusterset.WaitOptions
+	)
+
+	BeforeEach(func() {
+		mcsClient = fakeMCSClientSet.NewSimpleClientset()
+		kubeClient = fakeKubeClient.NewSimpleClientset()
+		waiter = clusterset.NewWaiter(mcsClient, kubeClient)
+		opts = clusterset.WaitOptions{PollInterval: 10 * time.Millisecond}
+	})
+
+	createServiceImport := func(cluster string, svcType mcsv1a1.ServiceImportType, ips ...string) {
+		_, err := mcsClient.MulticlusterV1alpha1().ServiceImports(clusterset.DefaultServiceImportNamespace).Create(
+			&mcsv1a1.ServiceImport{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: service1 + "-" + namespace1 + "-" + cluster,
+					Labels: map[string]string{
+						lhconstants.LabelSourceName:      service1,
+						lhconstants.LabelSourceNamespace: namespace1,
+						lhconstants.LabelSourceCluster:   cluster,
+					},
+				},
+				Spec: mcsv1a1.ServiceImportSpec{Type: svcType, IPs: ips},
+			})
+		Expect(err).To(Succeed())
+	}
+
+	createEndpointSlice := func(cluster string, ready bool) {
+		_, err := kubeClient.DiscoveryV1beta1().EndpointSlices(namespace1).Create(&discovery.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: service1 + "-" + cluster,
+				Labels: map[string]string{
+					discovery.LabelManagedBy:       lhconstants.LabelValueManagedBy,
+					lhconstants.LabelSourceName:    service1,
+					lhconstants.LabelSourceCluster: cluster,
+				},
+			},
+			AddressType: discovery.AddressTypeIPv4,
+			Endpoints: []discovery.Endpoint{{
+				Addresses:  []string{"192.168.5.1"},
+				Conditions: discovery.EndpointConditions{Ready: &ready},
+			}},
+		})
+		Expect(err).To(Succeed())
+	}
+
+	waitFor := func(timeout time.Duration) ([]string, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		return waiter.WaitForServiceImport(ctx, service1, namespace1, opts)
+	}
+
+	When("a ClusterSetIP service is imported with ready endpoints", func() {
+		It("should return the contributing clusters", func() {
+			createServiceImport(clusterID1, mcsv1a1.ClusterSetIP, "10.253.1.1")
+			createEndpointSlice(clusterID1, true)
+			createServiceImport(clusterID2, mcsv1a1.ClusterSetIP, "10.253.2.1")
+			createEndpointSlice(clusterID2, true)
+
+			Expect(waitFor(time.Second)).To(Equal([]string{clusterID1, clusterID2}))
+		})
+	})
+
+	When("a headless service is imported with ready endpoints", func() {
+		It("should return the contributing clusters", func() {
+			createServiceImport(clusterID1, mcsv1a1.Headless)
+			createEndpointSlice(clusterID1, true)
+
+			Expect(waitFor(time.Second)).To(Equal([]string{clusterID1}))
+		})
+	})
+
+	When("a cluster's endpoints aren't ready", func() {
+		It("should not return the cluster", func() {
+			createServiceImport(clusterID1, mcsv1a1.ClusterSetIP, "10.253.1.1")
+			createEndpointSlice(clusterID1, true)
+			createServiceImport(clusterID2, mcsv1a1.ClusterSetIP, "10.253.2.1")
+			createEndpointSlice(clusterID2, false)
+
+			Expect(waitFor(time.Second)).To(Equal([]string{clusterID1}))
+		})
+	})
+
+	When("the service becomes resolvable while waiting", func() {
+		It("should return once it's resolvable", func() {
+			go func() {
+				defer GinkgoRecover()
+
+				time.Sleep(100 * time.Millisecond)
+				createServiceImport(clusterID1, mcsv1a1.ClusterSetIP, "10.253.1.1")
+				time.Sleep(100 * time.Millisecond)
+				createEndpointSlice(clusterID1, true)
+			}()
+
+			Expect(waitFor(5 * time.Second)).To(Equal([]string{clusterID1}))
+		})
+	})
+
+	When("fewer than the required clusters contribute", func() {
+		It("should wait until enough clusters contribute", func() {
+			opts.MinClusters = 2
+
+			createServiceImport(clusterID1, mcsv1a1.ClusterSetIP, "10.253.1.1")
+			createEndpointSlice(clusterID1, true)
+
+			go func() {
+				defer GinkgoRecover()
+
+				time.Sleep(100 * time.Millisecond)
+				createServiceImport(clusterID2, mcsv1a1.ClusterSetIP, "10.253.2.1")
+				createEndpointSlice(clusterID2, true)
+			}()
+
+			Expect(waitFor(5 * time.Second)).To(Equal([]string{clusterID1, clusterID2}))
+		})
+	})
+
+	When("the service doesn't become resolvable before the context expires", func() {
+		It("should return an error", func() {
+			createServiceImport(clusterID1, mcsv1a1.ClusterSetIP)
+			createEndpointSlice(clusterID1, true)
+
+			_, err := waitFor(100 * time.Millisecond)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("0 of the required 1 clusters contribute"))
+			Expect(err.Error()).To(ContainSubstring(context.DeadlineExceeded.Error()))
+		})
+	})
+})
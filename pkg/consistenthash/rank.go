@@ -0,0 +1,68 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package consistenthash
+
+import (
+	"hash/fnv"
+	"sort"
+)
+
+// Rank orders the members by their rendezvous (highest random weight) hash score for the given key. The order for a
+// key is stable and doesn't depend on the order of the members. Removing a member only changes the first member for
+// the keys that ranked it first, while adding one only takes over the keys for which it ranks first.
+func Rank(key string, members []string) []string {
+	type scored struct {
+		member string
+		score  uint64
+	}
+
+	scores := make([]scored, len(members))
+	for i, member := range members {
+		scores[i] = scored{member: member, score: score(key, member)}
+	}
+
+	sort.SliceStable(scores, func(i, j int) bool {
+		if scores[i].score != scores[j].score {
+			return scores[i].score > scores[j].score
+		}
+
+		return scores[i].member < scores[j].member
+	})
+
+	ranked := make([]string, len(scores))
+	for i := range scores {
+		ranked[i] = scores[i].member
+	}
+
+	return ranked
+}
+
+func score(key, member string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write([]byte(member))
+
+	// FNV alone distributes similar inputs poorly so mix the bits, as in the splitmix64 finalizer.
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+
+	return x
+}
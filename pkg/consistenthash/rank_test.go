@@ -0,0 +1,93 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package consistenthash_test
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/submariner-io/lighthouse/pkg/consistenthash"
+)
+
+var _ = Describe("Rank", func() {
+	members := []string{"10.1.0.1", "10.1.0.2", "10.1.0.3", "10.1.0.4"}
+
+	clientKeys := func(count int) []string {
+		keys := make([]string, count)
+		for i := range keys {
+			keys[i] = fmt.Sprintf("10.240.%d.%d", i/256, i%256)
+		}
+
+		return keys
+	}
+
+	When("called repeatedly for the same key", func() {
+		It("should return the same order", func() {
+			ranked := consistenthash.Rank("10.240.0.1", members)
+			Expect(ranked).To(ConsistOf(members))
+
+			for i := 0; i < 5; i++ {
+				Expect(consistenthash.Rank("10.240.0.1", members)).To(Equal(ranked))
+			}
+		})
+	})
+
+	When("the members are given in a different order", func() {
+		It("should return the same order", func() {
+			reversed := []string{members[3], members[2], members[1], members[0]}
+			Expect(consistenthash.Rank("10.240.0.1", reversed)).To(Equal(consistenthash.Rank("10.240.0.1", members)))
+		})
+	})
+
+	When("called for many keys", func() {
+		It("should spread the first member across all members", func() {
+			firsts := map[string]int{}
+			for _, key := range clientKeys(1000) {
+				firsts[consistenthash.Rank(key, members)[0]]++
+			}
+
+			Expect(firsts).To(HaveLen(len(members)))
+
+			for _, count := range firsts {
+				Expect(count).To(BeNumerically(">", 150))
+			}
+		})
+	})
+
+	When("a member is removed", func() {
+		It("should only move the keys that ranked it first", func() {
+			remaining := members[1:]
+
+			for _, key := range clientKeys(500) {
+				before := consistenthash.Rank(key, members)
+				after := consistenthash.Rank(key, remaining)
+
+				if before[0] == members[0] {
+					Expect(after[0]).To(Equal(before[1]))
+				} else {
+					Expect(after[0]).To(Equal(before[0]))
+				}
+			}
+		})
+	})
+
+	When("there are no members", func() {
+		It("should return an empty order", func() {
+			Expect(consistenthash.Rank("10.240.0.1", nil)).To(BeEmpty())
+		})
+	})
+})
@@ -16,11 +16,47 @@ limitations under the License.
 package constants
 
 const (
-	OriginName             = "origin-name"
-	OriginNamespace        = "origin-namespace"
-	LabelSourceName        = "lighthouse.submariner.io/sourceName"
-	LabelSourceNamespace   = "lighthouse.submariner.io/sourceNamespace"
-	LabelSourceCluster     = "lighthouse.submariner.io/sourceCluster"
-	LabelServiceImportName = "multicluster.kubernetes.io/service-name"
-	LabelValueManagedBy    = "lighthouse-agent.submariner.io"
+	OriginName                 = "origin-name"
+	OriginNamespace            = "origin-namespace"
+	LabelSourceName            = "lighthouse.submariner.io/sourceName"
+	LabelSourceNamespace       = "lighthouse.submariner.io/sourceNamespace"
+	LabelSourceCluster         = "lighthouse.submariner.io/sourceCluster"
+	LabelServiceImportName     = "multicluster.kubernetes.io/service-name"
+	LabelValueManagedBy        = "lighthouse-agent.submariner.io"
+	AnnotationVariant          = "lighthouse.submariner.io/variant"
+	AnnotationExportTime       = "lighthouse.submariner.io/export-time"
+	LabelExport                = "lighthouse.submariner.io/export"
+	AnnotationAutoExported     = "lighthouse.submariner.io/auto-exported"
+	AnnotationMinClusters      = "lighthouse.submariner.io/min-clusters"
+	AnnotationPortRemap        = "lighthouse.submariner.io/port-remap"
+	AnnotationClustersetUID    = "lighthouse.submariner.io/clusterset-uid"
+	AnnotationWeight           = "lighthouse.submariner.io/weight"
+	AnnotationDraining         = "lighthouse.submariner.io/draining"
+	AnnotationGlobalName       = "lighthouse.submariner.io/global-name"
+	AnnotationHTTPRoute        = "lighthouse.submariner.io/http-route"
+	AnnotationBackend          = "lighthouse.submariner.io/backend-service"
+	AnnotationEndpointSelector = "lighthouse.submariner.io/endpoint-selector"
+	AnnotationSingleton        = "lighthouse.submariner.io/singleton"
+	AnnotationExportDelay      = "lighthouse.submariner.io/export-delay"
+	AnnotationFrozen           = "lighthouse.submariner.io/frozen"
+	// Set by the exporting agent on the ServiceImport of an export within its export delay, as per its own clock.
+	AnnotationExportPending = "lighthouse.submariner.io/export-pending"
+	// The time the exporting agent last refreshed an EndpointSlice, as a heartbeat its staleness is checked against.
+	AnnotationRefreshed = "lighthouse.submariner.io/refreshed"
+	// The internalTrafficPolicy of the exported Service, set on its ServiceImport if the Service has one.
+	AnnotationInternalTrafficPolicy = "lighthouse.submariner.io/internal-traffic-policy"
+	// Whether the local or the clusterset answers take precedence for a service resolvable in both.
+	AnnotationDNSPriority = "lighthouse.submariner.io/dns-priority"
+	MetricsNamespace      = "lighthouse"
+	// The exporting cluster's IP of a ClusterSetIP service, set on its ServiceImport whose spec.ips are the clusterset
+	// VIPs of the service if it has any, otherwise the same IP.
+	AnnotationClusterIP = "cluster-ip"
+	// The internalTrafficPolicy restricting a Service's traffic to node-local endpoints.
+	InternalTrafficPolicyLocal = "Local"
+	// The AnnotationDNSPriority values.
+	DNSPriorityLocal      = "local"
+	DNSPriorityClusterset = "clusterset"
 )
+
+// MaxWeight is the highest weight a cluster's export of a service can be given by the AnnotationWeight annotation.
+const MaxWeight = 100
@@ -17,8 +17,10 @@ package endpointslice
 
 import (
 	"fmt"
+	"reflect"
 
 	lhconstants "github.com/submariner-io/lighthouse/pkg/constants"
+	"github.com/submariner-io/lighthouse/pkg/liveness"
 	discovery "k8s.io/api/discovery/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
@@ -38,17 +40,24 @@ var NewClientset NewClientsetFunc
 type Controller struct {
 	// Indirection hook for unit tests to supply fake client sets
 	NewClientset NewClientsetFunc
-	epsInformer  cache.Controller
-	stopCh       chan struct{}
-	store        Store
-	clientSet    kubernetes.Interface
+	// Rewrites the endpoints after the Transforms, eg to their global addresses, set before Start.
+	EndpointTransformer EndpointTransformer
+	// The bounds on the EndpointSlices cached and the transforms applied to them before they are, set before Start.
+	Limits      Limits
+	Transforms  []Transform
+	epsInformer cache.Controller
+	epsStore    cache.Store
+	stopCh      chan struct{}
+	store       Store
+	clientSet   kubernetes.Interface
 }
 
 func NewController(endpointSliceStore Store) *Controller {
 	return &Controller{
-		NewClientset: getNewClientsetFunc(),
-		stopCh:       make(chan struct{}),
-		store:        endpointSliceStore,
+		NewClientset:        getNewClientsetFunc(),
+		EndpointTransformer: IdentityTransformer{},
+		stopCh:              make(chan struct{}),
+		store:               endpointSliceStore,
 	}
 }
 
@@ -75,26 +84,48 @@ func (c *Controller) Start(kubeConfig *rest.Config) error {
 		discovery.LabelManagedBy: lhconstants.LabelValueManagedBy,
 	}
 	labelSelector := labels.Set(labelMap).String()
+	transforms := append(append([]Transform{}, c.Transforms...), TransformEndpoints(c.EndpointTransformer))
+	filter := newIngestFilter(c.Limits, transforms)
 
-	_, c.epsInformer = cache.NewInformer(
+	c.epsStore, c.epsInformer = cache.NewInformer(
 		&cache.ListWatch{
 			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
 				options.LabelSelector = labelSelector
-				return clientSet.DiscoveryV1beta1().EndpointSlices(metav1.NamespaceAll).List(options)
+				list, err := clientSet.DiscoveryV1beta1().EndpointSlices(metav1.NamespaceAll).List(options)
+				if err != nil {
+					return nil, err
+				}
+
+				filter.filterList(list)
+
+				return list, nil
 			},
 			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
 				options.LabelSelector = labelSelector
-				return clientSet.DiscoveryV1beta1().EndpointSlices(metav1.NamespaceAll).Watch(options)
+				w, err := clientSet.DiscoveryV1beta1().EndpointSlices(metav1.NamespaceAll).Watch(options)
+				if err != nil {
+					return nil, err
+				}
+
+				return watch.Filter(w, filter.filterEvent), nil
 			},
 		},
 		&discovery.EndpointSlice{},
-		0,
+		liveness.ResyncPeriod,
 		cache.ResourceEventHandlerFuncs{
 			AddFunc: func(obj interface{}) {
 				c.store.Put(obj.(*discovery.EndpointSlice))
+				c.updateEndpointSliceCounts()
+				liveness.Reconciled(liveness.ControllerEndpointSlice)
 			},
 			UpdateFunc: func(old interface{}, new interface{}) {
-				c.store.Put(new.(*discovery.EndpointSlice))
+				// A resync redelivers an unchanged EndpointSlice, which only shows the controller is still processing its
+				// events.
+				if !reflect.DeepEqual(old, new) {
+					c.store.Put(new.(*discovery.EndpointSlice))
+				}
+
+				liveness.Reconciled(liveness.ControllerEndpointSlice)
 			},
 			DeleteFunc: func(obj interface{}) {
 				var endpointSlice *discovery.EndpointSlice
@@ -114,21 +145,55 @@ func (c *Controller) Start(kubeConfig *rest.Config) error {
 					}
 				}
 				c.store.Remove(endpointSlice)
+				c.updateEndpointSliceCounts()
+				liveness.Reconciled(liveness.ControllerEndpointSlice)
 			},
 		},
 	)
 
 	go c.epsInformer.Run(c.stopCh)
 
+	serviceEndpoints.setController(c)
+
 	return nil
 }
 
+// HasSynced returns true once the EndpointSlices listed when the controller started were added to the map. Unlike the
+// other controllers, Start doesn't wait for it.
+func (c *Controller) HasSynced() bool {
+	return c.epsInformer != nil && c.epsInformer.HasSynced()
+}
+
 func (c *Controller) Stop() {
 	close(c.stopCh)
+	serviceEndpoints.clearController(c)
 
 	klog.Infof("EndpointSlice Controller stopped")
 }
 
+// updateEndpointSliceCounts recomputes the ImportedEndpointSlices gauge from the informer cache so it can't drift from
+// the actual state.
+func (c *Controller) updateEndpointSliceCounts() {
+	counts := map[string]int{}
+
+	for _, obj := range c.epsStore.List() {
+		counts[obj.(*discovery.EndpointSlice).Labels[lhconstants.LabelSourceCluster]]++
+	}
+
+	ImportedEndpointSlices.Reset()
+
+	for cluster, count := range counts {
+		ImportedEndpointSlices.WithLabelValues(cluster).Set(float64(count))
+	}
+}
+
+// EndpointCounts returns the ready and total endpoint counts of each exported service with imported endpoints, by
+// "namespace/name", and the number of clusters contributing ready endpoints. It's computed from the cached
+// EndpointSlices, without a query.
+func (c *Controller) EndpointCounts() map[string]EndpointCounts {
+	return c.store.GetEndpointCounts()
+}
+
 func (c *Controller) IsHealthy(name, namespace, clusterId string) bool {
 	key := keyFunc(name, namespace)
 	endpointInfo := c.store.Get(key)
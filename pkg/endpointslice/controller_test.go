@@ -16,12 +16,17 @@ limitations under the License.
 package endpointslice_test
 
 import (
+	"net"
+	"strings"
+	"sync"
 	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	lhconstants "github.com/submariner-io/lighthouse/pkg/constants"
 	"github.com/submariner-io/lighthouse/pkg/endpointslice"
+	"github.com/submariner-io/lighthouse/pkg/liveness"
 	"k8s.io/api/discovery/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
@@ -72,6 +77,20 @@ var _ = Describe("EndpointSlice controller", func() {
 		})
 	})
 
+	When("IsHealthy is called for a service whose endpoints are all in an excluded CIDR", func() {
+		It("should return false", func() {
+			_, cidr, err := net.ParseCIDR("192.168.0.0/24")
+			Expect(err).To(Succeed())
+			t.epMap.ExcludeCIDRs([]*net.IPNet{cidr})
+
+			esName := testName1 + remoteClusterID1
+			endPoint1 := t.newEndpoint(cluster1HostNamePod1, cluster1EndPointIP1)
+			endpointSlice := t.newEndpointSliceFromEndpoint(testService1, remoteClusterID1, esName, testNS1, []v1beta1.Endpoint{endPoint1})
+			t.createEndpointSlice(testNS1, endpointSlice)
+			t.awaitNotIsHealthy(testService1, testNS1, remoteClusterID1)
+		})
+	})
+
 	When("a service exists in multiple clusters with valid endpoints", func() {
 		When("IsHealthy is called for each cluster", func() {
 			It("should return true", func() {
@@ -101,8 +120,297 @@ var _ = Describe("EndpointSlice controller", func() {
 			t.awaitNotIsHealthy(testService1, testNS1, "randomcluster")
 		})
 	})
+
+	When("EndpointSlices are imported from multiple clusters", func() {
+		It("should update the imported EndpointSlices gauge by source cluster", func() {
+			endPoint1 := t.newEndpoint(cluster1HostNamePod1, cluster1EndPointIP1)
+			t.createEndpointSlice(testNS1, t.newEndpointSliceFromEndpoint(testService1, remoteClusterID1,
+				testName1+remoteClusterID1, testNS1, []v1beta1.Endpoint{endPoint1}))
+			t.createEndpointSlice(testNS2, t.newEndpointSliceFromEndpoint(testService2, remoteClusterID1,
+				testName2+remoteClusterID1, testNS2, []v1beta1.Endpoint{endPoint1}))
+
+			endPoint2 := t.newEndpoint(cluster2HostNamePod1, cluster2EndPointIP1)
+			t.createEndpointSlice(testNS1, t.newEndpointSliceFromEndpoint(testService1, remoteClusterID2,
+				testName1+remoteClusterID2, testNS1, []v1beta1.Endpoint{endPoint2}))
+
+			t.awaitImportedEndpointSlices(remoteClusterID1, 2)
+			t.awaitImportedEndpointSlices(remoteClusterID2, 1)
+
+			Expect(t.kubeClient.DiscoveryV1beta1().EndpointSlices(testNS1).Delete(testName1+remoteClusterID2,
+				&metav1.DeleteOptions{})).To(Succeed())
+			t.awaitImportedEndpointSlices(remoteClusterID2, 0)
+			t.awaitImportedEndpointSlices(remoteClusterID1, 2)
+		})
+	})
+
+	When("an EndpointSlice is imported", func() {
+		It("should advance the last reconcile timestamp", func() {
+			reconciled := func() float64 {
+				return testutil.ToFloat64(liveness.LastReconcileTimestamp.WithLabelValues(liveness.ControllerEndpointSlice))
+			}
+
+			last := reconciled()
+
+			t.createEndpointSlice(testNS1, t.newEndpointSliceFromEndpoint(testService1, remoteClusterID1,
+				testName1+remoteClusterID1, testNS1, []v1beta1.Endpoint{t.newEndpoint(cluster1HostNamePod1, cluster1EndPointIP1)}))
+
+			Eventually(reconciled, 5).Should(BeNumerically(">", last))
+		})
+	})
 })
 
+var _ = Describe("EndpointSlice controller ingest", func() {
+	var (
+		kubeClient kubernetes.Interface
+		store      *recordingStore
+		controller *endpointslice.Controller
+	)
+
+	newSlice := func(name, cluster string) *v1beta1.EndpointSlice {
+		es := (&endpointSliceTestDriver{}).newEndpointSliceFromEndpoint(testService1, cluster, name, testNS1,
+			[]v1beta1.Endpoint{{Addresses: []string{cluster1EndPointIP1}}})
+		es.AddressType = v1beta1.AddressTypeIPv4
+
+		return es
+	}
+
+	create := func(es *v1beta1.EndpointSlice) {
+		_, err := kubeClient.DiscoveryV1beta1().EndpointSlices(es.Namespace).Create(es)
+		Expect(err).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		kubeClient = fakeKubeClient.NewSimpleClientset()
+		store = &recordingStore{Map: endpointslice.NewMap(), slices: map[string]*v1beta1.EndpointSlice{}}
+		controller = endpointslice.NewController(store)
+		controller.NewClientset = func(c *rest.Config) (kubernetes.Interface, error) {
+			return kubeClient, nil
+		}
+	})
+
+	JustBeforeEach(func() {
+		Expect(controller.Start(&rest.Config{})).To(Succeed())
+	})
+
+	AfterEach(func() {
+		controller.Stop()
+	})
+
+	When("a service has more EndpointSlices than its limit", func() {
+		BeforeEach(func() {
+			controller.Limits = endpointslice.Limits{PerService: 2}
+		})
+
+		It("should drop the EndpointSlices beyond it until others are deleted", func() {
+			create(newSlice("slice1", remoteClusterID1))
+			create(newSlice("slice2", remoteClusterID2))
+			Eventually(store.names, 5).Should(ConsistOf("slice1", "slice2"))
+
+			create(newSlice("slice3", remoteClusterID2))
+			Consistently(store.names, 300*time.Millisecond).Should(ConsistOf("slice1", "slice2"))
+			Expect(testutil.ToFloat64(endpointslice.DroppedEndpointSlices.WithLabelValues(testNS1+"/"+testService1,
+				remoteClusterID2))).To(Equal(float64(1)))
+
+			Expect(kubeClient.DiscoveryV1beta1().EndpointSlices(testNS1).Delete("slice1",
+				&metav1.DeleteOptions{})).To(Succeed())
+			Eventually(store.names, 5).Should(ConsistOf("slice2"))
+
+			slice3 := newSlice("slice3", remoteClusterID2)
+			slice3.Endpoints[0].Addresses = []string{cluster2EndPointIP1}
+			_, err := kubeClient.DiscoveryV1beta1().EndpointSlices(testNS1).Update(slice3)
+			Expect(err).To(Succeed())
+
+			Eventually(store.names, 5).Should(ConsistOf("slice2", "slice3"))
+			Expect(testutil.ToFloat64(endpointslice.DroppedEndpointSlices.WithLabelValues(testNS1+"/"+testService1,
+				remoteClusterID2))).To(Equal(float64(0)))
+		})
+	})
+
+	When("the managed fields are stripped", func() {
+		BeforeEach(func() {
+			controller.Transforms = []endpointslice.Transform{endpointslice.StripManagedFields,
+				endpointslice.StripLargeAnnotations(10)}
+		})
+
+		It("should cache the EndpointSlices without them", func() {
+			es := newSlice("slice1", remoteClusterID1)
+			es.ManagedFields = []metav1.ManagedFieldsEntry{
+				{Manager: "lighthouse-agent", Operation: metav1.ManagedFieldsOperationApply},
+			}
+			es.Annotations = map[string]string{"small": "value", "large": "a value larger than the limit"}
+			create(es)
+
+			Eventually(store.names, 5).Should(ConsistOf("slice1"))
+
+			cached := store.get("slice1")
+			Expect(cached.ManagedFields).To(BeEmpty())
+			Expect(cached.Annotations).To(Equal(map[string]string{"small": "value"}))
+		})
+	})
+	When("EndpointSlices are imported and deleted", func() {
+		It("should reflect them in the endpoint counts and their metrics", func() {
+			key := testNS1 + "/" + testService1
+
+			create(newSlice("slice1", remoteClusterID1))
+			create(newSlice("slice2", remoteClusterID2))
+			Eventually(controller.EndpointCounts, 5).Should(Equal(map[string]endpointslice.EndpointCounts{
+				key: {Ready: 2, Total: 2, Clusters: 2},
+			}))
+
+			Expect(testutil.CollectAndCompare(endpointslice.EndpointCountsCollector(), strings.NewReader(`
+# HELP lighthouse_service_endpoint_clusters Number of clusters with ready endpoints for the service.
+# TYPE lighthouse_service_endpoint_clusters gauge
+lighthouse_service_endpoint_clusters{service="`+key+`"} 2
+# HELP lighthouse_service_ready_endpoints Number of ready endpoints of the service across the clusters.
+# TYPE lighthouse_service_ready_endpoints gauge
+lighthouse_service_ready_endpoints{service="`+key+`"} 2
+`), "lighthouse_service_endpoint_clusters", "lighthouse_service_ready_endpoints")).To(Succeed())
+
+			Expect(kubeClient.DiscoveryV1beta1().EndpointSlices(testNS1).Delete("slice1",
+				&metav1.DeleteOptions{})).To(Succeed())
+			Eventually(controller.EndpointCounts, 5).Should(Equal(map[string]endpointslice.EndpointCounts{
+				key: {Ready: 1, Total: 1, Clusters: 1},
+			}))
+
+			Expect(kubeClient.DiscoveryV1beta1().EndpointSlices(testNS1).Delete("slice2",
+				&metav1.DeleteOptions{})).To(Succeed())
+			Eventually(controller.EndpointCounts, 5).Should(BeEmpty())
+			Expect(testutil.CollectAndCompare(endpointslice.EndpointCountsCollector(), strings.NewReader(""))).To(Succeed())
+		})
+	})
+
+	When("no endpoint transformer is set", func() {
+		It("should cache the endpoints unchanged", func() {
+			create(newSlice("slice1", remoteClusterID1))
+
+			Eventually(store.names, 5).Should(ConsistOf("slice1"))
+			Expect(store.get("slice1").Endpoints[0].Addresses).To(Equal([]string{cluster1EndPointIP1}))
+		})
+	})
+
+	When("an endpoint transformer rewrites the addresses", func() {
+		BeforeEach(func() {
+			controller.EndpointTransformer = addressTransformer{remoteClusterID1: cluster2EndPointIP1}
+		})
+
+		It("should cache the EndpointSlices of the source cluster with the rewritten addresses", func() {
+			create(newSlice("slice1", remoteClusterID1))
+			create(newSlice("slice2", remoteClusterID2))
+
+			Eventually(store.names, 5).Should(ConsistOf("slice1", "slice2"))
+			Expect(store.get("slice1").Endpoints[0].Addresses).To(Equal([]string{cluster2EndPointIP1}))
+			Expect(store.get("slice2").Endpoints[0].Addresses).To(Equal([]string{cluster1EndPointIP1}))
+
+			ips, found := store.GetIPs("", remoteClusterID1, testNS1, testService1, nil)
+			Expect(found).To(BeTrue())
+			Expect(ips).To(Equal([]string{cluster2EndPointIP1}))
+		})
+	})
+
+	When("the global CIDR transformer is set", func() {
+		BeforeEach(func() {
+			transformer := endpointslice.NewGlobalCIDRTransformer()
+			Expect(transformer.AddMapping(remoteClusterID1, parseCIDR("192.168.0.0/16"),
+				parseCIDR("242.0.0.0/16"))).To(Succeed())
+			controller.EndpointTransformer = transformer
+		})
+
+		It("should map the addresses in the cluster's CIDR to its global CIDR", func() {
+			create(newSlice("slice1", remoteClusterID1))
+			create(newSlice("slice2", remoteClusterID2))
+
+			Eventually(store.names, 5).Should(ConsistOf("slice1", "slice2"))
+			Expect(store.get("slice1").Endpoints[0].Addresses).To(Equal([]string{"242.0.0.1"}))
+			Expect(store.get("slice2").Endpoints[0].Addresses).To(Equal([]string{cluster1EndPointIP1}))
+		})
+	})
+})
+
+var _ = Describe("GlobalCIDRTransformer", func() {
+	var transformer *endpointslice.GlobalCIDRTransformer
+
+	BeforeEach(func() {
+		transformer = endpointslice.NewGlobalCIDRTransformer()
+		Expect(transformer.AddMapping(remoteClusterID1, parseCIDR("10.1.0.0/16"), parseCIDR("242.1.0.0/16"))).To(Succeed())
+		Expect(transformer.AddMapping(remoteClusterID1, parseCIDR("fd00:1::/64"), parseCIDR("fd00:242::/64"))).To(Succeed())
+	})
+
+	It("should keep the host bits of the addresses in a mapped CIDR", func() {
+		ep := transformer.TransformEndpoint(v1beta1.Endpoint{Addresses: []string{"10.1.2.3", "fd00:1::5", "10.2.0.1"}},
+			remoteClusterID1)
+		Expect(ep.Addresses).To(Equal([]string{"242.1.2.3", "fd00:242::5", "10.2.0.1"}))
+	})
+
+	It("should return the endpoints of the other clusters unchanged", func() {
+		ep := transformer.TransformEndpoint(v1beta1.Endpoint{Addresses: []string{"10.1.2.3"}}, remoteClusterID2)
+		Expect(ep.Addresses).To(Equal([]string{"10.1.2.3"}))
+	})
+
+	It("should reject a global CIDR of a different size", func() {
+		Expect(transformer.AddMapping(remoteClusterID2, parseCIDR("10.1.0.0/16"), parseCIDR("242.1.0.0/24"))).ToNot(Succeed())
+	})
+})
+
+// addressTransformer replaces the addresses of the endpoints from a cluster with the cluster's address.
+type addressTransformer map[string]string
+
+func (t addressTransformer) TransformEndpoint(ep v1beta1.Endpoint, sourceCluster string) v1beta1.Endpoint {
+	if address, ok := t[sourceCluster]; ok {
+		ep.Addresses = []string{address}
+	}
+
+	return ep
+}
+
+func parseCIDR(s string) *net.IPNet {
+	_, cidr, err := net.ParseCIDR(s)
+	Expect(err).To(Succeed())
+
+	return cidr
+}
+
+// recordingStore records the EndpointSlices put in the Map, as cached by the controller.
+type recordingStore struct {
+	*endpointslice.Map
+	sync.Mutex
+	slices map[string]*v1beta1.EndpointSlice
+}
+
+func (s *recordingStore) Put(es *v1beta1.EndpointSlice) {
+	s.Lock()
+	s.slices[es.Name] = es
+	s.Unlock()
+
+	s.Map.Put(es)
+}
+
+func (s *recordingStore) Remove(es *v1beta1.EndpointSlice) {
+	s.Lock()
+	delete(s.slices, es.Name)
+	s.Unlock()
+
+	s.Map.Remove(es)
+}
+
+func (s *recordingStore) names() []string {
+	s.Lock()
+	defer s.Unlock()
+
+	names := []string{}
+	for name := range s.slices {
+		names = append(names, name)
+	}
+
+	return names
+}
+
+func (s *recordingStore) get(name string) *v1beta1.EndpointSlice {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.slices[name]
+}
+
 type endpointSliceTestDriver struct {
 	controller *endpointslice.Controller
 	kubeClient kubernetes.Interface
@@ -171,3 +479,9 @@ func (t *endpointSliceTestDriver) awaitNotIsHealthy(name, nameSpace, clusterId s
 		return t.controller.IsHealthy(name, nameSpace, clusterId)
 	}, 500*time.Millisecond).Should(BeFalse())
 }
+
+func (t *endpointSliceTestDriver) awaitImportedEndpointSlices(clusterID string, expected int) {
+	Eventually(func() float64 {
+		return testutil.ToFloat64(endpointslice.ImportedEndpointSlices.WithLabelValues(clusterID))
+	}, 5).Should(Equal(float64(expected)))
+}
@@ -0,0 +1,32 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package endpointslice
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SetClock replaces the clock used to timestamp and age the EndpointSlices of the given Map.
+func SetClock(m *Map, now func() time.Time) {
+	m.now = now
+}
+
+// EndpointCountsCollector returns the collector of the endpoint counts of each service.
+func EndpointCountsCollector() prometheus.Collector {
+	return serviceEndpoints
+}
@@ -0,0 +1,186 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package endpointslice
+
+import (
+	"sync"
+
+	lhconstants "github.com/submariner-io/lighthouse/pkg/constants"
+	discovery "k8s.io/api/discovery/v1beta1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/klog"
+)
+
+// A Transform modifies an EndpointSlice before it's cached, eg to strip the fields that aren't needed to answer the
+// queries so they don't take memory.
+type Transform func(es *discovery.EndpointSlice)
+
+// StripManagedFields removes the managed fields, which are only used by server-side apply.
+func StripManagedFields(es *discovery.EndpointSlice) {
+	es.ManagedFields = nil
+}
+
+// StripLargeAnnotations returns a Transform removing the annotations whose value is larger than maxSize bytes, eg the
+// last applied configuration.
+func StripLargeAnnotations(maxSize int) Transform {
+	return func(es *discovery.EndpointSlice) {
+		for key, value := range es.Annotations {
+			if len(value) > maxSize {
+				delete(es.Annotations, key)
+			}
+		}
+	}
+}
+
+// Limits bounds the number of EndpointSlices cached, per service and per source cluster. Zero is unlimited.
+type Limits struct {
+	PerService int
+	PerCluster int
+}
+
+type sliceOwner struct {
+	service string
+	cluster string
+}
+
+// ingestFilter transforms the listed and watched EndpointSlices and drops those beyond the limits before they reach
+// the informer cache. The EndpointSlices are admitted in the order they're seen, and a dropped EndpointSlice is
+// admitted once it's updated after others were deleted, or on the next relist.
+type ingestFilter struct {
+	sync.Mutex
+	limits     Limits
+	transforms []Transform
+	admitted   map[string]sliceOwner
+	dropped    map[string]sliceOwner
+	services   map[string]int
+	clusters   map[string]int
+}
+
+func newIngestFilter(limits Limits, transforms []Transform) *ingestFilter {
+	f := &ingestFilter{limits: limits, transforms: transforms}
+	f.reset()
+
+	return f
+}
+
+func (f *ingestFilter) reset() {
+	f.Lock()
+	defer f.Unlock()
+
+	f.admitted = map[string]sliceOwner{}
+	f.dropped = map[string]sliceOwner{}
+	f.services = map[string]int{}
+	f.clusters = map[string]int{}
+
+	DroppedEndpointSlices.Reset()
+}
+
+// filterList replaces the listed EndpointSlices with the admitted ones, transformed.
+func (f *ingestFilter) filterList(list *discovery.EndpointSliceList) {
+	f.reset()
+
+	items := list.Items[:0]
+
+	for i := range list.Items {
+		if f.admit(&list.Items[i]) {
+			items = append(items, list.Items[i])
+		}
+	}
+
+	list.Items = items
+}
+
+func (f *ingestFilter) filterEvent(event watch.Event) (watch.Event, bool) {
+	es, ok := event.Object.(*discovery.EndpointSlice)
+	if !ok {
+		return event, true
+	}
+
+	switch event.Type {
+	case watch.Added, watch.Modified:
+		return event, f.admit(es)
+	case watch.Deleted:
+		return event, f.release(es)
+	}
+
+	return event, true
+}
+
+func (f *ingestFilter) admit(es *discovery.EndpointSlice) bool {
+	for _, transform := range f.transforms {
+		transform(es)
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	key := es.Namespace + "/" + es.Name
+	if _, ok := f.admitted[key]; ok {
+		return true
+	}
+
+	owner := sliceOwner{service: serviceLabel(es), cluster: es.Labels[lhconstants.LabelSourceCluster]}
+
+	if (f.limits.PerService > 0 && f.services[owner.service] >= f.limits.PerService) ||
+		(f.limits.PerCluster > 0 && f.clusters[owner.cluster] >= f.limits.PerCluster) {
+		if _, ok := f.dropped[key]; !ok {
+			klog.Warningf("Dropping the EndpointSlice %q for %q from %q as the service or cluster is at its limit of "+
+				"cached EndpointSlices %+v", key, owner.service, owner.cluster, f.limits)
+			DroppedEndpointSlices.WithLabelValues(owner.service, owner.cluster).Inc()
+
+			f.dropped[key] = owner
+		}
+
+		return false
+	}
+
+	if _, ok := f.dropped[key]; ok {
+		DroppedEndpointSlices.WithLabelValues(owner.service, owner.cluster).Dec()
+		delete(f.dropped, key)
+	}
+
+	f.admitted[key] = owner
+	f.services[owner.service]++
+	f.clusters[owner.cluster]++
+
+	return true
+}
+
+// release forgets a deleted EndpointSlice and returns whether it was admitted, as only those are cached.
+func (f *ingestFilter) release(es *discovery.EndpointSlice) bool {
+	f.Lock()
+	defer f.Unlock()
+
+	key := es.Namespace + "/" + es.Name
+
+	if owner, ok := f.dropped[key]; ok {
+		DroppedEndpointSlices.WithLabelValues(owner.service, owner.cluster).Dec()
+		delete(f.dropped, key)
+
+		return false
+	}
+
+	owner, ok := f.admitted[key]
+	if !ok {
+		return false
+	}
+
+	delete(f.admitted, key)
+	f.services[owner.service]--
+	f.clusters[owner.cluster]--
+
+	return true
+}
@@ -16,7 +16,13 @@ limitations under the License.
 package endpointslice
 
 import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/submariner-io/admiral/pkg/log"
 	"github.com/submariner-io/lighthouse/pkg/constants"
@@ -25,20 +31,52 @@ import (
 )
 
 type endpointInfo struct {
-	key         string
+	key string
+	// The "namespace/name" of the service.
+	service     string
 	clusterInfo map[string]*clusterInfo
+	rrCount     uint64
 }
 
+// EndpointCounts are the endpoint counts of a service aggregated across the clusters, the excluded addresses aside.
+type EndpointCounts struct {
+	Ready int
+	Total int
+	// The number of clusters with ready endpoints.
+	Clusters int
+}
+
+// clusterInfo holds the endpoints of a service in a cluster, aggregated from all its EndpointSlices.
 type clusterInfo struct {
 	hostIPs map[string][]string
 	ipList  []string
+	// Maps each address dropped because it matches an excluded CIDR to the CIDR.
+	excluded map[string]string
+	// Maps the name of each of the service's EndpointSlices in the cluster to its endpoints.
+	slices  map[string][]discovery.Endpoint
+	updated time.Time
+	// Whether the EndpointSlices are refreshed periodically by the exporting agent, without which a stable
+	// EndpointSlice can't be told from a stale one.
+	refreshed bool
+	stale     bool
 }
 
 type Map struct {
 	epMap map[string]*endpointInfo
+	// Maps a service key to the name of each of its EndpointSlices skipped because it's malformed to the cluster the
+	// EndpointSlice originates from.
+	skipped       map[string]map[string]string
+	excludedCIDRs []*net.IPNet
+	maxAge        time.Duration
+	now           func() time.Time
 	sync.RWMutex
+	changeHandlers      []ChangeHandler
+	changeHandlersMutex sync.RWMutex
 }
 
+// ChangeHandler is notified when the endpoints of a service in a cluster are added, updated or removed.
+type ChangeHandler func(namespace, name string)
+
 func (m *Map) GetIPs(hostname, cluster, namespace, name string, checkCluster func(string) bool) ([]string, bool) {
 	key := keyFunc(name, namespace)
 
@@ -81,9 +119,271 @@ func (m *Map) GetIPs(hostname, cluster, namespace, name string, checkCluster fun
 	}
 }
 
+// GetPrimaryIP returns the lowest IP of a ready endpoint for the service among the clusters that pass checkCluster, so
+// every client in the clusterset gets the same single endpoint of a singleton service until it disappears or stops
+// being ready. The returned IP is empty if there's no such endpoint.
+func (m *Map) GetPrimaryIP(namespace, name string, checkCluster func(string) bool) (string, bool) {
+	clusterIPs := func() map[string][]string {
+		m.RLock()
+		defer m.RUnlock()
+
+		result, ok := m.epMap[keyFunc(name, namespace)]
+		if !ok {
+			return nil
+		}
+
+		clusterIPs := make(map[string][]string, len(result.clusterInfo))
+		for clusterID, info := range result.clusterInfo {
+			clusterIPs[clusterID] = info.readyIPs()
+		}
+
+		return clusterIPs
+	}()
+
+	if clusterIPs == nil {
+		return "", false
+	}
+
+	primary := ""
+
+	for clusterID, ips := range clusterIPs {
+		if checkCluster != nil && !checkCluster(clusterID) {
+			continue
+		}
+
+		for _, ip := range ips {
+			if primary == "" || bytes.Compare(net.ParseIP(ip).To16(), net.ParseIP(primary).To16()) < 0 {
+				primary = ip
+			}
+		}
+	}
+
+	return primary, true
+}
+
+// readyIPs returns the IPs of the cluster's endpoints that are ready, which they are unless stated otherwise. The
+// excluded addresses aren't returned.
+func (c *clusterInfo) readyIPs() []string {
+	listed := make(map[string]bool, len(c.ipList))
+	for _, ip := range c.ipList {
+		listed[ip] = true
+	}
+
+	ips := []string{}
+
+	for _, endpoints := range c.slices {
+		for i := range endpoints {
+			if endpoints[i].Conditions.Ready != nil && !*endpoints[i].Conditions.Ready {
+				continue
+			}
+
+			for _, address := range endpoints[i].Addresses {
+				if listed[address] {
+					ips = append(ips, address)
+				}
+			}
+		}
+	}
+
+	return ips
+}
+
+// GetIPsFromClusters returns the IPs from at most maxClusters of the clusters with endpoints for the service that pass
+// checkCluster. The local cluster, if eligible, is selected first and the remaining clusters are taken in the order
+// returned by rankClusters or round-robin if it's nil.
+func (m *Map) GetIPsFromClusters(namespace, name, localCluster string, maxClusters int,
+	rankClusters func([]string) []string, checkCluster func(string) bool) ([]string, bool) {
+	return m.getIPsFromClusters(namespace, name, localCluster, maxClusters, true, rankClusters, checkCluster)
+}
+
+// PeekIPsFromClusters is like GetIPsFromClusters except that the round-robin isn't advanced, eg to report the answer of
+// a query without changing those of the next queries.
+func (m *Map) PeekIPsFromClusters(namespace, name, localCluster string, maxClusters int,
+	rankClusters func([]string) []string, checkCluster func(string) bool) ([]string, bool) {
+	return m.getIPsFromClusters(namespace, name, localCluster, maxClusters, false, rankClusters, checkCluster)
+}
+
+func (m *Map) getIPsFromClusters(namespace, name, localCluster string, maxClusters int, advance bool,
+	rankClusters func([]string) []string, checkCluster func(string) bool) ([]string, bool) {
+	clusterIPs, counter := func() (map[string][]string, *uint64) {
+		m.RLock()
+		defer m.RUnlock()
+
+		result, ok := m.epMap[keyFunc(name, namespace)]
+		if !ok {
+			return nil, nil
+		}
+
+		clusterIPs := make(map[string][]string, len(result.clusterInfo))
+		for clusterID, info := range result.clusterInfo {
+			clusterIPs[clusterID] = info.ipList
+		}
+
+		return clusterIPs, &result.rrCount
+	}()
+
+	if clusterIPs == nil {
+		return nil, false
+	}
+
+	clusters := make([]string, 0, len(clusterIPs))
+
+	for clusterID, ips := range clusterIPs {
+		if len(ips) > 0 && clusterID != localCluster && (checkCluster == nil || checkCluster(clusterID)) {
+			clusters = append(clusters, clusterID)
+		}
+	}
+
+	if rankClusters != nil {
+		clusters = rankClusters(clusters)
+	} else if len(clusters) > 0 {
+		sort.Strings(clusters)
+		next := atomic.LoadUint64(counter) + 1
+		if advance {
+			next = atomic.AddUint64(counter, 1)
+		}
+
+		start := int(next % uint64(len(clusters)))
+		clusters = append(clusters[start:], clusters[:start]...)
+	}
+
+	if len(clusterIPs[localCluster]) > 0 && (checkCluster == nil || checkCluster(localCluster)) {
+		clusters = append([]string{localCluster}, clusters...)
+	}
+
+	if maxClusters > 0 && len(clusters) > maxClusters {
+		clusters = clusters[:maxClusters]
+	}
+
+	ips := make([]string, 0)
+	for _, clusterID := range clusters {
+		ips = append(ips, clusterIPs[clusterID]...)
+	}
+
+	return ips, true
+}
+
+// GetClusterForIP returns the ID of the cluster with an endpoint for the service at the given IP, or an empty string if
+// there is none.
+func (m *Map) GetClusterForIP(namespace, name, ip string) string {
+	m.RLock()
+	defer m.RUnlock()
+
+	result, ok := m.epMap[keyFunc(name, namespace)]
+	if !ok {
+		return ""
+	}
+
+	for clusterID, info := range result.clusterInfo {
+		for _, clusterIP := range info.ipList {
+			if clusterIP == ip {
+				return clusterID
+			}
+		}
+	}
+
+	return ""
+}
+
+// GetClustersByIP returns the IDs of the clusters with an endpoint for the service at each of its endpoint IPs,
+// sorted.
+func (m *Map) GetClustersByIP(namespace, name string) map[string][]string {
+	m.RLock()
+	defer m.RUnlock()
+
+	clustersByIP := map[string][]string{}
+
+	result, ok := m.epMap[keyFunc(name, namespace)]
+	if !ok {
+		return clustersByIP
+	}
+
+	for clusterID, info := range result.clusterInfo {
+		seen := make(map[string]bool, len(info.ipList))
+
+		for _, ip := range info.ipList {
+			if !seen[ip] {
+				seen[ip] = true
+				clustersByIP[ip] = append(clustersByIP[ip], clusterID)
+			}
+		}
+	}
+
+	for _, clusters := range clustersByIP {
+		sort.Strings(clusters)
+	}
+
+	return clustersByIP
+}
+
+// GetIPCount returns the number of endpoint IPs for the service in the given cluster.
+func (m *Map) GetIPCount(namespace, name, cluster string) int {
+	m.RLock()
+	defer m.RUnlock()
+
+	result, ok := m.epMap[keyFunc(name, namespace)]
+	if !ok {
+		return 0
+	}
+
+	info, ok := result.clusterInfo[cluster]
+	if !ok {
+		return 0
+	}
+
+	return len(info.ipList)
+}
+
+// GetExcludedIPs returns the endpoint IPs of the service in the given cluster that aren't returned because they match
+// an excluded CIDR, mapped to the CIDR.
+func (m *Map) GetExcludedIPs(namespace, name, cluster string) map[string]string {
+	m.RLock()
+	defer m.RUnlock()
+
+	result, ok := m.epMap[keyFunc(name, namespace)]
+	if !ok {
+		return nil
+	}
+
+	info, ok := result.clusterInfo[cluster]
+	if !ok {
+		return nil
+	}
+
+	excluded := make(map[string]string, len(info.excluded))
+	for ip, cidr := range info.excluded {
+		excluded[ip] = cidr
+	}
+
+	return excluded
+}
+
 func NewMap() *Map {
 	return &Map{
-		epMap: make(map[string]*endpointInfo),
+		epMap:   make(map[string]*endpointInfo),
+		skipped: make(map[string]map[string]string),
+		now:     time.Now,
+	}
+}
+
+// OnChange registers a handler notified whenever the EndpointSlices of a service change. The handler is called after
+// the change is stored, without holding the map's lock, and must not block.
+func (m *Map) OnChange(handler ChangeHandler) {
+	m.changeHandlersMutex.Lock()
+	defer m.changeHandlersMutex.Unlock()
+
+	m.changeHandlers = append(m.changeHandlers, handler)
+}
+
+func (m *Map) notifyChange(es *discovery.EndpointSlice) {
+	name, _ := sourceName(es)
+	namespace := es.Labels[constants.LabelSourceNamespace]
+
+	m.changeHandlersMutex.RLock()
+	defer m.changeHandlersMutex.RUnlock()
+
+	for _, handler := range m.changeHandlers {
+		handler(namespace, name)
 	}
 }
 
@@ -101,33 +401,212 @@ func (m *Map) Put(es *discovery.EndpointSlice) {
 		return
 	}
 
+	defer m.notifyChange(es)
+
 	m.Lock()
 	defer m.Unlock()
 
+	// A malformed EndpointSlice is skipped rather than failing the answers, which keep the cluster's last valid
+	// version of it, if any, along with the EndpointSlices of the other clusters.
+	if err := validateEndpointSlice(es); err != nil {
+		klog.Warningf("Skipping the EndpointSlice %q for %q from %q: %v", es.Name, key, cluster, err)
+		SkippedEndpointSlices.WithLabelValues(serviceLabel(es), cluster).Inc()
+
+		if m.skipped[key] == nil {
+			m.skipped[key] = map[string]string{}
+		}
+
+		m.skipped[key][es.Name] = cluster
+
+		return
+	}
+
+	m.clearSkipped(key, es.Name)
+
 	epInfo, ok := m.epMap[key]
 	if !ok {
 		epInfo = &endpointInfo{
 			key:         key,
+			service:     serviceLabel(es),
 			clusterInfo: make(map[string]*clusterInfo),
 		}
 	}
 
-	epInfo.clusterInfo[cluster] = &clusterInfo{
+	slices := map[string][]discovery.Endpoint{}
+
+	if previous := epInfo.clusterInfo[cluster]; previous != nil {
+		if previous.stale {
+			klog.Infof("The stale EndpointSlice for %q in %q was updated", key, cluster)
+		}
+
+		for name, endpoints := range previous.slices {
+			slices[name] = endpoints
+		}
+	}
+
+	slices[es.Name] = es.Endpoints
+
+	epInfo.clusterInfo[cluster] = m.newClusterInfo(slices, key, cluster)
+	epInfo.clusterInfo[cluster].updated = m.now()
+	epInfo.clusterInfo[cluster].refreshed = es.Annotations[constants.AnnotationRefreshed] != ""
+
+	klog.V(log.DEBUG).Infof("Adding clusterInfo %#v for EndpointSlice %q in %q", epInfo.clusterInfo[cluster], es.Name, cluster)
+
+	m.epMap[key] = epInfo
+}
+
+// ExcludeCIDRs sets the CIDRs whose addresses are never returned, eg because they overlap across clusters or aren't
+// routable. The addresses of endpoints already present are filtered again.
+func (m *Map) ExcludeCIDRs(cidrs []*net.IPNet) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.excludedCIDRs = cidrs
+
+	for key, epInfo := range m.epMap {
+		for cluster, info := range epInfo.clusterInfo {
+			epInfo.clusterInfo[cluster] = m.newClusterInfo(info.slices, key, cluster)
+			epInfo.clusterInfo[cluster].updated = info.updated
+			epInfo.clusterInfo[cluster].refreshed = info.refreshed
+			epInfo.clusterInfo[cluster].stale = info.stale
+		}
+	}
+}
+
+// SetMaxAge sets the age after which an EndpointSlice that hasn't been updated is considered stale. Only the
+// EndpointSlices refreshed periodically by their exporting agent can be stale, as the others aren't updated while their
+// endpoints are stable. Zero disables the staleness check.
+func (m *Map) SetMaxAge(maxAge time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.maxAge = maxAge
+}
+
+// IsStale checks if the EndpointSlice for the service in the given cluster hasn't been updated within the max age.
+func (m *Map) IsStale(namespace, name, cluster string) bool {
+	m.RLock()
+	defer m.RUnlock()
+
+	result, ok := m.epMap[keyFunc(name, namespace)]
+	if !ok {
+		return false
+	}
+
+	info, ok := result.clusterInfo[cluster]
+
+	return ok && m.isStale(info)
+}
+
+// CheckStaleness logs the EndpointSlices that became stale since the last check and updates the stale EndpointSlices
+// metric.
+func (m *Map) CheckStaleness() {
+	m.Lock()
+	defer m.Unlock()
+
+	count := 0
+
+	for key, epInfo := range m.epMap {
+		for cluster, info := range epInfo.clusterInfo {
+			stale := m.isStale(info)
+			if stale && !info.stale {
+				klog.Warningf("The EndpointSlice for %q in %q hasn't been updated for %v - its endpoints may be out of date",
+					key, cluster, m.now().Sub(info.updated).Round(time.Second))
+			}
+
+			info.stale = stale
+
+			if stale {
+				count++
+			}
+		}
+	}
+
+	StaleEndpointSlices.Set(float64(count))
+}
+
+func (m *Map) isStale(info *clusterInfo) bool {
+	return m.maxAge > 0 && info.refreshed && m.now().Sub(info.updated) > m.maxAge
+}
+
+// newClusterInfo merges the endpoints of the given EndpointSlices, ordered by name. An address present in several of
+// them, eg while an endpoint moves between slices, is only returned once.
+func (m *Map) newClusterInfo(slices map[string][]discovery.Endpoint, key, cluster string) *clusterInfo {
+	info := &clusterInfo{
 		ipList:  make([]string, 0),
 		hostIPs: make(map[string][]string),
+		slices:  slices,
 	}
 
-	for _, endpoint := range es.Endpoints {
-		if endpoint.Hostname != nil {
-			epInfo.clusterInfo[cluster].hostIPs[*endpoint.Hostname] = endpoint.Addresses
+	names := make([]string, 0, len(slices))
+	for name := range slices {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	seen := map[string]bool{}
+
+	for _, name := range names {
+		for _, endpoint := range slices[name] {
+			addresses := m.filterExcluded(endpoint.Addresses, key, cluster, info)
+
+			if endpoint.Hostname != nil {
+				info.hostIPs[*endpoint.Hostname] = addresses
+			}
+
+			for _, address := range addresses {
+				if !seen[address] {
+					seen[address] = true
+					info.ipList = append(info.ipList, address)
+				}
+			}
 		}
+	}
 
-		epInfo.clusterInfo[cluster].ipList = append(epInfo.clusterInfo[cluster].ipList, endpoint.Addresses...)
+	return info
+}
+
+func (m *Map) filterExcluded(addresses []string, key, cluster string, info *clusterInfo) []string {
+	if len(m.excludedCIDRs) == 0 {
+		return addresses
 	}
 
-	klog.V(log.DEBUG).Infof("Adding clusterInfo %#v for EndpointSlice %q in %q", epInfo.clusterInfo[cluster], es.Name, cluster)
+	filtered := make([]string, 0, len(addresses))
 
-	m.epMap[key] = epInfo
+	for _, address := range addresses {
+		if cidr := m.excludingCIDR(address); cidr != nil {
+			klog.V(log.DEBUG).Infof("Excluding address %s of %q in %q matching CIDR %s", address, key, cluster, cidr)
+			ExcludedAddresses.Inc()
+
+			if info.excluded == nil {
+				info.excluded = make(map[string]string)
+			}
+
+			info.excluded[address] = cidr.String()
+
+			continue
+		}
+
+		filtered = append(filtered, address)
+	}
+
+	return filtered
+}
+
+func (m *Map) excludingCIDR(address string) *net.IPNet {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return nil
+	}
+
+	for _, cidr := range m.excludedCIDRs {
+		if cidr.Contains(ip) {
+			return cidr
+		}
+	}
+
+	return nil
 }
 
 func (m *Map) Remove(es *discovery.EndpointSlice) {
@@ -139,17 +618,79 @@ func (m *Map) Remove(es *discovery.EndpointSlice) {
 			return
 		}
 
+		defer m.notifyChange(es)
+
 		m.Lock()
 		defer m.Unlock()
 
+		m.clearSkipped(key, es.Name)
+
 		epInfo, ok := m.epMap[key]
 		if !ok {
 			return
 		}
 
-		klog.V(log.DEBUG).Infof("Adding endpointInfo %#v for %s in %s", epInfo.clusterInfo[cluster], es.Name, cluster)
-		delete(epInfo.clusterInfo, cluster)
+		info, ok := epInfo.clusterInfo[cluster]
+		if !ok {
+			return
+		}
+
+		if _, ok := info.slices[es.Name]; !ok {
+			return
+		}
+
+		klog.V(log.DEBUG).Infof("Removing EndpointSlice %s from clusterInfo %#v in %s", es.Name, info, cluster)
+
+		slices := map[string][]discovery.Endpoint{}
+		for name, endpoints := range info.slices {
+			if name != es.Name {
+				slices[name] = endpoints
+			}
+		}
+
+		if len(slices) == 0 {
+			delete(epInfo.clusterInfo, cluster)
+			return
+		}
+
+		epInfo.clusterInfo[cluster] = m.newClusterInfo(slices, key, cluster)
+		epInfo.clusterInfo[cluster].updated = m.now()
+	}
+}
+
+// GetEndpointCounts returns the endpoint counts of each service with imported endpoints, by "namespace/name", computed
+// from the cached EndpointSlices.
+func (m *Map) GetEndpointCounts() map[string]EndpointCounts {
+	m.RLock()
+	defer m.RUnlock()
+
+	counts := map[string]EndpointCounts{}
+
+	for _, epInfo := range m.epMap {
+		if len(epInfo.clusterInfo) == 0 {
+			continue
+		}
+
+		serviceCounts := counts[epInfo.service]
+
+		for _, info := range epInfo.clusterInfo {
+			ready := map[string]bool{}
+			for _, ip := range info.readyIPs() {
+				ready[ip] = true
+			}
+
+			serviceCounts.Ready += len(ready)
+			serviceCounts.Total += len(info.ipList)
+
+			if len(ready) > 0 {
+				serviceCounts.Clusters++
+			}
+		}
+
+		counts[epInfo.service] = serviceCounts
 	}
+
+	return counts
 }
 
 func (m *Map) Get(key string) *endpointInfo {
@@ -160,8 +701,59 @@ func (m *Map) Get(key string) *endpointInfo {
 	return endpointInfo
 }
 
+// validateEndpointSlice returns an error if an address of the EndpointSlice isn't an IP or doesn't match the
+// EndpointSlice's address type.
+func validateEndpointSlice(es *discovery.EndpointSlice) error {
+	for i := range es.Endpoints {
+		for _, address := range es.Endpoints[i].Addresses {
+			ip := net.ParseIP(address)
+			if ip == nil {
+				return fmt.Errorf("address %q isn't an IP", address)
+			}
+
+			if (es.AddressType == discovery.AddressTypeIPv4 && ip.To4() == nil) ||
+				(es.AddressType == discovery.AddressTypeIPv6 && ip.To4() != nil) {
+				return fmt.Errorf("address %q doesn't match the address type %s", address, es.AddressType)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (m *Map) clearSkipped(key, sliceName string) {
+	delete(m.skipped[key], sliceName)
+
+	if len(m.skipped[key]) == 0 {
+		delete(m.skipped, key)
+	}
+}
+
+// GetSkippedClusters returns the IDs of the clusters whose contribution to the service is partial or missing as one
+// of their EndpointSlices is malformed and was skipped.
+func (m *Map) GetSkippedClusters(namespace, name string) []string {
+	m.RLock()
+	defer m.RUnlock()
+
+	clusters := []string{}
+	seen := map[string]bool{}
+
+	for _, cluster := range m.skipped[keyFunc(name, namespace)] {
+		if !seen[cluster] {
+			seen[cluster] = true
+			clusters = append(clusters, cluster)
+		}
+	}
+
+	sort.Strings(clusters)
+
+	return clusters
+}
+
+// getKey returns the key of the service an EndpointSlice belongs to. The service is identified by the source name
+// label or, for slices not labeled by Lighthouse, the standard service name label.
 func getKey(es *discovery.EndpointSlice) (string, bool) {
-	name, ok := es.Labels[constants.LabelSourceName]
+	name, ok := sourceName(es)
 
 	if !ok {
 		return "", false
@@ -176,6 +768,21 @@ func getKey(es *discovery.EndpointSlice) (string, bool) {
 	return keyFunc(name, namespace), true
 }
 
+func sourceName(es *discovery.EndpointSlice) (string, bool) {
+	name, ok := es.Labels[constants.LabelSourceName]
+	if !ok {
+		name, ok = es.Labels[discovery.LabelServiceName]
+	}
+
+	return name, ok
+}
+
+// serviceLabel returns the "namespace/name" of the service an EndpointSlice belongs to, used to label its metrics.
+func serviceLabel(es *discovery.EndpointSlice) string {
+	name, _ := sourceName(es)
+	return es.Labels[constants.LabelSourceNamespace] + "/" + name
+}
+
 func keyFunc(name, namespace string) string {
 	return name + "-" + namespace
 }
@@ -16,10 +16,14 @@ limitations under the License.
 package endpointslice_test
 
 import (
+	"fmt"
+	"net"
 	"sort"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/submariner-io/lighthouse/pkg/endpointslice"
 
 	lhconstants "github.com/submariner-io/lighthouse/pkg/constants"
@@ -37,6 +41,7 @@ var _ = Describe("EndpointSlice Map", func() {
 		endpointIP  = "100.96.157.101"
 		endpointIP2 = "100.96.157.102"
 		endpointIP3 = "100.96.157.103"
+		overlapIP   = "10.253.1.5"
 	)
 
 	var (
@@ -101,6 +106,36 @@ var _ = Describe("EndpointSlice Map", func() {
 				expectIPs(hostname, clusterID1, namespace1, service1, []string{endpointIP})
 			})
 		})
+		When("the cluster for an IP is requested", func() {
+			It("should return the cluster with that endpoint", func() {
+				endpointSliceMap.Put(newEndpointSlice(namespace1, service1, clusterID1, []string{endpointIP}))
+				endpointSliceMap.Put(newEndpointSlice(namespace1, service1, clusterID2, []string{endpointIP2}))
+
+				Expect(endpointSliceMap.GetClusterForIP(namespace1, service1, endpointIP2)).To(Equal(clusterID2))
+				Expect(endpointSliceMap.GetClusterForIP(namespace1, service1, endpointIP3)).To(BeEmpty())
+			})
+		})
+		When("the clusters of the IPs exported by several clusters are requested", func() {
+			It("should return all the clusters of each IP, sorted", func() {
+				endpointSliceMap.Put(newEndpointSlice(namespace1, service1, clusterID2, []string{endpointIP, endpointIP2}))
+				endpointSliceMap.Put(newEndpointSlice(namespace1, service1, clusterID1, []string{endpointIP}))
+
+				Expect(endpointSliceMap.GetClustersByIP(namespace1, service1)).To(Equal(map[string][]string{
+					endpointIP:  {clusterID1, clusterID2},
+					endpointIP2: {clusterID2},
+				}))
+				Expect(endpointSliceMap.GetClustersByIP(namespace1, "unknown")).To(BeEmpty())
+			})
+		})
+		When("the IP count for a cluster is requested", func() {
+			It("should return the number of endpoint IPs in that cluster", func() {
+				endpointSliceMap.Put(newEndpointSlice(namespace1, service1, clusterID1, []string{endpointIP, endpointIP2}))
+
+				Expect(endpointSliceMap.GetIPCount(namespace1, service1, clusterID1)).To(Equal(2))
+				Expect(endpointSliceMap.GetIPCount(namespace1, service1, clusterID2)).To(Equal(0))
+				Expect(endpointSliceMap.GetIPCount(namespace1, "unknown", clusterID1)).To(Equal(0))
+			})
+		})
 	})
 
 	When("a headless service is present in multiple connected clusters with one disconnected", func() {
@@ -118,6 +153,21 @@ var _ = Describe("EndpointSlice Map", func() {
 		})
 	})
 
+	When("a change handler is registered", func() {
+		It("should notify it of the service whose EndpointSlices are put or removed", func() {
+			changed := []string{}
+			endpointSliceMap.OnChange(func(namespace, name string) {
+				changed = append(changed, namespace+"/"+name)
+			})
+
+			es := newEndpointSlice(namespace1, service1, clusterID1, []string{endpointIP})
+			endpointSliceMap.Put(es)
+			endpointSliceMap.Remove(es)
+
+			Expect(changed).To(Equal([]string{namespace1 + "/" + service1, namespace1 + "/" + service1}))
+		})
+	})
+
 	When("a headless service is present in multiple connected clusters and one is removed", func() {
 		It("should consistently return all the remaining IPs", func() {
 			es1 := newEndpointSlice(namespace1, service1, clusterID1, []string{endpointIP})
@@ -133,6 +183,365 @@ var _ = Describe("EndpointSlice Map", func() {
 		})
 	})
 
+	When("the primary endpoint of a headless service is requested", func() {
+		const lowestIP = "100.96.157.9"
+
+		var es1 *discovery.EndpointSlice
+
+		BeforeEach(func() {
+			es1 = newEndpointSlice(namespace1, service1, clusterID1, []string{endpointIP2, lowestIP})
+			endpointSliceMap.Put(es1)
+			endpointSliceMap.Put(newEndpointSlice(namespace1, service1, clusterID2, []string{endpointIP3, endpointIP}))
+		})
+
+		getPrimaryIP := func() string {
+			ip, found := endpointSliceMap.GetPrimaryIP(namespace1, service1, checkCluster)
+			Expect(found).To(BeTrue())
+
+			return ip
+		}
+
+		It("should consistently return the numerically lowest IP across the clusters", func() {
+			for i := 0; i < 5; i++ {
+				Expect(getPrimaryIP()).To(Equal(lowestIP))
+			}
+		})
+
+		When("its cluster is disconnected", func() {
+			It("should fail over to the lowest IP of the other clusters", func() {
+				clusterStatusMap[clusterID1] = false
+				Expect(getPrimaryIP()).To(Equal(endpointIP))
+			})
+		})
+
+		When("it's removed", func() {
+			It("should fail over to the next lowest IP", func() {
+				es1.Endpoints[0].Addresses = []string{endpointIP2}
+				endpointSliceMap.Put(es1)
+				Expect(getPrimaryIP()).To(Equal(endpointIP))
+			})
+		})
+
+		When("it isn't ready", func() {
+			It("should fail over to the lowest ready IP", func() {
+				ready := false
+				es1.Endpoints = []discovery.Endpoint{
+					{Addresses: []string{lowestIP}, Conditions: discovery.EndpointConditions{Ready: &ready}},
+					{Addresses: []string{endpointIP2}},
+				}
+				endpointSliceMap.Put(es1)
+				Expect(getPrimaryIP()).To(Equal(endpointIP))
+			})
+		})
+
+		When("no cluster is eligible", func() {
+			It("should return found with an empty IP", func() {
+				clusterStatusMap = map[string]bool{}
+				Expect(getPrimaryIP()).To(BeEmpty())
+			})
+		})
+	})
+
+	When("a headless service is present in five connected clusters and the clusters are limited to two", func() {
+		clusterIPs := map[string]string{}
+
+		getLimitedIPs := func(localCluster string, rank func([]string) []string) []string {
+			ips, found := endpointSliceMap.GetIPsFromClusters(namespace1, service1, localCluster, 2, rank, checkCluster)
+			Expect(found).To(BeTrue())
+			return ips
+		}
+
+		BeforeEach(func() {
+			for i := 1; i <= 5; i++ {
+				clusterID := fmt.Sprintf("cluster%d", i)
+				clusterIPs[clusterID] = fmt.Sprintf("100.96.158.%d", i)
+				clusterStatusMap[clusterID] = true
+				endpointSliceMap.Put(newEndpointSlice(namespace1, service1, clusterID, []string{clusterIPs[clusterID]}))
+			}
+		})
+
+		It("should return the IPs of two clusters round-robin", func() {
+			returned := map[string]bool{}
+
+			for i := 0; i < 5; i++ {
+				ips := getLimitedIPs("", nil)
+				Expect(ips).To(HaveLen(2))
+
+				for _, ip := range ips {
+					returned[ip] = true
+				}
+			}
+
+			Expect(returned).To(HaveLen(5))
+		})
+
+		It("should always include the local cluster", func() {
+			for i := 0; i < 5; i++ {
+				ips := getLimitedIPs("cluster3", nil)
+				Expect(ips).To(HaveLen(2))
+				Expect(ips[0]).To(Equal(clusterIPs["cluster3"]))
+			}
+		})
+
+		It("should take the clusters in the given rank order", func() {
+			rank := func(clusters []string) []string {
+				sort.Sort(sort.Reverse(sort.StringSlice(clusters)))
+				return clusters
+			}
+
+			Expect(getLimitedIPs("", rank)).To(Equal([]string{clusterIPs["cluster5"], clusterIPs["cluster4"]}))
+		})
+
+		It("should exclude disconnected clusters", func() {
+			clusterStatusMap["cluster5"] = false
+			clusterStatusMap["cluster4"] = false
+			clusterStatusMap["cluster3"] = false
+
+			for i := 0; i < 5; i++ {
+				Expect(getLimitedIPs("cluster3", nil)).To(ConsistOf(clusterIPs["cluster1"], clusterIPs["cluster2"]))
+			}
+		})
+	})
+
+	When("a headless service is backed by several EndpointSlices in a cluster", func() {
+		var slices []*discovery.EndpointSlice
+
+		BeforeEach(func() {
+			slices = []*discovery.EndpointSlice{
+				newEndpointSlice(namespace1, service1, clusterID1, []string{"100.96.157.1", "100.96.157.2"}),
+				newEndpointSlice(namespace1, service1, clusterID1, []string{"100.96.157.3"}),
+				newEndpointSlice(namespace1, service1, clusterID1, []string{"100.96.157.4", "100.96.157.2"}),
+			}
+
+			for i, es := range slices {
+				es.Name = fmt.Sprintf("%s-%d", service1, i)
+				endpointSliceMap.Put(es)
+			}
+
+			endpointSliceMap.Put(newEndpointSlice(namespace1, service1, clusterID2, []string{endpointIP2}))
+		})
+
+		It("should return the endpoints of all the EndpointSlices once each", func() {
+			expectIPs("", clusterID1, namespace1, service1, []string{"100.96.157.1", "100.96.157.2", "100.96.157.3",
+				"100.96.157.4"})
+			expectIPs("", "", namespace1, service1, []string{"100.96.157.1", "100.96.157.2", "100.96.157.3",
+				"100.96.157.4", endpointIP2})
+			Expect(endpointSliceMap.GetIPCount(namespace1, service1, clusterID1)).To(Equal(4))
+			Expect(endpointSliceMap.GetClusterForIP(namespace1, service1, "100.96.157.3")).To(Equal(clusterID1))
+		})
+
+		It("should update the endpoints of an EndpointSlice without affecting the others", func() {
+			slices[1].Endpoints[0].Addresses = []string{"100.96.157.5"}
+			endpointSliceMap.Put(slices[1])
+
+			expectIPs("", clusterID1, namespace1, service1, []string{"100.96.157.1", "100.96.157.2", "100.96.157.5",
+				"100.96.157.4"})
+		})
+
+		It("should only remove the endpoints of a removed EndpointSlice", func() {
+			endpointSliceMap.Remove(slices[0])
+			expectIPs("", clusterID1, namespace1, service1, []string{"100.96.157.3", "100.96.157.4", "100.96.157.2"})
+
+			endpointSliceMap.Remove(slices[1])
+			endpointSliceMap.Remove(slices[2])
+
+			_, found := endpointSliceMap.GetIPs("", clusterID1, namespace1, service1, nil)
+			Expect(found).To(BeFalse())
+			expectIPs("", "", namespace1, service1, []string{endpointIP2})
+		})
+	})
+
+	When("an EndpointSlice is only labeled with the standard service name", func() {
+		It("should be stored for the service", func() {
+			es := newEndpointSlice(namespace1, service1, clusterID1, []string{endpointIP})
+			delete(es.Labels, lhconstants.LabelSourceName)
+			es.Labels[discovery.LabelServiceName] = service1
+			endpointSliceMap.Put(es)
+
+			expectIPs("", clusterID1, namespace1, service1, []string{endpointIP})
+		})
+	})
+
+	When("a headless service has endpoint addresses in an excluded CIDR", func() {
+		var excludedBefore float64
+
+		BeforeEach(func() {
+			_, cidr, err := net.ParseCIDR("10.253.0.0/16")
+			Expect(err).To(Succeed())
+
+			excludedBefore = testutil.ToFloat64(endpointslice.ExcludedAddresses)
+			endpointSliceMap.ExcludeCIDRs([]*net.IPNet{cidr})
+		})
+
+		It("should return only the addresses outside the CIDR", func() {
+			es1 := newEndpointSlice(namespace1, service1, clusterID1, []string{endpointIP, overlapIP})
+			endpointSliceMap.Put(es1)
+			es2 := newEndpointSlice(namespace1, service1, clusterID2, []string{endpointIP2})
+			endpointSliceMap.Put(es2)
+
+			expectIPs("", "", namespace1, service1, []string{endpointIP, endpointIP2})
+			Expect(testutil.ToFloat64(endpointslice.ExcludedAddresses) - excludedBefore).To(Equal(float64(1)))
+		})
+
+		It("should return the excluded addresses with their CIDR", func() {
+			es1 := newEndpointSlice(namespace1, service1, clusterID1, []string{endpointIP, overlapIP})
+			endpointSliceMap.Put(es1)
+
+			Expect(endpointSliceMap.GetExcludedIPs(namespace1, service1, clusterID1)).To(Equal(map[string]string{
+				overlapIP: "10.253.0.0/16"}))
+			Expect(endpointSliceMap.GetExcludedIPs(namespace1, service1, clusterID2)).To(BeEmpty())
+		})
+
+		It("should exclude the addresses from the specific host", func() {
+			hostname := "host1"
+			es1 := newEndpointSlice(namespace1, service1, clusterID1, []string{overlapIP, endpointIP})
+			es1.Endpoints[0].Hostname = &hostname
+			endpointSliceMap.Put(es1)
+
+			expectIPs(hostname, clusterID1, namespace1, service1, []string{endpointIP})
+		})
+	})
+
+	When("the excluded CIDRs are set after the endpoints are present", func() {
+		It("should filter the existing addresses", func() {
+			es1 := newEndpointSlice(namespace1, service1, clusterID1, []string{endpointIP, overlapIP})
+			endpointSliceMap.Put(es1)
+
+			expectIPs("", "", namespace1, service1, []string{endpointIP, overlapIP})
+
+			_, cidr, err := net.ParseCIDR("10.253.0.0/16")
+			Expect(err).To(Succeed())
+			endpointSliceMap.ExcludeCIDRs([]*net.IPNet{cidr})
+
+			expectIPs("", "", namespace1, service1, []string{endpointIP})
+
+			endpointSliceMap.ExcludeCIDRs(nil)
+
+			expectIPs("", "", namespace1, service1, []string{endpointIP, overlapIP})
+		})
+	})
+
+	When("an EndpointSlice stops being updated", func() {
+		var now time.Time
+
+		BeforeEach(func() {
+			now = time.Now()
+			endpointslice.SetClock(endpointSliceMap, func() time.Time {
+				return now
+			})
+
+			endpointSliceMap.SetMaxAge(time.Minute)
+			endpointSliceMap.Put(newRefreshedEndpointSlice(namespace1, service1, clusterID1, []string{endpointIP}))
+			endpointSliceMap.Put(newRefreshedEndpointSlice(namespace1, service1, clusterID2, []string{endpointIP2}))
+		})
+
+		It("should be stale once it's older than the max age until it's updated again", func() {
+			now = now.Add(30 * time.Second)
+			endpointSliceMap.Put(newRefreshedEndpointSlice(namespace1, service1, clusterID2, []string{endpointIP2}))
+
+			now = now.Add(31 * time.Second)
+			Expect(endpointSliceMap.IsStale(namespace1, service1, clusterID1)).To(BeTrue())
+			Expect(endpointSliceMap.IsStale(namespace1, service1, clusterID2)).To(BeFalse())
+
+			endpointSliceMap.CheckStaleness()
+			Expect(testutil.ToFloat64(endpointslice.StaleEndpointSlices)).To(Equal(float64(1)))
+
+			endpointSliceMap.Put(newRefreshedEndpointSlice(namespace1, service1, clusterID1, []string{endpointIP}))
+			Expect(endpointSliceMap.IsStale(namespace1, service1, clusterID1)).To(BeFalse())
+
+			endpointSliceMap.CheckStaleness()
+			Expect(testutil.ToFloat64(endpointslice.StaleEndpointSlices)).To(Equal(float64(0)))
+		})
+
+		It("should keep its age when the excluded CIDRs are set", func() {
+			now = now.Add(2 * time.Minute)
+			endpointSliceMap.ExcludeCIDRs(nil)
+
+			Expect(endpointSliceMap.IsStale(namespace1, service1, clusterID1)).To(BeTrue())
+		})
+
+		It("should not be stale if it isn't refreshed by its agent", func() {
+			endpointSliceMap.Put(newEndpointSlice(namespace1, service1, clusterID1, []string{endpointIP}))
+			now = now.Add(2 * time.Minute)
+
+			Expect(endpointSliceMap.IsStale(namespace1, service1, clusterID1)).To(BeFalse())
+			Expect(endpointSliceMap.IsStale(namespace1, service1, clusterID2)).To(BeTrue())
+		})
+
+		It("should not be stale if the max age is disabled", func() {
+			now = now.Add(2 * time.Minute)
+			endpointSliceMap.SetMaxAge(0)
+
+			Expect(endpointSliceMap.IsStale(namespace1, service1, clusterID1)).To(BeFalse())
+
+			endpointSliceMap.CheckStaleness()
+			Expect(testutil.ToFloat64(endpointslice.StaleEndpointSlices)).To(Equal(float64(0)))
+		})
+	})
+
+	When("a cluster's EndpointSlice is malformed", func() {
+		var skippedBefore float64
+
+		BeforeEach(func() {
+			skippedBefore = testutil.ToFloat64(endpointslice.SkippedEndpointSlices.WithLabelValues(
+				namespace1+"/"+service1, clusterID2))
+
+			endpointSliceMap.Put(newEndpointSlice(namespace1, service1, clusterID1, []string{endpointIP}))
+			endpointSliceMap.Put(newEndpointSlice(namespace1, service1, clusterID2, []string{"not-an-ip"}))
+		})
+
+		It("should skip that cluster and still return the IPs from the other clusters", func() {
+			expectIPs("", "", namespace1, service1, []string{endpointIP})
+			Expect(endpointSliceMap.GetSkippedClusters(namespace1, service1)).To(Equal([]string{clusterID2}))
+			Expect(testutil.ToFloat64(endpointslice.SkippedEndpointSlices.WithLabelValues(
+				namespace1+"/"+service1, clusterID2)) - skippedBefore).To(Equal(float64(1)))
+		})
+
+		It("should stop skipping the cluster once its EndpointSlice is valid again", func() {
+			endpointSliceMap.Put(newEndpointSlice(namespace1, service1, clusterID2, []string{endpointIP2}))
+
+			expectIPs("", "", namespace1, service1, []string{endpointIP, endpointIP2})
+			Expect(endpointSliceMap.GetSkippedClusters(namespace1, service1)).To(BeEmpty())
+		})
+
+		It("should keep the previous valid version of an EndpointSlice that becomes malformed", func() {
+			endpointSliceMap.Put(newEndpointSlice(namespace1, service1, clusterID2, []string{endpointIP2}))
+			endpointSliceMap.Put(newEndpointSlice(namespace1, service1, clusterID2, []string{"2001:db8::1"}))
+
+			expectIPs("", "", namespace1, service1, []string{endpointIP, endpointIP2})
+			Expect(endpointSliceMap.GetSkippedClusters(namespace1, service1)).To(Equal([]string{clusterID2}))
+		})
+	})
+	When("the endpoint counts are requested", func() {
+		It("should reflect the EndpointSlices added and removed", func() {
+			Expect(endpointSliceMap.GetEndpointCounts()).To(BeEmpty())
+
+			notReady := false
+			es1 := newEndpointSlice(namespace1, service1, clusterID1, []string{endpointIP})
+			es1.Endpoints = append(es1.Endpoints, discovery.Endpoint{Addresses: []string{endpointIP2},
+				Conditions: discovery.EndpointConditions{Ready: &notReady}})
+			endpointSliceMap.Put(es1)
+
+			key := namespace1 + "/" + service1
+			Expect(endpointSliceMap.GetEndpointCounts()).To(Equal(map[string]endpointslice.EndpointCounts{
+				key: {Ready: 1, Total: 2, Clusters: 1},
+			}))
+
+			es2 := newEndpointSlice(namespace1, service1, clusterID2, []string{endpointIP3})
+			endpointSliceMap.Put(es2)
+			Expect(endpointSliceMap.GetEndpointCounts()).To(Equal(map[string]endpointslice.EndpointCounts{
+				key: {Ready: 2, Total: 3, Clusters: 2},
+			}))
+
+			endpointSliceMap.Remove(es1)
+			Expect(endpointSliceMap.GetEndpointCounts()).To(Equal(map[string]endpointslice.EndpointCounts{
+				key: {Ready: 1, Total: 1, Clusters: 1},
+			}))
+
+			endpointSliceMap.Remove(es2)
+			Expect(endpointSliceMap.GetEndpointCounts()).To(BeEmpty())
+		})
+	})
 })
 
 func newEndpointSlice(namespace, name, clusterID string, endpointIPs []string) *discovery.EndpointSlice {
@@ -156,3 +565,11 @@ func newEndpointSlice(namespace, name, clusterID string, endpointIPs []string) *
 		},
 	}
 }
+
+// newRefreshedEndpointSlice returns an EndpointSlice stamped with the time it was refreshed by its exporting agent.
+func newRefreshedEndpointSlice(namespace, name, clusterID string, endpointIPs []string) *discovery.EndpointSlice {
+	es := newEndpointSlice(namespace, name, clusterID, endpointIPs)
+	es.Annotations = map[string]string{lhconstants.AnnotationRefreshed: time.Now().UTC().Format(time.RFC3339Nano)}
+
+	return es
+}
@@ -0,0 +1,126 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package endpointslice
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/submariner-io/lighthouse/pkg/constants"
+)
+
+var (
+	// ExcludedAddresses counts the endpoint addresses filtered out because they match an excluded CIDR.
+	ExcludedAddresses = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: constants.MetricsNamespace,
+		Name:      "endpoint_addresses_excluded_total",
+		Help:      "Number of endpoint addresses filtered out because they match an excluded CIDR.",
+	})
+
+	// StaleEndpointSlices is the number of EndpointSlices that haven't been updated within the configured max age.
+	StaleEndpointSlices = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: constants.MetricsNamespace,
+		Name:      "endpointslices_stale",
+		Help:      "Number of EndpointSlices that haven't been updated within the configured max age.",
+	})
+
+	// SkippedEndpointSlices counts the imported EndpointSlices skipped because they're malformed, by service and source
+	// cluster.
+	SkippedEndpointSlices = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: constants.MetricsNamespace,
+		Name:      "endpointslices_skipped_total",
+		Help:      "Number of imported EndpointSlices skipped because they're malformed, by service and source cluster.",
+	}, []string{"service", "source_cluster"})
+
+	// DroppedEndpointSlices is the number of EndpointSlices currently not cached because their service or source cluster
+	// is at its limit, by service and source cluster.
+	DroppedEndpointSlices = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: constants.MetricsNamespace,
+		Name:      "endpointslices_dropped",
+		Help:      "Number of EndpointSlices not cached because their service or source cluster is at its limit.",
+	}, []string{"service", "source_cluster"})
+
+	// ImportedEndpointSlices is the number of EndpointSlices currently imported, by source cluster.
+	ImportedEndpointSlices = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: constants.MetricsNamespace,
+		Name:      "imported_endpointslices",
+		Help:      "Number of EndpointSlices currently imported, by source cluster.",
+	}, []string{"source_cluster"})
+
+	// serviceEndpoints reports the endpoint counts of each service from the running Controller.
+	serviceEndpoints = &endpointCountsCollector{
+		ready: prometheus.NewDesc(prometheus.BuildFQName(constants.MetricsNamespace, "", "service_ready_endpoints"),
+			"Number of ready endpoints of the service across the clusters.", []string{"service"}, nil),
+		total: prometheus.NewDesc(prometheus.BuildFQName(constants.MetricsNamespace, "", "service_endpoints"),
+			"Number of endpoints of the service across the clusters.", []string{"service"}, nil),
+		clusters: prometheus.NewDesc(prometheus.BuildFQName(constants.MetricsNamespace, "", "service_endpoint_clusters"),
+			"Number of clusters with ready endpoints for the service.", []string{"service"}, nil),
+	}
+)
+
+// endpointCountsCollector collects the endpoint counts from the in-memory caches of the Controller when scraped, so
+// they're always current. Its Controller is the one last started, as the collector stays registered across reloads.
+type endpointCountsCollector struct {
+	sync.Mutex
+	controller *Controller
+	ready      *prometheus.Desc
+	total      *prometheus.Desc
+	clusters   *prometheus.Desc
+}
+
+func (e *endpointCountsCollector) setController(c *Controller) {
+	e.Lock()
+	defer e.Unlock()
+
+	e.controller = c
+}
+
+func (e *endpointCountsCollector) clearController(c *Controller) {
+	e.Lock()
+	defer e.Unlock()
+
+	if e.controller == c {
+		e.controller = nil
+	}
+}
+
+func (e *endpointCountsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- e.ready
+	ch <- e.total
+	ch <- e.clusters
+}
+
+func (e *endpointCountsCollector) Collect(ch chan<- prometheus.Metric) {
+	e.Lock()
+	controller := e.controller
+	e.Unlock()
+
+	if controller == nil {
+		return
+	}
+
+	for service, counts := range controller.EndpointCounts() {
+		ch <- prometheus.MustNewConstMetric(e.ready, prometheus.GaugeValue, float64(counts.Ready), service)
+		ch <- prometheus.MustNewConstMetric(e.total, prometheus.GaugeValue, float64(counts.Total), service)
+		ch <- prometheus.MustNewConstMetric(e.clusters, prometheus.GaugeValue, float64(counts.Clusters), service)
+	}
+}
+
+// Collectors returns the metrics maintained for EndpointSlices.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{ExcludedAddresses, StaleEndpointSlices, SkippedEndpointSlices, DroppedEndpointSlices,
+		ImportedEndpointSlices, serviceEndpoints}
+}
@@ -23,4 +23,6 @@ type Store interface {
 	Remove(endpointSlice *discovery.EndpointSlice)
 
 	Get(key string) *endpointInfo
+
+	GetEndpointCounts() map[string]EndpointCounts
 }
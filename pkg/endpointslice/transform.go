@@ -0,0 +1,125 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package endpointslice
+
+import (
+	"fmt"
+	"net"
+
+	lhconstants "github.com/submariner-io/lighthouse/pkg/constants"
+	discovery "k8s.io/api/discovery/v1beta1"
+)
+
+// An EndpointTransformer rewrites an endpoint imported from a source cluster before it's cached, eg to map its
+// addresses to those it's reachable at from the local cluster.
+type EndpointTransformer interface {
+	TransformEndpoint(ep discovery.Endpoint, sourceCluster string) discovery.Endpoint
+}
+
+// IdentityTransformer returns the endpoints unchanged. It's the default EndpointTransformer.
+type IdentityTransformer struct{}
+
+func (IdentityTransformer) TransformEndpoint(ep discovery.Endpoint, _ string) discovery.Endpoint {
+	return ep
+}
+
+// TransformEndpoints returns a Transform rewriting each endpoint of an EndpointSlice with the transformer, passing it
+// the EndpointSlice's source cluster.
+func TransformEndpoints(transformer EndpointTransformer) Transform {
+	return func(es *discovery.EndpointSlice) {
+		sourceCluster := es.Labels[lhconstants.LabelSourceCluster]
+
+		for i := range es.Endpoints {
+			es.Endpoints[i] = transformer.TransformEndpoint(es.Endpoints[i], sourceCluster)
+		}
+	}
+}
+
+type cidrMapping struct {
+	cidr       *net.IPNet
+	globalCIDR *net.IPNet
+}
+
+// GlobalCIDRTransformer maps the endpoint addresses from a cluster which are in one of its CIDRs to the same host in
+// the corresponding global CIDR, eg the range its Globalnet gateway translates them to. The other addresses are
+// returned unchanged.
+type GlobalCIDRTransformer struct {
+	mappings map[string][]cidrMapping
+}
+
+func NewGlobalCIDRTransformer() *GlobalCIDRTransformer {
+	return &GlobalCIDRTransformer{mappings: map[string][]cidrMapping{}}
+}
+
+// AddMapping maps the addresses from the cluster in cidr to globalCIDR, which must be of the same family and size.
+func (t *GlobalCIDRTransformer) AddMapping(cluster string, cidr, globalCIDR *net.IPNet) error {
+	ones, bits := cidr.Mask.Size()
+	globalOnes, globalBits := globalCIDR.Mask.Size()
+
+	if ones != globalOnes || bits != globalBits {
+		return fmt.Errorf("the CIDR %q and the global CIDR %q must be of the same family and size", cidr, globalCIDR)
+	}
+
+	t.mappings[cluster] = append(t.mappings[cluster], cidrMapping{cidr: cidr, globalCIDR: globalCIDR})
+
+	return nil
+}
+
+func (t *GlobalCIDRTransformer) TransformEndpoint(ep discovery.Endpoint, sourceCluster string) discovery.Endpoint {
+	mappings := t.mappings[sourceCluster]
+	if len(mappings) == 0 {
+		return ep
+	}
+
+	addresses := make([]string, len(ep.Addresses))
+
+	for i, address := range ep.Addresses {
+		addresses[i] = address
+
+		ip := net.ParseIP(address)
+		if ip == nil {
+			continue
+		}
+
+		for _, mapping := range mappings {
+			if mapping.cidr.Contains(ip) {
+				addresses[i] = translate(ip, mapping).String()
+				break
+			}
+		}
+	}
+
+	ep.Addresses = addresses
+
+	return ep
+}
+
+// translate keeps the host bits of ip and replaces its network bits with those of the global CIDR.
+func translate(ip net.IP, mapping cidrMapping) net.IP {
+	if len(mapping.globalCIDR.IP) == net.IPv4len {
+		ip = ip.To4()
+	} else {
+		ip = ip.To16()
+	}
+
+	translated := make(net.IP, len(ip))
+
+	for i := range ip {
+		translated[i] = mapping.globalCIDR.IP[i] | (ip[i] &^ mapping.globalCIDR.Mask[i])
+	}
+
+	return translated
+}
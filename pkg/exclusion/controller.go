@@ -0,0 +1,181 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package exclusion
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/submariner-io/lighthouse/pkg/rbac"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+// ClustersKey is the key of the ConfigMap data listing the IDs of the excluded clusters, separated by commas or
+// whitespace.
+const ClustersKey = "clusters"
+
+type NewClientsetFunc func(kubeConfig *rest.Config) (kubernetes.Interface, error)
+
+// Indirection hook for unit tests to supply fake client sets
+var NewClientset NewClientsetFunc
+
+// Controller watches a ConfigMap listing the clusters excluded from DNS answers, so the list can be changed without
+// restarting.
+type Controller struct {
+	// Indirection hook for unit tests to supply fake client sets
+	NewClientset NewClientsetFunc
+	namespace    string
+	name         string
+	excluded     atomic.Value
+	informer     cache.Controller
+	stopCh       chan struct{}
+}
+
+func NewController(namespace, name string) *Controller {
+	controller := &Controller{
+		NewClientset: getNewClientsetFunc(),
+		namespace:    namespace,
+		name:         name,
+		stopCh:       make(chan struct{}),
+	}
+	controller.excluded.Store(map[string]bool{})
+
+	return controller
+}
+
+func getNewClientsetFunc() NewClientsetFunc {
+	if NewClientset != nil {
+		return NewClientset
+	}
+
+	return func(c *rest.Config) (kubernetes.Interface, error) {
+		return kubernetes.NewForConfig(c)
+	}
+}
+
+// RequiredPermissions returns the permissions the controller needs to watch its ConfigMap.
+func (c *Controller) RequiredPermissions() []rbac.Permission {
+	return rbac.ResourcePermissions("", "configmaps", c.namespace, "list", "watch")
+}
+
+func (c *Controller) Start(kubeConfig *rest.Config) error {
+	klog.Infof("Starting the excluded clusters controller for ConfigMap %s/%s", c.namespace, c.name)
+
+	clientSet, err := c.NewClientset(kubeConfig)
+	if err != nil {
+		return fmt.Errorf("error creating client set: %v", err)
+	}
+
+	nameSelector := fields.OneTermEqualSelector("metadata.name", c.name).String()
+
+	_, c.informer = cache.NewInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				options.FieldSelector = nameSelector
+				return clientSet.CoreV1().ConfigMaps(c.namespace).List(options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				options.FieldSelector = nameSelector
+				return clientSet.CoreV1().ConfigMaps(c.namespace).Watch(options)
+			},
+		},
+		&v1.ConfigMap{},
+		0,
+		cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				c.configMapUpdated(obj.(*v1.ConfigMap))
+			},
+			UpdateFunc: func(old interface{}, new interface{}) {
+				c.configMapUpdated(new.(*v1.ConfigMap))
+			},
+			DeleteFunc: func(obj interface{}) {
+				c.setExcluded(map[string]bool{})
+			},
+		},
+	)
+
+	go c.informer.Run(c.stopCh)
+
+	return nil
+}
+
+// HasSynced returns true once the ConfigMap listed when the controller started was processed, so the excluded clusters
+// reflect it.
+func (c *Controller) HasSynced() bool {
+	return c.informer != nil && c.informer.HasSynced()
+}
+
+func (c *Controller) Stop() {
+	close(c.stopCh)
+
+	klog.Infof("Excluded clusters controller stopped")
+}
+
+func (c *Controller) configMapUpdated(configMap *v1.ConfigMap) {
+	if configMap.Name != c.name {
+		return
+	}
+
+	excluded := map[string]bool{}
+
+	for _, clusterID := range strings.FieldsFunc(configMap.Data[ClustersKey], func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	}) {
+		excluded[clusterID] = true
+	}
+
+	c.setExcluded(excluded)
+}
+
+func (c *Controller) setExcluded(excluded map[string]bool) {
+	if reflect.DeepEqual(excluded, c.excluded.Load().(map[string]bool)) {
+		return
+	}
+
+	// The map is replaced rather than updated so the DNS queries reading it concurrently never see a partial update.
+	c.excluded.Store(excluded)
+	klog.Infof("The clusters excluded from DNS answers are now %v", c.ExcludedClusters())
+}
+
+// IsExcluded returns true if the given cluster is excluded from DNS answers.
+func (c *Controller) IsExcluded(clusterID string) bool {
+	return c.excluded.Load().(map[string]bool)[clusterID]
+}
+
+// ExcludedClusters returns the sorted IDs of the clusters currently excluded from DNS answers.
+func (c *Controller) ExcludedClusters() []string {
+	excluded := c.excluded.Load().(map[string]bool)
+
+	clusterIDs := make([]string, 0, len(excluded))
+	for clusterID := range excluded {
+		clusterIDs = append(clusterIDs, clusterID)
+	}
+
+	sort.Strings(clusterIDs)
+
+	return clusterIDs
+}
@@ -0,0 +1,142 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package exclusion_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/submariner-io/lighthouse/pkg/exclusion"
+	"github.com/submariner-io/lighthouse/pkg/rbac"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	fakeKubeClient "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	namespace     = "submariner-operator"
+	configMapName = "excluded-clusters"
+)
+
+var _ = Describe("Excluded clusters controller", func() {
+	var (
+		kubeClient *fakeKubeClient.Clientset
+		controller *exclusion.Controller
+		configMap  *corev1.ConfigMap
+	)
+
+	BeforeEach(func() {
+		kubeClient = fakeKubeClient.NewSimpleClientset()
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      configMapName,
+				Namespace: namespace,
+			},
+			Data: map[string]string{exclusion.ClustersKey: "east, west"},
+		}
+
+		controller = exclusion.NewController(namespace, configMapName)
+		controller.NewClientset = func(kubeConfig *rest.Config) (kubernetes.Interface, error) {
+			return kubeClient, nil
+		}
+	})
+
+	JustBeforeEach(func() {
+		Expect(controller.Start(&rest.Config{})).To(Succeed())
+	})
+
+	AfterEach(func() {
+		controller.Stop()
+	})
+
+	awaitExcluded := func(clusterIDs ...string) {
+		Eventually(controller.ExcludedClusters, 5).Should(Equal(clusterIDs))
+	}
+
+	It("should only make requests covered by its required permissions", func() {
+		Eventually(kubeClient.Actions).ShouldNot(BeEmpty())
+
+		for _, action := range kubeClient.Actions() {
+			Expect(controller.RequiredPermissions()).To(ContainElement(rbac.Permission{Verb: action.GetVerb(),
+				Resource: action.GetResource().Resource, Namespace: action.GetNamespace()}))
+		}
+	})
+
+	When("the ConfigMap doesn't exist", func() {
+		It("should not exclude any cluster", func() {
+			Consistently(controller.ExcludedClusters, 0.3).Should(BeEmpty())
+			Expect(controller.IsExcluded("east")).To(BeFalse())
+		})
+	})
+
+	When("the ConfigMap exists when the controller starts", func() {
+		BeforeEach(func() {
+			_, err := kubeClient.CoreV1().ConfigMaps(namespace).Create(configMap)
+			Expect(err).To(Succeed())
+		})
+
+		It("should exclude the listed clusters once it has synced", func() {
+			Eventually(controller.HasSynced, 5).Should(BeTrue())
+			Expect(controller.ExcludedClusters()).To(Equal([]string{"east", "west"}))
+		})
+	})
+
+	When("the ConfigMap is created", func() {
+		It("should exclude the listed clusters", func() {
+			_, err := kubeClient.CoreV1().ConfigMaps(namespace).Create(configMap)
+			Expect(err).To(Succeed())
+
+			awaitExcluded("east", "west")
+			Expect(controller.IsExcluded("east")).To(BeTrue())
+			Expect(controller.IsExcluded("south")).To(BeFalse())
+		})
+	})
+
+	When("the ConfigMap is subsequently updated", func() {
+		It("should exclude the newly listed clusters", func() {
+			_, err := kubeClient.CoreV1().ConfigMaps(namespace).Create(configMap)
+			Expect(err).To(Succeed())
+			awaitExcluded("east", "west")
+
+			configMap.Data[exclusion.ClustersKey] = "south\nwest"
+			_, err = kubeClient.CoreV1().ConfigMaps(namespace).Update(configMap)
+			Expect(err).To(Succeed())
+			awaitExcluded("south", "west")
+		})
+	})
+
+	When("the ConfigMap is subsequently deleted", func() {
+		It("should not exclude any cluster", func() {
+			_, err := kubeClient.CoreV1().ConfigMaps(namespace).Create(configMap)
+			Expect(err).To(Succeed())
+			awaitExcluded("east", "west")
+
+			Expect(kubeClient.CoreV1().ConfigMaps(namespace).Delete(configMapName, &metav1.DeleteOptions{})).To(Succeed())
+			Eventually(controller.ExcludedClusters, 5).Should(BeEmpty())
+		})
+	})
+
+	When("another ConfigMap is created", func() {
+		It("should ignore it", func() {
+			configMap.Name = "other"
+			_, err := kubeClient.CoreV1().ConfigMaps(namespace).Create(configMap)
+			Expect(err).To(Succeed())
+
+			Consistently(controller.ExcludedClusters, 0.3).Should(BeEmpty())
+		})
+	})
+})
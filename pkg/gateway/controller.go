@@ -17,10 +17,15 @@ package gateway
 
 import (
 	"fmt"
+	"reflect"
+	"sort"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/submariner-io/admiral/pkg/log"
 	"github.com/submariner-io/admiral/pkg/workqueue"
+	"github.com/submariner-io/lighthouse/pkg/liveness"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -39,25 +44,102 @@ type NewClientsetFunc func(c *rest.Config) (dynamic.Interface, error)
 var NewClientset NewClientsetFunc
 
 type Controller struct {
+	// Incremented whenever the connection status map or the forced connections are stored. It's first so it's
+	// 64-bit aligned for atomic access on 32-bit platforms.
+	generation uint64
+	// Incremented whenever the connected statuses are set, so the Gateway statuses already applied are applied again.
+	statusesGeneration uint64
+	// The number of Gateways listed by the initial sync that weren't processed yet, or -1 until they're listed.
+	initialPending int64
+	// Set to 1 while more than one Gateway is active.
+	splitBrain       uint32
 	NewClientset     NewClientsetFunc
 	informer         cache.Controller
 	store            cache.Store
 	queue            workqueue.Interface
 	stopCh           chan struct{}
 	clusterStatusMap atomic.Value
-	localClusterID   atomic.Value
-	gatewayAvailable bool
+	// Serializes the updates of the cluster status map so a recompute can't interleave with an incremental update.
+	statusMapMutex  sync.Mutex
+	forcedConnected atomic.Value
+	localClusterID  atomic.Value
+	// The set of connection statuses reporting a cluster as connected.
+	connectedStatuses atomic.Value
+	gatewayAvailable  bool
+	results           chan<- reconcileResult
+	// Maps a Gateway key to the appliedVersion of its status last applied.
+	appliedVersions sync.Map
+	// The keys of the Gateways listed by the initial sync that weren't processed yet.
+	initialKeys sync.Map
+	// The handlers notified of each change in a cluster's connection status.
+	connectivityHandlers []ConnectivityChangeHandler
+	handlersMutex        sync.RWMutex
+	// Maps a cluster ID to its last Connection reported by an active Gateway. Only stored while holding statusMapMutex.
+	connections atomic.Value
+	// Maps a Gateway key to the IDs of the clusters whose connection it last reported, so the connections no Gateway
+	// reports anymore are pruned. Guarded by statusMapMutex.
+	reportedClusters map[string]map[string]bool
+	// The latencyRTTBounds of the accepted connection round-trip times.
+	latencyBounds atomic.Value
 }
 
+// Connection describes the connection to a cluster last reported by an active Gateway, whatever its status, so the
+// DNS-visible connectivity can be correlated with the tunnel technology it relies on.
+type Connection struct {
+	ClusterID string
+	Status    string
+	// The cable driver of the connection, eg libreswan, wireguard or vxlan.
+	Backend string
+	// The name of the cable the connection goes through.
+	CableName string
+	// The average round-trip time of the connection, or 0 if it's unknown, eg not reported or out of range.
+	LatencyRTT time.Duration
+}
+
+// appliedVersion identifies a Gateway status that was applied, along with the connected statuses it was applied with.
+type appliedVersion struct {
+	resourceVersion    string
+	statusesGeneration uint64
+}
+
+// ConnectivityChangeHandler is notified when the Gateway status reports a cluster becoming connected or disconnected.
+type ConnectivityChangeHandler func(clusterID string, connected bool)
+
+// reconcileResult is emitted on the results channel, if set, after each Gateway work item is processed.
+type reconcileResult struct {
+	Key     string
+	Outcome string
+}
+
+// DefaultConnectedStatus is the connection status reporting a cluster as connected unless configured otherwise.
+const DefaultConnectedStatus = "connected"
+
+const (
+	outcomeProcessed  = "Processed"
+	outcomeRequeued   = "Requeued"
+	outcomeIncomplete = "Incomplete"
+	outcomeDeleted    = "Deleted"
+)
+
+// How many times a Gateway with an incomplete status is retried before waiting for its next update, as its status may
+// never be completed, eg if its writer stopped half-way.
+const maxIncompleteStatusRequeues = 5
+
 func NewController() *Controller {
 	controller := &Controller{
 		NewClientset:     getNewClientsetFunc(),
 		queue:            workqueue.New("Gateway Controller"),
 		stopCh:           make(chan struct{}),
 		gatewayAvailable: true,
+		initialPending:   -1,
+		reportedClusters: map[string]map[string]bool{},
 	}
 	controller.clusterStatusMap.Store(make(map[string]bool))
+	controller.forcedConnected.Store(make(map[string]bool))
+	controller.connections.Store(map[string]Connection{})
+	controller.latencyBounds.Store(latencyRTTBounds{maxRTT: DefaultMaxLatencyRTT})
 	controller.localClusterID.Store("")
+	controller.connectedStatuses.Store(map[string]bool{DefaultConnectedStatus: true})
 
 	return controller
 }
@@ -91,15 +173,12 @@ func (c *Controller) Start(kubeConfig *rest.Config) error {
 			return gwClientset.List(metav1.ListOptions{})
 		},
 		WatchFunc: gwClientset.Watch,
-	}, &unstructured.Unstructured{}, 0, cache.ResourceEventHandlerFuncs{
+	}, &unstructured.Unstructured{}, liveness.ResyncPeriod, cache.ResourceEventHandlerFuncs{
 		AddFunc: c.queue.Enqueue,
 		UpdateFunc: func(old interface{}, new interface{}) {
 			c.queue.Enqueue(new)
 		},
-		DeleteFunc: func(obj interface{}) {
-			key, _ := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
-			klog.V(log.DEBUG).Infof("GatewayStatus %q deleted", key)
-		},
+		DeleteFunc: c.gatewayDeleted,
 	})
 
 	go c.informer.Run(c.stopCh)
@@ -108,6 +187,21 @@ func (c *Controller) Start(kubeConfig *rest.Config) error {
 		return fmt.Errorf("failed to wait for informer cache to sync")
 	}
 
+	pending := int64(0)
+
+	for _, key := range c.store.ListKeys() {
+		// The queue drops a key it can't split without processing it, so it mustn't hold up the initial sync.
+		if _, _, err := cache.SplitMetaNamespaceKey(key); err != nil {
+			klog.Errorf("Ignoring the Gateway with key %q for the initial sync: %v", key, err)
+			continue
+		}
+
+		c.initialKeys.Store(key, true)
+		pending++
+	}
+
+	atomic.StoreInt64(&c.initialPending, pending)
+
 	go c.queue.Run(c.stopCh, c.processNextGateway)
 
 	return nil
@@ -122,17 +216,140 @@ func (c *Controller) Stop() {
 func (c *Controller) processNextGateway(key, name, ns string) (bool, error) {
 	obj, exists, err := c.store.GetByKey(key)
 	if err != nil {
+		c.notifyResult(key, outcomeRequeued)
+
 		// requeue the item to work on later
 		return true, fmt.Errorf("error retrieving Gateway with key %q from the cache: %v", key, err)
 	}
 
+	// An incomplete status is retried but is as good as it gets for the initial sync.
+	if _, initial := c.initialKeys.Load(key); initial {
+		c.initialKeys.Delete(key)
+		atomic.AddInt64(&c.initialPending, -1)
+	}
+
 	if exists {
-		c.gatewayCreatedOrUpdated(obj.(*unstructured.Unstructured))
+		gw := obj.(*unstructured.Unstructured)
+
+		if isStatusIncomplete(gw) {
+			if c.queue.NumRequeues(key) < maxIncompleteStatusRequeues {
+				c.notifyResult(key, outcomeRequeued)
+
+				// The status is likely being written so retry with the latest version rather than applying a partial update
+				return true, fmt.Errorf("the status of Gateway %q at resourceVersion %q is incomplete", key,
+					gw.GetResourceVersion())
+			}
+
+			klog.Warningf("The status of Gateway %q at resourceVersion %q is still incomplete - waiting for its next update",
+				key, gw.GetResourceVersion())
+			c.notifyResult(key, outcomeIncomplete)
+
+			return false, nil
+		}
+
+		current := appliedVersion{
+			resourceVersion:    gw.GetResourceVersion(),
+			statusesGeneration: atomic.LoadUint64(&c.statusesGeneration),
+		}
+
+		if applied, ok := c.appliedVersions.Load(key); !ok || current.resourceVersion == "" || applied != current {
+			c.gatewayCreatedOrUpdated(gw)
+			c.appliedVersions.Store(key, current)
+		} else {
+			klog.V(log.DEBUG).Infof("The status of Gateway %q at resourceVersion %q was already applied", key,
+				current.resourceVersion)
+		}
 	}
 
+	c.updateGatewayCounts()
+	liveness.Reconciled(liveness.ControllerGateway)
+	c.notifyResult(key, outcomeProcessed)
+
 	return false, nil
 }
 
+// gatewayDeleted forgets the status applied for a deleted Gateway, given as is or as a DeletedFinalStateUnknown if the
+// deletion was missed. The Gateway counts are still updated if its key can't be determined.
+func (c *Controller) gatewayDeleted(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err == nil {
+		_, _, err = cache.SplitMetaNamespaceKey(key)
+	}
+
+	if err != nil {
+		klog.Errorf("Error determining the key of the deleted Gateway %#v: %v", obj, err)
+		c.updateGatewayCounts()
+
+		return
+	}
+
+	klog.V(log.DEBUG).Infof("GatewayStatus %q deleted", key)
+	c.appliedVersions.Delete(key)
+	c.forgetReportedClusters(key)
+	c.updateGatewayCounts()
+	c.notifyResult(key, outcomeDeleted)
+}
+
+// setResultsChannel sets a channel on which the outcome of each processed work item is sent so unit tests can wait
+// for it instead of polling. It must be called before Start.
+func (c *Controller) setResultsChannel(results chan<- reconcileResult) {
+	c.results = results
+}
+
+func (c *Controller) notifyResult(key, outcome string) {
+	if c.results == nil {
+		return
+	}
+
+	select {
+	case c.results <- reconcileResult{Key: key, Outcome: outcome}:
+	case <-c.stopCh:
+	}
+}
+
+// updateGatewayCounts recomputes the Gateway gauges from the store contents so they can't drift from the actual state.
+func (c *Controller) updateGatewayCounts() {
+	total := 0
+	active := []string{}
+
+	for _, obj := range c.store.List() {
+		total++
+
+		gw := obj.(*unstructured.Unstructured)
+
+		haStatus, _, _ := unstructured.NestedString(gw.Object, "status", "haStatus")
+		if haStatus == "active" {
+			active = append(active, gw.GetName())
+		}
+	}
+
+	GatewaysTotal.Set(float64(total))
+	GatewaysActive.Set(float64(len(active)))
+
+	c.updateSplitBrain(active)
+}
+
+// updateSplitBrain reports, via a gauge and a warning when it starts and ends, whether more than one Gateway is
+// active. The statuses of all the active Gateways are aggregated regardless.
+func (c *Controller) updateSplitBrain(active []string) {
+	if len(active) > 1 {
+		GatewaySplitBrain.Set(1)
+
+		if atomic.CompareAndSwapUint32(&c.splitBrain, 0, 1) {
+			sort.Strings(active)
+			klog.Warningf("Split brain: %d Gateways are active - %v", len(active), active)
+		}
+
+		return
+	}
+
+	GatewaySplitBrain.Set(0)
+
+	if atomic.CompareAndSwapUint32(&c.splitBrain, 1, 0) {
+		klog.Warningf("The split brain ended - the active Gateways are now %v", active)
+	}
+}
+
 func (c *Controller) gatewayCreatedOrUpdated(obj *unstructured.Unstructured) {
 	connections, localClusterID, ok := getGatewayStatus(obj)
 	if !ok {
@@ -142,13 +359,19 @@ func (c *Controller) gatewayCreatedOrUpdated(obj *unstructured.Unstructured) {
 	// Updating
 	c.updateLocalClusterIDIfNeeded(localClusterID)
 
-	c.updateClusterStatusMap(connections)
+	key, _ := cache.MetaNamespaceKeyFunc(obj)
+	c.updateClusterStatusMap(key, connections)
 }
 
-func (c *Controller) updateClusterStatusMap(connections []interface{}) {
+func (c *Controller) updateClusterStatusMap(key string, connections []interface{}) {
 	var newMap map[string]bool
 
+	c.statusMapMutex.Lock()
+
 	currentMap := c.getClusterStatusMap()
+	changed := map[string]bool{}
+	newConnections := copyConnections(c.getConnections())
+	reported := map[string]bool{}
 
 	for _, connection := range connections {
 		connectionMap := connection.(map[string]interface{})
@@ -156,15 +379,21 @@ func (c *Controller) updateClusterStatusMap(connections []interface{}) {
 		status, found, err := unstructured.NestedString(connectionMap, "status")
 		if err != nil || !found {
 			klog.Errorf("status field not found in %#v", connectionMap)
+			GatewayParseErrors.WithLabelValues("connections.status").Inc()
 		}
 
 		clusterID, found, err := unstructured.NestedString(connectionMap, "endpoint", "cluster_id")
 		if !found || err != nil {
 			klog.Errorf("cluster_id field not found in %#v", connectionMap)
+			GatewayParseErrors.WithLabelValues("connections.endpoint.cluster_id").Inc()
 			continue
 		}
 
-		if status == "connected" {
+		newConnections[clusterID] = c.parseConnection(connectionMap, clusterID, status, true)
+		reported[clusterID] = true
+
+		// The local cluster is always connected to itself, whatever the connected statuses.
+		if c.isConnectedStatus(status) || clusterID == c.LocalClusterID() {
 			_, found := currentMap[clusterID]
 			if !found {
 				if newMap == nil {
@@ -172,6 +401,7 @@ func (c *Controller) updateClusterStatusMap(connections []interface{}) {
 				}
 
 				newMap[clusterID] = true
+				changed[clusterID] = true
 			}
 		} else {
 			_, found = currentMap[clusterID]
@@ -180,6 +410,7 @@ func (c *Controller) updateClusterStatusMap(connections []interface{}) {
 					newMap = copyMap(currentMap)
 				}
 				delete(newMap, clusterID)
+				changed[clusterID] = false
 			}
 		}
 	}
@@ -187,6 +418,146 @@ func (c *Controller) updateClusterStatusMap(connections []interface{}) {
 	if newMap != nil {
 		klog.Infof("Updating the gateway status %#v ", newMap)
 		c.clusterStatusMap.Store(newMap)
+		atomic.AddUint64(&c.generation, 1)
+	}
+
+	c.reportedClusters[key] = reported
+	c.pruneConnections(newConnections)
+	c.storeConnections(newConnections)
+
+	c.statusMapMutex.Unlock()
+
+	c.notifyConnectivityChanges(changed)
+}
+
+// RecomputeClusterStatus rebuilds the cluster status map from the Gateways currently in the store, rather than
+// incrementally from their updates, and atomically replaces it. The connected clusters are the union of the remote
+// clusters connected to the active Gateways, along with the local cluster. It's meant to recover from a suspected
+// drift of the map, eg after fixing a bug in the Gateway status reporting, and returns the IDs of the connected
+// clusters. Unlike ForceConnected, it only ever reflects the actual Gateway statuses.
+func (c *Controller) RecomputeClusterStatus() []string {
+	newMap := map[string]bool{}
+	newConnections := map[string]Connection{}
+	reportedClusters := map[string]map[string]bool{}
+
+	if c.store != nil {
+		for _, obj := range c.store.List() {
+			connections, _, ok := getGatewayStatus(obj.(*unstructured.Unstructured))
+			if !ok {
+				continue
+			}
+
+			key, _ := cache.MetaNamespaceKeyFunc(obj)
+			reportedClusters[key] = map[string]bool{}
+
+			for _, connection := range connections {
+				connectionMap := connection.(map[string]interface{})
+				status, _, _ := unstructured.NestedString(connectionMap, "status")
+
+				clusterID, found, _ := unstructured.NestedString(connectionMap, "endpoint", "cluster_id")
+				if !found {
+					continue
+				}
+
+				newConnections[clusterID] = c.parseConnection(connectionMap, clusterID, status, false)
+				reportedClusters[key][clusterID] = true
+
+				if c.isConnectedStatus(status) || clusterID == c.LocalClusterID() {
+					newMap[clusterID] = true
+				}
+			}
+		}
+	}
+
+	c.statusMapMutex.Lock()
+
+	currentMap := c.getClusterStatusMap()
+	changed := map[string]bool{}
+
+	for clusterID := range currentMap {
+		if !newMap[clusterID] {
+			changed[clusterID] = false
+		}
+	}
+
+	for clusterID := range newMap {
+		if !currentMap[clusterID] {
+			changed[clusterID] = true
+		}
+	}
+
+	if len(changed) > 0 {
+		klog.Warningf("The recomputed gateway status %v differs from the previous one %v", mapKeys(newMap),
+			mapKeys(currentMap))
+	} else {
+		klog.Infof("The recomputed gateway status %v matches the previous one", mapKeys(newMap))
+	}
+
+	c.clusterStatusMap.Store(newMap)
+	atomic.AddUint64(&c.generation, 1)
+	c.reportedClusters = reportedClusters
+	c.storeConnections(newConnections)
+
+	c.statusMapMutex.Unlock()
+
+	c.notifyConnectivityChanges(changed)
+
+	return mapKeys(newMap)
+}
+
+// SetConnectedStatuses sets the connection statuses reporting a cluster as connected, replacing the default
+// "connected", eg for Submariner builds whose cable drivers report "established". The Gateways are processed again so
+// the clusters' connectivity reflects the new statuses.
+func (c *Controller) SetConnectedStatuses(statuses ...string) {
+	connected := map[string]bool{}
+	for _, status := range statuses {
+		connected[status] = true
+	}
+
+	klog.Infof("Clusters are considered connected when their connection status is one of %v", mapKeys(connected))
+
+	if reflect.DeepEqual(connected, c.connectedStatuses.Load()) {
+		return
+	}
+
+	c.connectedStatuses.Store(connected)
+	atomic.AddUint64(&c.statusesGeneration, 1)
+
+	if c.store == nil {
+		return
+	}
+
+	for _, obj := range c.store.List() {
+		c.queue.Enqueue(obj)
+	}
+}
+
+func (c *Controller) isConnectedStatus(status string) bool {
+	return c.connectedStatuses.Load().(map[string]bool)[status]
+}
+
+// OnConnectivityChange registers a handler notified whenever the Gateway status reports a cluster becoming connected
+// or disconnected. The handler is called from the controller's worker after the new status is stored, so IsConnected
+// reflects it, and must not block.
+func (c *Controller) OnConnectivityChange(handler ConnectivityChangeHandler) {
+	c.handlersMutex.Lock()
+	defer c.handlersMutex.Unlock()
+
+	c.connectivityHandlers = append(c.connectivityHandlers, handler)
+}
+
+func (c *Controller) notifyConnectivityChanges(changed map[string]bool) {
+	if len(changed) == 0 {
+		return
+	}
+
+	c.handlersMutex.RLock()
+	defer c.handlersMutex.RUnlock()
+
+	for clusterID, connected := range changed {
+		for _, handler := range c.connectivityHandlers {
+			handler(clusterID, connected)
+		}
 	}
 }
 
@@ -198,10 +569,25 @@ func (c *Controller) updateLocalClusterIDIfNeeded(clusterID string) {
 	}
 }
 
+// isStatusIncomplete returns true if the Gateway is active but its status is missing the local endpoint or the
+// connections, which happens transiently while the status is written.
+func isStatusIncomplete(obj *unstructured.Unstructured) bool {
+	haStatus, _, _ := unstructured.NestedString(obj.Object, "status", "haStatus")
+	if haStatus != "active" {
+		return false
+	}
+
+	_, hasLocalEndpoint, _ := unstructured.NestedFieldNoCopy(obj.Object, "status", "localEndpoint", "cluster_id")
+	_, hasConnections, _ := unstructured.NestedFieldNoCopy(obj.Object, "status", "connections")
+
+	return !hasLocalEndpoint || !hasConnections
+}
+
 func getGatewayStatus(obj *unstructured.Unstructured) (connections []interface{}, clusterID string, gwStatus bool) {
 	status, found, err := unstructured.NestedMap(obj.Object, "status")
 	if !found || err != nil {
 		klog.Errorf("status field not found in %#v, err was: %v", obj, err)
+		GatewayParseErrors.WithLabelValues("status").Inc()
 		return nil, "", false
 	}
 
@@ -209,14 +595,15 @@ func getGatewayStatus(obj *unstructured.Unstructured) (connections []interface{}
 
 	if !found || err != nil {
 		klog.Errorf("localEndpoint->cluster_id not found in %#v, err was: %v", status, err)
+		GatewayParseErrors.WithLabelValues("localEndpoint.cluster_id").Inc()
 
 		localClusterID = ""
 	} else {
+		// The whole local endpoint is kept so its cable driver is parsed like the remote endpoints'.
+		localEndpoint, _, _ := unstructured.NestedMap(status, "localEndpoint")
 		connections = append(connections, map[string]interface{}{
-			"status": "connected",
-			"endpoint": map[string]interface{}{
-				"cluster_id": localClusterID,
-			},
+			"status":   DefaultConnectedStatus,
+			"endpoint": localEndpoint,
 		})
 	}
 
@@ -224,6 +611,7 @@ func getGatewayStatus(obj *unstructured.Unstructured) (connections []interface{}
 
 	if !found || err != nil {
 		klog.Errorf("haStatus field not found in %#v, err was: %v", status, err)
+		GatewayParseErrors.WithLabelValues("haStatus").Inc()
 		return connections, localClusterID, true
 	}
 
@@ -231,6 +619,7 @@ func getGatewayStatus(obj *unstructured.Unstructured) (connections []interface{}
 		rconns, _, err := unstructured.NestedSlice(status, "connections")
 		if err != nil {
 			klog.Errorf("connections field not found in %#v, err was: %v", status, err)
+			GatewayParseErrors.WithLabelValues("connections").Inc()
 			return connections, localClusterID, false
 		}
 
@@ -240,6 +629,93 @@ func getGatewayStatus(obj *unstructured.Unstructured) (connections []interface{}
 	return connections, localClusterID, true
 }
 
+// parseConnection returns the Connection of the cluster from its Gateway status connection. The cable driver, cable
+// name and round-trip time are optional as older Gateways don't report them, so only a cable field of the wrong type
+// is counted as a parse error, if countErrors is set.
+func (c *Controller) parseConnection(connectionMap map[string]interface{}, clusterID, status string,
+	countErrors bool) Connection {
+	connection := Connection{ClusterID: clusterID, Status: status, LatencyRTT: c.parseLatencyRTT(connectionMap, clusterID)}
+
+	for field, value := range map[string]*string{"backend": &connection.Backend, "cable_name": &connection.CableName} {
+		parsed, _, err := unstructured.NestedString(connectionMap, "endpoint", field)
+		if err != nil && countErrors {
+			klog.Errorf("%s field of cluster %q is invalid in %#v: %v", field, clusterID, connectionMap, err)
+			GatewayParseErrors.WithLabelValues("connections.endpoint." + field).Inc()
+		}
+
+		*value = parsed
+	}
+
+	return connection
+}
+
+// forgetReportedClusters prunes the connections only the deleted Gateway with the given key reported.
+func (c *Controller) forgetReportedClusters(key string) {
+	c.statusMapMutex.Lock()
+	defer c.statusMapMutex.Unlock()
+
+	if _, found := c.reportedClusters[key]; !found {
+		return
+	}
+
+	delete(c.reportedClusters, key)
+
+	newConnections := copyConnections(c.getConnections())
+	c.pruneConnections(newConnections)
+	c.storeConnections(newConnections)
+}
+
+// pruneConnections removes the connections of the clusters that none of the Gateways reports anymore, eg once a
+// cluster left the clusterset. It must be called while holding statusMapMutex.
+func (c *Controller) pruneConnections(connections map[string]Connection) {
+	for clusterID := range connections {
+		reported := false
+
+		for _, clusters := range c.reportedClusters {
+			reported = reported || clusters[clusterID]
+		}
+
+		if !reported {
+			klog.Infof("Pruning the connection of cluster %q as no Gateway reports it anymore", clusterID)
+			delete(connections, clusterID)
+		}
+	}
+}
+
+// storeConnections stores the clusters' Connections and updates the backend metric of the clusters whose cable driver
+// changed. It must be called while holding statusMapMutex.
+func (c *Controller) storeConnections(newConnections map[string]Connection) {
+	current := c.getConnections()
+
+	for clusterID, connection := range current {
+		if newConnection, found := newConnections[clusterID]; (!found || newConnection.Backend != connection.Backend) &&
+			connection.Backend != "" {
+			GatewayConnectionBackend.DeleteLabelValues(clusterID, connection.Backend)
+		}
+	}
+
+	for clusterID, connection := range newConnections {
+		if connection.Backend != "" {
+			GatewayConnectionBackend.WithLabelValues(clusterID, connection.Backend).Set(1)
+		}
+	}
+
+	c.connections.Store(newConnections)
+}
+
+func (c *Controller) getConnections() map[string]Connection {
+	return c.connections.Load().(map[string]Connection)
+}
+
+func copyConnections(src map[string]Connection) map[string]Connection {
+	m := make(map[string]Connection, len(src))
+	for k, v := range src {
+		m[k] = v
+	}
+
+	return m
+}
+
 func (c *Controller) getClusterStatusMap() map[string]bool {
 	return c.clusterStatusMap.Load().(map[string]bool)
 }
@@ -266,9 +742,89 @@ func copyMap(src map[string]bool) map[string]bool {
 	return m
 }
 
+func mapKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
 // Public API
 func (c *Controller) IsConnected(clusterID string) bool {
-	return !c.gatewayAvailable || c.getClusterStatusMap()[clusterID]
+	return !c.gatewayAvailable || c.getClusterStatusMap()[clusterID] || c.forcedConnected.Load().(map[string]bool)[clusterID]
+}
+
+// GetWithGeneration returns the IDs of the connected clusters, including those forced to be connected, along with a
+// generation that increases whenever they may have changed. Callers caching results derived from the connectivity
+// can skip recomputing them while the generation is unchanged. The returned map must not be modified. Note that all
+// clusters are reported as connected by IsConnected if the Gateway resource doesn't exist, which isn't reflected here.
+func (c *Controller) GetWithGeneration() (map[string]bool, uint64) {
+	// The generation is read first as it's incremented after storing, so a concurrent update at worst results in a
+	// newer map being returned with the previous generation, which only causes a spurious recomputation later.
+	generation := atomic.LoadUint64(&c.generation)
+
+	connected := c.getClusterStatusMap()
+
+	forced := c.forcedConnected.Load().(map[string]bool)
+	if len(forced) > 0 {
+		connected = copyMap(connected)
+		for k := range forced {
+			connected[k] = true
+		}
+	}
+
+	return connected, generation
+}
+
+// ForceConnected overrides the Gateway status to report the given clusters as connected, regardless of their actual
+// connection status. This is intended for disaster scenarios where the Gateway status reporting is broken but the
+// tunnels are up. The override remains in effect until ClearForceConnected is called.
+func (c *Controller) ForceConnected(clusterIDs ...string) {
+	forced := copyMap(c.forcedConnected.Load().(map[string]bool))
+	for _, clusterID := range clusterIDs {
+		forced[clusterID] = true
+		ForcedConnections.WithLabelValues(clusterID).Set(1)
+	}
+
+	klog.Warningf("Forcing clusters %v to be reported as connected - the override is now in effect for %v", clusterIDs,
+		mapKeys(forced))
+	c.forcedConnected.Store(forced)
+	atomic.AddUint64(&c.generation, 1)
+}
+
+// ClearForceConnected removes any forced connection override.
+func (c *Controller) ClearForceConnected() {
+	forced := c.forcedConnected.Load().(map[string]bool)
+	if len(forced) == 0 {
+		return
+	}
+
+	klog.Warningf("Clearing the forced connection override for clusters %v", mapKeys(forced))
+	c.forcedConnected.Store(make(map[string]bool))
+	atomic.AddUint64(&c.generation, 1)
+	ForcedConnections.Reset()
+}
+
+// ForcedConnectedClusters returns the IDs of the clusters currently forced to be reported as connected.
+func (c *Controller) ForcedConnectedClusters() []string {
+	return mapKeys(c.forcedConnected.Load().(map[string]bool))
+}
+
+// GetConnection returns the connection to the given cluster last reported by an active Gateway, with its cable driver,
+// and whether there's one. It's independent of whether the cluster is reported as connected by IsConnected.
+func (c *Controller) GetConnection(clusterID string) (Connection, bool) {
+	connection, found := c.getConnections()[clusterID]
+	return connection, found
+}
+
+// HasSynced returns true once the Gateways listed when the controller started were processed, so the connectivity of
+// the clusters reflects them. It's always true if the Gateway resource doesn't exist.
+func (c *Controller) HasSynced() bool {
+	return !c.gatewayAvailable || atomic.LoadInt64(&c.initialPending) == 0
 }
 
 func (c *Controller) LocalClusterID() string {
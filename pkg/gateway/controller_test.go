@@ -16,12 +16,21 @@ limitations under the License.
 package gateway_test
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
 	"testing"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/submariner-io/admiral/pkg/fake"
 	"github.com/submariner-io/lighthouse/pkg/gateway"
+	"github.com/submariner-io/lighthouse/pkg/liveness"
+	"github.com/submariner-io/lighthouse/pkg/rbac"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -30,7 +39,12 @@ import (
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/dynamic"
 	fakeClient "k8s.io/client-go/dynamic/fake"
+	fakeKubeClient "k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/flowcontrol"
 	"k8s.io/klog"
 )
 
@@ -38,6 +52,12 @@ const localClusterID = "east"
 const remoteClusterID1 = "west"
 const remoteClusterID2 = "south"
 
+var gatewayGVR = schema.GroupVersionResource{
+	Group:    "submariner.io",
+	Version:  "v1",
+	Resource: "gateways",
+}
+
 var _ = Describe("Gateway controller", func() {
 	t := newTestDiver()
 
@@ -83,24 +103,175 @@ var _ = Describe("Gateway controller", func() {
 		When("IsConnected is called for the remote clusters", func() {
 			It("should return the appropriate response", func() {
 				t.createGateway()
-				t.awaitIsConnected(localClusterID)
+				t.awaitResult(gateway.OutcomeProcessed)
+				Expect(t.controller.IsConnected(localClusterID)).To(BeTrue())
 
 				t.addGatewayStatusConnection(remoteClusterID1, "connected")
 				t.updateGateway()
-				t.awaitIsConnected(remoteClusterID1)
+				t.awaitResult(gateway.OutcomeProcessed)
+				Expect(t.controller.IsConnected(remoteClusterID1)).To(BeTrue())
 
 				t.addGatewayStatusConnection(remoteClusterID1, "error")
 				t.addGatewayStatusConnection(remoteClusterID2, "connected")
 				t.updateGateway()
-				t.awaitIsNotConnected(remoteClusterID1)
-				t.awaitIsConnected(remoteClusterID2)
+				t.awaitResult(gateway.OutcomeProcessed)
+				Expect(t.controller.IsConnected(remoteClusterID1)).To(BeFalse())
+				Expect(t.controller.IsConnected(remoteClusterID2)).To(BeTrue())
 
 				t.addGatewayStatusConnection(remoteClusterID1, "connected")
 				t.addGatewayStatusConnection(remoteClusterID2, "error")
 				t.updateGateway()
-				t.awaitIsConnected(remoteClusterID1)
-				t.awaitIsNotConnected(remoteClusterID2)
+				t.awaitResult(gateway.OutcomeProcessed)
+				Expect(t.controller.IsConnected(remoteClusterID1)).To(BeTrue())
+				Expect(t.controller.IsConnected(remoteClusterID2)).To(BeFalse())
+			})
+		})
+	})
+
+	When("an active Gateway's status is read while partially written", func() {
+		It("should requeue and not apply the partial status", func() {
+			t.addGatewayStatusConnection(remoteClusterID1, "connected")
+			t.createGateway()
+			t.awaitResult(gateway.OutcomeProcessed)
+			Expect(t.controller.IsConnected(remoteClusterID1)).To(BeTrue())
+
+			unstructured.RemoveNestedField(t.gatewayObj.Object, "status", "connections")
+			t.setGatewayLocalClusterID(remoteClusterID2)
+			t.updateGateway()
+			t.awaitResult(gateway.OutcomeRequeued)
+			Expect(t.controller.IsConnected(remoteClusterID1)).To(BeTrue())
+			Expect(t.controller.LocalClusterID()).To(Equal(localClusterID))
+
+			t.setGatewayLocalClusterID(localClusterID)
+			t.addGatewayStatusConnection(remoteClusterID1, "error")
+			t.updateGateway()
+			t.awaitResult(gateway.OutcomeProcessed)
+			Expect(t.controller.IsConnected(remoteClusterID1)).To(BeFalse())
+		})
+	})
+
+	When("an active Gateway's status stays incomplete", func() {
+		It("should stop requeuing it and apply its next complete update", func() {
+			unstructured.RemoveNestedField(t.gatewayObj.Object, "status", "connections")
+			t.createGateway()
+			t.awaitResult(gateway.OutcomeIncomplete)
+
+			Consistently(t.results, 300*time.Millisecond).ShouldNot(Receive())
+			Expect(t.controller.LocalClusterID()).To(BeEmpty())
+
+			t.addGatewayStatusConnection(remoteClusterID1, "connected")
+			t.updateGateway()
+			t.awaitResult(gateway.OutcomeProcessed)
+			Expect(t.controller.IsConnected(remoteClusterID1)).To(BeTrue())
+			Expect(t.controller.LocalClusterID()).To(Equal(localClusterID))
+		})
+	})
+
+	When("a Gateway is reconciled", func() {
+		It("should advance the last reconcile timestamp only on success", func() {
+			reconciled := func() float64 {
+				return testutil.ToFloat64(liveness.LastReconcileTimestamp.WithLabelValues(liveness.ControllerGateway))
+			}
+
+			t.addGatewayStatusConnection(remoteClusterID1, "connected")
+			t.createGateway()
+			t.awaitResult(gateway.OutcomeProcessed)
+			last := reconciled()
+			Expect(last).ToNot(BeZero())
+
+			unstructured.RemoveNestedField(t.gatewayObj.Object, "status", "connections")
+			t.updateGateway()
+			t.awaitResult(gateway.OutcomeRequeued)
+			Expect(reconciled()).To(Equal(last))
+
+			t.addGatewayStatusConnection(remoteClusterID1, "error")
+			t.updateGateway()
+			t.awaitResult(gateway.OutcomeProcessed)
+			Expect(reconciled()).To(BeNumerically(">", last))
+		})
+	})
+
+	When("a Gateway is deleted", func() {
+		It("should emit the processing results", func() {
+			t.createGateway()
+			t.awaitResult(gateway.OutcomeProcessed)
+
+			Expect(t.gatewayClient.Delete(t.gatewayObj.GetName(), &metav1.DeleteOptions{})).To(Succeed())
+			t.awaitResult(gateway.OutcomeDeleted)
+		})
+	})
+
+	When("a Gateway is namespaced", func() {
+		testNamespacedGateway := func(namespace string) {
+			BeforeEach(func() {
+				t.gatewayObj.SetNamespace(namespace)
+				t.addGatewayStatusConnection(remoteClusterID1, "connected")
+				t.createGateway()
+			})
+
+			It("should process it at startup under its namespaced key", func() {
+				t.awaitResult(gateway.OutcomeProcessed)
+				Eventually(t.controller.HasSynced, 5).Should(BeTrue())
+				Expect(t.controller.IsConnected(remoteClusterID1)).To(BeTrue())
+			})
+
+			It("should process its deletion under its namespaced key", func() {
+				t.awaitResult(gateway.OutcomeProcessed)
+				t.awaitGatewayCounts(1, 1)
+
+				t.deleteGateway()
+				t.awaitResult(gateway.OutcomeDeleted)
+				t.awaitGatewayCounts(0, 0)
 			})
+
+			It("should return it from the debug endpoint", func() {
+				t.awaitResult(gateway.OutcomeProcessed)
+
+				gateways := t.getDebugGateways("?name=" + t.gatewayObj.GetName())
+				Expect(gateways).To(HaveLen(1))
+				Expect(gateways[0]).To(HaveKeyWithValue("metadata", HaveKeyWithValue("namespace", namespace)))
+			})
+		}
+
+		When("its deletion was missed by the informer", func() {
+			BeforeEach(func() {
+				t.gatewayObj.SetNamespace("submariner-operator")
+			})
+
+			It("should process it under the key of the last known state", func() {
+				gateway.GatewayDeleted(t.controller, cache.DeletedFinalStateUnknown{
+					Key: "submariner-operator/" + t.gatewayObj.GetName(),
+					Obj: t.gatewayObj,
+				})
+				t.awaitResult(gateway.OutcomeDeleted)
+			})
+		})
+
+		When("the deleted object's key can't be determined", func() {
+			It("should not emit a processing result", func() {
+				gateway.GatewayDeleted(t.controller, "not-a-gateway")
+				Consistently(t.results, 0.3).ShouldNot(Receive())
+			})
+		})
+
+		Context("in the default namespace", func() {
+			testNamespacedGateway(metav1.NamespaceDefault)
+		})
+
+		Context("in a custom namespace", func() {
+			testNamespacedGateway("submariner-operator")
+		})
+	})
+
+	When("the Gateways existing at startup are processed", func() {
+		BeforeEach(func() {
+			t.addGatewayStatusConnection(remoteClusterID1, "connected")
+			t.createGateway()
+		})
+
+		It("should report the controller as synced", func() {
+			Eventually(t.controller.HasSynced, 5).Should(BeTrue())
+			Expect(t.controller.IsConnected(remoteClusterID1)).To(BeTrue())
 		})
 	})
 
@@ -129,6 +300,667 @@ var _ = Describe("Gateway controller", func() {
 		})
 	})
 
+	When("a second Gateway becomes active", func() {
+		It("should report a split brain until only one is active", func() {
+			t.createGateway()
+			t.awaitGatewayCounts(1, 1)
+			t.awaitSplitBrain(0)
+
+			second := newGateway()
+			second.SetName("second-gateway")
+			_, err := t.gatewayClient.Create(second, metav1.CreateOptions{})
+			Expect(err).To(Succeed())
+			t.awaitGatewayCounts(2, 2)
+			t.awaitSplitBrain(1)
+
+			Expect(unstructured.SetNestedField(second.Object, "passive", "status", "haStatus")).To(Succeed())
+			_, err = t.gatewayClient.Update(second, metav1.UpdateOptions{})
+			Expect(err).To(Succeed())
+			t.awaitGatewayCounts(2, 1)
+			t.awaitSplitBrain(0)
+		})
+	})
+
+	When("active and passive Gateways are created", func() {
+		It("should update the Gateway gauges", func() {
+			t.createGateway()
+			t.awaitGatewayCounts(1, 1)
+
+			passive := newGateway()
+			passive.SetName("passive-gateway")
+			Expect(unstructured.SetNestedField(passive.Object, "passive", "status", "haStatus")).To(Succeed())
+			_, err := t.gatewayClient.Create(passive, metav1.CreateOptions{})
+			Expect(err).To(Succeed())
+			t.awaitGatewayCounts(2, 1)
+
+			Expect(t.gatewayClient.Delete(t.gatewayObj.GetName(), nil)).To(Succeed())
+			t.awaitGatewayCounts(1, 0)
+		})
+	})
+
+	When("clusters are forced to be connected", func() {
+		It("should report them as connected until the override is cleared", func() {
+			t.addGatewayStatusConnection(remoteClusterID1, "error")
+			t.createGateway()
+			t.awaitIsConnected(localClusterID)
+			Expect(t.controller.IsConnected(remoteClusterID1)).To(BeFalse())
+
+			t.controller.ForceConnected(remoteClusterID1, remoteClusterID2)
+			Expect(t.controller.IsConnected(remoteClusterID1)).To(BeTrue())
+			Expect(t.controller.IsConnected(remoteClusterID2)).To(BeTrue())
+			Expect(t.controller.ForcedConnectedClusters()).To(Equal([]string{remoteClusterID2, remoteClusterID1}))
+			Expect(testutil.ToFloat64(gateway.ForcedConnections.WithLabelValues(remoteClusterID1))).To(Equal(float64(1)))
+
+			t.updateGateway()
+			Consistently(func() bool {
+				return t.controller.IsConnected(remoteClusterID1)
+			}, 0.3).Should(BeTrue())
+
+			t.controller.ClearForceConnected()
+			Expect(t.controller.IsConnected(remoteClusterID1)).To(BeFalse())
+			Expect(t.controller.IsConnected(remoteClusterID2)).To(BeFalse())
+			Expect(t.controller.IsConnected(localClusterID)).To(BeTrue())
+			Expect(t.controller.ForcedConnectedClusters()).To(BeEmpty())
+			Expect(testutil.ToFloat64(gateway.ForcedConnections.WithLabelValues(remoteClusterID1))).To(BeZero())
+		})
+	})
+
+	When("the connected statuses are configured", func() {
+		It("should report clusters as connected only with the configured statuses", func() {
+			t.addGatewayStatusConnection(remoteClusterID1, "established")
+			t.addGatewayStatusConnection(remoteClusterID2, "connected")
+			t.createGateway()
+			t.awaitIsConnected(remoteClusterID2)
+			Expect(t.controller.IsConnected(remoteClusterID1)).To(BeFalse())
+
+			t.controller.SetConnectedStatuses("established")
+			t.awaitIsConnected(remoteClusterID1)
+			t.awaitIsNotConnected(remoteClusterID2)
+			Expect(t.controller.IsConnected(localClusterID)).To(BeTrue())
+
+			t.controller.SetConnectedStatuses(gateway.DefaultConnectedStatus)
+			t.awaitIsConnected(remoteClusterID2)
+			t.awaitIsNotConnected(remoteClusterID1)
+		})
+	})
+
+	When("the connection status of a remote cluster changes", func() {
+		It("should notify the connectivity change handlers", func() {
+			changes := make(chan string, 10)
+			t.controller.OnConnectivityChange(func(clusterID string, connected bool) {
+				changes <- fmt.Sprintf("%s=%t", clusterID, connected)
+			})
+
+			t.addGatewayStatusConnection(remoteClusterID1, "connected")
+			t.createGateway()
+			var first, second string
+			Eventually(changes).Should(Receive(&first))
+			Eventually(changes).Should(Receive(&second))
+			Expect([]string{first, second}).To(ConsistOf(localClusterID+"=true", remoteClusterID1+"=true"))
+			Expect(t.controller.IsConnected(remoteClusterID1)).To(BeTrue())
+
+			t.addGatewayStatusConnection(remoteClusterID1, "error")
+			t.updateGateway()
+			Eventually(changes).Should(Receive(Equal(remoteClusterID1 + "=false")))
+
+			t.addGatewayStatusConnection(remoteClusterID1, "connected")
+			t.updateGateway()
+			Eventually(changes).Should(Receive(Equal(remoteClusterID1 + "=true")))
+			Consistently(changes, 0.3).ShouldNot(Receive())
+		})
+	})
+
+	When("connectivity Events are recorded", func() {
+		var (
+			recorder *record.FakeRecorder
+			object   *corev1.ObjectReference
+		)
+
+		BeforeEach(func() {
+			recorder = record.NewFakeRecorder(10)
+			object = &corev1.ObjectReference{APIVersion: "v1", Kind: "ConfigMap", Namespace: "submariner", Name: "lighthouse"}
+		})
+
+		It("should record an Event on each transition of a remote cluster", func() {
+			t.controller.RecordConnectivityEvents(recorder, object, flowcontrol.NewFakeAlwaysRateLimiter())
+
+			t.addGatewayStatusConnection(remoteClusterID1, "connected")
+			t.createGateway()
+			t.awaitIsConnected(remoteClusterID1)
+
+			var first, second string
+			Eventually(recorder.Events).Should(Receive(&first))
+			Eventually(recorder.Events).Should(Receive(&second))
+			Expect([]string{first, second}).To(ConsistOf(
+				fmt.Sprintf("Normal ClusterConnected Cluster %q is connected", localClusterID),
+				fmt.Sprintf("Normal ClusterConnected Cluster %q is connected", remoteClusterID1)))
+
+			t.addGatewayStatusConnection(remoteClusterID1, "error")
+			t.updateGateway()
+			Eventually(recorder.Events).Should(Receive(Equal(
+				fmt.Sprintf("Warning ClusterDisconnected Cluster %q is disconnected", remoteClusterID1))))
+
+			t.addGatewayStatusConnection(remoteClusterID1, "connected")
+			t.updateGateway()
+			Eventually(recorder.Events).Should(Receive(Equal(
+				fmt.Sprintf("Normal ClusterConnected Cluster %q is connected", remoteClusterID1))))
+			Consistently(recorder.Events, 0.3).ShouldNot(Receive())
+		})
+
+		It("should defer the Events exceeding the rate limit and only record the latest state", func() {
+			t.controller.RecordConnectivityEvents(recorder, object, flowcontrol.NewTokenBucketRateLimiter(0.5, 2))
+
+			t.addGatewayStatusConnection(remoteClusterID1, "connected")
+			t.createGateway()
+			t.awaitIsConnected(remoteClusterID1)
+
+			Eventually(recorder.Events).Should(Receive())
+			Eventually(recorder.Events).Should(Receive())
+
+			t.addGatewayStatusConnection(remoteClusterID1, "error")
+			t.updateGateway()
+			t.awaitIsNotConnected(remoteClusterID1)
+
+			t.addGatewayStatusConnection(remoteClusterID1, "connected")
+			t.updateGateway()
+			t.awaitIsConnected(remoteClusterID1)
+
+			t.addGatewayStatusConnection(remoteClusterID1, "error")
+			t.updateGateway()
+			t.awaitIsNotConnected(remoteClusterID1)
+
+			Eventually(recorder.Events, 5).Should(Receive(Equal(
+				fmt.Sprintf("Warning ClusterDisconnected Cluster %q is disconnected", remoteClusterID1))))
+			Consistently(recorder.Events, 1).ShouldNot(Receive())
+		})
+
+		It("should not record the deferred Events of a cluster back to its recorded state", func() {
+			t.controller.RecordConnectivityEvents(recorder, object, flowcontrol.NewTokenBucketRateLimiter(0.5, 2))
+
+			t.addGatewayStatusConnection(remoteClusterID1, "connected")
+			t.createGateway()
+			t.awaitIsConnected(remoteClusterID1)
+
+			Eventually(recorder.Events).Should(Receive())
+			Eventually(recorder.Events).Should(Receive())
+
+			t.addGatewayStatusConnection(remoteClusterID1, "error")
+			t.updateGateway()
+			t.awaitIsNotConnected(remoteClusterID1)
+
+			t.addGatewayStatusConnection(remoteClusterID1, "connected")
+			t.updateGateway()
+			t.awaitIsConnected(remoteClusterID1)
+
+			Consistently(recorder.Events, 3).ShouldNot(Receive())
+		})
+	})
+
+	When("connectivity Events are written to the API server", func() {
+		It("should only make requests covered by the Event permissions", func() {
+			clientSet := fakeKubeClient.NewSimpleClientset()
+			broadcaster, recorder := gateway.NewEventRecorderFor(clientSet)
+			defer broadcaster.Shutdown()
+
+			object := &corev1.ObjectReference{APIVersion: "v1", Kind: "ConfigMap", Namespace: "submariner", Name: "lighthouse"}
+			t.controller.RecordConnectivityEvents(recorder, object, flowcontrol.NewFakeAlwaysRateLimiter())
+
+			t.addGatewayStatusConnection(remoteClusterID1, "connected")
+			t.createGateway()
+			t.awaitIsConnected(remoteClusterID1)
+
+			// The repeated transitions of the same cluster are recorded as a new Event then patches of its count.
+			for i := 0; i < 2; i++ {
+				t.addGatewayStatusConnection(remoteClusterID1, "error")
+				t.updateGateway()
+				t.awaitIsNotConnected(remoteClusterID1)
+
+				t.addGatewayStatusConnection(remoteClusterID1, "connected")
+				t.updateGateway()
+				t.awaitIsConnected(remoteClusterID1)
+			}
+
+			Eventually(func() []string {
+				verbs := []string{}
+				for _, action := range clientSet.Actions() {
+					verbs = append(verbs, action.GetVerb())
+				}
+
+				return verbs
+			}).Should(ContainElements("create", "patch"))
+
+			// The fake client doesn't record the namespace the Events are written to, which is that of their object.
+			permissions := gateway.EventPermissions(object.Namespace)
+			for _, action := range clientSet.Actions() {
+				Expect(permissions).To(ContainElement(rbac.Permission{Verb: action.GetVerb(),
+					Resource: action.GetResource().Resource, Namespace: object.Namespace}))
+
+				if create, ok := action.(k8stesting.CreateAction); ok {
+					Expect(create.GetObject().(*corev1.Event).Namespace).To(Equal(object.Namespace))
+				}
+			}
+		})
+	})
+
+	When("a Gateway's status fails to parse", func() {
+		var (
+			field    string
+			expected float64
+		)
+
+		JustBeforeEach(func() {
+			expected = testutil.ToFloat64(gateway.GatewayParseErrors.WithLabelValues(field)) + 1
+			t.createGateway()
+			t.awaitResult(gateway.OutcomeProcessed)
+		})
+
+		expectParseError := func() {
+			Expect(testutil.ToFloat64(gateway.GatewayParseErrors.WithLabelValues(field))).To(Equal(expected))
+		}
+
+		addConnectionWithout := func(key string) {
+			t.addGatewayStatusConnection(remoteClusterID1, "connected")
+			conns, _, err := unstructured.NestedSlice(t.gatewayObj.Object, "status", "connections")
+			Expect(err).To(Succeed())
+			delete(conns[0].(map[string]interface{}), key)
+			Expect(unstructured.SetNestedSlice(t.gatewayObj.Object, conns, "status", "connections")).To(Succeed())
+		}
+
+		When("the status is missing", func() {
+			BeforeEach(func() {
+				field = "status"
+				unstructured.RemoveNestedField(t.gatewayObj.Object, "status")
+			})
+
+			It("should count the parse error", expectParseError)
+		})
+
+		When("the local endpoint's cluster ID is missing", func() {
+			BeforeEach(func() {
+				field = "localEndpoint.cluster_id"
+				Expect(unstructured.SetNestedField(t.gatewayObj.Object, "passive", "status", "haStatus")).To(Succeed())
+				unstructured.RemoveNestedField(t.gatewayObj.Object, "status", "localEndpoint")
+			})
+
+			It("should count the parse error", expectParseError)
+		})
+
+		When("the HA status is missing", func() {
+			BeforeEach(func() {
+				field = "haStatus"
+				unstructured.RemoveNestedField(t.gatewayObj.Object, "status", "haStatus")
+			})
+
+			It("should count the parse error", expectParseError)
+		})
+
+		When("the connections aren't a list", func() {
+			BeforeEach(func() {
+				field = "connections"
+				Expect(unstructured.SetNestedField(t.gatewayObj.Object, "none", "status", "connections")).To(Succeed())
+			})
+
+			It("should count the parse error", expectParseError)
+		})
+
+		When("a connection's status is missing", func() {
+			BeforeEach(func() {
+				field = "connections.status"
+				addConnectionWithout("status")
+			})
+
+			It("should count the parse error", expectParseError)
+		})
+
+		When("a connection's cluster ID is missing", func() {
+			BeforeEach(func() {
+				field = "connections.endpoint.cluster_id"
+				addConnectionWithout("endpoint")
+			})
+
+			It("should count the parse error", expectParseError)
+		})
+
+		When("a connection's backend isn't a string", func() {
+			BeforeEach(func() {
+				field = "connections.endpoint.backend"
+				t.addGatewayStatusConnection(remoteClusterID1, "connected")
+				t.setGatewayConnectionEndpointField(0, int64(1), "backend")
+			})
+
+			It("should count the parse error", expectParseError)
+		})
+	})
+
+	When("an active Gateway reports the cable drivers of its connections", func() {
+		BeforeEach(func() {
+			t.addGatewayStatusConnection(remoteClusterID1, "connected")
+			t.setGatewayConnectionEndpointField(0, "libreswan", "backend")
+			t.setGatewayConnectionEndpointField(0, "submariner-cable-cluster1", "cable_name")
+			t.addGatewayStatusConnection(remoteClusterID2, "error")
+			t.setGatewayConnectionEndpointField(1, "wireguard", "backend")
+			Expect(unstructured.SetNestedField(t.gatewayObj.Object, "vxlan", "status", "localEndpoint", "backend")).To(Succeed())
+		})
+
+		JustBeforeEach(func() {
+			t.createGateway()
+			t.awaitResult(gateway.OutcomeProcessed)
+		})
+
+		It("should expose the backend of each connection, whatever its status", func() {
+			connection, found := t.controller.GetConnection(remoteClusterID1)
+			Expect(found).To(BeTrue())
+			Expect(connection).To(Equal(gateway.Connection{
+				ClusterID: remoteClusterID1,
+				Status:    "connected",
+				Backend:   "libreswan",
+				CableName: "submariner-cable-cluster1",
+			}))
+
+			connection, found = t.controller.GetConnection(remoteClusterID2)
+			Expect(found).To(BeTrue())
+			Expect(connection).To(Equal(gateway.Connection{
+				ClusterID: remoteClusterID2,
+				Status:    "error",
+				Backend:   "wireguard",
+			}))
+
+			connection, found = t.controller.GetConnection(localClusterID)
+			Expect(found).To(BeTrue())
+			Expect(connection.Backend).To(Equal("vxlan"))
+
+			_, found = t.controller.GetConnection("unknown")
+			Expect(found).To(BeFalse())
+		})
+
+		It("should set the backend metric of each connection", func() {
+			Expect(testutil.ToFloat64(gateway.GatewayConnectionBackend.WithLabelValues(remoteClusterID1, "libreswan"))).To(
+				Equal(float64(1)))
+			Expect(testutil.ToFloat64(gateway.GatewayConnectionBackend.WithLabelValues(remoteClusterID2, "wireguard"))).To(
+				Equal(float64(1)))
+		})
+
+		When("the backend of a connection changes", func() {
+			JustBeforeEach(func() {
+				t.setGatewayConnectionEndpointField(0, "wireguard", "backend")
+				t.updateGateway()
+				t.awaitResult(gateway.OutcomeProcessed)
+			})
+
+			It("should expose the new backend and remove the metric of the previous one", func() {
+				connection, _ := t.controller.GetConnection(remoteClusterID1)
+				Expect(connection.Backend).To(Equal("wireguard"))
+
+				Expect(testutil.ToFloat64(gateway.GatewayConnectionBackend.WithLabelValues(remoteClusterID1, "wireguard"))).To(
+					Equal(float64(1)))
+				Expect(gateway.GatewayConnectionBackend.DeleteLabelValues(remoteClusterID1, "libreswan")).To(BeFalse())
+			})
+		})
+
+		When("a connection is no longer reported", func() {
+			JustBeforeEach(func() {
+				conns, _, err := unstructured.NestedSlice(t.gatewayObj.Object, "status", "connections")
+				Expect(err).To(Succeed())
+				Expect(unstructured.SetNestedSlice(t.gatewayObj.Object, conns[:1], "status", "connections")).To(Succeed())
+				t.updateGateway()
+				t.awaitResult(gateway.OutcomeProcessed)
+			})
+
+			It("should prune the connection and its backend metric", func() {
+				_, found := t.controller.GetConnection(remoteClusterID2)
+				Expect(found).To(BeFalse())
+				Expect(gateway.GatewayConnectionBackend.DeleteLabelValues(remoteClusterID2, "wireguard")).To(BeFalse())
+
+				_, found = t.controller.GetConnection(remoteClusterID1)
+				Expect(found).To(BeTrue())
+			})
+		})
+
+		When("the Gateway is deleted", func() {
+			JustBeforeEach(func() {
+				t.deleteGateway()
+				t.awaitResult(gateway.OutcomeDeleted)
+			})
+
+			It("should prune the connections it reported and their backend metrics", func() {
+				for _, clusterID := range []string{localClusterID, remoteClusterID1, remoteClusterID2} {
+					_, found := t.controller.GetConnection(clusterID)
+					Expect(found).To(BeFalse())
+				}
+
+				Expect(gateway.GatewayConnectionBackend.DeleteLabelValues(remoteClusterID1, "libreswan")).To(BeFalse())
+				Expect(gateway.GatewayConnectionBackend.DeleteLabelValues(remoteClusterID2, "wireguard")).To(BeFalse())
+			})
+		})
+	})
+
+	When("an active Gateway reports the round-trip times of its connections", func() {
+		var (
+			rtt    string
+			bounds []time.Duration
+		)
+
+		BeforeEach(func() {
+			rtt = "1.5ms"
+			bounds = nil
+			t.addGatewayStatusConnection(remoteClusterID1, "connected")
+		})
+
+		JustBeforeEach(func() {
+			if bounds != nil {
+				t.controller.SetLatencyRTTBounds(bounds[0], bounds[1])
+			}
+
+			t.setGatewayConnectionField(0, map[string]interface{}{"average": rtt}, "latencyRTT")
+			t.createGateway()
+			t.awaitResult(gateway.OutcomeProcessed)
+		})
+
+		expectLatencyRTT := func(expected time.Duration) {
+			connection, found := t.controller.GetConnection(remoteClusterID1)
+			Expect(found).To(BeTrue())
+			Expect(connection.LatencyRTT).To(Equal(expected))
+		}
+
+		It("should expose the round-trip time of each connection", func() {
+			expectLatencyRTT(1500 * time.Microsecond)
+		})
+
+		When("a round-trip time is negative", func() {
+			BeforeEach(func() {
+				rtt = "-3ms"
+			})
+
+			It("should treat it as unknown", func() {
+				expectLatencyRTT(0)
+			})
+		})
+
+		When("a round-trip time is zero", func() {
+			BeforeEach(func() {
+				rtt = "0s"
+			})
+
+			It("should treat it as unknown", func() {
+				expectLatencyRTT(0)
+			})
+		})
+
+		When("a round-trip time is above the default maximum", func() {
+			BeforeEach(func() {
+				rtt = "1h"
+			})
+
+			It("should treat it as unknown", func() {
+				expectLatencyRTT(0)
+			})
+		})
+
+		When("a round-trip time can't be parsed", func() {
+			BeforeEach(func() {
+				rtt = "fast"
+			})
+
+			It("should treat it as unknown", func() {
+				expectLatencyRTT(0)
+			})
+		})
+
+		When("bounds are configured", func() {
+			BeforeEach(func() {
+				bounds = []time.Duration{time.Millisecond, 100 * time.Millisecond}
+			})
+
+			When("a round-trip time is below the minimum", func() {
+				BeforeEach(func() {
+					rtt = "200us"
+				})
+
+				It("should clamp it to the minimum", func() {
+					expectLatencyRTT(time.Millisecond)
+				})
+			})
+
+			When("a round-trip time is above the maximum", func() {
+				BeforeEach(func() {
+					rtt = "500ms"
+				})
+
+				It("should treat it as unknown", func() {
+					expectLatencyRTT(0)
+				})
+			})
+
+			When("the bounds are updated", func() {
+				BeforeEach(func() {
+					rtt = "500ms"
+				})
+
+				It("should process the Gateway again with the new bounds", func() {
+					t.controller.SetLatencyRTTBounds(0, time.Second)
+					t.awaitResult(gateway.OutcomeProcessed)
+					expectLatencyRTT(500 * time.Millisecond)
+				})
+			})
+		})
+	})
+
+	When("connections are sorted by round-trip time", func() {
+		It("should sort the connections whose round-trip time is unknown last", func() {
+			connections := []gateway.Connection{
+				{ClusterID: "unknown"},
+				{ClusterID: "slow", LatencyRTT: 50 * time.Millisecond},
+				{ClusterID: "fast", LatencyRTT: time.Millisecond},
+			}
+
+			sort.SliceStable(connections, func(i, j int) bool {
+				return connections[i].LatencyLess(connections[j])
+			})
+
+			Expect([]string{connections[0].ClusterID, connections[1].ClusterID, connections[2].ClusterID}).To(
+				Equal([]string{"fast", "slow", "unknown"}))
+		})
+	})
+
+	When("GetWithGeneration is called", func() {
+		It("should return the connected clusters with a generation that changes only when they're updated", func() {
+			t.addGatewayStatusConnection(remoteClusterID1, "connected")
+			t.createGateway()
+			t.awaitResult(gateway.OutcomeProcessed)
+
+			connected, generation := t.controller.GetWithGeneration()
+			Expect(connected).To(Equal(map[string]bool{localClusterID: true, remoteClusterID1: true}))
+
+			t.addGatewayStatusConnection(remoteClusterID2, "connecting")
+			t.updateGateway()
+			t.awaitResult(gateway.OutcomeProcessed)
+
+			_, unchanged := t.controller.GetWithGeneration()
+			Expect(unchanged).To(Equal(generation))
+
+			t.addGatewayStatusConnection(remoteClusterID1, "error")
+			t.updateGateway()
+			t.awaitResult(gateway.OutcomeProcessed)
+
+			connected, updated := t.controller.GetWithGeneration()
+			Expect(connected).To(Equal(map[string]bool{localClusterID: true}))
+			Expect(updated).To(BeNumerically(">", generation))
+
+			t.controller.ForceConnected(remoteClusterID2)
+
+			connected, forced := t.controller.GetWithGeneration()
+			Expect(connected).To(Equal(map[string]bool{localClusterID: true, remoteClusterID2: true}))
+			Expect(forced).To(BeNumerically(">", updated))
+
+			t.controller.ClearForceConnected()
+		})
+	})
+
+	When("the Gateways are requested from the debug endpoint", func() {
+		It("should return the stored Gateways, filtered by name if requested", func() {
+			t.addGatewayStatusConnection(remoteClusterID1, "connected")
+			t.createGateway()
+			t.awaitResult(gateway.OutcomeProcessed)
+
+			other := newGateway()
+			other.SetName("other-gateway")
+			_, err := t.gatewayClient.Create(other, metav1.CreateOptions{})
+			Expect(err).To(Succeed())
+			Eventually(t.results, 5).Should(Receive(Equal(gateway.ReconcileResult{Key: other.GetName(),
+				Outcome: gateway.OutcomeProcessed})))
+
+			obj, err := t.gatewayClient.Get(t.gatewayObj.GetName(), metav1.GetOptions{})
+			Expect(err).To(Succeed())
+
+			Expect(t.getDebugGateways("?name=" + t.gatewayObj.GetName())).To(Equal([]map[string]interface{}{obj.Object}))
+			Expect(t.getDebugGateways("")).To(HaveLen(2))
+			Expect(t.getDebugGateways("?name=missing")).To(BeEmpty())
+		})
+	})
+
+	When("the cluster status is recomputed after drifting from the Gateways", func() {
+		It("should rebuild it from the stored Gateways", func() {
+			t.addGatewayStatusConnection(remoteClusterID1, "connected")
+			t.addGatewayStatusConnection(remoteClusterID2, "error")
+			t.createGateway()
+			t.awaitIsConnected(remoteClusterID1)
+
+			changes := make(chan string, 10)
+			t.controller.OnConnectivityChange(func(clusterID string, connected bool) {
+				changes <- fmt.Sprintf("%s=%t", clusterID, connected)
+			})
+
+			gateway.SetClusterStatusMap(t.controller, map[string]bool{remoteClusterID2: true})
+			Expect(t.controller.IsConnected(remoteClusterID1)).To(BeFalse())
+			Expect(t.controller.IsConnected(remoteClusterID2)).To(BeTrue())
+
+			_, generation := t.controller.GetWithGeneration()
+
+			Expect(t.recomputeFromDebugEndpoint()).To(Equal([]string{localClusterID, remoteClusterID1}))
+			Expect(t.controller.IsConnected(localClusterID)).To(BeTrue())
+			Expect(t.controller.IsConnected(remoteClusterID1)).To(BeTrue())
+			Expect(t.controller.IsConnected(remoteClusterID2)).To(BeFalse())
+
+			_, recomputed := t.controller.GetWithGeneration()
+			Expect(recomputed).To(BeNumerically(">", generation))
+
+			var notified []string
+			for i := 0; i < 3; i++ {
+				var change string
+				Eventually(changes).Should(Receive(&change))
+				notified = append(notified, change)
+			}
+
+			Expect(notified).To(ConsistOf(localClusterID+"=true", remoteClusterID1+"=true", remoteClusterID2+"=false"))
+		})
+
+		It("should only accept a POST", func() {
+			recorder := httptest.NewRecorder()
+			t.controller.DebugHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, gateway.DebugRecomputePath, nil))
+			Expect(recorder.Code).To(Equal(http.StatusMethodNotAllowed))
+		})
+	})
+
 	When("IsConnected is called for a non-existent cluster ID", func() {
 		It("should return false", func() {
 			Expect(t.controller.IsConnected(remoteClusterID1)).To(BeFalse())
@@ -146,6 +978,12 @@ var _ = Describe("Gateway controller", func() {
 				t.awaitIsConnected(remoteClusterID1)
 			})
 		})
+
+		When("HasSynced is called", func() {
+			It("should return true", func() {
+				Expect(t.controller.HasSynced()).To(BeTrue())
+			})
+		})
 	})
 })
 
@@ -155,6 +993,7 @@ type testDriver struct {
 	gatewayClient  dynamic.ResourceInterface
 	gatewayReactor *fake.FailingReactor
 	gatewayObj     *unstructured.Unstructured
+	results        chan gateway.ReconcileResult
 }
 
 func newTestDiver() *testDriver {
@@ -163,14 +1002,11 @@ func newTestDiver() *testDriver {
 	BeforeEach(func() {
 		t.dynClient = fakeClient.NewSimpleDynamicClient(runtime.NewScheme())
 
-		t.gatewayClient = t.dynClient.Resource(schema.GroupVersionResource{
-			Group:    "submariner.io",
-			Version:  "v1",
-			Resource: "gateways",
-		}).Namespace(corev1.NamespaceAll)
+		t.gatewayClient = t.dynClient.Resource(gatewayGVR).Namespace(corev1.NamespaceAll)
 
 		t.gatewayReactor = fake.NewFailingReactorForResource(&t.dynClient.Fake, "gateways")
 		t.gatewayObj = newGateway()
+		t.results = make(chan gateway.ReconcileResult, 100)
 	})
 
 	JustBeforeEach(func() {
@@ -179,6 +1015,7 @@ func newTestDiver() *testDriver {
 			return t.dynClient, nil
 		}
 
+		gateway.SetResultsChannel(t.controller, t.results)
 		Expect(t.controller.Start(&rest.Config{})).To(Succeed())
 	})
 
@@ -201,6 +1038,50 @@ func (t *testDriver) awaitIsNotConnected(clusterID string) {
 	}, 5).Should(BeFalse())
 }
 
+func (t *testDriver) awaitResult(outcome string) {
+	key, err := cache.MetaNamespaceKeyFunc(t.gatewayObj)
+	Expect(err).To(Succeed())
+	Eventually(t.results, 5).Should(Receive(Equal(gateway.ReconcileResult{Key: key, Outcome: outcome})))
+}
+
+func (t *testDriver) getDebugGateways(query string) []map[string]interface{} {
+	recorder := httptest.NewRecorder()
+	t.controller.DebugHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, gateway.DebugGatewaysPath+query, nil))
+	Expect(recorder.Code).To(Equal(http.StatusOK))
+
+	gateways := []map[string]interface{}{}
+	Expect(json.Unmarshal(recorder.Body.Bytes(), &gateways)).To(Succeed())
+
+	return gateways
+}
+
+func (t *testDriver) recomputeFromDebugEndpoint() []string {
+	recorder := httptest.NewRecorder()
+	t.controller.DebugHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, gateway.DebugRecomputePath, nil))
+	Expect(recorder.Code).To(Equal(http.StatusOK))
+
+	connected := []string{}
+	Expect(json.Unmarshal(recorder.Body.Bytes(), &connected)).To(Succeed())
+
+	return connected
+}
+
+func (t *testDriver) awaitGatewayCounts(total, active int) {
+	Eventually(func() float64 {
+		return testutil.ToFloat64(gateway.GatewaysTotal)
+	}, 5).Should(Equal(float64(total)))
+
+	Eventually(func() float64 {
+		return testutil.ToFloat64(gateway.GatewaysActive)
+	}, 5).Should(Equal(float64(active)))
+}
+
+func (t *testDriver) awaitSplitBrain(value float64) {
+	Eventually(func() float64 {
+		return testutil.ToFloat64(gateway.GatewaySplitBrain)
+	}, 5).Should(Equal(value))
+}
+
 func (t *testDriver) localClusterIDValidationTest(localClusterID string) {
 	t.createGateway()
 	t.awaitValidLocalClusterID(localClusterID)
@@ -224,15 +1105,23 @@ func (t *testDriver) awaitValidLocalClusterID(clusterID string) {
 }
 
 func (t *testDriver) createGateway() {
-	_, err := t.gatewayClient.Create(t.gatewayObj, metav1.CreateOptions{})
+	_, err := t.gatewayClientFor(t.gatewayObj).Create(t.gatewayObj, metav1.CreateOptions{})
 	Expect(err).To(Succeed())
 }
 
 func (t *testDriver) updateGateway() {
-	_, err := t.gatewayClient.Update(t.gatewayObj, metav1.UpdateOptions{})
+	_, err := t.gatewayClientFor(t.gatewayObj).Update(t.gatewayObj, metav1.UpdateOptions{})
 	Expect(err).To(Succeed())
 }
 
+func (t *testDriver) deleteGateway() {
+	Expect(t.gatewayClientFor(t.gatewayObj).Delete(t.gatewayObj.GetName(), &metav1.DeleteOptions{})).To(Succeed())
+}
+
+func (t *testDriver) gatewayClientFor(gw *unstructured.Unstructured) dynamic.ResourceInterface {
+	return t.dynClient.Resource(gatewayGVR).Namespace(gw.GetNamespace())
+}
+
 func (t *testDriver) setGatewayLocalClusterID(clusterID string) {
 	Expect(unstructured.SetNestedField(t.gatewayObj.Object, clusterID, "status", "localEndpoint", "cluster_id")).To(Succeed())
 }
@@ -250,11 +1139,23 @@ func (t *testDriver) addGatewayStatusConnection(clusterID, status string) *unstr
 	return t.gatewayObj
 }
 
+func (t *testDriver) setGatewayConnectionEndpointField(index int, value interface{}, field string) {
+	t.setGatewayConnectionField(index, value, "endpoint", field)
+}
+
+func (t *testDriver) setGatewayConnectionField(index int, value interface{}, fields ...string) {
+	conns, _, err := unstructured.NestedSlice(t.gatewayObj.Object, "status", "connections")
+	Expect(err).To(Succeed())
+	Expect(unstructured.SetNestedField(conns[index].(map[string]interface{}), value, fields...)).To(Succeed())
+	Expect(unstructured.SetNestedSlice(t.gatewayObj.Object, conns, "status", "connections")).To(Succeed())
+}
+
 func newGateway() *unstructured.Unstructured {
 	gw := &unstructured.Unstructured{}
 	gw.SetName("test-gateway")
 	Expect(unstructured.SetNestedField(gw.Object, localClusterID, "status", "localEndpoint", "cluster_id")).To(Succeed())
 	Expect(unstructured.SetNestedField(gw.Object, "active", "status", "haStatus")).To(Succeed())
+	Expect(unstructured.SetNestedSlice(gw.Object, []interface{}{}, "status", "connections")).To(Succeed())
 
 	return gw
 }
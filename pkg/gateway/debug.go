@@ -0,0 +1,100 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// The path on which DebugHandler serves the Gateways.
+const DebugGatewaysPath = "/debug/gateways"
+
+// The path on which DebugHandler recomputes the cluster status map.
+const DebugRecomputePath = "/debug/gateways/recompute"
+
+// DebugHandler serves a GET of DebugGatewaysPath with the raw JSON of the Gateways in the controller's store, as a
+// list sorted by name then namespace, to diagnose how their status is parsed. The list is restricted to the Gateways
+// named by the "name" query parameter, if present. The Gateway status includes the endpoint IPs of the clusters, so
+// the handler should only be reachable by cluster administrators. A POST of DebugRecomputePath rebuilds the cluster
+// status map from the store, see RecomputeClusterStatus, and returns the IDs of the connected clusters.
+func (c *Controller) DebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(DebugGatewaysPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Only GET is supported", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := json.MarshalIndent(c.storedGateways(r.URL.Query().Get("name")), "", "  ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(append(body, '\n'))
+	})
+	mux.HandleFunc(DebugRecomputePath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only POST is supported", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := json.Marshal(c.RecomputeClusterStatus())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(append(body, '\n'))
+	})
+
+	return mux
+}
+
+// storedGateways returns the objects of the Gateways in the store named name, or all of them if name is empty. It's
+// empty if the Gateway resource doesn't exist.
+func (c *Controller) storedGateways(name string) []map[string]interface{} {
+	gateways := []map[string]interface{}{}
+
+	if c.store == nil {
+		return gateways
+	}
+
+	objs := c.store.List()
+	sort.Slice(objs, func(i, j int) bool {
+		gwi, gwj := objs[i].(*unstructured.Unstructured), objs[j].(*unstructured.Unstructured)
+		if gwi.GetName() != gwj.GetName() {
+			return gwi.GetName() < gwj.GetName()
+		}
+
+		return gwi.GetNamespace() < gwj.GetNamespace()
+	})
+
+	for _, obj := range objs {
+		gw := obj.(*unstructured.Unstructured)
+		if name == "" || gw.GetName() == name {
+			gateways = append(gateways, gw.Object)
+		}
+	}
+
+	return gateways
+}
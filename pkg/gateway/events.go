@@ -0,0 +1,186 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package gateway
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/submariner-io/admiral/pkg/log"
+	"github.com/submariner-io/lighthouse/pkg/rbac"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/klog"
+)
+
+const (
+	// The component reported as the source of the connectivity Events.
+	eventComponent = "lighthouse-coredns"
+
+	reasonClusterConnected    = "ClusterConnected"
+	reasonClusterDisconnected = "ClusterDisconnected"
+
+	// The rate of the connectivity Events beyond which they're deferred and coalesced, so a storm of transitions
+	// doesn't flood the API server: a burst of EventsBurst, then one every 5 seconds on average.
+	EventsBurst = 20
+	EventsQPS   = 0.2
+)
+
+// NewEventRecorder returns a recorder writing Events to the API server of the given config through the returned
+// broadcaster, which must be shut down once the recorder is no longer used. The broadcaster neither drops nor
+// aggregates the Events below the connectivity Events' rate, which is enforced by RecordConnectivityEvents.
+func NewEventRecorder(kubeConfig *rest.Config) (record.EventBroadcaster, record.EventRecorder, error) {
+	clientSet, err := kubernetes.NewForConfig(kubeConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating client set: %v", err)
+	}
+
+	broadcaster, recorder := newEventRecorder(clientSet)
+
+	return broadcaster, recorder, nil
+}
+
+// EventPermissions returns the permissions the recorder needs to write the Events of an object in the given namespace:
+// new Events are created, and their repeats patched to bump their count.
+func EventPermissions(namespace string) []rbac.Permission {
+	return rbac.ResourcePermissions("", "events", namespace, "create", "patch")
+}
+
+func newEventRecorder(clientSet kubernetes.Interface) (record.EventBroadcaster, record.EventRecorder) {
+	broadcaster := record.NewBroadcasterWithCorrelatorOptions(record.CorrelatorOptions{
+		BurstSize: EventsBurst,
+		QPS:       EventsQPS,
+	})
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientSet.CoreV1().Events("")})
+
+	return broadcaster, broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: eventComponent})
+}
+
+// RecordConnectivityEvents records an Event on the given object, eg a dedicated ConfigMap, whenever the Gateway status
+// reports a cluster becoming connected or disconnected, so the transitions show up in "kubectl get events". The Events
+// that the limiter doesn't accept are deferred until it does, when only the latest connectivity of each of their
+// clusters is recorded, so a storm of transitions is coalesced but the Events of a cluster always end with its current
+// state.
+func (c *Controller) RecordConnectivityEvents(recorder record.EventRecorder, object *v1.ObjectReference,
+	limiter flowcontrol.RateLimiter) {
+	events := &connectivityEvents{
+		recorder: recorder,
+		object:   object,
+		limiter:  limiter,
+		pending:  map[string]bool{},
+		recorded: map[string]bool{},
+	}
+
+	c.OnConnectivityChange(events.onChange)
+}
+
+// connectivityEvents records the connectivity Events of the clusters subject to a rate limiter.
+type connectivityEvents struct {
+	recorder record.EventRecorder
+	object   *v1.ObjectReference
+	limiter  flowcontrol.RateLimiter
+	mutex    sync.Mutex
+	// The latest connectivity of the clusters whose Events were deferred, and the last one recorded for each cluster.
+	pending  map[string]bool
+	recorded map[string]bool
+	// Whether the deferred Events are being recorded, in which case the new ones are deferred too, to keep them in order.
+	deferring bool
+}
+
+func (e *connectivityEvents) onChange(clusterID string, connected bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if !e.deferring && e.limiter.TryAccept() {
+		e.record(clusterID, connected)
+		return
+	}
+
+	klog.V(log.DEBUG).Infof("Deferring the Event for the connectivity change of cluster %q (connected %t) as it "+
+		"exceeds the rate limit", clusterID, connected)
+
+	e.pending[clusterID] = connected
+
+	if !e.deferring {
+		e.deferring = true
+
+		go e.recordDeferred()
+	}
+}
+
+// recordDeferred records the latest connectivity of the clusters whose Events were deferred, as the limiter accepts
+// them, skipping those whose connectivity is back to what was last recorded.
+func (e *connectivityEvents) recordDeferred() {
+	for e.hasPending() {
+		e.limiter.Accept()
+
+		e.mutex.Lock()
+
+		if clusterIDs := e.prunePending(); len(clusterIDs) > 0 {
+			sort.Strings(clusterIDs)
+
+			connected := e.pending[clusterIDs[0]]
+			delete(e.pending, clusterIDs[0])
+			e.record(clusterIDs[0], connected)
+		}
+
+		e.mutex.Unlock()
+	}
+}
+
+// hasPending returns whether any deferred Events are left, once pruned. Otherwise, the new Events are no longer
+// deferred.
+func (e *connectivityEvents) hasPending() bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.deferring = len(e.prunePending()) > 0
+
+	return e.deferring
+}
+
+// prunePending drops the deferred Events that wouldn't change the last recorded connectivity of their cluster, and
+// returns the clusters of those left.
+func (e *connectivityEvents) prunePending() []string {
+	clusterIDs := make([]string, 0, len(e.pending))
+
+	for clusterID, connected := range e.pending {
+		if recorded, ok := e.recorded[clusterID]; ok && recorded == connected {
+			delete(e.pending, clusterID)
+		} else {
+			clusterIDs = append(clusterIDs, clusterID)
+		}
+	}
+
+	return clusterIDs
+}
+
+func (e *connectivityEvents) record(clusterID string, connected bool) {
+	e.recorded[clusterID] = connected
+
+	if connected {
+		e.recorder.Eventf(e.object, v1.EventTypeNormal, reasonClusterConnected, "Cluster %q is connected", clusterID)
+	} else {
+		e.recorder.Eventf(e.object, v1.EventTypeWarning, reasonClusterDisconnected, "Cluster %q is disconnected",
+			clusterID)
+	}
+}
@@ -0,0 +1,50 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package gateway
+
+import (
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+)
+
+// ReconcileResult and SetResultsChannel expose the results channel to the external test package only.
+type ReconcileResult = reconcileResult
+
+const (
+	OutcomeProcessed  = outcomeProcessed
+	OutcomeRequeued   = outcomeRequeued
+	OutcomeIncomplete = outcomeIncomplete
+	OutcomeDeleted    = outcomeDeleted
+)
+
+func SetResultsChannel(c *Controller, results chan<- ReconcileResult) {
+	c.setResultsChannel(results)
+}
+
+// SetClusterStatusMap replaces the cluster status map, bypassing the Gateways, to simulate a drift.
+func SetClusterStatusMap(c *Controller, connected map[string]bool) {
+	c.clusterStatusMap.Store(connected)
+}
+
+// GatewayDeleted simulates the informer's notification of a deleted Gateway, eg one whose deletion was missed.
+func GatewayDeleted(c *Controller, obj interface{}) {
+	c.gatewayDeleted(obj)
+}
+
+// NewEventRecorderFor returns a recorder writing Events with the given client set.
+func NewEventRecorderFor(clientSet kubernetes.Interface) (record.EventBroadcaster, record.EventRecorder) {
+	return newEventRecorder(clientSet)
+}
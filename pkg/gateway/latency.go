@@ -0,0 +1,100 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package gateway
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/submariner-io/admiral/pkg/log"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/klog"
+)
+
+// DefaultMaxLatencyRTT is the highest connection round-trip time accepted unless configured otherwise. Higher
+// readings, eg reported while a tunnel is being set up, are treated as unknown.
+const DefaultMaxLatencyRTT = 10 * time.Second
+
+// latencyRTTBounds are the bounds of the accepted connection round-trip times. Readings below minRTT are raised to
+// minRTT while readings above maxRTT are rejected.
+type latencyRTTBounds struct {
+	minRTT time.Duration
+	maxRTT time.Duration
+}
+
+// SetLatencyRTTBounds sets the bounds of the connection round-trip times: readings below minRTT are clamped to minRTT,
+// while readings above maxRTT, and readings that aren't positive whatever the bounds, are treated as unknown. The
+// Gateways are processed again so the connections' latency reflects the new bounds.
+func (c *Controller) SetLatencyRTTBounds(minRTT, maxRTT time.Duration) {
+	bounds := latencyRTTBounds{minRTT: minRTT, maxRTT: maxRTT}
+	if bounds == c.latencyBounds.Load() {
+		return
+	}
+
+	klog.Infof("Connection round-trip times are clamped to %v and rejected above %v", minRTT, maxRTT)
+
+	c.latencyBounds.Store(bounds)
+	atomic.AddUint64(&c.statusesGeneration, 1)
+
+	if c.store == nil {
+		return
+	}
+
+	for _, obj := range c.store.List() {
+		c.queue.Enqueue(obj)
+	}
+}
+
+// parseLatencyRTT returns the average round-trip time of the connection within the configured bounds, or 0 if it's
+// unknown, because it isn't reported, can't be parsed or is out of range.
+func (c *Controller) parseLatencyRTT(connectionMap map[string]interface{}, clusterID string) time.Duration {
+	value, found, err := unstructured.NestedString(connectionMap, "latencyRTT", "average")
+	if !found || err != nil {
+		return 0
+	}
+
+	rtt, err := time.ParseDuration(value)
+	if err != nil {
+		klog.V(log.DEBUG).Infof("Ignoring the round-trip time %q of cluster %q that can't be parsed: %v", value, clusterID,
+			err)
+		return 0
+	}
+
+	bounds := c.latencyBounds.Load().(latencyRTTBounds)
+
+	if rtt <= 0 || rtt > bounds.maxRTT {
+		klog.V(log.DEBUG).Infof("Ignoring the round-trip time %v of cluster %q that's out of range (0, %v]", rtt, clusterID,
+			bounds.maxRTT)
+		return 0
+	}
+
+	if rtt < bounds.minRTT {
+		klog.V(log.DEBUG).Infof("Clamping the round-trip time %v of cluster %q to %v", rtt, clusterID, bounds.minRTT)
+		return bounds.minRTT
+	}
+
+	return rtt
+}
+
+// LatencyLess returns true if the connection has a lower round-trip time than the other. The connections whose
+// round-trip time is unknown are sorted last.
+func (c Connection) LatencyLess(other Connection) bool {
+	if c.LatencyRTT == 0 || other.LatencyRTT == 0 {
+		return c.LatencyRTT != 0
+	}
+
+	return c.LatencyRTT < other.LatencyRTT
+}
@@ -0,0 +1,74 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package gateway
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/submariner-io/lighthouse/pkg/constants"
+)
+
+var (
+	// GatewaysTotal is the number of Gateway objects currently observed.
+	GatewaysTotal = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: constants.MetricsNamespace,
+		Name:      "gateways_total",
+		Help:      "Number of Gateway objects observed.",
+	})
+
+	// GatewaysActive is the number of observed Gateway objects whose HA status is active.
+	GatewaysActive = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: constants.MetricsNamespace,
+		Name:      "gateways_active",
+		Help:      "Number of observed Gateway objects with an active HA status.",
+	})
+
+	// GatewaySplitBrain is set to 1 while more than one Gateway reports an active HA status, which usually indicates an
+	// HA failure.
+	GatewaySplitBrain = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: constants.MetricsNamespace,
+		Name:      "gateway_split_brain",
+		Help:      "Whether more than one observed Gateway object has an active HA status.",
+	})
+
+	// ForcedConnections is set to 1 for each cluster whose connection status is manually forced to connected.
+	ForcedConnections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: constants.MetricsNamespace,
+		Name:      "gateway_forced_connections",
+		Help:      "Clusters manually forced to be reported as connected, regardless of the Gateway status.",
+	}, []string{"cluster"})
+
+	// GatewayParseErrors counts the failures to parse a field of a Gateway's status, which usually indicate a change of
+	// the Gateway schema.
+	GatewayParseErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: constants.MetricsNamespace,
+		Name:      "gateway_parse_errors_total",
+		Help:      "Failures to parse a field of a Gateway's status, by field.",
+	}, []string{"field"})
+
+	// GatewayConnectionBackend is set to 1 for the cable driver of each cluster's connection, eg libreswan, wireguard
+	// or vxlan, as reported by the active Gateway.
+	GatewayConnectionBackend = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: constants.MetricsNamespace,
+		Name:      "gateway_connection_backend",
+		Help:      "The cable driver of each cluster's connection reported by the active Gateway.",
+	}, []string{"cluster_id", "backend"})
+)
+
+// Collectors returns the metrics maintained by the Gateway controller.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{GatewaysTotal, GatewaysActive, GatewaySplitBrain, ForcedConnections,
+		GatewayParseErrors, GatewayConnectionBackend}
+}
@@ -0,0 +1,166 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ipam
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/big"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrPoolExhausted is returned by Allocate when all the IPs in the pool are claimed by other keys.
+var ErrPoolExhausted = errors.New("the IP pool is exhausted")
+
+// Claim is an IP claimed for a key, eg the clusterset VIP recorded on a ServiceImport exported at the given time.
+type Claim struct {
+	Key  string
+	IP   string
+	Time time.Time
+}
+
+// Precedes returns whether the claim takes precedence over the other claim of the same IP, ie if it's older, with ties
+// broken by key.
+func (c *Claim) Precedes(other *Claim) bool {
+	return c.Time.Before(other.Time) || (c.Time.Equal(other.Time) && c.Key < other.Key)
+}
+
+// Pool allocates IPs from an IPv4 or IPv6 CIDR. The network and broadcast addresses, or for IPv6 the first and last
+// addresses, are never allocated. At most 2^32-1 IPs are allocated from a larger IPv6 CIDR. The pool is stateless: the
+// IP of a key is probed from a hash of the key, skipping the IPs claimed by other keys, so pools of the same CIDR
+// allocate the same IPs given the same claims without coordinating.
+type Pool struct {
+	network *net.IPNet
+	ipv6    bool
+	first   *big.Int
+	size    uint32
+}
+
+func NewPool(cidr string) (*Pool, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid IP pool CIDR %q", cidr)
+	}
+
+	ones, bits := network.Mask.Size()
+	if bits-ones < 2 {
+		return nil, fmt.Errorf("the IP pool CIDR %q has no allocatable IPs", cidr)
+	}
+
+	size := uint32(math.MaxUint32)
+	if bits-ones <= 32 {
+		size = uint32(uint64(1)<<uint(bits-ones) - 2)
+	}
+
+	return &Pool{
+		network: network,
+		ipv6:    network.IP.To4() == nil,
+		first:   new(big.Int).Add(ipToInt(network.IP), big.NewInt(1)),
+		size:    size,
+	}, nil
+}
+
+// IsIPv6 returns true if the pool allocates IPv6 addresses.
+func (p *Pool) IsIPv6() bool {
+	return p.ipv6
+}
+
+// Allocate returns the IP of the given key. An IP claimed by several keys belongs to the oldest claim, with ties broken
+// by key. The key keeps the IP it claims if it belongs to it, otherwise the IPs are probed from the hash of the key,
+// skipping those belonging to other keys.
+func (p *Pool) Allocate(key string, claims []Claim) (string, error) {
+	owners := map[uint32]*Claim{}
+
+	for i := range claims {
+		claim := &claims[i]
+
+		offset, ok := p.offsetOf(claim.IP)
+		if !ok {
+			continue
+		}
+
+		if owner, ok := owners[offset]; !ok || claim.Precedes(owner) {
+			owners[offset] = claim
+		}
+	}
+
+	used := map[uint32]bool{}
+
+	for offset, owner := range owners {
+		if owner.Key == key {
+			return p.ipAt(offset), nil
+		}
+
+		used[offset] = true
+	}
+
+	if uint32(len(used)) >= p.size {
+		return "", ErrPoolExhausted
+	}
+
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(key))
+
+	offset := uint32(hash.Sum64() % uint64(p.size))
+	for used[offset] {
+		offset = (offset + 1) % p.size
+	}
+
+	return p.ipAt(offset), nil
+}
+
+func (p *Pool) ipAt(offset uint32) string {
+	return intToIP(new(big.Int).Add(p.first, big.NewInt(int64(offset))), p.ipv6).String()
+}
+
+func (p *Pool) offsetOf(ip string) (uint32, bool) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil || (parsed.To4() == nil) != p.ipv6 || !p.network.Contains(parsed) {
+		return 0, false
+	}
+
+	offset := new(big.Int).Sub(ipToInt(parsed), p.first)
+	if offset.Sign() < 0 || !offset.IsUint64() || offset.Uint64() >= uint64(p.size) {
+		return 0, false
+	}
+
+	return uint32(offset.Uint64()), true
+}
+
+func ipToInt(ip net.IP) *big.Int {
+	if ip4 := ip.To4(); ip4 != nil {
+		return new(big.Int).SetBytes(ip4)
+	}
+
+	return new(big.Int).SetBytes(ip.To16())
+}
+
+func intToIP(i *big.Int, ipv6 bool) net.IP {
+	length := net.IPv4len
+	if ipv6 {
+		length = net.IPv6len
+	}
+
+	b := i.Bytes()
+	ip := make(net.IP, length)
+	copy(ip[length-len(b):], b)
+
+	return ip
+}
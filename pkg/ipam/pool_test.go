@@ -0,0 +1,158 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ipam_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/submariner-io/lighthouse/pkg/ipam"
+)
+
+const cidr = "243.0.0.0/30"
+
+var _ = Describe("IP pool", func() {
+	var pool *ipam.Pool
+
+	BeforeEach(func() {
+		var err error
+
+		pool, err = ipam.NewPool(cidr)
+		Expect(err).To(Succeed())
+	})
+
+	allocate := func(key string, claims ...ipam.Claim) string {
+		ip, err := pool.Allocate(key, claims)
+		Expect(err).To(Succeed())
+
+		return ip
+	}
+
+	When("an IP is allocated without claims", func() {
+		It("should derive the same IP for the same key, excluding the network and broadcast addresses", func() {
+			ip := allocate("ns1.svc1")
+			Expect(ip).To(BeElementOf("243.0.0.1", "243.0.0.2"))
+
+			other, err := ipam.NewPool(cidr)
+			Expect(err).To(Succeed())
+			Expect(other.Allocate("ns1.svc1", nil)).To(Equal(ip))
+		})
+	})
+
+	When("the IP of the key is claimed by another key", func() {
+		It("should allocate another IP", func() {
+			ip := allocate("ns1.svc1")
+			Expect(allocate("ns1.svc1", ipam.Claim{Key: "ns1.svc2", IP: ip})).ToNot(Equal(ip))
+		})
+	})
+
+	When("the key claims an IP", func() {
+		It("should keep it", func() {
+			ip := allocate("ns1.svc1")
+			other := "243.0.0.1"
+			if ip == other {
+				other = "243.0.0.2"
+			}
+
+			Expect(allocate("ns1.svc1", ipam.Claim{Key: "ns1.svc1", IP: other})).To(Equal(other))
+		})
+
+		It("should ignore the claim if the IP isn't in the pool", func() {
+			ip := allocate("ns1.svc1")
+			Expect(allocate("ns1.svc1", ipam.Claim{Key: "ns1.svc1", IP: "243.0.0.3"})).To(Equal(ip))
+			Expect(allocate("ns1.svc1", ipam.Claim{Key: "ns1.svc1", IP: "fd00:243::1"})).To(Equal(ip))
+		})
+	})
+
+	When("several keys claim the same IP", func() {
+		It("should give it to the oldest claim", func() {
+			now := time.Now()
+			claims := []ipam.Claim{
+				{Key: "ns1.svc1", IP: "243.0.0.1", Time: now},
+				{Key: "ns1.svc2", IP: "243.0.0.1", Time: now.Add(-time.Minute)},
+			}
+
+			Expect(allocate("ns1.svc2", claims...)).To(Equal("243.0.0.1"))
+			Expect(allocate("ns1.svc1", claims...)).To(Equal("243.0.0.2"))
+		})
+
+		It("should break ties by key", func() {
+			claims := []ipam.Claim{{Key: "ns1.svc2", IP: "243.0.0.1"}, {Key: "ns1.svc1", IP: "243.0.0.1"}}
+
+			Expect(allocate("ns1.svc1", claims...)).To(Equal("243.0.0.1"))
+			Expect(allocate("ns1.svc2", claims...)).To(Equal("243.0.0.2"))
+		})
+	})
+
+	When("the pool is exhausted", func() {
+		It("should return ErrPoolExhausted", func() {
+			_, err := pool.Allocate("ns1.svc3", []ipam.Claim{
+				{Key: "ns1.svc1", IP: "243.0.0.1"},
+				{Key: "ns1.svc2", IP: "243.0.0.2"},
+			})
+			Expect(err).To(Equal(ipam.ErrPoolExhausted))
+		})
+	})
+
+	When("the CIDR is IPv6", func() {
+		BeforeEach(func() {
+			var err error
+
+			pool, err = ipam.NewPool("fd00:243::/126")
+			Expect(err).To(Succeed())
+		})
+
+		It("should allocate IPv6 addresses excluding the first and last ones", func() {
+			Expect(pool.IsIPv6()).To(BeTrue())
+
+			ip := allocate("ns1.svc1")
+			Expect(ip).To(BeElementOf("fd00:243::1", "fd00:243::2"))
+			Expect(allocate("ns1.svc2", ipam.Claim{Key: "ns1.svc1", IP: ip})).ToNot(Equal(ip))
+
+			_, err := pool.Allocate("ns1.svc3", []ipam.Claim{
+				{Key: "ns1.svc1", IP: "fd00:243::1"},
+				{Key: "ns1.svc2", IP: "fd00:243::2"},
+			})
+			Expect(err).To(Equal(ipam.ErrPoolExhausted))
+		})
+
+		It("should ignore the IPv4 claims", func() {
+			Expect(allocate("ns1.svc1", ipam.Claim{Key: "ns1.svc1", IP: "243.0.0.1"})).To(HavePrefix("fd00:243::"))
+		})
+	})
+
+	When("the CIDR is a large IPv6 CIDR", func() {
+		It("should allocate from it", func() {
+			p, err := ipam.NewPool("fd00:243::/64")
+			Expect(err).To(Succeed())
+
+			ip, err := p.Allocate("ns1.svc1", nil)
+			Expect(err).To(Succeed())
+			Expect(ip).To(HavePrefix("fd00:243::"))
+		})
+	})
+
+	When("the CIDR is invalid", func() {
+		It("should return an error", func() {
+			_, err := ipam.NewPool("243.0.0.0")
+			Expect(err).To(HaveOccurred())
+
+			_, err = ipam.NewPool("243.0.0.0/31")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
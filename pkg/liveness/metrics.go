@@ -0,0 +1,53 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package liveness
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/submariner-io/lighthouse/pkg/constants"
+)
+
+// The names of the controllers recording their reconciles.
+const (
+	ControllerGateway       = "gateway"
+	ControllerServiceImport = "service-import"
+	ControllerEndpointSlice = "endpoint-slice"
+)
+
+// ResyncPeriod is how often the controllers' informers redeliver the objects they cache, which counts as a reconcile,
+// so the timestamp of a controller holding any object keeps advancing while nothing changes, as long as it's still
+// processing its informer's events.
+var ResyncPeriod = 5 * time.Minute
+
+// LastReconcileTimestamp is the time of the last successful reconcile of each controller, so a controller that stopped
+// progressing can be detected while the pod is still up and ready.
+var LastReconcileTimestamp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: constants.MetricsNamespace,
+	Name:      "last_reconcile_timestamp_seconds",
+	Help:      "Unix time of the last successful reconcile, by controller.",
+}, []string{"controller"})
+
+// Reconciled records a successful reconcile by the given controller.
+func Reconciled(controller string) {
+	LastReconcileTimestamp.WithLabelValues(controller).SetToCurrentTime()
+}
+
+// Collectors returns the liveness metrics of the controllers.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{LastReconcileTimestamp}
+}
@@ -0,0 +1,139 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package rbac
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog"
+)
+
+type NewClientsetFunc func(kubeConfig *rest.Config) (kubernetes.Interface, error)
+
+// Indirection hook for unit tests to supply fake client sets
+var NewClientset NewClientsetFunc
+
+// NewClientsetForConfig returns the client set the permissions are reviewed with.
+func NewClientsetForConfig(kubeConfig *rest.Config) (kubernetes.Interface, error) {
+	if NewClientset != nil {
+		return NewClientset(kubeConfig)
+	}
+
+	return kubernetes.NewForConfig(kubeConfig)
+}
+
+// Permission is a verb on a resource, or on one of its subresources if given, that a component needs, cluster-wide
+// unless a namespace is given.
+type Permission struct {
+	Verb        string
+	Group       string
+	Resource    string
+	Subresource string
+	Namespace   string
+}
+
+func (p Permission) String() string {
+	resource := p.Resource
+	if p.Group != "" {
+		resource += "." + p.Group
+	}
+
+	if p.Subresource != "" {
+		resource += "/" + p.Subresource
+	}
+
+	if p.Namespace != "" {
+		return fmt.Sprintf("%s %s in namespace %q", p.Verb, resource, p.Namespace)
+	}
+
+	return p.Verb + " " + resource
+}
+
+// ResourcePermissions returns the permissions for each of the given verbs on a resource.
+func ResourcePermissions(group, resource, namespace string, verbs ...string) []Permission {
+	permissions := make([]Permission, len(verbs))
+	for i, verb := range verbs {
+		permissions[i] = Permission{Verb: verb, Group: group, Resource: resource, Namespace: namespace}
+	}
+
+	return permissions
+}
+
+// Check reviews each permission with a SelfSubjectAccessReview and returns those that are denied, in order.
+func Check(client kubernetes.Interface, permissions []Permission) ([]Permission, error) {
+	missing := []Permission{}
+
+	for _, permission := range permissions {
+		review, err := client.AuthorizationV1().SelfSubjectAccessReviews().Create(&authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace:   permission.Namespace,
+					Verb:        permission.Verb,
+					Group:       permission.Group,
+					Resource:    permission.Resource,
+					Subresource: permission.Subresource,
+				},
+			},
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reviewing the permission to %s", permission)
+		}
+
+		if !review.Status.Allowed {
+			missing = append(missing, permission)
+		}
+	}
+
+	return missing, nil
+}
+
+// Verify checks that the component has the given permissions and logs a summary of those it's missing, which would
+// otherwise make it fail in delayed, confusing ways, eg informers that never sync. If failFast is set, the missing
+// permissions are returned as an error. If they can't be reviewed, eg because the API server is unreachable, a
+// warning is logged rather than failing.
+func Verify(client kubernetes.Interface, component string, permissions []Permission, failFast bool) error {
+	missing, err := Check(client, permissions)
+	if err != nil {
+		klog.Warningf("Unable to verify the permissions of %s: %v", component, err)
+		return nil
+	}
+
+	if len(missing) == 0 {
+		klog.Infof("Verified the %d permissions %s needs", len(permissions), component)
+		return nil
+	}
+
+	names := make([]string, len(missing))
+	for i := range missing {
+		names[i] = missing[i].String()
+	}
+
+	summary := fmt.Sprintf("Missing %d of the %d permissions %s needs, check its RBAC Roles and bindings: %s",
+		len(missing), len(permissions), component, strings.Join(names, ", "))
+
+	if failFast {
+		return errors.New(summary)
+	}
+
+	klog.Errorf("%s", summary)
+
+	return nil
+}
@@ -0,0 +1,131 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package rbac_test
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/submariner-io/lighthouse/pkg/rbac"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakeKubeClient "k8s.io/client-go/kubernetes/fake"
+	testing "k8s.io/client-go/testing"
+)
+
+var _ = Describe("Permission check", func() {
+	var (
+		client      *fakeKubeClient.Clientset
+		denied      map[rbac.Permission]bool
+		reviewErr   error
+		permissions []rbac.Permission
+	)
+
+	BeforeEach(func() {
+		client = fakeKubeClient.NewSimpleClientset()
+		denied = map[rbac.Permission]bool{}
+		reviewErr = nil
+		permissions = append(rbac.ResourcePermissions("multicluster.x-k8s.io", "serviceimports", "", "list", "watch"),
+			rbac.ResourcePermissions("", "configmaps", "submariner-operator", "get", "update")...)
+		permissions = append(permissions, rbac.Permission{Verb: "update", Group: "multicluster.x-k8s.io",
+			Resource: "serviceexports", Subresource: "status"})
+
+		client.PrependReactor("create", "selfsubjectaccessreviews",
+			func(action testing.Action) (bool, runtime.Object, error) {
+				if reviewErr != nil {
+					return true, &authorizationv1.SelfSubjectAccessReview{}, reviewErr
+				}
+
+				review := action.(testing.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+				attributes := review.Spec.ResourceAttributes
+				review.Status.Allowed = !denied[rbac.Permission{Verb: attributes.Verb, Group: attributes.Group,
+					Resource: attributes.Resource, Subresource: attributes.Subresource, Namespace: attributes.Namespace}]
+
+				return true, review, nil
+			})
+	})
+
+	When("all the permissions are granted", func() {
+		It("should report no missing permissions", func() {
+			missing, err := rbac.Check(client, permissions)
+			Expect(err).To(Succeed())
+			Expect(missing).To(BeEmpty())
+			Expect(rbac.Verify(client, "the test", permissions, true)).To(Succeed())
+		})
+	})
+
+	When("some permissions are denied", func() {
+		BeforeEach(func() {
+			denied[permissions[1]] = true
+			denied[permissions[2]] = true
+		})
+
+		It("should report them", func() {
+			missing, err := rbac.Check(client, permissions)
+			Expect(err).To(Succeed())
+			Expect(missing).To(Equal([]rbac.Permission{permissions[1], permissions[2]}))
+		})
+
+		Context("and fail fast is set", func() {
+			It("should return an error listing them", func() {
+				err := rbac.Verify(client, "the test", permissions, true)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("Missing 2 of the 5 permissions"))
+				Expect(err.Error()).To(ContainSubstring("watch serviceimports.multicluster.x-k8s.io"))
+				Expect(err.Error()).To(ContainSubstring(`get configmaps in namespace "submariner-operator"`))
+				Expect(err.Error()).ToNot(ContainSubstring("list serviceimports"))
+			})
+		})
+
+		Context("and fail fast isn't set", func() {
+			It("should not return an error", func() {
+				Expect(rbac.Verify(client, "the test", permissions, false)).To(Succeed())
+			})
+		})
+	})
+
+	When("a subresource permission is denied", func() {
+		BeforeEach(func() {
+			denied[permissions[4]] = true
+		})
+
+		It("should report it with its subresource", func() {
+			missing, err := rbac.Check(client, permissions)
+			Expect(err).To(Succeed())
+			Expect(missing).To(Equal([]rbac.Permission{permissions[4]}))
+
+			err = rbac.Verify(client, "the test", permissions, true)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("update serviceexports.multicluster.x-k8s.io/status"))
+		})
+	})
+
+	When("the permissions can't be reviewed", func() {
+		BeforeEach(func() {
+			reviewErr = errors.New("fake error")
+		})
+
+		It("should return an error from Check", func() {
+			_, err := rbac.Check(client, permissions)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should not fail Verify even if fail fast is set", func() {
+			Expect(rbac.Verify(client, "the test", permissions, true)).To(Succeed())
+		})
+	})
+})
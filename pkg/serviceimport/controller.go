@@ -17,8 +17,10 @@ package serviceimport
 
 import (
 	"fmt"
+	"reflect"
 
 	"github.com/submariner-io/admiral/pkg/log"
+	"github.com/submariner-io/lighthouse/pkg/liveness"
 	mcsClientset "github.com/submariner-io/lighthouse/pkg/mcs/client/clientset/versioned"
 	mcsInformers "github.com/submariner-io/lighthouse/pkg/mcs/client/informers/externalversions"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -39,8 +41,20 @@ type Controller struct {
 	serviceInformer cache.SharedIndexInformer
 	stopCh          chan struct{}
 	store           Store
+	results         chan<- reconcileResult
 }
 
+// reconcileResult is emitted on the results channel, if set, after each ServiceImport event is processed.
+type reconcileResult struct {
+	Key     string
+	Outcome string
+}
+
+const (
+	outcomeProcessed = "Processed"
+	outcomeDeleted   = "Deleted"
+)
+
 func NewController(serviceImportStore Store) *Controller {
 	return &Controller{
 		NewClientset: getNewClientsetFunc(),
@@ -67,13 +81,20 @@ func (c *Controller) Start(kubeConfig *rest.Config) error {
 		return fmt.Errorf("Error creating client set: %v", err)
 	}
 
-	informerFactory := mcsInformers.NewSharedInformerFactoryWithOptions(clientSet, 0,
+	informerFactory := mcsInformers.NewSharedInformerFactoryWithOptions(clientSet, liveness.ResyncPeriod,
 		mcsInformers.WithNamespace(metav1.NamespaceAll))
 
 	c.serviceInformer = informerFactory.Multicluster().V1alpha1().ServiceImports().Informer()
 	c.serviceInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: c.serviceImportCreatedOrUpdated,
 		UpdateFunc: func(oldObj, newObj interface{}) {
+			// A resync redelivers an unchanged ServiceImport, which only shows the controller is still processing its
+			// events.
+			if reflect.DeepEqual(oldObj, newObj) {
+				liveness.Reconciled(liveness.ControllerServiceImport)
+				return
+			}
+
 			c.serviceImportCreatedOrUpdated(newObj)
 		},
 		DeleteFunc: c.serviceImportDeleted,
@@ -88,6 +109,11 @@ func (c *Controller) Start(kubeConfig *rest.Config) error {
 	return nil
 }
 
+// HasSynced returns true once the ServiceImports listed when the controller started were added to the map.
+func (c *Controller) HasSynced() bool {
+	return c.serviceInformer != nil && c.serviceInformer.HasSynced()
+}
+
 func (c *Controller) Stop() {
 	close(c.stopCh)
 
@@ -98,6 +124,9 @@ func (c *Controller) serviceImportCreatedOrUpdated(obj interface{}) {
 	klog.V(log.DEBUG).Infof("In serviceImportCreatedOrUpdated for: %#v, ", obj)
 
 	c.store.Put(obj.(*mcsv1a1.ServiceImport))
+	c.updateServiceImportCounts()
+	liveness.Reconciled(liveness.ControllerServiceImport)
+	c.notifyResult(obj, outcomeProcessed)
 }
 
 func (c *Controller) serviceImportDeleted(obj interface{}) {
@@ -120,4 +149,42 @@ func (c *Controller) serviceImportDeleted(obj interface{}) {
 	}
 
 	c.store.Remove(si)
+	c.updateServiceImportCounts()
+	liveness.Reconciled(liveness.ControllerServiceImport)
+	c.notifyResult(si, outcomeDeleted)
+}
+
+// updateServiceImportCounts recomputes the ServiceImports gauge from the informer cache so it can't drift from the
+// actual state.
+func (c *Controller) updateServiceImportCounts() {
+	counts := map[mcsv1a1.ServiceImportType]int{mcsv1a1.ClusterSetIP: 0, mcsv1a1.Headless: 0}
+
+	for _, obj := range c.serviceInformer.GetStore().List() {
+		counts[obj.(*mcsv1a1.ServiceImport).Spec.Type]++
+	}
+
+	ServiceImports.Reset()
+
+	for sType, count := range counts {
+		ServiceImports.WithLabelValues(string(sType)).Set(float64(count))
+	}
+}
+
+// setResultsChannel sets a channel on which the outcome of each processed event is sent so unit tests can wait for it
+// instead of polling. It must be called before Start.
+func (c *Controller) setResultsChannel(results chan<- reconcileResult) {
+	c.results = results
+}
+
+func (c *Controller) notifyResult(obj interface{}, outcome string) {
+	if c.results == nil {
+		return
+	}
+
+	key, _ := cache.MetaNamespaceKeyFunc(obj)
+
+	select {
+	case c.results <- reconcileResult{Key: key, Outcome: outcome}:
+	case <-c.stopCh:
+	}
 }
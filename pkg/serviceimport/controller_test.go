@@ -16,9 +16,13 @@ limitations under the License.
 package serviceimport_test
 
 import (
+	"time"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	lhconstants "github.com/submariner-io/lighthouse/pkg/constants"
+	"github.com/submariner-io/lighthouse/pkg/liveness"
 	mcsClientset "github.com/submariner-io/lighthouse/pkg/mcs/client/clientset/versioned"
 	fakeMCSClientSet "github.com/submariner-io/lighthouse/pkg/mcs/client/clientset/versioned/fake"
 	"github.com/submariner-io/lighthouse/pkg/serviceimport"
@@ -46,6 +50,7 @@ func testLifecycleNotifications() {
 		controller    *serviceimport.Controller
 		fakeClientSet mcsClientset.Interface
 		store         *fakeStore
+		results       chan serviceimport.ReconcileResult
 	)
 
 	BeforeEach(func() {
@@ -56,12 +61,16 @@ func testLifecycleNotifications() {
 
 		serviceImport = newServiceImport(namespace1, service1, serviceIP, clusterID)
 		controller = serviceimport.NewController(store)
+		results = make(chan serviceimport.ReconcileResult, 10)
+		serviceimport.SetResultsChannel(controller, results)
 		fakeClientSet = fakeMCSClientSet.NewSimpleClientset()
 
 		controller.NewClientset = func(c *rest.Config) (mcsClientset.Interface, error) {
 			return fakeClientSet, nil
 		}
+	})
 
+	JustBeforeEach(func() {
 		Expect(controller.Start(&rest.Config{})).To(Succeed())
 	})
 
@@ -115,6 +124,31 @@ func testLifecycleNotifications() {
 		})
 	})
 
+	When("the ServiceImports are resynced", func() {
+		var prevResyncPeriod time.Duration
+
+		BeforeEach(func() {
+			prevResyncPeriod = liveness.ResyncPeriod
+			liveness.ResyncPeriod = 100 * time.Millisecond
+		})
+
+		AfterEach(func() {
+			liveness.ResyncPeriod = prevResyncPeriod
+		})
+
+		It("should advance the last reconcile timestamp without putting them again", func() {
+			reconciled := func() float64 {
+				return testutil.ToFloat64(liveness.LastReconcileTimestamp.WithLabelValues(liveness.ControllerServiceImport))
+			}
+
+			testOnAdd(serviceImport)
+			last := reconciled()
+
+			Eventually(reconciled, 5).Should(BeNumerically(">", last))
+			store.verifyNoPut()
+		})
+	})
+
 	When("a ServiceImport is updated", func() {
 		It("it should be updated in the ServiceImport store", func() {
 			testOnAdd(serviceImport)
@@ -133,6 +167,57 @@ func testLifecycleNotifications() {
 			testOnRemove(serviceImport)
 		})
 	})
+
+	When("ServiceImports of different types are added and deleted", func() {
+		It("should update the ServiceImports gauge by type", func() {
+			headless := newServiceImport(namespace1, service1, "", clusterID2)
+			headless.Spec.Type = mcsv1a1.Headless
+
+			testOnDoubleAdd(serviceImport, headless)
+			testOnAdd(newServiceImport(namespace1, "service2", serviceIP2, clusterID))
+
+			awaitServiceImports(mcsv1a1.ClusterSetIP, 2)
+			awaitServiceImports(mcsv1a1.Headless, 1)
+
+			Expect(deleteService(headless)).To(Succeed())
+			store.verifyRemove(headless)
+
+			awaitServiceImports(mcsv1a1.Headless, 0)
+			awaitServiceImports(mcsv1a1.ClusterSetIP, 2)
+		})
+	})
+
+	When("ServiceImport events are processed", func() {
+		It("should emit the processing results", func() {
+			key := serviceImport.Namespace + "/" + serviceImport.Name
+
+			Expect(createService(serviceImport)).To(Succeed())
+			Eventually(results, 5).Should(Receive(Equal(serviceimport.ReconcileResult{Key: key,
+				Outcome: serviceimport.OutcomeProcessed})))
+
+			Expect(deleteService(serviceImport)).To(Succeed())
+			Eventually(results, 5).Should(Receive(Equal(serviceimport.ReconcileResult{Key: key,
+				Outcome: serviceimport.OutcomeDeleted})))
+		})
+
+		It("should advance the last reconcile timestamp", func() {
+			reconciled := func() float64 {
+				return testutil.ToFloat64(liveness.LastReconcileTimestamp.WithLabelValues(liveness.ControllerServiceImport))
+			}
+
+			last := reconciled()
+
+			Expect(createService(serviceImport)).To(Succeed())
+			Eventually(results, 5).Should(Receive())
+			Expect(reconciled()).To(BeNumerically(">", last))
+		})
+	})
+}
+
+func awaitServiceImports(sType mcsv1a1.ServiceImportType, expected int) {
+	Eventually(func() float64 {
+		return testutil.ToFloat64(serviceimport.ServiceImports.WithLabelValues(string(sType)))
+	}, 5).Should(Equal(float64(expected)))
 }
 
 func newServiceImport(namespace, name, serviceIP, clusterID string) *mcsv1a1.ServiceImport {
@@ -179,6 +264,10 @@ func (f *fakeStore) verifyPut(expected *mcsv1a1.ServiceImport) {
 	Eventually(f.put, 5).Should(Receive(Equal(expected)), "Put was not called")
 }
 
+func (f *fakeStore) verifyNoPut() {
+	Consistently(f.put, 300*time.Millisecond).ShouldNot(Receive(), "Put was called")
+}
+
 func (f *fakeStore) verifyRemove(expected *mcsv1a1.ServiceImport) {
 	Eventually(f.remove, 5).Should(Receive(Equal(expected)), "Remove was not called")
 }
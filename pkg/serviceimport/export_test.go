@@ -0,0 +1,35 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package serviceimport
+
+import "time"
+
+// ReconcileResult and SetResultsChannel expose the results channel to the external test package only.
+type ReconcileResult = reconcileResult
+
+const (
+	OutcomeProcessed = outcomeProcessed
+	OutcomeDeleted   = outcomeDeleted
+)
+
+func SetResultsChannel(c *Controller, results chan<- ReconcileResult) {
+	c.setResultsChannel(results)
+}
+
+// SetClock replaces the map's clock, eg to step through a drain period.
+func SetClock(m *Map, now func() time.Time) {
+	m.now = now
+}
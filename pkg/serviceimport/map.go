@@ -16,10 +16,19 @@ limitations under the License.
 package serviceimport
 
 import (
+	"bytes"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/submariner-io/lighthouse/pkg/consistenthash"
 	lhconstants "github.com/submariner-io/lighthouse/pkg/constants"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
 	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
 )
 
@@ -27,32 +36,260 @@ type clusterInfo struct {
 	ip     string
 	name   string
 	weight uint64
+	// If set, the cluster's weight decays over its drain period and it isn't preferred as the local cluster.
+	draining bool
+}
+
+// The number of phases the weight of a draining cluster decays in over its drain period, down to a tenth of its
+// weight in the last phase.
+const drainPhases = 10
+
+// The drain period of a cluster marked draining without a duration.
+const defaultDrainPeriod = 5 * time.Minute
+
+// clusterExport holds what a single cluster exported for a service.
+type clusterExport struct {
+	ip           string
+	clustersetIP string
+	variant      string
+	svcType      mcsv1a1.ServiceImportType
+	affinity     corev1.ServiceAffinity
+	exportTime   time.Time
+	minClusters  int
+	singleton    bool
+	weight       uint64
+	draining     bool
+	ports        []mcsv1a1.ServicePort
+	globalName   string
+	// The exported Service's internalTrafficPolicy, if it has one.
+	internalTrafficPolicy string
+	dnsPriority           string
+	// The IPv6 clusterset VIP, alongside clustersetIP if the Service is dual-stack.
+	clustersetIPv6 string
+	// Whether the exporting cluster flagged the export as within its export delay, during which it's not resolvable.
+	pending bool
+	// The IPs the service's answers are frozen to, if any.
+	frozenIPs []string
+	// How long the cluster's weight decays for, from when the export was first seen draining.
+	drainPeriod   time.Duration
+	drainingSince time.Time
 }
 
 type serviceInfo struct {
-	key           string
-	clusterIPs    map[string]string
-	clustersQueue []clusterInfo
-	rrCount       uint64
-	isHeadless    bool
+	key            string
+	clusterIPs     map[string]string
+	clusterExports map[string]*clusterExport
+	clustersQueue  []clusterInfo
+	rrCount        uint64
+	svcType        mcsv1a1.ServiceImportType
+	affinity       corev1.ServiceAffinity
+	clustersetIP   string
+	clustersetIPv6 string
+	isHeadless     bool
+	minClusters    int
+	singleton      bool
+	dnsPriority    string
+	uid            string
+	ports          []mcsv1a1.ServicePort
+	// The global name claimed by the oldest export, and the time of that export.
+	globalName     string
+	globalNameTime time.Time
+	frozenIPs      []string
+	// When the weight of a draining cluster decays to its next phase, after which the queue is rebuilt, or the zero
+	// time if no cluster is still decaying.
+	nextQueueRebuild time.Time
 }
 
-func (si *serviceInfo) buildClusterInfoQueue() {
-	si.clustersQueue = make([]clusterInfo, 0)
+// buildClusterInfoQueue builds the round-robin queue of the clusters, in which each cluster appears as many times as
+// the weight it's exported with, 1 by default. The entries are interleaved by smooth weighted round-robin so a heavier
+// cluster isn't returned in bursts. While a cluster is draining, the weights are scaled by drainPhases and the
+// draining cluster's weight decays by a phase at a time, so its share of the answers moves to the other clusters.
+func (si *serviceInfo) buildClusterInfoQueue(now time.Time) {
+	clusters := make([]clusterInfo, 0, len(si.clusterIPs))
+	total := uint64(0)
+	scale := uint64(1)
+
+	for cluster := range si.clusterIPs {
+		if export := si.clusterExports[cluster]; export != nil && export.draining {
+			scale = drainPhases
+		}
+	}
+
+	si.nextQueueRebuild = time.Time{}
+
 	for cluster, ip := range si.clusterIPs {
-		c := clusterInfo{name: cluster, ip: ip, weight: 0}
-		si.clustersQueue = append(si.clustersQueue, c)
+		c := clusterInfo{name: cluster, ip: ip, weight: 1}
+
+		export := si.clusterExports[cluster]
+		if export != nil && export.weight > 0 {
+			c.weight = export.weight
+		}
+
+		if export != nil && export.draining {
+			phase, next := export.drainPhase(now)
+			c.draining = true
+			c.weight *= drainPhases - phase
+
+			if !next.IsZero() && (si.nextQueueRebuild.IsZero() || next.Before(si.nextQueueRebuild)) {
+				si.nextQueueRebuild = next
+			}
+		} else {
+			c.weight *= scale
+		}
+
+		clusters = append(clusters, c)
+		total += c.weight
+	}
+
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i].name < clusters[j].name
+	})
+
+	si.clustersQueue = make([]clusterInfo, 0, total)
+	current := make([]int64, len(clusters))
+
+	for n := uint64(0); n < total; n++ {
+		selected := 0
+
+		for i := range clusters {
+			current[i] += int64(clusters[i].weight)
+			if current[i] > current[selected] {
+				selected = i
+			}
+		}
+
+		current[selected] -= int64(total)
+		si.clustersQueue = append(si.clustersQueue, clusters[selected])
+	}
+}
+
+// drainPhase returns the phase a draining export's weight decayed to at the given time, from 0 to drainPhases - 1, and
+// when it decays to the next phase, or the zero time once it's in the last phase. The last phase lasts until the export
+// is removed so the cluster still answers if the other clusters fail.
+func (e *clusterExport) drainPhase(now time.Time) (uint64, time.Time) {
+	elapsed := now.Sub(e.drainingSince)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+
+	phase := uint64(elapsed * drainPhases / e.drainPeriod)
+	if phase >= drainPhases-1 {
+		return drainPhases - 1, time.Time{}
+	}
+
+	return phase, e.drainingSince.Add(e.drainPeriod * time.Duration(phase+1) / drainPhases)
+}
+
+// mergeClusterExports aggregates the exports from all clusters into the clusterset service. The service type is
+// resolved from the oldest export, per the MCS conflict resolution policy, with ties broken by cluster ID. Exports of
+// the resolved type are merged while exports whose type conflicts are excluded until the conflict is resolved.
+func (si *serviceInfo) mergeClusterExports(now time.Time) {
+	var oldest string
+
+	for cluster, export := range si.clusterExports {
+		if oldest == "" || export.exportTime.Before(si.clusterExports[oldest].exportTime) ||
+			(export.exportTime.Equal(si.clusterExports[oldest].exportTime) && cluster < oldest) {
+			oldest = cluster
+		}
+	}
+
+	si.svcType = ""
+	si.affinity = ""
+	si.clustersetIP = ""
+	si.clustersetIPv6 = ""
+	si.minClusters = 0
+	si.singleton = false
+	si.dnsPriority = ""
+	si.globalName = ""
+	si.globalNameTime = time.Time{}
+	si.frozenIPs = nil
+
+	if oldest != "" {
+		si.svcType = si.clusterExports[oldest].svcType
+		si.affinity = si.clusterExports[oldest].affinity
+		si.clustersetIP = si.clusterExports[oldest].clustersetIP
+		si.clustersetIPv6 = si.clusterExports[oldest].clustersetIPv6
+		si.minClusters = si.clusterExports[oldest].minClusters
+		si.singleton = si.clusterExports[oldest].singleton
+		si.dnsPriority = si.clusterExports[oldest].dnsPriority
+		si.globalName = si.clusterExports[oldest].globalName
+		si.globalNameTime = si.clusterExports[oldest].exportTime
+		si.frozenIPs = si.clusterExports[oldest].frozenIPs
+	}
+
+	si.isHeadless = si.svcType == mcsv1a1.Headless
+	si.clusterIPs = make(map[string]string)
+
+	for cluster, export := range si.clusterExports {
+		if export.svcType == mcsv1a1.ClusterSetIP && export.svcType == si.svcType {
+			si.clusterIPs[cluster] = export.ip
+		}
+	}
+
+	si.mergePorts()
+
+	if !si.isHeadless {
+		si.buildClusterInfoQueue(now)
+	}
+}
+
+// mergePorts merges the ports of the exports of the resolved type by name. A port exported with conflicting
+// properties, eg its appProtocol, by several clusters is taken from the oldest export, with ties broken by cluster ID.
+func (si *serviceInfo) mergePorts() {
+	clusters := make([]string, 0, len(si.clusterExports))
+
+	for cluster, export := range si.clusterExports {
+		if export.svcType == si.svcType {
+			clusters = append(clusters, cluster)
+		}
+	}
+
+	sort.Slice(clusters, func(i, j int) bool {
+		ti, tj := si.clusterExports[clusters[i]].exportTime, si.clusterExports[clusters[j]].exportTime
+		return ti.Before(tj) || (ti.Equal(tj) && clusters[i] < clusters[j])
+	})
+
+	si.ports = []mcsv1a1.ServicePort{}
+	merged := map[string]bool{}
+
+	for _, cluster := range clusters {
+		for i := range si.clusterExports[cluster].ports {
+			port := &si.clusterExports[cluster].ports[i]
+			if !merged[port.Name] {
+				merged[port.Name] = true
+				si.ports = append(si.ports, *port.DeepCopy())
+			}
+		}
 	}
 }
 
 type Map struct {
 	svcMap map[string]*serviceInfo
+	// Maps a clusterset UID to the key of the service it identifies.
+	uids map[string]string
+	// Maps a global name to the keys of the services claiming it.
+	globalNames map[string]map[string]bool
+	// The namespaces, and the keys of the services, allowed to claim a global name.
+	globalNameAllowlist map[string]bool
 	sync.RWMutex
+	changeHandlers      []ChangeHandler
+	changeHandlersMutex sync.RWMutex
+	// Returns the current time, which the tests replace to step through a drain period.
+	now func() time.Time
 }
 
+// ChangeHandler is notified when the exports of a service are added, updated or removed.
+type ChangeHandler func(namespace, name string)
+
+// selectIP selects the next eligible cluster round-robin. A draining cluster keeps a share of the queue, decayed with
+// its weight, so a service moving to another cluster is answered from either while both are available and from the
+// one that is otherwise, without an empty answer in between. A weighted cluster appears several times in the queue but
+// is only checked once.
 func (m *Map) selectIP(queue []clusterInfo, counter *uint64, name, namespace string, checkCluster func(string) bool,
 	checkEndpoint func(string, string, string) bool) string {
 	queueLength := len(queue)
+	isEligible := memoizeEligibility(name, namespace, checkCluster, checkEndpoint)
+
 	for i := 0; i < queueLength; i++ {
 		c := atomic.LoadUint64(counter)
 
@@ -60,7 +297,7 @@ func (m *Map) selectIP(queue []clusterInfo, counter *uint64, name, namespace str
 
 		atomic.AddUint64(counter, 1)
 
-		if checkCluster(info.name) && checkEndpoint(name, namespace, info.name) {
+		if isEligible(info.name) {
 			return info.ip
 		}
 	}
@@ -68,33 +305,124 @@ func (m *Map) selectIP(queue []clusterInfo, counter *uint64, name, namespace str
 	return ""
 }
 
+// memoizeEligibility returns a function that checks whether a cluster is eligible, running the checks only once per
+// cluster so the health observations, eg recorded by a circuit breaker, aren't multiplied by the cluster's weight.
+func memoizeEligibility(name, namespace string, checkCluster func(string) bool,
+	checkEndpoint func(string, string, string) bool) func(string) bool {
+	eligible := map[string]bool{}
+
+	return func(cluster string) bool {
+		result, ok := eligible[cluster]
+		if !ok {
+			result = checkCluster(cluster) && checkEndpoint(name, namespace, cluster)
+			eligible[cluster] = result
+		}
+
+		return result
+	}
+}
+
+// selectStickyIP selects the first eligible cluster in the order ranked for the client so repeated queries from the
+// same client get the same answer while that cluster remains eligible. As the weights don't apply to the ranking, the
+// draining clusters are only selected once no other cluster is eligible.
+func (m *Map) selectStickyIP(queue []clusterInfo, client, name, namespace string, checkCluster func(string) bool,
+	checkEndpoint func(string, string, string) bool) string {
+	clusterIPs := make(map[string]string, len(queue))
+	clusters := make([]string, 0, len(queue))
+	draining := map[string]bool{}
+
+	for _, info := range queue {
+		// A weighted cluster appears several times in the queue but is ranked once.
+		if _, ok := clusterIPs[info.name]; ok {
+			continue
+		}
+
+		clusterIPs[info.name] = info.ip
+		clusters = append(clusters, info.name)
+		draining[info.name] = info.draining
+	}
+
+	ranked := consistenthash.Rank(client, clusters)
+
+	for _, selectDraining := range []bool{false, true} {
+		for _, cluster := range ranked {
+			if draining[cluster] == selectDraining && checkCluster(cluster) && checkEndpoint(name, namespace, cluster) {
+				return clusterIPs[cluster]
+			}
+		}
+	}
+
+	return ""
+}
+
 func (m *Map) GetIP(namespace, name, cluster, localCluster string, checkCluster func(string) bool,
 	checkEndpoint func(string, string, string) bool) (ip string, found, isLocal bool) {
-	clusterIPs, queue, counter, isHeadless := func() (map[string]string, []clusterInfo, *uint64, bool) {
+	return m.getIP(namespace, name, cluster, localCluster, "", true, checkCluster, checkEndpoint)
+}
+
+// GetIPForClient is like GetIP except that, for services with ClientIP session affinity, the cluster is selected by
+// consistent hashing of the given client address rather than round-robin.
+func (m *Map) GetIPForClient(namespace, name, cluster, localCluster, client string, checkCluster func(string) bool,
+	checkEndpoint func(string, string, string) bool) (ip string, found, isLocal bool) {
+	return m.getIP(namespace, name, cluster, localCluster, client, true, checkCluster, checkEndpoint)
+}
+
+// PeekIPForClient is like GetIPForClient except that the round-robin isn't advanced, eg to report the answer of a
+// query without changing those of the next queries.
+func (m *Map) PeekIPForClient(namespace, name, cluster, localCluster, client string, checkCluster func(string) bool,
+	checkEndpoint func(string, string, string) bool) (ip string, found, isLocal bool) {
+	return m.getIP(namespace, name, cluster, localCluster, client, false, checkCluster, checkEndpoint)
+}
+
+func (m *Map) getIP(namespace, name, cluster, localCluster, client string, advance bool, checkCluster func(string) bool,
+	checkEndpoint func(string, string, string) bool) (ip string, found, isLocal bool) {
+	m.rebuildDecayedQueue(namespace, name)
+
+	clusterIPs, queue, counter, isHeadless, affinity, clustersetIP := func() (map[string]string, []clusterInfo, *uint64,
+		bool, corev1.ServiceAffinity, string) {
 		m.RLock()
 		defer m.RUnlock()
 
 		si, ok := m.svcMap[keyFunc(namespace, name)]
 		if !ok {
-			return nil, nil, nil, false
+			return nil, nil, nil, false, "", ""
 		}
 
-		return si.clusterIPs, si.clustersQueue, &si.rrCount, si.isHeadless
+		return si.clusterIPs, si.clustersQueue, &si.rrCount, si.isHeadless, si.affinity, si.clustersetIP
 	}()
 
 	if clusterIPs == nil || isHeadless {
 		return "", false, false
 	}
 
+	if !advance {
+		peeked := atomic.LoadUint64(counter)
+		counter = &peeked
+	}
+
 	// If a clusterId is specified, we supply it even if the service is not there
 	if cluster != "" {
 		ip, found = clusterIPs[cluster]
 		return ip, found, cluster == localCluster
 	}
 
+	// The clusterset VIP is returned as long as the service is available from any cluster
+	if clustersetIP != "" {
+		isEligible := memoizeEligibility(name, namespace, checkCluster, checkEndpoint)
+
+		for _, info := range queue {
+			if isEligible(info.name) {
+				return clustersetIP, true, false
+			}
+		}
+
+		return "", true, false
+	}
+
 	// If we are aware of the local cluster
 	// And we found some accessible IP, we shall return it
-	if localCluster != "" {
+	// unless the local cluster is draining, in which case it's only selected as any other draining cluster
+	if localCluster != "" && !isDraining(queue, localCluster) {
 		ip, found := clusterIPs[localCluster]
 
 		if found && ip != "" && checkEndpoint(name, namespace, localCluster) {
@@ -103,7 +431,11 @@ func (m *Map) GetIP(namespace, name, cluster, localCluster string, checkCluster
 	}
 
 	// Fall back to Round-Robin if service is not presented in the local cluster
-	ip = m.selectIP(queue, counter, name, namespace, checkCluster, checkEndpoint)
+	if client != "" && affinity == corev1.ServiceAffinityClientIP {
+		ip = m.selectStickyIP(queue, client, name, namespace, checkCluster, checkEndpoint)
+	} else {
+		ip = m.selectIP(queue, counter, name, namespace, checkCluster, checkEndpoint)
+	}
 	if ip != "" {
 		return ip, true, false
 	}
@@ -111,9 +443,115 @@ func (m *Map) GetIP(namespace, name, cluster, localCluster string, checkCluster
 	return "", true, false
 }
 
+// GetPrimaryIP returns the IP of the primary cluster of a singleton service, the eligible cluster with the lowest IP,
+// so every client in the clusterset gets the same answer until that cluster stops being eligible. As the weights don't
+// apply to it, the draining clusters are only selected once no other cluster is eligible. Unlike GetIP, the local
+// cluster isn't preferred. A service with a clusterset VIP is answered with the VIP, as by GetIP.
+func (m *Map) GetPrimaryIP(namespace, name, localCluster string, checkCluster func(string) bool,
+	checkEndpoint func(string, string, string) bool) (ip string, found, isLocal bool) {
+	queue, exists := func() ([]clusterInfo, bool) {
+		m.RLock()
+		defer m.RUnlock()
+
+		si, ok := m.svcMap[keyFunc(namespace, name)]
+		if !ok || si.isHeadless || si.clustersetIP != "" {
+			return nil, false
+		}
+
+		return si.clustersQueue, true
+	}()
+
+	if !exists {
+		return m.getIP(namespace, name, "", localCluster, "", true, checkCluster, checkEndpoint)
+	}
+
+	for _, draining := range []bool{false, true} {
+		var primary *clusterInfo
+
+		for i := range queue {
+			info := &queue[i]
+			if info.draining != draining || (primary != nil && !ipLess(info.ip, primary.ip)) {
+				continue
+			}
+
+			if checkCluster(info.name) && checkEndpoint(name, namespace, info.name) {
+				primary = info
+			}
+		}
+
+		if primary != nil {
+			return primary.ip, true, primary.name == localCluster
+		}
+	}
+
+	return "", true, false
+}
+
+func ipLess(a, b string) bool {
+	return bytes.Compare(net.ParseIP(a).To16(), net.ParseIP(b).To16()) < 0
+}
+
+// rebuildDecayedQueue rebuilds the round-robin queue of the service once the weight of one of its draining clusters
+// decayed to its next phase.
+func (m *Map) rebuildDecayedQueue(namespace, name string) {
+	key := keyFunc(namespace, name)
+	now := m.now()
+
+	isDue := func() bool {
+		si, ok := m.svcMap[key]
+		return ok && !si.isHeadless && !si.nextQueueRebuild.IsZero() && !now.Before(si.nextQueueRebuild)
+	}
+
+	m.RLock()
+	due := isDue()
+	m.RUnlock()
+
+	if !due {
+		return
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	if isDue() {
+		m.svcMap[key].buildClusterInfoQueue(now)
+	}
+}
+
+func isDraining(queue []clusterInfo, cluster string) bool {
+	for _, info := range queue {
+		if info.name == cluster {
+			return info.draining
+		}
+	}
+
+	return false
+}
+
 func NewMap() *Map {
 	return &Map{
-		svcMap: make(map[string]*serviceInfo),
+		svcMap:      make(map[string]*serviceInfo),
+		uids:        make(map[string]string),
+		globalNames: make(map[string]map[string]bool),
+		now:         time.Now,
+	}
+}
+
+// OnChange registers a handler notified whenever the exports of a service change. The handler is called after the
+// change is stored, without holding the map's lock, and must not block.
+func (m *Map) OnChange(handler ChangeHandler) {
+	m.changeHandlersMutex.Lock()
+	defer m.changeHandlersMutex.Unlock()
+
+	m.changeHandlers = append(m.changeHandlers, handler)
+}
+
+func (m *Map) notifyChange(namespace, name string) {
+	m.changeHandlersMutex.RLock()
+	defer m.changeHandlersMutex.RUnlock()
+
+	for _, handler := range m.changeHandlers {
+		handler(namespace, name)
 	}
 }
 
@@ -122,30 +560,98 @@ func (m *Map) Put(serviceImport *mcsv1a1.ServiceImport) {
 		namespace := serviceImport.Annotations["origin-namespace"]
 		key := keyFunc(namespace, name)
 
+		defer m.notifyChange(namespace, name)
+
 		m.Lock()
 		defer m.Unlock()
 
 		remoteService, ok := m.svcMap[key]
+		cluster := serviceImport.GetLabels()[lhconstants.LabelSourceCluster]
+		now := m.now()
 
 		if !ok {
 			remoteService = &serviceInfo{
-				key:        key,
-				clusterIPs: make(map[string]string),
-				rrCount:    0,
-				isHeadless: serviceImport.Spec.Type == mcsv1a1.Headless,
+				key:            key,
+				clusterIPs:     make(map[string]string),
+				clusterExports: make(map[string]*clusterExport),
+				rrCount:        0,
+			}
+		}
+
+		export := &clusterExport{
+			variant:  serviceImport.Annotations[lhconstants.AnnotationVariant],
+			svcType:  serviceImport.Spec.Type,
+			affinity: serviceImport.Spec.SessionAffinity,
+		}
+
+		if exportTime, err := time.Parse(time.RFC3339, serviceImport.Annotations[lhconstants.AnnotationExportTime]); err == nil {
+			export.exportTime = exportTime
+		}
+
+		if minClusters, err := strconv.Atoi(serviceImport.Annotations[lhconstants.AnnotationMinClusters]); err == nil {
+			export.minClusters = minClusters
+		}
+
+		export.draining, export.drainPeriod = parseDraining(serviceImport.Annotations[lhconstants.AnnotationDraining])
+		if export.draining {
+			// The decay is timed from when the export is first seen draining, so a later update doesn't restart it.
+			export.drainingSince = now
+			if previous := remoteService.clusterExports[cluster]; previous != nil && previous.draining {
+				export.drainingSince = previous.drainingSince
+			}
+		}
+
+		export.pending = serviceImport.Annotations[lhconstants.AnnotationExportPending] == "true"
+		export.singleton = serviceImport.Annotations[lhconstants.AnnotationSingleton] == "true"
+		export.globalName = serviceImport.Annotations[lhconstants.AnnotationGlobalName]
+		export.internalTrafficPolicy = serviceImport.Annotations[lhconstants.AnnotationInternalTrafficPolicy]
+		export.dnsPriority = serviceImport.Annotations[lhconstants.AnnotationDNSPriority]
+		export.frozenIPs = ParseFrozenIPs(serviceImport.Annotations[lhconstants.AnnotationFrozen])
+
+		for i := range serviceImport.Spec.Ports {
+			export.ports = append(export.ports, *serviceImport.Spec.Ports[i].DeepCopy())
+		}
+
+		// The weight is capped as it bounds the length of the round-robin queue.
+		if weight, err := strconv.ParseUint(serviceImport.Annotations[lhconstants.AnnotationWeight], 10, 64); err == nil {
+			export.weight = weight
+			if export.weight > lhconstants.MaxWeight {
+				export.weight = lhconstants.MaxWeight
 			}
 		}
 
 		if serviceImport.Spec.Type == mcsv1a1.ClusterSetIP {
-			remoteService.clusterIPs[serviceImport.GetLabels()[lhconstants.LabelSourceCluster]] = serviceImport.Spec.IPs[0]
+			export.ip = clusterIP(serviceImport)
+			export.clustersetIP, export.clustersetIPv6 = ClustersetIPs(serviceImport)
 		}
 
-		if !remoteService.isHeadless {
-			remoteService.buildClusterInfoQueue()
+		remoteService.clusterExports[cluster] = export
+
+		previousGlobalName := remoteService.globalName
+		remoteService.mergeClusterExports(now)
+
+		if uid := serviceImport.Annotations[lhconstants.AnnotationClustersetUID]; uid != "" {
+			remoteService.uid = uid
+			m.uids[uid] = key
 		}
 
 		m.svcMap[key] = remoteService
+		m.updateGlobalName(remoteService, previousGlobalName)
+	}
+}
+
+// parseDraining returns whether a draining annotation marks the cluster as draining and for how long its weight decays,
+// given as a duration or "true" for the default period.
+func parseDraining(value string) (bool, time.Duration) {
+	if value == "true" {
+		return true, defaultDrainPeriod
+	}
+
+	if period, err := time.ParseDuration(value); err == nil && period > 0 {
+		return true, period
 	}
+
+	return false, 0
 }
 
 func (m *Map) Remove(serviceImport *mcsv1a1.ServiceImport) {
@@ -153,6 +659,8 @@ func (m *Map) Remove(serviceImport *mcsv1a1.ServiceImport) {
 		namespace := serviceImport.Annotations["origin-namespace"]
 		key := keyFunc(namespace, name)
 
+		defer m.notifyChange(namespace, name)
+
 		m.Lock()
 		defer m.Unlock()
 
@@ -162,15 +670,517 @@ func (m *Map) Remove(serviceImport *mcsv1a1.ServiceImport) {
 		}
 
 		for _, info := range serviceImport.Status.Clusters {
-			delete(remoteService.clusterIPs, info.Cluster)
+			delete(remoteService.clusterExports, info.Cluster)
 		}
 
-		if len(remoteService.clusterIPs) == 0 {
+		previousGlobalName := remoteService.globalName
+
+		if len(remoteService.clusterExports) == 0 {
 			delete(m.svcMap, key)
-		} else if !remoteService.isHeadless {
-			remoteService.buildClusterInfoQueue()
+			delete(m.uids, remoteService.uid)
+			remoteService.globalName = ""
+		} else {
+			remoteService.mergeClusterExports(m.now())
+		}
+
+		m.updateGlobalName(remoteService, previousGlobalName)
+	}
+}
+
+// GetClusterVariant returns the variant of the service exported by the given cluster or the empty string if the
+// cluster's export isn't a variant.
+func (m *Map) GetClusterVariant(namespace, name, cluster string) string {
+	m.RLock()
+	defer m.RUnlock()
+
+	si, ok := m.svcMap[keyFunc(namespace, name)]
+	if !ok {
+		return ""
+	}
+
+	if export, ok := si.clusterExports[cluster]; ok {
+		return export.variant
+	}
+
+	return ""
+}
+
+// IsVariant returns true if the given label names a variant of the service rather than one of the clusters
+// exporting it, ignoring case. Cluster IDs take precedence over variant names.
+func (m *Map) IsVariant(namespace, name, label string) bool {
+	m.RLock()
+	defer m.RUnlock()
+
+	si, ok := m.svcMap[keyFunc(namespace, name)]
+	if !ok {
+		return false
+	}
+
+	if _, isCluster := si.clusterExports[label]; isCluster {
+		return false
+	}
+
+	for _, export := range si.clusterExports {
+		if strings.EqualFold(export.variant, label) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsMerged returns true if the given cluster's export of the service is merged into the clusterset service, ie its
+// type matches the resolved type. It returns true for services that aren't known.
+func (m *Map) IsMerged(namespace, name, cluster string) bool {
+	m.RLock()
+	defer m.RUnlock()
+
+	si, ok := m.svcMap[keyFunc(namespace, name)]
+	if !ok {
+		return true
+	}
+
+	export, ok := si.clusterExports[cluster]
+
+	return !ok || export.svcType == si.svcType
+}
+
+// IsPending returns true if the given cluster's export of the service is within its export delay, during which it's
+// not resolvable, eg so the service can warm up before it's exposed to the clusterset. The delay is held by the
+// exporting cluster, which flags the export as pending until it elapsed as per its own clock.
+func (m *Map) IsPending(namespace, name, cluster string) bool {
+	m.RLock()
+	defer m.RUnlock()
+
+	si, ok := m.svcMap[keyFunc(namespace, name)]
+	if !ok {
+		return false
+	}
+
+	export, ok := si.clusterExports[cluster]
+
+	return ok && export.pending
+}
+
+// GetClusters returns the sorted IDs of the clusters that export the service.
+func (m *Map) GetClusters(namespace, name string) []string {
+	m.RLock()
+	defer m.RUnlock()
+
+	si, ok := m.svcMap[keyFunc(namespace, name)]
+	if !ok {
+		return nil
+	}
+
+	clusters := make([]string, 0, len(si.clusterExports))
+	for cluster := range si.clusterExports {
+		clusters = append(clusters, cluster)
+	}
+
+	sort.Strings(clusters)
+
+	return clusters
+}
+
+// ServiceSummary describes an exported service as aggregated from the exports of all clusters.
+type ServiceSummary struct {
+	Namespace    string
+	Name         string
+	Type         mcsv1a1.ServiceImportType
+	ClustersetIP string
+	// The IPv6 clusterset VIP of a service with an IPv6 cluster IP, if any.
+	ClustersetIPv6 string
+	// Maps the IDs of the clusters whose exports are merged to their IP. It's empty for a headless service.
+	ClusterIPs map[string]string
+	Ports      []mcsv1a1.ServicePort
+	// The global name the service resolves by, if any.
+	GlobalName string
+}
+
+// List returns the summaries of the exported services, sorted by namespace and name.
+func (m *Map) List() []ServiceSummary {
+	m.RLock()
+	defer m.RUnlock()
+
+	services := make([]ServiceSummary, 0, len(m.svcMap))
+
+	for key, si := range m.svcMap {
+		parts := strings.SplitN(key, "/", 2)
+		summary := ServiceSummary{
+			Namespace:      parts[0],
+			Name:           parts[1],
+			Type:           si.svcType,
+			ClustersetIP:   si.clustersetIP,
+			ClustersetIPv6: si.clustersetIPv6,
+			ClusterIPs:     make(map[string]string, len(si.clusterIPs)),
+			Ports:          make([]mcsv1a1.ServicePort, len(si.ports)),
+		}
+
+		for i := range si.ports {
+			summary.Ports[i] = *si.ports[i].DeepCopy()
+		}
+
+		if si.globalName != "" && m.globalNameOwner(si.globalName) == key {
+			summary.GlobalName = si.globalName
+		}
+
+		for cluster, ip := range si.clusterIPs {
+			summary.ClusterIPs[cluster] = ip
+		}
+
+		services = append(services, summary)
+	}
+
+	sort.Slice(services, func(i, j int) bool {
+		return keyFunc(services[i].Namespace, services[i].Name) < keyFunc(services[j].Namespace, services[j].Name)
+	})
+
+	return services
+}
+
+// GetClusterForIP returns the ID of the cluster whose export of the service has the given IP, or an empty string if
+// there is none.
+func (m *Map) GetClusterForIP(namespace, name, ip string) string {
+	m.RLock()
+	defer m.RUnlock()
+
+	si, ok := m.svcMap[keyFunc(namespace, name)]
+	if !ok {
+		return ""
+	}
+
+	for cluster, clusterIP := range si.clusterIPs {
+		if clusterIP == ip {
+			return cluster
+		}
+	}
+
+	return ""
+}
+
+// HasClientIPAffinity returns true if the service has ClientIP session affinity.
+func (m *Map) HasClientIPAffinity(namespace, name string) bool {
+	m.RLock()
+	defer m.RUnlock()
+
+	si, ok := m.svcMap[keyFunc(namespace, name)]
+
+	return ok && si.affinity == corev1.ServiceAffinityClientIP
+}
+
+// IsSingleton returns true if the service is answered with a single primary endpoint, as set by the oldest export.
+func (m *Map) IsSingleton(namespace, name string) bool {
+	m.RLock()
+	defer m.RUnlock()
+
+	si, ok := m.svcMap[keyFunc(namespace, name)]
+
+	return ok && si.singleton
+}
+
+// HasClustersetPriority returns true if the clusterset answers take precedence over the local ones for the service, as
+// set by the oldest export.
+func (m *Map) HasClustersetPriority(namespace, name string) bool {
+	m.RLock()
+	defer m.RUnlock()
+
+	si, ok := m.svcMap[keyFunc(namespace, name)]
+
+	return ok && si.dnsPriority == lhconstants.DNSPriorityClusterset
+}
+
+// GetClustersetIP returns the clusterset VIP of the service, as allocated for the oldest export, or "" if it has none.
+func (m *Map) GetClustersetIP(namespace, name string) string {
+	m.RLock()
+	defer m.RUnlock()
+
+	si, ok := m.svcMap[keyFunc(namespace, name)]
+	if !ok || si.isHeadless {
+		return ""
+	}
+
+	return si.clustersetIP
+}
+
+// GetClustersetIPv6 returns the IPv6 clusterset VIP of the service, as allocated for the oldest export, or "" if it
+// has none.
+func (m *Map) GetClustersetIPv6(namespace, name string) string {
+	m.RLock()
+	defer m.RUnlock()
+
+	si, ok := m.svcMap[keyFunc(namespace, name)]
+	if !ok || si.isHeadless {
+		return ""
+	}
+
+	return si.clustersetIPv6
+}
+
+// HasLocalTrafficPolicy returns true if the given cluster exports the service with a Local internalTrafficPolicy.
+func (m *Map) HasLocalTrafficPolicy(namespace, name, cluster string) bool {
+	m.RLock()
+	defer m.RUnlock()
+
+	si, ok := m.svcMap[keyFunc(namespace, name)]
+	if !ok {
+		return false
+	}
+
+	export, ok := si.clusterExports[cluster]
+
+	return ok && export.internalTrafficPolicy == lhconstants.InternalTrafficPolicyLocal
+}
+
+// IsHeadless returns true if the service is headless, as resolved from the oldest export.
+func (m *Map) IsHeadless(namespace, name string) bool {
+	m.RLock()
+	defer m.RUnlock()
+
+	si, ok := m.svcMap[keyFunc(namespace, name)]
+
+	return ok && si.isHeadless
+}
+
+// GetEligibleIPs returns the IPs of the clusters the round-robin may select for a ClusterSetIP service now, by cluster.
+// It's empty for a headless service.
+func (m *Map) GetEligibleIPs(namespace, name string, checkCluster func(string) bool,
+	checkEndpoint func(string, string, string) bool) map[string]string {
+	m.rebuildDecayedQueue(namespace, name)
+
+	queue := func() []clusterInfo {
+		m.RLock()
+		defer m.RUnlock()
+
+		si, ok := m.svcMap[keyFunc(namespace, name)]
+		if !ok || si.isHeadless {
+			return nil
+		}
+
+		return si.clustersQueue
+	}()
+
+	isEligible := memoizeEligibility(name, namespace, checkCluster, checkEndpoint)
+	eligible := map[string]string{}
+
+	for _, info := range queue {
+		if isEligible(info.name) {
+			eligible[info.name] = info.ip
+		}
+	}
+
+	return eligible
+}
+
+// GetFrozenIPs returns the IPs the answers of the service are frozen to by the frozen annotation of the oldest export,
+// if any.
+func (m *Map) GetFrozenIPs(namespace, name string) []string {
+	m.RLock()
+	defer m.RUnlock()
+
+	si, ok := m.svcMap[keyFunc(namespace, name)]
+	if !ok {
+		return nil
+	}
+
+	return si.frozenIPs
+}
+
+// ParseFrozenIPs parses the value of the frozen annotation, a comma-separated list of IPs. It returns nil if the value
+// is empty or any of the IPs is invalid.
+func ParseFrozenIPs(value string) []string {
+	if value == "" {
+		return nil
+	}
+
+	ips := strings.Split(value, ",")
+	for i := range ips {
+		ips[i] = strings.TrimSpace(ips[i])
+		if net.ParseIP(ips[i]) == nil {
+			return nil
+		}
+	}
+
+	return ips
+}
+
+// GetMinClusters returns the minimum number of clusters the service must be available from for it to be resolved, which
+// is 1 unless set by the oldest export.
+func (m *Map) GetMinClusters(namespace, name string) int {
+	m.RLock()
+	defer m.RUnlock()
+
+	si, ok := m.svcMap[keyFunc(namespace, name)]
+	if !ok || si.minClusters < 1 {
+		return 1
+	}
+
+	return si.minClusters
+}
+
+// GetPorts returns the ports of the service merged from the exports of all clusters.
+func (m *Map) GetPorts(namespace, name string) []mcsv1a1.ServicePort {
+	m.RLock()
+	defer m.RUnlock()
+
+	si, ok := m.svcMap[keyFunc(namespace, name)]
+	if !ok {
+		return nil
+	}
+
+	ports := make([]mcsv1a1.ServicePort, len(si.ports))
+	for i := range si.ports {
+		ports[i] = *si.ports[i].DeepCopy()
+	}
+
+	return ports
+}
+
+// updateGlobalName moves the service's claim from its previous global name to its current one. It must be called with
+// the lock held.
+func (m *Map) updateGlobalName(si *serviceInfo, previous string) {
+	if previous == si.globalName {
+		return
+	}
+
+	if previous != "" {
+		delete(m.globalNames[previous], si.key)
+
+		if len(m.globalNames[previous]) == 0 {
+			delete(m.globalNames, previous)
+		}
+	}
+
+	if si.globalName == "" {
+		return
+	}
+
+	if m.globalNames[si.globalName] == nil {
+		m.globalNames[si.globalName] = map[string]bool{}
+	}
+
+	m.globalNames[si.globalName][si.key] = true
+
+	if m.isGlobalNameAllowed(si.key) && len(m.allowedGlobalNameClaims(si.globalName)) > 1 {
+		klog.Warningf("The global name %q is claimed by several services - it resolves to %q, exported first",
+			si.globalName, m.globalNameOwner(si.globalName))
+	}
+}
+
+// SetGlobalNameAllowlist sets the services allowed to claim a global name, each given as a namespace, allowing all its
+// services, or as namespace/name. The claims of the other services are ignored, so they can't take a global name over.
+func (m *Map) SetGlobalNameAllowlist(allowed ...string) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.globalNameAllowlist = map[string]bool{}
+	for _, entry := range allowed {
+		m.globalNameAllowlist[entry] = true
+	}
+}
+
+// isGlobalNameAllowed returns whether the service with the given key may claim a global name. It must be called with
+// the lock held.
+func (m *Map) isGlobalNameAllowed(key string) bool {
+	return m.globalNameAllowlist[key] || m.globalNameAllowlist[strings.SplitN(key, "/", 2)[0]]
+}
+
+// allowedGlobalNameClaims returns the keys of the services allowed to claim the global name among those claiming it.
+// It must be called with the lock held.
+func (m *Map) allowedGlobalNameClaims(globalName string) []string {
+	keys := []string{}
+
+	for key := range m.globalNames[globalName] {
+		if m.isGlobalNameAllowed(key) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// globalNameOwner returns the key of the service the global name resolves to, among those allowed to claim it. If
+// several services claim it, it's the one exported first, with ties broken by key. It must be called with the lock
+// held.
+func (m *Map) globalNameOwner(globalName string) string {
+	owner := ""
+
+	for _, key := range m.allowedGlobalNameClaims(globalName) {
+		if owner == "" {
+			owner = key
+			continue
+		}
+
+		t, ownerTime := m.svcMap[key].globalNameTime, m.svcMap[owner].globalNameTime
+		if t.Before(ownerTime) || (t.Equal(ownerTime) && key < owner) {
+			owner = key
+		}
+	}
+
+	return owner
+}
+
+// GetServiceForGlobalName returns the namespace and name of the service the given global name resolves to.
+func (m *Map) GetServiceForGlobalName(globalName string) (namespace, name string, found bool) {
+	m.RLock()
+	defer m.RUnlock()
+
+	key := m.globalNameOwner(globalName)
+	if key == "" {
+		return "", "", false
+	}
+
+	parts := strings.SplitN(key, "/", 2)
+
+	return parts[0], parts[1], true
+}
+
+// GetServiceForUID returns the namespace and name of the service identified by the given clusterset UID.
+func (m *Map) GetServiceForUID(uid string) (namespace, name string, found bool) {
+	m.RLock()
+	defer m.RUnlock()
+
+	key, found := m.uids[uid]
+	if !found {
+		return "", "", false
+	}
+
+	parts := strings.SplitN(key, "/", 2)
+
+	return parts[0], parts[1], true
+}
+
+// clusterIP returns the exporting cluster's IP of a ClusterSetIP ServiceImport.
+func clusterIP(serviceImport *mcsv1a1.ServiceImport) string {
+	if ip := serviceImport.Annotations[lhconstants.AnnotationClusterIP]; ip != "" {
+		return ip
+	}
+
+	if len(serviceImport.Spec.IPs) > 0 {
+		return serviceImport.Spec.IPs[0]
+	}
+
+	return ""
+}
+
+// ClustersetIPs returns the IPv4 and IPv6 clusterset VIPs of a ClusterSetIP ServiceImport, ie its spec.ips other than
+// the exporting cluster's IP, or "" for a family it has none of.
+func ClustersetIPs(serviceImport *mcsv1a1.ServiceImport) (ipv4, ipv6 string) {
+	ip := clusterIP(serviceImport)
+
+	for _, vip := range serviceImport.Spec.IPs {
+		parsed := net.ParseIP(vip)
+		if vip == ip || parsed == nil {
+			continue
+		}
+
+		if parsed.To4() != nil {
+			ipv4 = vip
+		} else {
+			ipv6 = vip
 		}
 	}
+
+	return ipv4, ipv6
 }
 
 func keyFunc(namespace, name string) string {
@@ -16,9 +16,15 @@ limitations under the License.
 package serviceimport_test
 
 import (
+	"fmt"
+	"time"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	lhconstants "github.com/submariner-io/lighthouse/pkg/constants"
 	"github.com/submariner-io/lighthouse/pkg/serviceimport"
+	corev1 "k8s.io/api/core/v1"
+	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
 )
 
 var _ = Describe("ServiceImport Map", func() {
@@ -173,6 +179,12 @@ var _ = Describe("ServiceImport Map", func() {
 				testRoundRobin(namespace1, service1, "", "", ips)
 			})
 		})
+
+		It("should return the cluster for each IP", func() {
+			Expect(serviceImportMap.GetClusterForIP(namespace1, service1, serviceIP1)).To(Equal(clusterID1))
+			Expect(serviceImportMap.GetClusterForIP(namespace1, service1, serviceIP2)).To(Equal(clusterID2))
+			Expect(serviceImportMap.GetClusterForIP(namespace1, service1, "1.2.3.4")).To(BeEmpty())
+		})
 	})
 
 	When("a service is present in three connected clusters", func() {
@@ -250,6 +262,23 @@ var _ = Describe("ServiceImport Map", func() {
 		})
 	})
 
+	When("services exist in two namespaces", func() {
+		It("should list them sorted by namespace and name", func() {
+			serviceImportMap.Put(newServiceImport(namespace2, service1, serviceIP2, clusterID1))
+			serviceImportMap.Put(newServiceImport(namespace1, service1, serviceIP1, clusterID1))
+			serviceImportMap.Put(newServiceImport(namespace1, service1, serviceIP2, clusterID2))
+
+			services := serviceImportMap.List()
+			Expect(services).To(HaveLen(2))
+			Expect(services[0].Namespace).To(Equal(namespace1))
+			Expect(services[0].Name).To(Equal(service1))
+			Expect(services[0].Type).To(Equal(mcsv1a1.ClusterSetIP))
+			Expect(services[0].ClusterIPs).To(Equal(map[string]string{clusterID1: serviceIP1, clusterID2: serviceIP2}))
+			Expect(services[1].Namespace).To(Equal(namespace2))
+			Expect(services[1].ClusterIPs).To(Equal(map[string]string{clusterID1: serviceIP2}))
+		})
+	})
+
 	When("a service does not exist", func() {
 		It("should return not found", func() {
 			expectIPsNotFound(namespace1, service1, "", "")
@@ -266,6 +295,21 @@ var _ = Describe("ServiceImport Map", func() {
 		})
 	})
 
+	When("a change handler is registered", func() {
+		It("should notify it of the service whose exports are put or removed", func() {
+			changed := []string{}
+			serviceImportMap.OnChange(func(namespace, name string) {
+				changed = append(changed, namespace+"/"+name)
+			})
+
+			si := newServiceImport(namespace1, service1, serviceIP1, clusterID1)
+			serviceImportMap.Put(si)
+			serviceImportMap.Remove(si)
+
+			Expect(changed).To(Equal([]string{namespace1 + "/" + service1, namespace1 + "/" + service1}))
+		})
+	})
+
 	When("a service present in one cluster is subsequently removed", func() {
 		It("should return not found", func() {
 			si := newServiceImport(namespace1, service1, serviceIP1, clusterID1)
@@ -294,4 +338,770 @@ var _ = Describe("ServiceImport Map", func() {
 			}
 		})
 	})
+
+	When("a service is exported as variants from two clusters", func() {
+		BeforeEach(func() {
+			si1 := newServiceImport(namespace1, service1, serviceIP1, clusterID1)
+			si1.Annotations[lhconstants.AnnotationVariant] = "blue"
+			serviceImportMap.Put(si1)
+
+			serviceImportMap.Put(newServiceImport(namespace1, service1, serviceIP2, clusterID2))
+		})
+
+		It("should return each cluster's variant", func() {
+			Expect(serviceImportMap.GetClusterVariant(namespace1, service1, clusterID1)).To(Equal("blue"))
+			Expect(serviceImportMap.GetClusterVariant(namespace1, service1, clusterID2)).To(BeEmpty())
+			Expect(serviceImportMap.GetClusterVariant(namespace2, service1, clusterID1)).To(BeEmpty())
+		})
+
+		It("should distinguish variant names from cluster IDs", func() {
+			Expect(serviceImportMap.IsVariant(namespace1, service1, "blue")).To(BeTrue())
+			Expect(serviceImportMap.IsVariant(namespace1, service1, "green")).To(BeFalse())
+			Expect(serviceImportMap.IsVariant(namespace1, service1, clusterID1)).To(BeFalse())
+		})
+	})
+	When("a service with ClientIP session affinity is present in three connected clusters", func() {
+		clients := make([]string, 50)
+		for i := range clients {
+			clients[i] = fmt.Sprintf("10.240.0.%d", i+1)
+		}
+
+		getClientIP := func(client string) string {
+			ip, found, _ := serviceImportMap.GetIPForClient(namespace1, service1, "", "", client, checkCluster, checkEndpoint)
+			Expect(found).To(BeTrue())
+			return ip
+		}
+
+		BeforeEach(func() {
+			for cluster, ip := range map[string]string{clusterID1: serviceIP1, clusterID2: serviceIP2, clusterID3: serviceIP3} {
+				si := newServiceImport(namespace1, service1, ip, cluster)
+				si.Spec.SessionAffinity = corev1.ServiceAffinityClientIP
+				serviceImportMap.Put(si)
+			}
+		})
+
+		It("should consistently return the same IP per client", func() {
+			Expect(serviceImportMap.HasClientIPAffinity(namespace1, service1)).To(BeTrue())
+
+			ips := map[string]bool{}
+			for _, client := range clients {
+				ip := getClientIP(client)
+				ips[ip] = true
+
+				for i := 0; i < 5; i++ {
+					Expect(getClientIP(client)).To(Equal(ip))
+				}
+			}
+
+			Expect(ips).To(HaveLen(3))
+		})
+
+		When("a cluster is subsequently disconnected", func() {
+			It("should only move the clients from the disconnected cluster", func() {
+				before := map[string]string{}
+				for _, client := range clients {
+					before[client] = getClientIP(client)
+				}
+
+				clusterStatusMap[clusterID2] = false
+
+				for _, client := range clients {
+					ip := getClientIP(client)
+					if before[client] == serviceIP2 {
+						Expect(ip).ToNot(Equal(serviceIP2))
+					} else {
+						Expect(ip).To(Equal(before[client]))
+					}
+				}
+			})
+		})
+
+		When("a cluster's endpoints subsequently become unhealthy", func() {
+			It("should only move the clients from the unhealthy cluster", func() {
+				before := map[string]string{}
+				for _, client := range clients {
+					before[client] = getClientIP(client)
+				}
+
+				endpointStatusMap[clusterID3] = false
+
+				for _, client := range clients {
+					ip := getClientIP(client)
+					if before[client] == serviceIP3 {
+						Expect(ip).ToNot(Equal(serviceIP3))
+					} else {
+						Expect(ip).To(Equal(before[client]))
+					}
+				}
+			})
+		})
+	})
+
+	When("a service with a clusterset IP is present in two connected clusters", func() {
+		const clustersetIP = "243.0.0.1"
+
+		BeforeEach(func() {
+			for cluster, ip := range map[string]string{clusterID1: serviceIP1, clusterID2: serviceIP2} {
+				si := newServiceImport(namespace1, service1, ip, cluster)
+				si.Annotations[lhconstants.AnnotationClusterIP] = ip
+				si.Spec.IPs = []string{clustersetIP}
+				serviceImportMap.Put(si)
+			}
+		})
+
+		It("should consistently return the clusterset IP regardless of local cluster", func() {
+			for i := 0; i < 5; i++ {
+				Expect(getIP(namespace1, service1)).To(Equal(clustersetIP))
+				Expect(getIPExpectFound(namespace1, service1, "", clusterID1)).To(Equal(clustersetIP))
+			}
+		})
+
+		It("should return the cluster's IP when a cluster is specified", func() {
+			Expect(getClusterIP(namespace1, service1, clusterID2)).To(Equal(serviceIP2))
+		})
+
+		It("should return the clusterset IP from GetClustersetIP", func() {
+			Expect(serviceImportMap.GetClustersetIP(namespace1, service1)).To(Equal(clustersetIP))
+			Expect(serviceImportMap.GetClustersetIP(namespace2, service1)).To(BeEmpty())
+		})
+
+		It("should return no IPv6 clusterset IP from GetClustersetIPv6", func() {
+			Expect(serviceImportMap.GetClustersetIPv6(namespace1, service1)).To(BeEmpty())
+		})
+
+		When("the service also has an IPv6 clusterset IP", func() {
+			BeforeEach(func() {
+				si := newServiceImport(namespace1, service1, serviceIP1, clusterID1)
+				si.Annotations[lhconstants.AnnotationClusterIP] = serviceIP1
+				si.Spec.IPs = []string{clustersetIP, "fd00:243::1"}
+				serviceImportMap.Put(si)
+			})
+
+			It("should return both clusterset IPs", func() {
+				Expect(serviceImportMap.GetClustersetIP(namespace1, service1)).To(Equal(clustersetIP))
+				Expect(serviceImportMap.GetClustersetIPv6(namespace1, service1)).To(Equal("fd00:243::1"))
+				Expect(serviceImportMap.List()[0].ClustersetIPv6).To(Equal("fd00:243::1"))
+			})
+		})
+
+		When("both clusters are disconnected", func() {
+			It("should return no IP", func() {
+				clusterStatusMap[clusterID1] = false
+				clusterStatusMap[clusterID2] = false
+
+				Expect(getIP(namespace1, service1)).To(BeEmpty())
+			})
+		})
+	})
+
+	When("a service without session affinity is queried for a client", func() {
+		BeforeEach(func() {
+			serviceImportMap.Put(newServiceImport(namespace1, service1, serviceIP1, clusterID1))
+			serviceImportMap.Put(newServiceImport(namespace1, service1, serviceIP2, clusterID2))
+		})
+
+		It("should return the IPs round-robin", func() {
+			Expect(serviceImportMap.HasClientIPAffinity(namespace1, service1)).To(BeFalse())
+
+			first, _, _ := serviceImportMap.GetIPForClient(namespace1, service1, "", "", "10.240.0.1", checkCluster, checkEndpoint)
+			second, _, _ := serviceImportMap.GetIPForClient(namespace1, service1, "", "", "10.240.0.1", checkCluster, checkEndpoint)
+			Expect([]string{first, second}).To(ConsistOf(serviceIP1, serviceIP2))
+		})
+	})
+
+	When("a service is exported with explicit weights from two connected clusters", func() {
+		BeforeEach(func() {
+			si := newServiceImport(namespace1, service1, serviceIP1, clusterID1)
+			si.Annotations[lhconstants.AnnotationWeight] = "3"
+			serviceImportMap.Put(si)
+
+			si = newServiceImport(namespace1, service1, serviceIP2, clusterID2)
+			si.Annotations[lhconstants.AnnotationWeight] = "1"
+			serviceImportMap.Put(si)
+		})
+
+		It("should return the IPs in proportion to the weights", func() {
+			counts := map[string]int{}
+			for i := 0; i < 40; i++ {
+				counts[getIP(namespace1, service1)]++
+			}
+
+			Expect(counts).To(Equal(map[string]int{serviceIP1: 30, serviceIP2: 10}))
+		})
+
+		It("should interleave the IPs of the heavier cluster", func() {
+			ips := make([]string, 4)
+			for i := range ips {
+				ips[i] = getIP(namespace1, service1)
+			}
+
+			Expect(ips).To(ConsistOf(serviceIP1, serviceIP1, serviceIP1, serviceIP2))
+			Expect(ips[0]).To(Equal(serviceIP1))
+			Expect(ips[3]).To(Equal(serviceIP1))
+		})
+
+		It("should check each cluster once per query", func() {
+			clusterStatusMap[clusterID1] = false
+			checks := map[string]int{}
+
+			_, _, _ = serviceImportMap.GetIP(namespace1, service1, "", "", checkCluster,
+				func(name, namespace, id string) bool {
+					checks[id]++
+					return endpointStatusMap[id]
+				})
+
+			Expect(checks).To(Equal(map[string]int{clusterID2: 1}))
+
+			clusterStatusMap[clusterID1] = true
+			endpointStatusMap[clusterID1] = false
+			endpointStatusMap[clusterID2] = false
+			checks = map[string]int{}
+
+			_, _, _ = serviceImportMap.GetIP(namespace1, service1, "", "", checkCluster,
+				func(name, namespace, id string) bool {
+					checks[id]++
+					return endpointStatusMap[id]
+				})
+
+			Expect(checks).To(Equal(map[string]int{clusterID1: 1, clusterID2: 1}))
+		})
+
+		When("the heavier cluster is disconnected", func() {
+			It("should consistently return the IP of the other cluster", func() {
+				clusterStatusMap[clusterID1] = false
+				for i := 0; i < 10; i++ {
+					Expect(getIP(namespace1, service1)).To(Equal(serviceIP2))
+				}
+			})
+		})
+
+		When("the local cluster is specified", func() {
+			It("should consistently return its IP regardless of the weights", func() {
+				for i := 0; i < 10; i++ {
+					Expect(getIPExpectFound(namespace1, service1, "", clusterID2)).To(Equal(serviceIP2))
+				}
+			})
+		})
+	})
+
+	When("a service is exported from a cluster within its export delay", func() {
+		BeforeEach(func() {
+			si := newServiceImport(namespace1, service1, serviceIP1, clusterID1)
+			si.Annotations[lhconstants.AnnotationExportDelay] = "30s"
+			si.Annotations[lhconstants.AnnotationExportPending] = "true"
+			serviceImportMap.Put(si)
+			serviceImportMap.Put(newServiceImport(namespace1, service1, serviceIP2, clusterID2))
+		})
+
+		It("should report the export as pending until the exporting cluster clears the flag", func() {
+			Expect(serviceImportMap.IsPending(namespace1, service1, clusterID1)).To(BeTrue())
+			Expect(serviceImportMap.IsPending(namespace1, service1, clusterID2)).To(BeFalse())
+
+			si := newServiceImport(namespace1, service1, serviceIP1, clusterID1)
+			si.Annotations[lhconstants.AnnotationExportDelay] = "30s"
+			serviceImportMap.Put(si)
+
+			Expect(serviceImportMap.IsPending(namespace1, service1, clusterID1)).To(BeFalse())
+		})
+	})
+
+	When("a service is exported with an export delay the exporting cluster doesn't flag as pending", func() {
+		It("should not report the export as pending", func() {
+			si := newServiceImport(namespace1, service1, serviceIP1, clusterID1)
+			si.Annotations[lhconstants.AnnotationExportTime] = time.Now().UTC().Format(time.RFC3339)
+			si.Annotations[lhconstants.AnnotationExportDelay] = "1h"
+			serviceImportMap.Put(si)
+
+			Expect(serviceImportMap.IsPending(namespace1, service1, clusterID1)).To(BeFalse())
+		})
+	})
+
+	When("a service moves from one cluster to another", func() {
+		var now time.Time
+
+		newDrainingServiceImport := func(serviceIP, clusterID string) *mcsv1a1.ServiceImport {
+			si := newServiceImport(namespace1, service1, serviceIP, clusterID)
+			si.Annotations[lhconstants.AnnotationDraining] = "10m"
+
+			return si
+		}
+
+		expectAnswers := func(localCluster string, ips ...string) {
+			for i := 0; i < 10; i++ {
+				Expect(getIPExpectFound(namespace1, service1, "", localCluster)).To(BeElementOf(ips))
+			}
+		}
+
+		// countAnswers returns how many of the given number of answers are from the source cluster.
+		countAnswers := func(n int, localCluster string) int {
+			count := 0
+
+			for i := 0; i < n; i++ {
+				ip := getIPExpectFound(namespace1, service1, "", localCluster)
+				Expect(ip).To(BeElementOf(serviceIP1, serviceIP2))
+
+				if ip == serviceIP1 {
+					count++
+				}
+			}
+
+			return count
+		}
+
+		BeforeEach(func() {
+			now = time.Now()
+			serviceimport.SetClock(serviceImportMap, func() time.Time {
+				return now
+			})
+
+			serviceImportMap.Put(newServiceImport(namespace1, service1, serviceIP1, clusterID1))
+		})
+
+		It("should phase the source cluster out as the target cluster phases in, without an empty answer", func() {
+			expectAnswers("", serviceIP1)
+
+			// Phase 1: the source cluster is marked draining and the target cluster exports the service, not yet healthy.
+			endpointStatusMap[clusterID2] = false
+			serviceImportMap.Put(newDrainingServiceImport(serviceIP1, clusterID1))
+			serviceImportMap.Put(newServiceImport(namespace1, service1, serviceIP2, clusterID2))
+			expectAnswers("", serviceIP1)
+
+			// Phase 2: the target cluster becomes healthy and takes over the source cluster's share as its weight decays.
+			endpointStatusMap[clusterID2] = true
+			Expect(countAnswers(20, "")).To(Equal(10))
+
+			now = now.Add(5 * time.Minute)
+			Expect(countAnswers(15, "")).To(Equal(5))
+
+			// An update of the source cluster's export doesn't restart the decay.
+			serviceImportMap.Put(newDrainingServiceImport(serviceIP1, clusterID1))
+			Expect(countAnswers(15, "")).To(Equal(5))
+
+			now = now.Add(time.Hour)
+			Expect(countAnswers(110, "")).To(Equal(10))
+
+			// The source cluster still answers if the target cluster fails.
+			clusterStatusMap[clusterID2] = false
+			expectAnswers("", serviceIP1)
+			clusterStatusMap[clusterID2] = true
+
+			serviceImportMap.Remove(newDrainingServiceImport(serviceIP1, clusterID1))
+			expectAnswers("", serviceIP2)
+		})
+
+		It("should decay the source cluster's weight over the default period if none is given", func() {
+			si := newServiceImport(namespace1, service1, serviceIP1, clusterID1)
+			si.Annotations[lhconstants.AnnotationDraining] = "true"
+			serviceImportMap.Put(si)
+			serviceImportMap.Put(newServiceImport(namespace1, service1, serviceIP2, clusterID2))
+			Expect(countAnswers(20, "")).To(Equal(10))
+
+			now = now.Add(150 * time.Second)
+			Expect(countAnswers(15, "")).To(Equal(5))
+		})
+
+		It("should not prefer the source cluster as the local cluster while it's draining", func() {
+			serviceImportMap.Put(newDrainingServiceImport(serviceIP1, clusterID1))
+			serviceImportMap.Put(newServiceImport(namespace1, service1, serviceIP2, clusterID2))
+			Expect(countAnswers(20, clusterID1)).To(Equal(10))
+
+			endpointStatusMap[clusterID2] = false
+			expectAnswers(clusterID1, serviceIP1)
+		})
+	})
+
+	When("a service is exported with an out of range weight", func() {
+		BeforeEach(func() {
+			si := newServiceImport(namespace1, service1, serviceIP1, clusterID1)
+			si.Annotations[lhconstants.AnnotationWeight] = "1000000"
+			serviceImportMap.Put(si)
+			serviceImportMap.Put(newServiceImport(namespace1, service1, serviceIP2, clusterID2))
+		})
+
+		It("should cap the weight", func() {
+			counts := map[string]int{}
+			for i := 0; i < lhconstants.MaxWeight+1; i++ {
+				counts[getIP(namespace1, service1)]++
+			}
+
+			Expect(counts).To(Equal(map[string]int{serviceIP1: lhconstants.MaxWeight, serviceIP2: 1}))
+		})
+	})
+
+	When("a service is exported from multiple clusters", func() {
+		now := time.Now()
+
+		newExport := func(serviceIP, clusterID string, sType mcsv1a1.ServiceImportType, age time.Duration) *mcsv1a1.ServiceImport {
+			si := newServiceImport(namespace1, service1, serviceIP, clusterID)
+			si.Spec.Type = sType
+			si.Annotations[lhconstants.AnnotationExportTime] = now.Add(-age).UTC().Format(time.RFC3339)
+
+			return si
+		}
+
+		Context("with the same type", func() {
+			BeforeEach(func() {
+				serviceImportMap.Put(newExport(serviceIP1, clusterID1, mcsv1a1.ClusterSetIP, time.Hour))
+				serviceImportMap.Put(newExport(serviceIP2, clusterID2, mcsv1a1.ClusterSetIP, time.Minute))
+			})
+
+			It("should merge the exports", func() {
+				testRoundRobin(namespace1, service1, "", "", []string{serviceIP1, serviceIP2})
+				Expect(serviceImportMap.IsMerged(namespace1, service1, clusterID1)).To(BeTrue())
+				Expect(serviceImportMap.IsMerged(namespace1, service1, clusterID2)).To(BeTrue())
+			})
+		})
+
+		Context("with conflicting types and the oldest is ClusterSetIP", func() {
+			BeforeEach(func() {
+				serviceImportMap.Put(newExport(serviceIP2, clusterID2, mcsv1a1.Headless, time.Minute))
+				serviceImportMap.Put(newExport(serviceIP1, clusterID1, mcsv1a1.ClusterSetIP, time.Hour))
+				serviceImportMap.Put(newExport(serviceIP3, clusterID3, mcsv1a1.ClusterSetIP, time.Second))
+			})
+
+			It("should resolve to ClusterSetIP and exclude the conflicting export", func() {
+				testRoundRobin(namespace1, service1, "", "", []string{serviceIP1, serviceIP3})
+				expectIPsNotFound(namespace1, service1, clusterID2, "")
+				Expect(serviceImportMap.IsMerged(namespace1, service1, clusterID2)).To(BeFalse())
+				Expect(serviceImportMap.IsMerged(namespace1, service1, clusterID3)).To(BeTrue())
+			})
+		})
+
+		Context("with conflicting types and the oldest is Headless", func() {
+			BeforeEach(func() {
+				serviceImportMap.Put(newExport(serviceIP1, clusterID1, mcsv1a1.ClusterSetIP, time.Minute))
+				serviceImportMap.Put(newExport(serviceIP2, clusterID2, mcsv1a1.Headless, time.Hour))
+			})
+
+			It("should resolve to Headless and exclude the conflicting export", func() {
+				expectIPsNotFound(namespace1, service1, "", "")
+				expectIPsNotFound(namespace1, service1, clusterID1, "")
+				Expect(serviceImportMap.IsMerged(namespace1, service1, clusterID1)).To(BeFalse())
+				Expect(serviceImportMap.IsMerged(namespace1, service1, clusterID2)).To(BeTrue())
+			})
+
+			When("the oldest export is subsequently removed", func() {
+				It("should resolve to the type of the remaining export", func() {
+					serviceImportMap.Remove(newExport(serviceIP2, clusterID2, mcsv1a1.Headless, time.Hour))
+
+					Expect(getIP(namespace1, service1)).To(Equal(serviceIP1))
+					Expect(serviceImportMap.IsMerged(namespace1, service1, clusterID1)).To(BeTrue())
+				})
+			})
+		})
+
+		Context("with conflicting types and the same export time", func() {
+			BeforeEach(func() {
+				serviceImportMap.Put(newExport(serviceIP2, clusterID2, mcsv1a1.Headless, time.Hour))
+				serviceImportMap.Put(newExport(serviceIP1, clusterID1, mcsv1a1.ClusterSetIP, time.Hour))
+			})
+
+			It("should resolve to the type of the export with the lowest cluster ID", func() {
+				Expect(getIP(namespace1, service1)).To(Equal(serviceIP1))
+				Expect(serviceImportMap.IsMerged(namespace1, service1, clusterID2)).To(BeFalse())
+			})
+		})
+	})
+
+	When("a singleton service is present in three connected clusters", func() {
+		BeforeEach(func() {
+			for cluster, ip := range map[string]string{clusterID1: serviceIP2, clusterID2: serviceIP3, clusterID3: serviceIP1} {
+				si := newServiceImport(namespace1, service1, ip, cluster)
+				si.Annotations[lhconstants.AnnotationSingleton] = "true"
+				serviceImportMap.Put(si)
+			}
+		})
+
+		getPrimaryIP := func(localCluster string) string {
+			ip, found, isLocal := serviceImportMap.GetPrimaryIP(namespace1, service1, localCluster, checkCluster, checkEndpoint)
+			Expect(found).To(BeTrue())
+			Expect(isLocal).To(Equal(localCluster == clusterID3 && ip == serviceIP1))
+
+			return ip
+		}
+
+		It("should consistently return the lowest IP regardless of the local cluster", func() {
+			Expect(serviceImportMap.IsSingleton(namespace1, service1)).To(BeTrue())
+
+			for i := 0; i < 5; i++ {
+				Expect(getPrimaryIP("")).To(Equal(serviceIP1))
+				Expect(getPrimaryIP(clusterID1)).To(Equal(serviceIP1))
+				Expect(getPrimaryIP(clusterID3)).To(Equal(serviceIP1))
+			}
+		})
+
+		When("the primary cluster is subsequently disconnected", func() {
+			It("should fail over to the next lowest IP", func() {
+				clusterStatusMap[clusterID3] = false
+				Expect(getPrimaryIP("")).To(Equal(serviceIP2))
+
+				clusterStatusMap[clusterID3] = true
+				Expect(getPrimaryIP("")).To(Equal(serviceIP1))
+			})
+		})
+
+		When("the primary cluster's endpoints subsequently become unhealthy", func() {
+			It("should fail over to the next lowest IP", func() {
+				endpointStatusMap[clusterID3] = false
+				Expect(getPrimaryIP("")).To(Equal(serviceIP2))
+			})
+		})
+
+		When("the primary cluster is draining", func() {
+			It("should only return it once no other cluster is eligible", func() {
+				si := newServiceImport(namespace1, service1, serviceIP1, clusterID3)
+				si.Annotations[lhconstants.AnnotationSingleton] = "true"
+				si.Annotations[lhconstants.AnnotationDraining] = "true"
+				serviceImportMap.Put(si)
+
+				Expect(getPrimaryIP("")).To(Equal(serviceIP2))
+
+				clusterStatusMap[clusterID1] = false
+				clusterStatusMap[clusterID2] = false
+				Expect(getPrimaryIP("")).To(Equal(serviceIP1))
+			})
+		})
+
+		When("no cluster is eligible", func() {
+			It("should return found with an empty IP", func() {
+				clusterStatusMap = map[string]bool{}
+				Expect(getPrimaryIP("")).To(BeEmpty())
+			})
+		})
+	})
+
+	When("a service is exported with a Local internalTrafficPolicy by one of two clusters", func() {
+		BeforeEach(func() {
+			si := newServiceImport(namespace1, service1, serviceIP1, clusterID1)
+			si.Annotations[lhconstants.AnnotationInternalTrafficPolicy] = lhconstants.InternalTrafficPolicyLocal
+			serviceImportMap.Put(si)
+
+			si = newServiceImport(namespace1, service1, serviceIP2, clusterID2)
+			si.Annotations[lhconstants.AnnotationInternalTrafficPolicy] = "Cluster"
+			serviceImportMap.Put(si)
+		})
+
+		It("should only report the Local policy for that cluster", func() {
+			Expect(serviceImportMap.HasLocalTrafficPolicy(namespace1, service1, clusterID1)).To(BeTrue())
+			Expect(serviceImportMap.HasLocalTrafficPolicy(namespace1, service1, clusterID2)).To(BeFalse())
+			Expect(serviceImportMap.HasLocalTrafficPolicy(namespace1, service1, clusterID3)).To(BeFalse())
+			Expect(serviceImportMap.HasLocalTrafficPolicy(namespace2, service1, clusterID1)).To(BeFalse())
+		})
+	})
+
+	When("a service isn't requested as a singleton", func() {
+		It("should not be a singleton", func() {
+			serviceImportMap.Put(newServiceImport(namespace1, service1, serviceIP1, clusterID1))
+			Expect(serviceImportMap.IsSingleton(namespace1, service1)).To(BeFalse())
+		})
+	})
+
+	When("a service specifies a minimum number of clusters", func() {
+		It("should return the value of the oldest export", func() {
+			si1 := newServiceImport(namespace1, service1, serviceIP1, clusterID1)
+			si1.Annotations[lhconstants.AnnotationExportTime] = time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+			si1.Annotations[lhconstants.AnnotationMinClusters] = "2"
+			serviceImportMap.Put(si1)
+
+			si2 := newServiceImport(namespace1, service1, serviceIP2, clusterID2)
+			si2.Annotations[lhconstants.AnnotationExportTime] = time.Now().UTC().Format(time.RFC3339)
+			si2.Annotations[lhconstants.AnnotationMinClusters] = "3"
+			serviceImportMap.Put(si2)
+
+			Expect(serviceImportMap.GetMinClusters(namespace1, service1)).To(Equal(2))
+		})
+	})
+
+	When("a service is exported with conflicting DNS priorities", func() {
+		It("should apply the priority of the oldest export", func() {
+			si1 := newServiceImport(namespace1, service1, serviceIP1, clusterID1)
+			si1.Annotations[lhconstants.AnnotationExportTime] = time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+			si1.Annotations[lhconstants.AnnotationDNSPriority] = lhconstants.DNSPriorityClusterset
+			serviceImportMap.Put(si1)
+
+			si2 := newServiceImport(namespace1, service1, serviceIP2, clusterID2)
+			si2.Annotations[lhconstants.AnnotationExportTime] = time.Now().UTC().Format(time.RFC3339)
+			si2.Annotations[lhconstants.AnnotationDNSPriority] = lhconstants.DNSPriorityLocal
+			serviceImportMap.Put(si2)
+
+			Expect(serviceImportMap.HasClustersetPriority(namespace1, service1)).To(BeTrue())
+
+			serviceImportMap.Remove(si1)
+			Expect(serviceImportMap.HasClustersetPriority(namespace1, service1)).To(BeFalse())
+		})
+	})
+
+	When("a service is exported with conflicting port appProtocols", func() {
+		It("should merge the ports with the appProtocol of the oldest export", func() {
+			h2c, grpc := "kubernetes.io/h2c", "grpc"
+
+			si1 := newServiceImport(namespace1, service1, serviceIP1, clusterID1)
+			si1.Annotations[lhconstants.AnnotationExportTime] = time.Now().UTC().Format(time.RFC3339)
+			si1.Spec.Ports = []mcsv1a1.ServicePort{{Name: "api", Protocol: corev1.ProtocolTCP, Port: 8080, AppProtocol: &grpc}}
+			serviceImportMap.Put(si1)
+
+			si2 := newServiceImport(namespace1, service1, serviceIP2, clusterID2)
+			si2.Annotations[lhconstants.AnnotationExportTime] = time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+			si2.Spec.Ports = []mcsv1a1.ServicePort{
+				{Name: "api", Protocol: corev1.ProtocolTCP, Port: 8080, AppProtocol: &h2c},
+				{Name: "metrics", Protocol: corev1.ProtocolTCP, Port: 9090},
+			}
+			serviceImportMap.Put(si2)
+
+			ports := serviceImportMap.GetPorts(namespace1, service1)
+			Expect(ports).To(HaveLen(2))
+			Expect(ports[0].Name).To(Equal("api"))
+			Expect(*ports[0].AppProtocol).To(Equal(h2c))
+			Expect(ports[1].Name).To(Equal("metrics"))
+			Expect(ports[1].AppProtocol).To(BeNil())
+
+			serviceImportMap.Remove(si2)
+
+			ports = serviceImportMap.GetPorts(namespace1, service1)
+			Expect(ports).To(HaveLen(1))
+			Expect(*ports[0].AppProtocol).To(Equal(grpc))
+		})
+	})
+
+	When("a service does not specify a minimum number of clusters", func() {
+		It("should return 1", func() {
+			serviceImportMap.Put(newServiceImport(namespace1, service1, serviceIP1, clusterID1))
+			Expect(serviceImportMap.GetMinClusters(namespace1, service1)).To(Equal(1))
+			Expect(serviceImportMap.GetMinClusters(namespace2, service1)).To(Equal(1))
+		})
+	})
+
+	When("services claim a global name", func() {
+		const globalName = "registry"
+
+		newGlobalServiceImport := func(namespace, clusterID string, age time.Duration) *mcsv1a1.ServiceImport {
+			si := newServiceImport(namespace, service1, serviceIP1, clusterID)
+			si.Annotations[lhconstants.AnnotationGlobalName] = globalName
+			si.Annotations[lhconstants.AnnotationExportTime] = time.Now().Add(-age).UTC().Format(time.RFC3339)
+
+			return si
+		}
+
+		BeforeEach(func() {
+			serviceImportMap.SetGlobalNameAllowlist(namespace1, namespace2+"/"+service1)
+		})
+
+		It("should resolve it to the service exported first until it's removed", func() {
+			older := newGlobalServiceImport(namespace1, clusterID1, time.Hour)
+			serviceImportMap.Put(newGlobalServiceImport(namespace2, clusterID1, time.Minute))
+			serviceImportMap.Put(older)
+
+			namespace, name, found := serviceImportMap.GetServiceForGlobalName(globalName)
+			Expect(found).To(BeTrue())
+			Expect(namespace).To(Equal(namespace1))
+			Expect(name).To(Equal(service1))
+
+			serviceImportMap.Remove(older)
+
+			namespace, _, found = serviceImportMap.GetServiceForGlobalName(globalName)
+			Expect(found).To(BeTrue())
+			Expect(namespace).To(Equal(namespace2))
+		})
+
+		It("should no longer resolve it once the annotation is removed", func() {
+			si := newGlobalServiceImport(namespace1, clusterID1, time.Hour)
+			serviceImportMap.Put(si)
+
+			delete(si.Annotations, lhconstants.AnnotationGlobalName)
+			serviceImportMap.Put(si)
+
+			_, _, found := serviceImportMap.GetServiceForGlobalName(globalName)
+			Expect(found).To(BeFalse())
+		})
+
+		It("should ignore the claims of the services outside the allowlist", func() {
+			serviceImportMap.SetGlobalNameAllowlist(namespace2)
+			serviceImportMap.Put(newGlobalServiceImport(namespace1, clusterID1, time.Hour))
+
+			_, _, found := serviceImportMap.GetServiceForGlobalName(globalName)
+			Expect(found).To(BeFalse())
+
+			serviceImportMap.Put(newGlobalServiceImport(namespace2, clusterID1, time.Minute))
+
+			namespace, _, found := serviceImportMap.GetServiceForGlobalName(globalName)
+			Expect(found).To(BeTrue())
+			Expect(namespace).To(Equal(namespace2))
+		})
+	})
+
+	When("a service has a clusterset UID", func() {
+		const uid = "0fb1a5c4-8f24-5c1e-a9d5-3b4b6e3f6d7a"
+
+		BeforeEach(func() {
+			for _, cluster := range []string{clusterID1, clusterID2} {
+				si := newServiceImport(namespace1, service1, serviceIP1, cluster)
+				si.Annotations[lhconstants.AnnotationClustersetUID] = uid
+				serviceImportMap.Put(si)
+			}
+		})
+
+		It("should return the service for the UID", func() {
+			namespace, name, found := serviceImportMap.GetServiceForUID(uid)
+			Expect(found).To(BeTrue())
+			Expect(namespace).To(Equal(namespace1))
+			Expect(name).To(Equal(service1))
+
+			_, _, found = serviceImportMap.GetServiceForUID("unknown")
+			Expect(found).To(BeFalse())
+		})
+
+		When("the service is removed from all the clusters", func() {
+			It("should no longer return the service for the UID", func() {
+				serviceImportMap.Remove(newServiceImport(namespace1, service1, serviceIP1, clusterID1))
+				_, _, found := serviceImportMap.GetServiceForUID(uid)
+				Expect(found).To(BeTrue())
+
+				serviceImportMap.Remove(newServiceImport(namespace1, service1, serviceIP1, clusterID2))
+				_, _, found = serviceImportMap.GetServiceForUID(uid)
+				Expect(found).To(BeFalse())
+			})
+		})
+	})
+
+	When("a service is peeked for a client", func() {
+		It("should not advance the round-robin", func() {
+			serviceImportMap.Put(newServiceImport(namespace1, service1, serviceIP1, clusterID1))
+			serviceImportMap.Put(newServiceImport(namespace1, service1, serviceIP2, clusterID2))
+
+			peeked, found, _ := serviceImportMap.PeekIPForClient(namespace1, service1, "", "", "", checkCluster, checkEndpoint)
+			Expect(found).To(BeTrue())
+			Expect(getIP(namespace1, service1)).To(Equal(peeked))
+		})
+	})
+
+	When("the eligible clusters of a service are requested", func() {
+		It("should return the IPs of the connected clusters with available endpoints", func() {
+			serviceImportMap.Put(newServiceImport(namespace1, service1, serviceIP1, clusterID1))
+			serviceImportMap.Put(newServiceImport(namespace1, service1, serviceIP2, clusterID2))
+			serviceImportMap.Put(newServiceImport(namespace1, service1, serviceIP3, clusterID3))
+			clusterStatusMap[clusterID2] = false
+			endpointStatusMap[clusterID3] = false
+
+			Expect(serviceImportMap.GetEligibleIPs(namespace1, service1, checkCluster, checkEndpoint)).To(Equal(
+				map[string]string{clusterID1: serviceIP1}))
+		})
+	})
+
+	When("a service is exported with a frozen annotation", func() {
+		It("should return the frozen IPs of the oldest export", func() {
+			si := newServiceImport(namespace1, service1, serviceIP1, clusterID1)
+			si.Annotations[lhconstants.AnnotationFrozen] = serviceIP1 + ", " + serviceIP2
+			serviceImportMap.Put(si)
+
+			Expect(serviceImportMap.GetFrozenIPs(namespace1, service1)).To(Equal([]string{serviceIP1, serviceIP2}))
+		})
+
+		It("should ignore an invalid annotation", func() {
+			si := newServiceImport(namespace1, service1, serviceIP1, clusterID1)
+			si.Annotations[lhconstants.AnnotationFrozen] = serviceIP1 + ",bogus"
+			serviceImportMap.Put(si)
+
+			Expect(serviceImportMap.GetFrozenIPs(namespace1, service1)).To(BeEmpty())
+		})
+	})
 })
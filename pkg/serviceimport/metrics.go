@@ -0,0 +1,33 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package serviceimport
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/submariner-io/lighthouse/pkg/constants"
+)
+
+// ServiceImports is the number of ServiceImports currently imported, by type.
+var ServiceImports = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: constants.MetricsNamespace,
+	Name:      "serviceimports",
+	Help:      "Number of ServiceImports currently imported, by type.",
+}, []string{"type"})
+
+// Collectors returns the metrics maintained for ServiceImports.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{ServiceImports}
+}
@@ -0,0 +1,161 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lighthouse
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/submariner-io/lighthouse/pkg/gateway"
+)
+
+// The default address of the admin endpoints, ie the standby promotion, debug and freeze endpoints. They're
+// unauthenticated and some of them change the answers, so by default they're only reachable from the pod itself.
+const defaultAdminAddress = "127.0.0.1:8182"
+
+// adminEndpoint serves the given paths of an admin endpoint on an address, shared by the other endpoints configured
+// with it.
+type adminEndpoint struct {
+	address string
+	paths   []string
+	handler http.Handler
+	// What the endpoint serves, as logged when it's started.
+	description string
+}
+
+// adminEndpoints returns the enabled admin endpoints.
+func (lh *Lighthouse) adminEndpoints() []adminEndpoint {
+	var endpoints []adminEndpoint
+
+	if lh.isStandby() {
+		endpoints = append(endpoints, adminEndpoint{
+			address:     lh.promoteAddress,
+			paths:       []string{promotePath},
+			handler:     lh.promoteHandler(),
+			description: "the standby promotion endpoint",
+		})
+	}
+
+	if lh.debugGatewaysAddress != "" {
+		endpoints = append(endpoints, adminEndpoint{
+			address:     lh.debugGatewaysAddress,
+			paths:       []string{gateway.DebugGatewaysPath, gateway.DebugRecomputePath},
+			handler:     lh.debugGatewaysHandler,
+			description: "the raw Gateways, which include the endpoint IPs,",
+		})
+	}
+
+	if lh.debugSnapshotAddress != "" {
+		endpoints = append(endpoints, adminEndpoint{
+			address:     lh.debugSnapshotAddress,
+			paths:       []string{debugSnapshotPath, debugAffinityPath},
+			handler:     lh.snapshotHandler(),
+			description: "the answer snapshot, which includes the endpoint IPs,",
+		})
+	}
+
+	if lh.freezeAddress != "" {
+		endpoints = append(endpoints, adminEndpoint{
+			address:     lh.freezeAddress,
+			paths:       []string{freezePath, unfreezePath},
+			handler:     lh.freezeHandler(),
+			description: "the freeze endpoint, which pins the answers of services,",
+		})
+	}
+
+	return endpoints
+}
+
+// adminHandlers returns the handler of each address the admin endpoints are served on, routing the paths of all the
+// endpoints configured with that address.
+func (lh *Lighthouse) adminHandlers() map[string]*http.ServeMux {
+	handlers := map[string]*http.ServeMux{}
+
+	for _, endpoint := range lh.adminEndpoints() {
+		mux, ok := handlers[endpoint.address]
+		if !ok {
+			mux = http.NewServeMux()
+			handlers[endpoint.address] = mux
+		}
+
+		for _, path := range endpoint.paths {
+			mux.Handle(path, endpoint.handler)
+		}
+
+		log.Warningf("Serving %s unauthenticated on %q", endpoint.description, endpoint.address)
+	}
+
+	return handlers
+}
+
+// startAdminServers starts serving the admin endpoints, with a server for each configured address. If an address can't
+// be listened on, the ones already opened are closed so that a later start can listen on them again.
+func (lh *Lighthouse) startAdminServers() error {
+	handlers := lh.adminHandlers()
+	listeners := map[string]net.Listener{}
+
+	for address := range handlers {
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			for _, opened := range listeners {
+				_ = opened.Close()
+			}
+
+			return err
+		}
+
+		listeners[address] = listener
+	}
+
+	for address, listener := range listeners {
+		server := &http.Server{Handler: handlers[address]}
+		lh.adminServers = append(lh.adminServers, server)
+		lh.adminListeners = append(lh.adminListeners, listener)
+
+		go func(address string, listener net.Listener) {
+			if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+				log.Errorf("Error serving the admin endpoints on %q: %v", address, err)
+			}
+		}(address, listener)
+	}
+
+	if lh.isStandby() {
+		log.Infof("In standby mode - refusing queries until promoted via %q", lh.promoteAddress)
+	}
+
+	return nil
+}
+
+// stopAdminServers closes the admin servers. It's called on a reload as well as on shutdown since the new instance is
+// started before the old one is shut down, and it has to listen on the same addresses.
+func (lh *Lighthouse) stopAdminServers() error {
+	var err error
+
+	for _, server := range lh.adminServers {
+		if closeErr := server.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+
+	for _, listener := range lh.adminListeners {
+		_ = listener.Close()
+	}
+
+	lh.adminServers = nil
+	lh.adminListeners = nil
+
+	return err
+}
@@ -0,0 +1,162 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lighthouse
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/miekg/dns"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The path on which the answer pinned to a client is served.
+const debugAffinityPath = "/debug/affinity"
+
+var errDryRunNext = errors.New("a dry run isn't passed to the next plugin")
+
+// AffinityAnswer is the answer a client gets for a service with ClientIP session affinity, which is pinned to it by
+// the sticky answers.
+type AffinityAnswer struct {
+	Client    string `json:"client"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// The endpoint returned first and its cluster, if the answer isn't empty.
+	Endpoint string `json:"endpoint,omitempty"`
+	Cluster  string `json:"cluster,omitempty"`
+	// The addresses in the answer, in order.
+	Answer []string `json:"answer"`
+	Rcode  string   `json:"rcode"`
+}
+
+// dryRunWriter captures the answer to a query from the given client instead of sending it.
+type dryRunWriter struct {
+	captureWriter
+	client net.Addr
+}
+
+func (w *dryRunWriter) RemoteAddr() net.Addr {
+	return w.client
+}
+
+func (w *dryRunWriter) LocalAddr() net.Addr {
+	return &net.UDPAddr{}
+}
+
+// Affinity resolves the A query of the given client for the service as ServeDNS would, without recording it in the
+// circuit breakers nor the metrics. It fails if the answers for the service aren't pinned to the client, ie if sticky
+// answers aren't enabled or the service doesn't have ClientIP session affinity.
+func (lh *Lighthouse) Affinity(client, namespace, name string) (*AffinityAnswer, error) {
+	clientIP := net.ParseIP(client)
+	if clientIP == nil {
+		return nil, fmt.Errorf("invalid client IP %q", client)
+	}
+
+	targetNamespace, targetName := namespace, name
+	if target, ok := lh.aliases[namespace+"/"+name]; ok {
+		targetParts := strings.SplitN(target, "/", 2)
+		targetNamespace, targetName = targetParts[0], targetParts[1]
+	}
+
+	if !lh.sticky || !lh.serviceImports.HasClientIPAffinity(targetNamespace, targetName) {
+		return nil, fmt.Errorf("the answers for %s/%s aren't pinned to the client: sticky answers must be enabled and "+
+			"the service must have ClientIP session affinity", namespace, name)
+	}
+
+	zone := ""
+
+	for _, z := range lh.Zones {
+		if !lh.localZones[z] {
+			zone = z
+			break
+		}
+	}
+
+	if zone == "" {
+		return nil, errors.New("no zone is configured for the exported services")
+	}
+
+	query := new(dns.Msg)
+	query.SetQuestion(canonicalName(recordRequest{service: name, namespace: namespace}, zone), dns.TypeA)
+
+	t := &queryTrace{span: trace.SpanFromContext(context.Background()), clusters: map[string]bool{}, dryRun: true}
+	w := &dryRunWriter{client: &net.UDPAddr{IP: clientIP}}
+
+	rcode, err := lh.serveDNS(context.Background(), w, query, t)
+	if err != nil {
+		log.Debugf("The dry run of the query for %q from %q failed: %v", query.Question[0].Name, client, err)
+	}
+
+	answer := &AffinityAnswer{
+		Client:    client,
+		Namespace: namespace,
+		Name:      name,
+		Answer:    []string{},
+		Rcode:     dns.RcodeToString[rcode],
+	}
+
+	if w.msg != nil {
+		for _, rr := range w.msg.Answer {
+			if a, ok := rr.(*dns.A); ok {
+				answer.Answer = append(answer.Answer, a.A.String())
+			}
+		}
+	}
+
+	if t.first != nil && len(answer.Answer) > 0 {
+		answer.Endpoint = t.first.IP
+		answer.Cluster = t.first.Cluster
+	}
+
+	return answer, nil
+}
+
+// affinityHandler serves a GET of debugAffinityPath?client=IP&service=NAMESPACE/NAME with the JSON of the answer
+// pinned to the client.
+func (lh *Lighthouse) affinityHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	serviceParts := strings.SplitN(r.URL.Query().Get("service"), "/", 2)
+	if len(serviceParts) != 2 || serviceParts[0] == "" || serviceParts[1] == "" {
+		http.Error(w, "The service must be given as NAMESPACE/NAME", http.StatusBadRequest)
+		return
+	}
+
+	// Queries are matched case-insensitively, so the services are too.
+	answer, err := lh.Affinity(r.URL.Query().Get("client"), strings.ToLower(serviceParts[0]),
+		strings.ToLower(serviceParts[1]))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	body, err := json.MarshalIndent(answer, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(append(body, '\n'))
+}
@@ -0,0 +1,52 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lighthouse
+
+import (
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+)
+
+// The states of an exported service as seen by the queries for it, by lighthouse_service_queries_total label.
+const (
+	serviceNotExported = "not_exported"
+	serviceUnavailable = "unavailable"
+	serviceAvailable   = "available"
+)
+
+// The response codes of the queries for an exported service without any available cluster, by unavailable-answer
+// argument.
+var unavailableRcodes = map[string]int{
+	"empty":    dns.RcodeSuccess,
+	"servfail": dns.RcodeServerFailure,
+}
+
+// countServiceQuery counts a query for a service in the given state, unless it's a dry run.
+func (lh *Lighthouse) countServiceQuery(t *queryTrace, state string) {
+	if !t.isDryRun() {
+		serviceQueries.WithLabelValues(state).Inc()
+	}
+}
+
+// unavailableResponse answers a query for an exported service without any available cluster and no fallback, with an
+// empty response or the configured unavailable-answer response code.
+func (lh *Lighthouse) unavailableResponse(state request.Request) (int, error) {
+	if lh.unavailableRcode != dns.RcodeSuccess {
+		return lh.unavailableRcode, nil
+	}
+
+	return lh.emptyResponse(state)
+}
@@ -0,0 +1,115 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lighthouse_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	lhconstants "github.com/submariner-io/lighthouse/pkg/constants"
+	"github.com/submariner-io/lighthouse/plugin/lighthouse"
+	discovery "k8s.io/api/discovery/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
+)
+
+const (
+	benchmarkNamespace = "bench"
+	benchmarkClusters  = 5
+)
+
+// BenchmarkServeDNS measures the queries per second and allocations of ServeDNS for ClusterIP and headless services
+// exported by several clusters, one of which is disconnected.
+func BenchmarkServeDNS(b *testing.B) {
+	lh, status := lighthouse.NewInMemory([]string{"clusterset.local"}, clusterName(0))
+
+	for i := 0; i < benchmarkClusters; i++ {
+		status.SetConnected(clusterName(i), i != benchmarkClusters-1)
+
+		lh.PutServiceImport(newBenchmarkServiceImport("nginx", clusterName(i), fmt.Sprintf("100.96.0.%d", i+1),
+			mcsv1a1.ClusterSetIP))
+		lh.PutServiceImport(newBenchmarkServiceImport("db", clusterName(i), "", mcsv1a1.Headless))
+		lh.PutEndpointSlice(newBenchmarkEndpointSlice("db", clusterName(i), fmt.Sprintf("10.%d.0.1", i),
+			fmt.Sprintf("10.%d.0.2", i)))
+	}
+
+	status.SetLocalServiceIP("nginx", benchmarkNamespace, "100.96.0.1")
+
+	for _, service := range []string{"nginx", "db"} {
+		msg := (&test.Case{Qname: service + "." + benchmarkNamespace + ".svc.clusterset.local.", Qtype: dns.TypeA}).Msg()
+
+		b.Run(service, func(b *testing.B) {
+			b.ReportAllocs()
+
+			for n := 0; n < b.N; n++ {
+				rec := dnstest.NewRecorder(&test.ResponseWriter{})
+				if code, err := lh.ServeDNS(context.TODO(), rec, msg); err != nil || code != dns.RcodeSuccess ||
+					len(rec.Msg.Answer) == 0 {
+					b.Fatalf("ServeDNS failed with code %d: %v", code, err)
+				}
+			}
+		})
+	}
+}
+
+func clusterName(i int) string {
+	return fmt.Sprintf("cluster%d", i+1)
+}
+
+func newBenchmarkServiceImport(name, clusterID, ip string, siType mcsv1a1.ServiceImportType) *mcsv1a1.ServiceImport {
+	return &mcsv1a1.ServiceImport{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: benchmarkNamespace,
+			Annotations: map[string]string{
+				lhconstants.OriginName:      name,
+				lhconstants.OriginNamespace: benchmarkNamespace,
+			},
+			Labels: map[string]string{
+				lhconstants.LabelSourceCluster: clusterID,
+			},
+		},
+		Spec: mcsv1a1.ServiceImportSpec{
+			Type: siType,
+			IPs:  []string{ip},
+		},
+		Status: mcsv1a1.ServiceImportStatus{
+			Clusters: []mcsv1a1.ClusterStatus{{Cluster: clusterID}},
+		},
+	}
+}
+
+func newBenchmarkEndpointSlice(name, clusterID string, ips ...string) *discovery.EndpointSlice {
+	return &discovery.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name + "-" + clusterID,
+			Namespace: benchmarkNamespace,
+			Labels: map[string]string{
+				lhconstants.LabelServiceImportName: name,
+				discovery.LabelManagedBy:           lhconstants.LabelValueManagedBy,
+				lhconstants.LabelSourceNamespace:   benchmarkNamespace,
+				lhconstants.LabelSourceCluster:     clusterID,
+				lhconstants.LabelSourceName:        name,
+			},
+		},
+		AddressType: discovery.AddressTypeIPv4,
+		Endpoints:   []discovery.Endpoint{{Addresses: ips}},
+	}
+}
@@ -0,0 +1,135 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lighthouse
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// captureWriter keeps the message written to it instead of sending it to the client.
+type captureWriter struct {
+	dns.ResponseWriter
+	msg *dns.Msg
+}
+
+func (w *captureWriter) WriteMsg(m *dns.Msg) error {
+	w.msg = m
+	return nil
+}
+
+// debugResponse answers a TXT query for "_debug.<service>.<namespace>.svc.<zone>" with a diagnostic of how an A query
+// for the service from the same client is resolved. There is a record for each cluster exporting the service, with
+// its connectivity status, number of endpoints, whether its endpoints were selected for the answer or else the reason
+// it was filtered out, if it was checked at all, and the addresses dropped by an excluded CIDR. These are followed by a
+// record with the answer itself. The records aren't cached.
+func (lh *Lighthouse) debugResponse(ctx context.Context, state request.Request, pReq recordRequest,
+	t *queryTrace) (int, error) {
+	pReq.cluster = ""
+
+	query := state.Req.Copy()
+	query.Question[0].Name = canonicalName(pReq, state.Zone)
+	query.Question[0].Qtype = dns.TypeA
+
+	// The diagnosed query is a dry run so it doesn't advance the round-robin or count as an answered query.
+	diag := &queryTrace{span: trace.SpanFromContext(ctx), clusters: map[string]bool{}, filtered: map[string]string{},
+		dryRun: true}
+	if t != nil {
+		diag.span = t.span
+	}
+
+	w := &dryRunWriter{captureWriter: captureWriter{ResponseWriter: state.W}, client: state.W.RemoteAddr()}
+
+	rcode, err := lh.serveDNS(ctx, w, query, diag)
+	if err != nil {
+		log.Debugf("The diagnosed query for %q failed: %v", query.Question[0].Name, err)
+	}
+
+	if target, ok := lh.aliases[pReq.namespace+"/"+pReq.service]; ok {
+		targetParts := strings.SplitN(target, "/", 2)
+		pReq.namespace, pReq.service = targetParts[0], targetParts[1]
+	}
+
+	records := []dns.RR{}
+
+	skipped := map[string]bool{}
+	for _, clusterID := range lh.endpointSlices.GetSkippedClusters(pReq.namespace, pReq.service) {
+		skipped[clusterID] = true
+	}
+
+	for _, clusterID := range lh.serviceImports.GetClusters(pReq.namespace, pReq.service) {
+		fields := []string{
+			"cluster=" + clusterID,
+			fmt.Sprintf("connected=%t", lh.clusterStatus.IsConnected(clusterID)),
+			fmt.Sprintf("endpoints=%d", lh.endpointSlices.GetIPCount(pReq.namespace, pReq.service, clusterID)),
+		}
+
+		if diag.clusters[clusterID] {
+			fields = append(fields, "selected=true")
+		}
+
+		if reason, ok := diag.filtered[clusterID]; ok {
+			fields = append(fields, "filtered="+reason)
+		}
+
+		if skipped[clusterID] {
+			fields = append(fields, "skipped=true")
+		}
+
+		if excluded := lh.endpointSlices.GetExcludedIPs(pReq.namespace, pReq.service, clusterID); len(excluded) > 0 {
+			ips := make([]string, 0, len(excluded))
+			for ip, cidr := range excluded {
+				ips = append(ips, ip+"("+cidr+")")
+			}
+
+			sort.Strings(ips)
+
+			fields = append(fields, "excluded="+strings.Join(ips, ","))
+		}
+
+		records = append(records, lh.debugRecord(state, fields...))
+	}
+
+	answer := []string{}
+
+	if w.msg != nil {
+		for _, rr := range w.msg.Answer {
+			switch rr := rr.(type) {
+			case *dns.A:
+				answer = append(answer, rr.A.String())
+			case *dns.CNAME:
+				answer = append(answer, rr.Target)
+			}
+		}
+	}
+
+	records = append(records, lh.debugRecord(state, "answer="+strings.Join(answer, ","), "rcode="+dns.RcodeToString[rcode]))
+
+	return lh.writeRecords(state, records)
+}
+
+func (lh *Lighthouse) debugRecord(state request.Request, fields ...string) dns.RR {
+	return &dns.TXT{
+		Hdr: dns.RR_Header{Name: state.QName(), Rrtype: dns.TypeTXT, Class: state.QClass(), Ttl: 0},
+		Txt: []string{strings.Join(fields, " ")},
+	}
+}
@@ -0,0 +1,443 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lighthouse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+	lhconstants "github.com/submariner-io/lighthouse/pkg/constants"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The paths on which the answers of services are frozen and unfrozen.
+const (
+	freezePath   = "/freeze"
+	unfreezePath = "/unfreeze"
+)
+
+// frozenAnswer is the answer of a frozen service, taken when it was frozen.
+type frozenAnswer struct {
+	endpoints []Endpoint
+	// The endpoints of the answer to AAAA queries, eg the IPv6 clusterset VIP, if any.
+	ipv6Endpoints []Endpoint
+	// Whether the endpoints are those of a headless service, otherwise they're the IPs of the clusters, which are
+	// answered round-robin, or the clusterset VIP.
+	headless bool
+	// The round-robin counter of the clusters.
+	rrCount uint64
+}
+
+// frozenKey returns the key of the service the given name refers to, following aliases.
+func (lh *Lighthouse) frozenKey(namespace, name string) string {
+	if target, ok := lh.aliases[namespace+"/"+name]; ok {
+		return target
+	}
+
+	return namespace + "/" + name
+}
+
+// getFrozen returns the answer the service was frozen with, if it's frozen, either by Freeze or by the frozen
+// annotation.
+func (lh *Lighthouse) getFrozen(pReq recordRequest) (*frozenAnswer, bool) {
+	lh.frozenMutex.RLock()
+	defer lh.frozenMutex.RUnlock()
+
+	frozen, ok := lh.frozen[pReq.namespace+"/"+pReq.service]
+	if !ok {
+		frozen, ok = lh.annotationFrozen[pReq.namespace+"/"+pReq.service]
+	}
+
+	return frozen, ok
+}
+
+// Freeze pins the answers for the service to the endpoints it's answered with now, regardless of the later changes to
+// its exports, endpoints or the connectivity of its clusters, until it's unfrozen. A ClusterSetIP service without a
+// clusterset VIP is pinned to the clusters it can be answered from now, which are then answered round-robin. The other
+// answers are resolved as live A and AAAA queries over TCP would be. Neither affects the round-robin, the circuit
+// breakers nor the metrics. It fails if the service isn't exported or has no available IPv4 endpoints, and returns
+// false if it was already frozen, in which case its answers are kept.
+func (lh *Lighthouse) Freeze(namespace, name string) (bool, error) {
+	key := lh.frozenKey(namespace, name)
+	targetParts := strings.SplitN(key, "/", 2)
+
+	if len(lh.serviceImports.GetClusters(targetParts[0], targetParts[1])) == 0 {
+		return false, fmt.Errorf("the service %s isn't exported", key)
+	}
+
+	if _, ok := lh.getFrozen(recordRequest{namespace: targetParts[0], service: targetParts[1]}); ok {
+		return false, nil
+	}
+
+	zone := ""
+
+	for _, z := range lh.Zones {
+		if !lh.localZones[z] {
+			zone = z
+			break
+		}
+	}
+
+	if zone == "" {
+		return false, errors.New("no zone is configured for the exported services")
+	}
+
+	frozen := &frozenAnswer{headless: lh.serviceImports.IsHeadless(targetParts[0], targetParts[1])}
+
+	if frozen.headless || lh.serviceImports.GetClustersetIP(targetParts[0], targetParts[1]) != "" {
+		for _, rr := range lh.dryRunAnswer(targetParts[0], targetParts[1], zone, dns.TypeA) {
+			if a, ok := rr.(*dns.A); ok {
+				frozen.endpoints = append(frozen.endpoints, Endpoint{IP: a.A.String(),
+					Cluster: lh.frozenCluster(targetParts[0], targetParts[1], a.A.String())})
+			}
+		}
+	} else {
+		frozen.endpoints = lh.eligibleClusters(recordRequest{namespace: targetParts[0], service: targetParts[1]})
+	}
+
+	for _, rr := range lh.dryRunAnswer(targetParts[0], targetParts[1], zone, dns.TypeAAAA) {
+		if aaaa, ok := rr.(*dns.AAAA); ok {
+			frozen.ipv6Endpoints = append(frozen.ipv6Endpoints, Endpoint{IP: aaaa.AAAA.String()})
+		}
+	}
+
+	if len(frozen.endpoints) == 0 {
+		return false, fmt.Errorf("the service %s has no available endpoints to freeze", key)
+	}
+
+	lh.frozenMutex.Lock()
+	defer lh.frozenMutex.Unlock()
+
+	if _, ok := lh.frozen[key]; ok {
+		return false, nil
+	}
+
+	if lh.frozen == nil {
+		lh.frozen = map[string]*frozenAnswer{}
+	}
+
+	lh.frozen[key] = frozen
+	frozenServices.WithLabelValues(key).Set(1)
+
+	log.Infof("Froze the answers for %q to %v", key, frozenIPs(frozen.endpoints))
+
+	return true, nil
+}
+
+// eligibleClusters returns the IPs of the clusters a ClusterSetIP service can be answered from now, ordered by cluster
+// ID, with the same checks as a query letting Lighthouse choose the cluster.
+func (lh *Lighthouse) eligibleClusters(pReq recordRequest) []Endpoint {
+	t := &queryTrace{span: trace.SpanFromContext(context.Background()), clusters: map[string]bool{}, dryRun: true}
+	isConnected, checkEndpoint := lh.clusterChecks(pReq, func(string) bool { return true }, t)
+	localClusterID := lh.clusterStatus.LocalClusterID()
+
+	eligible := lh.serviceImports.GetEligibleIPs(pReq.namespace, pReq.service, isConnected, checkEndpoint)
+	endpoints := make([]Endpoint, 0, len(eligible))
+
+	for clusterID, ip := range eligible {
+		if clusterID == localClusterID {
+			if localIP, found := lh.localServices.GetIP(pReq.service, pReq.namespace); found && localIP != "" {
+				ip = localIP
+			}
+		}
+
+		endpoints = append(endpoints, Endpoint{IP: ip, Cluster: clusterID})
+	}
+
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].Cluster < endpoints[j].Cluster
+	})
+
+	return endpoints
+}
+
+// updateAnnotationFrozen freezes the answers of the service to the IPs of the frozen annotation of its oldest export,
+// if any, and unfreezes them once it's removed.
+func (lh *Lighthouse) updateAnnotationFrozen(namespace, name string) {
+	key := namespace + "/" + name
+	ips := lh.serviceImports.GetFrozenIPs(namespace, name)
+
+	lh.frozenMutex.Lock()
+	defer lh.frozenMutex.Unlock()
+
+	previous, wasFrozen := lh.annotationFrozen[key]
+	if len(ips) == 0 {
+		if wasFrozen {
+			delete(lh.annotationFrozen, key)
+
+			if _, ok := lh.frozen[key]; !ok {
+				frozenServices.DeleteLabelValues(key)
+			}
+
+			log.Infof("Unfroze the answers for %q as its %q annotation was removed", key, lhconstants.AnnotationFrozen)
+		}
+
+		return
+	}
+
+	frozen := &frozenAnswer{headless: lh.serviceImports.IsHeadless(namespace, name)}
+
+	for _, ip := range ips {
+		if net.ParseIP(ip).To4() != nil {
+			frozen.endpoints = append(frozen.endpoints, Endpoint{IP: ip, Cluster: lh.frozenCluster(namespace, name, ip)})
+		} else {
+			frozen.ipv6Endpoints = append(frozen.ipv6Endpoints, Endpoint{IP: ip})
+		}
+	}
+
+	if wasFrozen && reflect.DeepEqual(previous.endpoints, frozen.endpoints) &&
+		reflect.DeepEqual(previous.ipv6Endpoints, frozen.ipv6Endpoints) && previous.headless == frozen.headless {
+		return
+	}
+
+	if lh.annotationFrozen == nil {
+		lh.annotationFrozen = map[string]*frozenAnswer{}
+	}
+
+	lh.annotationFrozen[key] = frozen
+	frozenServices.WithLabelValues(key).Set(1)
+
+	log.Infof("Froze the answers for %q to %v as per its %q annotation", key, ips, lhconstants.AnnotationFrozen)
+}
+
+func frozenIPs(endpoints []Endpoint) []string {
+	ips := make([]string, len(endpoints))
+	for i := range endpoints {
+		ips[i] = endpoints[i].IP
+	}
+
+	return ips
+}
+
+// Unfreeze resumes answering the service from its current state, unless it's also frozen by the frozen annotation. It
+// returns false if it wasn't frozen by Freeze.
+func (lh *Lighthouse) Unfreeze(namespace, name string) bool {
+	key := lh.frozenKey(namespace, name)
+
+	lh.frozenMutex.Lock()
+	defer lh.frozenMutex.Unlock()
+
+	if _, ok := lh.frozen[key]; !ok {
+		return false
+	}
+
+	delete(lh.frozen, key)
+
+	if _, ok := lh.annotationFrozen[key]; !ok {
+		frozenServices.DeleteLabelValues(key)
+	}
+
+	log.Infof("Unfroze the answers for %q", key)
+
+	return true
+}
+
+// dryRunAnswer returns the answer records of a dry run of a query of the given type for the service, or none if it
+// didn't succeed.
+func (lh *Lighthouse) dryRunAnswer(namespace, name, zone string, qType uint16) []dns.RR {
+	query := new(dns.Msg)
+	query.SetQuestion(canonicalName(recordRequest{service: name, namespace: namespace}, zone), qType)
+
+	t := &queryTrace{span: trace.SpanFromContext(context.Background()), clusters: map[string]bool{}, dryRun: true}
+	w := &dryRunWriter{client: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}}
+
+	rcode, err := lh.serveDNS(context.Background(), w, query, t)
+	if err != nil {
+		log.Debugf("The dry run of the query for %q to freeze failed: %v", query.Question[0].Name, err)
+	}
+
+	if w.msg == nil || rcode != dns.RcodeSuccess {
+		return nil
+	}
+
+	return w.msg.Answer
+}
+
+// frozenCluster returns the ID of the cluster the given IP of the service belongs to, if known.
+func (lh *Lighthouse) frozenCluster(namespace, name, ip string) string {
+	if clusterID := lh.serviceImports.GetClusterForIP(namespace, name, ip); clusterID != "" {
+		return clusterID
+	}
+
+	if localIP, found := lh.localServices.GetIP(name, namespace); found && localIP == ip {
+		return lh.clusterStatus.LocalClusterID()
+	}
+
+	return lh.endpointSlices.GetClusterForIP(namespace, name, ip)
+}
+
+// frozenResponse answers a query for a frozen service with the endpoints it was frozen with, preceded by the alias
+// CNAME, if any: all of them ordered by the AnswerOrderer for a headless service, otherwise one of them round-robin.
+func (lh *Lighthouse) frozenResponse(state request.Request, pReq recordRequest, frozen *frozenAnswer,
+	cname *dns.CNAME, t *queryTrace) (int, error) {
+	log.Debugf("Answering %q with the endpoints %q was frozen with", state.QName(), pReq.namespace+"/"+pReq.service)
+
+	lh.countServiceQuery(t, serviceAvailable)
+
+	if !t.isDryRun() {
+		frozenAnswers.WithLabelValues(pReq.namespace + "/" + pReq.service).Inc()
+	}
+
+	if state.QType() == dns.TypeAAAA {
+		return lh.frozenIPv6Response(state, frozen, cname)
+	}
+
+	if len(frozen.endpoints) == 0 {
+		return lh.emptyResponse(state)
+	}
+
+	var (
+		endpoints []Endpoint
+		truncated bool
+	)
+
+	if frozen.headless {
+		// The frozen endpoints are ordered in place so they're copied.
+		endpoints = lh.answerOrder(true).Order(append([]Endpoint{}, frozen.endpoints...), QueryContext{
+			Namespace:        pReq.namespace,
+			Service:          pReq.service,
+			Client:           state.IP(),
+			LocalCluster:     lh.clusterStatus.LocalClusterID(),
+			ClientIPAffinity: lh.serviceImports.HasClientIPAffinity(pReq.namespace, pReq.service),
+			Headless:         true,
+		})
+
+		truncated = lh.maxAnswers > 0 && len(endpoints) > lh.maxAnswers && state.Proto() == "udp"
+		if truncated {
+			endpoints = endpoints[:lh.maxAnswers]
+		}
+	} else {
+		next := atomic.LoadUint64(&frozen.rrCount)
+		if !t.isDryRun() {
+			next = atomic.AddUint64(&frozen.rrCount, 1) - 1
+		}
+
+		endpoints = []Endpoint{frozen.endpoints[next%uint64(len(frozen.endpoints))]}
+	}
+
+	for _, endpoint := range endpoints {
+		t.clusterSelected(endpoint.Cluster)
+	}
+
+	t.firstSelected(endpoints[0])
+
+	records := make([]dns.RR, 0, len(endpoints)+1)
+	name := state.QName()
+
+	if cname != nil {
+		records = append(records, cname)
+		name = cname.Target
+	}
+
+	for _, endpoint := range endpoints {
+		records = append(records, &dns.A{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: state.QClass(),
+			Ttl: lh.ttl}, A: net.ParseIP(endpoint.IP).To4()})
+	}
+
+	a := new(dns.Msg)
+	a.SetReply(state.Req)
+	a.Authoritative = true
+	a.Truncated = truncated
+	a.Answer = records
+
+	return lh.writeMsg(state, a)
+}
+
+// frozenIPv6Response answers an AAAA query for a frozen service with the IPv6 endpoints it was frozen with, if any,
+// preceded by the alias CNAME, if any.
+func (lh *Lighthouse) frozenIPv6Response(state request.Request, frozen *frozenAnswer, cname *dns.CNAME) (int, error) {
+	if len(frozen.ipv6Endpoints) == 0 {
+		return lh.emptyResponse(state)
+	}
+
+	records := make([]dns.RR, 0, len(frozen.ipv6Endpoints)+1)
+	name := state.QName()
+
+	if cname != nil {
+		records = append(records, cname)
+		name = cname.Target
+	}
+
+	for _, endpoint := range frozen.ipv6Endpoints {
+		records = append(records, &dns.AAAA{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA, Class: state.QClass(),
+			Ttl: lh.ttl}, AAAA: net.ParseIP(endpoint.IP)})
+	}
+
+	return lh.writeRecords(state, records)
+}
+
+// freezeHandler serves the freeze endpoint: a POST to /freeze?service=NAMESPACE/NAME freezes the service's answers
+// and a POST to /unfreeze?service=NAMESPACE/NAME unfreezes them, both being no-ops if they already are.
+func (lh *Lighthouse) freezeHandler() http.Handler {
+	service := func(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only POST is supported", http.StatusMethodNotAllowed)
+			return "", "", false
+		}
+
+		serviceParts := strings.SplitN(r.URL.Query().Get("service"), "/", 2)
+		if len(serviceParts) != 2 || serviceParts[0] == "" || serviceParts[1] == "" {
+			http.Error(w, "The service must be given as NAMESPACE/NAME", http.StatusBadRequest)
+			return "", "", false
+		}
+
+		// Queries are matched case-insensitively, so the services are too.
+		return strings.ToLower(serviceParts[0]), strings.ToLower(serviceParts[1]), true
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(freezePath, func(w http.ResponseWriter, r *http.Request) {
+		namespace, name, ok := service(w, r)
+		if !ok {
+			return
+		}
+
+		frozen, err := lh.Freeze(namespace, name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if frozen {
+			_, _ = w.Write([]byte("Frozen\n"))
+		} else {
+			_, _ = w.Write([]byte("Already frozen\n"))
+		}
+	})
+	mux.HandleFunc(unfreezePath, func(w http.ResponseWriter, r *http.Request) {
+		namespace, name, ok := service(w, r)
+		if !ok {
+			return
+		}
+
+		if lh.Unfreeze(namespace, name) {
+			_, _ = w.Write([]byte("Unfrozen\n"))
+		} else {
+			_, _ = w.Write([]byte("Not frozen\n"))
+		}
+	})
+
+	return mux
+}
@@ -20,14 +20,33 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"strconv"
+	"strings"
 
 	"github.com/coredns/coredns/plugin"
 	"github.com/coredns/coredns/request"
 	"github.com/miekg/dns"
+	"github.com/submariner-io/lighthouse/pkg/consistenthash"
 )
 
 // ServeDNS implements the plugin.Handler interface.
 func (lh *Lighthouse) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	if lh.queryTimeout > 0 {
+		return lh.serveWithTimeout(ctx, w, r)
+	}
+
+	return lh.serveTraced(ctx, w, r)
+}
+
+func (lh *Lighthouse) serveTraced(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	t := lh.startTrace(ctx, r)
+	rcode, err := lh.serveDNS(ctx, w, r, t)
+	t.end(rcode, err)
+
+	return rcode, err
+}
+
+func (lh *Lighthouse) serveDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg, t *queryTrace) (int, error) {
 	state := request.Request{W: w, Req: r}
 	qname := state.QName()
 
@@ -37,12 +56,45 @@ func (lh *Lighthouse) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns
 	// zone:  example.org.
 	// Matches will return zone in all lower cases
 	zone := plugin.Zones(lh.Zones).Matches(qname)
+
+	// The records are still named after the short query while the expanded name is parsed.
+	parseState := state
+	if expanded, ok := lh.expandShortName(qname); ok {
+		log.Debugf("Expanding the short name %q to %q", qname, expanded)
+
+		qname = expanded
+		zone = plugin.Zones(lh.Zones).Matches(qname)
+		parseState = request.Request{W: w, Req: r.Copy()}
+		parseState.Req.Question[0].Name = qname
+	}
+
 	if zone == "" {
 		log.Debugf("Request does not match configured zones %v", lh.Zones)
 		return lh.nextOrFailure(state.Name(), ctx, w, r, dns.RcodeNotZone, "No matching zone found")
 	}
 
-	if state.QType() != dns.TypeA && state.QType() != dns.TypeAAAA {
+	// Answers from a partial sync could be cached by the clients, eg an NXDOMAIN for a service that's not synced yet.
+	if lh.preSyncRcode != dns.RcodeSuccess && !lh.hasSynced() {
+		log.Debugf("Answering the query for %q with %s until the initial sync completes", qname,
+			dns.RcodeToString[lh.preSyncRcode])
+		return lh.preSyncRcode, nil
+	}
+
+	if lh.isStandby() {
+		log.Debugf("Refusing the query for %q in standby mode", qname)
+		return dns.RcodeRefused, nil
+	}
+
+	if lh.localZones[zone] {
+		return lh.localZoneResponse(ctx, state, zone)
+	}
+
+	if !t.isDryRun() {
+		zoneQueries.WithLabelValues(zone, strconv.FormatBool(lh.aliasZones[zone])).Inc()
+	}
+
+	if state.QType() != dns.TypeA && state.QType() != dns.TypeAAAA &&
+		(state.QType() != dns.TypeTXT || !(lh.clustersTXT || lh.debugTXT)) {
 		msg := fmt.Sprintf("Query of type %d is not supported", state.QType())
 		log.Debugf(msg)
 
@@ -51,57 +103,419 @@ func (lh *Lighthouse) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns
 
 	zone = qname[len(qname)-len(zone):] // maintain case of original query
 	state.Zone = zone
+	parseState.Zone = zone
+
+	pReq, pErr := parseRequest(parseState)
+	if uidReq, ok := lh.parseUIDRequest(parseState); ok {
+		pReq, pErr = uidReq, nil
+	} else if globalReq, ok := lh.parseGlobalNameRequest(parseState); ok {
+		pReq, pErr = globalReq, nil
+	}
 
-	pReq, pErr := parseRequest(state)
 	if pErr != nil || pReq.podOrSvc != Svc {
 		// We only support svc type queries i.e. *.svc.*
 		log.Debugf("Request type %q is not a 'svc' type query - err was %v", pReq.podOrSvc, pErr)
 		return lh.nextOrFailure(state.Name(), ctx, w, r, dns.RcodeNameError, "Only services supported")
 	}
 
+	if state.QType() == dns.TypeTXT {
+		if lh.debugTXT && pReq.cluster == debugLabel && pReq.hostname == "" {
+			return lh.debugResponse(ctx, state, pReq, t)
+		}
+
+		if !lh.clustersTXT || pReq.cluster != clustersLabel || pReq.hostname != "" {
+			msg := fmt.Sprintf("TXT query for %q is not supported", qname)
+			log.Debugf(msg)
+
+			return lh.nextOrFailure(state.Name(), ctx, w, r, dns.RcodeNotImplemented, msg)
+		}
+
+		records := lh.clustersRecords(state, pReq)
+		if len(records) == 0 {
+			log.Debugf("No clusters found for %q", qname)
+			return lh.nextOrFailure(state.Name(), ctx, w, r, dns.RcodeNameError, "record not found")
+		}
+
+		return lh.writeRecords(state, records)
+	}
+
 	var (
 		ips   []string
-		found bool
-		ip    string
+		cname *dns.CNAME
+		// Maps each deduplicated endpoint IP to the cluster it's attributed to.
+		representatives map[string]string
 	)
 
-	ip, found = lh.getClusterIpForSvc(pReq)
+	if target, ok := lh.aliases[pReq.namespace+"/"+pReq.service]; ok {
+		targetParts := strings.SplitN(target, "/", 2)
+		pReq.namespace, pReq.service = targetParts[0], targetParts[1]
+
+		if lh.aliasCNAME {
+			cname = &dns.CNAME{
+				Hdr:    dns.RR_Header{Name: state.QName(), Rrtype: dns.TypeCNAME, Class: state.QClass(), Ttl: lh.ttl},
+				Target: canonicalName(pReq, state.Zone),
+			}
+		}
+	}
+
+	// The VIP's name is only ever answered with its A or AAAA record so the CNAME to it can't loop.
+	vipQuery := lh.clustersetIPCNAME && pReq.cluster == vipLabel && pReq.hostname == ""
+	if vipQuery {
+		pReq.cluster = ""
+	}
+
+	// Queries for a specific cluster, hostname or variant are answered from the current state.
+	if pReq.cluster == "" && pReq.hostname == "" && !vipQuery && !lh.localOnly {
+		if frozen, ok := lh.getFrozen(pReq); ok {
+			return lh.frozenResponse(state, pReq, frozen, cname, t)
+		}
+	}
+
+	variant := lh.activeVariants[pReq.namespace+"/"+pReq.service]
+	if pReq.cluster != "" && pReq.hostname == "" && lh.serviceImports.IsVariant(pReq.namespace, pReq.service, pReq.cluster) {
+		variant = pReq.cluster
+		pReq.cluster = ""
+	}
+
+	if lh.localOnly {
+		// Only the local cluster's endpoints are returned, regardless of the requested cluster.
+		localClusterID := lh.clusterStatus.LocalClusterID()
+		if pReq.cluster != "" && pReq.cluster != localClusterID {
+			log.Debugf("Cluster %q was requested for %q in local-only mode", pReq.cluster, qname)
+			return lh.nextOrFailure(state.Name(), ctx, w, r, dns.RcodeNameError, "record not found")
+		}
+
+		pReq.cluster = localClusterID
+	}
+
+	if lh.internalTrafficPolicy && pReq.cluster == "" && !vipQuery {
+		// The clusterset analog of node-local endpoints is the local cluster's endpoints.
+		localClusterID := lh.clusterStatus.LocalClusterID()
+		if lh.serviceImports.HasLocalTrafficPolicy(pReq.namespace, pReq.service, localClusterID) {
+			log.Debugf("Answering %q with the local cluster's endpoints as per its internalTrafficPolicy", qname)
+			pReq.cluster = localClusterID
+		}
+	}
+
+	eligible := lh.exclusionFilter(pReq, lh.variantFilter(pReq, variant), t)
+
+	if minClusters := lh.getMinClusters(pReq); minClusters > 1 && pReq.cluster == "" {
+		if available := lh.countAvailableClusters(pReq, eligible); available < minClusters {
+			lh.countServiceQuery(t, serviceUnavailable)
+
+			if fallback := lh.configuredFallback(pReq); fallback != "" {
+				log.Debugf("Only %d of the %d clusters required for %q are available - returning the fallback %q",
+					available, minClusters, qname, fallback)
+				return lh.fallbackResponse(state, fallback)
+			}
+
+			log.Debugf("Only %d of the %d clusters required for %q are available", available, minClusters, qname)
+
+			return lh.unavailableResponse(state)
+		}
+	}
+
+	inRegion, outOfRegion := lh.regionFilters(pReq, eligible)
+
+	client := ""
+	if lh.sticky {
+		client = state.IP()
+	}
+
+	ip, firstCluster, found := lh.getClusterIpForSvc(pReq, client, inRegion, t)
+	if found && ip == "" && outOfRegion != nil {
+		log.Debugf("No cluster in the local region is available for %q - falling back to the other regions", qname)
+		ip, firstCluster, found = lh.getClusterIpForSvc(pReq, client, outOfRegion, t)
+	}
+
+	isHeadless := !found
+
+	// A query for the service as a whole is answered with its clusterset VIP of the queried family, if any, both for A
+	// and AAAA queries.
+	vip := ""
+	if !isHeadless && pReq.cluster == "" {
+		vip = lh.clustersetVIP(pReq, state.QType())
+	}
+
+	if vipQuery && vip == "" {
+		log.Debugf("No clusterset VIP found for %q", qname)
+		return lh.nextOrFailure(state.Name(), ctx, w, r, dns.RcodeNameError, "record not found")
+	}
+
+	if isHeadless {
+		ips, representatives, found = lh.getHeadlessIPs(pReq, client, inRegion, t)
+		if found && len(ips) == 0 && outOfRegion != nil {
+			log.Debugf("No cluster in the local region is available for %q - falling back to the other regions", qname)
+			ips, representatives, found = lh.getHeadlessIPs(pReq, client, outOfRegion, t)
+		}
 
-	if !found {
-		ips, found = lh.endpointSlices.GetIPs(pReq.hostname, pReq.cluster, pReq.namespace, pReq.service, lh.clusterStatus.IsConnected)
 		if !found {
 			log.Debugf("No record found for %q", qname)
+			lh.countServiceQuery(t, serviceNotExported)
+
 			return lh.nextOrFailure(state.Name(), ctx, w, r, dns.RcodeNameError, "record not found")
 		}
-	} else if ip != "" {
+	} else if ip != "" && (!lh.localOnly || lh.isHealthy(pReq.service, pReq.namespace, pReq.cluster)) {
 		ips = []string{ip}
 	}
 
+	if len(ips) == 0 && lh.localOnly {
+		log.Debugf("No local endpoints found for %q in local-only mode", qname)
+		return lh.nextOrFailure(state.Name(), ctx, w, r, dns.RcodeNameError, "record not found")
+	}
+
 	if len(ips) == 0 {
+		lh.countServiceQuery(t, serviceUnavailable)
+
+		if fallback := lh.getFallback(pReq); fallback != "" {
+			log.Debugf("No connected cluster found for %q - returning the fallback %q", qname, fallback)
+			return lh.fallbackResponse(state, fallback)
+		}
+
 		log.Debugf("Couldn't find a connected cluster or valid IPs for %q", qname)
-		return lh.emptyResponse(state)
+
+		return lh.unavailableResponse(state)
 	}
 
+	lh.countServiceQuery(t, serviceAvailable)
+
 	if state.QType() == dns.TypeAAAA {
+		if vip != "" {
+			return lh.clustersetIPv6Response(state, pReq, vip, cname, vipQuery)
+		}
+
 		log.Debugf("Returning empty response for TypeAAAA query")
+
 		return lh.emptyResponse(state)
 	}
 
+	endpoints := make([]Endpoint, len(ips))
+	for i, ip := range ips {
+		endpoints[i] = Endpoint{IP: ip, Cluster: firstCluster}
+		if cluster, ok := representatives[ip]; ok {
+			endpoints[i].Cluster = cluster
+		} else if isHeadless {
+			endpoints[i].Cluster = lh.endpointSlices.GetClusterForIP(pReq.namespace, pReq.service, ip)
+		}
+	}
+
+	endpoints = lh.answerOrder(isHeadless).Order(endpoints, QueryContext{
+		Namespace:        pReq.namespace,
+		Service:          pReq.service,
+		Client:           state.IP(),
+		LocalCluster:     lh.clusterStatus.LocalClusterID(),
+		ClientIPAffinity: lh.serviceImports.HasClientIPAffinity(pReq.namespace, pReq.service),
+		Headless:         isHeadless,
+	})
+
+	// Clients retry a truncated UDP answer over TCP, which gets all the addresses.
+	truncated := lh.maxAnswers > 0 && len(endpoints) > lh.maxAnswers && state.Proto() == "udp"
+	if truncated {
+		log.Debugf("Truncating the answer for %q from %d to %d addresses", qname, len(endpoints), lh.maxAnswers)
+		endpoints = endpoints[:lh.maxAnswers]
+	}
+
+	if isHeadless {
+		for _, endpoint := range endpoints {
+			t.clusterSelected(endpoint.Cluster)
+		}
+	} else {
+		t.clusterSelected(firstCluster)
+	}
+
+	t.firstSelected(endpoints[0])
+
+	if !t.isDryRun() {
+		lh.recordFirstCluster(pReq, endpoints[0].Cluster)
+	}
+
 	records := make([]dns.RR, 0)
+	name := state.QName()
+	addresses := 0
 
-	for _, ip := range ips {
-		record := &dns.A{Hdr: dns.RR_Header{Name: state.QName(), Rrtype: dns.TypeA, Class: state.QClass(), Ttl: lh.ttl}, A: net.ParseIP(ip).To4()}
+	if cname != nil {
+		records = append(records, cname)
+		name = cname.Target
+	}
+
+	if vipCNAME := lh.vipCNAME(state, pReq, name, vip, vipQuery); vipCNAME != nil {
+		records = append(records, vipCNAME)
+		name = vipCNAME.Target
+	}
+
+	for _, endpoint := range endpoints {
+		ip := net.ParseIP(endpoint.IP).To4()
+		if ip == nil {
+			// Answer with the other endpoints rather than an A record without an address.
+			log.Warningf("Skipping the endpoint %q from %q for %q as it's not an IPv4 address", endpoint.IP,
+				endpoint.Cluster, state.QName())
+			continue
+		}
+
+		record := &dns.A{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: state.QClass(), Ttl: lh.ttl}, A: ip}
 		log.Debugf("rr is %v", record)
 		records = append(records, record)
+		addresses++
+	}
+
+	if addresses == 0 {
+		return lh.emptyResponse(state)
 	}
 
 	a := new(dns.Msg)
 	a.SetReply(r)
 	a.Authoritative = true
+	a.Truncated = truncated
 	a.Answer = append(a.Answer, records...)
 	log.Debugf("Responding to query with '%s'", a.Answer)
 
-	wErr := w.WriteMsg(a)
+	return lh.writeMsg(state, a)
+}
+
+// clustersetVIP returns the service's clusterset VIP of the family of the query type.
+func (lh *Lighthouse) clustersetVIP(pReq recordRequest, qType uint16) string {
+	if qType == dns.TypeAAAA {
+		return lh.serviceImports.GetClustersetIPv6(pReq.namespace, pReq.service)
+	}
+
+	return lh.serviceImports.GetClustersetIP(pReq.namespace, pReq.service)
+}
+
+// vipCNAME returns the CNAME from name to the VIP's name if the service is answered with a clusterset VIP and the
+// clusterset-ip-cname option is set, otherwise nil. The VIP's name itself is answered with the VIP.
+func (lh *Lighthouse) vipCNAME(state request.Request, pReq recordRequest, name, vip string, vipQuery bool) *dns.CNAME {
+	if !lh.clustersetIPCNAME || vipQuery || vip == "" {
+		return nil
+	}
+
+	return &dns.CNAME{
+		Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeCNAME, Class: state.QClass(), Ttl: lh.clustersetIPCNAMETTL},
+		Target: canonicalName(recordRequest{cluster: vipLabel, service: pReq.service, namespace: pReq.namespace},
+			state.Zone),
+	}
+}
+
+// clustersetIPv6Response answers an AAAA query for a service with an IPv6 clusterset VIP, as long as it's available
+// from any cluster, with the VIP, preceded by the alias CNAME and the CNAME to the VIP's name, if any.
+func (lh *Lighthouse) clustersetIPv6Response(state request.Request, pReq recordRequest, clustersetIPv6 string,
+	cname *dns.CNAME, vipQuery bool) (int, error) {
+	records := []dns.RR{}
+	name := state.QName()
+
+	if cname != nil {
+		records = append(records, cname)
+		name = cname.Target
+	}
+
+	if vipCNAME := lh.vipCNAME(state, pReq, name, clustersetIPv6, vipQuery); vipCNAME != nil {
+		records = append(records, vipCNAME)
+		name = vipCNAME.Target
+	}
+
+	records = append(records, &dns.AAAA{
+		Hdr:  dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA, Class: state.QClass(), Ttl: lh.ttl},
+		AAAA: net.ParseIP(clustersetIPv6),
+	})
+
+	return lh.writeRecords(state, records)
+}
+
+// localZoneResponse answers an A query in a local zone, such as cluster.local, for a service exported by the local
+// cluster with its local endpoints. The zone belongs to the kubernetes plugin so every other query is passed to the
+// next plugin rather than failed.
+func (lh *Lighthouse) localZoneResponse(ctx context.Context, state request.Request, zone string) (int, error) {
+	if state.QType() != dns.TypeA {
+		return lh.next(ctx, state.W, state.Req)
+	}
+
+	state.Zone = state.QName()[len(state.QName())-len(zone):] // maintain case of original query
+
+	pReq, pErr := parseRequest(state)
+	if pErr != nil || pReq.podOrSvc != Svc || pReq.cluster != "" || pReq.hostname != "" {
+		return lh.next(ctx, state.W, state.Req)
+	}
+
+	var ips []string
+
+	localClusterID := lh.clusterStatus.LocalClusterID()
+
+	if _, found, _ := lh.serviceImports.GetIP(pReq.namespace, pReq.service, localClusterID, localClusterID, nil,
+		nil); found {
+		if ip, found := lh.localServices.GetIP(pReq.service, pReq.namespace); found && ip != "" {
+			ips = []string{ip}
+		}
+	} else {
+		ips, _ = lh.endpointSlices.GetIPs("", localClusterID, pReq.namespace, pReq.service, nil)
+	}
+
+	if len(ips) == 0 {
+		log.Debugf("No local endpoints found for %q in the local zone", state.QName())
+		return lh.next(ctx, state.W, state.Req)
+	}
+
+	if lh.serviceImports.HasClustersetPriority(pReq.namespace, pReq.service) {
+		if clustersetIPs := lh.clustersetIPs(pReq); len(clustersetIPs) > 0 {
+			log.Debugf("Answering %q with the clusterset endpoints as per its DNS priority", state.QName())
+			ips = clustersetIPs
+		}
+	}
+
+	// Without an IPv4 address left, the answer is empty rather than an A record without an address.
+	records := []dns.RR{}
+
+	for _, ip := range ips {
+		ipv4 := net.ParseIP(ip).To4()
+		if ipv4 == nil {
+			log.Warningf("Skipping the local address %q for %q as it's not an IPv4 address", ip, state.QName())
+			continue
+		}
+
+		records = append(records, &dns.A{Hdr: dns.RR_Header{Name: state.QName(), Rrtype: dns.TypeA, Class: state.QClass(),
+			Ttl: lh.ttl}, A: ipv4})
+	}
+
+	return lh.writeRecords(state, records)
+}
+
+// clustersetIPs returns the IPs the service is answered with in the clusterset zones, without a specific cluster or
+// client, or none if no cluster is available.
+func (lh *Lighthouse) clustersetIPs(pReq recordRequest) []string {
+	eligible := lh.exclusionFilter(pReq, lh.variantFilter(pReq, lh.activeVariants[pReq.namespace+"/"+pReq.service]), nil)
+	inRegion, outOfRegion := lh.regionFilters(pReq, eligible)
+
+	for _, filter := range []func(string) bool{inRegion, outOfRegion} {
+		if filter == nil {
+			continue
+		}
+
+		if ip, _, found := lh.getClusterIpForSvc(pReq, "", filter, nil); found {
+			if ip != "" {
+				return []string{ip}
+			}
+
+			continue
+		}
+
+		if ips, _, _ := lh.getHeadlessIPs(pReq, "", filter, nil); len(ips) > 0 {
+			return ips
+		}
+	}
+
+	return nil
+}
+
+// writeMsg writes the reply, compressed unless disabled, with an OPT record reflecting the request's, so the
+// supported EDNS0 options such as NSID and COOKIE are echoed back.
+func (lh *Lighthouse) writeMsg(state request.Request, a *dns.Msg) (int, error) {
+	a.Compress = !lh.noCompression
+	state.SizeAndDo(a)
+
+	wErr := state.W.WriteMsg(a)
+	if errors.Is(wErr, errQueryTimedOut) {
+		log.Debugf("Discarding the answer to %q as the query timed out", state.QName())
+		return dns.RcodeServerFailure, wErr
+	}
+
 	if wErr != nil {
 		// Error writing reply msg
 		log.Errorf("Failed to write message %#v: %v", a, wErr)
@@ -116,28 +530,395 @@ func (lh *Lighthouse) emptyResponse(state request.Request) (int, error) {
 	a.SetReply(state.Req)
 	a.Authoritative = true
 
-	wErr := state.W.WriteMsg(a)
-	if wErr != nil {
-		// Error writing reply msg
-		log.Errorf("Failed to write message %#v: %v", a, wErr)
-		return dns.RcodeServerFailure, lh.error("failed to write response")
+	return lh.writeMsg(state, a)
+}
+
+// clustersRecords returns a TXT record for each cluster exporting the service, with its connectivity status and
+// number of endpoints as space-separated "key=value" fields.
+func (lh *Lighthouse) clustersRecords(state request.Request, pReq recordRequest) []dns.RR {
+	records := []dns.RR{}
+
+	for _, clusterID := range lh.serviceImports.GetClusters(pReq.namespace, pReq.service) {
+		records = append(records, &dns.TXT{
+			Hdr: dns.RR_Header{Name: state.QName(), Rrtype: dns.TypeTXT, Class: state.QClass(), Ttl: lh.ttl},
+			Txt: []string{fmt.Sprintf("cluster=%s connected=%t endpoints=%d", clusterID,
+				lh.clusterStatus.IsConnected(clusterID), lh.endpointSlices.GetIPCount(pReq.namespace, pReq.service, clusterID))},
+		})
 	}
 
-	return dns.RcodeSuccess, nil
+	return records
+}
+
+func (lh *Lighthouse) writeRecords(state request.Request, records []dns.RR) (int, error) {
+	a := new(dns.Msg)
+	a.SetReply(state.Req)
+	a.Authoritative = true
+	a.Answer = records
+
+	return lh.writeMsg(state, a)
 }
 
-func (lh *Lighthouse) getClusterIpForSvc(pReq recordRequest) (ip string, found bool) {
+// getFallback returns the fallback configured for the service if none of the clusters exporting it is connected.
+func (lh *Lighthouse) getFallback(pReq recordRequest) string {
+	fallback := lh.configuredFallback(pReq)
+	if fallback == "" {
+		return ""
+	}
+
+	for _, clusterID := range lh.serviceImports.GetClusters(pReq.namespace, pReq.service) {
+		if lh.clusterStatus.IsConnected(clusterID) {
+			return ""
+		}
+	}
+
+	return fallback
+}
+
+// configuredFallback returns the fallback configured for the service, or the global one if it has none.
+func (lh *Lighthouse) configuredFallback(pReq recordRequest) string {
+	if fallback, ok := lh.serviceFallbacks[pReq.namespace+"/"+pReq.service]; ok {
+		return fallback
+	}
+
+	return lh.fallback
+}
+
+// fallbackResponse answers with an A record for a fallback IP or a CNAME record for a fallback hostname.
+func (lh *Lighthouse) fallbackResponse(state request.Request, fallback string) (int, error) {
+	var record dns.RR
+
+	hdr := dns.RR_Header{Name: state.QName(), Class: state.QClass(), Ttl: lh.ttl}
+
+	if ip := net.ParseIP(fallback); ip != nil {
+		if state.QType() == dns.TypeAAAA {
+			return lh.emptyResponse(state)
+		}
+
+		hdr.Rrtype = dns.TypeA
+		record = &dns.A{Hdr: hdr, A: ip.To4()}
+	} else {
+		hdr.Rrtype = dns.TypeCNAME
+		record = &dns.CNAME{Hdr: hdr, Target: dns.Fqdn(fallback)}
+	}
+
+	a := new(dns.Msg)
+	a.SetReply(state.Req)
+	a.Authoritative = true
+	a.Answer = []dns.RR{record}
+
+	return lh.writeMsg(state, a)
+}
+
+// getClusterIpForSvc returns the IP of a cluster exporting the service. If a client address is given, services with
+// ClientIP session affinity consistently return the same cluster for it rather than round-robin. Singleton services
+// return their primary cluster to every client. The ID of the cluster
+// the IP belongs to is also returned, or an empty string if it doesn't belong to a single cluster.
+func (lh *Lighthouse) getClusterIpForSvc(pReq recordRequest, client string, inVariant func(string) bool,
+	t *queryTrace) (ip, clusterID string, found bool) {
 	localClusterID := lh.clusterStatus.LocalClusterID()
+	isConnected, checkEndpoint := lh.clusterChecks(pReq, inVariant, t)
+
+	var isLocal bool
 
-	ip, found, isLocal := lh.serviceImports.GetIP(pReq.namespace, pReq.service, pReq.cluster, localClusterID, lh.clusterStatus.IsConnected,
-		lh.endpointsStatus.IsHealthy)
+	if pReq.cluster == "" && lh.serviceImports.IsSingleton(pReq.namespace, pReq.service) {
+		ip, found, isLocal = lh.serviceImports.GetPrimaryIP(pReq.namespace, pReq.service, localClusterID, isConnected,
+			checkEndpoint)
+	} else {
+		getIP := lh.serviceImports.GetIPForClient
+		if t.isDryRun() {
+			getIP = lh.serviceImports.PeekIPForClient
+		}
+
+		ip, found, isLocal = getIP(pReq.namespace, pReq.service, pReq.cluster, lh.preferredClusterID(pReq.namespace),
+			client, isConnected, checkEndpoint)
+	}
 
 	getLocal := isLocal || (pReq.cluster != "" && pReq.cluster == localClusterID)
 	if found && getLocal {
 		ip, found = lh.localServices.GetIP(pReq.service, pReq.namespace)
+		return ip, localClusterID, found
+	}
+
+	if pReq.cluster != "" {
+		return ip, pReq.cluster, found
+	}
+
+	return ip, lh.serviceImports.GetClusterForIP(pReq.namespace, pReq.service, ip), found
+}
+
+// clusterChecks returns the checks of the clusters a ClusterSetIP service can be answered from: whether a cluster is
+// connected and whether its export is in the variant, fresh, resolvable and healthy.
+func (lh *Lighthouse) clusterChecks(pReq recordRequest, inVariant func(string) bool,
+	t *queryTrace) (func(string) bool, func(string, string, string) bool) {
+	isHealthy := t.checkCluster(clusterUnhealthy, func(clusterID string) bool {
+		return lh.isEndpointHealthy(pReq.service, pReq.namespace, clusterID, t.isDryRun())
+	})
+	isFresh := t.checkCluster(clusterStale, lh.freshnessFilter(pReq))
+	isResolvable := t.checkCluster(clusterPending, lh.exportDelayFilter(pReq))
+
+	isConnected := t.checkCluster(clusterDisconnected, lh.clusterStatus.IsConnected)
+	checkEndpoint := func(name, namespace, clusterID string) bool {
+		return inVariant(clusterID) && isFresh(clusterID) && isResolvable(clusterID) && isHealthy(clusterID)
+	}
+
+	return isConnected, checkEndpoint
+}
+
+// getHeadlessIPs returns the endpoint IPs of a headless service. If max-clusters is configured, the IPs come from at
+// most that many clusters, preferring the local cluster and then in round-robin order or, for sticky answers, in the
+// order ranked for the client. The IPs are ordered afterwards by the AnswerOrderer. Singleton services only return
+// their primary endpoint, from any of the clusters. If dedup-endpoints is configured, an IP exported by several clusters
+// is only returned once, mapped to the cluster it's attributed to.
+func (lh *Lighthouse) getHeadlessIPs(pReq recordRequest, client string, inVariant func(string) bool,
+	t *queryTrace) ([]string, map[string]string, bool) {
+	isConnected := t.checkCluster(clusterDisconnected, lh.clusterStatus.IsConnected)
+	isFresh := t.checkCluster(clusterStale, lh.freshnessFilter(pReq))
+	isResolvable := t.checkCluster(clusterPending, lh.exportDelayFilter(pReq))
+	checkCluster := func(clusterID string) bool {
+		return inVariant(clusterID) && lh.serviceImports.IsMerged(pReq.namespace, pReq.service, clusterID) &&
+			lh.breakerAllows(pReq.service, pReq.namespace, clusterID, t.isDryRun()) && (lh.localOnly || isConnected(clusterID)) &&
+			isFresh(clusterID) && isResolvable(clusterID)
+	}
+
+	var rank func([]string) []string
+	if client != "" && lh.serviceImports.HasClientIPAffinity(pReq.namespace, pReq.service) {
+		rank = func(members []string) []string {
+			return consistenthash.Rank(client, members)
+		}
+	}
+
+	var (
+		ips   []string
+		found bool
+	)
+
+	if pReq.cluster == "" && lh.serviceImports.IsSingleton(pReq.namespace, pReq.service) {
+		primary, found := lh.endpointSlices.GetPrimaryIP(pReq.namespace, pReq.service, checkCluster)
+		if primary == "" {
+			return []string{}, nil, found
+		}
+
+		return []string{primary}, nil, found
+	}
+
+	if lh.maxClusters > 0 && pReq.cluster == "" {
+		getIPsFromClusters := lh.endpointSlices.GetIPsFromClusters
+		if t.isDryRun() {
+			getIPsFromClusters = lh.endpointSlices.PeekIPsFromClusters
+		}
+
+		ips, found = getIPsFromClusters(pReq.namespace, pReq.service, lh.preferredClusterID(pReq.namespace),
+			lh.maxClusters, rank, checkCluster)
+	} else {
+		ips, found = lh.endpointSlices.GetIPs(pReq.hostname, pReq.cluster, pReq.namespace, pReq.service, checkCluster)
+	}
+
+	if !lh.dedupEndpoints || pReq.cluster != "" {
+		return ips, nil, found
+	}
+
+	ips, representatives := lh.deduplicateIPs(pReq, ips, checkCluster)
+
+	return ips, representatives, found
+}
+
+// deduplicateIPs collapses the IPs exported by several clusters, eg pods of a shared backend reachable from each of
+// them, keeping the first occurrence of each. An IP exported by several clusters is attributed to the preferred cluster
+// if it's one of the eligible clusters exporting it, otherwise to the first of them by ID, so it's consistently
+// filtered and reported by a cluster it was selected from.
+func (lh *Lighthouse) deduplicateIPs(pReq recordRequest, ips []string, checkCluster func(string) bool) ([]string,
+	map[string]string) {
+	seen := make(map[string]bool, len(ips))
+	deduplicated := make([]string, 0, len(ips))
+	representatives := map[string]string{}
+	preferred := lh.preferredClusterID(pReq.namespace)
+	clustersByIP := lh.endpointSlices.GetClustersByIP(pReq.namespace, pReq.service)
+
+	for _, ip := range ips {
+		if seen[ip] {
+			continue
+		}
+
+		seen[ip] = true
+		deduplicated = append(deduplicated, ip)
+
+		clusters := clustersByIP[ip]
+		if len(clusters) < 2 {
+			continue
+		}
+
+		for _, clusterID := range clusters {
+			if checkCluster(clusterID) && (representatives[ip] == "" || clusterID == preferred) {
+				representatives[ip] = clusterID
+			}
+		}
+	}
+
+	if len(deduplicated) < len(ips) {
+		log.Debugf("Deduplicated the %d endpoint IPs of %q to %d", len(ips), pReq.namespace+"/"+pReq.service,
+			len(deduplicated))
+	}
+
+	return deduplicated, representatives
+}
+
+// countAvailableClusters returns the number of distinct clusters that are connected and have healthy endpoints for the
+// service, among those whose export is merged and pass the given filter.
+func (lh *Lighthouse) countAvailableClusters(pReq recordRequest, filter func(string) bool) int {
+	isFresh := lh.freshnessFilter(pReq)
+	isResolvable := lh.exportDelayFilter(pReq)
+	available := 0
+
+	for _, clusterID := range lh.serviceImports.GetClusters(pReq.namespace, pReq.service) {
+		if filter(clusterID) && lh.serviceImports.IsMerged(pReq.namespace, pReq.service, clusterID) &&
+			lh.clusterStatus.IsConnected(clusterID) && lh.isHealthy(pReq.service, pReq.namespace, clusterID) &&
+			isFresh(clusterID) && isResolvable(clusterID) {
+			available++
+		}
+	}
+
+	return available
+}
+
+// breakerAllows returns true if there's no circuit breaker or it allows the given cluster for the service. A dry run
+// doesn't half-open the breaker.
+func (lh *Lighthouse) breakerAllows(name, namespace, clusterID string, dryRun bool) bool {
+	if lh.breaker == nil {
+		return true
+	}
+
+	if dryRun {
+		return lh.breaker.Peek(namespace+"/"+name, clusterID)
+	}
+
+	return lh.breaker.Allow(namespace+"/"+name, clusterID)
+}
+
+// recordFirstCluster counts the cluster returned first in the answer to a query that lets Lighthouse choose the
+// cluster.
+func (lh *Lighthouse) recordFirstCluster(pReq recordRequest, clusterID string) {
+	if pReq.cluster == "" && clusterID != "" {
+		clusterFirstAnswers.WithLabelValues(pReq.namespace+"/"+pReq.service, clusterID).Inc()
+	}
+}
+
+// isEndpointHealthy checks the health of the service's endpoints in the given cluster. If a circuit breaker is
+// configured, the result is recorded, unless it's a dry run, and the cluster is considered unhealthy for the service
+// while the service's breaker for it is open.
+func (lh *Lighthouse) isEndpointHealthy(name, namespace, clusterID string, dryRun bool) bool {
+	if lh.breaker == nil {
+		return lh.isHealthy(name, namespace, clusterID)
+	}
+
+	if !lh.breakerAllows(name, namespace, clusterID, dryRun) {
+		return false
+	}
+
+	if dryRun {
+		return lh.isHealthy(name, namespace, clusterID)
+	}
+
+	if !lh.isHealthy(name, namespace, clusterID) {
+		lh.breaker.RecordFailure(namespace+"/"+name, clusterID)
+		return false
 	}
 
-	return ip, found
+	lh.breaker.RecordSuccess(namespace+"/"+name, clusterID)
+
+	return true
+}
+
+// clusterConnectivityChanged is notified by the Gateway controller when a cluster's connection status changes. A
+// reconnected cluster's circuit breakers are reset so they don't keep excluding it for the remainder of their
+// cooldown, given the failures were likely caused by the disconnection. The cluster is then returned for all the
+// services it exports, subject to the health of its endpoints, from the next query.
+func (lh *Lighthouse) clusterConnectivityChanged(clusterID string, connected bool) {
+	if !connected || lh.breaker == nil {
+		return
+	}
+
+	if lh.breaker.Reset(clusterID) {
+		log.Infof("Cluster %q reconnected - reset its circuit breakers", clusterID)
+	}
+}
+
+// variantFilter returns a function that checks if a cluster exports the given variant of the requested service. All
+// clusters match if no variant is given.
+func (lh *Lighthouse) variantFilter(pReq recordRequest, variant string) func(string) bool {
+	return func(clusterID string) bool {
+		// The variant is lower-cased, as it's configured or parsed from the query, but the annotation may not be.
+		return variant == "" || strings.EqualFold(lh.serviceImports.GetClusterVariant(pReq.namespace, pReq.service, clusterID),
+			variant)
+	}
+}
+
+// exclusionFilter returns a function that checks if a cluster passes the given filter and isn't excluded from answers.
+// Queries for a specific cluster aren't affected.
+func (lh *Lighthouse) exclusionFilter(pReq recordRequest, filter func(string) bool, t *queryTrace) func(string) bool {
+	if lh.excludedClusters == nil || pReq.cluster != "" {
+		return filter
+	}
+
+	isIncluded := t.checkCluster(clusterExcluded, func(clusterID string) bool {
+		return !lh.excludedClusters.IsExcluded(clusterID)
+	})
+
+	return func(clusterID string) bool {
+		return filter(clusterID) && isIncluded(clusterID)
+	}
+}
+
+// regionFilters returns functions that check if a cluster passes the given filter and is, respectively, in the local
+// cluster's region or in another region. If answers aren't restricted by region, because region-affinity isn't
+// configured, a specific cluster is requested or the local cluster's region isn't known, the first is the given filter
+// and the second is nil.
+func (lh *Lighthouse) regionFilters(pReq recordRequest, filter func(string) bool) (func(string) bool, func(string) bool) {
+	if !lh.regionAffinity || pReq.cluster != "" {
+		return filter, nil
+	}
+
+	region, ok := lh.clusterRegions[lh.clusterStatus.LocalClusterID()]
+	if !ok {
+		return filter, nil
+	}
+
+	inRegion := func(clusterID string) bool {
+		return lh.clusterRegions[clusterID] == region && filter(clusterID)
+	}
+
+	outOfRegion := func(clusterID string) bool {
+		return lh.clusterRegions[clusterID] != region && filter(clusterID)
+	}
+
+	return inRegion, outOfRegion
+}
+
+// freshnessFilter returns a function that checks if the service's EndpointSlice in a cluster has been updated within
+// the configured max age. All clusters match unless stale endpoints are excluded.
+func (lh *Lighthouse) freshnessFilter(pReq recordRequest) func(string) bool {
+	return func(clusterID string) bool {
+		return !lh.excludeStale || !lh.endpointSlices.IsStale(pReq.namespace, pReq.service, clusterID)
+	}
+}
+
+// exportDelayFilter returns a function that checks if the service's export from a cluster is past its export delay.
+func (lh *Lighthouse) exportDelayFilter(pReq recordRequest) func(string) bool {
+	return func(clusterID string) bool {
+		return !lh.serviceImports.IsPending(pReq.namespace, pReq.service, clusterID)
+	}
+}
+
+// canonicalName returns the fully qualified name of the requested record in the given zone.
+func canonicalName(pReq recordRequest, zone string) string {
+	labels := []string{}
+
+	for _, label := range []string{pReq.hostname, pReq.cluster, pReq.service, pReq.namespace, Svc} {
+		if label != "" {
+			labels = append(labels, label)
+		}
+	}
+
+	return dns.Fqdn(strings.Join(labels, ".") + "." + zone)
 }
 
 // Name implements the Handler interface.
@@ -149,9 +930,20 @@ func (lh *Lighthouse) error(str string) error {
 	return plugin.Error(lh.Name(), errors.New(str))
 }
 
+// expandShortName appends the search domain to a "<service>.<namespace>" query name that doesn't match any zone.
+func (lh *Lighthouse) expandShortName(qname string) (string, bool) {
+	if lh.searchDomain == "" || dns.CountLabel(qname) != 2 || plugin.Zones(lh.Zones).Matches(qname) != "" {
+		return qname, false
+	}
+
+	return qname + lh.searchDomain, true
+}
+
+// nextOrFailure passes the query to the next plugin if fallthrough is configured for it or it's a short name, which
+// might just as well be an external name, otherwise it fails with the given code.
 func (lh *Lighthouse) nextOrFailure(name string, ctx context.Context, w dns.ResponseWriter, r *dns.Msg, code int, err string) (int, error) {
-	if lh.Fall.Through(name) {
-		return plugin.NextOrFailure(lh.Name(), lh.Next, ctx, w, r)
+	if _, isShortName := lh.expandShortName(name); isShortName || lh.Fall.Through(name) {
+		return lh.next(ctx, w, r)
 	} else {
 		return code, lh.error(err)
 	}
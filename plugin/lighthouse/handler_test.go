@@ -17,7 +17,17 @@ package lighthouse
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/coredns/coredns/plugin"
 	"github.com/coredns/coredns/plugin/pkg/dnstest"
 	"github.com/coredns/coredns/plugin/pkg/fall"
 	"github.com/coredns/coredns/plugin/test"
@@ -25,11 +35,21 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/submariner-io/lighthouse/pkg/circuitbreaker"
 	lhconstants "github.com/submariner-io/lighthouse/pkg/constants"
 	"github.com/submariner-io/lighthouse/pkg/endpointslice"
+	"github.com/submariner-io/lighthouse/pkg/exclusion"
 	"github.com/submariner-io/lighthouse/pkg/serviceimport"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	corev1 "k8s.io/api/core/v1"
 	discovery "k8s.io/api/discovery/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	fakeKubeClient "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
 	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
 )
 
@@ -51,6 +71,45 @@ var _ = Describe("Lighthouse DNS plugin Handler", func() {
 	Context("Cluster connectivity status", testClusterStatus)
 	Context("Headless services", testHeadlessService)
 	Context("Local services", testLocalService)
+	Context("Service variants", testServiceVariants)
+	Context("Local-only mode", testLocalOnly)
+	Context("Circuit breaker", testCircuitBreaker)
+	Context("Service aliases", testServiceAliases)
+	Context("Alias zones", testAliasZones)
+	Context("DNS stickiness", testDNSStickiness)
+	Context("Maximum clusters", testMaxClusters)
+	Context("Fallback", testFallback)
+	Context("Cluster first answer metrics", testClusterFirstAnswers)
+	Context("Short names", testShortNames)
+	Context("Tracing", testTracing)
+	Context("Clusters TXT records", testClustersTXT)
+	Context("Stale endpoints", testStaleEndpoints)
+	Context("Region affinity", testRegionAffinity)
+	Context("Answer ordering", testAnswerOrdering)
+	Context("Standby mode", testStandby)
+	Context("Aggregated headless answers", testAggregatedHeadless)
+	Context("Debug TXT queries", testDebugTXT)
+	Context("Case-insensitive queries", testCaseInsensitivity)
+	Context("Minimum clusters", testMinClusters)
+	Context("Message options", testMessageOptions)
+	Context("Excluded clusters", testExcludedClusters)
+	Context("Local zone", testLocalZone)
+	Context("Clusterset UID queries", testUIDQueries)
+	Context("Queries before the initial sync", testPreSync)
+	Context("Answer snapshot", testSnapshot)
+	Context("Query timeout", testQueryTimeout)
+	Context("Global names", testGlobalNames)
+	Context("Partial results", testPartialResults)
+	Context("Affinity query", testAffinityQuery)
+	Context("Singleton services", testSingleton)
+	Context("Clusterset IP CNAME", testClustersetIPCNAME)
+	Context("Internal traffic policy", testInternalTrafficPolicy)
+	Context("Unavailable services", testUnavailableServices)
+	Context("Namespace policies", testNamespacePolicies)
+	Context("Frozen services", testFrozenServices)
+	Context("Clusterset readiness", testClusterSetReadiness)
+	Context("Endpoint deduplication", testEndpointDeduplication)
+	Context("Export delay", testExportDelay)
 })
 
 type FailingResponseWriter struct {
@@ -87,6 +146,17 @@ func (m *MockClusterStatus) LocalClusterID() string {
 	return m.localClusterID
 }
 
+// slowClusterStatus delays each connectivity check, to simulate a query that takes long to answer.
+type slowClusterStatus struct {
+	*MockClusterStatus
+	delay time.Duration
+}
+
+func (m *slowClusterStatus) IsConnected(clusterId string) bool {
+	time.Sleep(m.delay)
+	return m.MockClusterStatus.IsConnected(clusterId)
+}
+
 type MockLocalServices struct {
 	LocalServicesMap map[string]string
 }
@@ -158,6 +228,95 @@ func testWithoutFallback() {
 		})
 	})
 
+	When("type A DNS query for an existing service with a clusterset IP", func() {
+		It("should succeed and write an A record response with the clusterset IP", func() {
+			lh.serviceImports.Put(newServiceImportWithClustersetIPs(namespace2, service1, clusterID, serviceIP, "243.0.0.1"))
+
+			executeTestCase(lh, rec, test.Case{
+				Qname: service1 + "." + namespace2 + ".svc.clusterset.local.",
+				Qtype: dns.TypeA,
+				Rcode: dns.RcodeSuccess,
+				Answer: []dns.RR{
+					test.A(service1 + "." + namespace2 + ".svc.clusterset.local.    5    IN    A    243.0.0.1"),
+				},
+			})
+		})
+	})
+
+	When("DNS queries for an existing service with IPv4 and IPv6 clusterset IPs", func() {
+		BeforeEach(func() {
+			lh.serviceImports.Put(newServiceImportWithClustersetIPs(namespace2, service1, clusterID, serviceIP, "243.0.0.1", "fd00:243::1"))
+		})
+
+		It("should write an A record response with the IPv4 clusterset IP", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname: service1 + "." + namespace2 + ".svc.clusterset.local.",
+				Qtype: dns.TypeA,
+				Rcode: dns.RcodeSuccess,
+				Answer: []dns.RR{
+					test.A(service1 + "." + namespace2 + ".svc.clusterset.local.    5    IN    A    243.0.0.1"),
+				},
+			})
+		})
+
+		It("should write an AAAA record response with the IPv6 clusterset IP", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname: service1 + "." + namespace2 + ".svc.clusterset.local.",
+				Qtype: dns.TypeAAAA,
+				Rcode: dns.RcodeSuccess,
+				Answer: []dns.RR{
+					test.AAAA(service1 + "." + namespace2 + ".svc.clusterset.local.    5    IN    AAAA    fd00:243::1"),
+				},
+			})
+		})
+
+		It("should write an empty AAAA response for a specific cluster", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname:  clusterID + "." + service1 + "." + namespace2 + ".svc.clusterset.local.",
+				Qtype:  dns.TypeAAAA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{},
+			})
+		})
+
+		It("should write an empty AAAA response if no cluster is connected", func() {
+			lh.clusterStatus.(*MockClusterStatus).clusterStatusMap[clusterID] = false
+
+			executeTestCase(lh, rec, test.Case{
+				Qname:  service1 + "." + namespace2 + ".svc.clusterset.local.",
+				Qtype:  dns.TypeAAAA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{},
+			})
+		})
+	})
+
+	When("DNS queries for an existing service with only an IPv6 clusterset IP", func() {
+		BeforeEach(func() {
+			lh.serviceImports.Put(newServiceImportWithClustersetIPs(namespace2, service1, clusterID, "fd00:96::1", "fd00:243::1"))
+		})
+
+		It("should write an empty A response", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname:  service1 + "." + namespace2 + ".svc.clusterset.local.",
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{},
+			})
+		})
+
+		It("should write an AAAA record response with the IPv6 clusterset IP", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname: service1 + "." + namespace2 + ".svc.clusterset.local.",
+				Qtype: dns.TypeAAAA,
+				Rcode: dns.RcodeSuccess,
+				Answer: []dns.RR{
+					test.AAAA(service1 + "." + namespace2 + ".svc.clusterset.local.    5    IN    AAAA    fd00:243::1"),
+				},
+			})
+		})
+	})
+
 	When("type A DNS query for an existing service with a different namespace", func() {
 		It("should succeed and write an A record response", func() {
 			lh.serviceImports.Put(newServiceImport(namespace2, service1, clusterID, serviceIP, mcsv1a1.ClusterSetIP))
@@ -318,6 +477,17 @@ func testWithFallback() {
 			})
 		})
 	})
+
+	When("type A DNS query for a non-existent service and non-matching fallthrough zone", func() {
+		It("should return RcodeNameError", func() {
+			lh.Fall = fall.F{Zones: []string{"cluster.east."}}
+			executeTestCase(lh, rec, test.Case{
+				Qname: "unknown." + namespace1 + ".svc.clusterset.local.",
+				Qtype: dns.TypeA,
+				Rcode: dns.RcodeNameError,
+			})
+		})
+	})
 }
 
 func testClusterStatus() {
@@ -628,6 +798,4705 @@ func testLocalService() {
 	})
 }
 
+func testServiceVariants() {
+	var (
+		rec *dnstest.Recorder
+		lh  *Lighthouse
+	)
+
+	BeforeEach(func() {
+		mockCs := NewMockClusterStatus()
+		mockCs.clusterStatusMap[clusterID] = true
+		mockCs.clusterStatusMap[clusterID2] = true
+		mockEs := NewMockEndpointStatus()
+		mockEs.endpointStatusMap[clusterID] = true
+		mockEs.endpointStatusMap[clusterID2] = true
+		lh = &Lighthouse{
+			Zones:           []string{"clusterset.local."},
+			serviceImports:  serviceimport.NewMap(),
+			endpointSlices:  setupEndpointSliceMap(),
+			clusterStatus:   mockCs,
+			endpointsStatus: mockEs,
+			localServices:   NewMockLocalServices(),
+			ttl:             defaultTtl,
+			activeVariants:  map[string]string{namespace1 + "/" + service1: "blue"},
+		}
+
+		lh.serviceImports.Put(newServiceImportVariant(namespace1, service1, clusterID, serviceIP, "blue"))
+		lh.serviceImports.Put(newServiceImportVariant(namespace1, service1, clusterID2, serviceIP2, "green"))
+
+		rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	})
+
+	qname := service1 + "." + namespace1 + ".svc.clusterset.local."
+
+	When("the active variant is set", func() {
+		It("should consistently return only the active variant's IP", func() {
+			for i := 0; i < 3; i++ {
+				executeTestCase(lh, rec, test.Case{
+					Qname:  qname,
+					Qtype:  dns.TypeA,
+					Rcode:  dns.RcodeSuccess,
+					Answer: []dns.RR{test.A(qname + "    5    IN    A    " + serviceIP)},
+				})
+			}
+		})
+	})
+
+	When("the active variant is flipped", func() {
+		It("should return the new active variant's IP", func() {
+			lh.activeVariants[namespace1+"/"+service1] = "green"
+
+			for i := 0; i < 3; i++ {
+				executeTestCase(lh, rec, test.Case{
+					Qname:  qname,
+					Qtype:  dns.TypeA,
+					Rcode:  dns.RcodeSuccess,
+					Answer: []dns.RR{test.A(qname + "    5    IN    A    " + serviceIP2)},
+				})
+			}
+		})
+	})
+
+	When("a non-active variant is requested by its variant-qualified name", func() {
+		It("should return the variant's IP", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname:  "green." + qname,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{test.A("green." + qname + "    5    IN    A    " + serviceIP2)},
+			})
+		})
+	})
+
+	When("a variant's annotation isn't lower-case", func() {
+		BeforeEach(func() {
+			lh.serviceImports.Put(newServiceImportVariant(namespace1, service1, clusterID2, serviceIP2, "Green"))
+			lh.activeVariants[namespace1+"/"+service1] = "green"
+		})
+
+		It("should match it as the active variant", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname:  qname,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{test.A(qname + "    5    IN    A    " + serviceIP2)},
+			})
+		})
+
+		It("should match it by its variant-qualified name", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname:  "GREEN." + qname,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{test.A("GREEN." + qname + "    5    IN    A    " + serviceIP2)},
+			})
+		})
+	})
+
+	When("a specific cluster is requested", func() {
+		It("should return the cluster's IP regardless of the active variant", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname:  clusterID2 + "." + qname,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{test.A(clusterID2 + "." + qname + "    5    IN    A    " + serviceIP2)},
+			})
+		})
+	})
+}
+
+func testLocalOnly() {
+	var (
+		rec    *dnstest.Recorder
+		lh     *Lighthouse
+		mockCs *MockClusterStatus
+	)
+
+	BeforeEach(func() {
+		mockCs = NewMockClusterStatus()
+		mockCs.clusterStatusMap[clusterID] = true
+		mockCs.clusterStatusMap[clusterID2] = true
+		mockCs.localClusterID = clusterID
+		mockEs := NewMockEndpointStatus()
+		mockEs.endpointStatusMap[clusterID] = true
+		mockEs.endpointStatusMap[clusterID2] = true
+		mockLs := NewMockLocalServices()
+		mockLs.LocalServicesMap[getKey(service1, namespace1)] = serviceIP
+		lh = &Lighthouse{
+			Zones:           []string{"clusterset.local."},
+			serviceImports:  setupServiceImportMap(),
+			endpointSlices:  setupEndpointSliceMap(),
+			clusterStatus:   mockCs,
+			endpointsStatus: mockEs,
+			localServices:   mockLs,
+			ttl:             defaultTtl,
+			localOnly:       true,
+		}
+		lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID2, serviceIP2, mcsv1a1.ClusterSetIP))
+
+		rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	})
+
+	qname := service1 + "." + namespace1 + ".svc.clusterset.local."
+
+	When("service is in local and remote clusters", func() {
+		It("should consistently return only the local cluster's IP", func() {
+			for i := 0; i < 3; i++ {
+				executeTestCase(lh, rec, test.Case{
+					Qname:  qname,
+					Qtype:  dns.TypeA,
+					Rcode:  dns.RcodeSuccess,
+					Answer: []dns.RR{test.A(qname + "    5    IN    A    " + serviceIP)},
+				})
+			}
+		})
+	})
+
+	When("service is in local and remote clusters, and local has no active endpoints", func() {
+		JustBeforeEach(func() {
+			lh.endpointsStatus.(*MockEndpointStatus).endpointStatusMap[clusterID] = false
+		})
+
+		It("should return RcodeNameError", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname: qname,
+				Qtype: dns.TypeA,
+				Rcode: dns.RcodeNameError,
+			})
+		})
+	})
+
+	When("the remote cluster is requested", func() {
+		It("should return RcodeNameError", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname: clusterID2 + "." + qname,
+				Qtype: dns.TypeA,
+				Rcode: dns.RcodeNameError,
+			})
+		})
+	})
+
+	When("service is in the remote cluster only", func() {
+		BeforeEach(func() {
+			lh.serviceImports.Put(newServiceImport(namespace2, service1, clusterID2, serviceIP2, mcsv1a1.ClusterSetIP))
+		})
+
+		It("should return RcodeNameError", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname: service1 + "." + namespace2 + ".svc.clusterset.local.",
+				Qtype: dns.TypeA,
+				Rcode: dns.RcodeNameError,
+			})
+		})
+	})
+
+	When("headless service is in local and remote clusters", func() {
+		BeforeEach(func() {
+			lh.serviceImports.Put(newServiceImport(namespace2, service1, clusterID, "", mcsv1a1.Headless))
+			lh.serviceImports.Put(newServiceImport(namespace2, service1, clusterID2, "", mcsv1a1.Headless))
+			lh.endpointSlices.Put(newEndpointSlice(namespace2, service1, clusterID, []string{endpointIP}))
+			lh.endpointSlices.Put(newEndpointSlice(namespace2, service1, clusterID2, []string{endpointIP2}))
+			mockCs.clusterStatusMap[clusterID] = false
+		})
+
+		It("should return only the local cluster's endpoint IPs regardless of connectivity", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname: service1 + "." + namespace2 + ".svc.clusterset.local.",
+				Qtype: dns.TypeA,
+				Rcode: dns.RcodeSuccess,
+				Answer: []dns.RR{
+					test.A(service1 + "." + namespace2 + ".svc.clusterset.local.    5    IN    A    " + endpointIP),
+				},
+			})
+		})
+	})
+}
+
+func testCircuitBreaker() {
+	const cooldown = 300 * time.Millisecond
+
+	var (
+		rec    *dnstest.Recorder
+		lh     *Lighthouse
+		mockEs *MockEndpointStatus
+	)
+
+	BeforeEach(func() {
+		mockCs := NewMockClusterStatus()
+		mockCs.clusterStatusMap[clusterID] = true
+		mockCs.clusterStatusMap[clusterID2] = true
+		mockEs = NewMockEndpointStatus()
+		mockEs.endpointStatusMap[clusterID] = true
+		mockEs.endpointStatusMap[clusterID2] = false
+		lh = &Lighthouse{
+			Zones:           []string{"clusterset.local."},
+			serviceImports:  setupServiceImportMap(),
+			endpointSlices:  setupEndpointSliceMap(),
+			clusterStatus:   mockCs,
+			endpointsStatus: mockEs,
+			localServices:   NewMockLocalServices(),
+			ttl:             defaultTtl,
+			breaker:         circuitbreaker.New(2, cooldown),
+		}
+		lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID2, serviceIP2, mcsv1a1.ClusterSetIP))
+
+		rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	})
+
+	qname := service1 + "." + namespace1 + ".svc.clusterset.local."
+	breakerKey := namespace1 + "/" + service1
+
+	query := func(expIP string) {
+		executeTestCase(lh, rec, test.Case{
+			Qname:  qname,
+			Qtype:  dns.TypeA,
+			Rcode:  dns.RcodeSuccess,
+			Answer: []dns.RR{test.A(qname + "    5    IN    A    " + expIP)},
+		})
+	}
+
+	When("a cluster's endpoints repeatedly fail health checks", func() {
+		BeforeEach(func() {
+			for i := 0; i < 4; i++ {
+				query(serviceIP)
+			}
+		})
+
+		It("should open the cluster's breaker", func() {
+			Expect(lh.breaker.GetState(breakerKey, clusterID2)).To(Equal(circuitbreaker.Open))
+			Expect(lh.breaker.GetState(breakerKey, clusterID)).To(Equal(circuitbreaker.Closed))
+		})
+
+		It("should not return the cluster during the cooldown even if it recovers", func() {
+			mockEs.endpointStatusMap[clusterID2] = true
+
+			for i := 0; i < 4; i++ {
+				query(serviceIP)
+			}
+		})
+
+		It("should return the cluster again after the cooldown once it recovers", func() {
+			mockEs.endpointStatusMap[clusterID2] = true
+			time.Sleep(cooldown)
+
+			ips := map[string]bool{}
+
+			for i := 0; i < 4; i++ {
+				_, err := lh.ServeDNS(context.TODO(), rec, test.Case{Qname: qname, Qtype: dns.TypeA}.Msg())
+				Expect(err).To(Succeed())
+				ips[rec.Msg.Answer[0].(*dns.A).A.String()] = true
+			}
+
+			Expect(ips).To(HaveKey(serviceIP2))
+			Expect(lh.breaker.GetState(breakerKey, clusterID2)).To(Equal(circuitbreaker.Closed))
+		})
+
+		It("should return the cluster again as soon as it reconnects", func() {
+			mockEs.endpointStatusMap[clusterID2] = true
+			lh.clusterConnectivityChanged(clusterID2, true)
+			Expect(lh.breaker.GetState(breakerKey, clusterID2)).To(Equal(circuitbreaker.Closed))
+
+			ips := map[string]bool{}
+
+			for i := 0; i < 4; i++ {
+				_, err := lh.ServeDNS(context.TODO(), rec, test.Case{Qname: qname, Qtype: dns.TypeA}.Msg())
+				Expect(err).To(Succeed())
+				ips[rec.Msg.Answer[0].(*dns.A).A.String()] = true
+			}
+
+			Expect(ips).To(HaveKey(serviceIP2))
+		})
+
+		It("should still return the cluster for the other services", func() {
+			const service2 = "service2"
+
+			lh.serviceImports.Put(newServiceImport(namespace1, service2, clusterID2, serviceIP2, mcsv1a1.ClusterSetIP))
+			mockEs.endpointStatusMap[clusterID2] = true
+
+			qname2 := service2 + "." + namespace1 + ".svc.clusterset.local."
+			executeTestCase(lh, rec, test.Case{
+				Qname:  qname2,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{test.A(qname2 + "    5    IN    A    " + serviceIP2)},
+			})
+
+			Expect(lh.breaker.GetState(namespace1+"/"+service2, clusterID2)).To(Equal(circuitbreaker.Closed))
+			Expect(lh.breaker.GetState(breakerKey, clusterID2)).To(Equal(circuitbreaker.Open))
+		})
+
+		It("should not reset the cluster's breaker when it disconnects", func() {
+			lh.clusterConnectivityChanged(clusterID2, false)
+			Expect(lh.breaker.GetState(breakerKey, clusterID2)).To(Equal(circuitbreaker.Open))
+		})
+	})
+}
+
+func testServiceAliases() {
+	const alias = "alias1"
+
+	var (
+		rec *dnstest.Recorder
+		lh  *Lighthouse
+	)
+
+	BeforeEach(func() {
+		mockCs := NewMockClusterStatus()
+		mockCs.clusterStatusMap[clusterID] = true
+		mockEs := NewMockEndpointStatus()
+		mockEs.endpointStatusMap[clusterID] = true
+		lh = &Lighthouse{
+			Zones:           []string{"clusterset.local."},
+			serviceImports:  setupServiceImportMap(),
+			endpointSlices:  setupEndpointSliceMap(),
+			clusterStatus:   mockCs,
+			endpointsStatus: mockEs,
+			localServices:   NewMockLocalServices(),
+			ttl:             defaultTtl,
+			aliases:         map[string]string{namespace1 + "/" + alias: namespace1 + "/" + service1},
+		}
+
+		rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	})
+
+	aliasName := alias + "." + namespace1 + ".svc.clusterset.local."
+	canonical := service1 + "." + namespace1 + ".svc.clusterset.local."
+
+	When("an alias is queried", func() {
+		It("should return the A records of the service under the alias name", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname:  aliasName,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{test.A(aliasName + "    5    IN    A    " + serviceIP)},
+			})
+		})
+	})
+
+	When("an alias is queried in CNAME mode", func() {
+		BeforeEach(func() {
+			lh.aliasCNAME = true
+			lh.ttl = 30
+		})
+
+		It("should return a CNAME to the canonical name and its A records", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname: aliasName,
+				Qtype: dns.TypeA,
+				Rcode: dns.RcodeSuccess,
+				Answer: []dns.RR{
+					test.CNAME(aliasName + "    30    IN    CNAME    " + canonical),
+					test.A(canonical + "    30    IN    A    " + serviceIP),
+				},
+			})
+		})
+
+		It("should preserve the cluster label in the canonical name", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname: clusterID + "." + aliasName,
+				Qtype: dns.TypeA,
+				Rcode: dns.RcodeSuccess,
+				Answer: []dns.RR{
+					test.CNAME(clusterID + "." + aliasName + "    30    IN    CNAME    " + clusterID + "." + canonical),
+					test.A(clusterID + "." + canonical + "    30    IN    A    " + serviceIP),
+				},
+			})
+		})
+
+		It("should answer the canonical name without a CNAME", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname:  canonical,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{test.A(canonical + "    30    IN    A    " + serviceIP)},
+			})
+		})
+	})
+}
+
+func testAliasZones() {
+	const aliasZone = "supercluster.local."
+
+	var (
+		rec *dnstest.Recorder
+		lh  *Lighthouse
+	)
+
+	BeforeEach(func() {
+		mockCs := NewMockClusterStatus()
+		mockCs.clusterStatusMap[clusterID] = true
+		mockEs := NewMockEndpointStatus()
+		mockEs.endpointStatusMap[clusterID] = true
+		lh = &Lighthouse{
+			Zones:           []string{"clusterset.local.", aliasZone},
+			serviceImports:  setupServiceImportMap(),
+			endpointSlices:  setupEndpointSliceMap(),
+			clusterStatus:   mockCs,
+			endpointsStatus: mockEs,
+			localServices:   NewMockLocalServices(),
+			ttl:             defaultTtl,
+			aliasZones:      map[string]bool{aliasZone: true},
+		}
+
+		rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	})
+
+	When("queries arrive on the primary and alias zones", func() {
+		It("should answer both identically and count the queries per zone", func() {
+			primaryCount := testutil.ToFloat64(zoneQueries.WithLabelValues("clusterset.local.", "false"))
+			aliasCount := testutil.ToFloat64(zoneQueries.WithLabelValues(aliasZone, "true"))
+
+			for _, zone := range []string{"clusterset.local.", aliasZone} {
+				qname := service1 + "." + namespace1 + ".svc." + zone
+				executeTestCase(lh, rec, test.Case{
+					Qname:  qname,
+					Qtype:  dns.TypeA,
+					Rcode:  dns.RcodeSuccess,
+					Answer: []dns.RR{test.A(qname + "    5    IN    A    " + serviceIP)},
+				})
+			}
+
+			Expect(testutil.ToFloat64(zoneQueries.WithLabelValues("clusterset.local.", "false"))).To(Equal(primaryCount + 1))
+			Expect(testutil.ToFloat64(zoneQueries.WithLabelValues(aliasZone, "true"))).To(Equal(aliasCount + 1))
+		})
+	})
+}
+
+func testDNSStickiness() {
+	const endpointIP3 = "100.96.157.103"
+
+	var (
+		lh     *Lighthouse
+		mockEs *MockEndpointStatus
+	)
+
+	clients := make([]string, 20)
+	for i := range clients {
+		clients[i] = fmt.Sprintf("10.240.0.%d", i+1)
+	}
+
+	qname := service1 + "." + namespace1 + ".svc.clusterset.local."
+
+	query := func(client string) []string {
+		rec := dnstest.NewRecorder(&test.ResponseWriter{RemoteIP: client})
+		code, err := lh.ServeDNS(context.TODO(), rec, (&test.Case{Qname: qname, Qtype: dns.TypeA}).Msg())
+		Expect(err).To(Succeed())
+		Expect(code).To(Equal(dns.RcodeSuccess))
+
+		ips := []string{}
+		for _, rr := range rec.Msg.Answer {
+			ips = append(ips, rr.(*dns.A).A.String())
+		}
+
+		return ips
+	}
+
+	firstAnswers := func() map[string]string {
+		answers := map[string]string{}
+		for _, client := range clients {
+			ips := query(client)
+			Expect(ips).ToNot(BeEmpty())
+			answers[client] = ips[0]
+		}
+
+		return answers
+	}
+
+	newAffinityServiceImport := func(clusterID, serviceIP string, siType mcsv1a1.ServiceImportType) *mcsv1a1.ServiceImport {
+		si := newServiceImport(namespace1, service1, clusterID, serviceIP, siType)
+		si.Spec.SessionAffinity = corev1.ServiceAffinityClientIP
+
+		return si
+	}
+
+	BeforeEach(func() {
+		mockCs := NewMockClusterStatus()
+		mockCs.clusterStatusMap[clusterID] = true
+		mockCs.clusterStatusMap[clusterID2] = true
+		mockEs = NewMockEndpointStatus()
+		mockEs.endpointStatusMap[clusterID] = true
+		mockEs.endpointStatusMap[clusterID2] = true
+		lh = &Lighthouse{
+			Zones:           []string{"clusterset.local."},
+			serviceImports:  serviceimport.NewMap(),
+			endpointSlices:  endpointslice.NewMap(),
+			clusterStatus:   mockCs,
+			endpointsStatus: mockEs,
+			localServices:   NewMockLocalServices(),
+			ttl:             defaultTtl,
+			sticky:          true,
+		}
+	})
+
+	When("a ClusterIP service with ClientIP session affinity is exported by two clusters", func() {
+		BeforeEach(func() {
+			lh.serviceImports.Put(newAffinityServiceImport(clusterID, serviceIP, mcsv1a1.ClusterSetIP))
+			lh.serviceImports.Put(newAffinityServiceImport(clusterID2, serviceIP2, mcsv1a1.ClusterSetIP))
+		})
+
+		It("should consistently return the same IP per client", func() {
+			answers := firstAnswers()
+			for i := 0; i < 5; i++ {
+				Expect(firstAnswers()).To(Equal(answers))
+			}
+
+			ips := map[string]bool{}
+			for _, ip := range answers {
+				ips[ip] = true
+			}
+
+			Expect(ips).To(HaveLen(2))
+		})
+
+		When("a cluster's endpoints subsequently become unhealthy", func() {
+			It("should move its clients to the other cluster", func() {
+				mockEs.endpointStatusMap[clusterID2] = false
+
+				for _, ip := range firstAnswers() {
+					Expect(ip).To(Equal(serviceIP))
+				}
+			})
+		})
+	})
+
+	When("a headless service with ClientIP session affinity has multiple endpoints", func() {
+		BeforeEach(func() {
+			lh.serviceImports.Put(newAffinityServiceImport(clusterID, "", mcsv1a1.Headless))
+			lh.endpointSlices.Put(newEndpointSlice(namespace1, service1, clusterID, []string{endpointIP, endpointIP2, endpointIP3}))
+		})
+
+		It("should consistently return the same order of all IPs per client", func() {
+			for _, client := range clients {
+				ips := query(client)
+				Expect(ips).To(ConsistOf(endpointIP, endpointIP2, endpointIP3))
+
+				for i := 0; i < 5; i++ {
+					Expect(query(client)).To(Equal(ips))
+				}
+			}
+		})
+
+		When("an endpoint subsequently disappears", func() {
+			It("should only rebalance the clients whose first answer disappeared", func() {
+				answers := firstAnswers()
+
+				lh.endpointSlices.Put(newEndpointSlice(namespace1, service1, clusterID, []string{endpointIP, endpointIP2}))
+
+				for client, ip := range firstAnswers() {
+					if answers[client] == endpointIP3 {
+						Expect(ip).ToNot(Equal(endpointIP3))
+					} else {
+						Expect(ip).To(Equal(answers[client]))
+					}
+				}
+			})
+		})
+	})
+
+	When("stickiness is not enabled", func() {
+		BeforeEach(func() {
+			lh.sticky = false
+			lh.serviceImports.Put(newAffinityServiceImport(clusterID, serviceIP, mcsv1a1.ClusterSetIP))
+			lh.serviceImports.Put(newAffinityServiceImport(clusterID2, serviceIP2, mcsv1a1.ClusterSetIP))
+		})
+
+		It("should return the IPs round-robin", func() {
+			Expect(append(query(clients[0]), query(clients[0])...)).To(ConsistOf(serviceIP, serviceIP2))
+		})
+	})
+}
+
+func testMaxClusters() {
+	var (
+		lh     *Lighthouse
+		mockCs *MockClusterStatus
+	)
+
+	qname := service1 + "." + namespace1 + ".svc.clusterset.local."
+
+	clusterIP := func(i int) string {
+		return fmt.Sprintf("100.96.158.%d", i)
+	}
+
+	query := func(qname string) []string {
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		code, err := lh.ServeDNS(context.TODO(), rec, (&test.Case{Qname: qname, Qtype: dns.TypeA}).Msg())
+		Expect(err).To(Succeed())
+		Expect(code).To(Equal(dns.RcodeSuccess))
+
+		ips := []string{}
+		for _, rr := range rec.Msg.Answer {
+			ips = append(ips, rr.(*dns.A).A.String())
+		}
+
+		return ips
+	}
+
+	BeforeEach(func() {
+		mockCs = NewMockClusterStatus()
+		lh = &Lighthouse{
+			Zones:           []string{"clusterset.local."},
+			serviceImports:  serviceimport.NewMap(),
+			endpointSlices:  endpointslice.NewMap(),
+			clusterStatus:   mockCs,
+			endpointsStatus: NewMockEndpointStatus(),
+			localServices:   NewMockLocalServices(),
+			ttl:             defaultTtl,
+			maxClusters:     2,
+		}
+
+		for i := 1; i <= 5; i++ {
+			id := fmt.Sprintf("cluster%d", i)
+			mockCs.clusterStatusMap[id] = true
+			lh.serviceImports.Put(newServiceImport(namespace1, service1, id, "", mcsv1a1.Headless))
+			lh.endpointSlices.Put(newEndpointSlice(namespace1, service1, id, []string{clusterIP(i)}))
+		}
+	})
+
+	When("a headless service is exported from five clusters", func() {
+		It("should return the endpoints of two clusters per answer, rotating over all of them", func() {
+			returned := map[string]bool{}
+
+			for i := 0; i < 5; i++ {
+				ips := query(qname)
+				Expect(ips).To(HaveLen(2))
+
+				for _, ip := range ips {
+					returned[ip] = true
+				}
+			}
+
+			Expect(returned).To(HaveLen(5))
+		})
+
+		When("the local cluster is one of them", func() {
+			BeforeEach(func() {
+				mockCs.localClusterID = "cluster4"
+			})
+
+			It("should always include the local cluster's endpoints", func() {
+				for i := 0; i < 5; i++ {
+					ips := query(qname)
+					Expect(ips).To(HaveLen(2))
+					Expect(ips).To(ContainElement(clusterIP(4)))
+				}
+			})
+		})
+
+		When("a specific cluster is queried", func() {
+			It("should return its endpoints", func() {
+				Expect(query("cluster5." + qname)).To(Equal([]string{clusterIP(5)}))
+			})
+		})
+	})
+}
+
+func testFallback() {
+	const fallbackIP = "192.0.2.10"
+
+	var (
+		rec    *dnstest.Recorder
+		lh     *Lighthouse
+		mockCs *MockClusterStatus
+	)
+
+	qname := service1 + "." + namespace1 + ".svc.clusterset.local."
+
+	BeforeEach(func() {
+		mockCs = NewMockClusterStatus()
+		mockCs.clusterStatusMap[clusterID] = false
+		mockEs := NewMockEndpointStatus()
+		mockEs.endpointStatusMap[clusterID] = true
+		lh = &Lighthouse{
+			Zones:            []string{"clusterset.local."},
+			serviceImports:   setupServiceImportMap(),
+			endpointSlices:   setupEndpointSliceMap(),
+			clusterStatus:    mockCs,
+			endpointsStatus:  mockEs,
+			localServices:    NewMockLocalServices(),
+			ttl:              defaultTtl,
+			fallback:         fallbackIP,
+			serviceFallbacks: map[string]string{},
+		}
+
+		rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	})
+
+	When("all the clusters exporting the service are disconnected", func() {
+		It("should return the fallback IP", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname:  qname,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{test.A(qname + "    5    IN    A    " + fallbackIP)},
+			})
+		})
+
+		It("should return an empty response for a type AAAA query", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname:  qname,
+				Qtype:  dns.TypeAAAA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{},
+			})
+		})
+
+		When("a fallback hostname is configured for the service", func() {
+			BeforeEach(func() {
+				lh.serviceFallbacks[namespace1+"/"+service1] = "unavailable.example.com"
+			})
+
+			It("should return a CNAME to the fallback hostname", func() {
+				executeTestCase(lh, rec, test.Case{
+					Qname:  qname,
+					Qtype:  dns.TypeA,
+					Rcode:  dns.RcodeSuccess,
+					Answer: []dns.RR{test.CNAME(qname + "    5    IN    CNAME    unavailable.example.com.")},
+				})
+			})
+		})
+
+		When("no fallback is configured", func() {
+			BeforeEach(func() {
+				lh.fallback = ""
+			})
+
+			It("should return an empty response", func() {
+				executeTestCase(lh, rec, test.Case{
+					Qname:  qname,
+					Qtype:  dns.TypeA,
+					Rcode:  dns.RcodeSuccess,
+					Answer: []dns.RR{},
+				})
+			})
+		})
+	})
+
+	When("a cluster exporting the service is connected", func() {
+		BeforeEach(func() {
+			mockCs.clusterStatusMap[clusterID] = true
+		})
+
+		It("should return the service IP", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname:  qname,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{test.A(qname + "    5    IN    A    " + serviceIP)},
+			})
+		})
+	})
+
+	When("a connected cluster has no healthy endpoints", func() {
+		It("should return an empty response", func() {
+			mockCs.clusterStatusMap[clusterID] = true
+			lh.endpointsStatus.(*MockEndpointStatus).endpointStatusMap[clusterID] = false
+
+			executeTestCase(lh, rec, test.Case{
+				Qname:  qname,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{},
+			})
+		})
+	})
+
+	When("the service doesn't exist", func() {
+		It("should return NXDOMAIN", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname: "unknown." + namespace1 + ".svc.clusterset.local.",
+				Qtype: dns.TypeA,
+				Rcode: dns.RcodeNameError,
+			})
+		})
+	})
+}
+
+func testClusterFirstAnswers() {
+	var lh *Lighthouse
+
+	svc := namespace1 + "/" + service1
+	qname := service1 + "." + namespace1 + ".svc.clusterset.local."
+
+	query := func(qname string) {
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		code, err := lh.ServeDNS(context.TODO(), rec, (&test.Case{Qname: qname, Qtype: dns.TypeA}).Msg())
+		Expect(err).To(Succeed())
+		Expect(code).To(Equal(dns.RcodeSuccess))
+	}
+
+	firstAnswers := func(clusterID string) float64 {
+		return testutil.ToFloat64(clusterFirstAnswers.WithLabelValues(svc, clusterID))
+	}
+
+	BeforeEach(func() {
+		mockCs := NewMockClusterStatus()
+		mockCs.clusterStatusMap[clusterID] = true
+		mockCs.clusterStatusMap[clusterID2] = true
+		mockEs := NewMockEndpointStatus()
+		mockEs.endpointStatusMap[clusterID] = true
+		mockEs.endpointStatusMap[clusterID2] = true
+		lh = &Lighthouse{
+			Zones:           []string{"clusterset.local."},
+			serviceImports:  serviceimport.NewMap(),
+			endpointSlices:  endpointslice.NewMap(),
+			clusterStatus:   mockCs,
+			endpointsStatus: mockEs,
+			localServices:   NewMockLocalServices(),
+			ttl:             defaultTtl,
+		}
+	})
+
+	When("a ClusterIP service is in two clusters", func() {
+		BeforeEach(func() {
+			lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID, serviceIP, mcsv1a1.ClusterSetIP))
+			lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID2, serviceIP2, mcsv1a1.ClusterSetIP))
+		})
+
+		It("should count each cluster returned first", func() {
+			count1 := firstAnswers(clusterID)
+			count2 := firstAnswers(clusterID2)
+
+			for i := 0; i < 4; i++ {
+				query(qname)
+			}
+
+			Expect(firstAnswers(clusterID)).To(Equal(count1 + 2))
+			Expect(firstAnswers(clusterID2)).To(Equal(count2 + 2))
+		})
+
+		It("should not count queries for a specific cluster", func() {
+			count := firstAnswers(clusterID2)
+
+			query(clusterID2 + "." + qname)
+
+			Expect(firstAnswers(clusterID2)).To(Equal(count))
+		})
+	})
+
+	When("a headless service is in a cluster", func() {
+		BeforeEach(func() {
+			lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID2, "", mcsv1a1.Headless))
+			lh.endpointSlices.Put(newEndpointSlice(namespace1, service1, clusterID2, []string{endpointIP}))
+		})
+
+		It("should count the cluster of the first endpoint", func() {
+			count := firstAnswers(clusterID2)
+
+			query(qname)
+
+			Expect(firstAnswers(clusterID2)).To(Equal(count + 1))
+		})
+	})
+}
+
+func testShortNames() {
+	var (
+		rec *dnstest.Recorder
+		lh  *Lighthouse
+	)
+
+	shortName := service1 + "." + namespace1 + "."
+
+	BeforeEach(func() {
+		mockCs := NewMockClusterStatus()
+		mockCs.clusterStatusMap[clusterID] = true
+		mockEs := NewMockEndpointStatus()
+		mockEs.endpointStatusMap[clusterID] = true
+
+		lh = &Lighthouse{
+			Zones:           []string{"clusterset.local."},
+			Next:            test.NextHandler(dns.RcodeBadCookie, errors.New("dummy plugin")),
+			serviceImports:  setupServiceImportMap(),
+			endpointSlices:  setupEndpointSliceMap(),
+			clusterStatus:   mockCs,
+			endpointsStatus: mockEs,
+			localServices:   NewMockLocalServices(),
+			ttl:             defaultTtl,
+			searchDomain:    "svc.clusterset.local.",
+		}
+
+		rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	})
+
+	When("type A DNS query for the short name of an exported service", func() {
+		It("should succeed and write an A record response for the short name", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname:  shortName,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{test.A(shortName + "    5    IN    A    " + serviceIP)},
+			})
+		})
+	})
+
+	When("type AAAA DNS query for the short name of an exported service", func() {
+		It("should return empty record", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname:  shortName,
+				Qtype:  dns.TypeAAAA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{},
+			})
+		})
+	})
+
+	When("type A DNS query for the full name of an exported service", func() {
+		It("should succeed and write an A record response", func() {
+			qname := service1 + "." + namespace1 + ".svc.clusterset.local."
+			executeTestCase(lh, rec, test.Case{
+				Qname:  qname,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{test.A(qname + "    5    IN    A    " + serviceIP)},
+			})
+		})
+	})
+
+	When("type A DNS query for a short name that isn't an exported service", func() {
+		It("should invoke the next plugin even without fallthrough", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname: "example.com.",
+				Qtype: dns.TypeA,
+				Rcode: dns.RcodeBadCookie,
+			})
+		})
+	})
+
+	When("type A DNS query for a name with more labels that doesn't match a zone", func() {
+		It("should not expand the name", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname: "cluster1." + shortName,
+				Qtype: dns.TypeA,
+				Rcode: dns.RcodeNotZone,
+			})
+		})
+	})
+
+	When("the search domain isn't configured", func() {
+		It("should not answer the short name", func() {
+			lh.searchDomain = ""
+			executeTestCase(lh, rec, test.Case{
+				Qname: shortName,
+				Qtype: dns.TypeA,
+				Rcode: dns.RcodeNotZone,
+			})
+		})
+	})
+}
+
+func testTracing() {
+	var (
+		rec      *dnstest.Recorder
+		lh       *Lighthouse
+		exporter *tracetest.InMemoryExporter
+	)
+
+	qname := service1 + "." + namespace1 + ".svc.clusterset.local."
+
+	spanAttribute := func(span *sdktrace.SpanSnapshot, key attribute.Key) attribute.Value {
+		for _, kv := range span.Attributes {
+			if kv.Key == key {
+				return kv.Value
+			}
+		}
+
+		return attribute.Value{}
+	}
+
+	BeforeEach(func() {
+		mockCs := NewMockClusterStatus()
+		mockCs.clusterStatusMap[clusterID] = true
+		mockEs := NewMockEndpointStatus()
+		mockEs.endpointStatusMap[clusterID] = true
+
+		exporter = tracetest.NewInMemoryExporter()
+
+		lh = &Lighthouse{
+			Zones:           []string{"clusterset.local."},
+			serviceImports:  setupServiceImportMap(),
+			endpointSlices:  setupEndpointSliceMap(),
+			clusterStatus:   mockCs,
+			endpointsStatus: mockEs,
+			localServices:   NewMockLocalServices(),
+			ttl:             defaultTtl,
+			tracer:          sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)).Tracer(tracerName),
+		}
+
+		rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	})
+
+	When("type A DNS query for an existing service", func() {
+		It("should record a span with the query and the answering cluster", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname:  qname,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{test.A(qname + "    5    IN    A    " + serviceIP)},
+			})
+
+			spans := exporter.GetSpans()
+			Expect(spans).To(HaveLen(1))
+			Expect(spans[0].Name).To(Equal("lighthouse.ServeDNS"))
+			Expect(spanAttribute(spans[0], "dns.qname").AsString()).To(Equal(qname))
+			Expect(spanAttribute(spans[0], "dns.qtype").AsString()).To(Equal("A"))
+			Expect(spanAttribute(spans[0], "dns.rcode").AsString()).To(Equal("NOERROR"))
+			Expect(spanAttribute(spans[0], "lighthouse.clusters").AsArray()).To(ConsistOf(clusterID))
+			Expect(spans[0].MessageEvents).To(BeEmpty())
+		})
+	})
+
+	When("type A DNS query for a service whose cluster is disconnected", func() {
+		It("should record a span with the filtered cluster", func() {
+			lh.clusterStatus.(*MockClusterStatus).clusterStatusMap[clusterID] = false
+			executeTestCase(lh, rec, test.Case{
+				Qname:  qname,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{},
+			})
+
+			spans := exporter.GetSpans()
+			Expect(spans).To(HaveLen(1))
+			Expect(spanAttribute(spans[0], "lighthouse.clusters").AsArray()).To(BeEmpty())
+
+			Expect(spans[0].MessageEvents).ToNot(BeEmpty())
+			event := spans[0].MessageEvents[0]
+			Expect(event.Name).To(Equal("cluster filtered"))
+			Expect(event.Attributes).To(ContainElement(attribute.String("lighthouse.cluster_id", clusterID)))
+			Expect(event.Attributes).To(ContainElement(attribute.String("lighthouse.reason", clusterDisconnected)))
+		})
+	})
+
+	When("type A DNS query for a non-existent service", func() {
+		It("should record a span with the NXDOMAIN response code and no clusters", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname: "unknown." + namespace1 + ".svc.clusterset.local.",
+				Qtype: dns.TypeA,
+				Rcode: dns.RcodeNameError,
+			})
+
+			spans := exporter.GetSpans()
+			Expect(spans).To(HaveLen(1))
+			Expect(spanAttribute(spans[0], "dns.rcode").AsString()).To(Equal("NXDOMAIN"))
+			Expect(spanAttribute(spans[0], "lighthouse.clusters").AsArray()).To(BeEmpty())
+		})
+	})
+
+	When("tracing is disabled", func() {
+		It("should not record a span", func() {
+			lh.tracer = nil
+			executeTestCase(lh, rec, test.Case{
+				Qname:  qname,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{test.A(qname + "    5    IN    A    " + serviceIP)},
+			})
+
+			Expect(exporter.GetSpans()).To(BeEmpty())
+		})
+	})
+}
+
+func testClustersTXT() {
+	var (
+		rec    *dnstest.Recorder
+		lh     *Lighthouse
+		mockCs *MockClusterStatus
+	)
+
+	qname := clustersLabel + "." + service1 + "." + namespace1 + ".svc.clusterset.local."
+
+	BeforeEach(func() {
+		mockCs = NewMockClusterStatus()
+		mockCs.clusterStatusMap[clusterID] = true
+		mockCs.clusterStatusMap[clusterID2] = false
+
+		lh = &Lighthouse{
+			Zones:           []string{"clusterset.local."},
+			serviceImports:  setupServiceImportMap(),
+			endpointSlices:  setupEndpointSliceMap(),
+			clusterStatus:   mockCs,
+			endpointsStatus: NewMockEndpointStatus(),
+			localServices:   NewMockLocalServices(),
+			ttl:             defaultTtl,
+			clustersTXT:     true,
+		}
+
+		lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID2, serviceIP2, mcsv1a1.ClusterSetIP))
+		lh.endpointSlices.Put(newEndpointSlice(namespace1, service1, clusterID2, []string{endpointIP, endpointIP2}))
+
+		rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	})
+
+	When("type TXT DNS query for the clusters of an exported service", func() {
+		It("should succeed and write a TXT record for each cluster", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname: qname,
+				Qtype: dns.TypeTXT,
+				Rcode: dns.RcodeSuccess,
+				Answer: []dns.RR{
+					test.TXT(qname + `    5    IN    TXT    "cluster=cluster1 connected=true endpoints=1"`),
+					test.TXT(qname + `    5    IN    TXT    "cluster=cluster2 connected=false endpoints=2"`),
+				},
+			})
+		})
+	})
+
+	When("the connectivity of a cluster changes", func() {
+		It("should be reflected in its TXT record", func() {
+			mockCs.clusterStatusMap[clusterID] = false
+			mockCs.clusterStatusMap[clusterID2] = true
+
+			executeTestCase(lh, rec, test.Case{
+				Qname: qname,
+				Qtype: dns.TypeTXT,
+				Rcode: dns.RcodeSuccess,
+				Answer: []dns.RR{
+					test.TXT(qname + `    5    IN    TXT    "cluster=cluster1 connected=false endpoints=1"`),
+					test.TXT(qname + `    5    IN    TXT    "cluster=cluster2 connected=true endpoints=2"`),
+				},
+			})
+		})
+	})
+
+	When("type TXT DNS query for the clusters of a non-existent service", func() {
+		It("should return RcodeNameError", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname: clustersLabel + ".unknown." + namespace1 + ".svc.clusterset.local.",
+				Qtype: dns.TypeTXT,
+				Rcode: dns.RcodeNameError,
+			})
+		})
+	})
+
+	When("type TXT DNS query for an exported service", func() {
+		It("should return RcodeNotImplemented", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname: service1 + "." + namespace1 + ".svc.clusterset.local.",
+				Qtype: dns.TypeTXT,
+				Rcode: dns.RcodeNotImplemented,
+			})
+		})
+	})
+
+	When("TXT records aren't enabled", func() {
+		It("should return RcodeNotImplemented", func() {
+			lh.clustersTXT = false
+			executeTestCase(lh, rec, test.Case{
+				Qname: qname,
+				Qtype: dns.TypeTXT,
+				Rcode: dns.RcodeNotImplemented,
+			})
+		})
+	})
+}
+
+func testStaleEndpoints() {
+	var (
+		rec *dnstest.Recorder
+		lh  *Lighthouse
+	)
+
+	qname := service1 + "." + namespace1 + ".svc.clusterset.local."
+
+	BeforeEach(func() {
+		mockCs := NewMockClusterStatus()
+		mockCs.clusterStatusMap[clusterID] = true
+		mockCs.clusterStatusMap[clusterID2] = true
+		mockEs := NewMockEndpointStatus()
+		mockEs.endpointStatusMap[clusterID] = true
+		mockEs.endpointStatusMap[clusterID2] = true
+
+		lh = &Lighthouse{
+			Zones:           []string{"clusterset.local."},
+			serviceImports:  serviceimport.NewMap(),
+			endpointSlices:  endpointslice.NewMap(),
+			clusterStatus:   mockCs,
+			endpointsStatus: mockEs,
+			localServices:   NewMockLocalServices(),
+			ttl:             defaultTtl,
+			excludeStale:    true,
+		}
+
+		rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	})
+
+	newRefreshedEndpointSlice := func(clusterID, endpointIP string) *discovery.EndpointSlice {
+		es := newEndpointSlice(namespace1, service1, clusterID, []string{endpointIP})
+		es.Annotations = map[string]string{lhconstants.AnnotationRefreshed: time.Now().UTC().Format(time.RFC3339Nano)}
+
+		return es
+	}
+
+	// The EndpointSlice of the second cluster stops being refreshed while the first one is refreshed.
+	makeSecondClusterStale := func() {
+		lh.endpointSlices.Put(newRefreshedEndpointSlice(clusterID2, endpointIP2))
+		lh.endpointSlices.SetMaxAge(200 * time.Millisecond)
+		time.Sleep(300 * time.Millisecond)
+		lh.endpointSlices.Put(newRefreshedEndpointSlice(clusterID, endpointIP))
+	}
+
+	When("a headless service has a stale EndpointSlice in a cluster", func() {
+		BeforeEach(func() {
+			lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID, "", mcsv1a1.Headless))
+			lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID2, "", mcsv1a1.Headless))
+			makeSecondClusterStale()
+		})
+
+		It("should exclude the stale endpoints", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname:  qname,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{test.A(qname + "    5    IN    A    " + endpointIP)},
+			})
+		})
+
+		It("should return the stale endpoints if they aren't excluded", func() {
+			lh.excludeStale = false
+			executeTestCase(lh, rec, test.Case{
+				Qname: qname,
+				Qtype: dns.TypeA,
+				Rcode: dns.RcodeSuccess,
+				Answer: []dns.RR{
+					test.A(qname + "    5    IN    A    " + endpointIP),
+					test.A(qname + "    5    IN    A    " + endpointIP2),
+				},
+			})
+		})
+	})
+
+	When("a ClusterIP service has a stale EndpointSlice in a cluster", func() {
+		BeforeEach(func() {
+			lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID, serviceIP, mcsv1a1.ClusterSetIP))
+			lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID2, serviceIP2, mcsv1a1.ClusterSetIP))
+			makeSecondClusterStale()
+		})
+
+		It("should only return the cluster with fresh endpoints", func() {
+			for i := 0; i < 2; i++ {
+				executeTestCase(lh, rec, test.Case{
+					Qname:  qname,
+					Qtype:  dns.TypeA,
+					Rcode:  dns.RcodeSuccess,
+					Answer: []dns.RR{test.A(qname + "    5    IN    A    " + serviceIP)},
+				})
+			}
+		})
+	})
+}
+
+func testRegionAffinity() {
+	const (
+		clusterID3  = "cluster3"
+		serviceIP3  = "100.96.156.103"
+		endpointIP3 = "100.96.157.103"
+	)
+
+	var (
+		rec    *dnstest.Recorder
+		lh     *Lighthouse
+		mockCs *MockClusterStatus
+	)
+
+	qname := service1 + "." + namespace1 + ".svc.clusterset.local."
+
+	BeforeEach(func() {
+		mockCs = NewMockClusterStatus()
+		mockCs.localClusterID = clusterID
+		mockCs.clusterStatusMap[clusterID] = true
+		mockCs.clusterStatusMap[clusterID2] = true
+		mockCs.clusterStatusMap[clusterID3] = true
+		mockEs := NewMockEndpointStatus()
+		mockEs.endpointStatusMap[clusterID2] = true
+		mockEs.endpointStatusMap[clusterID3] = true
+
+		lh = &Lighthouse{
+			Zones:           []string{"clusterset.local."},
+			serviceImports:  serviceimport.NewMap(),
+			endpointSlices:  endpointslice.NewMap(),
+			clusterStatus:   mockCs,
+			endpointsStatus: mockEs,
+			localServices:   NewMockLocalServices(),
+			ttl:             defaultTtl,
+			clusterRegions:  map[string]string{clusterID: "us-east", clusterID2: "us-east", clusterID3: "eu-west"},
+			regionAffinity:  true,
+		}
+
+		rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	})
+
+	expectAnswers := func(qname string, ips ...string) {
+		answers := []dns.RR{}
+		for _, ip := range ips {
+			answers = append(answers, test.A(qname+"    5    IN    A    "+ip))
+		}
+
+		// Query repeatedly so round-robin would have returned every cluster.
+		for i := 0; i < 3; i++ {
+			executeTestCase(lh, rec, test.Case{
+				Qname:  qname,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: answers,
+			})
+		}
+	}
+
+	When("a ClusterIP service is exported by clusters in the local and another region", func() {
+		BeforeEach(func() {
+			lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID2, serviceIP2, mcsv1a1.ClusterSetIP))
+			lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID3, serviceIP3, mcsv1a1.ClusterSetIP))
+		})
+
+		It("should only return the cluster in the local region", func() {
+			expectAnswers(qname, serviceIP2)
+		})
+
+		It("should fall back to the other region if the local region's cluster isn't connected", func() {
+			mockCs.clusterStatusMap[clusterID2] = false
+			expectAnswers(qname, serviceIP3)
+		})
+
+		It("should return a specific cluster in another region", func() {
+			expectAnswers(clusterID3+"."+qname, serviceIP3)
+		})
+
+		It("should return all the regions if region affinity isn't enabled", func() {
+			lh.regionAffinity = false
+
+			ips := map[string]bool{}
+
+			for i := 0; i < 2; i++ {
+				rec = dnstest.NewRecorder(&test.ResponseWriter{})
+				_, err := lh.ServeDNS(context.TODO(), rec, (&test.Case{Qname: qname, Qtype: dns.TypeA}).Msg())
+				Expect(err).To(Succeed())
+				Expect(rec.Msg.Answer).To(HaveLen(1))
+				ips[rec.Msg.Answer[0].(*dns.A).A.String()] = true
+			}
+
+			Expect(ips).To(Equal(map[string]bool{serviceIP2: true, serviceIP3: true}))
+		})
+	})
+
+	When("a headless service is exported by clusters in the local and another region", func() {
+		BeforeEach(func() {
+			lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID2, "", mcsv1a1.Headless))
+			lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID3, "", mcsv1a1.Headless))
+			lh.endpointSlices.Put(newEndpointSlice(namespace1, service1, clusterID2, []string{endpointIP2}))
+			lh.endpointSlices.Put(newEndpointSlice(namespace1, service1, clusterID3, []string{endpointIP3}))
+		})
+
+		It("should only return the endpoints in the local region", func() {
+			expectAnswers(qname, endpointIP2)
+		})
+
+		It("should fall back to the other region if the local region's cluster isn't connected", func() {
+			mockCs.clusterStatusMap[clusterID2] = false
+			expectAnswers(qname, endpointIP3)
+		})
+	})
+}
+
+func testAnswerOrdering() {
+	var (
+		lh      *Lighthouse
+		queries []QueryContext
+	)
+
+	qname := service1 + "." + namespace1 + ".svc.clusterset.local."
+
+	query := func() []string {
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		code, err := lh.ServeDNS(context.TODO(), rec, (&test.Case{Qname: qname, Qtype: dns.TypeA}).Msg())
+		Expect(err).To(Succeed())
+		Expect(code).To(Equal(dns.RcodeSuccess))
+
+		ips := []string{}
+		for _, rr := range rec.Msg.Answer {
+			ips = append(ips, rr.(*dns.A).A.String())
+		}
+
+		return ips
+	}
+
+	BeforeEach(func() {
+		queries = nil
+		mockCs := NewMockClusterStatus()
+		mockCs.localClusterID = clusterID2
+		mockCs.clusterStatusMap[clusterID] = true
+		mockCs.clusterStatusMap[clusterID2] = true
+
+		lh = &Lighthouse{
+			Zones:           []string{"clusterset.local."},
+			serviceImports:  serviceimport.NewMap(),
+			endpointSlices:  endpointslice.NewMap(),
+			clusterStatus:   mockCs,
+			endpointsStatus: NewMockEndpointStatus(),
+			localServices:   NewMockLocalServices(),
+			ttl:             defaultTtl,
+		}
+
+		lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID, "", mcsv1a1.Headless))
+		lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID2, "", mcsv1a1.Headless))
+		lh.endpointSlices.Put(newEndpointSlice(namespace1, service1, clusterID, []string{endpointIP}))
+		lh.endpointSlices.Put(newEndpointSlice(namespace1, service1, clusterID2, []string{endpointIP2}))
+	})
+
+	When("the local-first orderer is configured", func() {
+		BeforeEach(func() {
+			lh.answerOrderer = AnswerOrdererFunc(localFirstOrder)
+		})
+
+		It("should always return the local cluster's endpoints first", func() {
+			for i := 0; i < 5; i++ {
+				Expect(query()).To(Equal([]string{endpointIP2, endpointIP}))
+			}
+		})
+	})
+
+	When("the stable orderer is configured", func() {
+		BeforeEach(func() {
+			lh.answerOrderer = AnswerOrdererFunc(stableOrder)
+		})
+
+		It("should return the same order for repeated queries until the endpoints change", func() {
+			ordered := query()
+			Expect(ordered).To(ConsistOf(endpointIP, endpointIP2))
+
+			for i := 0; i < 5; i++ {
+				Expect(query()).To(Equal(ordered))
+			}
+
+			const endpointIP3 = "100.96.157.103"
+
+			lh.endpointSlices.Put(newEndpointSlice(namespace1, service1, clusterID, []string{endpointIP, endpointIP3}))
+
+			changed := query()
+			Expect(changed).To(ConsistOf(endpointIP, endpointIP2, endpointIP3))
+			Expect(changed).To(ContainElements(ordered))
+
+			remaining := []string{}
+			for _, ip := range changed {
+				if ip != endpointIP3 {
+					remaining = append(remaining, ip)
+				}
+			}
+
+			Expect(remaining).To(Equal(ordered))
+
+			for i := 0; i < 5; i++ {
+				Expect(query()).To(Equal(changed))
+			}
+		})
+
+		It("should truncate the answers to the same first addresses with max-answers", func() {
+			ordered := query()
+
+			lh.maxAnswers = 1
+
+			for i := 0; i < 5; i++ {
+				Expect(query()).To(Equal(ordered[:1]))
+			}
+		})
+	})
+
+	When("a headless cluster order is configured", func() {
+		const (
+			endpointIP3 = "100.96.157.103"
+			endpointIP4 = "100.96.157.104"
+		)
+
+		BeforeEach(func() {
+			lh.answerOrderer = AnswerOrdererFunc(stableOrder)
+			lh.headlessClusterRank = localFirstClusters
+			lh.endpointSlices.Put(newEndpointSlice(namespace1, service1, clusterID, []string{endpointIP, endpointIP3}))
+			lh.endpointSlices.Put(newEndpointSlice(namespace1, service1, clusterID2, []string{endpointIP2, endpointIP4}))
+		})
+
+		It("should group the headless answers by cluster, the local cluster first, in the configured order", func() {
+			first := query()
+			Expect(first).To(HaveLen(4))
+			Expect(first[:2]).To(ConsistOf(endpointIP2, endpointIP4))
+			Expect(first[2:]).To(ConsistOf(endpointIP, endpointIP3))
+
+			for i := 0; i < 10; i++ {
+				Expect(query()).To(Equal(first))
+			}
+		})
+	})
+
+	When("a custom orderer is configured", func() {
+		BeforeEach(func() {
+			lh.answerOrderer = AnswerOrdererFunc(func(endpoints []Endpoint, query QueryContext) []Endpoint {
+				queries = append(queries, query)
+
+				sort.Slice(endpoints, func(i, j int) bool {
+					return endpoints[i].Cluster < endpoints[j].Cluster
+				})
+
+				return endpoints
+			})
+		})
+
+		It("should return the endpoints in its order and pass it the query and the endpoints' clusters", func() {
+			Expect(query()).To(Equal([]string{endpointIP, endpointIP2}))
+			Expect(queries).To(HaveLen(1))
+			Expect(queries[0].Namespace).To(Equal(namespace1))
+			Expect(queries[0].Service).To(Equal(service1))
+			Expect(queries[0].LocalCluster).To(Equal(clusterID2))
+			Expect(queries[0].Headless).To(BeTrue())
+			Expect(queries[0].Client).ToNot(BeEmpty())
+		})
+	})
+}
+
+func testStandby() {
+	var (
+		rec *dnstest.Recorder
+		lh  *Lighthouse
+	)
+
+	qname := service1 + "." + namespace1 + ".svc.clusterset.local."
+
+	BeforeEach(func() {
+		mockCs := NewMockClusterStatus()
+		mockCs.clusterStatusMap[clusterID] = true
+		mockEs := NewMockEndpointStatus()
+		mockEs.endpointStatusMap[clusterID] = true
+
+		lh = &Lighthouse{
+			Zones:           []string{"clusterset.local."},
+			serviceImports:  setupServiceImportMap(),
+			endpointSlices:  setupEndpointSliceMap(),
+			clusterStatus:   mockCs,
+			endpointsStatus: mockEs,
+			localServices:   NewMockLocalServices(),
+			ttl:             defaultTtl,
+			standby:         1,
+		}
+
+		rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	})
+
+	AfterEach(func() {
+		atomic.StoreUint32(&promoted, 0)
+	})
+
+	promote := func(method string) int {
+		w := httptest.NewRecorder()
+		lh.promoteHandler().ServeHTTP(w, httptest.NewRequest(method, "/promote", nil))
+
+		return w.Code
+	}
+
+	When("in standby mode", func() {
+		It("should refuse queries", func() {
+			code, err := lh.ServeDNS(context.TODO(), rec, (&test.Case{Qname: qname, Qtype: dns.TypeA}).Msg())
+			Expect(err).To(Succeed())
+			Expect(code).To(Equal(dns.RcodeRefused))
+			Expect(rec.Msg).To(BeNil())
+		})
+
+		It("should not be promoted by a GET", func() {
+			Expect(promote(http.MethodGet)).To(Equal(http.StatusMethodNotAllowed))
+			Expect(lh.isStandby()).To(BeTrue())
+		})
+	})
+
+	When("promoted", func() {
+		It("should answer queries immediately", func() {
+			Expect(promote(http.MethodPost)).To(Equal(http.StatusOK))
+
+			executeTestCase(lh, rec, test.Case{
+				Qname: qname,
+				Qtype: dns.TypeA,
+				Rcode: dns.RcodeSuccess,
+				Answer: []dns.RR{
+					test.A(qname + "    5    IN    A    " + serviceIP),
+				},
+			})
+
+			Expect(promote(http.MethodPost)).To(Equal(http.StatusOK))
+			Expect(lh.isStandby()).To(BeFalse())
+		})
+	})
+
+	When("other admin endpoints are configured with the same address", func() {
+		BeforeEach(func() {
+			lh.promoteAddress = defaultAdminAddress
+			lh.debugSnapshotAddress = defaultAdminAddress
+			lh.freezeAddress = "127.0.0.1:8190"
+		})
+
+		It("should serve them all on that address", func() {
+			handlers := lh.adminHandlers()
+			Expect(handlers).To(HaveLen(2))
+			Expect(handlers).To(HaveKey(defaultAdminAddress))
+
+			w := httptest.NewRecorder()
+			handlers[defaultAdminAddress].ServeHTTP(w, httptest.NewRequest(http.MethodGet, debugSnapshotPath, nil))
+			Expect(w.Code).To(Equal(http.StatusOK))
+
+			w = httptest.NewRecorder()
+			handlers[defaultAdminAddress].ServeHTTP(w, httptest.NewRequest(http.MethodPost, promotePath, nil))
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(lh.isStandby()).To(BeFalse())
+
+			w = httptest.NewRecorder()
+			handlers[defaultAdminAddress].ServeHTTP(w, httptest.NewRequest(http.MethodPost, freezePath, nil))
+			Expect(w.Code).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	When("the admin servers are restarted", func() {
+		BeforeEach(func() {
+			lh.promoteAddress = "127.0.0.1:8191"
+		})
+
+		It("should listen on the same address again", func() {
+			Expect(lh.startAdminServers()).To(Succeed())
+			Expect(lh.stopAdminServers()).To(Succeed())
+			Expect(lh.startAdminServers()).To(Succeed())
+			Expect(lh.stopAdminServers()).To(Succeed())
+		})
+	})
+
+	When("an address of the admin servers is in use", func() {
+		var inUse net.Listener
+
+		BeforeEach(func() {
+			var err error
+			inUse, err = net.Listen("tcp", "127.0.0.1:8193")
+			Expect(err).To(Succeed())
+
+			lh.promoteAddress = "127.0.0.1:8192"
+			lh.debugSnapshotAddress = "127.0.0.1:8193"
+		})
+
+		AfterEach(func() {
+			Expect(inUse.Close()).To(Succeed())
+		})
+
+		It("should fail and close the addresses already listened on", func() {
+			Expect(lh.startAdminServers()).ToNot(Succeed())
+			Expect(lh.adminServers).To(BeEmpty())
+
+			listener, err := net.Listen("tcp", "127.0.0.1:8192")
+			Expect(err).To(Succeed())
+			Expect(listener.Close()).To(Succeed())
+		})
+	})
+}
+
+func testAggregatedHeadless() {
+	var (
+		lh     *Lighthouse
+		mockCs *MockClusterStatus
+	)
+
+	qname := service1 + "." + namespace1 + ".svc.clusterset.local."
+
+	query := func(qname string, tcp bool) *dns.Msg {
+		rec := dnstest.NewRecorder(&test.ResponseWriter{TCP: tcp})
+		code, err := lh.ServeDNS(context.TODO(), rec, (&test.Case{Qname: qname, Qtype: dns.TypeA}).Msg())
+		Expect(err).To(Succeed())
+		Expect(code).To(Equal(dns.RcodeSuccess))
+
+		return rec.Msg
+	}
+
+	answerIPs := func(msg *dns.Msg) []string {
+		ips := []string{}
+		for _, rr := range msg.Answer {
+			ips = append(ips, rr.(*dns.A).A.String())
+		}
+
+		return ips
+	}
+
+	BeforeEach(func() {
+		mockCs = NewMockClusterStatus()
+		mockCs.clusterStatusMap[clusterID] = true
+		mockCs.clusterStatusMap[clusterID2] = true
+
+		lh = &Lighthouse{
+			Zones:           []string{"clusterset.local."},
+			serviceImports:  serviceimport.NewMap(),
+			endpointSlices:  endpointslice.NewMap(),
+			clusterStatus:   mockCs,
+			endpointsStatus: NewMockEndpointStatus(),
+			localServices:   NewMockLocalServices(),
+			ttl:             defaultTtl,
+		}
+
+		lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID, "", mcsv1a1.Headless))
+		lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID2, "", mcsv1a1.Headless))
+		lh.endpointSlices.Put(newEndpointSlice(namespace1, service1, clusterID, []string{endpointIP}))
+		lh.endpointSlices.Put(newEndpointSlice(namespace1, service1, clusterID2, []string{endpointIP2}))
+	})
+
+	When("a headless service is exported by two connected clusters", func() {
+		It("should return the union of their endpoints for the unqualified name", func() {
+			msg := query(qname, false)
+			Expect(answerIPs(msg)).To(ConsistOf(endpointIP, endpointIP2))
+			Expect(msg.Truncated).To(BeFalse())
+		})
+
+		It("should return each cluster's endpoints for the cluster-qualified names", func() {
+			Expect(answerIPs(query(clusterID+"."+qname, false))).To(Equal([]string{endpointIP}))
+			Expect(answerIPs(query(clusterID2+"."+qname, false))).To(Equal([]string{endpointIP2}))
+		})
+	})
+
+	When("one of the clusters is disconnected", func() {
+		It("should only return the endpoints of the connected cluster for the unqualified name", func() {
+			mockCs.clusterStatusMap[clusterID2] = false
+			Expect(answerIPs(query(qname, false))).To(Equal([]string{endpointIP}))
+		})
+	})
+
+	When("the number of endpoints exceeds max-answers", func() {
+		BeforeEach(func() {
+			lh.maxAnswers = 1
+		})
+
+		It("should truncate the answer over UDP", func() {
+			msg := query(qname, false)
+			Expect(msg.Answer).To(HaveLen(1))
+			Expect(answerIPs(msg)).To(ContainElement(BeElementOf(endpointIP, endpointIP2)))
+			Expect(msg.Truncated).To(BeTrue())
+		})
+
+		It("should return all the endpoints over TCP", func() {
+			msg := query(qname, true)
+			Expect(answerIPs(msg)).To(ConsistOf(endpointIP, endpointIP2))
+			Expect(msg.Truncated).To(BeFalse())
+		})
+	})
+}
+
+func testDebugTXT() {
+	var (
+		rec    *dnstest.Recorder
+		lh     *Lighthouse
+		mockCs *MockClusterStatus
+		mockEs *MockEndpointStatus
+	)
+
+	const service2 = "service2"
+
+	qname := debugLabel + "." + service1 + "." + namespace1 + ".svc.clusterset.local."
+
+	txtRecords := func(qname string) []string {
+		code, err := lh.ServeDNS(context.TODO(), rec, (&test.Case{Qname: qname, Qtype: dns.TypeTXT}).Msg())
+		Expect(err).To(Succeed())
+		Expect(code).To(Equal(dns.RcodeSuccess))
+
+		txts := []string{}
+		for _, rr := range rec.Msg.Answer {
+			txts = append(txts, strings.Join(rr.(*dns.TXT).Txt, ""))
+		}
+
+		return txts
+	}
+
+	BeforeEach(func() {
+		mockCs = NewMockClusterStatus()
+		mockCs.clusterStatusMap[clusterID] = false
+		mockCs.clusterStatusMap[clusterID2] = true
+		mockEs = NewMockEndpointStatus()
+
+		lh = &Lighthouse{
+			Zones:           []string{"clusterset.local."},
+			serviceImports:  setupServiceImportMap(),
+			endpointSlices:  setupEndpointSliceMap(),
+			clusterStatus:   mockCs,
+			endpointsStatus: mockEs,
+			localServices:   NewMockLocalServices(),
+			ttl:             defaultTtl,
+			debugTXT:        true,
+		}
+
+		lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID2, serviceIP2, mcsv1a1.ClusterSetIP))
+		lh.endpointSlices.Put(newEndpointSlice(namespace1, service1, clusterID2, []string{endpointIP, endpointIP2}))
+
+		rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	})
+
+	When("none of the clusters exporting a service passes the filters", func() {
+		It("should enumerate the reason each cluster was filtered and the empty answer", func() {
+			Expect(txtRecords(qname)).To(Equal([]string{
+				"cluster=cluster1 connected=false endpoints=1 filtered=disconnected",
+				"cluster=cluster2 connected=true endpoints=2 filtered=unhealthy",
+				"answer= rcode=NOERROR",
+			}))
+
+			for _, rr := range rec.Msg.Answer {
+				Expect(rr.Header().Ttl).To(BeZero())
+			}
+		})
+	})
+
+	When("a cluster exporting a service passes the filters", func() {
+		It("should report the cluster as selected and the final answer", func() {
+			mockEs.endpointStatusMap[clusterID2] = true
+
+			Expect(txtRecords(qname)).To(ContainElements("cluster=cluster2 connected=true endpoints=2 selected=true",
+				"answer="+serviceIP2+" rcode=NOERROR"))
+		})
+	})
+
+	When("the clusters exporting a service are answered in turn", func() {
+		BeforeEach(func() {
+			mockCs.clusterStatusMap[clusterID] = true
+			mockEs.endpointStatusMap[clusterID] = true
+			mockEs.endpointStatusMap[clusterID2] = true
+		})
+
+		It("should not advance the round-robin", func() {
+			answer := func() string {
+				_, err := lh.ServeDNS(context.TODO(), rec, (&test.Case{
+					Qname: service1 + "." + namespace1 + ".svc.clusterset.local.",
+					Qtype: dns.TypeA,
+				}).Msg())
+				Expect(err).To(Succeed())
+				Expect(rec.Msg.Answer).To(HaveLen(1))
+
+				return rec.Msg.Answer[0].(*dns.A).A.String()
+			}
+
+			first := answer()
+
+			txtRecords(qname)
+
+			Expect(answer()).ToNot(Equal(first))
+		})
+	})
+
+	When("the endpoints in a cluster match an excluded CIDR", func() {
+		BeforeEach(func() {
+			_, cidr, err := net.ParseCIDR(endpointIP2 + "/32")
+			Expect(err).To(Succeed())
+			lh.endpointSlices.ExcludeCIDRs([]*net.IPNet{cidr})
+
+			mockCs.clusterStatusMap[clusterID] = true
+			lh.serviceImports.Put(newServiceImport(namespace1, service2, clusterID, "", mcsv1a1.Headless))
+			lh.endpointSlices.Put(newEndpointSlice(namespace1, service2, clusterID, []string{endpointIP, endpointIP2}))
+		})
+
+		It("should enumerate the excluded addresses", func() {
+			Expect(txtRecords(debugLabel + "." + service2 + "." + namespace1 + ".svc.clusterset.local.")).To(Equal([]string{
+				"cluster=cluster1 connected=true endpoints=1 selected=true excluded=" + endpointIP2 + "(" + endpointIP2 + "/32)",
+				"answer=" + endpointIP + " rcode=NOERROR",
+			}))
+		})
+	})
+
+	When("the service doesn't exist", func() {
+		It("should report the failed answer", func() {
+			Expect(txtRecords(debugLabel + ".unknown." + namespace1 + ".svc.clusterset.local.")).To(Equal([]string{
+				"answer= rcode=NXDOMAIN",
+			}))
+		})
+	})
+
+	When("the service doesn't exist and the query falls through", func() {
+		BeforeEach(func() {
+			lh.Fall = fall.Root
+			lh.Next = plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+				Fail("The diagnosed query was passed to the next plugin")
+				return dns.RcodeSuccess, nil
+			})
+		})
+
+		It("should not pass the diagnosed query to the next plugin", func() {
+			Expect(txtRecords(debugLabel + ".unknown." + namespace1 + ".svc.clusterset.local.")).To(Equal([]string{
+				"answer= rcode=SERVFAIL",
+			}))
+		})
+	})
+
+	When("debug TXT records aren't enabled", func() {
+		It("should return RcodeNotImplemented", func() {
+			lh.debugTXT = false
+			executeTestCase(lh, rec, test.Case{
+				Qname: qname,
+				Qtype: dns.TypeTXT,
+				Rcode: dns.RcodeNotImplemented,
+			})
+		})
+	})
+}
+
+func testCaseInsensitivity() {
+	var (
+		rec *dnstest.Recorder
+		lh  *Lighthouse
+	)
+
+	BeforeEach(func() {
+		mockCs := NewMockClusterStatus()
+		mockCs.clusterStatusMap[clusterID] = true
+		mockEs := NewMockEndpointStatus()
+		mockEs.endpointStatusMap[clusterID] = true
+		lh = &Lighthouse{
+			Zones:           []string{"clusterset.local."},
+			serviceImports:  setupServiceImportMap(),
+			endpointSlices:  setupEndpointSliceMap(),
+			clusterStatus:   mockCs,
+			endpointsStatus: mockEs,
+			localServices:   NewMockLocalServices(),
+			ttl:             defaultTtl,
+			clustersTXT:     true,
+		}
+
+		rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	})
+
+	When("type A DNS query for an existing service in mixed case", func() {
+		It("should succeed and write an A record response with the case of the query", func() {
+			qname := "Service1.NameSpace1.svc.clusterset.local."
+			executeTestCase(lh, rec, test.Case{
+				Qname:  qname,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{test.A(qname + "    5    IN    A    " + serviceIP)},
+			})
+		})
+	})
+
+	When("type A DNS query for an existing service in a specific cluster in mixed case", func() {
+		It("should succeed and write an A record response with the case of the query", func() {
+			qname := "CLUSTER1.SERVICE1.NAMESPACE1.SVC.CLUSTERSET.LOCAL."
+			executeTestCase(lh, rec, test.Case{
+				Qname:  qname,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{test.A(qname + "    5    IN    A    " + serviceIP)},
+			})
+		})
+	})
+
+	When("type A DNS query for an existing service with a mixed case zone", func() {
+		It("should succeed and write an A record response with the case of the query", func() {
+			qname := service1 + "." + namespace1 + ".Svc.ClusterSet.Local."
+			executeTestCase(lh, rec, test.Case{
+				Qname:  qname,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{test.A(qname + "    5    IN    A    " + serviceIP)},
+			})
+		})
+	})
+
+	When("type A DNS query for the short name of an exported service in mixed case", func() {
+		It("should succeed and write an A record response for the short name", func() {
+			lh.searchDomain = "svc.clusterset.local."
+			qname := "Service1.Namespace1."
+			executeTestCase(lh, rec, test.Case{
+				Qname:  qname,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{test.A(qname + "    5    IN    A    " + serviceIP)},
+			})
+		})
+	})
+
+	When("an alias is queried in mixed case", func() {
+		It("should return the A records of the service under the alias name", func() {
+			lh.aliases = map[string]string{namespace1 + "/alias1": namespace1 + "/" + service1}
+			qname := "Alias1.Namespace1.svc.clusterset.local."
+			executeTestCase(lh, rec, test.Case{
+				Qname:  qname,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{test.A(qname + "    5    IN    A    " + serviceIP)},
+			})
+		})
+	})
+
+	When("type TXT DNS query for the clusters of a service in mixed case", func() {
+		It("should succeed and write a TXT record response with the case of the query", func() {
+			qname := "_Clusters.Service1.Namespace1.svc.clusterset.local."
+			executeTestCase(lh, rec, test.Case{
+				Qname: qname,
+				Qtype: dns.TypeTXT,
+				Rcode: dns.RcodeSuccess,
+				Answer: []dns.RR{
+					test.TXT(qname + `    5    IN    TXT    "cluster=cluster1 connected=true endpoints=1"`),
+				},
+			})
+		})
+	})
+}
+
+func testMinClusters() {
+	var (
+		rec    *dnstest.Recorder
+		lh     *Lighthouse
+		mockCs *MockClusterStatus
+		mockEs *MockEndpointStatus
+	)
+
+	qname := service1 + "." + namespace1 + ".svc.clusterset.local."
+
+	newMinClustersServiceImport := func(clusterID string, siType mcsv1a1.ServiceImportType, ip string) *mcsv1a1.ServiceImport {
+		si := newServiceImport(namespace1, service1, clusterID, ip, siType)
+		si.Annotations[lhconstants.AnnotationMinClusters] = "2"
+
+		return si
+	}
+
+	BeforeEach(func() {
+		mockCs = NewMockClusterStatus()
+		mockCs.clusterStatusMap[clusterID] = true
+		mockCs.clusterStatusMap[clusterID2] = true
+		mockEs = NewMockEndpointStatus()
+		mockEs.endpointStatusMap[clusterID] = true
+		mockEs.endpointStatusMap[clusterID2] = true
+		lh = &Lighthouse{
+			Zones:            []string{"clusterset.local."},
+			serviceImports:   serviceimport.NewMap(),
+			endpointSlices:   endpointslice.NewMap(),
+			clusterStatus:    mockCs,
+			endpointsStatus:  mockEs,
+			localServices:    NewMockLocalServices(),
+			ttl:              defaultTtl,
+			serviceFallbacks: map[string]string{},
+		}
+
+		lh.serviceImports.Put(newMinClustersServiceImport(clusterID, mcsv1a1.Headless, ""))
+		lh.serviceImports.Put(newMinClustersServiceImport(clusterID2, mcsv1a1.Headless, ""))
+		lh.endpointSlices.Put(newEndpointSlice(namespace1, service1, clusterID, []string{endpointIP}))
+		lh.endpointSlices.Put(newEndpointSlice(namespace1, service1, clusterID2, []string{endpointIP2}))
+
+		rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	})
+
+	When("the required number of clusters is available", func() {
+		It("should succeed and write the A records of all the clusters", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname: qname,
+				Qtype: dns.TypeA,
+				Rcode: dns.RcodeSuccess,
+				Answer: []dns.RR{
+					test.A(qname + "    5    IN    A    " + endpointIP),
+					test.A(qname + "    5    IN    A    " + endpointIP2),
+				},
+			})
+		})
+	})
+
+	When("fewer than the required number of clusters are connected", func() {
+		It("should return an empty response", func() {
+			mockCs.clusterStatusMap[clusterID2] = false
+			executeTestCase(lh, rec, test.Case{
+				Qname:  qname,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{},
+			})
+		})
+	})
+
+	When("fewer than the required number of clusters have healthy endpoints", func() {
+		It("should return an empty response", func() {
+			mockEs.endpointStatusMap[clusterID] = false
+			executeTestCase(lh, rec, test.Case{
+				Qname:  qname,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{},
+			})
+		})
+	})
+
+	When("fewer than the required number of clusters are available for a ClusterSetIP service", func() {
+		It("should return an empty response", func() {
+			lh.serviceImports = serviceimport.NewMap()
+			lh.serviceImports.Put(newMinClustersServiceImport(clusterID, mcsv1a1.ClusterSetIP, serviceIP))
+			lh.serviceImports.Put(newMinClustersServiceImport(clusterID2, mcsv1a1.ClusterSetIP, serviceIP2))
+			mockCs.clusterStatusMap[clusterID] = false
+
+			executeTestCase(lh, rec, test.Case{
+				Qname:  qname,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{},
+			})
+		})
+	})
+
+	When("fewer than the required number of clusters are available and a fallback is configured", func() {
+		It("should return the fallback", func() {
+			lh.fallback = "192.0.2.10"
+			mockCs.clusterStatusMap[clusterID2] = false
+			executeTestCase(lh, rec, test.Case{
+				Qname:  qname,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{test.A(qname + "    5    IN    A    192.0.2.10")},
+			})
+		})
+	})
+
+	When("a specific cluster is requested while fewer than the required number of clusters are available", func() {
+		It("should succeed and write that cluster's A record", func() {
+			mockCs.clusterStatusMap[clusterID2] = false
+			executeTestCase(lh, rec, test.Case{
+				Qname:  clusterID + "." + qname,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{test.A(clusterID + "." + qname + "    5    IN    A    " + endpointIP)},
+			})
+		})
+	})
+
+	When("the service doesn't set a minimum", func() {
+		It("should succeed with a single available cluster", func() {
+			lh.serviceImports = serviceimport.NewMap()
+			lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID, "", mcsv1a1.Headless))
+			lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID2, "", mcsv1a1.Headless))
+			mockCs.clusterStatusMap[clusterID2] = false
+
+			executeTestCase(lh, rec, test.Case{
+				Qname:  qname,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{test.A(qname + "    5    IN    A    " + endpointIP)},
+			})
+		})
+	})
+}
+
+func testMessageOptions() {
+	var (
+		rec *dnstest.Recorder
+		lh  *Lighthouse
+	)
+
+	qname := service1 + "." + namespace1 + ".svc.clusterset.local."
+
+	BeforeEach(func() {
+		mockCs := NewMockClusterStatus()
+		mockCs.clusterStatusMap[clusterID] = true
+		mockEs := NewMockEndpointStatus()
+		mockEs.endpointStatusMap[clusterID] = true
+		lh = &Lighthouse{
+			Zones:           []string{"clusterset.local."},
+			serviceImports:  setupServiceImportMap(),
+			endpointSlices:  setupEndpointSliceMap(),
+			clusterStatus:   mockCs,
+			endpointsStatus: mockEs,
+			localServices:   NewMockLocalServices(),
+			ttl:             defaultTtl,
+		}
+
+		rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	})
+
+	serve := func(m *dns.Msg) {
+		code, err := lh.ServeDNS(context.TODO(), rec, m)
+		Expect(err).To(Succeed())
+		Expect(code).To(Equal(dns.RcodeSuccess))
+		Expect(rec.Msg.Answer).To(HaveLen(1))
+	}
+
+	When("compression isn't disabled", func() {
+		It("should compress the answer", func() {
+			serve(new(dns.Msg).SetQuestion(qname, dns.TypeA))
+			Expect(rec.Msg.Compress).To(BeTrue())
+		})
+	})
+
+	When("compression is disabled", func() {
+		It("should not compress the answer", func() {
+			lh.noCompression = true
+			serve(new(dns.Msg).SetQuestion(qname, dns.TypeA))
+			Expect(rec.Msg.Compress).To(BeFalse())
+		})
+	})
+
+	When("the query has EDNS0 options", func() {
+		It("should echo the supported options", func() {
+			m := new(dns.Msg).SetQuestion(qname, dns.TypeA)
+			m.SetEdns0(4096, true)
+			opt := m.IsEdns0()
+			opt.Option = append(opt.Option,
+				&dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "24a5ac1223344556"},
+				&dns.EDNS0_NSID{Code: dns.EDNS0NSID},
+				&dns.EDNS0_LOCAL{Code: dns.EDNS0LOCALSTART, Data: []byte{1}})
+
+			serve(m)
+
+			replyOpt := rec.Msg.IsEdns0()
+			Expect(replyOpt).ToNot(BeNil())
+			Expect(replyOpt.UDPSize()).To(Equal(uint16(4096)))
+			Expect(replyOpt.Do()).To(BeTrue())
+			Expect(replyOpt.Option).To(HaveLen(2))
+			Expect(replyOpt.Option[0]).To(BeAssignableToTypeOf(&dns.EDNS0_COOKIE{}))
+			Expect(replyOpt.Option[0].(*dns.EDNS0_COOKIE).Cookie).To(Equal("24a5ac1223344556"))
+			Expect(replyOpt.Option[1].Option()).To(Equal(uint16(dns.EDNS0NSID)))
+		})
+	})
+
+	When("the query has no OPT record", func() {
+		It("should not add one", func() {
+			serve(new(dns.Msg).SetQuestion(qname, dns.TypeA))
+			Expect(rec.Msg.IsEdns0()).To(BeNil())
+		})
+	})
+}
+
+func testExcludedClusters() {
+	const configMapNamespace = "submariner-operator"
+
+	var (
+		rec        *dnstest.Recorder
+		lh         *Lighthouse
+		kubeClient *fakeKubeClient.Clientset
+		controller *exclusion.Controller
+		configMap  *corev1.ConfigMap
+	)
+
+	qname := service1 + "." + namespace1 + ".svc.clusterset.local."
+
+	BeforeEach(func() {
+		mockCs := NewMockClusterStatus()
+		mockCs.clusterStatusMap[clusterID] = true
+		mockCs.clusterStatusMap[clusterID2] = true
+		mockEs := NewMockEndpointStatus()
+		mockEs.endpointStatusMap[clusterID] = true
+		mockEs.endpointStatusMap[clusterID2] = true
+
+		kubeClient = fakeKubeClient.NewSimpleClientset()
+		controller = exclusion.NewController(configMapNamespace, "excluded-clusters")
+		controller.NewClientset = func(kubeConfig *rest.Config) (kubernetes.Interface, error) {
+			return kubeClient, nil
+		}
+
+		Expect(controller.Start(&rest.Config{})).To(Succeed())
+
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "excluded-clusters", Namespace: configMapNamespace},
+			Data:       map[string]string{exclusion.ClustersKey: ""},
+		}
+		_, err := kubeClient.CoreV1().ConfigMaps(configMapNamespace).Create(configMap)
+		Expect(err).To(Succeed())
+
+		lh = &Lighthouse{
+			Zones:            []string{"clusterset.local."},
+			serviceImports:   serviceimport.NewMap(),
+			endpointSlices:   endpointslice.NewMap(),
+			clusterStatus:    mockCs,
+			endpointsStatus:  mockEs,
+			localServices:    NewMockLocalServices(),
+			ttl:              defaultTtl,
+			excludedClusters: controller,
+		}
+
+		lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID, "", mcsv1a1.Headless))
+		lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID2, "", mcsv1a1.Headless))
+		lh.endpointSlices.Put(newEndpointSlice(namespace1, service1, clusterID, []string{endpointIP}))
+		lh.endpointSlices.Put(newEndpointSlice(namespace1, service1, clusterID2, []string{endpointIP2}))
+
+		rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	})
+
+	AfterEach(func() {
+		controller.Stop()
+	})
+
+	answerIPs := func(name string) []string {
+		code, err := lh.ServeDNS(context.TODO(), rec, test.Case{Qname: name, Qtype: dns.TypeA}.Msg())
+		Expect(err).To(Succeed())
+		Expect(code).To(Equal(dns.RcodeSuccess))
+
+		ips := []string{}
+		for _, rr := range rec.Msg.Answer {
+			ips = append(ips, rr.(*dns.A).A.String())
+		}
+
+		sort.Strings(ips)
+
+		return ips
+	}
+
+	updateExcluded := func(clusterIDs string) {
+		configMap.Data[exclusion.ClustersKey] = clusterIDs
+		_, err := kubeClient.CoreV1().ConfigMaps(configMapNamespace).Update(configMap)
+		Expect(err).To(Succeed())
+	}
+
+	When("the excluded clusters are updated", func() {
+		It("should change the answers accordingly", func() {
+			Expect(answerIPs(qname)).To(Equal([]string{endpointIP, endpointIP2}))
+
+			updateExcluded(clusterID2)
+			Eventually(func() []string {
+				return answerIPs(qname)
+			}, 5).Should(Equal([]string{endpointIP}))
+
+			updateExcluded(clusterID)
+			Eventually(func() []string {
+				return answerIPs(qname)
+			}, 5).Should(Equal([]string{endpointIP2}))
+
+			updateExcluded("")
+			Eventually(func() []string {
+				return answerIPs(qname)
+			}, 5).Should(Equal([]string{endpointIP, endpointIP2}))
+		})
+	})
+
+	When("an excluded cluster is specifically requested", func() {
+		It("should still answer with its endpoints", func() {
+			updateExcluded(clusterID2)
+			Eventually(func() bool {
+				return controller.IsExcluded(clusterID2)
+			}, 5).Should(BeTrue())
+
+			Expect(answerIPs(clusterID2 + "." + qname)).To(Equal([]string{endpointIP2}))
+		})
+	})
+}
+
+func testLocalZone() {
+	var (
+		rec *dnstest.Recorder
+		lh  *Lighthouse
+	)
+
+	BeforeEach(func() {
+		mockCs := NewMockClusterStatus()
+		mockCs.clusterStatusMap[clusterID] = true
+		mockCs.clusterStatusMap[clusterID2] = true
+		mockCs.localClusterID = clusterID
+		mockEs := NewMockEndpointStatus()
+		mockEs.endpointStatusMap[clusterID] = true
+		mockEs.endpointStatusMap[clusterID2] = true
+		mockLs := NewMockLocalServices()
+		mockLs.LocalServicesMap[getKey(service1, namespace1)] = serviceIP
+		lh = &Lighthouse{
+			Zones:           []string{"clusterset.local.", defaultLocalZone},
+			Next:            test.NextHandler(dns.RcodeBadCookie, errors.New("dummy plugin")),
+			serviceImports:  setupServiceImportMap(),
+			endpointSlices:  setupEndpointSliceMap(),
+			clusterStatus:   mockCs,
+			endpointsStatus: mockEs,
+			localServices:   mockLs,
+			ttl:             defaultTtl,
+			localZones:      map[string]bool{defaultLocalZone: true},
+		}
+		lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID2, serviceIP2, mcsv1a1.ClusterSetIP))
+
+		rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	})
+
+	When("a service exported by the local cluster is queried in the local zone", func() {
+		It("should return the local cluster's IP", func() {
+			qname := service1 + "." + namespace1 + ".svc.cluster.local."
+			executeTestCase(lh, rec, test.Case{
+				Qname:  qname,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{test.A(qname + "    5    IN    A    " + serviceIP)},
+			})
+		})
+	})
+
+	When("a headless service exported by the local cluster is queried in the local zone", func() {
+		BeforeEach(func() {
+			lh.serviceImports.Put(newServiceImport(namespace2, service1, clusterID, "", mcsv1a1.Headless))
+			lh.serviceImports.Put(newServiceImport(namespace2, service1, clusterID2, "", mcsv1a1.Headless))
+			lh.endpointSlices.Put(newEndpointSlice(namespace2, service1, clusterID, []string{endpointIP}))
+			lh.endpointSlices.Put(newEndpointSlice(namespace2, service1, clusterID2, []string{endpointIP2}))
+		})
+
+		It("should return only the local cluster's endpoint IPs", func() {
+			qname := service1 + "." + namespace2 + ".svc.cluster.local."
+			executeTestCase(lh, rec, test.Case{
+				Qname:  qname,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{test.A(qname + "    5    IN    A    " + endpointIP)},
+			})
+		})
+	})
+
+	When("a service exported by the local cluster is queried in the local zone in standby mode", func() {
+		It("should refuse the query", func() {
+			lh.standby = 1
+
+			code, err := lh.ServeDNS(context.TODO(), rec, (&test.Case{
+				Qname: service1 + "." + namespace1 + ".svc.cluster.local.",
+				Qtype: dns.TypeA,
+			}).Msg())
+			Expect(err).To(Succeed())
+			Expect(code).To(Equal(dns.RcodeRefused))
+			Expect(rec.Msg).To(BeNil())
+		})
+	})
+
+	When("a headless service with IPv6 local endpoints is queried in the local zone", func() {
+		BeforeEach(func() {
+			lh.serviceImports.Put(newServiceImport(namespace2, service1, clusterID, "", mcsv1a1.Headless))
+
+			es := newEndpointSlice(namespace2, service1, clusterID, []string{"fd00::1", "fd00::2"})
+			es.AddressType = discovery.AddressTypeIPv6
+			lh.endpointSlices.Put(es)
+		})
+
+		It("should return an empty answer", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname:  service1 + "." + namespace2 + ".svc.cluster.local.",
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{},
+			})
+		})
+	})
+
+	When("a service with an IPv6 local IP is queried in the local zone", func() {
+		BeforeEach(func() {
+			lh.localServices.(*MockLocalServices).LocalServicesMap[getKey(service1, namespace1)] = "fd00::1"
+		})
+
+		It("should return an empty answer", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname:  service1 + "." + namespace1 + ".svc.cluster.local.",
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{},
+			})
+		})
+	})
+
+	When("a headless service exported with a clusterset DNS priority is queried in the local zone", func() {
+		BeforeEach(func() {
+			for _, si := range []*mcsv1a1.ServiceImport{
+				newServiceImport(namespace2, service1, clusterID, "", mcsv1a1.Headless),
+				newServiceImport(namespace2, service1, clusterID2, "", mcsv1a1.Headless),
+			} {
+				si.Annotations[lhconstants.AnnotationDNSPriority] = lhconstants.DNSPriorityClusterset
+				lh.serviceImports.Put(si)
+			}
+
+			lh.endpointSlices.Put(newEndpointSlice(namespace2, service1, clusterID, []string{endpointIP}))
+			lh.endpointSlices.Put(newEndpointSlice(namespace2, service1, clusterID2, []string{endpointIP2}))
+		})
+
+		It("should return the endpoint IPs of all the clusters", func() {
+			qname := service1 + "." + namespace2 + ".svc.cluster.local."
+			executeTestCase(lh, rec, test.Case{
+				Qname: qname,
+				Qtype: dns.TypeA,
+				Rcode: dns.RcodeSuccess,
+				Answer: []dns.RR{
+					test.A(qname + "    5    IN    A    " + endpointIP),
+					test.A(qname + "    5    IN    A    " + endpointIP2),
+				},
+			})
+		})
+	})
+
+	When("a service's local endpoints are unhealthy", func() {
+		BeforeEach(func() {
+			lh.endpointsStatus.(*MockEndpointStatus).endpointStatusMap[clusterID] = false
+		})
+
+		Context("and it's exported with a clusterset DNS priority", func() {
+			BeforeEach(func() {
+				for _, si := range []*mcsv1a1.ServiceImport{
+					newServiceImport(namespace1, service1, clusterID, serviceIP, mcsv1a1.ClusterSetIP),
+					newServiceImport(namespace1, service1, clusterID2, serviceIP2, mcsv1a1.ClusterSetIP),
+				} {
+					si.Annotations[lhconstants.AnnotationDNSPriority] = lhconstants.DNSPriorityClusterset
+					lh.serviceImports.Put(si)
+				}
+			})
+
+			It("should return the clusterset answer in the local zone", func() {
+				qname := service1 + "." + namespace1 + ".svc.cluster.local."
+				executeTestCase(lh, rec, test.Case{
+					Qname:  qname,
+					Qtype:  dns.TypeA,
+					Rcode:  dns.RcodeSuccess,
+					Answer: []dns.RR{test.A(qname + "    5    IN    A    " + serviceIP2)},
+				})
+			})
+		})
+
+		Context("and it's exported with a local DNS priority", func() {
+			BeforeEach(func() {
+				si := newServiceImport(namespace1, service1, clusterID, serviceIP, mcsv1a1.ClusterSetIP)
+				si.Annotations[lhconstants.AnnotationDNSPriority] = lhconstants.DNSPriorityLocal
+				lh.serviceImports.Put(si)
+			})
+
+			It("should return the local cluster's IP in the local zone", func() {
+				qname := service1 + "." + namespace1 + ".svc.cluster.local."
+				executeTestCase(lh, rec, test.Case{
+					Qname:  qname,
+					Qtype:  dns.TypeA,
+					Rcode:  dns.RcodeSuccess,
+					Answer: []dns.RR{test.A(qname + "    5    IN    A    " + serviceIP)},
+				})
+			})
+		})
+	})
+
+	When("a service exported only by a remote cluster is queried in the local zone", func() {
+		BeforeEach(func() {
+			lh.serviceImports.Put(newServiceImport(namespace2, service1, clusterID2, serviceIP2, mcsv1a1.ClusterSetIP))
+		})
+
+		It("should invoke the next plugin", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname: service1 + "." + namespace2 + ".svc.cluster.local.",
+				Qtype: dns.TypeA,
+				Rcode: dns.RcodeBadCookie,
+			})
+		})
+	})
+
+	When("an unknown service is queried in the local zone", func() {
+		It("should invoke the next plugin", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname: "unknown." + namespace1 + ".svc.cluster.local.",
+				Qtype: dns.TypeA,
+				Rcode: dns.RcodeBadCookie,
+			})
+		})
+	})
+
+	When("a non-service name is queried in the local zone", func() {
+		It("should invoke the next plugin", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname: "kubernetes.default.pod.cluster.local.",
+				Qtype: dns.TypeA,
+				Rcode: dns.RcodeBadCookie,
+			})
+		})
+	})
+
+	When("a type AAAA query is made in the local zone", func() {
+		It("should invoke the next plugin", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname: service1 + "." + namespace1 + ".svc.cluster.local.",
+				Qtype: dns.TypeAAAA,
+				Rcode: dns.RcodeBadCookie,
+			})
+		})
+	})
+
+	When("a service is queried in the clusterset zone", func() {
+		It("should be answered as before", func() {
+			qname := service1 + "." + namespace1 + ".svc.clusterset.local."
+			executeTestCase(lh, rec, test.Case{
+				Qname:  qname,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{test.A(qname + "    5    IN    A    " + serviceIP)},
+			})
+		})
+	})
+}
+
+func testUIDQueries() {
+	const uid = "0fb1a5c4-8f24-5c1e-a9d5-3b4b6e3f6d7a"
+
+	var (
+		rec *dnstest.Recorder
+		lh  *Lighthouse
+	)
+
+	BeforeEach(func() {
+		mockCs := NewMockClusterStatus()
+		mockCs.clusterStatusMap[clusterID] = true
+		mockEs := NewMockEndpointStatus()
+		mockEs.endpointStatusMap[clusterID] = true
+		lh = &Lighthouse{
+			Zones:           []string{"clusterset.local."},
+			serviceImports:  serviceimport.NewMap(),
+			endpointSlices:  setupEndpointSliceMap(),
+			clusterStatus:   mockCs,
+			endpointsStatus: mockEs,
+			localServices:   NewMockLocalServices(),
+			ttl:             defaultTtl,
+			uidQueries:      true,
+		}
+
+		si := newServiceImport(namespace1, service1, clusterID, serviceIP, mcsv1a1.ClusterSetIP)
+		si.Annotations[lhconstants.AnnotationClustersetUID] = uid
+		lh.serviceImports.Put(si)
+
+		rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	})
+
+	When("a known UID is queried", func() {
+		It("should answer with the service's IP", func() {
+			qname := uid + ".uid.clusterset.local."
+			executeTestCase(lh, rec, test.Case{
+				Qname:  qname,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{test.A(qname + "    5    IN    A    " + serviceIP)},
+			})
+		})
+	})
+
+	When("an unknown UID is queried", func() {
+		It("should return RcodeNameError", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname: "3d4a5b6c-0000-5000-8000-000000000000.uid.clusterset.local.",
+				Qtype: dns.TypeA,
+				Rcode: dns.RcodeNameError,
+			})
+		})
+	})
+
+	When("UID queries are not enabled", func() {
+		BeforeEach(func() {
+			lh.uidQueries = false
+		})
+
+		It("should return RcodeNameError", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname: uid + ".uid.clusterset.local.",
+				Qtype: dns.TypeA,
+				Rcode: dns.RcodeNameError,
+			})
+		})
+	})
+}
+
+func testPreSync() {
+	var (
+		rec    *dnstest.Recorder
+		lh     *Lighthouse
+		synced bool
+	)
+
+	qname := service1 + "." + namespace1 + ".svc.clusterset.local."
+
+	BeforeEach(func() {
+		mockCs := NewMockClusterStatus()
+		mockCs.clusterStatusMap[clusterID] = true
+		mockEs := NewMockEndpointStatus()
+		mockEs.endpointStatusMap[clusterID] = true
+		synced = false
+
+		lh = &Lighthouse{
+			Zones:           []string{"clusterset.local."},
+			serviceImports:  setupServiceImportMap(),
+			endpointSlices:  setupEndpointSliceMap(),
+			clusterStatus:   mockCs,
+			endpointsStatus: mockEs,
+			localServices:   NewMockLocalServices(),
+			ttl:             defaultTtl,
+			preSyncRcode:    dns.RcodeServerFailure,
+			synced: func() bool {
+				return synced
+			},
+		}
+
+		rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	})
+
+	answered := test.Case{
+		Qname: qname,
+		Qtype: dns.TypeA,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.A(qname + "    5    IN    A    " + serviceIP),
+		},
+	}
+
+	When("the initial sync hasn't completed", func() {
+		It("should answer queries with SERVFAIL", func() {
+			code, err := lh.ServeDNS(context.TODO(), rec, (&test.Case{Qname: qname, Qtype: dns.TypeA}).Msg())
+			Expect(err).To(Succeed())
+			Expect(code).To(Equal(dns.RcodeServerFailure))
+			Expect(rec.Msg).To(BeNil())
+		})
+
+		When("configured to refuse queries", func() {
+			It("should answer queries with REFUSED", func() {
+				lh.preSyncRcode = dns.RcodeRefused
+
+				code, err := lh.ServeDNS(context.TODO(), rec, (&test.Case{Qname: qname, Qtype: dns.TypeA}).Msg())
+				Expect(err).To(Succeed())
+				Expect(code).To(Equal(dns.RcodeRefused))
+			})
+		})
+
+		When("configured to serve queries", func() {
+			It("should answer queries from the data synced so far", func() {
+				lh.preSyncRcode = dns.RcodeSuccess
+				executeTestCase(lh, rec, answered)
+			})
+		})
+	})
+
+	When("the initial sync completed", func() {
+		It("should answer queries, even if the controllers are no longer synced", func() {
+			synced = true
+			executeTestCase(lh, rec, answered)
+
+			synced = false
+			rec = dnstest.NewRecorder(&test.ResponseWriter{})
+			executeTestCase(lh, rec, answered)
+		})
+	})
+}
+
+func testSnapshot() {
+	var (
+		lh     *Lighthouse
+		mockCs *MockClusterStatus
+	)
+
+	BeforeEach(func() {
+		mockCs = NewMockClusterStatus()
+		mockCs.clusterStatusMap[clusterID] = true
+		mockCs.clusterStatusMap[clusterID2] = true
+		mockCs.localClusterID = clusterID
+		mockEs := NewMockEndpointStatus()
+		mockEs.endpointStatusMap[clusterID] = true
+		mockEs.endpointStatusMap[clusterID2] = true
+
+		lh = &Lighthouse{
+			Zones:           []string{"clusterset.local."},
+			serviceImports:  setupServiceImportMap(),
+			endpointSlices:  setupEndpointSliceMap(),
+			clusterStatus:   mockCs,
+			endpointsStatus: mockEs,
+			localServices:   NewMockLocalServices(),
+			ttl:             defaultTtl,
+		}
+
+		lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID2, serviceIP2, mcsv1a1.ClusterSetIP))
+	})
+
+	clusterSnapshot := func(snapshot *Snapshot, clusterID string) ClusterSnapshot {
+		Expect(snapshot.Services).To(HaveLen(1))
+
+		for _, cluster := range snapshot.Services[0].Clusters {
+			if cluster.ClusterID == clusterID {
+				return cluster
+			}
+		}
+
+		Fail("No snapshot for cluster " + clusterID)
+
+		return ClusterSnapshot{}
+	}
+
+	It("should list the answers for each exported service", func() {
+		snapshot := lh.Snapshot()
+		Expect(snapshot.Zones).To(Equal([]string{"clusterset.local."}))
+		Expect(snapshot.LocalClusterID).To(Equal(clusterID))
+		Expect(snapshot.Services).To(HaveLen(1))
+
+		service := snapshot.Services[0]
+		Expect(service.Namespace).To(Equal(namespace1))
+		Expect(service.Name).To(Equal(service1))
+		Expect(service.DNSNames).To(Equal([]string{service1 + "." + namespace1 + ".svc.clusterset.local."}))
+		Expect(service.RecordTypes).To(Equal([]string{"A"}))
+		Expect(service.Type).To(Equal(string(mcsv1a1.ClusterSetIP)))
+		Expect(service.Clusters).To(HaveLen(2))
+
+		Expect(clusterSnapshot(snapshot, clusterID)).To(Equal(ClusterSnapshot{
+			ClusterID: clusterID,
+			Merged:    true,
+			Connected: true,
+			Healthy:   true,
+			IP:        serviceIP,
+			Endpoints: []string{endpointIP},
+			Eligible:  true,
+		}))
+
+		Expect(clusterSnapshot(snapshot, clusterID2)).To(Equal(ClusterSnapshot{
+			ClusterID: clusterID2,
+			Merged:    true,
+			Connected: true,
+			Healthy:   true,
+			IP:        serviceIP2,
+			Endpoints: []string{},
+			Eligible:  true,
+		}))
+	})
+
+	When("a cluster is disconnected", func() {
+		It("should report the cluster as not eligible", func() {
+			mockCs.clusterStatusMap[clusterID2] = false
+
+			cluster := clusterSnapshot(lh.Snapshot(), clusterID2)
+			Expect(cluster.Connected).To(BeFalse())
+			Expect(cluster.Eligible).To(BeFalse())
+			Expect(clusterSnapshot(lh.Snapshot(), clusterID).Eligible).To(BeTrue())
+		})
+	})
+
+	When("a port of the service has an appProtocol", func() {
+		It("should include it in the ports", func() {
+			appProtocol := "kubernetes.io/h2c"
+			si := newServiceImport(namespace1, service1, clusterID2, serviceIP2, mcsv1a1.ClusterSetIP)
+			si.Spec.Ports = []mcsv1a1.ServicePort{{Name: "http", Protocol: corev1.ProtocolTCP, Port: 80,
+				AppProtocol: &appProtocol}}
+			lh.serviceImports.Put(si)
+
+			Expect(lh.Snapshot().Services[0].Ports).To(Equal([]PortSnapshot{{Name: "http", Protocol: "TCP", Port: 80,
+				AppProtocol: appProtocol}}))
+		})
+	})
+
+	When("the TXT records are enabled", func() {
+		It("should include the TXT record type", func() {
+			lh.clustersTXT = true
+			Expect(lh.Snapshot().Services[0].RecordTypes).To(Equal([]string{"A", "TXT"}))
+		})
+	})
+
+	When("the snapshot endpoint is queried", func() {
+		It("should return the JSON of the snapshot", func() {
+			recorder := httptest.NewRecorder()
+			lh.snapshotHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, debugSnapshotPath, nil))
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Header().Get("Content-Type")).To(Equal("application/json"))
+
+			snapshot := &Snapshot{}
+			Expect(json.Unmarshal(recorder.Body.Bytes(), snapshot)).To(Succeed())
+			Expect(snapshot.Services).To(HaveLen(1))
+			Expect(snapshot.Services[0].Clusters).To(HaveLen(2))
+		})
+
+		It("should reject methods other than GET", func() {
+			recorder := httptest.NewRecorder()
+			lh.snapshotHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, debugSnapshotPath, nil))
+			Expect(recorder.Code).To(Equal(http.StatusMethodNotAllowed))
+		})
+	})
+}
+
+func testQueryTimeout() {
+	var (
+		rec        *dnstest.Recorder
+		lh         *Lighthouse
+		slowCs     *slowClusterStatus
+		nextCalled int32
+	)
+
+	qname := service1 + "." + namespace1 + ".svc.clusterset.local."
+
+	BeforeEach(func() {
+		mockCs := NewMockClusterStatus()
+		mockCs.clusterStatusMap[clusterID] = true
+		slowCs = &slowClusterStatus{MockClusterStatus: mockCs}
+		mockEs := NewMockEndpointStatus()
+		mockEs.endpointStatusMap[clusterID] = true
+		atomic.StoreInt32(&nextCalled, 0)
+
+		lh = &Lighthouse{
+			Zones: []string{"clusterset.local."},
+			Next: plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+				atomic.AddInt32(&nextCalled, 1)
+				return dns.RcodeBadCookie, errors.New("dummy plugin")
+			}),
+			serviceImports:     setupServiceImportMap(),
+			endpointSlices:     setupEndpointSliceMap(),
+			clusterStatus:      slowCs,
+			endpointsStatus:    mockEs,
+			localServices:      NewMockLocalServices(),
+			ttl:                defaultTtl,
+			queryTimeout:       50 * time.Millisecond,
+			queryTimeoutAction: timeoutFallthrough,
+		}
+
+		rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	})
+
+	When("the query is answered within the timeout", func() {
+		It("should return the answer", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname: qname,
+				Qtype: dns.TypeA,
+				Rcode: dns.RcodeSuccess,
+				Answer: []dns.RR{
+					test.A(qname + "    5    IN    A    " + serviceIP),
+				},
+			})
+			Expect(atomic.LoadInt32(&nextCalled)).To(BeZero())
+		})
+	})
+
+	When("the query isn't answered within the timeout", func() {
+		BeforeEach(func() {
+			slowCs.delay = 300 * time.Millisecond
+		})
+
+		It("should invoke the next plugin and discard the late answer", func() {
+			before := testutil.ToFloat64(queryTimeouts.WithLabelValues(timeoutFallthrough))
+
+			executeTestCase(lh, rec, test.Case{
+				Qname: qname,
+				Qtype: dns.TypeA,
+				Rcode: dns.RcodeBadCookie,
+			})
+			Expect(atomic.LoadInt32(&nextCalled)).To(Equal(int32(1)))
+			Expect(testutil.ToFloat64(queryTimeouts.WithLabelValues(timeoutFallthrough))).To(Equal(before + 1))
+
+			Consistently(func() *dns.Msg {
+				return rec.Msg
+			}, 500*time.Millisecond).Should(BeNil())
+		})
+
+		It("should not share the query with the late answer", func() {
+			lh.Next = plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+				atomic.AddInt32(&nextCalled, 1)
+				r.Question[0].Name = "other." + r.Question[0].Name
+				r.Id++
+
+				return dns.RcodeBadCookie, errors.New("dummy plugin")
+			})
+
+			executeTestCase(lh, rec, test.Case{
+				Qname: qname,
+				Qtype: dns.TypeA,
+				Rcode: dns.RcodeBadCookie,
+			})
+			Expect(atomic.LoadInt32(&nextCalled)).To(Equal(int32(1)))
+
+			Consistently(func() *dns.Msg {
+				return rec.Msg
+			}, 500*time.Millisecond).Should(BeNil())
+		})
+
+		When("configured to fail the query", func() {
+			It("should return RcodeServerFailure", func() {
+				lh.queryTimeoutAction = timeoutServfail
+
+				code, err := lh.ServeDNS(context.TODO(), rec, (&test.Case{Qname: qname, Qtype: dns.TypeA}).Msg())
+				Expect(err).To(Succeed())
+				Expect(code).To(Equal(dns.RcodeServerFailure))
+				Expect(atomic.LoadInt32(&nextCalled)).To(BeZero())
+
+				Consistently(func() *dns.Msg {
+					return rec.Msg
+				}, 500*time.Millisecond).Should(BeNil())
+			})
+		})
+	})
+
+	When("the next plugin takes longer than the timeout", func() {
+		It("should not invoke the next plugin a second time", func() {
+			lh.Fall = fall.Root
+			lh.Next = plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+				atomic.AddInt32(&nextCalled, 1)
+				time.Sleep(150 * time.Millisecond)
+
+				return dns.RcodeBadCookie, errors.New("dummy plugin")
+			})
+
+			executeTestCase(lh, rec, test.Case{
+				Qname: service1 + "." + namespace1 + ".pod.clusterset.local.",
+				Qtype: dns.TypeA,
+				Rcode: dns.RcodeBadCookie,
+			})
+			Expect(atomic.LoadInt32(&nextCalled)).To(Equal(int32(1)))
+		})
+	})
+}
+
+func testGlobalNames() {
+	const globalName = "registry"
+
+	var (
+		rec *dnstest.Recorder
+		lh  *Lighthouse
+	)
+
+	BeforeEach(func() {
+		mockCs := NewMockClusterStatus()
+		mockCs.clusterStatusMap[clusterID] = true
+		mockEs := NewMockEndpointStatus()
+		mockEs.endpointStatusMap[clusterID] = true
+		lh = &Lighthouse{
+			Zones:           []string{"clusterset.local."},
+			serviceImports:  serviceimport.NewMap(),
+			endpointSlices:  setupEndpointSliceMap(),
+			clusterStatus:   mockCs,
+			endpointsStatus: mockEs,
+			localServices:   NewMockLocalServices(),
+			ttl:             defaultTtl,
+			globalNames:     true,
+		}
+
+		lh.serviceImports.SetGlobalNameAllowlist(namespace1)
+
+		si := newServiceImport(namespace1, service1, clusterID, serviceIP, mcsv1a1.ClusterSetIP)
+		si.Annotations[lhconstants.AnnotationGlobalName] = globalName
+		lh.serviceImports.Put(si)
+
+		rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	})
+
+	When("a known global name is queried", func() {
+		It("should answer with the service's IP", func() {
+			qname := globalName + ".global.clusterset.local."
+			executeTestCase(lh, rec, test.Case{
+				Qname:  qname,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{test.A(qname + "    5    IN    A    " + serviceIP)},
+			})
+		})
+	})
+
+	When("an unknown global name is queried", func() {
+		It("should return RcodeNameError", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname: "unknown.global.clusterset.local.",
+				Qtype: dns.TypeA,
+				Rcode: dns.RcodeNameError,
+			})
+		})
+	})
+
+	When("a service with the global name is exported from a namespace named global", func() {
+		BeforeEach(func() {
+			lh.serviceImports.Put(newServiceImport("global", globalName, clusterID, serviceIP2, mcsv1a1.ClusterSetIP))
+		})
+
+		It("should answer each name with its own service", func() {
+			qname := globalName + ".global.clusterset.local."
+			executeTestCase(lh, rec, test.Case{
+				Qname:  qname,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{test.A(qname + "    5    IN    A    " + serviceIP)},
+			})
+
+			qname = globalName + ".global.svc.clusterset.local."
+			rec = dnstest.NewRecorder(&test.ResponseWriter{})
+			executeTestCase(lh, rec, test.Case{
+				Qname:  qname,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{test.A(qname + "    5    IN    A    " + serviceIP2)},
+			})
+		})
+	})
+
+	When("a service outside the allowlist claims the global name first", func() {
+		BeforeEach(func() {
+			putExport := func(namespace, serviceIP string, age time.Duration) {
+				si := newServiceImport(namespace, service1, clusterID, serviceIP, mcsv1a1.ClusterSetIP)
+				si.Annotations[lhconstants.AnnotationGlobalName] = globalName
+				si.Annotations[lhconstants.AnnotationExportTime] = time.Now().Add(-age).UTC().Format(time.RFC3339)
+				lh.serviceImports.Put(si)
+			}
+
+			putExport(namespace1, serviceIP, 0)
+			putExport(namespace2, serviceIP2, time.Hour)
+		})
+
+		It("should answer with the allowed service's IP", func() {
+			qname := globalName + ".global.clusterset.local."
+			executeTestCase(lh, rec, test.Case{
+				Qname:  qname,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{test.A(qname + "    5    IN    A    " + serviceIP)},
+			})
+		})
+	})
+
+	When("global names are not enabled", func() {
+		BeforeEach(func() {
+			lh.globalNames = false
+		})
+
+		It("should return RcodeNameError", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname: globalName + ".global.clusterset.local.",
+				Qtype: dns.TypeA,
+				Rcode: dns.RcodeNameError,
+			})
+		})
+	})
+}
+
+func testPartialResults() {
+	var (
+		rec *dnstest.Recorder
+		lh  *Lighthouse
+	)
+
+	qname := service1 + "." + namespace1 + ".svc.clusterset.local."
+
+	BeforeEach(func() {
+		mockCs := NewMockClusterStatus()
+		mockCs.clusterStatusMap[clusterID] = true
+		mockCs.clusterStatusMap[clusterID2] = true
+
+		lh = &Lighthouse{
+			Zones:           []string{"clusterset.local."},
+			serviceImports:  serviceimport.NewMap(),
+			endpointSlices:  endpointslice.NewMap(),
+			clusterStatus:   mockCs,
+			endpointsStatus: NewMockEndpointStatus(),
+			localServices:   NewMockLocalServices(),
+			ttl:             defaultTtl,
+		}
+
+		lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID, "", mcsv1a1.Headless))
+		lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID2, "", mcsv1a1.Headless))
+		lh.endpointSlices.Put(newEndpointSlice(namespace1, service1, clusterID, []string{endpointIP}))
+
+		rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	})
+
+	When("one cluster's EndpointSlice is malformed", func() {
+		BeforeEach(func() {
+			lh.endpointSlices.Put(newEndpointSlice(namespace1, service1, clusterID2, []string{"not-an-ip"}))
+		})
+
+		It("should answer with the endpoints of the other cluster", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname:  qname,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{test.A(qname + "    5    IN    A    " + endpointIP)},
+			})
+		})
+
+		It("should flag the cluster as skipped in the snapshot", func() {
+			service := lh.Snapshot().Services[0]
+			Expect(service.Conditions).To(Equal([]string{ConditionEndpointSlicesSkipped}))
+			Expect(service.Clusters).To(HaveLen(2))
+
+			for _, cluster := range service.Clusters {
+				Expect(cluster.Skipped).To(Equal(cluster.ClusterID == clusterID2))
+			}
+		})
+
+		It("should clear the service's condition once the EndpointSlice is valid", func() {
+			lh.endpointSlices.Put(newEndpointSlice(namespace1, service1, clusterID2, []string{endpointIP2}))
+
+			Expect(lh.Snapshot().Services[0].Conditions).To(BeEmpty())
+		})
+
+		It("should flag the cluster as skipped in the debug TXT records", func() {
+			lh.debugTXT = true
+
+			_, err := lh.ServeDNS(context.TODO(), rec, (&test.Case{Qname: debugLabel + "." + qname, Qtype: dns.TypeTXT}).Msg())
+			Expect(err).To(Succeed())
+
+			txts := []string{}
+			for _, rr := range rec.Msg.Answer {
+				txts = append(txts, strings.Join(rr.(*dns.TXT).Txt, ""))
+			}
+
+			Expect(txts).To(ContainElement(HavePrefix("cluster=" + clusterID2 + " ")))
+
+			for _, txt := range txts {
+				Expect(strings.HasSuffix(txt, "skipped=true")).To(Equal(strings.HasPrefix(txt, "cluster="+clusterID2+" ")))
+			}
+		})
+	})
+
+	When("one cluster's EndpointSlice has IPv6 addresses", func() {
+		BeforeEach(func() {
+			es := newEndpointSlice(namespace1, service1, clusterID2, []string{"2001:db8::1"})
+			es.AddressType = discovery.AddressTypeIPv6
+			lh.endpointSlices.Put(es)
+		})
+
+		It("should answer with the IPv4 endpoints only", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname:  qname,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{test.A(qname + "    5    IN    A    " + endpointIP)},
+			})
+		})
+	})
+}
+
+func testAffinityQuery() {
+	var (
+		lh     *Lighthouse
+		mockEs *MockEndpointStatus
+	)
+
+	clients := make([]string, 20)
+	for i := range clients {
+		clients[i] = fmt.Sprintf("10.240.0.%d", i+1)
+	}
+
+	qname := service1 + "." + namespace1 + ".svc.clusterset.local."
+
+	query := func(client string) []string {
+		rec := dnstest.NewRecorder(&test.ResponseWriter{RemoteIP: client})
+		code, err := lh.ServeDNS(context.TODO(), rec, (&test.Case{Qname: qname, Qtype: dns.TypeA}).Msg())
+		Expect(err).To(Succeed())
+		Expect(code).To(Equal(dns.RcodeSuccess))
+
+		ips := []string{}
+		for _, rr := range rec.Msg.Answer {
+			ips = append(ips, rr.(*dns.A).A.String())
+		}
+
+		return ips
+	}
+
+	newAffinityServiceImport := func(clusterID, serviceIP string, siType mcsv1a1.ServiceImportType) *mcsv1a1.ServiceImport {
+		si := newServiceImport(namespace1, service1, clusterID, serviceIP, siType)
+		si.Spec.SessionAffinity = corev1.ServiceAffinityClientIP
+
+		return si
+	}
+
+	BeforeEach(func() {
+		mockCs := NewMockClusterStatus()
+		mockCs.clusterStatusMap[clusterID] = true
+		mockCs.clusterStatusMap[clusterID2] = true
+		mockEs = NewMockEndpointStatus()
+		mockEs.endpointStatusMap[clusterID] = true
+		mockEs.endpointStatusMap[clusterID2] = true
+		lh = &Lighthouse{
+			Zones:           []string{"clusterset.local."},
+			serviceImports:  serviceimport.NewMap(),
+			endpointSlices:  endpointslice.NewMap(),
+			clusterStatus:   mockCs,
+			endpointsStatus: mockEs,
+			localServices:   NewMockLocalServices(),
+			ttl:             defaultTtl,
+			sticky:          true,
+			breaker:         circuitbreaker.New(1, time.Hour),
+		}
+	})
+
+	When("a ClusterIP service with ClientIP session affinity is exported by two clusters", func() {
+		BeforeEach(func() {
+			lh.serviceImports.Put(newAffinityServiceImport(clusterID, serviceIP, mcsv1a1.ClusterSetIP))
+			lh.serviceImports.Put(newAffinityServiceImport(clusterID2, serviceIP2, mcsv1a1.ClusterSetIP))
+		})
+
+		It("should return the endpoint and cluster ServeDNS answers each client with", func() {
+			clusters := map[string]string{serviceIP: clusterID, serviceIP2: clusterID2}
+
+			for _, client := range clients {
+				answer, err := lh.Affinity(client, namespace1, service1)
+				Expect(err).To(Succeed())
+
+				ips := query(client)
+				Expect(answer.Answer).To(Equal(ips))
+				Expect(answer.Endpoint).To(Equal(ips[0]))
+				Expect(answer.Cluster).To(Equal(clusters[ips[0]]))
+				Expect(answer.Rcode).To(Equal(dns.RcodeToString[dns.RcodeSuccess]))
+			}
+		})
+
+		When("a cluster's endpoints are unhealthy", func() {
+			BeforeEach(func() {
+				mockEs.endpointStatusMap[clusterID2] = false
+			})
+
+			It("should not record the query in the circuit breaker nor the metrics", func() {
+				label := namespace1 + "/" + service1
+				firstAnswers := testutil.ToFloat64(clusterFirstAnswers.WithLabelValues(label, clusterID))
+				zoneCount := testutil.ToFloat64(zoneQueries.WithLabelValues("clusterset.local.", "false"))
+
+				for _, client := range clients {
+					answer, err := lh.Affinity(client, namespace1, service1)
+					Expect(err).To(Succeed())
+					Expect(answer.Endpoint).To(Equal(serviceIP))
+				}
+
+				Expect(lh.breaker.GetState(label, clusterID2)).To(Equal(circuitbreaker.Closed))
+				Expect(testutil.ToFloat64(clusterFirstAnswers.WithLabelValues(label, clusterID))).To(Equal(firstAnswers))
+				Expect(testutil.ToFloat64(zoneQueries.WithLabelValues("clusterset.local.", "false"))).To(Equal(zoneCount))
+			})
+		})
+
+		It("should serve the answer on the debug endpoint", func() {
+			recorder := httptest.NewRecorder()
+			lh.snapshotHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet,
+				debugAffinityPath+"?client="+clients[0]+"&service="+namespace1+"/"+service1, nil))
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Header().Get("Content-Type")).To(Equal("application/json"))
+
+			answer := &AffinityAnswer{}
+			Expect(json.Unmarshal(recorder.Body.Bytes(), answer)).To(Succeed())
+			Expect(answer.Client).To(Equal(clients[0]))
+			Expect(answer.Endpoint).To(Equal(query(clients[0])[0]))
+		})
+
+		It("should match the service of the debug endpoint case-insensitively", func() {
+			recorder := httptest.NewRecorder()
+			lh.snapshotHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet,
+				debugAffinityPath+"?client="+clients[0]+"&service="+strings.ToUpper(namespace1+"/"+service1), nil))
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+
+			answer := &AffinityAnswer{}
+			Expect(json.Unmarshal(recorder.Body.Bytes(), answer)).To(Succeed())
+			Expect(answer.Endpoint).To(Equal(query(clients[0])[0]))
+		})
+
+		It("should reject a request without a valid service", func() {
+			recorder := httptest.NewRecorder()
+			lh.snapshotHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet,
+				debugAffinityPath+"?client="+clients[0]+"&service="+service1, nil))
+			Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("should fail for an invalid client IP", func() {
+			_, err := lh.Affinity("not-an-ip", namespace1, service1)
+			Expect(err).To(HaveOccurred())
+		})
+
+		When("stickiness is not enabled", func() {
+			BeforeEach(func() {
+				lh.sticky = false
+			})
+
+			It("should fail", func() {
+				_, err := lh.Affinity(clients[0], namespace1, service1)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+
+	When("a headless service with ClientIP session affinity has multiple endpoints", func() {
+		BeforeEach(func() {
+			lh.serviceImports.Put(newAffinityServiceImport(clusterID, "", mcsv1a1.Headless))
+			lh.endpointSlices.Put(newEndpointSlice(namespace1, service1, clusterID, []string{endpointIP, endpointIP2}))
+		})
+
+		It("should return the answer ServeDNS returns each client", func() {
+			for _, client := range clients {
+				answer, err := lh.Affinity(client, namespace1, service1)
+				Expect(err).To(Succeed())
+
+				ips := query(client)
+				Expect(answer.Answer).To(Equal(ips))
+				Expect(answer.Endpoint).To(Equal(ips[0]))
+				Expect(answer.Cluster).To(Equal(clusterID))
+			}
+		})
+	})
+
+	When("a service doesn't have ClientIP session affinity", func() {
+		BeforeEach(func() {
+			lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID, serviceIP, mcsv1a1.ClusterSetIP))
+		})
+
+		It("should fail", func() {
+			_, err := lh.Affinity(clients[0], namespace1, service1)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+}
+
+func testSingleton() {
+	var (
+		lh     *Lighthouse
+		mockCs *MockClusterStatus
+	)
+
+	qname := service1 + "." + namespace1 + ".svc.clusterset.local."
+
+	query := func() []string {
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		code, err := lh.ServeDNS(context.TODO(), rec, (&test.Case{Qname: qname, Qtype: dns.TypeA}).Msg())
+		Expect(err).To(Succeed())
+		Expect(code).To(Equal(dns.RcodeSuccess))
+
+		ips := []string{}
+		for _, rr := range rec.Msg.Answer {
+			ips = append(ips, rr.(*dns.A).A.String())
+		}
+
+		return ips
+	}
+
+	newSingletonServiceImport := func(clusterID, serviceIP string, siType mcsv1a1.ServiceImportType) *mcsv1a1.ServiceImport {
+		si := newServiceImport(namespace1, service1, clusterID, serviceIP, siType)
+		si.Annotations[lhconstants.AnnotationSingleton] = "true"
+
+		return si
+	}
+
+	BeforeEach(func() {
+		mockCs = NewMockClusterStatus()
+		mockCs.clusterStatusMap[clusterID] = true
+		mockCs.clusterStatusMap[clusterID2] = true
+		mockCs.localClusterID = clusterID2
+		mockEs := NewMockEndpointStatus()
+		mockEs.endpointStatusMap[clusterID] = true
+		mockEs.endpointStatusMap[clusterID2] = true
+		mockLs := NewMockLocalServices()
+		mockLs.LocalServicesMap[getKey(service1, namespace1)] = serviceIP2
+		lh = &Lighthouse{
+			Zones:           []string{"clusterset.local."},
+			serviceImports:  serviceimport.NewMap(),
+			endpointSlices:  endpointslice.NewMap(),
+			clusterStatus:   mockCs,
+			endpointsStatus: mockEs,
+			localServices:   mockLs,
+			ttl:             defaultTtl,
+			maxClusters:     1,
+		}
+	})
+
+	When("a singleton ClusterIP service is exported by two clusters", func() {
+		BeforeEach(func() {
+			lh.serviceImports.Put(newSingletonServiceImport(clusterID, serviceIP, mcsv1a1.ClusterSetIP))
+			lh.serviceImports.Put(newSingletonServiceImport(clusterID2, serviceIP2, mcsv1a1.ClusterSetIP))
+		})
+
+		It("should consistently return the primary cluster's IP rather than the local cluster's", func() {
+			for i := 0; i < 5; i++ {
+				Expect(query()).To(Equal([]string{serviceIP}))
+			}
+		})
+
+		When("the primary cluster is subsequently disconnected", func() {
+			It("should fail over to the other cluster", func() {
+				mockCs.clusterStatusMap[clusterID] = false
+				Expect(query()).To(Equal([]string{serviceIP2}))
+
+				mockCs.clusterStatusMap[clusterID] = true
+				Expect(query()).To(Equal([]string{serviceIP}))
+			})
+		})
+	})
+
+	When("a singleton headless service has endpoints in two clusters", func() {
+		BeforeEach(func() {
+			lh.serviceImports.Put(newSingletonServiceImport(clusterID, "", mcsv1a1.Headless))
+			lh.serviceImports.Put(newSingletonServiceImport(clusterID2, "", mcsv1a1.Headless))
+			lh.endpointSlices.Put(newEndpointSlice(namespace1, service1, clusterID, []string{endpointIP2}))
+			lh.endpointSlices.Put(newEndpointSlice(namespace1, service1, clusterID2, []string{endpointIP}))
+		})
+
+		It("should consistently return only the primary endpoint", func() {
+			for i := 0; i < 5; i++ {
+				Expect(query()).To(Equal([]string{endpointIP}))
+			}
+		})
+
+		When("the primary endpoint subsequently goes away", func() {
+			It("should fail over to the next endpoint", func() {
+				lh.endpointSlices.Put(newEndpointSlice(namespace1, service1, clusterID2, []string{}))
+				Expect(query()).To(Equal([]string{endpointIP2}))
+			})
+		})
+	})
+}
+
+func testClustersetIPCNAME() {
+	const (
+		clustersetIP = "243.0.0.1"
+		alias        = "alias1"
+	)
+
+	var (
+		rec *dnstest.Recorder
+		lh  *Lighthouse
+	)
+
+	BeforeEach(func() {
+		mockCs := NewMockClusterStatus()
+		mockCs.clusterStatusMap[clusterID] = true
+		mockEs := NewMockEndpointStatus()
+		mockEs.endpointStatusMap[clusterID] = true
+		lh = &Lighthouse{
+			Zones:                []string{"clusterset.local."},
+			serviceImports:       setupServiceImportMap(),
+			endpointSlices:       setupEndpointSliceMap(),
+			clusterStatus:        mockCs,
+			endpointsStatus:      mockEs,
+			localServices:        NewMockLocalServices(),
+			ttl:                  30,
+			clustersetIPCNAME:    true,
+			clustersetIPCNAMETTL: 300,
+			aliases:              map[string]string{namespace2 + "/" + alias: namespace2 + "/" + service1},
+		}
+
+		lh.serviceImports.Put(newServiceImportWithClustersetIPs(namespace2, service1, clusterID, serviceIP, clustersetIP))
+
+		rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	})
+
+	canonical := service1 + "." + namespace2 + ".svc.clusterset.local."
+	vipName := "_vip." + canonical
+
+	When("a service with a clusterset IP is queried", func() {
+		It("should return a CNAME to the VIP's name and the VIP's A record", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname: canonical,
+				Qtype: dns.TypeA,
+				Rcode: dns.RcodeSuccess,
+				// The answer is sorted by name before it's checked.
+				Answer: []dns.RR{
+					test.A(vipName + "    30    IN    A    " + clustersetIP),
+					test.CNAME(canonical + "    300    IN    CNAME    " + vipName),
+				},
+			})
+		})
+	})
+
+	When("the VIP's name is queried", func() {
+		It("should only return the VIP's A record", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname:  vipName,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{test.A(vipName + "    30    IN    A    " + clustersetIP)},
+			})
+		})
+	})
+
+	When("a service with IPv4 and IPv6 clusterset IPs is queried for AAAA", func() {
+		BeforeEach(func() {
+			lh.serviceImports.Put(newServiceImportWithClustersetIPs(namespace2, service1, clusterID, serviceIP, clustersetIP,
+				"fd00:243::1"))
+		})
+
+		It("should return a CNAME to the VIP's name and the VIP's AAAA record", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname: canonical,
+				Qtype: dns.TypeAAAA,
+				Rcode: dns.RcodeSuccess,
+				Answer: []dns.RR{
+					test.AAAA(vipName + "    30    IN    AAAA    fd00:243::1"),
+					test.CNAME(canonical + "    300    IN    CNAME    " + vipName),
+				},
+			})
+		})
+
+		It("should only return the VIP's AAAA record for the VIP's name", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname:  vipName,
+				Qtype:  dns.TypeAAAA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{test.AAAA(vipName + "    30    IN    AAAA    fd00:243::1")},
+			})
+		})
+	})
+
+	When("a service with only an IPv4 clusterset IP is queried for AAAA", func() {
+		It("should return RcodeNameError for its VIP's name", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname: vipName,
+				Qtype: dns.TypeAAAA,
+				Rcode: dns.RcodeNameError,
+			})
+		})
+	})
+
+	When("an alias is queried in CNAME mode", func() {
+		BeforeEach(func() {
+			lh.aliasCNAME = true
+		})
+
+		It("should chain the CNAMEs to the VIP's name", func() {
+			aliasName := alias + "." + namespace2 + ".svc.clusterset.local."
+
+			executeTestCase(lh, rec, test.Case{
+				Qname: aliasName,
+				Qtype: dns.TypeA,
+				Rcode: dns.RcodeSuccess,
+				Answer: []dns.RR{
+					test.A(vipName + "    30    IN    A    " + clustersetIP),
+					test.CNAME(aliasName + "    30    IN    CNAME    " + canonical),
+					test.CNAME(canonical + "    300    IN    CNAME    " + vipName),
+				},
+			})
+		})
+	})
+
+	When("a specific cluster of a service with a clusterset IP is queried", func() {
+		It("should return the cluster's IP without a CNAME", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname:  clusterID + "." + canonical,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{test.A(clusterID + "." + canonical + "    30    IN    A    " + serviceIP)},
+			})
+		})
+	})
+
+	When("a service without a clusterset IP is queried", func() {
+		It("should return its A record without a CNAME", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname: service1 + "." + namespace1 + ".svc.clusterset.local.",
+				Qtype: dns.TypeA,
+				Rcode: dns.RcodeSuccess,
+				Answer: []dns.RR{
+					test.A(service1 + "." + namespace1 + ".svc.clusterset.local.    30    IN    A    " + serviceIP),
+				},
+			})
+		})
+
+		It("should return RcodeNameError for its VIP's name", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname: "_vip." + service1 + "." + namespace1 + ".svc.clusterset.local.",
+				Qtype: dns.TypeA,
+				Rcode: dns.RcodeNameError,
+			})
+		})
+	})
+}
+
+func testInternalTrafficPolicy() {
+	var (
+		lh     *Lighthouse
+		mockEs *MockEndpointStatus
+	)
+
+	query := func() []string {
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		code, err := lh.ServeDNS(context.TODO(), rec, test.Case{
+			Qname: service1 + "." + namespace1 + ".svc.clusterset.local.",
+			Qtype: dns.TypeA,
+		}.Msg())
+		Expect(err).To(Succeed())
+		Expect(code).To(Equal(dns.RcodeSuccess))
+
+		ips := []string{}
+		for _, rr := range rec.Msg.Answer {
+			ips = append(ips, rr.(*dns.A).A.String())
+		}
+
+		sort.Strings(ips)
+
+		return ips
+	}
+
+	newLocalPolicyServiceImport := func(clusterID, serviceIP string, siType mcsv1a1.ServiceImportType) *mcsv1a1.ServiceImport {
+		si := newServiceImport(namespace1, service1, clusterID, serviceIP, siType)
+		si.Annotations[lhconstants.AnnotationInternalTrafficPolicy] = lhconstants.InternalTrafficPolicyLocal
+
+		return si
+	}
+
+	BeforeEach(func() {
+		mockCs := NewMockClusterStatus()
+		mockCs.clusterStatusMap[clusterID] = true
+		mockCs.clusterStatusMap[clusterID2] = true
+		mockCs.localClusterID = clusterID2
+		mockEs = NewMockEndpointStatus()
+		mockEs.endpointStatusMap[clusterID] = true
+		mockEs.endpointStatusMap[clusterID2] = true
+		mockLs := NewMockLocalServices()
+		mockLs.LocalServicesMap[getKey(service1, namespace1)] = serviceIP2
+		lh = &Lighthouse{
+			Zones:                 []string{"clusterset.local."},
+			serviceImports:        serviceimport.NewMap(),
+			endpointSlices:        endpointslice.NewMap(),
+			clusterStatus:         mockCs,
+			endpointsStatus:       mockEs,
+			localServices:         mockLs,
+			ttl:                   defaultTtl,
+			internalTrafficPolicy: true,
+		}
+	})
+
+	When("the local cluster exports a ClusterIP service with a Local internalTrafficPolicy", func() {
+		BeforeEach(func() {
+			lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID, serviceIP, mcsv1a1.ClusterSetIP))
+			lh.serviceImports.Put(newLocalPolicyServiceImport(clusterID2, serviceIP2, mcsv1a1.ClusterSetIP))
+			mockEs.endpointStatusMap[clusterID2] = false
+		})
+
+		It("should only return the local cluster's IP", func() {
+			Expect(query()).To(Equal([]string{serviceIP2}))
+		})
+
+		When("the policy isn't honored", func() {
+			It("should return the other cluster's IP", func() {
+				lh.internalTrafficPolicy = false
+				Expect(query()).To(Equal([]string{serviceIP}))
+			})
+		})
+	})
+
+	When("only a remote cluster exports a ClusterIP service with a Local internalTrafficPolicy", func() {
+		BeforeEach(func() {
+			lh.serviceImports.Put(newLocalPolicyServiceImport(clusterID, serviceIP, mcsv1a1.ClusterSetIP))
+			lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID2, serviceIP2, mcsv1a1.ClusterSetIP))
+			mockEs.endpointStatusMap[clusterID2] = false
+		})
+
+		It("should return the other clusters as usual", func() {
+			Expect(query()).To(Equal([]string{serviceIP}))
+		})
+	})
+
+	When("the local cluster exports a headless service with a Local internalTrafficPolicy", func() {
+		BeforeEach(func() {
+			lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID, "", mcsv1a1.Headless))
+			lh.serviceImports.Put(newLocalPolicyServiceImport(clusterID2, "", mcsv1a1.Headless))
+			lh.endpointSlices.Put(newEndpointSlice(namespace1, service1, clusterID, []string{endpointIP}))
+			lh.endpointSlices.Put(newEndpointSlice(namespace1, service1, clusterID2, []string{endpointIP2}))
+		})
+
+		It("should only return the local cluster's endpoints", func() {
+			Expect(query()).To(Equal([]string{endpointIP2}))
+		})
+	})
+}
+
+func testUnavailableServices() {
+	const fallbackIP = "192.0.2.10"
+
+	var (
+		rec    *dnstest.Recorder
+		lh     *Lighthouse
+		mockCs *MockClusterStatus
+		counts map[string]float64
+	)
+
+	qname := service1 + "." + namespace1 + ".svc.clusterset.local."
+
+	BeforeEach(func() {
+		mockCs = NewMockClusterStatus()
+		mockCs.clusterStatusMap[clusterID] = true
+		mockEs := NewMockEndpointStatus()
+		mockEs.endpointStatusMap[clusterID] = true
+		lh = &Lighthouse{
+			Zones:            []string{"clusterset.local."},
+			serviceImports:   setupServiceImportMap(),
+			endpointSlices:   setupEndpointSliceMap(),
+			clusterStatus:    mockCs,
+			endpointsStatus:  mockEs,
+			localServices:    NewMockLocalServices(),
+			ttl:              defaultTtl,
+			serviceFallbacks: map[string]string{},
+		}
+
+		counts = map[string]float64{}
+		for _, state := range []string{serviceNotExported, serviceUnavailable, serviceAvailable} {
+			counts[state] = testutil.ToFloat64(serviceQueries.WithLabelValues(state))
+		}
+
+		rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	})
+
+	expectCounted := func(counted string) {
+		for state, count := range counts {
+			if state == counted {
+				count++
+			}
+
+			Expect(testutil.ToFloat64(serviceQueries.WithLabelValues(state))).To(Equal(count), "state %q", state)
+		}
+	}
+
+	serveDNS := func() int {
+		code, err := lh.ServeDNS(context.TODO(), rec, (&test.Case{Qname: qname, Qtype: dns.TypeA}).Msg())
+		Expect(err).To(Succeed())
+
+		return code
+	}
+
+	When("a service that was never exported is queried", func() {
+		It("should return RcodeNameError and count it as not exported", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname: "unknown." + namespace1 + ".svc.clusterset.local.",
+				Qtype: dns.TypeA,
+				Rcode: dns.RcodeNameError,
+			})
+			expectCounted(serviceNotExported)
+		})
+
+		It("should return RcodeNameError even if unavailable services get SERVFAIL", func() {
+			lh.unavailableRcode = dns.RcodeServerFailure
+			executeTestCase(lh, rec, test.Case{
+				Qname: "unknown." + namespace1 + ".svc.clusterset.local.",
+				Qtype: dns.TypeA,
+				Rcode: dns.RcodeNameError,
+			})
+			expectCounted(serviceNotExported)
+		})
+	})
+
+	When("an exported service with an available cluster is queried", func() {
+		It("should succeed and count it as available", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname:  qname,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{test.A(qname + "    5    IN    A    " + serviceIP)},
+			})
+			expectCounted(serviceAvailable)
+		})
+	})
+
+	When("an exported service whose clusters are all disconnected is queried", func() {
+		BeforeEach(func() {
+			mockCs.clusterStatusMap[clusterID] = false
+		})
+
+		It("should return an empty response by default and count it as unavailable", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname:  qname,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{},
+			})
+			expectCounted(serviceUnavailable)
+		})
+
+		When("unavailable services are configured to get SERVFAIL", func() {
+			BeforeEach(func() {
+				lh.unavailableRcode = dns.RcodeServerFailure
+			})
+
+			It("should return RcodeServerFailure and count it as unavailable", func() {
+				Expect(serveDNS()).To(Equal(dns.RcodeServerFailure))
+				Expect(rec.Msg).To(BeNil())
+				expectCounted(serviceUnavailable)
+			})
+
+			It("should still return the fallback if one is configured", func() {
+				lh.fallback = fallbackIP
+				executeTestCase(lh, rec, test.Case{
+					Qname:  qname,
+					Qtype:  dns.TypeA,
+					Rcode:  dns.RcodeSuccess,
+					Answer: []dns.RR{test.A(qname + "    5    IN    A    " + fallbackIP)},
+				})
+				expectCounted(serviceUnavailable)
+			})
+		})
+	})
+
+	When("an exported service has fewer than its minimum number of clusters available", func() {
+		BeforeEach(func() {
+			si := newServiceImport(namespace1, service1, clusterID, serviceIP, mcsv1a1.ClusterSetIP)
+			si.Annotations[lhconstants.AnnotationMinClusters] = "2"
+			lh.serviceImports.Put(si)
+		})
+
+		It("should return an empty response by default and count it as unavailable", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname:  qname,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{},
+			})
+			expectCounted(serviceUnavailable)
+		})
+
+		It("should not pass the query to the next plugin with fallthrough", func() {
+			lh.Fall = fall.Root
+			lh.Next = test.NextHandler(dns.RcodeBadCookie, errors.New("dummy plugin"))
+			executeTestCase(lh, rec, test.Case{
+				Qname:  qname,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{},
+			})
+			expectCounted(serviceUnavailable)
+		})
+
+		It("should return RcodeServerFailure if unavailable services are configured to get SERVFAIL", func() {
+			lh.unavailableRcode = dns.RcodeServerFailure
+			Expect(serveDNS()).To(Equal(dns.RcodeServerFailure))
+			expectCounted(serviceUnavailable)
+		})
+	})
+}
+
+func testNamespacePolicies() {
+	var (
+		rec    *dnstest.Recorder
+		lh     *Lighthouse
+		mockCs *MockClusterStatus
+		mockEs *MockEndpointStatus
+	)
+
+	const namespace3 = "namespace3"
+
+	qname := func(namespace string) string {
+		return service1 + "." + namespace + ".svc.clusterset.local."
+	}
+
+	expectAnswer := func(namespace, ip string) {
+		executeTestCase(lh, rec, test.Case{
+			Qname:  qname(namespace),
+			Qtype:  dns.TypeA,
+			Rcode:  dns.RcodeSuccess,
+			Answer: []dns.RR{test.A(qname(namespace) + "    5    IN    A    " + ip)},
+		})
+	}
+
+	BeforeEach(func() {
+		mockCs = NewMockClusterStatus()
+		mockCs.clusterStatusMap[clusterID] = true
+		mockCs.clusterStatusMap[clusterID2] = true
+		mockCs.localClusterID = clusterID
+		mockEs = NewMockEndpointStatus()
+		mockEs.endpointStatusMap[clusterID] = true
+		mockEs.endpointStatusMap[clusterID2] = true
+		mockLs := NewMockLocalServices()
+		lh = &Lighthouse{
+			Zones:            []string{"clusterset.local."},
+			serviceImports:   serviceimport.NewMap(),
+			endpointSlices:   setupEndpointSliceMap(),
+			clusterStatus:    mockCs,
+			endpointsStatus:  mockEs,
+			localServices:    mockLs,
+			ttl:              defaultTtl,
+			serviceFallbacks: map[string]string{},
+			namespacePolicies: map[string]namespacePolicy{
+				namespace2: {readinessGated: false, minClusters: 2, preferLocal: false},
+			},
+		}
+
+		// The same service is exported by the same clusters in each namespace.
+		for _, namespace := range []string{namespace1, namespace2, namespace3} {
+			lh.serviceImports.Put(newServiceImport(namespace, service1, clusterID, serviceIP, mcsv1a1.ClusterSetIP))
+			lh.serviceImports.Put(newServiceImport(namespace, service1, clusterID2, serviceIP2, mcsv1a1.ClusterSetIP))
+			mockLs.LocalServicesMap[getKey(service1, namespace)] = serviceIP
+		}
+
+		rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	})
+
+	When("the local endpoints are unhealthy", func() {
+		BeforeEach(func() {
+			mockEs.endpointStatusMap[clusterID] = false
+		})
+
+		It("should still return the local cluster only in a namespace without readiness gating", func() {
+			expectAnswer(namespace1, serviceIP2)
+			expectAnswer(namespace2, serviceIP)
+		})
+	})
+
+	When("both clusters are available", func() {
+		It("should only alternate between the clusters in a namespace that doesn't prefer the local cluster", func() {
+			expectAnswer(namespace1, serviceIP)
+			expectAnswer(namespace1, serviceIP)
+
+			answers := map[string]bool{}
+
+			for i := 0; i < 2; i++ {
+				code, err := lh.ServeDNS(context.TODO(), rec, (&test.Case{Qname: qname(namespace2), Qtype: dns.TypeA}).Msg())
+				Expect(err).To(Succeed())
+				Expect(code).To(Equal(dns.RcodeSuccess))
+				Expect(rec.Msg.Answer).To(HaveLen(1))
+				answers[rec.Msg.Answer[0].(*dns.A).A.String()] = true
+			}
+
+			Expect(answers).To(Equal(map[string]bool{serviceIP: true, serviceIP2: true}))
+		})
+	})
+
+	When("the remote cluster is disconnected", func() {
+		BeforeEach(func() {
+			mockCs.clusterStatusMap[clusterID2] = false
+		})
+
+		It("should answer the queries in a namespace requiring two clusters with an empty response", func() {
+			expectAnswer(namespace1, serviceIP)
+			executeTestCase(lh, rec, test.Case{
+				Qname:  qname(namespace2),
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{},
+			})
+		})
+	})
+
+	When("a default policy is configured", func() {
+		BeforeEach(func() {
+			lh.namespacePolicies[defaultPolicyNamespace] = namespacePolicy{readinessGated: false, minClusters: 1, preferLocal: true}
+			mockEs.endpointStatusMap[clusterID] = false
+		})
+
+		It("should apply it to the namespaces that aren't listed", func() {
+			expectAnswer(namespace1, serviceIP)
+			expectAnswer(namespace3, serviceIP)
+		})
+	})
+}
+
+func testFrozenServices() {
+	var (
+		rec    *dnstest.Recorder
+		lh     *Lighthouse
+		mockCs *MockClusterStatus
+	)
+
+	const service2 = "service2"
+
+	qname := func(service string) string {
+		return service + "." + namespace1 + ".svc.clusterset.local."
+	}
+
+	expectAnswer := func(service string, ips ...string) {
+		answer := make([]dns.RR, len(ips))
+		for i, ip := range ips {
+			answer[i] = test.A(qname(service) + "    5    IN    A    " + ip)
+		}
+
+		executeTestCase(lh, rec, test.Case{
+			Qname:  qname(service),
+			Qtype:  dns.TypeA,
+			Rcode:  dns.RcodeSuccess,
+			Answer: answer,
+		})
+	}
+
+	freeze := func(path, service string) (int, string) {
+		w := httptest.NewRecorder()
+		lh.freezeHandler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, path+"?service="+service, nil))
+
+		return w.Code, w.Body.String()
+	}
+
+	BeforeEach(func() {
+		mockCs = NewMockClusterStatus()
+		mockCs.clusterStatusMap[clusterID] = true
+		mockCs.clusterStatusMap[clusterID2] = true
+		mockEs := NewMockEndpointStatus()
+		mockEs.endpointStatusMap[clusterID] = true
+		mockEs.endpointStatusMap[clusterID2] = true
+
+		lh = &Lighthouse{
+			Zones:           []string{"clusterset.local."},
+			serviceImports:  setupServiceImportMap(),
+			endpointSlices:  setupEndpointSliceMap(),
+			clusterStatus:   mockCs,
+			endpointsStatus: mockEs,
+			localServices:   NewMockLocalServices(),
+			ttl:             defaultTtl,
+		}
+
+		lh.serviceImports.Put(newServiceImport(namespace1, service2, clusterID, "", mcsv1a1.Headless))
+		lh.endpointSlices.Put(newEndpointSlice(namespace1, service2, clusterID, []string{endpointIP}))
+
+		rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	})
+
+	When("a ClusterIP service is frozen", func() {
+		BeforeEach(func() {
+			code, body := freeze("/freeze", namespace1+"/"+service1)
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(body).To(Equal("Frozen\n"))
+			Expect(testutil.ToFloat64(frozenServices.WithLabelValues(namespace1 + "/" + service1))).To(Equal(float64(1)))
+		})
+
+		AfterEach(func() {
+			lh.Unfreeze(namespace1, service1)
+		})
+
+		It("should keep answering with the frozen cluster despite it being disconnected and another exporting", func() {
+			lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID2, serviceIP2, mcsv1a1.ClusterSetIP))
+			mockCs.clusterStatusMap[clusterID] = false
+
+			before := testutil.ToFloat64(frozenAnswers.WithLabelValues(namespace1 + "/" + service1))
+
+			expectAnswer(service1, serviceIP)
+			expectAnswer(service1, serviceIP)
+			Expect(testutil.ToFloat64(frozenAnswers.WithLabelValues(namespace1 + "/" + service1))).To(Equal(before + 2))
+		})
+
+		It("should answer queries for a specific cluster from the current state", func() {
+			lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID2, serviceIP2, mcsv1a1.ClusterSetIP))
+
+			executeTestCase(lh, rec, test.Case{
+				Qname: clusterID2 + "." + qname(service1),
+				Qtype: dns.TypeA,
+				Rcode: dns.RcodeSuccess,
+				Answer: []dns.RR{
+					test.A(clusterID2 + "." + qname(service1) + "    5    IN    A    " + serviceIP2),
+				},
+			})
+		})
+
+		It("should be a no-op to freeze it again", func() {
+			mockCs.clusterStatusMap[clusterID] = false
+			lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID2, serviceIP2, mcsv1a1.ClusterSetIP))
+
+			code, body := freeze("/freeze", namespace1+"/"+service1)
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(body).To(Equal("Already frozen\n"))
+			expectAnswer(service1, serviceIP)
+		})
+
+		When("then unfrozen", func() {
+			It("should resume answering from the current state", func() {
+				mockCs.clusterStatusMap[clusterID] = false
+				lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID2, serviceIP2, mcsv1a1.ClusterSetIP))
+
+				code, body := freeze("/unfreeze", namespace1+"/"+service1)
+				Expect(code).To(Equal(http.StatusOK))
+				Expect(body).To(Equal("Unfrozen\n"))
+				Expect(testutil.ToFloat64(frozenServices.WithLabelValues(namespace1 + "/" + service1))).To(BeZero())
+
+				expectAnswer(service1, serviceIP2)
+			})
+		})
+	})
+
+	When("a ClusterIP service exported by several clusters is frozen", func() {
+		BeforeEach(func() {
+			lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID2, serviceIP2, mcsv1a1.ClusterSetIP))
+			Expect(lh.Freeze(namespace1, service1)).To(BeTrue())
+		})
+
+		AfterEach(func() {
+			lh.Unfreeze(namespace1, service1)
+		})
+
+		It("should keep answering round-robin with the clusters it was frozen with despite them being disconnected", func() {
+			mockCs.clusterStatusMap[clusterID] = false
+
+			expectAnswer(service1, serviceIP)
+			expectAnswer(service1, serviceIP2)
+			expectAnswer(service1, serviceIP)
+		})
+
+		It("should not advance the round-robin on dry runs", func() {
+			expectAnswer(service1, serviceIP)
+			Expect(lh.dryRunAnswer(namespace1, service1, "clusterset.local.", dns.TypeA)).To(HaveLen(1))
+			expectAnswer(service1, serviceIP2)
+		})
+	})
+
+	When("a service is frozen by the frozen annotation", func() {
+		BeforeEach(func() {
+			lh.serviceImports.OnChange(lh.updateAnnotationFrozen)
+
+			si := newServiceImport(namespace1, service1, clusterID, serviceIP, mcsv1a1.ClusterSetIP)
+			si.Annotations[lhconstants.AnnotationFrozen] = serviceIP
+			lh.serviceImports.Put(si)
+		})
+
+		It("should keep answering with the annotated IPs until the annotation is removed", func() {
+			lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID2, serviceIP2, mcsv1a1.ClusterSetIP))
+			mockCs.clusterStatusMap[clusterID] = false
+
+			expectAnswer(service1, serviceIP)
+			Expect(testutil.ToFloat64(frozenServices.WithLabelValues(namespace1 + "/" + service1))).To(Equal(float64(1)))
+			Expect(lh.Unfreeze(namespace1, service1)).To(BeFalse())
+			expectAnswer(service1, serviceIP)
+
+			lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID, serviceIP, mcsv1a1.ClusterSetIP))
+
+			expectAnswer(service1, serviceIP2)
+		})
+	})
+
+	When("a headless service is frozen", func() {
+		BeforeEach(func() {
+			Expect(lh.Freeze(namespace1, service2)).To(BeTrue())
+		})
+
+		AfterEach(func() {
+			lh.Unfreeze(namespace1, service2)
+		})
+
+		It("should keep answering with the frozen endpoints despite their changes", func() {
+			lh.endpointSlices.Put(newEndpointSlice(namespace1, service2, clusterID, []string{endpointIP2}))
+			expectAnswer(service2, endpointIP)
+		})
+
+		It("should keep answering the other services from the current state", func() {
+			lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID2, serviceIP2, mcsv1a1.ClusterSetIP))
+			mockCs.clusterStatusMap[clusterID] = false
+
+			expectAnswer(service1, serviceIP2)
+		})
+
+		It("should return the frozen endpoints once unfrozen", func() {
+			lh.endpointSlices.Put(newEndpointSlice(namespace1, service2, clusterID, []string{endpointIP2}))
+			Expect(lh.Unfreeze(namespace1, service2)).To(BeTrue())
+			expectAnswer(service2, endpointIP2)
+			Expect(lh.Unfreeze(namespace1, service2)).To(BeFalse())
+		})
+	})
+
+	When("a service with IPv4 and IPv6 clusterset IPs is frozen", func() {
+		const service3 = "service3"
+
+		BeforeEach(func() {
+			lh.serviceImports.Put(newServiceImportWithClustersetIPs(namespace1, service3, clusterID, serviceIP, "243.0.0.1",
+				"fd00:243::1"))
+			Expect(lh.Freeze(namespace1, service3)).To(BeTrue())
+		})
+
+		AfterEach(func() {
+			lh.Unfreeze(namespace1, service3)
+		})
+
+		It("should keep answering AAAA queries with the frozen IPv6 clusterset IP", func() {
+			mockCs.clusterStatusMap[clusterID] = false
+
+			expectAnswer(service3, "243.0.0.1")
+			executeTestCase(lh, rec, test.Case{
+				Qname:  qname(service3),
+				Qtype:  dns.TypeAAAA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{test.AAAA(qname(service3) + "    5    IN    AAAA    fd00:243::1")},
+			})
+		})
+	})
+
+	When("a service that isn't exported is frozen", func() {
+		It("should fail", func() {
+			code, _ := freeze("/freeze", namespace1+"/unknown")
+			Expect(code).To(Equal(http.StatusBadRequest))
+		})
+	})
+
+	When("a service without available endpoints is frozen", func() {
+		It("should fail", func() {
+			mockCs.clusterStatusMap[clusterID] = false
+
+			_, err := lh.Freeze(namespace1, service1)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	When("a service is frozen with its name in upper case", func() {
+		BeforeEach(func() {
+			code, _ := freeze("/freeze", strings.ToUpper(namespace1+"/"+service1))
+			Expect(code).To(Equal(http.StatusOK))
+		})
+
+		AfterEach(func() {
+			lh.Unfreeze(namespace1, service1)
+		})
+
+		It("should freeze the answers of the queries for the service", func() {
+			lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID2, serviceIP2, mcsv1a1.ClusterSetIP))
+			mockCs.clusterStatusMap[clusterID] = false
+
+			expectAnswer(service1, serviceIP)
+		})
+	})
+
+	When("the service isn't given as NAMESPACE/NAME", func() {
+		It("should fail", func() {
+			code, _ := freeze("/freeze", service1)
+			Expect(code).To(Equal(http.StatusBadRequest))
+		})
+	})
+
+	When("not a POST", func() {
+		It("should fail", func() {
+			w := httptest.NewRecorder()
+			lh.freezeHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/freeze?service="+namespace1+"/"+service1,
+				nil))
+			Expect(w.Code).To(Equal(http.StatusMethodNotAllowed))
+		})
+	})
+}
+
+func newServiceImportVariant(namespace, name, clusterID, serviceIP, variant string) *mcsv1a1.ServiceImport {
+	si := newServiceImport(namespace, name, clusterID, serviceIP, mcsv1a1.ClusterSetIP)
+	si.Annotations[lhconstants.AnnotationVariant] = variant
+
+	return si
+}
+
+func newServiceImportWithClustersetIPs(namespace, name, clusterID, serviceIP string, vips ...string) *mcsv1a1.ServiceImport {
+	si := newServiceImport(namespace, name, clusterID, serviceIP, mcsv1a1.ClusterSetIP)
+	si.Annotations[lhconstants.AnnotationClusterIP] = serviceIP
+	si.Spec.IPs = vips
+
+	return si
+}
+
+func testClusterSetReadiness() {
+	var (
+		lh     *Lighthouse
+		mockCs *MockClusterStatus
+	)
+
+	key := namespace1 + "/" + service1
+
+	readyMetric := func() float64 {
+		return testutil.ToFloat64(clusterSetReadyServices.WithLabelValues(key))
+	}
+
+	disconnectedMetric := func() float64 {
+		return testutil.ToFloat64(reachableClustersDisconnected.WithLabelValues(key))
+	}
+
+	BeforeEach(func() {
+		clusterSetReadyServices.DeleteLabelValues(key)
+		reachableClustersDisconnected.DeleteLabelValues(key)
+
+		mockCs = NewMockClusterStatus()
+		mockCs.clusterStatusMap[clusterID] = true
+		mockCs.clusterStatusMap[clusterID2] = true
+		mockCs.localClusterID = clusterID
+
+		epMap := endpointslice.NewMap()
+
+		lh = &Lighthouse{
+			Zones:           []string{"clusterset.local."},
+			serviceImports:  serviceimport.NewMap(),
+			endpointSlices:  epMap,
+			clusterStatus:   mockCs,
+			endpointsStatus: endpointslice.NewController(epMap),
+			localServices:   NewMockLocalServices(),
+			ttl:             defaultTtl,
+		}
+
+		lh.serviceImports.OnChange(lh.updateClusterSetReady)
+		lh.endpointSlices.OnChange(lh.updateClusterSetReady)
+	})
+
+	When("a service is exported without ready endpoints", func() {
+		It("should not be clusterset ready", func() {
+			lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID2, serviceIP2, mcsv1a1.ClusterSetIP))
+
+			Expect(lh.ClusterSetReady(namespace1, service1)).To(BeFalse())
+			Expect(lh.clusterSetReady).To(HaveKeyWithValue(key, serviceReadiness{}))
+			Expect(readyMetric()).To(BeZero())
+		})
+	})
+
+	When("endpoints come and go in the clusters exporting a service", func() {
+		BeforeEach(func() {
+			lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID, serviceIP, mcsv1a1.ClusterSetIP))
+			lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID2, serviceIP2, mcsv1a1.ClusterSetIP))
+		})
+
+		It("should be clusterset ready as long as one of them has ready endpoints", func() {
+			lh.endpointSlices.Put(newEndpointSlice(namespace1, service1, clusterID2, []string{endpointIP2}))
+			Expect(lh.ClusterSetReady(namespace1, service1)).To(BeTrue())
+			Expect(readyMetric()).To(Equal(1.0))
+
+			lh.endpointSlices.Put(newEndpointSlice(namespace1, service1, clusterID, []string{endpointIP}))
+			Expect(readyMetric()).To(Equal(1.0))
+
+			lh.endpointSlices.Remove(newEndpointSlice(namespace1, service1, clusterID2, []string{endpointIP2}))
+			Expect(readyMetric()).To(Equal(1.0))
+
+			lh.endpointSlices.Put(newEndpointSlice(namespace1, service1, clusterID, []string{}))
+			Expect(lh.ClusterSetReady(namespace1, service1)).To(BeFalse())
+			Expect(readyMetric()).To(BeZero())
+
+			lh.endpointSlices.Put(newEndpointSlice(namespace1, service1, clusterID2, []string{endpointIP2}))
+			Expect(lh.ClusterSetReady(namespace1, service1)).To(BeTrue())
+			Expect(readyMetric()).To(Equal(1.0))
+		})
+	})
+
+	When("the only cluster with ready endpoints disconnects and reconnects", func() {
+		BeforeEach(func() {
+			lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID2, serviceIP2, mcsv1a1.ClusterSetIP))
+			lh.endpointSlices.Put(newEndpointSlice(namespace1, service1, clusterID2, []string{endpointIP2}))
+		})
+
+		It("should follow the cluster's connectivity", func() {
+			Expect(readyMetric()).To(Equal(1.0))
+
+			mockCs.clusterStatusMap[clusterID2] = false
+			lh.clusterSetConnectivityChanged(clusterID2, false)
+			Expect(lh.ClusterSetReady(namespace1, service1)).To(BeFalse())
+			Expect(readyMetric()).To(BeZero())
+
+			mockCs.clusterStatusMap[clusterID2] = true
+			lh.clusterSetConnectivityChanged(clusterID2, true)
+			Expect(lh.ClusterSetReady(namespace1, service1)).To(BeTrue())
+			Expect(readyMetric()).To(Equal(1.0))
+		})
+
+		It("should set the ReachableClustersDisconnected condition while the cluster is disconnected", func() {
+			Expect(lh.ReachableClustersDisconnected(namespace1, service1)).To(BeFalse())
+			Expect(disconnectedMetric()).To(BeZero())
+			Expect(lh.Snapshot().Services[0].Conditions).To(BeEmpty())
+
+			mockCs.clusterStatusMap[clusterID2] = false
+			lh.clusterSetConnectivityChanged(clusterID2, false)
+			Expect(lh.ReachableClustersDisconnected(namespace1, service1)).To(BeTrue())
+			Expect(disconnectedMetric()).To(Equal(1.0))
+			Expect(lh.Snapshot().Services[0].Conditions).To(Equal([]string{ConditionReachableClustersDisconnected}))
+
+			mockCs.clusterStatusMap[clusterID2] = true
+			lh.clusterSetConnectivityChanged(clusterID2, true)
+			Expect(lh.ReachableClustersDisconnected(namespace1, service1)).To(BeFalse())
+			Expect(disconnectedMetric()).To(BeZero())
+			Expect(lh.Snapshot().Services[0].Conditions).To(BeEmpty())
+		})
+
+		It("should clear the ReachableClustersDisconnected condition once a connected cluster has ready endpoints", func() {
+			mockCs.clusterStatusMap[clusterID2] = false
+			lh.clusterSetConnectivityChanged(clusterID2, false)
+			Expect(disconnectedMetric()).To(Equal(1.0))
+
+			lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID, serviceIP, mcsv1a1.ClusterSetIP))
+			lh.endpointSlices.Put(newEndpointSlice(namespace1, service1, clusterID, []string{endpointIP}))
+			Expect(lh.ReachableClustersDisconnected(namespace1, service1)).To(BeFalse())
+			Expect(disconnectedMetric()).To(BeZero())
+		})
+	})
+
+	When("a service has no ready endpoints in any cluster", func() {
+		BeforeEach(func() {
+			lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID2, serviceIP2, mcsv1a1.ClusterSetIP))
+		})
+
+		It("should not set the ReachableClustersDisconnected condition when the cluster disconnects", func() {
+			mockCs.clusterStatusMap[clusterID2] = false
+			lh.clusterSetConnectivityChanged(clusterID2, false)
+			Expect(lh.ReachableClustersDisconnected(namespace1, service1)).To(BeFalse())
+			Expect(disconnectedMetric()).To(BeZero())
+		})
+	})
+
+	When("the local cluster has ready endpoints", func() {
+		BeforeEach(func() {
+			mockCs.clusterStatusMap[clusterID] = false
+			lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID, serviceIP, mcsv1a1.ClusterSetIP))
+			lh.endpointSlices.Put(newEndpointSlice(namespace1, service1, clusterID, []string{endpointIP}))
+		})
+
+		It("should be clusterset ready regardless of the Gateway connectivity", func() {
+			Expect(lh.ClusterSetReady(namespace1, service1)).To(BeTrue())
+			Expect(lh.Snapshot().Services[0].ClusterSetReady).To(BeTrue())
+		})
+	})
+
+	When("a service is no longer exported", func() {
+		BeforeEach(func() {
+			lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID2, serviceIP2, mcsv1a1.ClusterSetIP))
+			lh.endpointSlices.Put(newEndpointSlice(namespace1, service1, clusterID2, []string{endpointIP2}))
+		})
+
+		It("should stop tracking its readiness", func() {
+			Expect(lh.clusterSetReady).To(HaveKey(key))
+
+			lh.serviceImports.Remove(newServiceImport(namespace1, service1, clusterID2, serviceIP2, mcsv1a1.ClusterSetIP))
+			Expect(lh.ClusterSetReady(namespace1, service1)).To(BeFalse())
+			Expect(lh.clusterSetReady).ToNot(HaveKey(key))
+		})
+	})
+}
+
+func testEndpointDeduplication() {
+	var (
+		lh       *Lighthouse
+		mockCs   *MockClusterStatus
+		answered []Endpoint
+	)
+
+	const sharedIP = "100.96.157.200"
+
+	qname := service1 + "." + namespace1 + ".svc.clusterset.local."
+
+	query := func(qname string) []string {
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		code, err := lh.ServeDNS(context.TODO(), rec, (&test.Case{Qname: qname, Qtype: dns.TypeA}).Msg())
+		Expect(err).To(Succeed())
+		Expect(code).To(Equal(dns.RcodeSuccess))
+
+		ips := []string{}
+		for _, rr := range rec.Msg.Answer {
+			ips = append(ips, rr.(*dns.A).A.String())
+		}
+
+		return ips
+	}
+
+	BeforeEach(func() {
+		answered = nil
+		mockCs = NewMockClusterStatus()
+		mockCs.clusterStatusMap[clusterID] = true
+		mockCs.clusterStatusMap[clusterID2] = true
+
+		lh = &Lighthouse{
+			Zones:           []string{"clusterset.local."},
+			serviceImports:  serviceimport.NewMap(),
+			endpointSlices:  endpointslice.NewMap(),
+			clusterStatus:   mockCs,
+			endpointsStatus: NewMockEndpointStatus(),
+			localServices:   NewMockLocalServices(),
+			ttl:             defaultTtl,
+			answerOrderer: AnswerOrdererFunc(func(endpoints []Endpoint, _ QueryContext) []Endpoint {
+				answered = append([]Endpoint{}, endpoints...)
+				return endpoints
+			}),
+		}
+
+		lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID, "", mcsv1a1.Headless))
+		lh.serviceImports.Put(newServiceImport(namespace1, service1, clusterID2, "", mcsv1a1.Headless))
+		lh.endpointSlices.Put(newEndpointSlice(namespace1, service1, clusterID, []string{endpointIP, sharedIP}))
+		lh.endpointSlices.Put(newEndpointSlice(namespace1, service1, clusterID2, []string{endpointIP2, sharedIP}))
+	})
+
+	clusterOf := func(ip string) string {
+		for _, endpoint := range answered {
+			if endpoint.IP == ip {
+				return endpoint.Cluster
+			}
+		}
+
+		return ""
+	}
+
+	When("dedup-endpoints isn't configured", func() {
+		It("should return an IP exported by two clusters twice", func() {
+			Expect(query(qname)).To(ConsistOf(endpointIP, endpointIP2, sharedIP, sharedIP))
+		})
+	})
+
+	When("dedup-endpoints is configured", func() {
+		BeforeEach(func() {
+			lh.dedupEndpoints = true
+		})
+
+		It("should return an IP exported by two clusters once", func() {
+			Expect(query(qname)).To(ConsistOf(endpointIP, endpointIP2, sharedIP))
+			Expect(clusterOf(sharedIP)).To(Equal(clusterID))
+		})
+
+		Context("and the local cluster exports the IP", func() {
+			BeforeEach(func() {
+				mockCs.localClusterID = clusterID2
+			})
+
+			It("should attribute it to the local cluster", func() {
+				Expect(query(qname)).To(ConsistOf(endpointIP, endpointIP2, sharedIP))
+				Expect(clusterOf(sharedIP)).To(Equal(clusterID2))
+			})
+		})
+
+		Context("and one of the clusters exporting the IP is disconnected", func() {
+			BeforeEach(func() {
+				mockCs.clusterStatusMap[clusterID] = false
+			})
+
+			It("should return it once, attributed to the connected cluster", func() {
+				Expect(query(qname)).To(ConsistOf(endpointIP2, sharedIP))
+				Expect(clusterOf(sharedIP)).To(Equal(clusterID2))
+			})
+		})
+
+		Context("and a specific cluster is queried", func() {
+			It("should return that cluster's IPs", func() {
+				Expect(query(clusterID + "." + qname)).To(ConsistOf(endpointIP, sharedIP))
+			})
+		})
+	})
+}
+
+func testExportDelay() {
+	var (
+		lh  *Lighthouse
+		rec *dnstest.Recorder
+	)
+
+	qname := service1 + "." + namespace1 + ".svc.clusterset.local."
+
+	newDelayedServiceImport := func(clusterID, serviceIP string, pending bool) *mcsv1a1.ServiceImport {
+		si := newServiceImport(namespace1, service1, clusterID, serviceIP, mcsv1a1.ClusterSetIP)
+		si.Annotations[lhconstants.AnnotationExportDelay] = "30s"
+
+		if pending {
+			si.Annotations[lhconstants.AnnotationExportPending] = "true"
+		}
+
+		return si
+	}
+
+	answer := func(ip string) test.Case {
+		return test.Case{
+			Qname: qname,
+			Qtype: dns.TypeA,
+			Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{
+				test.A(fmt.Sprintf("%s    5    IN    A    %s", qname, ip)),
+			},
+		}
+	}
+
+	BeforeEach(func() {
+		mockCs := NewMockClusterStatus()
+		mockCs.clusterStatusMap[clusterID] = true
+		mockCs.clusterStatusMap[clusterID2] = true
+		mockEs := NewMockEndpointStatus()
+		mockEs.endpointStatusMap[clusterID] = true
+		mockEs.endpointStatusMap[clusterID2] = true
+
+		lh = &Lighthouse{
+			Zones:           []string{"clusterset.local."},
+			serviceImports:  serviceimport.NewMap(),
+			endpointSlices:  endpointslice.NewMap(),
+			clusterStatus:   mockCs,
+			endpointsStatus: mockEs,
+			localServices:   NewMockLocalServices(),
+			ttl:             defaultTtl,
+		}
+
+		rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	})
+
+	When("the only export of a service is within its export delay", func() {
+		BeforeEach(func() {
+			lh.serviceImports.Put(newDelayedServiceImport(clusterID, serviceIP, true))
+		})
+
+		It("should only resolve it once the exporting cluster cleared its pending flag", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname:  qname,
+				Qtype:  dns.TypeA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{},
+			})
+
+			Expect(lh.Snapshot().Services[0].Clusters[0].Pending).To(BeTrue())
+
+			lh.serviceImports.Put(newDelayedServiceImport(clusterID, serviceIP, false))
+
+			rec = dnstest.NewRecorder(&test.ResponseWriter{})
+			executeTestCase(lh, rec, answer(serviceIP))
+		})
+	})
+
+	When("a service is exported from a cluster within its export delay and one past it", func() {
+		BeforeEach(func() {
+			lh.serviceImports.Put(newDelayedServiceImport(clusterID, serviceIP, true))
+			lh.serviceImports.Put(newDelayedServiceImport(clusterID2, serviceIP2, false))
+		})
+
+		It("should only resolve it from the cluster past its delay", func() {
+			for i := 0; i < 5; i++ {
+				rec = dnstest.NewRecorder(&test.ResponseWriter{})
+				executeTestCase(lh, rec, answer(serviceIP2))
+			}
+		})
+
+		It("should resolve it from the pending cluster when it's specifically requested", func() {
+			executeTestCase(lh, rec, test.Case{
+				Qname: clusterID + "." + qname,
+				Qtype: dns.TypeA,
+				Rcode: dns.RcodeSuccess,
+				Answer: []dns.RR{
+					test.A(fmt.Sprintf("%s    5    IN    A    %s", clusterID+"."+qname, serviceIP)),
+				},
+			})
+		})
+	})
+}
+
 func executeTestCase(lh *Lighthouse, rec *dnstest.Recorder, tc test.Case) {
 	code, err := lh.ServeDNS(context.TODO(), rec, tc.Msg())
 
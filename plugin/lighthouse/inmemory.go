@@ -0,0 +1,127 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lighthouse
+
+import (
+	"sync"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/submariner-io/lighthouse/pkg/endpointslice"
+	"github.com/submariner-io/lighthouse/pkg/serviceimport"
+	discovery "k8s.io/api/discovery/v1beta1"
+	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
+)
+
+// InMemoryStatus holds the cluster connectivity, endpoint health and local Service IPs of an in-memory Lighthouse,
+// set directly rather than by the Gateway, EndpointSlice and Service controllers. The clusters are disconnected and
+// the endpoints healthy unless set otherwise. It's safe for concurrent use, eg by parallel benchmarks.
+type InMemoryStatus struct {
+	mutex          sync.RWMutex
+	localClusterID string
+	connected      map[string]bool
+	unhealthy      map[string]bool
+	localIPs       map[string]string
+}
+
+var _ ClusterStatus = &InMemoryStatus{}
+var _ EndpointsStatus = &InMemoryStatus{}
+var _ LocalServices = &InMemoryStatus{}
+
+// NewInMemory returns a Lighthouse answering queries in the given zones from in-memory state only, without informers
+// or a Gateway controller, eg to benchmark ServeDNS. The ServiceImports and EndpointSlices are added with
+// PutServiceImport and PutEndpointSlice, and the connectivity with the setters of the returned InMemoryStatus. Queries
+// the plugin has no answer for are failed as there's no next plugin.
+func NewInMemory(zones []string, localClusterID string) (*Lighthouse, *InMemoryStatus) {
+	status := &InMemoryStatus{
+		localClusterID: localClusterID,
+		connected:      map[string]bool{},
+		unhealthy:      map[string]bool{},
+		localIPs:       map[string]string{},
+	}
+
+	lh := &Lighthouse{ttl: defaultTtl, serviceImports: serviceimport.NewMap(), endpointSlices: endpointslice.NewMap(),
+		clusterStatus: status, endpointsStatus: status, localServices: status, activeVariants: map[string]string{},
+		aliases: map[string]string{}, aliasZones: map[string]bool{}, localZones: map[string]bool{},
+		serviceFallbacks: map[string]string{}, clusterRegions: map[string]string{},
+		namespacePolicies: map[string]namespacePolicy{}}
+
+	for _, zone := range zones {
+		lh.Zones = append(lh.Zones, plugin.Host(zone).Normalize())
+	}
+
+	return lh, status
+}
+
+// PutServiceImport adds or updates a ServiceImport of an in-memory Lighthouse, as the ServiceImport controller does.
+func (lh *Lighthouse) PutServiceImport(serviceImport *mcsv1a1.ServiceImport) {
+	lh.serviceImports.Put(serviceImport)
+}
+
+// PutEndpointSlice adds or updates an EndpointSlice of an in-memory Lighthouse, as the EndpointSlice controller does.
+func (lh *Lighthouse) PutEndpointSlice(endpointSlice *discovery.EndpointSlice) {
+	lh.endpointSlices.Put(endpointSlice)
+}
+
+// SetConnected sets whether the given cluster is connected.
+func (s *InMemoryStatus) SetConnected(clusterID string, connected bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.connected[clusterID] = connected
+}
+
+// SetHealthy sets whether the endpoints of the service in the given cluster are healthy.
+func (s *InMemoryStatus) SetHealthy(name, namespace, clusterID string, healthy bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.unhealthy[namespace+"/"+name+"/"+clusterID] = !healthy
+}
+
+// SetLocalServiceIP sets the cluster IP of the local Service, returned for the local cluster's exports.
+func (s *InMemoryStatus) SetLocalServiceIP(name, namespace, ip string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.localIPs[namespace+"/"+name] = ip
+}
+
+func (s *InMemoryStatus) IsConnected(clusterID string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.connected[clusterID]
+}
+
+func (s *InMemoryStatus) LocalClusterID() string {
+	return s.localClusterID
+}
+
+func (s *InMemoryStatus) IsHealthy(name, namespace, clusterID string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return !s.unhealthy[namespace+"/"+name+"/"+clusterID]
+}
+
+func (s *InMemoryStatus) GetIP(name, namespace string) (string, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	ip, found := s.localIPs[namespace+"/"+name]
+
+	return ip, found
+}
@@ -17,18 +17,36 @@ package lighthouse
 
 import (
 	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
 
 	"github.com/coredns/coredns/plugin"
 	"github.com/coredns/coredns/plugin/pkg/fall"
 	clog "github.com/coredns/coredns/plugin/pkg/log"
+	"github.com/submariner-io/lighthouse/pkg/circuitbreaker"
 	"github.com/submariner-io/lighthouse/pkg/endpointslice"
 	"github.com/submariner-io/lighthouse/pkg/serviceimport"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
 	Svc        = "svc"
 	Pod        = "pod"
 	defaultTtl = uint32(5)
+	// The label prefixed to a service name in TXT queries for the clusters exporting it.
+	clustersLabel = "_clusters"
+	// The label prefixed to a service name in TXT queries for the diagnostic of its resolution.
+	debugLabel = "_debug"
+	// The label prefixed to a service name for the stable name whose A record is the service's clusterset VIP.
+	vipLabel = "_vip"
+	// The label following the clusterset UID of a service in queries by UID.
+	uidLabel = "uid"
+	// The label following the global name of a service in queries by global name.
+	globalLabel = "global"
+	// The zone served for the local cluster's exported services if the local-zone directive has no arguments.
+	defaultLocalZone = "cluster.local."
 )
 
 var (
@@ -40,6 +58,11 @@ var (
 var log = clog.NewWithPlugin("lighthouse")
 
 type Lighthouse struct {
+	// Non-zero while in standby mode, in which queries are refused until promoted. It's first so it's aligned for
+	// atomic access.
+	standby uint32
+	// Non-zero once the initial sync of the controllers completed.
+	initialSyncDone uint32
 	Next            plugin.Handler
 	Fall            fall.F
 	Zones           []string
@@ -49,6 +72,104 @@ type Lighthouse struct {
 	clusterStatus   ClusterStatus
 	endpointsStatus EndpointsStatus
 	localServices   LocalServices
+	localOnly       bool
+	// The configured directives ordering the local cluster ahead of the others, eg "answer-order local-first".
+	localFirstOrders []string
+	// If set, a service the local cluster exports with a Local internalTrafficPolicy is answered with the local
+	// cluster's endpoints only.
+	internalTrafficPolicy bool
+	breaker               *circuitbreaker.Breaker
+	// Maps a service's "<namespace>/<name>" to the variant whose endpoints are returned for the service name.
+	activeVariants map[string]string
+	// Maps an alias "<namespace>/<name>" to the "<namespace>/<name>" of the service it refers to.
+	aliases map[string]string
+	// If set, alias queries are answered with a CNAME to the canonical service name followed by its A records.
+	aliasCNAME bool
+	// If set, queries for a service with a clusterset VIP are answered with a CNAME to the stable
+	// "_vip.<service>.<namespace>.svc.<zone>" followed by the A record of the VIP under that name.
+	clustersetIPCNAME bool
+	// The TTL of the CNAME to the VIP's name.
+	clustersetIPCNAMETTL uint32
+	// Additional zones, also present in Zones, that are served identically to the primary zones.
+	aliasZones map[string]bool
+	// Zones, also present in Zones, in which the local cluster's exported services are answered, e.g. cluster.local.
+	localZones map[string]bool
+	// If set, services with ClientIP session affinity get answers ordered consistently per client address.
+	sticky bool
+	// If non-zero, the maximum number of clusters whose endpoints are returned in a headless service's answer.
+	maxClusters int
+	// The IP or CNAME target returned for a service none of whose exporting clusters is connected, if any.
+	fallback string
+	// Maps a service's "<namespace>/<name>" to its fallback, overriding the global one.
+	serviceFallbacks map[string]string
+	// If set, "svc.<zone>" appended to "<service>.<namespace>" queries that don't match any zone.
+	searchDomain string
+	// If set, each query is recorded in a span.
+	tracer trace.Tracer
+	// If set, TXT queries for "_clusters.<service>.<namespace>.svc.<zone>" describe the clusters exporting the service.
+	clustersTXT bool
+	// If set, TXT queries for "_debug.<service>.<namespace>.svc.<zone>" diagnose how the service's name is resolved.
+	debugTXT bool
+	// If set, the endpoints of EndpointSlices that haven't been updated within the configured max age aren't returned.
+	excludeStale bool
+	// Maps a cluster ID to its region.
+	clusterRegions map[string]string
+	// If set, answers are restricted to clusters in the local cluster's region unless none of them is available.
+	regionAffinity bool
+	// Orders the endpoints of each answer. If nil, the default for the sticky setting is used.
+	answerOrderer AnswerOrderer
+	// If set, ranks the clusters the endpoints of headless answers are grouped by, before they're ordered within each
+	// cluster by answerOrderer.
+	headlessClusterRank func(clusters []string, query QueryContext) []string
+	// If non-zero, the maximum number of addresses in an answer over UDP, beyond which it's truncated.
+	maxAnswers int
+	// If set, answers are written without name compression.
+	noCompression bool
+	// If set, an endpoint IP exported by several clusters is only returned once in a headless service's answer.
+	dedupEndpoints bool
+	// If set, "<uid>.uid.<zone>" queries are answered for the service with that clusterset UID.
+	uidQueries bool
+	// If set, "<name>.global.<zone>" queries are answered for the service with that global name.
+	globalNames bool
+	// The address of the endpoint promoting the instance from standby mode.
+	promoteAddress string
+	// If set, the address of the endpoint serving the raw Gateways.
+	debugGatewaysAddress string
+	// Serves the raw Gateways of the Gateway controller.
+	debugGatewaysHandler http.Handler
+	// If set, the address of the endpoint serving the answer snapshot.
+	debugSnapshotAddress string
+	// If set, the clusters excluded from answers unless specifically requested.
+	excludedClusters ExcludedClusters
+	// Reports whether the initial sync of the controllers completed. If nil, they're considered synced.
+	synced func() bool
+	// The response code of the queries received before the initial sync completed, or RcodeSuccess to answer them
+	// from the data synced so far.
+	preSyncRcode int
+	// The response code of the queries for an exported service without any available cluster and no fallback, or
+	// RcodeSuccess for an empty answer.
+	unavailableRcode int
+	// The import policies of the namespaces listed by namespace-policy, by namespace or defaultPolicyNamespace.
+	namespacePolicies map[string]namespacePolicy
+	// If non-zero, the time within which a query must be answered or passed to the next plugin.
+	queryTimeout time.Duration
+	// What's done with a query that isn't answered within the query timeout, timeoutFallthrough or timeoutServfail.
+	queryTimeoutAction string
+	// If set, the address of the endpoint freezing the answers of services.
+	freezeAddress string
+	// Serve the admin endpoints, ie the promotion, debug and freeze endpoints, one for each of their addresses.
+	adminServers []*http.Server
+	// The listeners of the adminServers, closed along with them as a server only closes a listener once it's serving it.
+	adminListeners []net.Listener
+	// Maps a frozen service's "<namespace>/<name>" to the answer it was frozen with.
+	frozen map[string]*frozenAnswer
+	// Maps the "<namespace>/<name>" of a service frozen by the frozen annotation of its oldest export to the answer.
+	annotationFrozen map[string]*frozenAnswer
+	frozenMutex      sync.RWMutex
+	// Maps an exported service's "<namespace>/<name>" to its readiness, as last reported by its clusterset_ready and
+	// reachable_clusters_disconnected metrics.
+	clusterSetReady      map[string]serviceReadiness
+	clusterSetReadyMutex sync.Mutex
 }
 
 type ClusterStatus interface {
@@ -57,6 +178,10 @@ type ClusterStatus interface {
 	LocalClusterID() string
 }
 
+type ExcludedClusters interface {
+	IsExcluded(clusterID string) bool
+}
+
 type LocalServices interface {
 	GetIP(name, namespace string) (string, bool)
 }
@@ -0,0 +1,84 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lighthouse
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/submariner-io/lighthouse/pkg/constants"
+)
+
+var (
+	// zoneQueries counts the queries received per matched zone so operators can tell when an alias zone is no
+	// longer used.
+	zoneQueries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: constants.MetricsNamespace,
+		Name:      "zone_queries_total",
+		Help:      "Number of queries received per zone and whether the zone is an alias zone.",
+	}, []string{"zone", "alias"})
+
+	// clusterFirstAnswers counts, per service, how often each cluster is placed first in the answer to a query that
+	// doesn't request a specific cluster so an uneven distribution across clusters can be spotted.
+	clusterFirstAnswers = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: constants.MetricsNamespace,
+		Name:      "dns_cluster_first_answer_total",
+		Help:      "Number of answers for a service in which the cluster was returned first.",
+	}, []string{"service", "cluster_id"})
+
+	// queryTimeouts counts the queries that weren't answered within the query timeout, by the action taken.
+	queryTimeouts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: constants.MetricsNamespace,
+		Name:      "query_timeouts_total",
+		Help:      "Number of queries that weren't answered within the query timeout, by the action taken.",
+	}, []string{"action"})
+
+	// serviceQueries counts the A and AAAA queries for services by the state of the service, so a name that was never
+	// exported can be told from an exported service without any available cluster.
+	serviceQueries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: constants.MetricsNamespace,
+		Name:      "service_queries_total",
+		Help:      "Number of queries for services, by whether the service isn't exported, is unavailable or is available.",
+	}, []string{"state"})
+
+	// frozenServices is 1 for each service whose answers are frozen, so a forgotten freeze can be spotted.
+	frozenServices = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: constants.MetricsNamespace,
+		Name:      "frozen_services",
+		Help:      "Whether the answers for the service are frozen.",
+	}, []string{"service"})
+
+	// frozenAnswers counts, per service, the queries answered with the endpoints the service was frozen with.
+	frozenAnswers = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: constants.MetricsNamespace,
+		Name:      "frozen_answers_total",
+		Help:      "Number of queries for a service answered with the endpoints it was frozen with.",
+	}, []string{"service"})
+
+	// clusterSetReadyServices is 1 for each exported service with ready endpoints in at least one connected cluster,
+	// otherwise 0, so alerts and dependent workloads can gate on the service being available somewhere.
+	clusterSetReadyServices = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: constants.MetricsNamespace,
+		Name:      "clusterset_ready",
+		Help:      "Whether the service has ready endpoints in at least one connected cluster.",
+	}, []string{"service"})
+
+	// reachableClustersDisconnected is 1 for each exported service whose ready endpoints are all in disconnected
+	// clusters, otherwise 0, to alert on services which look exported but are unreachable from the whole clusterset.
+	reachableClustersDisconnected = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: constants.MetricsNamespace,
+		Name:      "reachable_clusters_disconnected",
+		Help:      "Whether the service has ready endpoints only in disconnected clusters.",
+	}, []string{"service"})
+)
@@ -0,0 +1,263 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lighthouse
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/submariner-io/lighthouse/pkg/consistenthash"
+	"github.com/submariner-io/lighthouse/pkg/gateway"
+)
+
+// Endpoint is an address in an answer along with the ID of the cluster it belongs to.
+type Endpoint struct {
+	IP      string
+	Cluster string
+}
+
+// QueryContext describes the query whose answer is being ordered.
+type QueryContext struct {
+	Namespace string
+	Service   string
+	// The address of the client that sent the query.
+	Client string
+	// The ID of the local cluster, if known.
+	LocalCluster string
+	// Whether the service has ClientIP session affinity.
+	ClientIPAffinity bool
+	// Whether the service is headless, otherwise the answer has a single endpoint.
+	Headless bool
+}
+
+// AnswerOrderer orders the endpoints of an answer before it's returned. Implementations must be safe for concurrent
+// use and may reorder the given slice in place. Custom implementations can be compiled in and registered with
+// RegisterAnswerOrderer.
+type AnswerOrderer interface {
+	Order(endpoints []Endpoint, query QueryContext) []Endpoint
+}
+
+// AnswerOrdererFunc adapts an ordinary function to an AnswerOrderer.
+type AnswerOrdererFunc func(endpoints []Endpoint, query QueryContext) []Endpoint
+
+func (f AnswerOrdererFunc) Order(endpoints []Endpoint, query QueryContext) []Endpoint {
+	return f(endpoints, query)
+}
+
+const (
+	answerOrderNone       = "none"
+	answerOrderSticky     = "sticky"
+	answerOrderLocalFirst = "local-first"
+	answerOrderStable     = "stable"
+)
+
+// The cluster orders of the headless-cluster-order directive.
+const (
+	clusterOrderLocalFirst = "local-first"
+	clusterOrderLatency    = "latency"
+	clusterOrderConfig     = "config"
+)
+
+var (
+	answerOrderersMutex sync.RWMutex
+	answerOrderers      = map[string]AnswerOrderer{
+		answerOrderNone:       AnswerOrdererFunc(unordered),
+		answerOrderSticky:     AnswerOrdererFunc(stickyOrder),
+		answerOrderLocalFirst: AnswerOrdererFunc(localFirstOrder),
+		answerOrderStable:     AnswerOrdererFunc(stableOrder),
+	}
+)
+
+// RegisterAnswerOrderer makes an AnswerOrderer available to the answer-order directive under the given name, replacing
+// any registered under the same name. It's meant to be called from the init function of the package providing it.
+func RegisterAnswerOrderer(name string, orderer AnswerOrderer) {
+	answerOrderersMutex.Lock()
+	defer answerOrderersMutex.Unlock()
+
+	answerOrderers[name] = orderer
+}
+
+// answerOrder returns the configured AnswerOrderer or, by default, the sticky orderer if sticky is enabled so answers
+// are otherwise returned in the order they were selected in. The endpoints of headless answers are first grouped by
+// cluster if a headless cluster order is configured, then ordered with it within each cluster.
+func (lh *Lighthouse) answerOrder(headless bool) AnswerOrderer {
+	var orderer AnswerOrderer = AnswerOrdererFunc(unordered)
+
+	switch {
+	case lh.answerOrderer != nil:
+		orderer = lh.answerOrderer
+	case lh.sticky:
+		orderer = AnswerOrdererFunc(stickyOrder)
+	}
+
+	if headless && lh.headlessClusterRank != nil {
+		return &clusterGroupedOrderer{rankClusters: lh.headlessClusterRank, within: orderer}
+	}
+
+	return orderer
+}
+
+func getAnswerOrderer(name string) (AnswerOrderer, bool) {
+	answerOrderersMutex.RLock()
+	defer answerOrderersMutex.RUnlock()
+
+	orderer, ok := answerOrderers[name]
+
+	return orderer, ok
+}
+
+// unordered returns the endpoints in the order they were selected in.
+func unordered(endpoints []Endpoint, _ QueryContext) []Endpoint {
+	return endpoints
+}
+
+// stickyOrder orders the endpoints of services with ClientIP session affinity consistently per client, using
+// rendezvous hashing on the endpoint IPs.
+func stickyOrder(endpoints []Endpoint, query QueryContext) []Endpoint {
+	if !query.ClientIPAffinity || query.Client == "" {
+		return endpoints
+	}
+
+	return rankEndpoints(query.Client, endpoints)
+}
+
+// stableOrder orders the endpoints by a hash of their IPs, keyed by the service so the first endpoints differ between
+// services. Unlike stickyOrder it applies to every client, so repeated queries get the same answer until the endpoints
+// change, which keeps client-side caches and connection pools from reshuffling. Adding or removing an endpoint leaves
+// the relative order of the others unchanged. It only orders the addresses of the answer: a ClusterSetIP service is
+// answered with the single IP of a cluster selected round-robin, which still rotates between the queries. As answers
+// are truncated to max-answers after they're ordered, UDP clients get the same first max-answers addresses.
+func stableOrder(endpoints []Endpoint, query QueryContext) []Endpoint {
+	return rankEndpoints(query.Namespace+"/"+query.Service, endpoints)
+}
+
+// rankEndpoints orders the endpoints by the rendezvous hash of their IPs for the given key.
+func rankEndpoints(key string, endpoints []Endpoint) []Endpoint {
+	byIP := make(map[string]Endpoint, len(endpoints))
+	ips := make([]string, len(endpoints))
+
+	for i, endpoint := range endpoints {
+		byIP[endpoint.IP] = endpoint
+		ips[i] = endpoint.IP
+	}
+
+	ordered := make([]Endpoint, 0, len(endpoints))
+	for _, ip := range consistenthash.Rank(key, ips) {
+		ordered = append(ordered, byIP[ip])
+	}
+
+	return ordered
+}
+
+// localFirstOrder moves the endpoints of the local cluster ahead of the others, otherwise preserving their order.
+func localFirstOrder(endpoints []Endpoint, query QueryContext) []Endpoint {
+	if query.LocalCluster == "" {
+		return endpoints
+	}
+
+	sort.SliceStable(endpoints, func(i, j int) bool {
+		return endpoints[i].Cluster == query.LocalCluster && endpoints[j].Cluster != query.LocalCluster
+	})
+
+	return endpoints
+}
+
+// clusterGroupedOrderer groups the endpoints by cluster, in the order the clusters are ranked in, and orders the
+// endpoints within each cluster with another AnswerOrderer, so clients retrying down a headless answer stay in the same
+// cluster before moving on to the next.
+type clusterGroupedOrderer struct {
+	// Orders the IDs of the answer's clusters, given sorted.
+	rankClusters func(clusters []string, query QueryContext) []string
+	// Orders the endpoints of each cluster.
+	within AnswerOrderer
+}
+
+func (o *clusterGroupedOrderer) Order(endpoints []Endpoint, query QueryContext) []Endpoint {
+	byCluster := map[string][]Endpoint{}
+	clusters := []string{}
+
+	for _, endpoint := range endpoints {
+		if _, ok := byCluster[endpoint.Cluster]; !ok {
+			clusters = append(clusters, endpoint.Cluster)
+		}
+
+		byCluster[endpoint.Cluster] = append(byCluster[endpoint.Cluster], endpoint)
+	}
+
+	sort.Strings(clusters)
+
+	ordered := make([]Endpoint, 0, len(endpoints))
+	for _, clusterID := range o.rankClusters(clusters, query) {
+		ordered = append(ordered, o.within.Order(byCluster[clusterID], query)...)
+	}
+
+	return ordered
+}
+
+// localFirstClusters ranks the local cluster first, followed by the others in ID order.
+func localFirstClusters(clusters []string, query QueryContext) []string {
+	sort.SliceStable(clusters, func(i, j int) bool {
+		return clusters[i] == query.LocalCluster && clusters[j] != query.LocalCluster
+	})
+
+	return clusters
+}
+
+// latencyClusters returns a ranking of the local cluster first, followed by the others by increasing round-trip time
+// of their connection as returned by getConnection, and those whose round-trip time is unknown last.
+func latencyClusters(getConnection func(clusterID string) (gateway.Connection, bool)) func([]string,
+	QueryContext) []string {
+	return func(clusters []string, query QueryContext) []string {
+		connections := make(map[string]gateway.Connection, len(clusters))
+		for _, clusterID := range clusters {
+			connections[clusterID], _ = getConnection(clusterID)
+		}
+
+		sort.SliceStable(clusters, func(i, j int) bool {
+			if clusters[i] == query.LocalCluster || clusters[j] == query.LocalCluster {
+				return clusters[i] == query.LocalCluster && clusters[j] != query.LocalCluster
+			}
+
+			return connections[clusters[i]].LatencyLess(connections[clusters[j]])
+		})
+
+		return clusters
+	}
+}
+
+// configuredClusters returns a ranking of the given clusters in the given order, followed by the others in ID order.
+func configuredClusters(order []string) func([]string, QueryContext) []string {
+	positions := make(map[string]int, len(order))
+	for i, clusterID := range order {
+		positions[clusterID] = i
+	}
+
+	return func(clusters []string, _ QueryContext) []string {
+		position := func(clusterID string) int {
+			if i, ok := positions[clusterID]; ok {
+				return i
+			}
+
+			return len(order)
+		}
+
+		sort.SliceStable(clusters, func(i, j int) bool {
+			return position(clusters[i]) < position(clusters[j])
+		})
+
+		return clusters
+	}
+}
@@ -0,0 +1,218 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lighthouse
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/submariner-io/lighthouse/pkg/gateway"
+)
+
+var _ = Describe("Answer orderers", func() {
+	Context("local-first", testLocalFirstOrder)
+	Context("sticky", testStickyOrder)
+	Context("stable", testStableOrder)
+	Context("registration", testAnswerOrdererRegistration)
+	Context("cluster-grouped", testClusterGroupedOrder)
+})
+
+func orderer(name string) AnswerOrderer {
+	o, ok := getAnswerOrderer(name)
+	Expect(ok).To(BeTrue())
+
+	return o
+}
+
+func answerIPs(endpoints []Endpoint) []string {
+	ips := []string{}
+	for _, endpoint := range endpoints {
+		ips = append(ips, endpoint.IP)
+	}
+
+	return ips
+}
+
+func testLocalFirstOrder() {
+	endpoints := func() []Endpoint {
+		return []Endpoint{
+			{IP: "10.0.0.1", Cluster: "east"},
+			{IP: "10.0.1.1", Cluster: "west"},
+			{IP: "10.0.0.2", Cluster: "east"},
+			{IP: "10.0.1.2", Cluster: "west"},
+		}
+	}
+
+	It("should move the local cluster's endpoints first, preserving the order otherwise", func() {
+		ordered := orderer(answerOrderLocalFirst).Order(endpoints(), QueryContext{LocalCluster: "west"})
+		Expect(answerIPs(ordered)).To(Equal([]string{"10.0.1.1", "10.0.1.2", "10.0.0.1", "10.0.0.2"}))
+	})
+
+	It("should preserve the order if the local cluster isn't known", func() {
+		ordered := orderer(answerOrderLocalFirst).Order(endpoints(), QueryContext{})
+		Expect(answerIPs(ordered)).To(Equal(answerIPs(endpoints())))
+	})
+}
+
+func testStickyOrder() {
+	endpoints := []Endpoint{
+		{IP: "10.0.0.1", Cluster: "east"},
+		{IP: "10.0.1.1", Cluster: "west"},
+		{IP: "10.0.2.1", Cluster: "south"},
+	}
+
+	reversed := []Endpoint{endpoints[2], endpoints[1], endpoints[0]}
+
+	It("should order the endpoints consistently per client for services with ClientIP affinity", func() {
+		query := QueryContext{Client: "192.168.1.5", ClientIPAffinity: true}
+
+		ordered := orderer(answerOrderSticky).Order(append([]Endpoint{}, endpoints...), query)
+		Expect(ordered).To(ConsistOf(endpoints))
+		Expect(orderer(answerOrderSticky).Order(append([]Endpoint{}, reversed...), query)).To(Equal(ordered))
+	})
+
+	It("should preserve the order for services without ClientIP affinity", func() {
+		ordered := orderer(answerOrderSticky).Order(append([]Endpoint{}, reversed...), QueryContext{Client: "192.168.1.5"})
+		Expect(ordered).To(Equal(reversed))
+	})
+}
+
+func testStableOrder() {
+	endpoints := []Endpoint{
+		{IP: "10.0.0.1", Cluster: "east"},
+		{IP: "10.0.1.1", Cluster: "west"},
+		{IP: "10.0.2.1", Cluster: "south"},
+		{IP: "10.0.3.1", Cluster: "north"},
+	}
+
+	reversed := []Endpoint{endpoints[3], endpoints[2], endpoints[1], endpoints[0]}
+	query := QueryContext{Namespace: "ns", Service: "svc", Headless: true}
+
+	It("should order the endpoints the same regardless of their order and of the client", func() {
+		ordered := orderer(answerOrderStable).Order(append([]Endpoint{}, endpoints...), query)
+		Expect(ordered).To(ConsistOf(endpoints))
+
+		Expect(orderer(answerOrderStable).Order(append([]Endpoint{}, reversed...), query)).To(Equal(ordered))
+
+		query.Client = "192.168.1.5"
+		Expect(orderer(answerOrderStable).Order(append([]Endpoint{}, reversed...), query)).To(Equal(ordered))
+	})
+
+	It("should keep the relative order of the other endpoints when one is removed", func() {
+		ordered := orderer(answerOrderStable).Order(append([]Endpoint{}, endpoints...), query)
+		removed := orderer(answerOrderStable).Order(append([]Endpoint{}, endpoints[1:]...), query)
+
+		expected := []Endpoint{}
+		for _, endpoint := range ordered {
+			if endpoint != endpoints[0] {
+				expected = append(expected, endpoint)
+			}
+		}
+
+		Expect(removed).To(Equal(expected))
+	})
+}
+
+func testAnswerOrdererRegistration() {
+	It("should make a custom orderer available by name", func() {
+		_, ok := getAnswerOrderer("reverse")
+		Expect(ok).To(BeFalse())
+
+		RegisterAnswerOrderer("reverse", AnswerOrdererFunc(func(endpoints []Endpoint, _ QueryContext) []Endpoint {
+			for i, j := 0, len(endpoints)-1; i < j; i, j = i+1, j-1 {
+				endpoints[i], endpoints[j] = endpoints[j], endpoints[i]
+			}
+
+			return endpoints
+		}))
+
+		ordered := orderer("reverse").Order([]Endpoint{{IP: "10.0.0.1"}, {IP: "10.0.0.2"}}, QueryContext{})
+		Expect(answerIPs(ordered)).To(Equal([]string{"10.0.0.2", "10.0.0.1"}))
+	})
+}
+
+func testClusterGroupedOrder() {
+	endpoints := func() []Endpoint {
+		return []Endpoint{
+			{IP: "10.0.0.1", Cluster: "east"},
+			{IP: "10.0.1.1", Cluster: "west"},
+			{IP: "10.0.2.1", Cluster: "south"},
+			{IP: "10.0.0.2", Cluster: "east"},
+			{IP: "10.0.1.2", Cluster: "west"},
+			{IP: "10.0.2.2", Cluster: "south"},
+			{IP: "10.0.0.3", Cluster: "east"},
+		}
+	}
+
+	clusterSequence := func(ordered []Endpoint) []string {
+		clusters := []string{}
+		for _, endpoint := range ordered {
+			if len(clusters) == 0 || clusters[len(clusters)-1] != endpoint.Cluster {
+				clusters = append(clusters, endpoint.Cluster)
+			}
+		}
+
+		return clusters
+	}
+
+	order := func(rankClusters func([]string, QueryContext) []string, query QueryContext) []Endpoint {
+		ordered := (&clusterGroupedOrderer{rankClusters: rankClusters, within: AnswerOrdererFunc(unordered)}).Order(
+			endpoints(), query)
+		Expect(ordered).To(ConsistOf(endpoints()))
+
+		return ordered
+	}
+
+	When("the clusters are ranked local-first", func() {
+		It("should group the endpoints by cluster with the local cluster's first, then by cluster ID", func() {
+			Expect(clusterSequence(order(localFirstClusters, QueryContext{LocalCluster: "west"}))).To(Equal(
+				[]string{"west", "east", "south"}))
+		})
+	})
+
+	When("the clusters are ranked by latency", func() {
+		It("should group the endpoints by cluster by increasing round-trip time, unknown last", func() {
+			rtts := map[string]time.Duration{"east": 20 * time.Millisecond, "south": 5 * time.Millisecond}
+			rankClusters := latencyClusters(func(clusterID string) (gateway.Connection, bool) {
+				return gateway.Connection{ClusterID: clusterID, LatencyRTT: rtts[clusterID]}, true
+			})
+
+			Expect(clusterSequence(order(rankClusters, QueryContext{}))).To(Equal([]string{"south", "east", "west"}))
+			Expect(clusterSequence(order(rankClusters, QueryContext{LocalCluster: "west"}))).To(Equal(
+				[]string{"west", "south", "east"}))
+		})
+	})
+
+	When("the clusters are ranked in a configured order", func() {
+		It("should group the endpoints by cluster in that order, the unlisted clusters last", func() {
+			Expect(clusterSequence(order(configuredClusters([]string{"south"}), QueryContext{}))).To(Equal(
+				[]string{"south", "east", "west"}))
+		})
+	})
+
+	It("should order the endpoints within each cluster with the given orderer", func() {
+		query := QueryContext{Namespace: "ns1", Service: "svc1"}
+		ordered := (&clusterGroupedOrderer{rankClusters: configuredClusters([]string{"east"}),
+			within: AnswerOrdererFunc(stableOrder)}).Order(endpoints(), query)
+
+		Expect(ordered[:3]).To(Equal(stableOrder([]Endpoint{
+			{IP: "10.0.0.1", Cluster: "east"},
+			{IP: "10.0.0.2", Cluster: "east"},
+			{IP: "10.0.0.3", Cluster: "east"},
+		}, query)))
+	})
+}
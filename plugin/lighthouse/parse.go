@@ -43,6 +43,9 @@ type recordRequest struct {
 // 3. (service): service.namespace.pod|svc.zone
 //
 // Federations are handled in the federation plugin. And aren't parsed here.
+//
+// DNS names are case-insensitive (RFC 4343) so the elements are parsed from the lower-cased name, matching the
+// Kubernetes names. The answers are named after the query as received, preserving its case for 0x20 compatibility.
 func parseRequest(state request.Request) (r recordRequest, err error) {
 	base, _ := dnsutil.TrimZone(state.Name(), state.Zone)
 	// return NODATA for apex queries
@@ -104,6 +107,45 @@ func parseRequest(state request.Request) (r recordRequest, err error) {
 	return r, nil
 }
 
+// parseUIDRequest parses a "<uid>.uid.<zone>" qname into the request for the service with that clusterset UID, if
+// queries by UID are enabled and the UID is known.
+func (lh *Lighthouse) parseUIDRequest(state request.Request) (recordRequest, bool) {
+	if !lh.uidQueries {
+		return recordRequest{}, false
+	}
+
+	base, _ := dnsutil.TrimZone(state.Name(), state.Zone)
+
+	segs := dns.SplitDomainName(base)
+	if len(segs) != 2 || segs[1] != uidLabel {
+		return recordRequest{}, false
+	}
+
+	namespace, name, found := lh.serviceImports.GetServiceForUID(segs[0])
+
+	return recordRequest{service: name, namespace: namespace, podOrSvc: Svc}, found
+}
+
+// parseGlobalNameRequest parses a "<name>.global.<zone>" qname into the request for the service with that global name,
+// if global names are enabled and the name is known. Such a name can't clash with a service's namespaced name, which
+// is always under "svc.<zone>".
+func (lh *Lighthouse) parseGlobalNameRequest(state request.Request) (recordRequest, bool) {
+	if !lh.globalNames {
+		return recordRequest{}, false
+	}
+
+	base, _ := dnsutil.TrimZone(state.Name(), state.Zone)
+
+	segs := dns.SplitDomainName(base)
+	if len(segs) != 2 || segs[1] != globalLabel {
+		return recordRequest{}, false
+	}
+
+	namespace, name, found := lh.serviceImports.GetServiceForGlobalName(segs[0])
+
+	return recordRequest{service: name, namespace: namespace, podOrSvc: Svc}, found
+}
+
 // String return a string representation of r, it just returns all fields concatenated with dots.
 // This is mostly used in tests.
 func (r recordRequest) String() string {
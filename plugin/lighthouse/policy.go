@@ -0,0 +1,73 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lighthouse
+
+// The namespace-policy namespace whose policy applies to the namespaces that aren't listed.
+const defaultPolicyNamespace = "*"
+
+// namespacePolicy is the import policy applied to the services of a namespace.
+type namespacePolicy struct {
+	// Whether a cluster is only returned for a service while the service's endpoints there are healthy.
+	readinessGated bool
+	// The minimum number of clusters the services must be available from, unless their min-clusters annotation
+	// requires more.
+	minClusters int
+	// Whether the local cluster is returned ahead of the other clusters if it exports the service.
+	preferLocal bool
+}
+
+// The policy of the namespaces that aren't listed by namespace-policy, unless set for defaultPolicyNamespace.
+var defaultNamespacePolicy = namespacePolicy{readinessGated: true, minClusters: 1, preferLocal: true}
+
+// namespacePolicy returns the import policy of the given namespace.
+func (lh *Lighthouse) namespacePolicy(namespace string) namespacePolicy {
+	if policy, ok := lh.namespacePolicies[namespace]; ok {
+		return policy
+	}
+
+	if policy, ok := lh.namespacePolicies[defaultPolicyNamespace]; ok {
+		return policy
+	}
+
+	return defaultNamespacePolicy
+}
+
+// isHealthy returns true if the service's endpoints in the cluster are healthy, or if its namespace's policy doesn't
+// gate the clusters on their readiness.
+func (lh *Lighthouse) isHealthy(name, namespace, clusterID string) bool {
+	return !lh.namespacePolicy(namespace).readinessGated || lh.endpointsStatus.IsHealthy(name, namespace, clusterID)
+}
+
+// getMinClusters returns the minimum number of clusters the service must be available from, the higher of its
+// min-clusters annotation and its namespace's policy.
+func (lh *Lighthouse) getMinClusters(pReq recordRequest) int {
+	minClusters := lh.serviceImports.GetMinClusters(pReq.namespace, pReq.service)
+	if policyMin := lh.namespacePolicy(pReq.namespace).minClusters; policyMin > minClusters {
+		return policyMin
+	}
+
+	return minClusters
+}
+
+// preferredClusterID returns the ID of the local cluster if it's preferred for the services of the namespace,
+// otherwise "" so the clusters are all selected alike.
+func (lh *Lighthouse) preferredClusterID(namespace string) string {
+	if !lh.namespacePolicy(namespace).preferLocal {
+		return ""
+	}
+
+	return lh.clusterStatus.LocalClusterID()
+}
@@ -0,0 +1,45 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lighthouse
+
+import (
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+)
+
+// The response codes of the queries received before the initial sync completed, by presync-answer argument.
+var preSyncRcodes = map[string]int{
+	"servfail": dns.RcodeServerFailure,
+	"refused":  dns.RcodeRefused,
+	"serve":    dns.RcodeSuccess,
+}
+
+// hasSynced returns true once the initial sync of the controllers completed. It stays true once it is.
+func (lh *Lighthouse) hasSynced() bool {
+	if lh.synced == nil || atomic.LoadUint32(&lh.initialSyncDone) != 0 {
+		return true
+	}
+
+	if !lh.synced() {
+		return false
+	}
+
+	atomic.StoreUint32(&lh.initialSyncDone, 1)
+	log.Infof("The initial sync completed - answering queries")
+
+	return true
+}
@@ -0,0 +1,128 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lighthouse
+
+// serviceReadiness is the clusterset-wide availability of an exported service.
+type serviceReadiness struct {
+	// Whether the service has ready endpoints in at least one connected cluster.
+	ready bool
+	// Whether the service has ready endpoints, but only in disconnected clusters.
+	disconnected bool
+}
+
+// ClusterSetReady returns whether the exported service has ready endpoints in at least one connected cluster, ie
+// whether it's available somewhere in the clusterset, regardless of the local cluster. Unlike a query, it ignores the
+// circuit breakers, variants and namespace policies.
+func (lh *Lighthouse) ClusterSetReady(namespace, name string) bool {
+	return lh.serviceReadiness(namespace, name).ready
+}
+
+// ReachableClustersDisconnected returns whether all the clusters with ready endpoints for the exported service are
+// disconnected, in which case it's exported but unreachable from the whole clusterset.
+func (lh *Lighthouse) ReachableClustersDisconnected(namespace, name string) bool {
+	return lh.serviceReadiness(namespace, name).disconnected
+}
+
+func (lh *Lighthouse) serviceReadiness(namespace, name string) serviceReadiness {
+	localClusterID := lh.clusterStatus.LocalClusterID()
+	readiness := serviceReadiness{}
+
+	for _, clusterID := range lh.serviceImports.GetClusters(namespace, name) {
+		if !lh.serviceImports.IsMerged(namespace, name, clusterID) ||
+			!lh.endpointsStatus.IsHealthy(name, namespace, clusterID) {
+			continue
+		}
+
+		if clusterID == localClusterID || lh.clusterStatus.IsConnected(clusterID) {
+			return serviceReadiness{ready: true}
+		}
+
+		readiness.disconnected = true
+	}
+
+	return readiness
+}
+
+// updateClusterSetReady updates the clusterset_ready and reachable_clusters_disconnected metrics of the service after a
+// change to its exports or endpoints, logging their transitions. The metrics are removed once the service is no longer
+// exported.
+func (lh *Lighthouse) updateClusterSetReady(namespace, name string) {
+	key := namespace + "/" + name
+	exported := len(lh.serviceImports.GetClusters(namespace, name)) > 0
+
+	readiness := serviceReadiness{}
+	if exported {
+		readiness = lh.serviceReadiness(namespace, name)
+	}
+
+	lh.clusterSetReadyMutex.Lock()
+	defer lh.clusterSetReadyMutex.Unlock()
+
+	previous, known := lh.clusterSetReady[key]
+
+	if !exported {
+		if known {
+			delete(lh.clusterSetReady, key)
+			clusterSetReadyServices.DeleteLabelValues(key)
+			reachableClustersDisconnected.DeleteLabelValues(key)
+		}
+
+		return
+	}
+
+	if known && previous == readiness {
+		return
+	}
+
+	if lh.clusterSetReady == nil {
+		lh.clusterSetReady = map[string]serviceReadiness{}
+	}
+
+	lh.clusterSetReady[key] = readiness
+
+	clusterSetReadyServices.WithLabelValues(key).Set(boolToFloat(readiness.ready))
+	reachableClustersDisconnected.WithLabelValues(key).Set(boolToFloat(readiness.disconnected))
+
+	if (known && previous.ready != readiness.ready) || (!known && !readiness.ready) {
+		log.Infof("The service %q is clusterset ready: %t", key, readiness.ready)
+	}
+
+	if readiness.disconnected && (!known || !previous.disconnected) {
+		log.Warningf("The service %q is unreachable: all the clusters with ready endpoints for it are disconnected", key)
+	} else if !readiness.disconnected && known && previous.disconnected {
+		log.Infof("The service %q no longer only has ready endpoints in disconnected clusters", key)
+	}
+}
+
+// updateAllClusterSetReady updates the readiness metrics of every exported service, eg once a cluster's
+// connectivity changed.
+func (lh *Lighthouse) updateAllClusterSetReady() {
+	for _, summary := range lh.serviceImports.List() {
+		lh.updateClusterSetReady(summary.Namespace, summary.Name)
+	}
+}
+
+func (lh *Lighthouse) clusterSetConnectivityChanged(_ string, _ bool) {
+	lh.updateAllClusterSetReady()
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
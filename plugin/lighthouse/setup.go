@@ -16,28 +16,54 @@ limitations under the License.
 package lighthouse
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"net"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/caddyserver/caddy"
 	"github.com/coredns/coredns/core/dnsserver"
 	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metrics"
+	"github.com/miekg/dns"
+	"github.com/submariner-io/lighthouse/pkg/circuitbreaker"
 	"github.com/submariner-io/lighthouse/pkg/endpointslice"
+	"github.com/submariner-io/lighthouse/pkg/exclusion"
 	"github.com/submariner-io/lighthouse/pkg/gateway"
+	"github.com/submariner-io/lighthouse/pkg/liveness"
+	"github.com/submariner-io/lighthouse/pkg/rbac"
 	"github.com/submariner-io/lighthouse/pkg/service"
 	"github.com/submariner-io/lighthouse/pkg/serviceimport"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/flowcontrol"
 )
 
 var (
-	masterURL  string
-	kubeconfig string
+	masterURL    string
+	kubeconfig   string
+	rbacFailFast bool
+)
+
+// requiredPermissions are those the controllers watching the cluster need, checked at startup.
+var requiredPermissions = concatPermissions(
+	rbac.ResourcePermissions("multicluster.x-k8s.io", "serviceimports", "", "list", "watch"),
+	rbac.ResourcePermissions("discovery.k8s.io", "endpointslices", "", "list", "watch"),
+	rbac.ResourcePermissions("submariner.io", "gateways", "", "list", "watch"),
+	rbac.ResourcePermissions("", "services", "", "list", "watch"),
 )
 
 // Hook for unit tests
 var buildKubeConfigFunc = clientcmd.BuildConfigFromFlags
 
+// Hook for unit tests
+var localClusterIDTimeout = 10 * time.Second
+
 // init registers this plugin within the Caddy plugin framework. It uses "example" as the
 // name, and couples it to the Action "setup".
 func init() {
@@ -57,6 +83,24 @@ func setupLighthouse(c *caddy.Controller) error {
 		return plugin.Error("lighthouse", err)
 	}
 
+	c.OnStartup(func() error {
+		metrics.MustRegister(c, gateway.Collectors()...)
+		metrics.MustRegister(c, circuitbreaker.Collectors()...)
+		metrics.MustRegister(c, endpointslice.Collectors()...)
+		metrics.MustRegister(c, serviceimport.Collectors()...)
+		metrics.MustRegister(c, liveness.Collectors()...)
+		metrics.MustRegister(c, zoneQueries, clusterFirstAnswers, queryTimeouts, serviceQueries, frozenServices,
+			frozenAnswers, clusterSetReadyServices, reachableClustersDisconnected)
+		return nil
+	})
+
+	if len(l.adminEndpoints()) > 0 {
+		c.OnStartup(l.startAdminServers)
+		c.OnRestart(l.stopAdminServers)
+		c.OnRestartFailed(l.startAdminServers)
+		c.OnShutdown(l.stopAdminServers)
+	}
+
 	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
 		l.Next = next
 		return l
@@ -71,6 +115,18 @@ func lighthouseParse(c *caddy.Controller) (*Lighthouse, error) {
 		return nil, fmt.Errorf("error building kubeconfig: %v", err)
 	}
 
+	// Checked before starting the controllers, which otherwise wait for their caches to sync without saying why
+	// they can't.
+	rbacClient, err := rbac.NewClientsetForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error creating the client set to check the permissions: %v", err)
+	}
+
+	err = rbac.Verify(rbacClient, "Lighthouse", requiredPermissions, rbacFailFast)
+	if err != nil {
+		return nil, err
+	}
+
 	siMap := serviceimport.NewMap()
 	siController := serviceimport.NewController(siMap)
 
@@ -81,10 +137,6 @@ func lighthouseParse(c *caddy.Controller) (*Lighthouse, error) {
 
 	epMap := endpointslice.NewMap()
 	epController := endpointslice.NewController(epMap)
-	err = epController.Start(cfg)
-	if err != nil {
-		return nil, fmt.Errorf("error starting the EndpointSlice controller: %v", err)
-	}
 
 	gwController := gateway.NewController()
 	err = gwController.Start(cfg)
@@ -107,7 +159,32 @@ func lighthouseParse(c *caddy.Controller) (*Lighthouse, error) {
 	})
 
 	lh := &Lighthouse{ttl: defaultTtl, serviceImports: siMap, clusterStatus: gwController, endpointSlices: epMap,
-		endpointsStatus: epController, localServices: svcController}
+		endpointsStatus: epController, localServices: svcController, activeVariants: map[string]string{},
+		aliases: map[string]string{}, aliasZones: map[string]bool{}, localZones: map[string]bool{}, serviceFallbacks: map[string]string{},
+		clusterRegions: map[string]string{}, preSyncRcode: dns.RcodeServerFailure,
+		namespacePolicies: map[string]namespacePolicy{}}
+
+	lh.synced = func() bool {
+		return siController.HasSynced() && epController.HasSynced() && gwController.HasSynced()
+	}
+
+	var forcedConnected []string
+
+	connectedStatuses := []string{gateway.DefaultConnectedStatus}
+	minLatencyRTT, maxLatencyRTT := time.Duration(0), gateway.DefaultMaxLatencyRTT
+
+	var excludedCIDRs []*net.IPNet
+
+	var globalCIDRs *endpointslice.GlobalCIDRTransformer
+
+	var endpointMaxAge time.Duration
+
+	var excludedClustersConfigMap string
+
+	var connectivityEventsConfigMap string
+
+	// The TTL of the CNAME to the VIP's name, if configured, otherwise the TTL of the answers.
+	clustersetIPCNAMETTL := -1
 
 	// Changed `for` to `if` to satisfy golint:
 	//	 SA4004: the surrounding loop is unconditionally terminated (staticcheck)
@@ -124,8 +201,327 @@ func lighthouseParse(c *caddy.Controller) (*Lighthouse, error) {
 
 		for c.NextBlock() {
 			switch c.Val() {
+			case "alias":
+				alias, target, err := parseAlias(c)
+				if err != nil {
+					return nil, err
+				}
+
+				lh.aliases[alias] = target
+			case "alias-zone":
+				zones := c.RemainingArgs()
+				if len(zones) == 0 {
+					return nil, c.ArgErr()
+				}
+
+				for _, zone := range zones {
+					zone = plugin.Host(zone).Normalize()
+					lh.aliasZones[zone] = true
+					lh.Zones = append(lh.Zones, zone)
+				}
+			case "local-zone":
+				zones := c.RemainingArgs()
+				if len(zones) == 0 {
+					zones = []string{defaultLocalZone}
+				}
+
+				for _, zone := range zones {
+					zone = plugin.Host(zone).Normalize()
+					lh.localZones[zone] = true
+					lh.Zones = append(lh.Zones, zone)
+				}
+			case "alias-cname":
+				lh.aliasCNAME = true
+			case "clusterset-ip-cname":
+				args := c.RemainingArgs()
+				if len(args) > 1 {
+					return nil, c.ArgErr()
+				}
+
+				lh.clustersetIPCNAME = true
+
+				if len(args) == 1 {
+					t, err := parseTtlValue(c, args[0])
+					if err != nil {
+						return nil, err
+					}
+
+					clustersetIPCNAMETTL = int(t)
+				}
+			case "answer-order":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+
+				orderer, ok := getAnswerOrderer(args[0])
+				if !ok {
+					return nil, c.Errf("unknown answer-order %q", args[0])
+				}
+
+				lh.answerOrderer = orderer
+				if args[0] == answerOrderLocalFirst {
+					lh.localFirstOrders = append(lh.localFirstOrders, "answer-order "+answerOrderLocalFirst)
+				}
+			case "headless-cluster-order":
+				order, rankClusters, err := parseHeadlessClusterOrder(c, gwController)
+				if err != nil {
+					return nil, err
+				}
+
+				if order == clusterOrderLocalFirst {
+					lh.localFirstOrders = append(lh.localFirstOrders, "headless-cluster-order "+clusterOrderLocalFirst)
+				}
+
+				lh.headlessClusterRank = rankClusters
+			case "cluster-region":
+				args := c.RemainingArgs()
+				if len(args) != 2 {
+					return nil, c.ArgErr()
+				}
+
+				lh.clusterRegions[args[0]] = args[1]
+			case "clusters-txt":
+				lh.clustersTXT = true
+			case "debug-txt":
+				lh.debugTXT = true
+			case "debug-gateways":
+				args := c.RemainingArgs()
+				if len(args) > 1 {
+					return nil, c.ArgErr()
+				}
+
+				lh.debugGatewaysAddress = defaultAdminAddress
+
+				if len(args) == 1 {
+					if _, _, err := net.SplitHostPort(args[0]); err != nil {
+						return nil, c.Errf("invalid debug-gateways address %q: %v", args[0], err)
+					}
+
+					lh.debugGatewaysAddress = args[0]
+				}
+
+				lh.debugGatewaysHandler = gwController.DebugHandler()
+			case "debug-snapshot":
+				args := c.RemainingArgs()
+				if len(args) > 1 {
+					return nil, c.ArgErr()
+				}
+
+				lh.debugSnapshotAddress = defaultAdminAddress
+
+				if len(args) == 1 {
+					if _, _, err := net.SplitHostPort(args[0]); err != nil {
+						return nil, c.Errf("invalid debug-snapshot address %q: %v", args[0], err)
+					}
+
+					lh.debugSnapshotAddress = args[0]
+				}
+			case "freeze":
+				args := c.RemainingArgs()
+				if len(args) > 1 {
+					return nil, c.ArgErr()
+				}
+
+				lh.freezeAddress = defaultAdminAddress
+
+				if len(args) == 1 {
+					if _, _, err := net.SplitHostPort(args[0]); err != nil {
+						return nil, c.Errf("invalid freeze address %q: %v", args[0], err)
+					}
+
+					lh.freezeAddress = args[0]
+				}
+			case "circuit-breaker":
+				threshold, cooldown, err := parseCircuitBreaker(c)
+				if err != nil {
+					return nil, err
+				}
+
+				lh.breaker = circuitbreaker.New(threshold, cooldown)
+			case "connected-status":
+				connectedStatuses, err = parseConnectedStatuses(c)
+				if err != nil {
+					return nil, err
+				}
+			case "latency-rtt-bounds":
+				minLatencyRTT, maxLatencyRTT, err = parseLatencyRTTBounds(c)
+				if err != nil {
+					return nil, err
+				}
+			case "force-connected":
+				forcedConnected = c.RemainingArgs()
+				if len(forcedConnected) == 0 {
+					return nil, c.ArgErr()
+				}
+			case "endpoint-max-age":
+				maxAge, exclude, err := parseEndpointMaxAge(c)
+				if err != nil {
+					return nil, err
+				}
+
+				endpointMaxAge = maxAge
+				lh.excludeStale = exclude
+			case "excluded-clusters":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+
+				if strings.Count(args[0], "/") != 1 {
+					return nil, c.Errf("excluded-clusters ConfigMap must be specified as <namespace>/<name>: %q", args[0])
+				}
+
+				excludedClustersConfigMap = args[0]
+			case "connectivity-events":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+
+				if strings.Count(args[0], "/") != 1 {
+					return nil, c.Errf("connectivity-events ConfigMap must be specified as <namespace>/<name>: %q", args[0])
+				}
+
+				connectivityEventsConfigMap = args[0]
+			case "exclude-cidr":
+				cidrs, err := parseExcludeCIDRs(c)
+				if err != nil {
+					return nil, err
+				}
+
+				excludedCIDRs = append(excludedCIDRs, cidrs...)
+			case "global-cidr":
+				if globalCIDRs == nil {
+					globalCIDRs = endpointslice.NewGlobalCIDRTransformer()
+				}
+
+				if err := parseGlobalCIDR(c, globalCIDRs); err != nil {
+					return nil, err
+				}
+			case "fallback":
+				service, target, err := parseFallback(c)
+				if err != nil {
+					return nil, err
+				}
+
+				if service == "" {
+					lh.fallback = target
+				} else {
+					lh.serviceFallbacks[service] = target
+				}
 			case "fallthrough":
 				lh.Fall.SetZonesFromArgs(c.RemainingArgs())
+			case "active-variant":
+				service, variant, err := parseActiveVariant(c)
+				if err != nil {
+					return nil, err
+				}
+
+				lh.activeVariants[service] = variant
+			case "uid-queries":
+				lh.uidQueries = true
+			case "global-names":
+				allowed, err := parseGlobalNames(c)
+				if err != nil {
+					return nil, err
+				}
+
+				lh.globalNames = true
+				siMap.SetGlobalNameAllowlist(allowed...)
+			case "internal-traffic-policy":
+				lh.internalTrafficPolicy = true
+			case "local-only":
+				lh.localOnly = true
+			case "max-answers":
+				maxAnswers, err := parsePositiveInt(c)
+				if err != nil {
+					return nil, err
+				}
+
+				lh.maxAnswers = maxAnswers
+			case "max-endpointslices":
+				limits, err := parseMaxEndpointSlices(c)
+				if err != nil {
+					return nil, err
+				}
+
+				epController.Limits = limits
+			case "max-clusters":
+				maxClusters, err := parsePositiveInt(c)
+				if err != nil {
+					return nil, err
+				}
+
+				lh.maxClusters = maxClusters
+			case "no-compression":
+				if len(c.RemainingArgs()) != 0 {
+					return nil, c.ArgErr()
+				}
+
+				lh.noCompression = true
+			case "dedup-endpoints":
+				if len(c.RemainingArgs()) != 0 {
+					return nil, c.ArgErr()
+				}
+
+				lh.dedupEndpoints = true
+			case "presync-answer":
+				lh.preSyncRcode, err = parsePreSyncAnswer(c)
+				if err != nil {
+					return nil, err
+				}
+			case "namespace-policy":
+				if err := parseNamespacePolicy(c, lh.namespacePolicies); err != nil {
+					return nil, err
+				}
+			case "unavailable-answer":
+				lh.unavailableRcode, err = parseUnavailableAnswer(c)
+				if err != nil {
+					return nil, err
+				}
+			case "query-timeout":
+				lh.queryTimeout, lh.queryTimeoutAction, err = parseQueryTimeout(c)
+				if err != nil {
+					return nil, err
+				}
+			case "region-affinity":
+				lh.regionAffinity = true
+			case "search-domain":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+
+				lh.searchDomain = plugin.Host(args[0]).Normalize()
+			case "standby":
+				args := c.RemainingArgs()
+				if len(args) > 1 {
+					return nil, c.ArgErr()
+				}
+
+				if !promotedInProcess() {
+					lh.standby = 1
+				}
+
+				lh.promoteAddress = defaultAdminAddress
+
+				if len(args) == 1 {
+					if _, _, err := net.SplitHostPort(args[0]); err != nil {
+						return nil, c.Errf("invalid standby promotion address %q: %v", args[0], err)
+					}
+
+					lh.promoteAddress = args[0]
+				}
+			case "strip-fields":
+				transforms, err := parseStripFields(c)
+				if err != nil {
+					return nil, err
+				}
+
+				epController.Transforms = transforms
+			case "sticky":
+				lh.sticky = true
 			case "ttl":
 				t, err := parseTtl(c)
 
@@ -134,6 +530,21 @@ func lighthouseParse(c *caddy.Controller) (*Lighthouse, error) {
 				}
 
 				lh.ttl = t
+			case "tracing":
+				if len(c.RemainingArgs()) != 0 {
+					return nil, c.ArgErr()
+				}
+
+				tp, err := newTracerProvider()
+				if err != nil {
+					return nil, c.Errf("error creating the tracer provider: %v", err)
+				}
+
+				lh.tracer = tp.Tracer(tracerName)
+
+				c.OnShutdown(func() error {
+					return tp.Shutdown(context.Background())
+				})
 			default:
 				if c.Val() != "}" {
 					return nil, c.Errf("unknown property '%s'", c.Val())
@@ -142,9 +553,575 @@ func lighthouseParse(c *caddy.Controller) (*Lighthouse, error) {
 		}
 	}
 
+	lh.clustersetIPCNAMETTL = lh.ttl
+	if clustersetIPCNAMETTL >= 0 {
+		lh.clustersetIPCNAMETTL = uint32(clustersetIPCNAMETTL)
+	}
+
+	gwController.SetConnectedStatuses(connectedStatuses...)
+	gwController.SetLatencyRTTBounds(minLatencyRTT, maxLatencyRTT)
+
+	// The override is part of the configuration so it's cleared by removing the directive and reloading.
+	gateway.ForcedConnections.Reset()
+
+	if len(forcedConnected) > 0 {
+		gwController.ForceConnected(forcedConnected...)
+	}
+
+	if lh.breaker != nil {
+		gwController.OnConnectivityChange(lh.clusterConnectivityChanged)
+	}
+
+	// The services exported before the handlers are registered are covered by the initial update.
+	siMap.OnChange(lh.updateClusterSetReady)
+	siMap.OnChange(lh.updateAnnotationFrozen)
+	epMap.OnChange(lh.updateClusterSetReady)
+	gwController.OnConnectivityChange(lh.clusterSetConnectivityChanged)
+	lh.updateAllClusterSetReady()
+
+	if connectivityEventsConfigMap != "" {
+		nameParts := strings.SplitN(connectivityEventsConfigMap, "/", 2)
+
+		err := rbac.Verify(rbacClient, "the Lighthouse connectivity Events", gateway.EventPermissions(nameParts[0]),
+			rbacFailFast)
+		if err != nil {
+			return nil, err
+		}
+
+		broadcaster, recorder, err := gateway.NewEventRecorder(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("error creating the connectivity Event recorder: %v", err)
+		}
+
+		c.OnShutdown(func() error {
+			broadcaster.Shutdown()
+			return nil
+		})
+
+		gwController.RecordConnectivityEvents(recorder, &corev1.ObjectReference{APIVersion: "v1", Kind: "ConfigMap",
+			Namespace: nameParts[0], Name: nameParts[1]}, flowcontrol.NewTokenBucketRateLimiter(gateway.EventsQPS,
+			gateway.EventsBurst))
+	}
+
+	if excludedClustersConfigMap != "" {
+		nameParts := strings.SplitN(excludedClustersConfigMap, "/", 2)
+		exclusionController := exclusion.NewController(nameParts[0], nameParts[1])
+
+		err := rbac.Verify(rbacClient, "the Lighthouse excluded clusters controller",
+			exclusionController.RequiredPermissions(), rbacFailFast)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := exclusionController.Start(cfg); err != nil {
+			return nil, fmt.Errorf("error starting the excluded clusters controller: %v", err)
+		}
+
+		c.OnShutdown(func() error {
+			exclusionController.Stop()
+			return nil
+		})
+
+		lh.excludedClusters = exclusionController
+
+		// Until the ConfigMap is listed, the excluded clusters would be returned.
+		synced := lh.synced
+		lh.synced = func() bool {
+			return synced() && exclusionController.HasSynced()
+		}
+	}
+
+	epMap.ExcludeCIDRs(excludedCIDRs)
+
+	if globalCIDRs != nil {
+		epController.EndpointTransformer = globalCIDRs
+	}
+	epMap.SetMaxAge(endpointMaxAge)
+
+	// The EndpointSlice controller is started once its limits and transforms are parsed as they apply on ingest.
+	if err := epController.Start(cfg); err != nil {
+		return nil, fmt.Errorf("error starting the EndpointSlice controller: %v", err)
+	}
+
+	if endpointMaxAge > 0 {
+		stopStalenessCheck := make(chan struct{})
+		go wait.Until(epMap.CheckStaleness, endpointMaxAge/2, stopStalenessCheck)
+
+		c.OnShutdown(func() error {
+			close(stopStalenessCheck)
+			return nil
+		})
+	}
+
+	// Aliases are resolved a single level so reject chains, which also prevents loops.
+	for alias, target := range lh.aliases {
+		if _, ok := lh.aliases[target]; ok {
+			return nil, fmt.Errorf("the target %q of alias %q is itself an alias", target, alias)
+		}
+	}
+
+	if lh.regionAffinity && len(lh.clusterRegions) == 0 {
+		return nil, fmt.Errorf("region-affinity requires the regions of the clusters to be configured with cluster-region")
+	}
+
+	// The zones are only known once the block is parsed as alias zones may follow the search domain.
+	if lh.searchDomain != "" && !lh.isSvcDomain(lh.searchDomain) {
+		return nil, fmt.Errorf("the search domain %q must be %q followed by one of the zones %v", lh.searchDomain,
+			Svc, lh.Zones)
+	}
+
+	if err := lh.validateLocalClusterID(); err != nil {
+		return nil, err
+	}
+
 	return lh, nil
 }
 
+// localClusterFeatures returns the names of the configured features that depend on knowing the local cluster ID.
+func (lh *Lighthouse) localClusterFeatures() []string {
+	features := []string{}
+
+	if lh.localOnly {
+		features = append(features, "local-only")
+	}
+
+	if len(lh.localZones) > 0 {
+		features = append(features, "local-zone")
+	}
+
+	features = append(features, lh.localFirstOrders...)
+
+	if lh.regionAffinity {
+		features = append(features, "region-affinity")
+	}
+
+	// The default policy prefers the local cluster when it's known, only the configured policies require it.
+	namespaces := make([]string, 0, len(lh.namespacePolicies))
+
+	for namespace, policy := range lh.namespacePolicies {
+		if policy.preferLocal {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+
+	sort.Strings(namespaces)
+
+	for _, namespace := range namespaces {
+		features = append(features, "namespace-policy "+namespace)
+	}
+
+	return features
+}
+
+func (lh *Lighthouse) validateLocalClusterID() error {
+	features := lh.localClusterFeatures()
+	if len(features) == 0 {
+		return nil
+	}
+
+	// The local cluster ID is learned from the Gateway status which is processed asynchronously so give it a chance
+	// to be populated.
+	var localClusterID string
+
+	_ = wait.PollImmediate(100*time.Millisecond, localClusterIDTimeout, func() (bool, error) {
+		localClusterID = lh.clusterStatus.LocalClusterID()
+		return localClusterID != "", nil
+	})
+
+	if localClusterID == "" {
+		return fmt.Errorf("the local cluster ID is required by %v but is not configured", features)
+	}
+
+	if !lh.clusterStatus.IsConnected(localClusterID) {
+		return fmt.Errorf("the local cluster ID %q required by %v does not match any known cluster", localClusterID, features)
+	}
+
+	return nil
+}
+
+func (lh *Lighthouse) isSvcDomain(domain string) bool {
+	for _, zone := range lh.Zones {
+		if domain == Svc+"."+zone {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseAlias(c *caddy.Controller) (string, string, error) {
+	args := c.RemainingArgs()
+	if len(args) != 2 {
+		return "", "", c.ArgErr()
+	}
+
+	for _, arg := range args {
+		if strings.Count(arg, "/") != 1 {
+			return "", "", c.Errf("alias services must be specified as <namespace>/<name>: %q", arg)
+		}
+	}
+
+	// Queries are matched case-insensitively, so the services are too.
+	return strings.ToLower(args[0]), strings.ToLower(args[1]), nil
+}
+
+func parseActiveVariant(c *caddy.Controller) (string, string, error) {
+	args := c.RemainingArgs()
+	if len(args) != 2 {
+		return "", "", c.ArgErr()
+	}
+
+	if strings.Count(args[0], "/") != 1 {
+		return "", "", c.Errf("active-variant service must be specified as <namespace>/<name>: %q", args[0])
+	}
+
+	return strings.ToLower(args[0]), strings.ToLower(args[1]), nil
+}
+
+func parseCircuitBreaker(c *caddy.Controller) (int, time.Duration, error) {
+	args := c.RemainingArgs()
+	if len(args) != 2 {
+		return 0, 0, c.ArgErr()
+	}
+
+	threshold, err := strconv.Atoi(args[0])
+	if err != nil || threshold < 1 {
+		return 0, 0, c.Errf("circuit-breaker threshold must be a positive integer: %q", args[0])
+	}
+
+	cooldown, err := time.ParseDuration(args[1])
+	if err != nil || cooldown <= 0 {
+		return 0, 0, c.Errf("circuit-breaker cooldown must be a positive duration: %q", args[1])
+	}
+
+	return threshold, cooldown, nil
+}
+
+func parseConnectedStatuses(c *caddy.Controller) ([]string, error) {
+	args := c.RemainingArgs()
+	if len(args) == 0 {
+		return nil, c.ArgErr()
+	}
+
+	for _, arg := range args {
+		// Submariner reports these while a connection is being established or has failed.
+		if arg == "connecting" || arg == "error" {
+			return nil, c.Errf("connected-status %q never means the cluster is connected", arg)
+		}
+	}
+
+	return args, nil
+}
+
+func parseLatencyRTTBounds(c *caddy.Controller) (time.Duration, time.Duration, error) {
+	args := c.RemainingArgs()
+	if len(args) != 2 {
+		return 0, 0, c.ArgErr()
+	}
+
+	minRTT, err := time.ParseDuration(args[0])
+	if err != nil || minRTT < 0 {
+		return 0, 0, c.Errf("latency-rtt-bounds minimum must be a non-negative duration: %q", args[0])
+	}
+
+	maxRTT, err := time.ParseDuration(args[1])
+	if err != nil || maxRTT <= minRTT {
+		return 0, 0, c.Errf("latency-rtt-bounds maximum must be a duration above the minimum: %q", args[1])
+	}
+
+	return minRTT, maxRTT, nil
+}
+
+func parseEndpointMaxAge(c *caddy.Controller) (time.Duration, bool, error) {
+	args := c.RemainingArgs()
+	if len(args) < 1 || len(args) > 2 {
+		return 0, false, c.ArgErr()
+	}
+
+	maxAge, err := time.ParseDuration(args[0])
+	if err != nil || maxAge <= 0 {
+		return 0, false, c.Errf("endpoint-max-age must be a positive duration: %q", args[0])
+	}
+
+	if len(args) == 2 && args[1] != "exclude" {
+		return 0, false, c.Errf("unknown endpoint-max-age option %q", args[1])
+	}
+
+	return maxAge, len(args) == 2, nil
+}
+
+func parseExcludeCIDRs(c *caddy.Controller) ([]*net.IPNet, error) {
+	args := c.RemainingArgs()
+	if len(args) == 0 {
+		return nil, c.ArgErr()
+	}
+
+	cidrs := make([]*net.IPNet, 0, len(args))
+
+	for _, arg := range args {
+		_, cidr, err := net.ParseCIDR(arg)
+		if err != nil {
+			return nil, c.Errf("exclude-cidr must be a valid CIDR: %q", arg)
+		}
+
+		cidrs = append(cidrs, cidr)
+	}
+
+	return cidrs, nil
+}
+
+func parseFallback(c *caddy.Controller) (string, string, error) {
+	args := c.RemainingArgs()
+	if len(args) == 0 || len(args) > 2 {
+		return "", "", c.ArgErr()
+	}
+
+	service, target := "", args[len(args)-1]
+
+	if len(args) == 2 {
+		service = strings.ToLower(args[0])
+		if strings.Count(service, "/") != 1 {
+			return "", "", c.Errf("fallback service must be specified as <namespace>/<name>: %q", service)
+		}
+	}
+
+	if ip := net.ParseIP(target); ip == nil || ip.To4() == nil {
+		if _, ok := dns.IsDomainName(target); ip != nil || !ok {
+			return "", "", c.Errf("fallback must be an IPv4 address or a hostname: %q", target)
+		}
+	}
+
+	return service, target, nil
+}
+
+// parseGlobalNames parses "NAMESPACE[/NAME]...", the namespaces whose services, or the services, allowed to claim a
+// global name.
+func parseGlobalNames(c *caddy.Controller) ([]string, error) {
+	args := c.RemainingArgs()
+	if len(args) == 0 {
+		return nil, c.ArgErr()
+	}
+
+	allowed := make([]string, len(args))
+
+	for i, arg := range args {
+		allowed[i] = strings.ToLower(arg)
+
+		parts := strings.Split(allowed[i], "/")
+		if len(parts) > 2 || parts[0] == "" || (len(parts) == 2 && parts[1] == "") {
+			return nil, c.Errf("global-names entries must be specified as <namespace> or <namespace>/<name>: %q", arg)
+		}
+	}
+
+	return allowed, nil
+}
+
+// parseHeadlessClusterOrder parses "local-first|latency|config [CLUSTER...]", returning the order and the ranking of
+// the clusters it applies.
+func parseHeadlessClusterOrder(c *caddy.Controller,
+	gwController *gateway.Controller) (string, func([]string, QueryContext) []string, error) {
+	args := c.RemainingArgs()
+	if len(args) == 0 {
+		return "", nil, c.ArgErr()
+	}
+
+	if args[0] != clusterOrderConfig && len(args) != 1 {
+		return "", nil, c.ArgErr()
+	}
+
+	switch args[0] {
+	case clusterOrderLocalFirst:
+		return args[0], localFirstClusters, nil
+	case clusterOrderLatency:
+		return args[0], latencyClusters(gwController.GetConnection), nil
+	case clusterOrderConfig:
+		if len(args) == 1 {
+			return "", nil, c.Errf("headless-cluster-order config requires the clusters in order")
+		}
+
+		return args[0], configuredClusters(args[1:]), nil
+	}
+
+	return "", nil, c.Errf("unknown headless-cluster-order %q", args[0])
+}
+
+// parseGlobalCIDR parses "CLUSTER CIDR GLOBAL-CIDR" and adds the mapping to the transformer.
+func parseGlobalCIDR(c *caddy.Controller, transformer *endpointslice.GlobalCIDRTransformer) error {
+	args := c.RemainingArgs()
+	if len(args) != 3 {
+		return c.ArgErr()
+	}
+
+	cidrs := make([]*net.IPNet, 0, 2)
+
+	for _, arg := range args[1:] {
+		_, cidr, err := net.ParseCIDR(arg)
+		if err != nil {
+			return c.Errf("global-cidr must be given valid CIDRs: %q", arg)
+		}
+
+		cidrs = append(cidrs, cidr)
+	}
+
+	if err := transformer.AddMapping(args[0], cidrs[0], cidrs[1]); err != nil {
+		return c.Errf("invalid global-cidr: %v", err)
+	}
+
+	return nil
+}
+
+func parseMaxEndpointSlices(c *caddy.Controller) (endpointslice.Limits, error) {
+	args := c.RemainingArgs()
+	if len(args) < 1 || len(args) > 2 {
+		return endpointslice.Limits{}, c.ArgErr()
+	}
+
+	limits := make([]int, 2)
+
+	for i, arg := range args {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 0 {
+			return endpointslice.Limits{}, c.Errf("max-endpointslices limits must be non-negative integers: %q", arg)
+		}
+
+		limits[i] = n
+	}
+
+	return endpointslice.Limits{PerService: limits[0], PerCluster: limits[1]}, nil
+}
+
+func parseStripFields(c *caddy.Controller) ([]endpointslice.Transform, error) {
+	args := c.RemainingArgs()
+	if len(args) > 1 {
+		return nil, c.ArgErr()
+	}
+
+	transforms := []endpointslice.Transform{endpointslice.StripManagedFields}
+
+	if len(args) == 1 {
+		maxSize, err := strconv.Atoi(args[0])
+		if err != nil || maxSize < 1 {
+			return nil, c.Errf("strip-fields annotation size must be a positive integer: %q", args[0])
+		}
+
+		transforms = append(transforms, endpointslice.StripLargeAnnotations(maxSize))
+	}
+
+	return transforms, nil
+}
+
+// parsePositiveInt parses the single positive integer argument of the current directive.
+func parsePositiveInt(c *caddy.Controller) (int, error) {
+	directive := c.Val()
+
+	args := c.RemainingArgs()
+	if len(args) != 1 {
+		return 0, c.ArgErr()
+	}
+
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 {
+		return 0, c.Errf("%s must be a positive integer: %q", directive, args[0])
+	}
+
+	return n, nil
+}
+
+func parsePreSyncAnswer(c *caddy.Controller) (int, error) {
+	args := c.RemainingArgs()
+	if len(args) != 1 {
+		return 0, c.ArgErr()
+	}
+
+	rcode, ok := preSyncRcodes[strings.ToLower(args[0])]
+	if !ok {
+		return 0, c.Errf("unknown presync-answer %q", args[0])
+	}
+
+	return rcode, nil
+}
+
+// parseNamespacePolicy parses "NAMESPACE [permissive] [no-prefer-local] [min-clusters N]", starting from the default
+// policy.
+func parseNamespacePolicy(c *caddy.Controller, policies map[string]namespacePolicy) error {
+	args := c.RemainingArgs()
+	if len(args) < 1 {
+		return c.ArgErr()
+	}
+
+	namespace := args[0]
+	if _, exists := policies[namespace]; exists {
+		return c.Errf("duplicate namespace-policy for %q", namespace)
+	}
+
+	policy := defaultNamespacePolicy
+
+	for i := 1; i < len(args); i++ {
+		switch args[i] {
+		case "permissive":
+			policy.readinessGated = false
+		case "no-prefer-local":
+			policy.preferLocal = false
+		case "min-clusters":
+			if i+1 == len(args) {
+				return c.ArgErr()
+			}
+
+			i++
+
+			n, err := strconv.Atoi(args[i])
+			if err != nil || n < 1 {
+				return c.Errf("namespace-policy min-clusters must be a positive integer: %q", args[i])
+			}
+
+			policy.minClusters = n
+		default:
+			return c.Errf("unknown namespace-policy option %q", args[i])
+		}
+	}
+
+	policies[namespace] = policy
+
+	return nil
+}
+
+func parseUnavailableAnswer(c *caddy.Controller) (int, error) {
+	args := c.RemainingArgs()
+	if len(args) != 1 {
+		return 0, c.ArgErr()
+	}
+
+	rcode, ok := unavailableRcodes[strings.ToLower(args[0])]
+	if !ok {
+		return 0, c.Errf("unknown unavailable-answer %q", args[0])
+	}
+
+	return rcode, nil
+}
+
+func parseQueryTimeout(c *caddy.Controller) (time.Duration, string, error) {
+	args := c.RemainingArgs()
+	if len(args) < 1 || len(args) > 2 {
+		return 0, "", c.ArgErr()
+	}
+
+	timeout, err := time.ParseDuration(args[0])
+	if err != nil || timeout <= 0 {
+		return 0, "", c.Errf("query-timeout must be a positive duration: %q", args[0])
+	}
+
+	action := timeoutFallthrough
+	if len(args) == 2 {
+		action = strings.ToLower(args[1])
+	}
+
+	if action != timeoutFallthrough && action != timeoutServfail {
+		return 0, "", c.Errf("unknown query-timeout action %q", args[1])
+	}
+
+	return timeout, action, nil
+}
+
 func parseTtl(c *caddy.Controller) (uint32, error) {
 	// Refer: https://github.com/coredns/coredns/blob/master/plugin/kubernetes/setup.go
 	args := c.RemainingArgs()
@@ -152,7 +1129,11 @@ func parseTtl(c *caddy.Controller) (uint32, error) {
 		return 0, c.ArgErr()
 	}
 
-	t, err := strconv.Atoi(args[0])
+	return parseTtlValue(c, args[0])
+}
+
+func parseTtlValue(c *caddy.Controller, arg string) (uint32, error) {
+	t, err := strconv.Atoi(arg)
 	if err != nil {
 		return 0, err
 	}
@@ -168,4 +1149,15 @@ func init() {
 	flag.StringVar(&kubeconfig, "kubeconfig", "", "Path to a kubeconfig. Only required if out-of-cluster.")
 	flag.StringVar(&masterURL, "master", "",
 		"The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
+	flag.BoolVar(&rbacFailFast, "rbac-fail-fast", false,
+		"Fail to start if any of the permissions Lighthouse needs is missing, rather than only logging them.")
+}
+
+func concatPermissions(permissions ...[]rbac.Permission) []rbac.Permission {
+	all := []rbac.Permission{}
+	for _, p := range permissions {
+		all = append(all, p...)
+	}
+
+	return all
 }
@@ -18,6 +18,8 @@ package lighthouse
 import (
 	"context"
 	"errors"
+	"sync/atomic"
+	"time"
 
 	"k8s.io/client-go/kubernetes"
 
@@ -27,16 +29,26 @@ import (
 	"github.com/miekg/dns"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	lhconstants "github.com/submariner-io/lighthouse/pkg/constants"
 	"github.com/submariner-io/lighthouse/pkg/endpointslice"
+	"github.com/submariner-io/lighthouse/pkg/exclusion"
 	"github.com/submariner-io/lighthouse/pkg/gateway"
 	mcsClientset "github.com/submariner-io/lighthouse/pkg/mcs/client/clientset/versioned"
 	fakeMCSClientset "github.com/submariner-io/lighthouse/pkg/mcs/client/clientset/versioned/fake"
+	"github.com/submariner-io/lighthouse/pkg/rbac"
 	"github.com/submariner-io/lighthouse/pkg/serviceimport"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	discovery "k8s.io/api/discovery/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/dynamic"
 	fakeClient "k8s.io/client-go/dynamic/fake"
 	fakeKubeClient "k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/testing"
+	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
 )
 
 type fakeHandler struct {
@@ -50,8 +62,13 @@ func (f *fakeHandler) Name() string {
 	return "fake"
 }
 
+// The permissions denied by the fake client set checking them.
+var deniedPermissions map[rbac.Permission]bool
+
 var _ = Describe("Plugin setup", func() {
 	BeforeEach(func() {
+		deniedPermissions = map[rbac.Permission]bool{}
+
 		gateway.NewClientset = func(c *rest.Config) (dynamic.Interface, error) {
 			return fakeClient.NewSimpleDynamicClient(runtime.NewScheme()), nil
 		}
@@ -63,10 +80,19 @@ var _ = Describe("Plugin setup", func() {
 		endpointslice.NewClientset = func(kubeConfig *rest.Config) (kubernetes.Interface, error) {
 			return fakeKubeClient.NewSimpleClientset(), nil
 		}
+
+		exclusion.NewClientset = func(kubeConfig *rest.Config) (kubernetes.Interface, error) {
+			return fakeKubeClient.NewSimpleClientset(), nil
+		}
+
+		rbac.NewClientset = func(kubeConfig *rest.Config) (kubernetes.Interface, error) {
+			return newFakeRBACClient(deniedPermissions), nil
+		}
 	})
 
 	AfterEach(func() {
 		gateway.NewClientset = nil
+		rbacFailFast = false
 	})
 
 	Context("Parsing correct configurations", testCorrectConfig)
@@ -99,6 +125,21 @@ func testCorrectConfig() {
 			Expect(lh.Fall).To(Equal(fall.F{}))
 			Expect(lh.Zones).To(BeEmpty())
 		})
+
+		It("should answer queries with SERVFAIL until the initial sync completes", func() {
+			Expect(lh.preSyncRcode).To(Equal(dns.RcodeServerFailure))
+			Eventually(lh.hasSynced, 5).Should(BeTrue())
+		})
+	})
+
+	When("a required permission is missing", func() {
+		BeforeEach(func() {
+			deniedPermissions[requiredPermissions[0]] = true
+		})
+
+		It("should succeed", func() {
+			Expect(lh).ToNot(BeNil())
+		})
 	})
 
 	When("lighthouse zone and fallthrough zone arguments are specified", func() {
@@ -114,6 +155,19 @@ func testCorrectConfig() {
 		})
 	})
 
+	When("alias zones are specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse clusterset.local {
+			    alias-zone supercluster.local
+            }`
+		})
+
+		It("should succeed with the zones and aliasZones fields populated correctly", func() {
+			Expect(lh.Zones).To(Equal([]string{"clusterset.local.", "supercluster.local."}))
+			Expect(lh.aliasZones).To(Equal(map[string]bool{"supercluster.local.": true}))
+		})
+	})
+
 	When("fallthrough argument with no zones is specified", func() {
 		BeforeEach(func() {
 			config = `lighthouse {
@@ -139,113 +193,1626 @@ func testCorrectConfig() {
 		})
 	})
 
-	It("Should handle missing optional fields", func() {
-		config := `lighthouse`
-		c := caddy.NewTestController("dns", config)
-		lh, err := lighthouseParse(c)
-		Expect(err).NotTo(HaveOccurred())
-		setupErr := setupLighthouse(c) // For coverage
-		Expect(setupErr).NotTo(HaveOccurred())
-		Expect(lh.Fall).Should(Equal(fall.F{}))
-		Expect(lh.Zones).Should(BeEmpty())
-		Expect(lh.ttl).Should(Equal(defaultTtl))
-	})
-}
+	When("force-connected arguments are specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    force-connected west south
+            }`
 
-func testIncorrectConfig() {
-	var (
-		setupErr error
-		config   string
-	)
+			gateway.NewClientset = func(c *rest.Config) (dynamic.Interface, error) {
+				client := fakeClient.NewSimpleDynamicClient(runtime.NewScheme())
+				gvr, _ := schema.ParseResourceArg("gateways.v1.submariner.io")
+				_, err := client.Resource(*gvr).Create(newConnectedGateway("east"), metav1.CreateOptions{})
 
-	JustBeforeEach(func() {
-		setupErr = setupLighthouse(caddy.NewTestController("dns", config))
+				return client, err
+			}
+		})
+
+		It("should report the clusters as connected", func() {
+			Expect(lh.clusterStatus.IsConnected("west")).Should(BeTrue())
+			Expect(lh.clusterStatus.IsConnected("south")).Should(BeTrue())
+			Expect(lh.clusterStatus.IsConnected("north")).Should(BeFalse())
+		})
 	})
 
-	When("an unexpected argument is specified", func() {
+	When("connected-status arguments are specified", func() {
 		BeforeEach(func() {
 			config = `lighthouse {
-                dummy
-		    } noplugin`
+			    connected-status established up
+            }`
 
-			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
-				return &rest.Config{}, nil
+			gateway.NewClientset = func(c *rest.Config) (dynamic.Interface, error) {
+				gw := newGateway("east")
+				Expect(unstructured.SetNestedSlice(gw.Object, []interface{}{
+					map[string]interface{}{"status": "established", "endpoint": map[string]interface{}{"cluster_id": "west"}},
+					map[string]interface{}{"status": "connected", "endpoint": map[string]interface{}{"cluster_id": "south"}},
+				}, "status", "connections")).To(Succeed())
+
+				client := fakeClient.NewSimpleDynamicClient(runtime.NewScheme())
+				gvr, _ := schema.ParseResourceArg("gateways.v1.submariner.io")
+				_, err := client.Resource(*gvr).Create(gw, metav1.CreateOptions{})
+
+				return client, err
 			}
 		})
 
-		It("should return an appropriate plugin error", func() {
-			verifyPluginError(setupErr, "dummy")
+		It("should report the clusters with the configured statuses as connected", func() {
+			Eventually(func() bool {
+				return lh.clusterStatus.IsConnected("west")
+			}).Should(BeTrue())
+			Expect(lh.clusterStatus.IsConnected("south")).Should(BeFalse())
+			Expect(lh.clusterStatus.IsConnected("east")).Should(BeTrue())
 		})
 	})
 
-	When("an empty ttl is specified", func() {
+	When("alias arguments are specified", func() {
 		BeforeEach(func() {
 			config = `lighthouse {
-                ttl
-		    } noplugin`
+			    alias ns1/db ns1/mysql
+			    alias-cname
+            }`
+		})
 
-			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
-				return &rest.Config{}, nil
-			}
+		It("should succeed with the aliases and aliasCNAME fields populated correctly", func() {
+			Expect(lh.aliases).Should(Equal(map[string]string{"ns1/db": "ns1/mysql"}))
+			Expect(lh.aliasCNAME).Should(BeTrue())
 		})
+	})
 
-		It("should return an appropriate plugin error", func() {
-			verifyPluginError(setupErr, "Wrong argument count")
+	When("clusterset-ip-cname is specified with a TTL", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    ttl 30
+			    clusterset-ip-cname 300
+            }`
+		})
+
+		It("should succeed with the clustersetIPCNAME fields populated correctly", func() {
+			Expect(lh.clustersetIPCNAME).Should(BeTrue())
+			Expect(lh.clustersetIPCNAMETTL).Should(Equal(uint32(300)))
 		})
 	})
 
-	When("an invalid ttl is specified", func() {
+	When("clusterset-ip-cname is specified without a TTL", func() {
 		BeforeEach(func() {
 			config = `lighthouse {
-                ttl -10
-		    } noplugin`
+			    clusterset-ip-cname
+			    ttl 30
+            }`
+		})
 
-			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
-				return &rest.Config{}, nil
-			}
+		It("should use the configured ttl for the CNAME", func() {
+			Expect(lh.clustersetIPCNAME).Should(BeTrue())
+			Expect(lh.clustersetIPCNAMETTL).Should(Equal(uint32(30)))
 		})
+	})
 
-		It("should return an appropriate plugin error", func() {
-			verifyPluginError(setupErr, "ttl must be in range [0, 3600]: -10")
+	When("internal-traffic-policy is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    internal-traffic-policy
+            }`
+		})
+
+		It("should succeed with the internalTrafficPolicy field populated correctly", func() {
+			Expect(lh.internalTrafficPolicy).Should(BeTrue())
 		})
 	})
 
-	When("building the kubeconfig fails", func() {
+	When("services are specified in mixed case", func() {
 		BeforeEach(func() {
-			config = "lighthouse"
+			config = `lighthouse {
+			    alias NS1/DB ns1/MySQL
+			    active-variant Ns1/Web Blue
+			    fallback Ns1/Web 10.0.0.1
+            }`
+		})
 
-			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
-				return nil, errors.New("mock")
-			}
+		It("should succeed with the services lower-cased", func() {
+			Expect(lh.aliases).Should(Equal(map[string]string{"ns1/db": "ns1/mysql"}))
+			Expect(lh.activeVariants).Should(Equal(map[string]string{"ns1/web": "blue"}))
+			Expect(lh.serviceFallbacks).Should(Equal(map[string]string{"ns1/web": "10.0.0.1"}))
 		})
+	})
 
-		It("should return an appropriate plugin error", func() {
-			verifyPluginError(setupErr, "mock")
+	When("circuit-breaker arguments are specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    circuit-breaker 3 30s
+            }`
+		})
+
+		It("should succeed with the breaker field populated", func() {
+			Expect(lh.breaker).ToNot(BeNil())
 		})
 	})
-}
 
-func testPluginRegistration() {
-	When("plugin setup succeeds", func() {
-		It("should properly register the Lighthouse plugin with the DNS server", func() {
-			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
-				return &rest.Config{}, nil
-			}
+	When("exclude-cidr arguments are specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    exclude-cidr 10.253.0.0/16 fd00:10::/64
+			    exclude-cidr 192.168.1.0/24
+            }`
+		})
 
-			controller := caddy.NewTestController("dns", "lighthouse")
-			err := setupLighthouse(controller)
-			Expect(err).To(Succeed())
+		It("should succeed with the addresses in the CIDRs excluded", func() {
+			lh.endpointSlices.Put(newEndpointSlice("ns1", "svc1", "west", []string{"10.253.1.1", "192.168.1.1", "10.1.1.1"}))
 
-			plugins := dnsserver.GetConfig(controller).Plugin
-			Expect(plugins).To(HaveLen(1))
+			ips, found := lh.endpointSlices.GetIPs("", "west", "ns1", "svc1", func(string) bool { return true })
+			Expect(found).To(BeTrue())
+			Expect(ips).To(Equal([]string{"10.1.1.1"}))
+		})
+	})
 
-			fakeHandler := &fakeHandler{}
-			handler := plugins[0](fakeHandler)
-			lh, ok := handler.(*Lighthouse)
-			Expect(ok).To(BeTrue(), "Unexpected Handler type %T", handler)
-			Expect(lh.Next).To(BeIdenticalTo(fakeHandler))
+	When("search-domain is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse clusterset.local {
+			    search-domain svc.clusterset.local
+            }`
+		})
+
+		It("should succeed with the searchDomain field populated correctly", func() {
+			Expect(lh.searchDomain).To(Equal("svc.clusterset.local."))
+		})
+	})
+
+	When("endpoint-max-age is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    endpoint-max-age 10m exclude
+            }`
+		})
+
+		It("should succeed with the excludeStale field set", func() {
+			Expect(lh.excludeStale).To(BeTrue())
+		})
+	})
+
+	When("debug-txt is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    debug-txt
+            }`
+		})
+
+		It("should succeed with the debugTXT field set", func() {
+			Expect(lh.debugTXT).To(BeTrue())
+		})
+	})
+
+	When("clusters-txt is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    clusters-txt
+            }`
+		})
+
+		It("should succeed with the clustersTXT field set", func() {
+			Expect(lh.clustersTXT).To(BeTrue())
+		})
+	})
+
+	When("tracing is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    tracing
+            }`
+		})
+
+		It("should succeed with the tracer set", func() {
+			Expect(lh.tracer).ToNot(BeNil())
+		})
+	})
+
+	When("fallback arguments are specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    fallback 192.0.2.10
+			    fallback ns1/svc1 unavailable.example.com
+            }`
+		})
+
+		It("should succeed with the fallback fields populated correctly", func() {
+			Expect(lh.fallback).To(Equal("192.0.2.10"))
+			Expect(lh.serviceFallbacks).To(Equal(map[string]string{"ns1/svc1": "unavailable.example.com"}))
+		})
+	})
+
+	When("max-clusters is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    max-clusters 2
+            }`
+		})
+
+		It("should succeed with the maxClusters field set", func() {
+			Expect(lh.maxClusters).To(Equal(2))
+		})
+	})
+
+	When("max-answers is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    max-answers 20
+            }`
+		})
+
+		It("should succeed with the maxAnswers field set", func() {
+			Expect(lh.maxAnswers).To(Equal(20))
+		})
+	})
+
+	When("sticky is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    sticky
+            }`
+		})
+
+		It("should succeed with the sticky field set", func() {
+			Expect(lh.sticky).To(BeTrue())
+		})
+	})
+
+	When("excluded-clusters is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    excluded-clusters submariner-operator/excluded-clusters
+            }`
+		})
+
+		It("should succeed with the excludedClusters field set", func() {
+			Expect(lh.excludedClusters).ToNot(BeNil())
+			Eventually(lh.hasSynced, 5).Should(BeTrue())
+		})
+
+		Context("and its ConfigMap can't be listed", func() {
+			BeforeEach(func() {
+				exclusion.NewClientset = func(kubeConfig *rest.Config) (kubernetes.Interface, error) {
+					client := fakeKubeClient.NewSimpleClientset()
+					client.PrependReactor("list", "configmaps", func(action testing.Action) (bool, runtime.Object, error) {
+						return true, nil, errors.New("fake list error")
+					})
+
+					return client, nil
+				}
+			})
+
+			It("should not complete the initial sync", func() {
+				Consistently(lh.hasSynced, 0.5).Should(BeFalse())
+			})
+		})
+	})
+
+	When("connectivity-events is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    connectivity-events submariner-operator/lighthouse-connectivity
+            }`
+		})
+
+		It("should succeed", func() {
+			Expect(lh).ToNot(BeNil())
+		})
+	})
+
+	When("no-compression is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    no-compression
+            }`
+		})
+
+		It("should succeed with the noCompression field set", func() {
+			Expect(lh.noCompression).To(BeTrue())
+		})
+	})
+
+	When("dedup-endpoints is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    dedup-endpoints
+            }`
+		})
+
+		It("should succeed with the dedupEndpoints field set", func() {
+			Expect(lh.dedupEndpoints).To(BeTrue())
+		})
+	})
+
+	When("uid-queries is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    uid-queries
+            }`
+		})
+
+		It("should succeed with the uidQueries field set", func() {
+			Expect(lh.uidQueries).To(BeTrue())
+		})
+	})
+
+	When("global-names is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    global-names infra monitoring/Prometheus
+            }`
 		})
+
+		It("should succeed with the globalNames field set and only allow the listed services to claim a global name",
+			func() {
+				Expect(lh.globalNames).To(BeTrue())
+
+				for _, claim := range []struct {
+					namespace, name string
+					allowed         bool
+				}{{"infra", "registry", true}, {"monitoring", "prometheus", true}, {"monitoring", "grafana", false}} {
+					si := newServiceImport(claim.namespace, claim.name, clusterID, serviceIP, mcsv1a1.ClusterSetIP)
+					si.Annotations[lhconstants.AnnotationGlobalName] = claim.name
+					lh.serviceImports.Put(si)
+
+					_, _, found := lh.serviceImports.GetServiceForGlobalName(claim.name)
+					Expect(found).To(Equal(claim.allowed), "global name of %s/%s", claim.namespace, claim.name)
+				}
+			})
 	})
+
+	When("answer-order is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    answer-order local-first
+            }`
+
+			gateway.NewClientset = func(c *rest.Config) (dynamic.Interface, error) {
+				client := fakeClient.NewSimpleDynamicClient(runtime.NewScheme())
+				gvr, _ := schema.ParseResourceArg("gateways.v1.submariner.io")
+				_, err := client.Resource(*gvr).Create(newConnectedGateway("east"), metav1.CreateOptions{})
+
+				return client, err
+			}
+		})
+
+		It("should succeed with the answerOrderer field set", func() {
+			Expect(lh.answerOrderer).ToNot(BeNil())
+		})
+	})
+
+	When("headless-cluster-order is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    headless-cluster-order config west east
+            }`
+		})
+
+		It("should succeed with the headlessClusterRank field set", func() {
+			Expect(lh.headlessClusterRank).ToNot(BeNil())
+
+			ordered := lh.answerOrder(true).Order([]Endpoint{{IP: "10.0.0.1", Cluster: "east"},
+				{IP: "10.0.1.1", Cluster: "west"}}, QueryContext{})
+			Expect(ordered).To(Equal([]Endpoint{{IP: "10.0.1.1", Cluster: "west"}, {IP: "10.0.0.1", Cluster: "east"}}))
+		})
+	})
+
+	When("standby is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    standby 127.0.0.1:8190
+            }`
+		})
+
+		It("should succeed with the standby fields set", func() {
+			Expect(lh.isStandby()).To(BeTrue())
+			Expect(lh.promoteAddress).To(Equal("127.0.0.1:8190"))
+		})
+	})
+
+	When("standby is specified without an address", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    standby
+            }`
+		})
+
+		It("should succeed with the default promotion address", func() {
+			Expect(lh.isStandby()).To(BeTrue())
+			Expect(lh.promoteAddress).To(Equal(defaultAdminAddress))
+		})
+	})
+
+	When("standby is specified after an instance of the process was promoted", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    standby
+            }`
+
+			atomic.StoreUint32(&promoted, 1)
+		})
+
+		AfterEach(func() {
+			atomic.StoreUint32(&promoted, 0)
+		})
+
+		It("should stay promoted", func() {
+			Expect(lh.isStandby()).To(BeFalse())
+			Expect(lh.adminEndpoints()).To(BeEmpty())
+		})
+	})
+
+	When("presync-answer is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    presync-answer Refused
+            }`
+		})
+
+		It("should succeed with the preSyncRcode field set", func() {
+			Expect(lh.preSyncRcode).To(Equal(dns.RcodeRefused))
+		})
+	})
+
+	When("namespace-policy is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    namespace-policy tenant-a permissive no-prefer-local min-clusters 2
+			    namespace-policy *
+            }`
+
+			gateway.NewClientset = func(c *rest.Config) (dynamic.Interface, error) {
+				client := fakeClient.NewSimpleDynamicClient(runtime.NewScheme())
+				gvr, _ := schema.ParseResourceArg("gateways.v1.submariner.io")
+				_, err := client.Resource(*gvr).Create(newConnectedGateway("east"), metav1.CreateOptions{})
+
+				return client, err
+			}
+		})
+
+		It("should succeed with the namespacePolicies field populated correctly", func() {
+			Expect(lh.namespacePolicies).To(Equal(map[string]namespacePolicy{
+				"tenant-a": {readinessGated: false, minClusters: 2, preferLocal: false},
+				"*":        defaultNamespacePolicy,
+			}))
+		})
+	})
+
+	When("unavailable-answer is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    unavailable-answer servfail
+            }`
+		})
+
+		It("should succeed with the unavailableRcode field set", func() {
+			Expect(lh.unavailableRcode).To(Equal(dns.RcodeServerFailure))
+		})
+	})
+
+	When("debug-gateways is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    debug-gateways 127.0.0.1:8191
+            }`
+		})
+
+		It("should succeed with the debug-gateways fields set", func() {
+			Expect(lh.debugGatewaysAddress).To(Equal("127.0.0.1:8191"))
+			Expect(lh.debugGatewaysHandler).ToNot(BeNil())
+		})
+	})
+
+	When("debug-gateways is specified without an address", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    debug-gateways
+            }`
+		})
+
+		It("should succeed with the default debug-gateways address", func() {
+			Expect(lh.debugGatewaysAddress).To(Equal(defaultAdminAddress))
+		})
+	})
+
+	When("query-timeout is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    query-timeout 500ms servfail
+            }`
+		})
+
+		It("should succeed with the query timeout fields set", func() {
+			Expect(lh.queryTimeout).To(Equal(500 * time.Millisecond))
+			Expect(lh.queryTimeoutAction).To(Equal(timeoutServfail))
+		})
+	})
+
+	When("query-timeout is specified without an action", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    query-timeout 1s
+            }`
+		})
+
+		It("should succeed with the fallthrough action", func() {
+			Expect(lh.queryTimeout).To(Equal(time.Second))
+			Expect(lh.queryTimeoutAction).To(Equal(timeoutFallthrough))
+		})
+	})
+
+	When("max-endpointslices is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    max-endpointslices 100 1000
+            }`
+		})
+
+		It("should succeed with the EndpointSlice limits set", func() {
+			Expect(lh.endpointsStatus.(*endpointslice.Controller).Limits).To(Equal(endpointslice.Limits{
+				PerService: 100,
+				PerCluster: 1000,
+			}))
+		})
+	})
+
+	When("strip-fields is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    strip-fields 1024
+            }`
+		})
+
+		It("should succeed with the EndpointSlice transforms set", func() {
+			Expect(lh.endpointsStatus.(*endpointslice.Controller).Transforms).To(HaveLen(2))
+		})
+	})
+
+	When("global-cidr is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    global-cidr west 10.1.0.0/16 242.1.0.0/16
+			    global-cidr west fd00:1::/64 fd00:242::/64
+            }`
+		})
+
+		It("should succeed with the global CIDR endpoint transformer set", func() {
+			transformer := lh.endpointsStatus.(*endpointslice.Controller).EndpointTransformer
+			Expect(transformer).To(BeAssignableToTypeOf(&endpointslice.GlobalCIDRTransformer{}))
+			Expect(transformer.TransformEndpoint(discovery.Endpoint{Addresses: []string{"10.1.2.3", "fd00:1::5"}},
+				"west").Addresses).To(Equal([]string{"242.1.2.3", "fd00:242::5"}))
+		})
+	})
+
+	When("debug-snapshot is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    debug-snapshot 127.0.0.1:8194
+            }`
+		})
+
+		It("should succeed with the debugSnapshotAddress field set", func() {
+			Expect(lh.debugSnapshotAddress).To(Equal("127.0.0.1:8194"))
+		})
+	})
+
+	When("debug-snapshot is specified without an address", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    debug-snapshot
+            }`
+		})
+
+		It("should succeed with the default debug-snapshot address", func() {
+			Expect(lh.debugSnapshotAddress).To(Equal(defaultAdminAddress))
+		})
+	})
+
+	When("freeze is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    freeze 127.0.0.1:8195
+            }`
+		})
+
+		It("should succeed with the freezeAddress field set", func() {
+			Expect(lh.freezeAddress).To(Equal("127.0.0.1:8195"))
+		})
+	})
+
+	When("freeze is specified without an address", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    freeze
+            }`
+		})
+
+		It("should succeed with the default freeze address", func() {
+			Expect(lh.freezeAddress).To(Equal(defaultAdminAddress))
+		})
+	})
+
+	When("active-variant arguments are specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    active-variant ns1/svc1 blue
+			    active-variant ns2/svc1 green
+            }`
+		})
+
+		It("should succeed with the activeVariants field populated correctly", func() {
+			Expect(lh.activeVariants).Should(Equal(map[string]string{"ns1/svc1": "blue", "ns2/svc1": "green"}))
+		})
+	})
+
+	When("a namespace-policy prefers the local cluster and the local cluster ID is known", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    namespace-policy tenant-a
+            }`
+
+			gateway.NewClientset = func(c *rest.Config) (dynamic.Interface, error) {
+				client := fakeClient.NewSimpleDynamicClient(runtime.NewScheme())
+				gvr, _ := schema.ParseResourceArg("gateways.v1.submariner.io")
+				_, err := client.Resource(*gvr).Create(newConnectedGateway("east"), metav1.CreateOptions{})
+
+				return client, err
+			}
+		})
+
+		It("should succeed with the local cluster ID populated", func() {
+			Expect(lh.localClusterFeatures()).Should(Equal([]string{"namespace-policy tenant-a"}))
+			Expect(lh.clusterStatus.LocalClusterID()).Should(Equal("east"))
+		})
+	})
+
+	When("local-only is specified and the local cluster ID is known", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    local-only
+            }`
+
+			gateway.NewClientset = func(c *rest.Config) (dynamic.Interface, error) {
+				client := fakeClient.NewSimpleDynamicClient(runtime.NewScheme())
+				gvr, _ := schema.ParseResourceArg("gateways.v1.submariner.io")
+				_, err := client.Resource(*gvr).Create(newConnectedGateway("east"), metav1.CreateOptions{})
+
+				return client, err
+			}
+		})
+
+		It("should succeed with the localOnly field populated correctly", func() {
+			Expect(lh.localOnly).Should(BeTrue())
+		})
+	})
+
+	When("region-affinity is specified with the cluster regions and the local cluster ID is known", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    cluster-region east us-east
+			    cluster-region west us-west
+			    region-affinity
+            }`
+
+			gateway.NewClientset = func(c *rest.Config) (dynamic.Interface, error) {
+				client := fakeClient.NewSimpleDynamicClient(runtime.NewScheme())
+				gvr, _ := schema.ParseResourceArg("gateways.v1.submariner.io")
+				_, err := client.Resource(*gvr).Create(newConnectedGateway("east"), metav1.CreateOptions{})
+
+				return client, err
+			}
+		})
+
+		It("should succeed with the regionAffinity and clusterRegions fields populated correctly", func() {
+			Expect(lh.regionAffinity).Should(BeTrue())
+			Expect(lh.clusterRegions).Should(Equal(map[string]string{"east": "us-east", "west": "us-west"}))
+		})
+	})
+
+	When("local-zone is specified without zones and the local cluster ID is known", func() {
+		BeforeEach(func() {
+			config = `lighthouse clusterset.local {
+			    local-zone
+            }`
+
+			gateway.NewClientset = func(c *rest.Config) (dynamic.Interface, error) {
+				client := fakeClient.NewSimpleDynamicClient(runtime.NewScheme())
+				gvr, _ := schema.ParseResourceArg("gateways.v1.submariner.io")
+				_, err := client.Resource(*gvr).Create(newConnectedGateway("east"), metav1.CreateOptions{})
+
+				return client, err
+			}
+		})
+
+		It("should succeed with the zones and localZones fields populated with cluster.local", func() {
+			Expect(lh.Zones).To(Equal([]string{"clusterset.local.", "cluster.local."}))
+			Expect(lh.localZones).To(Equal(map[string]bool{"cluster.local.": true}))
+		})
+	})
+
+	When("local-zone is specified with a zone and the local cluster ID is known", func() {
+		BeforeEach(func() {
+			config = `lighthouse clusterset.local {
+			    local-zone east.local
+            }`
+
+			gateway.NewClientset = func(c *rest.Config) (dynamic.Interface, error) {
+				client := fakeClient.NewSimpleDynamicClient(runtime.NewScheme())
+				gvr, _ := schema.ParseResourceArg("gateways.v1.submariner.io")
+				_, err := client.Resource(*gvr).Create(newConnectedGateway("east"), metav1.CreateOptions{})
+
+				return client, err
+			}
+		})
+
+		It("should succeed with the zones and localZones fields populated correctly", func() {
+			Expect(lh.Zones).To(Equal([]string{"clusterset.local.", "east.local."}))
+			Expect(lh.localZones).To(Equal(map[string]bool{"east.local.": true}))
+		})
+	})
+
+	It("Should handle missing optional fields", func() {
+		config := `lighthouse`
+		c := caddy.NewTestController("dns", config)
+		lh, err := lighthouseParse(c)
+		Expect(err).NotTo(HaveOccurred())
+		setupErr := setupLighthouse(c) // For coverage
+		Expect(setupErr).NotTo(HaveOccurred())
+		Expect(lh.Fall).Should(Equal(fall.F{}))
+		Expect(lh.Zones).Should(BeEmpty())
+		Expect(lh.ttl).Should(Equal(defaultTtl))
+	})
+}
+
+func testIncorrectConfig() {
+	var (
+		setupErr error
+		config   string
+	)
+
+	JustBeforeEach(func() {
+		setupErr = setupLighthouse(caddy.NewTestController("dns", config))
+	})
+
+	When("an unexpected argument is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                dummy
+		    } noplugin`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "dummy")
+		})
+	})
+
+	When("a required permission is missing and rbac-fail-fast is set", func() {
+		BeforeEach(func() {
+			config = "lighthouse"
+			rbacFailFast = true
+			deniedPermissions[requiredPermissions[1]] = true
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return a plugin error listing it", func() {
+			verifyPluginError(setupErr, "Missing 1 of the")
+			verifyPluginError(setupErr, requiredPermissions[1].String())
+		})
+	})
+
+	When("a connectivity Events permission is missing and rbac-fail-fast is set", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    connectivity-events submariner-operator/lighthouse-connectivity
+            }`
+			rbacFailFast = true
+			deniedPermissions[gateway.EventPermissions("submariner-operator")[0]] = true
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return a plugin error listing it", func() {
+			verifyPluginError(setupErr, `create events in namespace "submariner-operator"`)
+		})
+	})
+
+	When("an excluded clusters permission is missing and rbac-fail-fast is set", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    excluded-clusters submariner-operator/excluded-clusters
+            }`
+			rbacFailFast = true
+			deniedPermissions[rbac.Permission{Verb: "watch", Resource: "configmaps",
+				Namespace: "submariner-operator"}] = true
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return a plugin error listing it", func() {
+			verifyPluginError(setupErr, `watch configmaps in namespace "submariner-operator"`)
+		})
+	})
+
+	When("an empty ttl is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                ttl
+		    } noplugin`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "Wrong argument count")
+		})
+	})
+
+	When("an invalid ttl is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                ttl -10
+		    } noplugin`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "ttl must be in range [0, 3600]: -10")
+		})
+	})
+
+	When("an invalid clusterset-ip-cname TTL is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                clusterset-ip-cname 7200
+		    } noplugin`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "ttl must be in range [0, 3600]: 7200")
+		})
+	})
+
+	When("an excluded-clusters ConfigMap without a namespace is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                excluded-clusters excluded-clusters
+		    }`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "excluded-clusters ConfigMap must be specified as <namespace>/<name>")
+		})
+	})
+
+	When("a connectivity-events ConfigMap without a namespace is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                connectivity-events lighthouse-connectivity
+		    }`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "connectivity-events ConfigMap must be specified as <namespace>/<name>")
+		})
+	})
+
+	When("an alias chain is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                alias ns1/a ns1/b
+                alias ns1/b ns1/a
+		    }`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "is itself an alias")
+		})
+	})
+
+	When("an invalid circuit-breaker cooldown is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                circuit-breaker 3 soon
+		    }`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "circuit-breaker cooldown must be a positive duration")
+		})
+	})
+
+	When("no connected-status arguments are specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                connected-status
+		    }`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "Wrong argument count")
+		})
+	})
+
+	When("a connected-status that never means connected is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                connected-status established error
+		    }`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "never means the cluster is connected")
+		})
+	})
+
+	When("a latency-rtt-bounds with a single argument is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                latency-rtt-bounds 10s
+		    }`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "Wrong argument count")
+		})
+	})
+
+	When("a negative latency-rtt-bounds minimum is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                latency-rtt-bounds -1ms 10s
+		    }`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "minimum must be a non-negative duration")
+		})
+	})
+
+	When("a latency-rtt-bounds maximum not above the minimum is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                latency-rtt-bounds 10ms 10ms
+		    }`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "maximum must be a duration above the minimum")
+		})
+	})
+
+	When("an invalid endpoint-max-age option is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                endpoint-max-age 10m drop
+		    }`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "unknown endpoint-max-age option \"drop\"")
+		})
+	})
+
+	When("a search-domain outside the zones is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                search-domain svc.cluster.local
+		    }`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "the search domain \"svc.cluster.local.\" must be")
+		})
+	})
+
+	When("an invalid fallback is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                fallback ns1/svc1 2001:db8::1
+		    }`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "fallback must be an IPv4 address or a hostname")
+		})
+	})
+
+	When("an invalid max-clusters is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                max-clusters 0
+		    }`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "max-clusters must be a positive integer")
+		})
+	})
+
+	When("an invalid max-answers is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                max-answers 0
+		    }`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "max-answers must be a positive integer: \"0\"")
+		})
+	})
+
+	When("an invalid exclude-cidr is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                exclude-cidr 10.253.0.0
+		    }`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "exclude-cidr must be a valid CIDR")
+		})
+	})
+
+	When("an unknown answer-order is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                answer-order latency
+		    }`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "unknown answer-order \"latency\"")
+		})
+	})
+
+	When("an unknown headless-cluster-order is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                headless-cluster-order region
+		    }`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "unknown headless-cluster-order \"region\"")
+		})
+	})
+
+	When("headless-cluster-order config is specified without clusters", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                headless-cluster-order config
+		    }`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "headless-cluster-order config requires the clusters in order")
+		})
+	})
+
+	When("an unknown presync-answer is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                presync-answer nxdomain
+		    }`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "unknown presync-answer \"nxdomain\"")
+		})
+	})
+
+	When("an unknown namespace-policy option is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                namespace-policy tenant-a strict
+		    }`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "unknown namespace-policy option \"strict\"")
+		})
+	})
+
+	When("an invalid namespace-policy min-clusters is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                namespace-policy tenant-a min-clusters 0
+		    }`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "namespace-policy min-clusters must be a positive integer: \"0\"")
+		})
+	})
+
+	When("a namespace-policy is duplicated", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                namespace-policy tenant-a permissive
+                namespace-policy tenant-a no-prefer-local
+		    }`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "duplicate namespace-policy for \"tenant-a\"")
+		})
+	})
+
+	When("an unknown unavailable-answer is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                unavailable-answer nxdomain
+		    }`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "unknown unavailable-answer \"nxdomain\"")
+		})
+	})
+
+	When("an invalid standby promotion address is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                standby 8190
+		    }`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "invalid standby promotion address \"8190\"")
+		})
+	})
+
+	When("an invalid debug-gateways address is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                debug-gateways 8191
+		    }`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "invalid debug-gateways address \"8191\"")
+		})
+	})
+
+	When("an invalid query-timeout is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                query-timeout 0s
+		    }`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "query-timeout must be a positive duration: \"0s\"")
+		})
+	})
+
+	When("an invalid max-endpointslices limit is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                max-endpointslices 100 -1
+		    }`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "max-endpointslices limits must be non-negative integers: \"-1\"")
+		})
+	})
+
+	When("an invalid strip-fields annotation size is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                strip-fields 0
+		    }`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "strip-fields annotation size must be a positive integer: \"0\"")
+		})
+	})
+
+	When("global-names is specified without any namespace", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                global-names
+		    }`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "Wrong argument count")
+		})
+	})
+
+	When("an invalid global-names entry is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                global-names infra/registry/v2
+		    }`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "global-names entries must be specified as <namespace> or <namespace>/<name>")
+		})
+	})
+
+	When("global-cidr CIDRs of different sizes are specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                global-cidr west 10.1.0.0/16 242.1.0.0/24
+		    }`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "invalid global-cidr")
+		})
+	})
+
+	When("an unknown query-timeout action is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                query-timeout 1s refused
+		    }`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "unknown query-timeout action \"refused\"")
+		})
+	})
+
+	When("an invalid freeze address is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                freeze 8195
+		    }`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "invalid freeze address \"8195\"")
+		})
+	})
+
+	When("an invalid debug-snapshot address is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                debug-snapshot 8194
+		    }`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "invalid debug-snapshot address \"8194\"")
+		})
+	})
+
+	When("an invalid active-variant service is specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                active-variant svc1 blue
+		    }`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "active-variant service must be specified as <namespace>/<name>")
+		})
+	})
+
+	When("region-affinity is specified without any cluster regions", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                region-affinity
+		    }`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr,
+				"region-affinity requires the regions of the clusters to be configured with cluster-region")
+		})
+	})
+
+	When("a namespace-policy prefers the local cluster and the local cluster ID is not configured", func() {
+		var oldTimeout time.Duration
+
+		BeforeEach(func() {
+			config = `lighthouse {
+                namespace-policy tenant-a min-clusters 2
+                namespace-policy tenant-b no-prefer-local
+		    }`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+
+			oldTimeout = localClusterIDTimeout
+			localClusterIDTimeout = 200 * time.Millisecond
+		})
+
+		AfterEach(func() {
+			localClusterIDTimeout = oldTimeout
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "the local cluster ID is required by [namespace-policy tenant-a] but is not configured")
+		})
+	})
+
+	When("local-first orders are specified and the local cluster ID is not configured", func() {
+		var oldTimeout time.Duration
+
+		BeforeEach(func() {
+			config = `lighthouse {
+                answer-order local-first
+                headless-cluster-order local-first
+		    }`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+
+			oldTimeout = localClusterIDTimeout
+			localClusterIDTimeout = 200 * time.Millisecond
+		})
+
+		AfterEach(func() {
+			localClusterIDTimeout = oldTimeout
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "the local cluster ID is required by [answer-order local-first "+
+				"headless-cluster-order local-first] but is not configured")
+		})
+	})
+
+	When("local-zone is specified and the local cluster ID is not configured", func() {
+		var oldTimeout time.Duration
+
+		BeforeEach(func() {
+			config = `lighthouse {
+                local-zone
+		    }`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+
+			oldTimeout = localClusterIDTimeout
+			localClusterIDTimeout = 200 * time.Millisecond
+		})
+
+		AfterEach(func() {
+			localClusterIDTimeout = oldTimeout
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "the local cluster ID is required by [local-zone] but is not configured")
+		})
+	})
+
+	When("building the kubeconfig fails", func() {
+		BeforeEach(func() {
+			config = "lighthouse"
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return nil, errors.New("mock")
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "mock")
+		})
+	})
+}
+
+func testPluginRegistration() {
+	When("plugin setup succeeds", func() {
+		It("should properly register the Lighthouse plugin with the DNS server", func() {
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+
+			controller := caddy.NewTestController("dns", "lighthouse")
+			err := setupLighthouse(controller)
+			Expect(err).To(Succeed())
+
+			plugins := dnsserver.GetConfig(controller).Plugin
+			Expect(plugins).To(HaveLen(1))
+
+			fakeHandler := &fakeHandler{}
+			handler := plugins[0](fakeHandler)
+			lh, ok := handler.(*Lighthouse)
+			Expect(ok).To(BeTrue(), "Unexpected Handler type %T", handler)
+			Expect(lh.Next).To(BeIdenticalTo(fakeHandler))
+		})
+	})
+}
+
+func newGateway(localClusterID string) *unstructured.Unstructured {
+	gw := &unstructured.Unstructured{}
+	gw.SetName("test-gateway")
+	Expect(unstructured.SetNestedField(gw.Object, localClusterID, "status", "localEndpoint", "cluster_id")).To(Succeed())
+	Expect(unstructured.SetNestedField(gw.Object, "active", "status", "haStatus")).To(Succeed())
+
+	return gw
+}
+
+// newConnectedGateway returns an active Gateway with a complete status, ie with its connections, which the Gateway
+// controller only applies once they're written.
+func newConnectedGateway(localClusterID string) *unstructured.Unstructured {
+	gw := newGateway(localClusterID)
+	Expect(unstructured.SetNestedSlice(gw.Object, []interface{}{}, "status", "connections")).To(Succeed())
+
+	return gw
+}
+
+func newFakeRBACClient(denied map[rbac.Permission]bool) kubernetes.Interface {
+	client := fakeKubeClient.NewSimpleClientset()
+	client.PrependReactor("create", "selfsubjectaccessreviews", func(action testing.Action) (bool, runtime.Object, error) {
+		review := action.(testing.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		attributes := review.Spec.ResourceAttributes
+		review.Status.Allowed = !denied[rbac.Permission{Verb: attributes.Verb, Group: attributes.Group,
+			Resource: attributes.Resource, Subresource: attributes.Subresource, Namespace: attributes.Namespace}]
+
+		return true, review, nil
+	})
+
+	return client
 }
 
 func verifyPluginError(err error, str string) {
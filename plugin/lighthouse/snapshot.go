@@ -0,0 +1,242 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lighthouse
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/submariner-io/lighthouse/pkg/circuitbreaker"
+	"github.com/submariner-io/lighthouse/pkg/serviceimport"
+	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
+)
+
+// The path on which the answer snapshot is served.
+const debugSnapshotPath = "/debug/snapshot"
+
+// The condition of a service whose ready endpoints are all in disconnected clusters.
+const ConditionReachableClustersDisconnected = "ReachableClustersDisconnected"
+
+// The condition of a service with a malformed EndpointSlice, whose cluster is answered with the last valid version of
+// it, if any.
+const ConditionEndpointSlicesSkipped = "EndpointSlicesSkipped"
+
+// Snapshot is what the plugin answers for each exported service at a point in time, eg to analyze offline what was
+// served during an incident.
+type Snapshot struct {
+	Time           time.Time         `json:"time"`
+	Zones          []string          `json:"zones"`
+	LocalClusterID string            `json:"localClusterID"`
+	Services       []ServiceSnapshot `json:"services"`
+}
+
+// ServiceSnapshot is what the plugin answers for an exported service.
+type ServiceSnapshot struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// The names the service is answered for, one per zone.
+	DNSNames []string `json:"dnsNames"`
+	// The types of the records answered for the names.
+	RecordTypes  []string          `json:"recordTypes"`
+	Type         string            `json:"type"`
+	ClustersetIP string            `json:"clustersetIP,omitempty"`
+	Ports        []PortSnapshot    `json:"ports"`
+	Clusters     []ClusterSnapshot `json:"clusters"`
+	// The IPv6 clusterset VIP answered for AAAA queries, if any.
+	ClustersetIPv6 string `json:"clustersetIPv6,omitempty"`
+	// True if the service has ready endpoints in at least one connected cluster.
+	ClusterSetReady bool `json:"clusterSetReady"`
+	// The warning conditions of the service, eg ReachableClustersDisconnected.
+	Conditions []string `json:"conditions,omitempty"`
+}
+
+// PortSnapshot is a port of an exported service, merged from the exports of all clusters.
+type PortSnapshot struct {
+	Name        string `json:"name,omitempty"`
+	Protocol    string `json:"protocol"`
+	Port        int32  `json:"port"`
+	AppProtocol string `json:"appProtocol,omitempty"`
+}
+
+// ClusterSnapshot is the state of a cluster exporting a service.
+type ClusterSnapshot struct {
+	ClusterID string `json:"clusterID"`
+	Variant   string `json:"variant,omitempty"`
+	// False if the cluster's export conflicts with the type of the service, in which case it isn't answered.
+	Merged    bool `json:"merged"`
+	Connected bool `json:"connected"`
+	Healthy   bool `json:"healthy"`
+	Stale     bool `json:"stale,omitempty"`
+	Excluded  bool `json:"excluded,omitempty"`
+	// True if the cluster's export is within its export delay, in which case it isn't answered yet.
+	Pending bool `json:"pending,omitempty"`
+	// True if the cluster's latest EndpointSlice was malformed, in which case its last valid version is answered.
+	Skipped bool `json:"skipped,omitempty"`
+	// The state of the cluster's circuit breaker, if circuit-breaker is configured.
+	CircuitBreaker string `json:"circuitBreaker,omitempty"`
+	// The cluster's IP for the service, unless it's headless.
+	IP string `json:"ip,omitempty"`
+	// The addresses of the service's endpoints in the cluster.
+	Endpoints []string `json:"endpoints"`
+	// True if the cluster can be returned for a query that lets Lighthouse choose the cluster.
+	Eligible bool `json:"eligible"`
+}
+
+// Snapshot returns what the plugin answers for each exported service. Unlike a query, it doesn't affect the
+// round-robin, the circuit breakers or the metrics.
+func (lh *Lighthouse) Snapshot() *Snapshot {
+	snapshot := &Snapshot{
+		Time:           time.Now().UTC(),
+		Zones:          []string{},
+		LocalClusterID: lh.clusterStatus.LocalClusterID(),
+		Services:       []ServiceSnapshot{},
+	}
+
+	for _, zone := range lh.Zones {
+		if !lh.localZones[zone] {
+			snapshot.Zones = append(snapshot.Zones, zone)
+		}
+	}
+
+	recordTypes := []string{"A"}
+	if lh.clustersTXT || lh.debugTXT {
+		recordTypes = append(recordTypes, "TXT")
+	}
+
+	for _, summary := range lh.serviceImports.List() {
+		service := ServiceSnapshot{
+			Namespace:    summary.Namespace,
+			Name:         summary.Name,
+			DNSNames:     []string{},
+			RecordTypes:  recordTypes,
+			Type:         string(summary.Type),
+			ClustersetIP: summary.ClustersetIP,
+			Ports:        []PortSnapshot{},
+			Clusters:     []ClusterSnapshot{},
+		}
+
+		service.ClusterSetReady = lh.ClusterSetReady(summary.Namespace, summary.Name)
+
+		if lh.ReachableClustersDisconnected(summary.Namespace, summary.Name) {
+			service.Conditions = append(service.Conditions, ConditionReachableClustersDisconnected)
+		}
+
+		if len(lh.endpointSlices.GetSkippedClusters(summary.Namespace, summary.Name)) > 0 {
+			service.Conditions = append(service.Conditions, ConditionEndpointSlicesSkipped)
+		}
+
+		if summary.ClustersetIPv6 != "" && summary.Type != mcsv1a1.Headless {
+			service.ClustersetIPv6 = summary.ClustersetIPv6
+			service.RecordTypes = append(append([]string{}, recordTypes...), "AAAA")
+		}
+
+		for i := range summary.Ports {
+			port := PortSnapshot{
+				Name:     summary.Ports[i].Name,
+				Protocol: string(summary.Ports[i].Protocol),
+				Port:     summary.Ports[i].Port,
+			}
+
+			if summary.Ports[i].AppProtocol != nil {
+				port.AppProtocol = *summary.Ports[i].AppProtocol
+			}
+
+			service.Ports = append(service.Ports, port)
+		}
+
+		pReq := recordRequest{service: summary.Name, namespace: summary.Namespace}
+
+		for _, zone := range snapshot.Zones {
+			service.DNSNames = append(service.DNSNames, canonicalName(pReq, zone))
+
+			if lh.globalNames && summary.GlobalName != "" {
+				service.DNSNames = append(service.DNSNames, summary.GlobalName+"."+globalLabel+"."+zone)
+			}
+		}
+
+		for _, clusterID := range lh.serviceImports.GetClusters(summary.Namespace, summary.Name) {
+			service.Clusters = append(service.Clusters, lh.clusterSnapshot(&summary, clusterID))
+		}
+
+		snapshot.Services = append(snapshot.Services, service)
+	}
+
+	return snapshot
+}
+
+func (lh *Lighthouse) clusterSnapshot(summary *serviceimport.ServiceSummary, clusterID string) ClusterSnapshot {
+	cluster := ClusterSnapshot{
+		ClusterID: clusterID,
+		Variant:   lh.serviceImports.GetClusterVariant(summary.Namespace, summary.Name, clusterID),
+		Merged:    lh.serviceImports.IsMerged(summary.Namespace, summary.Name, clusterID),
+		Connected: lh.clusterStatus.IsConnected(clusterID),
+		Healthy:   lh.endpointsStatus.IsHealthy(summary.Name, summary.Namespace, clusterID),
+		Stale:     lh.excludeStale && lh.endpointSlices.IsStale(summary.Namespace, summary.Name, clusterID),
+		Pending:   lh.serviceImports.IsPending(summary.Namespace, summary.Name, clusterID),
+		Excluded:  lh.excludedClusters != nil && lh.excludedClusters.IsExcluded(clusterID),
+		Endpoints: []string{},
+	}
+
+	if summary.Type != mcsv1a1.Headless {
+		cluster.IP = summary.ClusterIPs[clusterID]
+	}
+
+	for _, skipped := range lh.endpointSlices.GetSkippedClusters(summary.Namespace, summary.Name) {
+		cluster.Skipped = cluster.Skipped || skipped == clusterID
+	}
+
+	if ips, found := lh.endpointSlices.GetIPs("", clusterID, summary.Namespace, summary.Name, nil); found {
+		cluster.Endpoints = append(cluster.Endpoints, ips...)
+	}
+
+	breakerOpen := false
+
+	if lh.breaker != nil {
+		state := lh.breaker.GetState(summary.Namespace+"/"+summary.Name, clusterID)
+		cluster.CircuitBreaker = state.String()
+		breakerOpen = state == circuitbreaker.Open
+	}
+
+	cluster.Eligible = cluster.Merged && cluster.Connected && cluster.Healthy && !cluster.Stale && !cluster.Pending &&
+		!cluster.Excluded && !breakerOpen
+
+	return cluster
+}
+
+// snapshotHandler serves a GET of debugSnapshotPath with the JSON of the answer snapshot, and of debugAffinityPath with
+// the JSON of the answer pinned to a client.
+func (lh *Lighthouse) snapshotHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(debugSnapshotPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Only GET is supported", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := json.MarshalIndent(lh.Snapshot(), "", "  ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(append(body, '\n'))
+	})
+	mux.HandleFunc(debugAffinityPath, lh.affinityHandler)
+
+	return mux
+}
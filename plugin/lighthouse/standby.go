@@ -0,0 +1,69 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lighthouse
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// The path on which a standby instance is promoted.
+const promotePath = "/promote"
+
+// Set once an instance has been promoted, so that the instances created by later reloads of the same process start
+// promoted rather than going back to standby.
+var promoted uint32
+
+func promotedInProcess() bool {
+	return atomic.LoadUint32(&promoted) != 0
+}
+
+func (lh *Lighthouse) isStandby() bool {
+	return atomic.LoadUint32(&lh.standby) != 0
+}
+
+// promote switches a standby instance to answering queries. As the informers keep running in standby mode, the answers
+// are up to date as soon as it's promoted. It returns false if the instance wasn't in standby.
+func (lh *Lighthouse) promote() bool {
+	if !atomic.CompareAndSwapUint32(&lh.standby, 1, 0) {
+		return false
+	}
+
+	atomic.StoreUint32(&promoted, 1)
+	log.Infof("Promoted from standby - now answering queries")
+
+	return true
+}
+
+// promoteHandler serves the promotion endpoint: a POST to promotePath promotes the instance, which is a no-op if it's
+// already been promoted.
+func (lh *Lighthouse) promoteHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(promotePath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only POST is supported", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if lh.promote() {
+			_, _ = w.Write([]byte("Promoted\n"))
+		} else {
+			_, _ = w.Write([]byte("Already promoted\n"))
+		}
+	})
+
+	return mux
+}
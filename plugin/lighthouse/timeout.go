@@ -0,0 +1,155 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lighthouse
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/miekg/dns"
+)
+
+// The actions on a query that isn't answered within the query timeout, by query-timeout argument.
+const (
+	timeoutFallthrough = "fallthrough"
+	timeoutServfail    = "servfail"
+)
+
+var errQueryTimedOut = errors.New("the query timed out")
+
+// timeoutWriter guards the ResponseWriter of a query answered in the background so that only one of the background
+// answer and the timeout action responds.
+type timeoutWriter struct {
+	dns.ResponseWriter
+	mutex    sync.Mutex
+	claimed  bool
+	timedOut bool
+}
+
+// claim reserves the writer for the background answer. It returns false if the query already timed out.
+func (w *timeoutWriter) claim() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.timedOut {
+		return false
+	}
+
+	w.claimed = true
+
+	return true
+}
+
+// expire reserves the writer for the timeout action. It returns false if the background answer already claimed it.
+func (w *timeoutWriter) expire() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.claimed {
+		return false
+	}
+
+	w.timedOut = true
+
+	return true
+}
+
+func (w *timeoutWriter) WriteMsg(m *dns.Msg) error {
+	if !w.claim() {
+		return errQueryTimedOut
+	}
+
+	return w.ResponseWriter.WriteMsg(m)
+}
+
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	if !w.claim() {
+		return 0, errQueryTimedOut
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+// serveWithTimeout answers the query in the background and, if it isn't answered nor passed to the next plugin within
+// the query timeout, either passes it to the next plugin or fails it with SERVFAIL, as configured. The time taken by the
+// next plugin doesn't count towards the timeout. The background answer is given a copy of the query, as it may still
+// be running while the next plugin handles the query, and its context is cancelled once the query is handled.
+func (lh *Lighthouse) serveWithTimeout(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	type result struct {
+		rcode int
+		err   error
+	}
+
+	tw := &timeoutWriter{ResponseWriter: w}
+	done := make(chan result, 1)
+
+	backgroundCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	query := r.Copy()
+
+	go func() {
+		rcode, err := lh.serveTraced(backgroundCtx, tw, query)
+		done <- result{rcode: rcode, err: err}
+	}()
+
+	timer := time.NewTimer(lh.queryTimeout)
+	defer timer.Stop()
+
+	select {
+	case res := <-done:
+		return res.rcode, res.err
+	case <-timer.C:
+	}
+
+	if !tw.expire() {
+		res := <-done
+		return res.rcode, res.err
+	}
+
+	queryTimeouts.WithLabelValues(lh.queryTimeoutAction).Inc()
+
+	if lh.queryTimeoutAction == timeoutServfail {
+		log.Warningf("The query for %q wasn't answered within %v - failing it", r.Question[0].Name, lh.queryTimeout)
+		return dns.RcodeServerFailure, nil
+	}
+
+	log.Warningf("The query for %q wasn't answered within %v - passing it to the next plugin", r.Question[0].Name,
+		lh.queryTimeout)
+
+	return plugin.NextOrFailure(lh.Name(), lh.Next, ctx, w, r)
+}
+
+// next passes the query to the next plugin. If the query is answered in the background, it's only passed if it didn't
+// time out. A dry run is never passed.
+func (lh *Lighthouse) next(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	if _, ok := w.(*dryRunWriter); ok {
+		return dns.RcodeServerFailure, errDryRunNext
+	}
+
+	if tw, ok := w.(*timeoutWriter); ok {
+		if !tw.claim() {
+			return dns.RcodeServerFailure, errQueryTimedOut
+		}
+
+		w = tw.ResponseWriter
+	}
+
+	return plugin.NextOrFailure(lh.Name(), lh.Next, ctx, w, r)
+}
@@ -0,0 +1,165 @@
+/*
+© 2021 Red Hat, Inc. and others
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lighthouse
+
+import (
+	"context"
+	"os"
+	"sort"
+
+	"github.com/miekg/dns"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/trace/jaeger"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	envJaegerEndpoint   = "OTEL_EXPORTER_JAEGER_ENDPOINT"
+	tracerName          = "github.com/submariner-io/lighthouse/plugin/lighthouse"
+	clusterDisconnected = "disconnected"
+	clusterUnhealthy    = "unhealthy"
+	clusterStale        = "stale"
+	clusterPending      = "pending"
+	clusterExcluded     = "excluded"
+)
+
+// newTracerProvider creates a provider exporting spans to Jaeger, configured by the standard OpenTelemetry environment
+// variables: to the collector at OTEL_EXPORTER_JAEGER_ENDPOINT if it's set, otherwise to the agent at
+// OTEL_EXPORTER_JAEGER_AGENT_HOST and OTEL_EXPORTER_JAEGER_AGENT_PORT. The resource attributes can be overridden by
+// OTEL_RESOURCE_ATTRIBUTES.
+func newTracerProvider() (*sdktrace.TracerProvider, error) {
+	endpoint := jaeger.WithAgentEndpoint()
+	if os.Getenv(envJaegerEndpoint) != "" {
+		endpoint = jaeger.WithCollectorEndpoint()
+	}
+
+	exporter, err := jaeger.NewRawExporter(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	detected, err := resource.New(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.AlwaysSample())),
+		sdktrace.WithResource(resource.Merge(resource.NewWithAttributes(semconv.ServiceNameKey.String("lighthouse")),
+			detected)),
+	), nil
+}
+
+// queryTrace records the resolution of a query in a span. All its methods are no-ops on a nil queryTrace, which is
+// used when tracing is disabled, so the query path doesn't need to check.
+type queryTrace struct {
+	span     trace.Span
+	clusters map[string]bool
+	// If non-nil, maps each rejected cluster to the reason, for the diagnostic of a debug query.
+	filtered map[string]string
+	// Set for a query resolved only to report its answer, which mustn't change the breakers nor the metrics.
+	dryRun bool
+	// The endpoint returned first in the answer, if any.
+	first *Endpoint
+}
+
+// startTrace starts the span of a query as a child of the span context in ctx, if any. It returns nil if tracing is
+// disabled.
+func (lh *Lighthouse) startTrace(ctx context.Context, r *dns.Msg) *queryTrace {
+	if lh.tracer == nil {
+		return nil
+	}
+
+	attrs := []attribute.KeyValue{}
+	if len(r.Question) > 0 {
+		attrs = append(attrs, attribute.String("dns.qname", r.Question[0].Name),
+			attribute.String("dns.qtype", dns.TypeToString[r.Question[0].Qtype]))
+	}
+
+	_, span := lh.tracer.Start(ctx, "lighthouse.ServeDNS", trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attrs...))
+
+	return &queryTrace{span: span, clusters: map[string]bool{}}
+}
+
+// checkCluster wraps a cluster check so a cluster it rejects is recorded with the given reason.
+func (t *queryTrace) checkCluster(reason string, check func(string) bool) func(string) bool {
+	if t == nil {
+		return check
+	}
+
+	return func(clusterID string) bool {
+		if check(clusterID) {
+			return true
+		}
+
+		if t.filtered != nil {
+			t.filtered[clusterID] = reason
+		}
+
+		t.span.AddEvent("cluster filtered", trace.WithAttributes(attribute.String("lighthouse.cluster_id", clusterID),
+			attribute.String("lighthouse.reason", reason)))
+
+		return false
+	}
+}
+
+// clusterSelected records a cluster whose IPs are in the answer.
+func (t *queryTrace) clusterSelected(clusterID string) {
+	if t != nil && clusterID != "" {
+		t.clusters[clusterID] = true
+	}
+}
+
+// firstSelected records the endpoint returned first in the answer.
+func (t *queryTrace) firstSelected(endpoint Endpoint) {
+	if t != nil {
+		t.first = &endpoint
+	}
+}
+
+func (t *queryTrace) isDryRun() bool {
+	return t != nil && t.dryRun
+}
+
+// end records the answering clusters and the outcome of the query, and ends the span.
+func (t *queryTrace) end(rcode int, err error) {
+	if t == nil {
+		return
+	}
+
+	clusters := make([]string, 0, len(t.clusters))
+	for clusterID := range t.clusters {
+		clusters = append(clusters, clusterID)
+	}
+
+	sort.Strings(clusters)
+
+	t.span.SetAttributes(attribute.Array("lighthouse.clusters", clusters),
+		attribute.String("dns.rcode", dns.RcodeToString[rcode]))
+
+	if err != nil {
+		t.span.RecordError(err)
+		t.span.SetStatus(codes.Error, err.Error())
+	}
+
+	t.span.End()
+}